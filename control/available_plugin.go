@@ -108,7 +108,15 @@ func newAvailablePlugin(resp plugin.Response, emitter gomit.Emitter, ep executab
 			}
 			ap.client = c
 		case plugin.NativeRPC:
-			c, e := client.NewCollectorNativeClient(resp.ListenAddress, DefaultClientTimeout, resp.PublicKey, !resp.Meta.Unsecure)
+			var (
+				c client.PluginCollectorClient
+				e error
+			)
+			if resp.Codec == plugin.JSONCodec {
+				c, e = client.NewCollectorNativeJSONClient(resp.ListenAddress, DefaultClientTimeout, resp.PublicKey, !resp.Meta.Unsecure, nil)
+			} else {
+				c, e = client.NewCollectorNativeClient(resp.ListenAddress, DefaultClientTimeout, resp.PublicKey, !resp.Meta.Unsecure)
+			}
 			if e != nil {
 				return nil, errors.New("error while creating client connection: " + e.Error())
 			}
@@ -125,7 +133,15 @@ func newAvailablePlugin(resp plugin.Response, emitter gomit.Emitter, ep executab
 	case plugin.PublisherPluginType:
 		switch resp.Meta.RPCType {
 		case plugin.NativeRPC:
-			c, e := client.NewPublisherNativeClient(resp.ListenAddress, DefaultClientTimeout, resp.PublicKey, !resp.Meta.Unsecure)
+			var (
+				c client.PluginPublisherClient
+				e error
+			)
+			if resp.Codec == plugin.JSONCodec {
+				c, e = client.NewPublisherNativeJSONClient(resp.ListenAddress, DefaultClientTimeout, resp.PublicKey, !resp.Meta.Unsecure, nil)
+			} else {
+				c, e = client.NewPublisherNativeClient(resp.ListenAddress, DefaultClientTimeout, resp.PublicKey, !resp.Meta.Unsecure)
+			}
 			if e != nil {
 				return nil, errors.New("error while creating client connection: " + e.Error())
 			}
@@ -142,7 +158,15 @@ func newAvailablePlugin(resp plugin.Response, emitter gomit.Emitter, ep executab
 	case plugin.ProcessorPluginType:
 		switch resp.Meta.RPCType {
 		case plugin.NativeRPC:
-			c, e := client.NewProcessorNativeClient(resp.ListenAddress, DefaultClientTimeout, resp.PublicKey, !resp.Meta.Unsecure)
+			var (
+				c client.PluginProcessorClient
+				e error
+			)
+			if resp.Codec == plugin.JSONCodec {
+				c, e = client.NewProcessorNativeJSONClient(resp.ListenAddress, DefaultClientTimeout, resp.PublicKey, !resp.Meta.Unsecure, nil)
+			} else {
+				c, e = client.NewProcessorNativeClient(resp.ListenAddress, DefaultClientTimeout, resp.PublicKey, !resp.Meta.Unsecure)
+			}
 			if e != nil {
 				return nil, errors.New("error while creating client connection: " + e.Error())
 			}
@@ -159,6 +183,22 @@ func newAvailablePlugin(resp plugin.Response, emitter gomit.Emitter, ep executab
 	default:
 		return nil, errors.New("Cannot create a client for a plugin of the type: " + resp.Type.String())
 	}
+	// Authenticate calls to the plugin with its session token
+	if ts, ok := ap.client.(client.TokenSetter); ok {
+		ts.SetToken(resp.Token)
+	}
+	// Do not call methods an older plugin does not serve
+	if vs, ok := ap.client.(client.RPCVersionSetter); ok {
+		vs.SetRPCVersion(resp.RPCVersion)
+	}
+	// Send metrics in the content type agreed with the plugin, snap.gob
+	// when it predates negotiation
+	if cs, ok := ap.client.(client.ContentTypeSetter); ok {
+		cs.SetContentType(resp.ContentType)
+	}
+	if es, ok := ap.client.(client.ContentEncodingSetter); ok {
+		es.SetContentEncoding(plugin.SelectContentEncoding(resp.ContentEncodings))
+	}
 
 	return ap, nil
 }
@@ -183,7 +223,16 @@ func (a *availablePlugin) RoutingStrategy() plugin.RoutingStrategyType {
 	return a.meta.RoutingStrategy
 }
 
+func (a *availablePlugin) RoutingKeys() []string {
+	return a.meta.RoutingKeys
+}
+
+// ConcurrencyCount returns the number of tasks an instance of the plugin
+// serves, 1 when the plugin does not limit its concurrent calls.
 func (a *availablePlugin) ConcurrencyCount() int {
+	if a.meta.ConcurrencyCount < 1 {
+		return 1
+	}
 	return a.meta.ConcurrencyCount
 }
 
@@ -499,7 +548,13 @@ func (ap *availablePlugins) processMetrics(metrics []core.Metric, pluginName str
 		return nil, []error{errors.New("unable to cast client to PluginProcessorClient")}
 	}
 
-	mts, errp := cli.Process(metrics, config)
+	var mts []core.Metric
+	var errp error
+	if pp, ok := cli.(client.PipelineProcessor); ok {
+		mts, errp = pp.ProcessPipeline(metrics, config, plugin.PipelineInfo{TaskID: taskID})
+	} else {
+		mts, errp = cli.Process(metrics, config)
+	}
 	if errp != nil {
 		return nil, []error{errp}
 	}
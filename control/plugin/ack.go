@@ -0,0 +1,232 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"sync"
+	"time"
+
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+// DefaultAckRetention is how long a deferred batch is tracked when
+// Arg.AckRetention is not set.
+var DefaultAckRetention = 5 * time.Minute
+
+// AckMode tells control, in a PublishReply, when the metrics published are
+// persisted.
+type AckMode int
+
+const (
+	// AckImmediate means the metrics were persisted when Publish returned
+	AckImmediate AckMode = iota
+	// AckDeferred means the metrics were queued by the plugin, whose
+	// outcome control polls with PublishStatus using the BatchID of the
+	// reply.
+	AckDeferred
+)
+
+var ackModes = [...]string{
+	"immediate",
+	"deferred",
+}
+
+func (m AckMode) String() string {
+	if m < 0 || int(m) >= len(ackModes) {
+		return "unknown"
+	}
+	return ackModes[m]
+}
+
+// BatchState is the outcome of a deferred batch
+type BatchState int
+
+const (
+	// BatchUnknown is the state of a batch which was never published, or
+	// was forgotten after the retention window
+	BatchUnknown BatchState = iota
+	// BatchPending means the plugin has not acknowledged the batch yet
+	BatchPending
+	// BatchSucceeded means the plugin persisted the batch
+	BatchSucceeded
+	// BatchFailed means the sink rejected the batch, or the plugin did not
+	// acknowledge it within the retention window
+	BatchFailed
+)
+
+var batchStates = [...]string{
+	"unknown",
+	"pending",
+	"succeeded",
+	"failed",
+}
+
+func (s BatchState) String() string {
+	if s < 0 || int(s) >= len(batchStates) {
+		return "unknown"
+	}
+	return batchStates[s]
+}
+
+// Acker resolves the batches of a DeferredPublisher.  It is implemented by
+// the session of a publisher.
+type Acker interface {
+	// Ack records the outcome of batchID, a failure when err is not nil.
+	// A batch is acknowledged once; later acks are ignored.
+	Ack(batchID string, err error)
+}
+
+// DeferredPublisher is implemented by publishers which queue the metrics
+// published and learn later whether their sink took them.  PublishDeferred
+// is called in place of Publish with the ID the session gave the batch, and
+// the plugin reports its outcome with the Acker given to SetAcker, called
+// once before the plugin is served.
+type DeferredPublisher interface {
+	PublishDeferred(batchID, contentType string, content []byte, config map[string]ctypes.ConfigValue) error
+	SetAcker(Acker)
+}
+
+// PublishStatusArgs are the arguments of PublishStatus
+type PublishStatusArgs struct {
+	Token    string
+	BatchIDs []string
+}
+
+// BatchStatus is the outcome of a deferred batch
+type BatchStatus struct {
+	State BatchState
+	// Error is the error of a failed batch
+	Error string `json:",omitempty"`
+}
+
+// PublishStatusReply is the reply of PublishStatus
+type PublishStatusReply struct {
+	Batches map[string]BatchStatus
+}
+
+type trackedBatch struct {
+	status BatchStatus
+	// since is when the batch was published, or resolved once it is no
+	// longer pending
+	since time.Time
+}
+
+// ackTracker follows the deferred batches of a publisher.  A batch pending
+// for longer than retention fails, and the outcome of a batch is forgotten
+// retention after it was resolved.  A nil ackTracker defers nothing.
+type ackTracker struct {
+	publisher DeferredPublisher
+	retention time.Duration
+	logger    func() Logger
+
+	mutex   sync.Mutex
+	batches map[string]*trackedBatch
+}
+
+func newAckTracker(p DeferredPublisher, retention time.Duration, logger func() Logger) *ackTracker {
+	return &ackTracker{publisher: p, retention: retention, logger: logger, batches: map[string]*trackedBatch{}}
+}
+
+// begin returns the ID of a new pending batch, or "" when the publisher
+// acknowledges immediately.
+func (t *ackTracker) begin() string {
+	if t == nil {
+		return ""
+	}
+	id := NewRequestID()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.expire(time.Now())
+	t.batches[id] = &trackedBatch{status: BatchStatus{State: BatchPending}, since: time.Now()}
+	return id
+}
+
+// drop forgets a batch whose PublishDeferred failed
+func (t *ackTracker) drop(id string) {
+	if t == nil || id == "" {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.batches, id)
+}
+
+func (t *ackTracker) ack(id string, err error) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := time.Now()
+	t.expire(now)
+	b, ok := t.batches[id]
+	if !ok || b.status.State != BatchPending {
+		t.logger().Warnf("Ignoring the ack of batch %q, which is not pending", id)
+		return
+	}
+	b.since = now
+	b.status = BatchStatus{State: BatchSucceeded}
+	if err != nil {
+		b.status = BatchStatus{State: BatchFailed, Error: err.Error()}
+	}
+}
+
+// status returns the outcome of each batch in ids
+func (t *ackTracker) status(ids []string) map[string]BatchStatus {
+	r := make(map[string]BatchStatus, len(ids))
+	if t == nil {
+		for _, id := range ids {
+			r[id] = BatchStatus{}
+		}
+		return r
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.expire(time.Now())
+	for _, id := range ids {
+		if b, ok := t.batches[id]; ok {
+			r[id] = b.status
+		} else {
+			r[id] = BatchStatus{}
+		}
+	}
+	return r
+}
+
+// expire fails the batches pending for longer than the retention, and
+// forgets those resolved before it.  The caller holds the mutex.
+func (t *ackTracker) expire(now time.Time) {
+	for id, b := range t.batches {
+		if now.Sub(b.since) < t.retention {
+			continue
+		}
+		if b.status.State == BatchPending {
+			b.status = BatchStatus{State: BatchFailed, Error: "not acknowledged within " + t.retention.String()}
+			b.since = now
+			continue
+		}
+		delete(t.batches, id)
+	}
+}
+
+// Ack records the outcome of a deferred batch, see Acker
+func (s *SessionState) Ack(batchID string, err error) {
+	s.acks.ack(batchID, err)
+}
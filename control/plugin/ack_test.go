@@ -0,0 +1,126 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// queueingSink queues each batch and leaves its outcome to the test
+type queueingSink struct {
+	MockPublisher
+	acker   Acker
+	batches []string
+}
+
+func (s *queueingSink) PublishDeferred(batchID, _ string, _ []byte, _ map[string]ctypes.ConfigValue) error {
+	s.batches = append(s.batches, batchID)
+	return nil
+}
+
+func (s *queueingSink) SetAcker(a Acker) {
+	s.acker = a
+}
+
+func TestAck(t *testing.T) {
+	m := NewPluginMeta("ack", 1, PublisherPluginType, []string{SnapGOBContentType}, nil, Unsecure(true))
+	start := func(p Plugin, args string) (*SessionState, *publisherPluginProxy) {
+		ss, err, _ := NewSessionState(args, p, m)
+		So(err, ShouldBeNil)
+		return ss, &publisherPluginProxy{Plugin: p.(PublisherPlugin), Session: ss, Meta: m, config: ss.config, acks: ss.acks}
+	}
+	publish := func(ss *SessionState, proxy *publisherPluginProxy) PublishReply {
+		in, err := ss.Encode(PublishArgs{ContentType: SnapGOBContentType, Token: ss.Token()})
+		So(err, ShouldBeNil)
+		var out []byte
+		So(proxy.Publish(in, &out), ShouldBeNil)
+		var reply PublishReply
+		So(ss.Decode(out, &reply), ShouldBeNil)
+		return reply
+	}
+	status := func(ss *SessionState, proxy *publisherPluginProxy, id string) BatchStatus {
+		in, err := ss.Encode(PublishStatusArgs{Token: ss.Token(), BatchIDs: []string{id}})
+		So(err, ShouldBeNil)
+		var out []byte
+		So(proxy.PublishStatus(in, &out), ShouldBeNil)
+		var reply PublishStatusReply
+		So(ss.Decode(out, &reply), ShouldBeNil)
+		So(reply.Batches, ShouldHaveLength, 1)
+		return reply.Batches[id]
+	}
+
+	Convey("A publisher persisting its metrics in Publish acks immediately", t, func() {
+		ss, proxy := start(&MockPublisher{}, "{}")
+		reply := publish(ss, proxy)
+		So(reply.AckMode, ShouldEqual, AckImmediate)
+		So(reply.BatchID, ShouldBeEmpty)
+		So(status(ss, proxy, "abc"), ShouldResemble, BatchStatus{State: BatchUnknown})
+	})
+	Convey("A publisher queueing its metrics", t, func() {
+		sink := &queueingSink{}
+		ss, proxy := start(sink, `{"LogLevel": "info"}`)
+		So(sink.acker, ShouldEqual, ss)
+		reply := publish(ss, proxy)
+		So(reply.AckMode, ShouldEqual, AckDeferred)
+		So(reply.BatchID, ShouldNotBeEmpty)
+		So(sink.batches, ShouldResemble, []string{reply.BatchID})
+		So(status(ss, proxy, reply.BatchID).State, ShouldEqual, BatchPending)
+
+		Convey("acks a batch its sink took", func() {
+			sink.acker.Ack(reply.BatchID, nil)
+			So(status(ss, proxy, reply.BatchID), ShouldResemble, BatchStatus{State: BatchSucceeded})
+		})
+		Convey("fails a batch its sink rejected", func() {
+			sink.acker.Ack(reply.BatchID, errors.New("rejected by the sink"))
+			So(status(ss, proxy, reply.BatchID), ShouldResemble, BatchStatus{State: BatchFailed, Error: "rejected by the sink"})
+			sink.acker.Ack(reply.BatchID, nil)
+			So(status(ss, proxy, reply.BatchID).State, ShouldEqual, BatchFailed)
+		})
+	})
+	Convey("A batch not acknowledged within the retention window", t, func() {
+		sink := &queueingSink{}
+		ss, proxy := start(sink, fmt.Sprintf(`{"LogLevel": "info", "AckRetention": %d}`, 30*time.Millisecond))
+		id := publish(ss, proxy).BatchID
+		time.Sleep(40 * time.Millisecond)
+		st := status(ss, proxy, id)
+		So(st.State, ShouldEqual, BatchFailed)
+		So(st.Error, ShouldContainSubstring, "not acknowledged")
+
+		Convey("ignores a late ack, and is forgotten after another window", func() {
+			sink.acker.Ack(id, nil)
+			So(status(ss, proxy, id).State, ShouldEqual, BatchFailed)
+			time.Sleep(40 * time.Millisecond)
+			So(status(ss, proxy, id).State, ShouldEqual, BatchUnknown)
+		})
+	})
+	Convey("The names of the ack modes and batch states", t, func() {
+		So(AckDeferred.String(), ShouldEqual, "deferred")
+		So(BatchSucceeded.String(), ShouldEqual, "succeeded")
+		So(BatchState(9).String(), ShouldEqual, "unknown")
+	})
+}
@@ -0,0 +1,126 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"sync"
+	"time"
+)
+
+// Backpressure is the advice of a publisher, in its Publish reply, that
+// control slow down the publishing to it.
+type Backpressure struct {
+	// QueueDepth is the number of metrics the plugin has yet to write
+	QueueDepth int
+	// Delay is how long control should wait before publishing again
+	Delay time.Duration
+	// Stop asks control to publish nothing more until the plugin clears
+	// it
+	Stop bool
+}
+
+// QueueReporter may be implemented by a publisher writing to its sink in
+// the background, to report its queue in the Publish replies.
+type QueueReporter interface {
+	QueueStatus() Backpressure
+}
+
+// Throttler lets a publisher signal backpressure to control.  It is
+// implemented by the session of a publisher.
+type Throttler interface {
+	// SlowDown asks control to wait delay between two publish calls
+	SlowDown(delay time.Duration)
+	// HardStop asks control to stop publishing
+	HardStop()
+	// ClearBackpressure withdraws the advice given by SlowDown and
+	// HardStop.
+	ClearBackpressure()
+}
+
+// ThrottledPublisher is implemented by publishers which signal backpressure
+// to control.  SetThrottler is called once, with the session of the plugin,
+// before the plugin is served.
+type ThrottledPublisher interface {
+	SetThrottler(Throttler)
+}
+
+// backpressure combines the advice signalled through the session with the
+// queue reported by the plugin.  A nil backpressure advises nothing.
+type backpressure struct {
+	queue QueueReporter
+
+	mutex     sync.Mutex
+	signalled Backpressure
+}
+
+func newBackpressure(plugin Plugin) *backpressure {
+	b := &backpressure{}
+	b.queue, _ = plugin.(QueueReporter)
+	return b
+}
+
+func (b *backpressure) set(delay time.Duration, stop bool) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.signalled.Delay = delay
+	b.signalled.Stop = stop
+}
+
+// report returns the advice for a Publish reply, or nil when control need
+// not slow down.
+func (b *backpressure) report() *Backpressure {
+	if b == nil {
+		return nil
+	}
+	b.mutex.Lock()
+	r := b.signalled
+	b.mutex.Unlock()
+	if b.queue != nil {
+		q := b.queue.QueueStatus()
+		r.QueueDepth = q.QueueDepth
+		if q.Delay > r.Delay {
+			r.Delay = q.Delay
+		}
+		r.Stop = r.Stop || q.Stop
+	}
+	if r == (Backpressure{}) {
+		return nil
+	}
+	return &r
+}
+
+// SlowDown asks control to wait delay between two publish calls, see
+// Throttler.
+func (s *SessionState) SlowDown(delay time.Duration) {
+	s.backpressure.set(delay, false)
+}
+
+// HardStop asks control to stop publishing, see Throttler
+func (s *SessionState) HardStop() {
+	s.backpressure.set(0, true)
+}
+
+// ClearBackpressure withdraws the advice given by SlowDown and HardStop
+func (s *SessionState) ClearBackpressure() {
+	s.backpressure.set(0, false)
+}
@@ -0,0 +1,122 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// slowSink queues the batches published while its sink is stalled, and
+// advises a delay growing with its queue
+type slowSink struct {
+	MockPublisher
+	throttler Throttler
+
+	mutex   sync.Mutex
+	stalled bool
+	queue   int
+}
+
+func (s *slowSink) Publish(_ string, content []byte, _ map[string]ctypes.ConfigValue) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stalled {
+		s.queue++
+	}
+	return nil
+}
+
+func (s *slowSink) QueueStatus() Backpressure {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return Backpressure{QueueDepth: s.queue, Delay: time.Duration(s.queue) * 10 * time.Millisecond}
+}
+
+func (s *slowSink) SetThrottler(t Throttler) {
+	s.throttler = t
+}
+
+// recover writes the queued batches once the sink catches up
+func (s *slowSink) recover() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stalled = false
+	s.queue = 0
+}
+
+func TestBackpressure(t *testing.T) {
+	Convey("A publisher writing to a slow sink", t, func() {
+		m := NewPluginMeta("backpressure", 1, PublisherPluginType, []string{SnapGOBContentType}, nil, Unsecure(true))
+		sink := &slowSink{stalled: true}
+		ss, err, _ := NewSessionState("{}", sink, m)
+		So(err, ShouldBeNil)
+		So(sink.throttler, ShouldEqual, ss)
+		proxy := &publisherPluginProxy{Plugin: sink, Session: ss, Meta: m, config: ss.config, backpressure: ss.backpressure}
+		publish := func() *Backpressure {
+			in, err := ss.Encode(PublishArgs{ContentType: SnapGOBContentType, Token: ss.Token()})
+			So(err, ShouldBeNil)
+			var out []byte
+			So(proxy.Publish(in, &out), ShouldBeNil)
+			var reply PublishReply
+			So(ss.Decode(out, &reply), ShouldBeNil)
+			return reply.Backpressure
+		}
+
+		Convey("advises a delay growing with its queue", func() {
+			var last time.Duration
+			for i := 1; i <= 3; i++ {
+				bp := publish()
+				So(bp, ShouldNotBeNil)
+				So(bp.QueueDepth, ShouldEqual, i)
+				So(bp.Delay, ShouldBeGreaterThan, last)
+				So(bp.Stop, ShouldBeFalse)
+				last = bp.Delay
+			}
+			Convey("which clears once the queue is written", func() {
+				sink.recover()
+				So(publish(), ShouldBeNil)
+			})
+		})
+		Convey("signals through its session", func() {
+			sink.throttler.SlowDown(time.Second)
+			bp := publish()
+			So(bp, ShouldNotBeNil)
+			So(bp.Delay, ShouldEqual, time.Second)
+			sink.throttler.HardStop()
+			So(publish().Stop, ShouldBeTrue)
+			sink.throttler.ClearBackpressure()
+			sink.recover()
+			So(publish(), ShouldBeNil)
+		})
+	})
+	Convey("A session which is not a publisher's advises nothing", t, func() {
+		ss, err, _ := NewSessionState("{}", &mockPlugin{}, NewPluginMeta("backpressure", 1, CollectorPluginType, nil, nil, Unsecure(true)))
+		So(err, ShouldBeNil)
+		ss.SlowDown(time.Second)
+		So(ss.backpressure.report(), ShouldBeNil)
+	})
+}
@@ -0,0 +1,195 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+// The config keys turning on the batching of the metrics published, see
+// batchConfig.  batch_size is a number of metrics and batch_timeout a number
+// of milliseconds.
+const (
+	BatchSizeKey    = "batch_size"
+	BatchTimeoutKey = "batch_timeout"
+)
+
+// DefaultBatchTimeout is the age at which a batch is flushed when its
+// config sets batch_size but not batch_timeout.
+var DefaultBatchTimeout = time.Second
+
+// batchConfig returns the size and age at which the batches of a publish
+// call with config are flushed, or false when the call is not batched.
+func batchConfig(config map[string]ctypes.ConfigValue) (size int, timeout time.Duration, ok bool) {
+	if v, ok := config[BatchSizeKey].(ctypes.ConfigValueInt); ok && v.Value > 0 {
+		size = v.Value
+	}
+	if v, ok := config[BatchTimeoutKey].(ctypes.ConfigValueInt); ok && v.Value > 0 {
+		timeout = time.Duration(v.Value) * time.Millisecond
+	}
+	if size == 0 && timeout == 0 {
+		return 0, 0, false
+	}
+	if timeout == 0 {
+		timeout = DefaultBatchTimeout
+	}
+	return size, timeout, true
+}
+
+// batch accumulates the metrics published with the same content type and
+// config, in the order of the calls.
+type batch struct {
+	key         string
+	contentType string
+	config      map[string]ctypes.ConfigValue
+	metrics     []MetricType
+	created     time.Time
+	timer       *time.Timer
+}
+
+// batcher holds the batches of a publisher until they are flushed to its
+// Publish, by size, by age, or when the session is killed.  A nil batcher
+// holds nothing.
+type batcher struct {
+	session Session
+	// publish calls the plugin with a batch
+	publish func(contentType string, content []byte, config map[string]ctypes.ConfigValue) error
+
+	mutex   sync.Mutex
+	batches map[string]*batch
+}
+
+func newBatcher(s Session, publish func(string, []byte, map[string]ctypes.ConfigValue) error) *batcher {
+	return &batcher{session: s, publish: publish, batches: map[string]*batch{}}
+}
+
+// batchKey identifies the batch of the calls with contentType and config.
+// Secure strings are keyed by their digest, as they print redacted.
+func batchKey(contentType string, config map[string]ctypes.ConfigValue) string {
+	keys := make([]string, 0, len(config))
+	for k := range config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{contentType}
+	for _, k := range keys {
+		if s, ok := config[k].(ctypes.ConfigValueSecureString); ok {
+			parts = append(parts, fmt.Sprintf("%s=%T{%s}", k, s, s.Digest()))
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s=%#v", k, config[k]))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// add appends the metrics of a publish call to their batch.  The call
+// filling the batch to size flushes it, and returns the error of the
+// plugin.
+func (b *batcher) add(contentType string, content []byte, config map[string]ctypes.ConfigValue, size int, timeout time.Duration) error {
+	mts, err := DecodeMetrics(contentType, content)
+	if err != nil {
+		return err
+	}
+	key := batchKey(contentType, config)
+	b.mutex.Lock()
+	bt, ok := b.batches[key]
+	if !ok {
+		bt = &batch{key: key, contentType: contentType, config: config, created: time.Now()}
+		bt.timer = time.AfterFunc(timeout, func() { b.expire(bt, timeout) })
+		b.batches[key] = bt
+	}
+	bt.metrics = append(bt.metrics, mts...)
+	if size == 0 || len(bt.metrics) < size {
+		b.mutex.Unlock()
+		return nil
+	}
+	delete(b.batches, key)
+	bt.timer.Stop()
+	b.mutex.Unlock()
+	return b.flush(bt)
+}
+
+// expire flushes bt once it reached its timeout, as a call of its own.  A
+// batch which cannot be flushed yet, e.g. while all the workers are busy,
+// is tried again after timeout.
+func (b *batcher) expire(bt *batch, timeout time.Duration) {
+	if err := b.session.beginCall(context.Background()); err != nil {
+		b.mutex.Lock()
+		if b.batches[bt.key] == bt {
+			bt.timer.Reset(timeout)
+		}
+		b.mutex.Unlock()
+		return
+	}
+	defer b.session.endCall()
+	b.mutex.Lock()
+	if b.batches[bt.key] != bt {
+		b.mutex.Unlock()
+		return
+	}
+	delete(b.batches, bt.key)
+	b.mutex.Unlock()
+	if err := b.flush(bt); err != nil {
+		b.session.Logger().Warnf("Flushing a batch of %d metrics failed: %v", len(bt.metrics), err)
+	}
+}
+
+// flushAll flushes every batch, oldest first, when the session is killed
+func (b *batcher) flushAll() {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	batches := make([]*batch, 0, len(b.batches))
+	for _, bt := range b.batches {
+		bt.timer.Stop()
+		batches = append(batches, bt)
+	}
+	b.batches = map[string]*batch{}
+	b.mutex.Unlock()
+	sort.Sort(batchesByAge(batches))
+	for _, bt := range batches {
+		if err := b.flush(bt); err != nil {
+			b.session.Logger().Warnf("Flushing a batch of %d metrics failed: %v", len(bt.metrics), err)
+		}
+	}
+}
+
+func (b *batcher) flush(bt *batch) error {
+	content, err := EncodeMetrics(bt.contentType, bt.metrics)
+	if err != nil {
+		return err
+	}
+	return b.publish(bt.contentType, content, bt.config)
+}
+
+type batchesByAge []*batch
+
+func (b batchesByAge) Len() int           { return len(b) }
+func (b batchesByAge) Less(i, j int) bool { return b[i].created.Before(b[j].created) }
+func (b batchesByAge) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
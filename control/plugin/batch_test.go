@@ -0,0 +1,167 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encrypter"
+	"github.com/intelsdi-x/snap/core"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// batchSink records the namespaces of each batch published, and the
+// password it was published with, if any
+type batchSink struct {
+	MockPublisher
+
+	mutex     sync.Mutex
+	batches   [][]string
+	passwords []string
+}
+
+func (s *batchSink) Publish(contentType string, content []byte, config map[string]ctypes.ConfigValue) error {
+	mts, err := DecodeMetrics(contentType, content)
+	if err != nil {
+		return err
+	}
+	names := make([]string, len(mts))
+	for i, mt := range mts {
+		names[i] = mt.Namespace().String()
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.batches = append(s.batches, names)
+	if v, ok := config["password"].(ctypes.ConfigValueSecureString); ok {
+		p, err := v.Reveal()
+		if err != nil {
+			return err
+		}
+		s.passwords = append(s.passwords, p)
+	}
+	return nil
+}
+
+func (s *batchSink) published() [][]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([][]string{}, s.batches...)
+}
+
+func TestBatch(t *testing.T) {
+	Convey("A publisher batching its metrics", t, func() {
+		m := NewPluginMeta("batch", 1, PublisherPluginType, []string{SnapGOBContentType}, nil, Unsecure(true))
+		sink := &batchSink{}
+		ss, err, _ := NewSessionState(`{"LogLevel": "info"}`, sink, m)
+		So(err, ShouldBeNil)
+		proxy := &publisherPluginProxy{Plugin: sink, Session: ss, Meta: m, config: ss.config}
+		proxy.batches = newBatcher(ss, proxy.publishBatch)
+		ss.batches = proxy.batches
+		publish := func(config map[string]ctypes.ConfigValue, names ...string) PublishReply {
+			mts := make([]MetricType, len(names))
+			for i, name := range names {
+				mts[i] = *NewMetricType(core.NewNamespace("batch", name), time.Now(), nil, "", i)
+			}
+			content, err := EncodeMetrics(SnapGOBContentType, mts)
+			So(err, ShouldBeNil)
+			in, err := ss.Encode(PublishArgs{ContentType: SnapGOBContentType, Content: content, Token: ss.Token(), Config: config})
+			So(err, ShouldBeNil)
+			var out []byte
+			So(proxy.Publish(in, &out), ShouldBeNil)
+			var reply PublishReply
+			So(ss.Decode(out, &reply), ShouldBeNil)
+			return reply
+		}
+		size := func(n int) map[string]ctypes.ConfigValue {
+			return map[string]ctypes.ConfigValue{
+				BatchSizeKey:    ctypes.ConfigValueInt{Value: n},
+				BatchTimeoutKey: ctypes.ConfigValueInt{Value: int(time.Minute / time.Millisecond)},
+			}
+		}
+
+		Convey("flushes a batch once it holds batch_size metrics", func() {
+			So(publish(size(4), "a1", "a2").Batched, ShouldBeTrue)
+			So(publish(size(4), "b1").Batched, ShouldBeTrue)
+			So(sink.published(), ShouldBeEmpty)
+			publish(size(4), "c1", "c2")
+			So(sink.published(), ShouldResemble, [][]string{{"/batch/a1", "/batch/a2", "/batch/b1", "/batch/c1", "/batch/c2"}})
+		})
+		Convey("keeps the calls with another config apart", func() {
+			other := size(2)
+			other["task"] = ctypes.ConfigValueStr{Value: "other"}
+			publish(size(2), "a1")
+			publish(other, "b1")
+			publish(size(2), "a2")
+			So(sink.published(), ShouldResemble, [][]string{{"/batch/a1", "/batch/a2"}})
+		})
+		Convey("flushes a batch after batch_timeout", func() {
+			config := map[string]ctypes.ConfigValue{BatchTimeoutKey: ctypes.ConfigValueInt{Value: 30}}
+			publish(config, "a1")
+			publish(config, "a2")
+			So(sink.published(), ShouldBeEmpty)
+			deadline := time.Now().Add(5 * time.Second)
+			for len(sink.published()) == 0 && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			So(sink.published(), ShouldResemble, [][]string{{"/batch/a1", "/batch/a2"}})
+		})
+		Convey("flushes its batches when killed", func() {
+			publish(size(100), "a1")
+			other := size(100)
+			other["task"] = ctypes.ConfigValueStr{Value: "other"}
+			publish(other, "b1")
+			publish(size(100), "a2")
+			So(sink.published(), ShouldBeEmpty)
+			ss.drain()
+			So(sink.published(), ShouldResemble, [][]string{{"/batch/a1", "/batch/a2"}, {"/batch/b1"}})
+		})
+		Convey("keeps the calls with another secret apart", func() {
+			key, err := encrypter.GenerateKey()
+			So(err, ShouldBeNil)
+			session := encrypter.New(nil, nil)
+			session.Key = key
+			ss.Encrypter = session
+			// control seals the secret of each call anew
+			secret := func(password string) map[string]ctypes.ConfigValue {
+				config := size(2)
+				sealed, err := ctypes.NewConfigValueSecureString(password).Seal(session)
+				So(err, ShouldBeNil)
+				config["password"] = sealed
+				return config
+			}
+			publish(secret("task A"), "a1")
+			publish(secret("task B"), "b1")
+			So(sink.published(), ShouldBeEmpty)
+			publish(secret("task B"), "b2")
+			publish(secret("task A"), "a2")
+			So(sink.published(), ShouldResemble, [][]string{{"/batch/b1", "/batch/b2"}, {"/batch/a1", "/batch/a2"}})
+			So(sink.passwords, ShouldResemble, []string{"task B", "task A"})
+		})
+		Convey("publishes at once without the batch keys", func() {
+			So(publish(nil, "a1").Batched, ShouldBeFalse)
+			So(sink.published(), ShouldResemble, [][]string{{"/batch/a1"}})
+		})
+	})
+}
@@ -0,0 +1,354 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/core/cdata"
+)
+
+// DefaultBenchDuration is how long Bench runs when BenchOptions set neither
+// a Duration nor Iterations
+var DefaultBenchDuration = 10 * time.Second
+
+var (
+	// ErrBenchUnsupported is returned by Bench for a plugin which is not a
+	// collector
+	ErrBenchUnsupported = errors.New("only collectors can be benchmarked")
+	// ErrBenchEmptyCatalog is returned by Bench when no metric of the
+	// catalog matches BenchOptions.Prefix
+	ErrBenchEmptyCatalog = errors.New("no metric type to collect")
+)
+
+// BenchOptions configure Bench
+type BenchOptions struct {
+	// Duration is how long CollectMetrics is called, unless Iterations is
+	// set.  Defaults to DefaultBenchDuration.
+	Duration time.Duration
+	// Iterations is the number of CollectMetrics calls
+	Iterations int
+	// Concurrency is the number of calls in progress at once.  Defaults
+	// to 1.
+	Concurrency int
+	// Prefix restricts the metric types collected to those whose
+	// namespace starts with it, e.g. "/intel/mock".  Empty collects the
+	// whole catalog.
+	Prefix string
+	// ContentTypes are the content types the collected metrics are
+	// encoded in to measure their size.  Defaults to the content types of
+	// NewArg.
+	ContentTypes []string
+}
+
+// BenchLatency sums up the durations of the CollectMetrics calls
+type BenchLatency struct {
+	Min  time.Duration
+	Mean time.Duration
+	P50  time.Duration
+	P95  time.Duration
+	P99  time.Duration
+	Max  time.Duration
+}
+
+// BenchEncoding is the size of the metrics collected by a call once encoded
+// in ContentType, and the throughput in bytes it makes at the rate of the
+// calls measured
+type BenchEncoding struct {
+	ContentType  string
+	BytesPerCall int
+	BytesPerSec  float64
+	Error        string `json:",omitempty"`
+}
+
+// BenchResult is the outcome of Bench
+type BenchResult struct {
+	Plugin      string
+	Version     int
+	Concurrency int
+	// MetricTypes is the number of metric types requested by each call
+	MetricTypes int
+	Calls       int
+	Errors      int
+	LastError   string `json:",omitempty"`
+	Duration    time.Duration
+	// Metrics is the number of metrics returned by all the calls
+	Metrics       int
+	CallsPerSec   float64
+	MetricsPerSec float64
+	Latency       BenchLatency
+	Encodings     []BenchEncoding
+	// AllocsPerCall and BytesAllocatedPerCall are the heap allocations of
+	// the process during the benchmark divided by the calls
+	AllocsPerCall         uint64
+	BytesAllocatedPerCall uint64
+}
+
+// Bench calls the CollectMetrics of collector c with its catalog, each
+// metric type with the default config of its policy, and measures the
+// latency of the calls and the rate of metrics they return.  Failed calls
+// are counted and timed like the others.
+func Bench(m *PluginMeta, c Plugin, opts BenchOptions) (*BenchResult, error) {
+	if m.Type != CollectorPluginType {
+		return nil, ErrBenchUnsupported
+	}
+	collector := collectorPlugin(c, nil)
+	catalog, err := benchCatalog(collector, opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Duration <= 0 && opts.Iterations <= 0 {
+		opts.Duration = DefaultBenchDuration
+	}
+	if len(opts.ContentTypes) == 0 {
+		opts.ContentTypes = NewArg(0).ContentTypes
+	}
+
+	var (
+		mutex     sync.Mutex
+		latencies []time.Duration
+		metrics   int
+		errs      int
+		lastError string
+		sample    []MetricType
+		wg        sync.WaitGroup
+	)
+	// next hands out the calls to the workers until the benchmark is over
+	calls := 0
+	var deadline time.Time
+	next := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if opts.Iterations > 0 {
+			calls++
+			return calls <= opts.Iterations
+		}
+		return time.Now().Before(deadline)
+	}
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	deadline = start.Add(opts.Duration)
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for next() {
+				t := time.Now()
+				mts, err := collector.CollectMetrics(catalog)
+				d := time.Since(t)
+				mutex.Lock()
+				latencies = append(latencies, d)
+				if err != nil {
+					errs++
+					lastError = err.Error()
+				} else {
+					metrics += len(mts)
+					if sample == nil {
+						sample = mts
+					}
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	r := &BenchResult{
+		Plugin:      m.Name,
+		Version:     m.Version,
+		Concurrency: opts.Concurrency,
+		MetricTypes: len(catalog),
+		Calls:       len(latencies),
+		Errors:      errs,
+		LastError:   lastError,
+		Duration:    elapsed,
+		Metrics:     metrics,
+		Latency:     benchLatency(latencies),
+	}
+	if elapsed > 0 {
+		r.CallsPerSec = float64(r.Calls) / elapsed.Seconds()
+		r.MetricsPerSec = float64(metrics) / elapsed.Seconds()
+	}
+	if r.Calls > 0 {
+		r.AllocsPerCall = (after.Mallocs - before.Mallocs) / uint64(r.Calls)
+		r.BytesAllocatedPerCall = (after.TotalAlloc - before.TotalAlloc) / uint64(r.Calls)
+	}
+	for _, ct := range opts.ContentTypes {
+		e := BenchEncoding{ContentType: ct}
+		if len(sample) == 0 {
+			e.Error = "no metric collected"
+		} else if b, err := EncodeMetrics(ct, sample); err != nil {
+			e.Error = err.Error()
+		} else {
+			// Every successful call is assumed to return as many bytes
+			// as the first one
+			e.BytesPerCall = len(b)
+			e.BytesPerSec = float64(len(b)) * float64(r.Calls-r.Errors) / elapsed.Seconds()
+		}
+		r.Encodings = append(r.Encodings, e)
+	}
+	return r, nil
+}
+
+// benchCatalog returns the metric types of the catalog of c whose
+// namespace starts with prefix, with the default config of their policy
+func benchCatalog(c CollectorPlugin, prefix string) ([]MetricType, error) {
+	policy, err := c.GetConfigPolicy()
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		policy = cpolicy.New()
+	}
+	all, err := c.GetMetricTypes(NewPluginConfigType())
+	if err != nil {
+		return nil, err
+	}
+	var catalog []MetricType
+	for _, mt := range all {
+		if !strings.HasPrefix(mt.Namespace().String(), prefix) {
+			continue
+		}
+		config, err := defaultConfig(policy, mt.Namespace().Strings())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", mt.Namespace(), err)
+		}
+		mt.Config_ = cdata.FromTable(config)
+		catalog = append(catalog, mt)
+	}
+	if len(catalog) == 0 {
+		return nil, ErrBenchEmptyCatalog
+	}
+	return catalog, nil
+}
+
+// benchLatency sums up latencies, which it sorts
+func benchLatency(latencies []time.Duration) BenchLatency {
+	if len(latencies) == 0 {
+		return BenchLatency{}
+	}
+	sort.Sort(durations(latencies))
+	var total time.Duration
+	for _, d := range latencies {
+		total += d
+	}
+	return BenchLatency{
+		Min:  latencies[0],
+		Mean: total / time.Duration(len(latencies)),
+		P50:  percentile(latencies, 0.50),
+		P95:  percentile(latencies, 0.95),
+		P99:  percentile(latencies, 0.99),
+		Max:  latencies[len(latencies)-1],
+	}
+}
+
+// percentile returns the duration under which a fraction q of the sorted
+// durations fall, by the nearest rank
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// write writes the result as tables
+func (r *BenchResult) write(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Plugin\t%s version %d\n", r.Plugin, r.Version)
+	fmt.Fprintf(w, "Calls\t%d in %v, %d failed, concurrency %d\n", r.Calls, r.Duration, r.Errors, r.Concurrency)
+	if r.LastError != "" {
+		fmt.Fprintf(w, "Last error\t%s\n", r.LastError)
+	}
+	fmt.Fprintf(w, "Metrics\t%d of %d metric types, %.1f/s\n", r.Metrics, r.MetricTypes, r.MetricsPerSec)
+	fmt.Fprintf(w, "Allocations\t%d allocs/call, %d bytes/call\n\n", r.AllocsPerCall, r.BytesAllocatedPerCall)
+	fmt.Fprintf(w, "CALLS/S\tMIN\tMEAN\tP50\tP95\tP99\tMAX\n")
+	l := r.Latency
+	fmt.Fprintf(w, "%.1f\t%v\t%v\t%v\t%v\t%v\t%v\n\n", r.CallsPerSec, l.Min, l.Mean, l.P50, l.P95, l.P99, l.Max)
+	fmt.Fprintf(w, "CONTENT TYPE\tBYTES/CALL\tBYTES/S\n")
+	for _, e := range r.Encodings {
+		if e.Error != "" {
+			fmt.Fprintf(w, "%s\t-\tFAILED: %s\n", e.ContentType, e.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%.0f\n", e.ContentType, e.BytesPerCall, e.BytesPerSec)
+	}
+	return w.Flush()
+}
+
+// runBench serves the --bench command line of a plugin run by hand
+func runBench(m *PluginMeta, c Plugin, args []string, out, errOut io.Writer) int {
+	flags := flag.NewFlagSet("--bench", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	var opts BenchOptions
+	flags.DurationVar(&opts.Duration, "duration", 0, "")
+	flags.IntVar(&opts.Iterations, "iterations", 0, "")
+	flags.IntVar(&opts.Concurrency, "concurrency", 1, "")
+	flags.StringVar(&opts.Prefix, "prefix", "", "")
+	asJSON := flags.Bool("json", false, "")
+	if err := flags.Parse(args); err != nil {
+		fmt.Fprintf(errOut, "%v\n", err)
+		fmt.Fprintf(errOut, usage, commandName())
+		return exitUsage
+	}
+	r, err := Bench(m, c, opts)
+	if err != nil {
+		fmt.Fprintf(errOut, "benchmark failed: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		var b []byte
+		if b, err = json.MarshalIndent(r, "", "  "); err == nil {
+			_, err = fmt.Fprintf(out, "%s\n", b)
+		}
+	} else {
+		err = r.write(out)
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,168 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// benchCollector takes delay to collect each metric type requested, and
+// fails every failEvery call
+type benchCollector struct {
+	delay     time.Duration
+	failEvery int64
+	calls     int64
+}
+
+func (c *benchCollector) CollectMetrics(mts []MetricType) ([]MetricType, error) {
+	n := atomic.AddInt64(&c.calls, 1)
+	time.Sleep(c.delay)
+	if c.failEvery > 0 && n%c.failEvery == 0 {
+		return nil, errors.New("collection failed")
+	}
+	out := make([]MetricType, len(mts))
+	for i, mt := range mts {
+		out[i] = *NewMetricType(mt.Namespace(), time.Now(), nil, "", float64(n))
+	}
+	return out, nil
+}
+
+func (c *benchCollector) GetMetricTypes(_ ConfigType) ([]MetricType, error) {
+	return []MetricType{
+		*NewMetricType(core.NewNamespace("bench", "a"), time.Time{}, nil, "", nil),
+		*NewMetricType(core.NewNamespace("bench", "b"), time.Time{}, nil, "", nil),
+		*NewMetricType(core.NewNamespace("other", "c"), time.Time{}, nil, "", nil),
+	}, nil
+}
+
+func (c *benchCollector) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	return cpolicy.New(), nil
+}
+
+func TestBench(t *testing.T) {
+	m := NewPluginMeta("bench", 2, CollectorPluginType, nil, nil, Unsecure(true))
+
+	Convey("Bench", t, func() {
+		Convey("runs the requested iterations over the whole catalog", func() {
+			c := &benchCollector{delay: time.Millisecond}
+			r, err := Bench(m, c, BenchOptions{Iterations: 20, Concurrency: 4})
+			So(err, ShouldBeNil)
+			So(c.calls, ShouldEqual, 20)
+			So(r.Plugin, ShouldEqual, "bench")
+			So(r.Version, ShouldEqual, 2)
+			So(r.Concurrency, ShouldEqual, 4)
+			So(r.MetricTypes, ShouldEqual, 3)
+			So(r.Calls, ShouldEqual, 20)
+			So(r.Errors, ShouldEqual, 0)
+			So(r.Metrics, ShouldEqual, 60)
+			So(r.MetricsPerSec, ShouldAlmostEqual, 3*r.CallsPerSec, 0.001)
+			So(r.CallsPerSec, ShouldAlmostEqual, 20/r.Duration.Seconds(), 0.001)
+
+			l := r.Latency
+			So(l.Min, ShouldBeGreaterThanOrEqualTo, time.Millisecond)
+			So(l.Min, ShouldBeLessThanOrEqualTo, l.P50)
+			So(l.P50, ShouldBeLessThanOrEqualTo, l.P95)
+			So(l.P95, ShouldBeLessThanOrEqualTo, l.P99)
+			So(l.P99, ShouldBeLessThanOrEqualTo, l.Max)
+			So(l.Mean, ShouldBeBetweenOrEqual, l.Min, l.Max)
+			So(r.AllocsPerCall, ShouldBeGreaterThan, 0)
+
+			So(r.Encodings, ShouldHaveLength, len(NewArg(0).ContentTypes))
+			for _, e := range r.Encodings {
+				So(e.Error, ShouldBeEmpty)
+				So(e.BytesPerCall, ShouldBeGreaterThan, 0)
+				So(e.BytesPerSec, ShouldAlmostEqual, float64(e.BytesPerCall)*r.CallsPerSec, 0.001)
+			}
+		})
+		Convey("runs for the requested duration", func() {
+			c := &benchCollector{delay: time.Millisecond}
+			r, err := Bench(m, c, BenchOptions{Duration: 50 * time.Millisecond})
+			So(err, ShouldBeNil)
+			So(r.Duration, ShouldBeGreaterThanOrEqualTo, 50*time.Millisecond)
+			So(r.Calls, ShouldBeGreaterThan, 0)
+			So(r.Calls, ShouldBeLessThanOrEqualTo, 51)
+		})
+		Convey("collects the metric types matching the prefix only", func() {
+			r, err := Bench(m, &benchCollector{}, BenchOptions{Iterations: 5, Prefix: "/bench"})
+			So(err, ShouldBeNil)
+			So(r.MetricTypes, ShouldEqual, 2)
+			So(r.Metrics, ShouldEqual, 10)
+
+			_, err = Bench(m, &benchCollector{}, BenchOptions{Iterations: 5, Prefix: "/none"})
+			So(err, ShouldEqual, ErrBenchEmptyCatalog)
+		})
+		Convey("counts the failed calls", func() {
+			r, err := Bench(m, &benchCollector{failEvery: 2}, BenchOptions{Iterations: 10, ContentTypes: []string{SnapJSONContentType}})
+			So(err, ShouldBeNil)
+			So(r.Calls, ShouldEqual, 10)
+			So(r.Errors, ShouldEqual, 5)
+			So(r.LastError, ShouldEqual, "collection failed")
+			So(r.Metrics, ShouldEqual, 15)
+			So(r.Encodings, ShouldHaveLength, 1)
+			So(r.Encodings[0].BytesPerSec, ShouldAlmostEqual, float64(r.Encodings[0].BytesPerCall)*5/r.Duration.Seconds(), 0.001)
+		})
+		Convey("refuses other plugins than collectors", func() {
+			p := NewPluginMeta("echo", 1, ProcessorPluginType, nil, nil)
+			_, err := Bench(p, &benchCollector{}, BenchOptions{Iterations: 1})
+			So(err, ShouldEqual, ErrBenchUnsupported)
+		})
+	})
+
+	Convey("A plugin run by hand with --bench", t, func() {
+		run := func(args ...string) (int, string, string) {
+			var out, errOut bytes.Buffer
+			code := RunCommand(m, &benchCollector{}, args, nil, &out, &errOut)
+			return code, out.String(), errOut.String()
+		}
+
+		Convey("prints the result as tables", func() {
+			code, out, _ := run("--bench", "--iterations", "3")
+			So(code, ShouldEqual, 0)
+			So(out, ShouldContainSubstring, "bench version 2")
+			So(out, ShouldContainSubstring, "P99")
+			So(out, ShouldContainSubstring, SnapGOBContentType)
+		})
+		Convey("prints the result as JSON", func() {
+			code, out, _ := run("--bench", "--iterations", "3", "--concurrency", "2", "--prefix", "/other", "--json")
+			So(code, ShouldEqual, 0)
+			var r BenchResult
+			So(json.Unmarshal([]byte(out), &r), ShouldBeNil)
+			So(r.Calls, ShouldEqual, 3)
+			So(r.Concurrency, ShouldEqual, 2)
+			So(r.Metrics, ShouldEqual, 3)
+		})
+		Convey("rejects unknown options", func() {
+			code, _, errOut := run("--bench", "--foo")
+			So(code, ShouldEqual, 2)
+			So(errOut, ShouldContainSubstring, "usage:")
+		})
+	})
+}
@@ -0,0 +1,146 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// metricCache holds the metrics collected for each requested metric type,
+// keyed by namespace and config, for the plugin's CacheTTL.
+type metricCache struct {
+	ttl time.Duration
+
+	mutex  sync.Mutex
+	cells  map[string]metricCacheCell
+	hits   uint64
+	misses uint64
+}
+
+type metricCacheCell struct {
+	time    time.Time
+	metrics []MetricType
+}
+
+// newMetricCache returns nil, which disables caching, unless ttl is
+// positive.
+func newMetricCache(ttl time.Duration) *metricCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &metricCache{ttl: ttl, cells: map[string]metricCacheCell{}}
+}
+
+// metricCacheKey returns the namespace of mt with a hash of its config.
+func metricCacheKey(mt MetricType) string {
+	key := mt.Namespace().String()
+	if mt.Config_ == nil {
+		return key
+	}
+	b, err := json.Marshal(mt.Config_)
+	if err != nil {
+		return key
+	}
+	h := sha256.Sum256(b)
+	return key + ":" + hex.EncodeToString(h[:])
+}
+
+// get returns the metrics cached under key, counting a hit or a miss.
+func (c *metricCache) get(key string, now time.Time) ([]MetricType, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cell, ok := c.cells[key]
+	if ok && now.Sub(cell.time) < c.ttl {
+		c.hits++
+		return cell.metrics, true
+	}
+	c.misses++
+	return nil, false
+}
+
+// put caches the metrics collected for the requested metric types, under
+// their keys, and drops the expired cells.  A metric is cached under the
+// requested metric types matching its namespace, or under the only one
+// requested.  A requested metric type which got no metric is not cached.
+func (c *metricCache) put(keys []string, requested, collected []MetricType, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, cell := range c.cells {
+		if now.Sub(cell.time) >= c.ttl {
+			delete(c.cells, key)
+		}
+	}
+	if len(requested) == 1 {
+		if len(collected) > 0 {
+			c.cells[keys[0]] = metricCacheCell{time: now, metrics: collected}
+		}
+		return
+	}
+	for i, mt := range requested {
+		var metrics []MetricType
+		for _, m := range collected {
+			if mt.Match(m.Namespace().Strings()) {
+				metrics = append(metrics, m)
+			}
+		}
+		if len(metrics) > 0 {
+			c.cells[keys[i]] = metricCacheCell{time: now, metrics: metrics}
+		}
+	}
+}
+
+func (c *metricCache) counts() (hits, misses uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.hits, c.misses
+}
+
+// collect returns the cached metrics of mts, and calls the plugin for the
+// others.
+func (c *metricCache) collect(p CollectorPlugin, mts []MetricType) ([]MetricType, error) {
+	now := time.Now()
+	var (
+		out    []MetricType
+		missed []MetricType
+		keys   []string
+	)
+	for _, mt := range mts {
+		key := metricCacheKey(mt)
+		if cached, ok := c.get(key, now); ok {
+			out = append(out, cached...)
+			continue
+		}
+		missed = append(missed, mt)
+		keys = append(keys, key)
+	}
+	if len(missed) == 0 {
+		return out, nil
+	}
+	ms, err := p.CollectMetrics(missed)
+	if err != nil {
+		return nil, err
+	}
+	c.put(keys, missed, ms, time.Now())
+	return append(out, ms...), nil
+}
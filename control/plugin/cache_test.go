@@ -0,0 +1,132 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"net/rpc"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	"github.com/intelsdi-x/snap/core"
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// callCountingCollector counts the calls to CollectMetrics
+type callCountingCollector struct {
+	mockPlugin
+
+	calls int32
+}
+
+func (c *callCountingCollector) CollectMetrics(mts []MetricType) ([]MetricType, error) {
+	atomic.AddInt32(&c.calls, 1)
+	return c.mockPlugin.CollectMetrics(mts)
+}
+
+func TestMetricCache(t *testing.T) {
+	start := func(ttl time.Duration) (*callCountingCollector, *rpc.Client, Response, chan int) {
+		m := NewPluginMeta("cache", 1, CollectorPluginType, nil, nil, Unsecure(true), CacheTTL(ttl))
+		collector := &callCountingCollector{}
+		resp, done := startTestPlugin(m, collector, fmt.Sprintf(`{"PingTimeoutDuration": %d}`, time.Minute))
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		return collector, client, resp, done
+	}
+	collect := func(client *rpc.Client, token string, mts []MetricType) []MetricType {
+		enc := encoding.NewGobEncoder()
+		in, err := enc.Encode(CollectMetricsArgs{MetricTypes: mts, Token: token})
+		So(err, ShouldBeNil)
+		var out []byte
+		So(client.Call("Collector.CollectMetrics", in, &out), ShouldBeNil)
+		var r CollectMetricsReply
+		So(enc.Decode(out, &r), ShouldBeNil)
+		return r.PluginMetrics
+	}
+	stats := func(client *rpc.Client, token string) SessionStats {
+		enc := encoding.NewGobEncoder()
+		in, err := enc.Encode(StatsArgs{Token: token})
+		So(err, ShouldBeNil)
+		var out []byte
+		So(client.Call("SessionState.GetStats", in, &out), ShouldBeNil)
+		var st SessionStats
+		So(enc.Decode(out, &st), ShouldBeNil)
+		return st
+	}
+	mts := []MetricType{*NewMetricType(core.NewNamespace("foo", "baz"), time.Now(), nil, "", 1)}
+
+	Convey("A collector with a CacheTTL", t, func() {
+		collector, client, resp, done := start(time.Minute)
+		defer client.Close()
+
+		Convey("is called once for two collections within the TTL", func() {
+			first := collect(client, resp.Token, mts)
+			second := collect(client, resp.Token, mts)
+			So(atomic.LoadInt32(&collector.calls), ShouldEqual, 1)
+			So(second, ShouldHaveLength, 1)
+			So(second[0].Timestamp().Equal(first[0].Timestamp()), ShouldBeTrue)
+			st := stats(client, resp.Token)
+			So(st.CacheHits, ShouldEqual, 1)
+			So(st.CacheMisses, ShouldEqual, 1)
+		})
+		Convey("is called for a metric with another config", func() {
+			collect(client, resp.Token, mts)
+			cfg := cdata.NewNode()
+			cfg.AddItem("user", ctypes.ConfigValueStr{Value: "foo"})
+			other := *NewMetricType(core.NewNamespace("foo", "baz"), time.Now(), nil, "", 1)
+			other.Config_ = cfg
+			collect(client, resp.Token, []MetricType{other})
+			So(atomic.LoadInt32(&collector.calls), ShouldEqual, 2)
+			collect(client, resp.Token, []MetricType{mts[0], other})
+			So(atomic.LoadInt32(&collector.calls), ShouldEqual, 2)
+		})
+		So(callKill(client, resp.Token), ShouldBeNil)
+		<-done
+	})
+	Convey("A collector is called again once the CacheTTL has passed", t, func() {
+		collector, client, resp, done := start(50 * time.Millisecond)
+		defer client.Close()
+		collect(client, resp.Token, mts)
+		time.Sleep(100 * time.Millisecond)
+		collect(client, resp.Token, mts)
+		So(atomic.LoadInt32(&collector.calls), ShouldEqual, 2)
+		So(stats(client, resp.Token).CacheMisses, ShouldEqual, 2)
+		So(callKill(client, resp.Token), ShouldBeNil)
+		<-done
+	})
+	Convey("A collector without a CacheTTL is always called", t, func() {
+		collector, client, resp, done := start(0)
+		defer client.Close()
+		collect(client, resp.Token, mts)
+		collect(client, resp.Token, mts)
+		So(atomic.LoadInt32(&collector.calls), ShouldEqual, 2)
+		st := stats(client, resp.Token)
+		So(st.CacheHits, ShouldEqual, 0)
+		So(st.CacheMisses, ShouldEqual, 0)
+		So(callKill(client, resp.Token), ShouldBeNil)
+		<-done
+	})
+}
@@ -0,0 +1,114 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import "sort"
+
+// Capabilities advertised in Response.Capabilities
+const (
+	// CapabilityStats means the plugin serves SessionState.GetStats
+	CapabilityStats = "stats"
+	// CapabilityPingStatus means the plugin serves SessionState.PingStatus
+	CapabilityPingStatus = "ping-status"
+	// CapabilityHealth means the plugin reports its own health in the
+	// PingReply
+	CapabilityHealth = "health"
+	// CapabilityKillHook means the plugin cleans up when it is killed
+	CapabilityKillHook = "kill-hook"
+	// CapabilityMetricConfig means the collector is given the config of
+	// each metric
+	CapabilityMetricConfig = "metric-config"
+	// CapabilityMetricCache means the collector caches the metrics it
+	// collects for its CacheTTL
+	CapabilityMetricCache = "metric-cache"
+	// CapabilitySecureConfig means secure config values may be sent,
+	// sealed with the session key
+	CapabilitySecureConfig = "secure-config"
+	// CapabilitySignedRequests means the plugin verifies signed requests
+	// from control
+	CapabilitySignedRequests = "signed-requests"
+	// CapabilityTLS means the listener requires TLS
+	CapabilityTLS = "tls"
+	// CapabilityJSONCodec means the plugin is called with JSON
+	CapabilityJSONCodec = "json-codec"
+	// CapabilitySubscriptions means the collector is told when metrics are
+	// subscribed and unsubscribed
+	CapabilitySubscriptions = "subscriptions"
+	// CapabilityPush means the plugin pushes metrics to Arg.PushAddress
+	CapabilityPush = "push"
+	// CapabilitySelfTest means the plugin checks its dependencies when
+	// SessionState.SelfTest is called
+	CapabilitySelfTest = "self-test"
+)
+
+// HasCapability reports whether the plugin advertised capability c.
+func (r *Response) HasCapability(c string) bool {
+	for _, rc := range r.Capabilities {
+		if rc == c {
+			return true
+		}
+	}
+	return false
+}
+
+// capabilities returns the sorted capabilities of the session serving r,
+// from the interfaces implemented by the plugin and the features of the
+// session.
+func (s *SessionState) capabilities(r *Response) []string {
+	var caps []string
+	if r.Meta.RPCType != GRPC {
+		caps = append(caps, CapabilityStats, CapabilityPingStatus)
+		if s.Encrypter != nil {
+			caps = append(caps, CapabilitySecureConfig)
+		}
+	}
+	if _, ok := s.plugin.(HealthReporter); ok {
+		caps = append(caps, CapabilityHealth)
+	}
+	if s.onKill.fn != nil {
+		caps = append(caps, CapabilityKillHook)
+	}
+	if r.Type == CollectorPluginType {
+		caps = append(caps, CapabilityMetricConfig)
+		if s.cache != nil {
+			caps = append(caps, CapabilityMetricCache)
+		}
+	}
+	if _, ok := s.plugin.(SubscriptionHandler); ok && r.Meta.RPCType != GRPC {
+		caps = append(caps, CapabilitySubscriptions)
+	}
+	if _, ok := s.plugin.(PushCollector); ok && s.pushes != nil {
+		caps = append(caps, CapabilityPush)
+	}
+	if s.Arg != nil && s.ControlPubKey != nil {
+		caps = append(caps, CapabilitySignedRequests)
+	}
+	if _, ok := s.plugin.(SelfTester); ok && r.Meta.RPCType != GRPC {
+		caps = append(caps, CapabilitySelfTest)
+	}
+	if s.Arg != nil && s.CertPath != "" {
+		caps = append(caps, CapabilityTLS)
+	}
+	if r.Meta.RPCType == JSONRPC || r.Codec == JSONCodec {
+		caps = append(caps, CapabilityJSONCodec)
+	}
+	sort.Strings(caps)
+	return caps
+}
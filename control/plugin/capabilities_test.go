@@ -0,0 +1,92 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// killHookPublisher is a publisher with an OnKill hook
+type killHookPublisher struct {
+	MockPublisher
+}
+
+func (p *killHookPublisher) OnKill(KillReason) error {
+	return nil
+}
+
+func TestCapabilities(t *testing.T) {
+	capabilities := func(m *PluginMeta, p Plugin, args string) []string {
+		resp, done := startTestPlugin(m, p, fmt.Sprintf(`{"PingTimeoutDuration": %d, %s}`, time.Millisecond, args))
+		So(resp.State, ShouldEqual, PluginSuccess)
+		<-done
+		return resp.Capabilities
+	}
+	Convey("A basic collector advertises", t, func() {
+		Convey("the stats, ping status and metric config", func() {
+			m := NewPluginMeta("basic", 1, CollectorPluginType, nil, nil, Unsecure(true))
+			So(capabilities(m, &MockPlugin{}, `"Codec": "gob"`), ShouldResemble, []string{
+				CapabilityMetricConfig,
+				CapabilityPingStatus,
+				CapabilityStats,
+			})
+		})
+		Convey("secure config in an encrypted session", func() {
+			m := NewPluginMeta("basic", 1, CollectorPluginType, nil, nil)
+			So(capabilities(m, &MockPlugin{}, `"Codec": "gob"`), ShouldResemble, []string{
+				CapabilityMetricConfig,
+				CapabilityPingStatus,
+				CapabilitySecureConfig,
+				CapabilityStats,
+			})
+		})
+		Convey("the JSON codec", func() {
+			m := NewPluginMeta("basic", 1, CollectorPluginType, nil, nil, Unsecure(true))
+			So(capabilities(m, &MockPlugin{}, `"Codec": "json"`), ShouldContain, CapabilityJSONCodec)
+		})
+	})
+	Convey("A collector with a CacheTTL reporting its health advertises both", t, func() {
+		m := NewPluginMeta("health", 1, CollectorPluginType, nil, nil, Unsecure(true), CacheTTL(time.Second))
+		caps := capabilities(m, &failingCollector{}, `"Codec": "gob"`)
+		So(caps, ShouldContain, CapabilityHealth)
+		So(caps, ShouldContain, CapabilityMetricCache)
+		So(caps, ShouldNotContain, CapabilityKillHook)
+	})
+	Convey("A publisher with an OnKill hook advertises it", t, func() {
+		m := NewPluginMeta("cleanup", 1, PublisherPluginType, nil, nil, Unsecure(true))
+		So(capabilities(m, &killHookPublisher{}, `"Codec": "gob"`), ShouldResemble, []string{
+			CapabilityKillHook,
+			CapabilityPingStatus,
+			CapabilityStats,
+		})
+	})
+	Convey("HasCapability", t, func() {
+		r := &Response{Capabilities: []string{CapabilityStats, CapabilityTLS}}
+		So(r.HasCapability(CapabilityTLS), ShouldBeTrue)
+		So(r.HasCapability(CapabilityHealth), ShouldBeFalse)
+		So((&Response{}).HasCapability(CapabilityStats), ShouldBeFalse)
+	})
+}
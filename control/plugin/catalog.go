@@ -0,0 +1,182 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/intelsdi-x/snap/core"
+)
+
+// maxRemovedRecords caps the removals a catalogTracker remembers.  An
+// incremental request older than the removals it forgot gets the full
+// catalog.
+const maxRemovedRecords = 1024
+
+type catalogRecord struct {
+	namespace   core.Namespace
+	fingerprint string
+	advertised  time.Time
+}
+
+type removedRecord struct {
+	namespace core.Namespace
+	removed   time.Time
+}
+
+// catalogTracker remembers when each metric in a collector's catalog was
+// last added or changed so that GetMetricTypes can answer incremental
+// requests.  Each update gets a strictly later time than the one before,
+// even if the system clock moves backwards.
+type catalogTracker struct {
+	mutex      sync.Mutex
+	now        func() time.Time
+	last       time.Time
+	records    map[string]catalogRecord
+	removed    map[string]removedRecord
+	maxRemoved int
+	// forgotten is the latest removal dropped to keep removed under
+	// maxRemoved
+	forgotten time.Time
+}
+
+func newCatalogTracker() *catalogTracker {
+	return &catalogTracker{
+		now:        time.Now,
+		records:    map[string]catalogRecord{},
+		removed:    map[string]removedRecord{},
+		maxRemoved: maxRemovedRecords,
+	}
+}
+
+// update records the current catalog, setting LastAdvertisedTime on each
+// metric to the time it was first seen in its current form.  It returns the
+// time of this update.
+func (c *catalogTracker) update(mts []MetricType) time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Strip the monotonic reading so that times compare on the wall clock,
+	// as they will once they have been encoded and sent to control.
+	now := c.now().Round(0)
+	if !now.After(c.last) {
+		now = c.last.Add(time.Nanosecond)
+	}
+	c.last = now
+
+	seen := make(map[string]bool, len(mts))
+	for i := range mts {
+		key := catalogKey(mts[i])
+		seen[key] = true
+		fp := fingerprint(mts[i])
+		rec, ok := c.records[key]
+		if !ok || rec.fingerprint != fp {
+			rec = catalogRecord{namespace: mts[i].Namespace(), fingerprint: fp, advertised: now}
+			c.records[key] = rec
+			delete(c.removed, key)
+		}
+		mts[i].LastAdvertisedTime_ = rec.advertised
+	}
+	for key, rec := range c.records {
+		if seen[key] {
+			continue
+		}
+		c.removed[key] = removedRecord{namespace: rec.namespace, removed: now}
+		delete(c.records, key)
+	}
+	c.prune()
+	return now
+}
+
+// prune forgets the oldest removals beyond maxRemoved.
+func (c *catalogTracker) prune() {
+	if len(c.removed) <= c.maxRemoved {
+		return
+	}
+	keys := make([]string, 0, len(c.removed))
+	for key := range c.removed {
+		keys = append(keys, key)
+	}
+	sort.Sort(byRemoved{keys, c.removed})
+	for _, key := range keys[:len(keys)-c.maxRemoved] {
+		if t := c.removed[key].removed; t.After(c.forgotten) {
+			c.forgotten = t
+		}
+		delete(c.removed, key)
+	}
+}
+
+// removedSince returns the namespaces dropped from the catalog after since.
+// It returns false when some of them were forgotten, see maxRemovedRecords.
+func (c *catalogTracker) removedSince(since time.Time) ([]core.Namespace, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.forgotten.After(since) {
+		return nil, false
+	}
+	keys := []string{}
+	for key, rec := range c.removed {
+		if rec.removed.After(since) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	nss := make([]core.Namespace, len(keys))
+	for i, key := range keys {
+		nss[i] = c.removed[key].namespace
+	}
+	return nss, true
+}
+
+// byRemoved sorts keys of removed records by their removal time.
+type byRemoved struct {
+	keys    []string
+	removed map[string]removedRecord
+}
+
+func (b byRemoved) Len() int      { return len(b.keys) }
+func (b byRemoved) Swap(i, j int) { b.keys[i], b.keys[j] = b.keys[j], b.keys[i] }
+func (b byRemoved) Less(i, j int) bool {
+	return b.removed[b.keys[i]].removed.Before(b.removed[b.keys[j]].removed)
+}
+
+// fingerprint captures the advertised attributes of a metric so that a
+// change can be detected between catalog calls.
+func fingerprint(mt MetricType) string {
+	ns := mt.Namespace()
+	keys := make([]string, 0, len(mt.Tags_))
+	for k := range mt.Tags_ {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tags := make([]string, len(keys))
+	for i, k := range keys {
+		tags[i] = k + "=" + mt.Tags_[k]
+	}
+	descs := make([]string, len(ns))
+	for i, e := range ns {
+		descs[i] = e.Name + ":" + e.Description
+	}
+	return fmt.Sprintf("%q|%q|%q|%q", mt.Unit_, mt.Description_, tags, descs)
+}
@@ -0,0 +1,184 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	"github.com/intelsdi-x/snap/core"
+
+	log "github.com/Sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func removedSince(ct *catalogTracker, since time.Time) []core.Namespace {
+	nss, ok := ct.removedSince(since)
+	So(ok, ShouldBeTrue)
+	return nss
+}
+
+func TestCatalogTracker(t *testing.T) {
+	Convey("catalogTracker", t, func() {
+		clock := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+		ct := newCatalogTracker()
+		ct.now = func() time.Time { return clock }
+		foo := *NewMetricType(core.NewNamespace("intel", "foo"), time.Time{}, nil, "", 1)
+		bar := *NewMetricType(core.NewNamespace("intel", "bar"), time.Time{}, nil, "", 1)
+
+		t1 := ct.update([]MetricType{foo, bar})
+		So(t1, ShouldResemble, clock)
+
+		Convey("unchanged metrics keep their advertised time", func() {
+			clock = clock.Add(time.Minute)
+			mts := []MetricType{foo, bar}
+			t2 := ct.update(mts)
+			So(t2.After(t1), ShouldBeTrue)
+			So(mts[0].LastAdvertisedTime(), ShouldResemble, t1)
+			So(mts[1].LastAdvertisedTime(), ShouldResemble, t1)
+			So(removedSince(ct, t1), ShouldBeEmpty)
+		})
+		Convey("added and updated metrics are advertised at the new time", func() {
+			clock = clock.Add(time.Minute)
+			baz := *NewMetricType(core.NewNamespace("intel", "baz"), time.Time{}, nil, "", 1)
+			fooUpdated := foo
+			fooUpdated.Unit_ = "bytes"
+			mts := []MetricType{fooUpdated, bar, baz}
+			t2 := ct.update(mts)
+			So(mts[0].LastAdvertisedTime(), ShouldResemble, t2)
+			So(mts[1].LastAdvertisedTime(), ShouldResemble, t1)
+			So(mts[2].LastAdvertisedTime(), ShouldResemble, t2)
+		})
+		Convey("removed metrics are reported after their removal", func() {
+			clock = clock.Add(time.Minute)
+			t2 := ct.update([]MetricType{foo})
+			So(removedSince(ct, t1), ShouldResemble, []core.Namespace{bar.Namespace()})
+			So(removedSince(ct, t2), ShouldBeEmpty)
+
+			Convey("and forgotten when they come back", func() {
+				clock = clock.Add(time.Minute)
+				mts := []MetricType{foo, bar}
+				t3 := ct.update(mts)
+				So(removedSince(ct, t1), ShouldBeEmpty)
+				So(mts[1].LastAdvertisedTime(), ShouldResemble, t3)
+			})
+		})
+		Convey("forgets the oldest removals beyond its cap", func() {
+			ct.maxRemoved = 1
+			clock = clock.Add(time.Minute)
+			t2 := ct.update([]MetricType{foo})
+			clock = clock.Add(time.Minute)
+			t3 := ct.update(nil)
+			So(ct.removed, ShouldHaveLength, 1)
+			So(removedSince(ct, t2), ShouldResemble, []core.Namespace{foo.Namespace()})
+			So(removedSince(ct, t3), ShouldBeEmpty)
+
+			_, ok := ct.removedSince(t1)
+			So(ok, ShouldBeFalse)
+		})
+		Convey("times keep moving forward when the clock moves backwards", func() {
+			clock = clock.Add(-time.Hour)
+			t2 := ct.update([]MetricType{foo})
+			So(t2.After(t1), ShouldBeTrue)
+			So(removedSince(ct, t1), ShouldResemble, []core.Namespace{bar.Namespace()})
+
+			t3 := ct.update([]MetricType{foo, bar})
+			So(t3.After(t2), ShouldBeTrue)
+			So(removedSince(ct, t2), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestCollectorProxyIncrementalCatalog(t *testing.T) {
+	Convey("GetMetricTypes with a since time", t, func() {
+		mockSessionState := &MockSessionState{
+			Encoder:             encoding.NewGobEncoder(),
+			listenPort:          "0",
+			token:               "abcdef",
+			logger:              log.New(),
+			PingTimeoutDuration: time.Millisecond * 100,
+			killChan:            make(chan int),
+		}
+		clock := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+		ct := newCatalogTracker()
+		ct.now = func() time.Time { return clock }
+		pp := &pagedPlugin{mts: []MetricType{
+			*NewMetricType(core.NewNamespace("intel", "foo"), time.Time{}, nil, "", 1),
+			*NewMetricType(core.NewNamespace("intel", "bar"), time.Time{}, nil, "", 1),
+		}}
+		c := &collectorPluginProxy{
+			Plugin:  pp,
+			Session: mockSessionState,
+			catalog: ct,
+		}
+		getMetricTypes := func(since time.Time) GetMetricTypesReply {
+			args, err := c.Session.Encode(GetMetricTypesArgs{PluginConfig: NewPluginConfigType(), Since: since})
+			So(err, ShouldBeNil)
+			var reply []byte
+			err = c.GetMetricTypes(args, &reply)
+			So(err, ShouldBeNil)
+			var mtr GetMetricTypesReply
+			err = c.Session.Decode(reply, &mtr)
+			So(err, ShouldBeNil)
+			return mtr
+		}
+
+		full := getMetricTypes(time.Time{})
+		So(len(full.MetricTypes), ShouldEqual, 2)
+		So(full.Removed, ShouldBeEmpty)
+
+		clock = clock.Add(time.Minute)
+		r := getMetricTypes(full.Timestamp)
+		So(r.MetricTypes, ShouldBeEmpty)
+		So(r.Removed, ShouldBeEmpty)
+
+		clock = clock.Add(time.Minute)
+		pp.mts = []MetricType{
+			pp.mts[0],
+			*NewMetricType(core.NewNamespace("intel", "baz"), time.Time{}, nil, "", 1),
+		}
+		r = getMetricTypes(full.Timestamp)
+		So(len(r.MetricTypes), ShouldEqual, 1)
+		So(r.MetricTypes[0].Namespace().String(), ShouldEqual, "/intel/baz")
+		So(r.Removed, ShouldResemble, []core.Namespace{core.NewNamespace("intel", "bar")})
+
+		So(r.Full, ShouldBeFalse)
+
+		So(len(getMetricTypes(time.Time{}).MetricTypes), ShouldEqual, 2)
+
+		Convey("returns the full catalog once removals after since are forgotten", func() {
+			ct.maxRemoved = 0
+			clock = clock.Add(time.Minute)
+			pp.mts = pp.mts[:1]
+			r := getMetricTypes(full.Timestamp)
+			So(r.Full, ShouldBeTrue)
+			So(r.Removed, ShouldBeEmpty)
+			So(len(r.MetricTypes), ShouldEqual, 1)
+			So(r.MetricTypes[0].Namespace().String(), ShouldEqual, "/intel/foo")
+
+			r = getMetricTypes(r.Timestamp)
+			So(r.Full, ShouldBeFalse)
+			So(r.MetricTypes, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,254 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sync"
+	"time"
+)
+
+// DefaultChunkSize is the size in bytes above which control asks for
+// replies to be sent in chunks, see Arg.ChunkSize.
+const DefaultChunkSize = 4 << 20
+
+// DefaultChunkTimeout is how long a chunked reply is kept after its last
+// fetch, unless Arg.ChunkTimeout says otherwise or it is acknowledged with
+// AckTransfer.
+const DefaultChunkTimeout = 30 * time.Second
+
+var (
+	// ErrUnknownTransfer is returned for a transfer which was acknowledged,
+	// expired or was never started
+	ErrUnknownTransfer error = &PluginError{Code: ErrorCodeConfigInvalid, Message: "unknown or expired transfer"}
+	// ErrChunkChecksum is returned when a fetched chunk does not match its
+	// checksum
+	ErrChunkChecksum = errors.New("chunk checksum mismatch")
+)
+
+// Transfer replaces a reply larger than Arg.ChunkSize.  The encoded reply
+// is fetched in Chunks chunks with SessionState.GetChunk, and decoded as
+// the reply once reassembled.  The plugin keeps it until it is acknowledged
+// with SessionState.AckTransfer or expires.
+type Transfer struct {
+	ID     string
+	Size   int
+	Chunks int
+}
+
+// GetChunkArgs are the arguments of GetChunk
+type GetChunkArgs struct {
+	Token    string
+	Transfer string
+	// Index is the chunk to fetch.  Chunks are fetched in order from 0.
+	// The last chunk fetched may be fetched again, e.g. when its reply was
+	// lost.
+	Index int
+}
+
+// AckTransferArgs are the arguments of AckTransfer
+type AckTransferArgs struct {
+	Token    string
+	Transfer string
+}
+
+// Chunk is the reply of GetChunk
+type Chunk struct {
+	Index int
+	Data  []byte
+	// Checksum is the CRC-32 (IEEE) of Data
+	Checksum uint32
+	// EOF is set on the last chunk
+	EOF bool
+}
+
+// FetchTransfer reassembles the reply of t from its chunks, fetched in
+// order with get, checking their checksums.
+func FetchTransfer(t *Transfer, get func(GetChunkArgs) (Chunk, error)) ([]byte, error) {
+	out := make([]byte, 0, t.Size)
+	for i := 0; ; i++ {
+		c, err := get(GetChunkArgs{Transfer: t.ID, Index: i})
+		if err != nil {
+			return nil, err
+		}
+		if c.Index != i {
+			return nil, fmt.Errorf("transfer %s: got chunk %d, expected %d", t.ID, c.Index, i)
+		}
+		if crc32.ChecksumIEEE(c.Data) != c.Checksum {
+			return nil, ErrChunkChecksum
+		}
+		out = append(out, c.Data...)
+		if c.EOF {
+			break
+		}
+	}
+	if len(out) != t.Size {
+		return nil, fmt.Errorf("transfer %s: got %d bytes, expected %d", t.ID, len(out), t.Size)
+	}
+	return out, nil
+}
+
+type chunkedReply struct {
+	data    []byte
+	next    int
+	expires time.Time
+}
+
+// chunkStore holds the replies being fetched in chunks.  A reply is dropped
+// when it is acknowledged, or expires when no chunk of it is fetched within
+// timeout.
+type chunkStore struct {
+	size    int
+	timeout time.Duration
+
+	mutex   sync.Mutex
+	replies map[string]*chunkedReply
+	now     func() time.Time
+}
+
+// newChunkStore returns the store of replies chunked in size bytes, or nil
+// when size is not positive.
+func newChunkStore(size int, timeout time.Duration) *chunkStore {
+	if size <= 0 {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = DefaultChunkTimeout
+	}
+	return &chunkStore{
+		size:    size,
+		timeout: timeout,
+		replies: map[string]*chunkedReply{},
+		now:     time.Now,
+	}
+}
+
+// put returns the Transfer of b when it exceeds the chunk size, nil when b
+// is to be sent as is.
+func (c *chunkStore) put(b []byte) (*Transfer, error) {
+	if c == nil || len(b) <= c.size {
+		return nil, nil
+	}
+	id, err := generateToken(MinTokenLength)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.expire()
+	c.replies[id] = &chunkedReply{data: b, expires: c.now().Add(c.timeout)}
+	return &Transfer{ID: id, Size: len(b), Chunks: (len(b) + c.size - 1) / c.size}, nil
+}
+
+// get returns chunk index of transfer id, which must be the next one or the
+// last one returned.
+func (c *chunkStore) get(id string, index int) (Chunk, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.expire()
+	r, ok := c.replies[id]
+	if !ok {
+		return Chunk{}, ErrUnknownTransfer
+	}
+	start := index * c.size
+	if index < r.next-1 || index > r.next || start >= len(r.data) {
+		return Chunk{}, &PluginError{Code: ErrorCodeConfigInvalid, Message: fmt.Sprintf("chunk %d requested, expected chunk %d", index, r.next)}
+	}
+	end := start + c.size
+	if end > len(r.data) {
+		end = len(r.data)
+	}
+	if index == r.next {
+		r.next++
+	}
+	r.expires = c.now().Add(c.timeout)
+	data := r.data[start:end]
+	return Chunk{Index: index, Data: data, Checksum: crc32.ChecksumIEEE(data), EOF: end == len(r.data)}, nil
+}
+
+// ack drops transfer id once its chunks were received.
+func (c *chunkStore) ack(id string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.expire()
+	if _, ok := c.replies[id]; !ok {
+		return ErrUnknownTransfer
+	}
+	delete(c.replies, id)
+	return nil
+}
+
+// pending returns the number of transfers not yet complete or expired.
+func (c *chunkStore) pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.expire()
+	return len(c.replies)
+}
+
+// expire drops the expired replies.  c.mutex must be held.
+func (c *chunkStore) expire() {
+	now := c.now()
+	for id, r := range c.replies {
+		if now.After(r.expires) {
+			delete(c.replies, id)
+		}
+	}
+}
+
+// GetChunk replies with the next Chunk of a reply sent as a Transfer.
+func (s *SessionState) GetChunk(args []byte, reply *[]byte) error {
+	a := &GetChunkArgs{}
+	if err := s.Decode(args, a); err != nil {
+		return err
+	}
+	if err := s.CheckToken(a.Token); err != nil {
+		return err
+	}
+	s.ResetHeartbeat()
+	if s.chunks == nil {
+		return ErrUnknownTransfer
+	}
+	c, err := s.chunks.get(a.Transfer, a.Index)
+	if err != nil {
+		return err
+	}
+	*reply, err = s.Encode(c)
+	return err
+}
+
+// AckTransfer drops a reply sent as a Transfer once control has received
+// all its chunks.
+func (s *SessionState) AckTransfer(args []byte, reply *[]byte) error {
+	a := &AckTransferArgs{}
+	if err := s.Decode(args, a); err != nil {
+		return err
+	}
+	if err := s.CheckToken(a.Token); err != nil {
+		return err
+	}
+	s.ResetHeartbeat()
+	if s.chunks == nil {
+		return ErrUnknownTransfer
+	}
+	return s.chunks.ack(a.Transfer)
+}
@@ -0,0 +1,202 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestChunkStore(t *testing.T) {
+	Convey("A chunk store with a tiny chunk size", t, func() {
+		store := newChunkStore(7, time.Minute)
+		now := time.Now()
+		store.now = func() time.Time { return now }
+		data := make([]byte, 100)
+		rand.New(rand.NewSource(1)).Read(data)
+		get := func(args GetChunkArgs) (Chunk, error) {
+			return store.get(args.Transfer, args.Index)
+		}
+
+		Convey("sends small replies as is", func() {
+			tr, err := store.put(data[:7])
+			So(err, ShouldBeNil)
+			So(tr, ShouldBeNil)
+			So(store.pending(), ShouldEqual, 0)
+		})
+		Convey("reassembles a large reply byte for byte", func() {
+			tr, err := store.put(data)
+			So(err, ShouldBeNil)
+			So(tr.Size, ShouldEqual, 100)
+			So(tr.Chunks, ShouldEqual, 15)
+			out, err := FetchTransfer(tr, get)
+			So(err, ShouldBeNil)
+			So(bytes.Equal(out, data), ShouldBeTrue)
+
+			Convey("and keeps it until it is acknowledged", func() {
+				So(store.pending(), ShouldEqual, 1)
+				c, err := store.get(tr.ID, 14)
+				So(err, ShouldBeNil)
+				So(c.EOF, ShouldBeTrue)
+				So(store.ack(tr.ID), ShouldBeNil)
+				So(store.pending(), ShouldEqual, 0)
+				_, err = store.get(tr.ID, 14)
+				So(err, ShouldEqual, ErrUnknownTransfer)
+				So(store.ack(tr.ID), ShouldEqual, ErrUnknownTransfer)
+			})
+			Convey("and expires it when it is not acknowledged", func() {
+				now = now.Add(time.Minute + time.Second)
+				So(store.pending(), ShouldEqual, 0)
+			})
+		})
+		Convey("serves chunks in order only", func() {
+			tr, err := store.put(data)
+			So(err, ShouldBeNil)
+			_, err = store.get(tr.ID, 1)
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeConfigInvalid)
+			c, err := store.get(tr.ID, 0)
+			So(err, ShouldBeNil)
+			So(c.Data, ShouldResemble, data[:7])
+			So(c.EOF, ShouldBeFalse)
+			_, err = store.get(tr.ID, 15)
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeConfigInvalid)
+		})
+		Convey("serves the last chunk fetched again", func() {
+			tr, err := store.put(data)
+			So(err, ShouldBeNil)
+			for i := 0; i < 3; i++ {
+				_, err = store.get(tr.ID, i)
+				So(err, ShouldBeNil)
+			}
+			c, err := store.get(tr.ID, 2)
+			So(err, ShouldBeNil)
+			So(c.Index, ShouldEqual, 2)
+			So(c.Data, ShouldResemble, data[14:21])
+			_, err = store.get(tr.ID, 1)
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeConfigInvalid)
+			c, err = store.get(tr.ID, 3)
+			So(err, ShouldBeNil)
+			So(c.Data, ShouldResemble, data[21:28])
+		})
+		Convey("detects a corrupt chunk", func() {
+			tr, err := store.put(data)
+			So(err, ShouldBeNil)
+			_, err = FetchTransfer(tr, func(args GetChunkArgs) (Chunk, error) {
+				c, err := get(args)
+				if args.Index == 3 {
+					c.Data = append([]byte{}, c.Data...)
+					c.Data[0] ^= 0xff
+				}
+				return c, err
+			})
+			So(err, ShouldEqual, ErrChunkChecksum)
+		})
+		Convey("expires a transfer whose next chunk is not fetched in time", func() {
+			tr, err := store.put(data)
+			So(err, ShouldBeNil)
+			other, err := store.put(data)
+			So(err, ShouldBeNil)
+			now = now.Add(50 * time.Second)
+			_, err = store.get(tr.ID, 0)
+			So(err, ShouldBeNil)
+			So(store.pending(), ShouldEqual, 2)
+
+			now = now.Add(20 * time.Second)
+			So(store.pending(), ShouldEqual, 1)
+			_, err = store.get(other.ID, 0)
+			So(err, ShouldEqual, ErrUnknownTransfer)
+			_, err = store.get(tr.ID, 1)
+			So(err, ShouldBeNil)
+
+			now = now.Add(time.Minute + time.Second)
+			So(store.pending(), ShouldEqual, 0)
+			_, err = store.get(tr.ID, 2)
+			So(err, ShouldEqual, ErrUnknownTransfer)
+		})
+	})
+	Convey("A chunk store is not created without a chunk size", t, func() {
+		So(newChunkStore(0, 0), ShouldBeNil)
+		var store *chunkStore
+		tr, err := store.put([]byte("snap"))
+		So(err, ShouldBeNil)
+		So(tr, ShouldBeNil)
+	})
+}
+
+func TestChunkedCollect(t *testing.T) {
+	Convey("A collector with a tiny chunk size", t, func() {
+		m := NewPluginMeta("chunks", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		resp, done := startTestPlugin(m, &mockPlugin{}, fmt.Sprintf(`{"ChunkSize": 64, "PingTimeoutDuration": %d}`, time.Minute))
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer client.Close()
+		enc := encoding.NewGobEncoder()
+		call := func(method string, args, reply interface{}) error {
+			in, err := enc.Encode(args)
+			So(err, ShouldBeNil)
+			var out []byte
+			if err := client.Call(method, in, &out); err != nil {
+				return err
+			}
+			return enc.Decode(out, reply)
+		}
+
+		var r CollectMetricsReply
+		So(call("Collector.CollectMetrics", CollectMetricsArgs{MetricTypes: mockMetricType, Token: resp.Token}, &r), ShouldBeNil)
+		So(r.Transfer, ShouldNotBeNil)
+		So(r.PluginMetrics, ShouldBeEmpty)
+		So(r.Transfer.Chunks, ShouldBeGreaterThan, 1)
+
+		b, err := FetchTransfer(r.Transfer, func(args GetChunkArgs) (Chunk, error) {
+			args.Token = resp.Token
+			var c Chunk
+			err := call("SessionState.GetChunk", args, &c)
+			return c, err
+		})
+		So(err, ShouldBeNil)
+		So(len(b), ShouldEqual, r.Transfer.Size)
+		var full CollectMetricsReply
+		So(enc.Decode(b, &full), ShouldBeNil)
+		So(full.PluginMetrics, ShouldHaveLength, len(mockMetricType))
+		So(full.PluginMetrics[1].Namespace().String(), ShouldEqual, "/foo/baz")
+
+		err = call("SessionState.GetChunk", GetChunkArgs{Token: resp.Token, Transfer: r.Transfer.ID}, &Chunk{})
+		So(ErrorCodeOf(err), ShouldEqual, ErrorCodeConfigInvalid)
+		err = call("SessionState.GetChunk", GetChunkArgs{Token: "bad", Transfer: r.Transfer.ID}, &Chunk{})
+		So(ErrorCodeOf(err), ShouldEqual, ErrorCodeUnauthorized)
+		in, err := enc.Encode(AckTransferArgs{Token: resp.Token, Transfer: r.Transfer.ID})
+		So(err, ShouldBeNil)
+		So(client.Call("SessionState.AckTransfer", in, &[]byte{}), ShouldBeNil)
+		err = call("SessionState.GetChunk", GetChunkArgs{Token: resp.Token, Transfer: r.Transfer.ID, Index: r.Transfer.Chunks - 1}, &Chunk{})
+		So(err, ShouldEqual, rpc.ServerError(ErrUnknownTransfer.Error()))
+
+		So(callKill(client, resp.Token), ShouldBeNil)
+		<-done
+	})
+}
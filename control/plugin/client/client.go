@@ -20,8 +20,12 @@ limitations under the License.
 package client
 
 import (
+	"errors"
+	"time"
+
 	"github.com/intelsdi-x/snap/control/plugin"
 	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/control/plugin/encrypter"
 	"github.com/intelsdi-x/snap/core"
 	"github.com/intelsdi-x/snap/core/ctypes"
 )
@@ -34,6 +38,84 @@ type PluginClient interface {
 	GetConfigPolicy() (*cpolicy.ConfigPolicy, error)
 }
 
+// HealthChecker is implemented by clients which can ask a plugin for its
+// health in place of a plain Ping.
+type HealthChecker interface {
+	PingStatus() (plugin.PingReply, error)
+}
+
+// StatsGetter is implemented by clients which can fetch a plugin's
+// session statistics.
+type StatsGetter interface {
+	GetStats() (plugin.SessionStats, error)
+}
+
+// TokenSetter is implemented by clients which authenticate their calls with
+// the session token from the plugin's Response.
+type TokenSetter interface {
+	SetToken(string)
+}
+
+// RPCVersionSetter is implemented by clients which skip the methods a
+// plugin does not serve, given the RPCVersion from its Response.
+type RPCVersionSetter interface {
+	SetRPCVersion(int)
+}
+
+// ContentTypeSetter is implemented by clients which send metrics in the
+// content type negotiated in the plugin's Response rather than snap.gob.
+type ContentTypeSetter interface {
+	SetContentType(string)
+}
+
+// ContentEncodingSetter is implemented by clients which compress large
+// payloads with an encoding the plugin decompresses.
+type ContentEncodingSetter interface {
+	SetContentEncoding(string)
+}
+
+// Subscriber is implemented by clients which tell a collector when the
+// metrics it serves are subscribed and unsubscribed by tasks.
+type Subscriber interface {
+	SubscribeMetrics([]core.Metric) error
+	UnsubscribeMetrics([]core.Metric) error
+}
+
+// ConfigSetter is implemented by clients which replace the config of a
+// running plugin.  A rejected config is reported in the reply, not as an
+// error.
+type ConfigSetter interface {
+	SetConfig(map[string]ctypes.ConfigValue) (plugin.SetConfigReply, error)
+}
+
+// SelfTester is implemented by clients which ask a plugin to check its own
+// dependencies, e.g. right after loading it.  The outcome of the test is
+// in the reply; the error is that of the call.
+type SelfTester interface {
+	SelfTest(map[string]ctypes.ConfigValue) (plugin.SelfTestReply, error)
+}
+
+// Suspender is implemented by clients which pause a plugin without ending
+// its session.
+type Suspender interface {
+	Suspend(reason string) error
+	Resume() error
+}
+
+// ErrUnsupportedMethod is returned, without calling the plugin, for a
+// method its RPC version does not serve.
+var ErrUnsupportedMethod = errors.New("method is not supported by the plugin's RPC version")
+
+// checkMethod returns ErrUnsupportedMethod when a plugin speaking version
+// does not serve method.  A version of 0 means SetRPCVersion was not
+// called, and every method is tried.
+func checkMethod(version int, method string) error {
+	if version != 0 && !plugin.SupportsMethod(version, method) {
+		return ErrUnsupportedMethod
+	}
+	return nil
+}
+
 // PluginCollectorClient A client providing collector specific plugin method calls.
 type PluginCollectorClient interface {
 	PluginClient
@@ -47,8 +129,118 @@ type PluginProcessorClient interface {
 	Process([]core.Metric, map[string]ctypes.ConfigValue) ([]core.Metric, error)
 }
 
+// PipelineProcessor is implemented by processor clients which pass the
+// position of the processor in the workflow to the plugin, see
+// plugin.PipelineInfo.
+type PipelineProcessor interface {
+	ProcessPipeline([]core.Metric, map[string]ctypes.ConfigValue, plugin.PipelineInfo) ([]core.Metric, error)
+}
+
 // PluginPublisherClient A client providing publishing specific plugin method calls.
 type PluginPublisherClient interface {
 	PluginClient
 	Publish([]core.Metric, map[string]ctypes.ConfigValue) error
 }
+
+// BackpressurePublisher is implemented by publisher clients which return
+// the backpressure a plugin advises in its Publish reply, so that control
+// can throttle the publishing.  The backpressure is nil when the plugin
+// advises none.
+type BackpressurePublisher interface {
+	PublishBackpressure([]core.Metric, map[string]ctypes.ConfigValue) (*plugin.Backpressure, error)
+}
+
+// AckPublisher is implemented by publisher clients which follow when the
+// metrics published are persisted.  A reply with the AckDeferred mode is
+// resolved later, and its BatchID polled with PublishStatus.
+type AckPublisher interface {
+	PublishAck([]core.Metric, map[string]ctypes.ConfigValue) (plugin.PublishReply, error)
+	PublishStatus(batchIDs []string) (map[string]plugin.BatchStatus, error)
+}
+
+// PluginStreamCollectorClient A client draining the metrics of a stream
+// collector.
+type PluginStreamCollectorClient interface {
+	PluginClient
+	GetMetricTypes(plugin.ConfigType) ([]core.Metric, error)
+	// DrainStream returns up to max streamed metrics, waiting up to wait
+	// when none are buffered.
+	DrainStream(max int, wait time.Duration) ([]core.Metric, *plugin.DrainStreamReply, error)
+}
+
+// catalogPageSize is the number of metrics requested per GetMetricTypes call.
+const catalogPageSize = 1000
+
+// ErrCatalogPaging is returned when a plugin asks for another catalog page
+// without advancing its continuation token.
+var ErrCatalogPaging = errors.New("plugin returned an invalid catalog continuation token")
+
+// getMetricTypePages calls fetch until the plugin reports no further pages and
+// returns the concatenated catalog.  Plugins which do not support paging
+// return the whole catalog in the first reply.
+func getMetricTypePages(args plugin.GetMetricTypesArgs, fetch func(plugin.GetMetricTypesArgs) (*plugin.GetMetricTypesReply, error)) ([]plugin.MetricType, error) {
+	if args.Limit == 0 {
+		args.Limit = catalogPageSize
+	}
+	// All pages are requested under the same request ID
+	args.RequestID = requestID(args.RequestID)
+	var mts []plugin.MetricType
+	for {
+		r, err := fetch(args)
+		if err != nil {
+			return nil, err
+		}
+		mts = append(mts, r.MetricTypes...)
+		if !r.More {
+			return mts, nil
+		}
+		if r.Continue <= args.Continue {
+			return nil, ErrCatalogPaging
+		}
+		args.Continue = r.Continue
+	}
+}
+
+// requestID returns id, or a new request ID when the call has none.
+func requestID(id string) string {
+	if id != "" {
+		return id
+	}
+	return plugin.NewRequestID()
+}
+
+// callDeadline returns the deadline of a call made with the client timeout,
+// after which the plugin gives up on it.  There is none without a timeout.
+func callDeadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// subscriptionArgs returns the arguments of a subscription to mts, their
+// config sealed with the session key.
+func subscriptionArgs(mts []core.Metric, e *encrypter.Encrypter, token string) (plugin.SubscribeMetricsArgs, error) {
+	args := plugin.SubscribeMetricsArgs{MetricTypes: make([]plugin.MetricType, len(mts)), Token: token}
+	for i, mt := range mts {
+		cfg, err := plugin.SealConfigNode(mt.Config(), sessionEncrypter(e))
+		if err != nil {
+			return args, err
+		}
+		args.MetricTypes[i] = plugin.MetricType{
+			Namespace_: mt.Namespace(),
+			Version_:   mt.Version(),
+			Config_:    cfg,
+		}
+	}
+	return args, nil
+}
+
+// sessionEncrypter returns the session key used to seal secure config values,
+// or nil when the session is not encrypted.
+func sessionEncrypter(e *encrypter.Encrypter) ctypes.Encrypter {
+	if e == nil {
+		return nil
+	}
+	return e
+}
@@ -28,6 +28,7 @@ import (
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	"golang.org/x/net/context"
 
@@ -38,7 +39,6 @@ import (
 	"github.com/intelsdi-x/snap/control/plugin/encrypter"
 	"github.com/intelsdi-x/snap/control/plugin/rpc"
 	"github.com/intelsdi-x/snap/core"
-	"github.com/intelsdi-x/snap/core/cdata"
 	"github.com/intelsdi-x/snap/core/ctypes"
 	"github.com/intelsdi-x/snap/pkg/rpcutil"
 )
@@ -59,6 +59,7 @@ type grpcClient struct {
 	timeout    time.Duration
 	conn       *grpc.ClientConn
 	encrypter  *encrypter.Encrypter
+	token      string
 }
 
 // NewCollectorGrpcClient returns a collector gRPC Client.
@@ -161,13 +162,20 @@ func newGrpcClient(addr string, port int, timeout time.Duration, typ plugin.Plug
 	return p, nil
 }
 
-func getContext(timeout time.Duration) context.Context {
-	ctxTimeout, _ := context.WithTimeout(context.Background(), timeout)
-	return ctxTimeout
+// SetToken sets the session token sent with each call to the plugin.
+func (g *grpcClient) SetToken(token string) {
+	g.token = token
+}
+
+// context returns the context for a call to the plugin, carrying the
+// session token, a new request ID and the client timeout.
+func (g *grpcClient) context() context.Context {
+	ctxTimeout, _ := context.WithTimeout(context.Background(), g.timeout)
+	return metadata.NewContext(ctxTimeout, metadata.Pairs(plugin.GRPCTokenKey, g.token, plugin.GRPCRequestIDKey, requestID("")))
 }
 
 func (g *grpcClient) Ping() error {
-	_, err := g.plugin.Ping(getContext(g.timeout), &rpc.Empty{})
+	_, err := g.plugin.Ping(g.context(), &rpc.Empty{})
 	if err != nil {
 		return err
 	}
@@ -180,7 +188,7 @@ func (g *grpcClient) SetKey() error {
 }
 
 func (g *grpcClient) Kill(reason string) error {
-	_, err := g.plugin.Kill(getContext(g.timeout), &rpc.KillArg{Reason: reason})
+	_, err := g.plugin.Kill(g.context(), &rpc.KillArg{Reason: reason})
 	g.conn.Close()
 	if err != nil {
 		return err
@@ -189,11 +197,11 @@ func (g *grpcClient) Kill(reason string) error {
 }
 
 func (g *grpcClient) Publish(metrics []core.Metric, config map[string]ctypes.ConfigValue) error {
-	arg := &rpc.PubProcArg{
-		Metrics: NewMetrics(metrics),
-		Config:  ToConfigMap(config),
+	arg, err := newPubProcArg(metrics, config)
+	if err != nil {
+		return err
 	}
-	_, err := g.publisher.Publish(getContext(g.timeout), arg)
+	_, err = g.publisher.Publish(g.context(), arg)
 	if err != nil {
 		return err
 	}
@@ -201,11 +209,11 @@ func (g *grpcClient) Publish(metrics []core.Metric, config map[string]ctypes.Con
 }
 
 func (g *grpcClient) Process(metrics []core.Metric, config map[string]ctypes.ConfigValue) ([]core.Metric, error) {
-	arg := &rpc.PubProcArg{
-		Metrics: NewMetrics(metrics),
-		Config:  ToConfigMap(config),
+	arg, err := newPubProcArg(metrics, config)
+	if err != nil {
+		return nil, err
 	}
-	reply, err := g.processor.Process(getContext(g.timeout), arg)
+	reply, err := g.processor.Process(g.context(), arg)
 
 	if err != nil {
 		return nil, err
@@ -213,7 +221,7 @@ func (g *grpcClient) Process(metrics []core.Metric, config map[string]ctypes.Con
 	if reply.Error != "" {
 		return nil, errors.New(reply.Error)
 	}
-	mts := ToCoreMetrics(reply.Metrics)
+	mts := rpc.ToCoreMetrics(reply.Metrics)
 	for _, mt := range mts {
 		log.Debug(mt.Namespace())
 	}
@@ -221,10 +229,11 @@ func (g *grpcClient) Process(metrics []core.Metric, config map[string]ctypes.Con
 }
 
 func (g *grpcClient) CollectMetrics(mts []core.Metric) ([]core.Metric, error) {
-	arg := &rpc.MetricsArg{
-		Metrics: NewMetrics(mts),
+	ms, err := rpc.NewMetrics(mts)
+	if err != nil {
+		return nil, err
 	}
-	reply, err := g.collector.CollectMetrics(getContext(g.timeout), arg)
+	reply, err := g.collector.CollectMetrics(g.context(), &rpc.MetricsArg{Metrics: ms})
 
 	if err != nil {
 		return nil, err
@@ -234,15 +243,16 @@ func (g *grpcClient) CollectMetrics(mts []core.Metric) ([]core.Metric, error) {
 		return nil, errors.New(reply.Error)
 	}
 
-	metrics := ToCoreMetrics(reply.Metrics)
+	metrics := rpc.ToCoreMetrics(reply.Metrics)
 	return metrics, nil
 }
 
 func (g *grpcClient) GetMetricTypes(config plugin.ConfigType) ([]core.Metric, error) {
-	arg := &rpc.GetMetricTypesArg{
-		Config: ToConfigMap(config.Table()),
+	cm, err := rpc.ToConfigMap(config.Table())
+	if err != nil {
+		return nil, err
 	}
-	reply, err := g.collector.GetMetricTypes(getContext(g.timeout), arg)
+	reply, err := g.collector.GetMetricTypes(g.context(), &rpc.GetMetricTypesArg{Config: cm})
 
 	if err != nil {
 		return nil, err
@@ -253,15 +263,15 @@ func (g *grpcClient) GetMetricTypes(config plugin.ConfigType) ([]core.Metric, er
 	}
 
 	for _, metric := range reply.Metrics {
-		metric.LastAdvertisedTime = ToTime(time.Now())
+		metric.LastAdvertisedTime = rpc.ToTime(time.Now())
 	}
 
-	results := ToCoreMetrics(reply.Metrics)
+	results := rpc.ToCoreMetrics(reply.Metrics)
 	return results, nil
 }
 
 func (g *grpcClient) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
-	reply, err := g.plugin.GetConfigPolicy(getContext(g.timeout), &rpc.Empty{})
+	reply, err := g.plugin.GetConfigPolicy(g.context(), &rpc.Empty{})
 
 	if err != nil {
 		return nil, err
@@ -274,205 +284,16 @@ func (g *grpcClient) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
 	return rpc.ToConfigPolicy(reply), nil
 }
 
-type metric struct {
-	namespace          core.Namespace
-	version            int
-	config             *cdata.ConfigDataNode
-	lastAdvertisedTime time.Time
-	timeStamp          time.Time
-	data               interface{}
-	tags               map[string]string
-	description        string
-	unit               string
-}
-
-func (m *metric) Namespace() core.Namespace     { return m.namespace }
-func (m *metric) Config() *cdata.ConfigDataNode { return m.config }
-func (m *metric) Version() int                  { return m.version }
-func (m *metric) Data() interface{}             { return m.data }
-func (m *metric) Tags() map[string]string       { return m.tags }
-func (m *metric) LastAdvertisedTime() time.Time { return m.lastAdvertisedTime }
-func (m *metric) Timestamp() time.Time          { return m.timeStamp }
-func (m *metric) Description() string           { return m.description }
-func (m *metric) Unit() string                  { return m.unit }
-
-func ToCoreMetrics(mts []*rpc.Metric) []core.Metric {
-	metrics := make([]core.Metric, len(mts))
-	for i, mt := range mts {
-		metrics[i] = ToCoreMetric(mt)
-	}
-	return metrics
-}
-
-func ToCoreMetric(mt *rpc.Metric) core.Metric {
-	ret := &metric{
-		namespace:          ToCoreNamespace(mt.Namespace),
-		version:            int(mt.Version),
-		tags:               mt.Tags,
-		timeStamp:          time.Unix(mt.Timestamp.Sec, mt.Timestamp.Nsec),
-		lastAdvertisedTime: time.Unix(mt.LastAdvertisedTime.Sec, mt.LastAdvertisedTime.Nsec),
-		config:             ConfigMapToConfig(mt.Config),
-		description:        mt.Description,
-		unit:               mt.Unit,
-	}
-
-	switch mt.Data.(type) {
-	case *rpc.Metric_BytesData:
-		ret.data = mt.GetBytesData()
-	case *rpc.Metric_StringData:
-		ret.data = mt.GetStringData()
-	case *rpc.Metric_Float32Data:
-		ret.data = mt.GetFloat32Data()
-	case *rpc.Metric_Float64Data:
-		ret.data = mt.GetFloat64Data()
-	case *rpc.Metric_Int32Data:
-		ret.data = mt.GetInt32Data()
-	case *rpc.Metric_Int64Data:
-		ret.data = mt.GetInt64Data()
-	case *rpc.Metric_BoolData:
-		ret.data = mt.GetBoolData()
-	}
-
-	return ret
-}
-
-func NewMetrics(ms []core.Metric) []*rpc.Metric {
-	metrics := make([]*rpc.Metric, len(ms))
-	for i, m := range ms {
-		metrics[i] = ToMetric(m)
-	}
-	return metrics
-}
-
-func ToMetric(co core.Metric) *rpc.Metric {
-	cm := &rpc.Metric{
-		Namespace: ToNamespace(co.Namespace()),
-		Version:   int64(co.Version()),
-		Tags:      co.Tags(),
-		Timestamp: &rpc.Time{
-			Sec:  co.Timestamp().Unix(),
-			Nsec: int64(co.Timestamp().Nanosecond()),
-		},
-		LastAdvertisedTime: &rpc.Time{
-			Sec:  co.LastAdvertisedTime().Unix(),
-			Nsec: int64(co.Timestamp().Nanosecond()),
-		},
-	}
-	if co.Config() != nil {
-		cm.Config = ConfigToConfigMap(co.Config())
-	}
-	switch t := co.Data().(type) {
-	case string:
-		cm.Data = &rpc.Metric_StringData{t}
-	case float64:
-		cm.Data = &rpc.Metric_Float64Data{t}
-	case float32:
-		cm.Data = &rpc.Metric_Float32Data{t}
-	case int32:
-		cm.Data = &rpc.Metric_Int32Data{t}
-	case int:
-		cm.Data = &rpc.Metric_Int64Data{int64(t)}
-	case int64:
-		cm.Data = &rpc.Metric_Int64Data{t}
-	case []byte:
-		cm.Data = &rpc.Metric_BytesData{t}
-	case bool:
-		cm.Data = &rpc.Metric_BoolData{t}
-	case nil:
-		cm.Data = nil
-	default:
-		log.Error(fmt.Sprintf("unsupported type: %s", t))
-	}
-	return cm
-}
-
-func ToCoreNamespace(n []*rpc.NamespaceElement) core.Namespace {
-	var namespace core.Namespace
-	for _, val := range n {
-		ele := core.NamespaceElement{
-			Value:       val.Value,
-			Description: val.Description,
-			Name:        val.Name,
-		}
-		namespace = append(namespace, ele)
-	}
-	return namespace
-}
-
-func ConfigMapToConfig(cfg *rpc.ConfigMap) *cdata.ConfigDataNode {
-	if cfg == nil {
-		return nil
-	}
-	config := cdata.FromTable(ParseConfig(cfg))
-	return config
-}
-
-func ToConfigMap(cv map[string]ctypes.ConfigValue) *rpc.ConfigMap {
-	newConfig := &rpc.ConfigMap{
-		IntMap:    make(map[string]int64),
-		FloatMap:  make(map[string]float64),
-		StringMap: make(map[string]string),
-		BoolMap:   make(map[string]bool),
-	}
-	for k, v := range cv {
-		switch v.Type() {
-		case "integer":
-			newConfig.IntMap[k] = int64(v.(ctypes.ConfigValueInt).Value)
-		case "float":
-			newConfig.FloatMap[k] = v.(ctypes.ConfigValueFloat).Value
-		case "string":
-			newConfig.StringMap[k] = v.(ctypes.ConfigValueStr).Value
-		case "bool":
-			newConfig.BoolMap[k] = v.(ctypes.ConfigValueBool).Value
-		}
-	}
-	return newConfig
-}
-
-func ToNamespace(n core.Namespace) []*rpc.NamespaceElement {
-	elements := make([]*rpc.NamespaceElement, 0, len(n))
-	for _, value := range n {
-		ne := &rpc.NamespaceElement{
-			Value:       value.Value,
-			Description: value.Description,
-			Name:        value.Name,
-		}
-		elements = append(elements, ne)
-	}
-	return elements
-}
-
-func ConfigToConfigMap(cd *cdata.ConfigDataNode) *rpc.ConfigMap {
-	if cd == nil {
-		return nil
-	}
-	return ToConfigMap(cd.Table())
-}
-
-func ParseConfig(config *rpc.ConfigMap) map[string]ctypes.ConfigValue {
-	c := make(map[string]ctypes.ConfigValue)
-	for k, v := range config.IntMap {
-		ival := ctypes.ConfigValueInt{Value: int(v)}
-		c[k] = ival
-	}
-	for k, v := range config.FloatMap {
-		fval := ctypes.ConfigValueFloat{Value: v}
-		c[k] = fval
-	}
-	for k, v := range config.StringMap {
-		sval := ctypes.ConfigValueStr{Value: v}
-		c[k] = sval
-	}
-	for k, v := range config.BoolMap {
-		bval := ctypes.ConfigValueBool{Value: v}
-		c[k] = bval
+// newPubProcArg returns the args of Publish and Process.  Secure strings in
+// config must be sealed, the gRPC session having no key to seal them with.
+func newPubProcArg(metrics []core.Metric, config map[string]ctypes.ConfigValue) (*rpc.PubProcArg, error) {
+	ms, err := rpc.NewMetrics(metrics)
+	if err != nil {
+		return nil, err
 	}
-	return c
-}
-
-func ToTime(t time.Time) *rpc.Time {
-	return &rpc.Time{
-		Nsec: t.Unix(),
-		Sec:  int64(t.Second()),
+	cm, err := rpc.ToConfigMap(config)
+	if err != nil {
+		return nil, err
 	}
+	return &rpc.PubProcArg{Metrics: ms, Config: cm}, nil
 }
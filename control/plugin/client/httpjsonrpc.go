@@ -43,12 +43,16 @@ import (
 var logger = log.WithField("_module", "client-httpjsonrpc")
 
 type httpJSONRPCClient struct {
-	url        string
-	id         uint64
-	timeout    time.Duration
-	pluginType plugin.PluginType
-	encrypter  *encrypter.Encrypter
-	encoder    encoding.Encoder
+	url             string
+	id              uint64
+	timeout         time.Duration
+	pluginType      plugin.PluginType
+	encrypter       *encrypter.Encrypter
+	encoder         encoding.Encoder
+	token           string
+	rpcVersion      int
+	contentType     string
+	contentEncoding string
 }
 
 // NewCollectorHttpJSONRPCClient returns CollectorHttpJSONRPCClient
@@ -112,25 +116,244 @@ func NewPublisherHttpJSONRPCClient(u string, timeout time.Duration, pub *rsa.Pub
 	return hjr, nil
 }
 
+// SetToken sets the session token sent with each call to the plugin
+func (h *httpJSONRPCClient) SetToken(token string) {
+	h.token = token
+}
+
+// SetRPCVersion sets the RPC version spoken by the plugin.  Methods it does
+// not serve then fail with ErrUnsupportedMethod.
+func (h *httpJSONRPCClient) SetRPCVersion(version int) {
+	if version == 0 {
+		// The plugin predates versioning
+		version = 1
+	}
+	h.rpcVersion = version
+}
+
+// SetContentType sets the content type of the metrics sent to the plugin,
+// as negotiated in its Response.
+func (h *httpJSONRPCClient) SetContentType(contentType string) {
+	h.contentType = contentType
+}
+
+// SetContentEncoding sets the encoding large payloads sent to the plugin are
+// compressed with, as selected from its Response.
+func (h *httpJSONRPCClient) SetContentEncoding(encoding string) {
+	h.contentEncoding = encoding
+}
+
 // Ping
 func (h *httpJSONRPCClient) Ping() error {
-	_, err := h.call("SessionState.Ping", []interface{}{})
+	out, err := h.encoder.Encode(plugin.PingArgs{Token: h.token})
+	if err != nil {
+		return err
+	}
+	_, err = h.call("SessionState.Ping", []interface{}{out})
 	return err
 }
 
+// PingStatus pings the plugin and returns its health
+func (h *httpJSONRPCClient) PingStatus() (plugin.PingReply, error) {
+	if err := checkMethod(h.rpcVersion, "SessionState.PingStatus"); err != nil {
+		return plugin.PingReply{}, err
+	}
+	out, err := h.encoder.Encode(plugin.PingArgs{Token: h.token})
+	if err != nil {
+		return plugin.PingReply{}, err
+	}
+	res, err := h.call("SessionState.PingStatus", []interface{}{out})
+	if err != nil {
+		return plugin.PingReply{}, err
+	}
+	if len(res.Result) == 0 {
+		return plugin.PingReply{}, errors.New(res.Error)
+	}
+	var r plugin.PingReply
+	err = h.encoder.Decode(res.Result, &r)
+	return r, err
+}
+
+// GetStats returns the plugin's session statistics
+func (h *httpJSONRPCClient) GetStats() (plugin.SessionStats, error) {
+	if err := checkMethod(h.rpcVersion, "SessionState.GetStats"); err != nil {
+		return plugin.SessionStats{}, err
+	}
+	out, err := h.encoder.Encode(plugin.StatsArgs{Token: h.token})
+	if err != nil {
+		return plugin.SessionStats{}, err
+	}
+	res, err := h.call("SessionState.GetStats", []interface{}{out})
+	if err != nil {
+		return plugin.SessionStats{}, err
+	}
+	if len(res.Result) == 0 {
+		return plugin.SessionStats{}, errors.New(res.Error)
+	}
+	var st plugin.SessionStats
+	err = h.encoder.Decode(res.Result, &st)
+	return st, err
+}
+
+// ackTransfer lets the plugin drop a transfer whose chunks were received.
+// A plugin which does not serve AckTransfer expires it instead.
+func (h *httpJSONRPCClient) ackTransfer(id string) {
+	if checkMethod(h.rpcVersion, "SessionState.AckTransfer") != nil {
+		return
+	}
+	out, err := h.encoder.Encode(plugin.AckTransferArgs{Token: h.token, Transfer: id})
+	if err != nil {
+		return
+	}
+	h.call("SessionState.AckTransfer", []interface{}{out})
+}
+
+// getChunk fetches a chunk of a reply sent as a plugin.Transfer.
+func (h *httpJSONRPCClient) getChunk(args plugin.GetChunkArgs) (plugin.Chunk, error) {
+	if err := checkMethod(h.rpcVersion, "SessionState.GetChunk"); err != nil {
+		return plugin.Chunk{}, err
+	}
+	args.Token = h.token
+	out, err := h.encoder.Encode(args)
+	if err != nil {
+		return plugin.Chunk{}, err
+	}
+	res, err := h.call("SessionState.GetChunk", []interface{}{out})
+	if err != nil {
+		return plugin.Chunk{}, err
+	}
+	if len(res.Result) == 0 {
+		return plugin.Chunk{}, errors.New(res.Error)
+	}
+	var c plugin.Chunk
+	err = h.encoder.Decode(res.Result, &c)
+	return c, err
+}
+
+// Suspend makes the plugin fail collect, publish and process calls until
+// Resume is called.
+func (h *httpJSONRPCClient) Suspend(reason string) error {
+	return h.suspension("SessionState.Suspend", plugin.SuspendArgs{Reason: reason, Token: h.token})
+}
+
+// Resume ends a suspension of the plugin.
+func (h *httpJSONRPCClient) Resume() error {
+	return h.suspension("SessionState.Resume", plugin.ResumeArgs{Token: h.token})
+}
+
+func (h *httpJSONRPCClient) suspension(method string, args interface{}) error {
+	if err := checkMethod(h.rpcVersion, method); err != nil {
+		return err
+	}
+	out, err := h.encoder.Encode(args)
+	if err != nil {
+		return err
+	}
+	res, err := h.call(method, []interface{}{out})
+	if err != nil {
+		return err
+	}
+	if len(res.Result) == 0 {
+		return errors.New(res.Error)
+	}
+	return nil
+}
+
+// SetConfig replaces the config of the plugin.
+func (h *httpJSONRPCClient) SetConfig(config map[string]ctypes.ConfigValue) (plugin.SetConfigReply, error) {
+	if err := checkMethod(h.rpcVersion, "SessionState.SetConfig"); err != nil {
+		return plugin.SetConfigReply{}, err
+	}
+	sealed, err := plugin.SealConfig(config, sessionEncrypter(h.encrypter))
+	if err != nil {
+		return plugin.SetConfigReply{}, err
+	}
+	out, err := h.encoder.Encode(plugin.SetConfigArgs{Config: sealed, Token: h.token})
+	if err != nil {
+		return plugin.SetConfigReply{}, err
+	}
+	res, err := h.call("SessionState.SetConfig", []interface{}{out})
+	if err != nil {
+		return plugin.SetConfigReply{}, err
+	}
+	if len(res.Result) == 0 {
+		return plugin.SetConfigReply{}, errors.New(res.Error)
+	}
+	var r plugin.SetConfigReply
+	err = h.encoder.Decode(res.Result, &r)
+	return r, err
+}
+
+// SelfTest runs the self-test of the plugin with config completing its own.
+func (h *httpJSONRPCClient) SelfTest(config map[string]ctypes.ConfigValue) (plugin.SelfTestReply, error) {
+	if err := checkMethod(h.rpcVersion, "SessionState.SelfTest"); err != nil {
+		return plugin.SelfTestReply{}, err
+	}
+	sealed, err := plugin.SealConfig(config, sessionEncrypter(h.encrypter))
+	if err != nil {
+		return plugin.SelfTestReply{}, err
+	}
+	out, err := h.encoder.Encode(plugin.SelfTestArgs{Config: sealed, Token: h.token})
+	if err != nil {
+		return plugin.SelfTestReply{}, err
+	}
+	res, err := h.call("SessionState.SelfTest", []interface{}{out})
+	if err != nil {
+		return plugin.SelfTestReply{}, err
+	}
+	if len(res.Result) == 0 {
+		return plugin.SelfTestReply{}, errors.New(res.Error)
+	}
+	var r plugin.SelfTestReply
+	err = h.encoder.Decode(res.Result, &r)
+	return r, err
+}
+
+// SubscribeMetrics tells the collector that a task subscribed to mts.
+func (h *httpJSONRPCClient) SubscribeMetrics(mts []core.Metric) error {
+	return h.subscription("Collector.SubscribeMetrics", mts)
+}
+
+// UnsubscribeMetrics tells the collector that a task unsubscribed from mts.
+func (h *httpJSONRPCClient) UnsubscribeMetrics(mts []core.Metric) error {
+	return h.subscription("Collector.UnsubscribeMetrics", mts)
+}
+
+func (h *httpJSONRPCClient) subscription(method string, mts []core.Metric) error {
+	if err := checkMethod(h.rpcVersion, method); err != nil {
+		return err
+	}
+	args, err := subscriptionArgs(mts, h.encrypter, h.token)
+	if err != nil {
+		return err
+	}
+	out, err := h.encoder.Encode(args)
+	if err != nil {
+		return err
+	}
+	res, err := h.call(method, []interface{}{out})
+	if err != nil {
+		return err
+	}
+	if len(res.Result) == 0 {
+		return errors.New(res.Error)
+	}
+	return nil
+}
+
 func (h *httpJSONRPCClient) SetKey() error {
 	key, err := h.encrypter.EncryptKey()
 	if err != nil {
 		return err
 	}
-	a := plugin.SetKeyArgs{Key: key}
+	a := plugin.SetKeyArgs{Key: key, Token: h.token}
 	_, err = h.call("SessionState.SetKey", []interface{}{a})
 	return err
 }
 
 // kill
 func (h *httpJSONRPCClient) Kill(reason string) error {
-	args := plugin.KillArgs{Reason: reason}
+	args := plugin.KillArgs{Reason: reason, ReasonCode: plugin.ParseKillReason(reason), Token: h.token}
 	out, err := h.encoder.Encode(args)
 	if err != nil {
 		return err
@@ -149,16 +372,25 @@ func (h *httpJSONRPCClient) CollectMetrics(mts []core.Metric) ([]core.Metric, er
 
 	metricsToCollect := make([]plugin.MetricType, len(mts))
 	for idx, mt := range mts {
+		cfg, err := plugin.SealConfigNode(mt.Config(), sessionEncrypter(h.encrypter))
+		if err != nil {
+			return nil, err
+		}
 		metricsToCollect[idx] = plugin.MetricType{
 			Namespace_:          mt.Namespace(),
 			LastAdvertisedTime_: mt.LastAdvertisedTime(),
 			Version_:            mt.Version(),
 			Tags_:               mt.Tags(),
-			Config_:             mt.Config(),
+			Config_:             cfg,
 		}
 	}
 
-	args := &plugin.CollectMetricsArgs{MetricTypes: metricsToCollect}
+	args := &plugin.CollectMetricsArgs{
+		MetricTypes: metricsToCollect,
+		Token:       h.token,
+		RequestID:   requestID(""),
+		Deadline:    callDeadline(h.timeout),
+	}
 
 	out, err := h.encoder.Encode(args)
 	if err != nil {
@@ -182,10 +414,25 @@ func (h *httpJSONRPCClient) CollectMetrics(mts []core.Metric) ([]core.Metric, er
 	if err != nil {
 		return nil, err
 	}
+	if r.Transfer != nil {
+		b, err := plugin.FetchTransfer(r.Transfer, h.getChunk)
+		if err != nil {
+			return nil, err
+		}
+		h.ackTransfer(r.Transfer.ID)
+		r = &plugin.CollectMetricsReply{}
+		if err := h.encoder.Decode(b, r); err != nil {
+			return nil, err
+		}
+	}
 
-	results = make([]core.Metric, len(r.PluginMetrics))
+	ms, err := r.Metrics()
+	if err != nil {
+		return nil, err
+	}
+	results = make([]core.Metric, len(ms))
 	idx := 0
-	for _, m := range r.PluginMetrics {
+	for _, m := range ms {
 		results[idx] = m
 		idx++
 	}
@@ -195,24 +442,30 @@ func (h *httpJSONRPCClient) CollectMetrics(mts []core.Metric) ([]core.Metric, er
 
 // GetMetricTypes returns metric types that can be collected
 func (h *httpJSONRPCClient) GetMetricTypes(config plugin.ConfigType) ([]core.Metric, error) {
-	args := plugin.GetMetricTypesArgs{PluginConfig: config}
+	args := plugin.GetMetricTypesArgs{PluginConfig: config, Token: h.token}
 
-	out, err := h.encoder.Encode(args)
-	if err != nil {
-		return nil, err
-	}
+	mts, err := getMetricTypePages(args, func(args plugin.GetMetricTypesArgs) (*plugin.GetMetricTypesReply, error) {
+		out, err := h.encoder.Encode(args)
+		if err != nil {
+			return nil, err
+		}
 
-	res, err := h.call("Collector.GetMetricTypes", []interface{}{out})
-	if err != nil {
-		return nil, err
-	}
-	var mtr plugin.GetMetricTypesReply
-	err = h.encoder.Decode(res.Result, &mtr)
+		res, err := h.call("Collector.GetMetricTypes", []interface{}{out})
+		if err != nil {
+			return nil, err
+		}
+		var mtr plugin.GetMetricTypesReply
+		err = h.encoder.Decode(res.Result, &mtr)
+		if err != nil {
+			return nil, err
+		}
+		return &mtr, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	metrics := make([]core.Metric, len(mtr.MetricTypes))
-	for i, mt := range mtr.MetricTypes {
+	metrics := make([]core.Metric, len(mts))
+	for i, mt := range mts {
 		mt.LastAdvertisedTime_ = time.Now()
 		metrics[i] = mt
 	}
@@ -221,7 +474,11 @@ func (h *httpJSONRPCClient) GetMetricTypes(config plugin.ConfigType) ([]core.Met
 
 // GetConfigPolicy returns a config policy
 func (h *httpJSONRPCClient) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
-	res, err := h.call("SessionState.GetConfigPolicy", []interface{}{})
+	out, err := h.encoder.Encode(plugin.GetConfigPolicyArgs{Token: h.token})
+	if err != nil {
+		return nil, err
+	}
+	res, err := h.call("SessionState.GetConfigPolicy", []interface{}{out})
 	if err != nil {
 		logger.WithFields(log.Fields{
 			"_block": "GetConfigPolicy",
@@ -241,12 +498,130 @@ func (h *httpJSONRPCClient) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
 	return cpr.Policy, nil
 }
 
-func (h *httpJSONRPCClient) Publish([]core.Metric, map[string]ctypes.ConfigValue) error {
-	return errors.New("Not Implemented")
+// Publish publishes the provided metrics
+func (h *httpJSONRPCClient) Publish(metrics []core.Metric, config map[string]ctypes.ConfigValue) error {
+	_, err := h.PublishBackpressure(metrics, config)
+	return err
+}
+
+// PublishBackpressure publishes the provided metrics and returns the
+// backpressure advised by the plugin
+func (h *httpJSONRPCClient) PublishBackpressure(metrics []core.Metric, config map[string]ctypes.ConfigValue) (*plugin.Backpressure, error) {
+	r, err := h.PublishAck(metrics, config)
+	return r.Backpressure, err
+}
+
+// PublishAck publishes the provided metrics and returns the reply of the
+// plugin
+func (h *httpJSONRPCClient) PublishAck(metrics []core.Metric, config map[string]ctypes.ConfigValue) (plugin.PublishReply, error) {
+	r := plugin.PublishReply{}
+	config, err := plugin.SealConfig(config, sessionEncrypter(h.encrypter))
+	if err != nil {
+		return r, err
+	}
+
+	content, contentType, err := encodeMetrics(h.contentType, metrics)
+	if err != nil {
+		return r, err
+	}
+	content, contentEncoding, err := compressContent(h.contentEncoding, content)
+	if err != nil {
+		return r, err
+	}
+	args := plugin.PublishArgs{
+		ContentType:     contentType,
+		Content:         content,
+		Config:          config,
+		Token:           h.token,
+		ContentEncoding: contentEncoding,
+		RequestID:       requestID(""),
+		Deadline:        callDeadline(h.timeout),
+		ReplyError:      true,
+	}
+
+	out, err := h.encoder.Encode(args)
+	if err != nil {
+		return r, err
+	}
+
+	res, err := h.call("Publisher.Publish", []interface{}{out})
+	if err != nil || len(res.Result) == 0 {
+		return r, err
+	}
+	if err = h.encoder.Decode(res.Result, &r); err != nil {
+		return r, err
+	}
+	if r.Error != nil {
+		return r, r.Error
+	}
+	return r, nil
+}
+
+// PublishStatus returns the outcome of the deferred batches
+func (h *httpJSONRPCClient) PublishStatus(batchIDs []string) (map[string]plugin.BatchStatus, error) {
+	if err := checkMethod(h.rpcVersion, "Publisher.PublishStatus"); err != nil {
+		return nil, err
+	}
+	out, err := h.encoder.Encode(plugin.PublishStatusArgs{Token: h.token, BatchIDs: batchIDs})
+	if err != nil {
+		return nil, err
+	}
+	res, err := h.call("Publisher.PublishStatus", []interface{}{out})
+	if err != nil {
+		return nil, err
+	}
+	var r plugin.PublishStatusReply
+	err = h.encoder.Decode(res.Result, &r)
+	return r.Batches, err
+}
+
+// Process processes the provided metrics and returns the result
+func (h *httpJSONRPCClient) Process(metrics []core.Metric, config map[string]ctypes.ConfigValue) ([]core.Metric, error) {
+	return h.ProcessPipeline(metrics, config, plugin.PipelineInfo{})
 }
 
-func (h *httpJSONRPCClient) Process([]core.Metric, map[string]ctypes.ConfigValue) ([]core.Metric, error) {
-	return nil, errors.New("Not Implemented")
+// ProcessPipeline processes the metrics at the position of the workflow
+// given by pipeline, see PipelineProcessor.
+func (h *httpJSONRPCClient) ProcessPipeline(metrics []core.Metric, config map[string]ctypes.ConfigValue, pipeline plugin.PipelineInfo) ([]core.Metric, error) {
+	config, err := plugin.SealConfig(config, sessionEncrypter(h.encrypter))
+	if err != nil {
+		return nil, err
+	}
+
+	content, contentType, err := encodeMetrics(h.contentType, metrics)
+	if err != nil {
+		return nil, err
+	}
+	content, contentEncoding, err := compressContent(h.contentEncoding, content)
+	if err != nil {
+		return nil, err
+	}
+	args := plugin.ProcessorArgs{
+		ContentType:     contentType,
+		Content:         content,
+		Config:          config,
+		Token:           h.token,
+		ContentEncoding: contentEncoding,
+		RequestID:       requestID(""),
+		Deadline:        callDeadline(h.timeout),
+		Pipeline:        pipeline,
+	}
+
+	out, err := h.encoder.Encode(args)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := h.call("Processor.Process", []interface{}{out})
+	if err != nil {
+		return nil, err
+	}
+	r := plugin.ProcessorReply{}
+	err = h.encoder.Decode(res.Result, &r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMetrics(r.ContentType, r.ContentEncoding, r.Content)
 }
 
 func (h *httpJSONRPCClient) GetType() string {
@@ -260,9 +635,11 @@ type jsonRpcResp struct {
 }
 
 func (h *httpJSONRPCClient) call(method string, args []interface{}) (*jsonRpcResp, error) {
+	// Calls may be made concurrently, e.g. pings while collecting
+	id := atomic.LoadUint64(&h.id)
 	data, err := json.Marshal(map[string]interface{}{
 		"method": method,
-		"id":     h.id,
+		"id":     id,
 		"params": args,
 	})
 	if err != nil {
@@ -271,7 +648,7 @@ func (h *httpJSONRPCClient) call(method string, args []interface{}) (*jsonRpcRes
 			"url":    h.url,
 			"args":   fmt.Sprintf("%+v", args),
 			"method": method,
-			"id":     h.id,
+			"id":     id,
 			"error":  err,
 		}).Error("error encoding request to json")
 		return nil, err
@@ -22,6 +22,7 @@ package client
 import (
 	crand "crypto/rand"
 	"crypto/rsa"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -61,6 +62,44 @@ func (m *mockProxy) Process(args []byte, reply *[]byte) error {
 }
 
 func (m *mockProxy) Publish(args []byte, reply *[]byte) error {
+	var dargs plugin.PublishArgs
+	if err := m.e.Decode(args, &dargs); err != nil {
+		return err
+	}
+	if dargs.ContentType != plugin.SnapGOBContentType {
+		return fmt.Errorf("unexpected content type %s", dargs.ContentType)
+	}
+	mts, err := plugin.UnmarshallMetricTypes(dargs.ContentType, dargs.Content)
+	if err != nil {
+		return err
+	}
+	if len(mts) == 0 {
+		if !dargs.ReplyError {
+			return errors.New("no metrics published")
+		}
+		*reply, _ = m.e.Encode(plugin.PublishReply{
+			Error: &plugin.PluginError{Code: plugin.ErrorCodeCallFailed, Message: "no metrics published"},
+		})
+		return nil
+	}
+	*reply, _ = m.e.Encode(plugin.PublishReply{
+		Backpressure: &plugin.Backpressure{QueueDepth: len(mts), Delay: time.Second},
+		AckMode:      plugin.AckDeferred,
+		BatchID:      "batch-1",
+	})
+	return nil
+}
+
+func (m *mockProxy) PublishStatus(args []byte, reply *[]byte) error {
+	var dargs plugin.PublishStatusArgs
+	if err := m.e.Decode(args, &dargs); err != nil {
+		return err
+	}
+	batches := map[string]plugin.BatchStatus{}
+	for _, id := range dargs.BatchIDs {
+		batches[id] = plugin.BatchStatus{State: plugin.BatchSucceeded}
+	}
+	*reply, _ = m.e.Encode(plugin.PublishStatusReply{Batches: batches})
 	return nil
 }
 
@@ -137,6 +176,12 @@ func (m *mockSessionStatePluginProxy) Ping(arg []byte, b *[]byte) error {
 	return nil
 }
 
+func (m *mockSessionStatePluginProxy) PingStatus(arg []byte, b *[]byte) error {
+	var err error
+	*b, err = m.e.Encode(plugin.PingReply{State: plugin.HealthDegraded, LastError: "failing", Calls: 3})
+	return err
+}
+
 func (m *mockSessionStatePluginProxy) Kill(arg []byte, b *[]byte) error {
 	return nil
 }
@@ -192,6 +237,25 @@ func TestHTTPJSONRPC(t *testing.T) {
 			So(err, ShouldBeNil)
 		})
 
+		Convey("PingStatus", func() {
+			r, err := c.(HealthChecker).PingStatus()
+			So(err, ShouldBeNil)
+			So(r.State, ShouldEqual, plugin.HealthDegraded)
+			So(r.LastError, ShouldEqual, "failing")
+			So(r.Calls, ShouldEqual, 3)
+		})
+
+		Convey("PingStatus of an older plugin", func() {
+			for _, version := range []int{0, 1} {
+				c.(RPCVersionSetter).SetRPCVersion(version)
+				_, err := c.(HealthChecker).PingStatus()
+				So(err, ShouldEqual, ErrUnsupportedMethod)
+			}
+			c.(RPCVersionSetter).SetRPCVersion(plugin.RPCVersion)
+			_, err := c.(HealthChecker).PingStatus()
+			So(err, ShouldBeNil)
+		})
+
 		Convey("Kill", func() {
 			err := c.Kill("somereason")
 			So(err, ShouldBeNil)
@@ -275,4 +339,128 @@ func TestHTTPJSONRPC(t *testing.T) {
 			})
 		})
 	})
+
+	Convey("Processor Client", t, func() {
+		p, err := NewProcessorHttpJSONRPCClient(fmt.Sprintf("http://%v", addr), 1*time.Second, &key.PublicKey, true)
+		So(err, ShouldBeNil)
+		So(p, ShouldNotBeNil)
+		cl := p.(*httpJSONRPCClient)
+		cl.encrypter.Key = symkey
+
+		Convey("Process", func() {
+			mts, err := p.Process([]core.Metric{
+				plugin.NewMetricType(core.NewNamespace("foo", "bar"), time.Now(), nil, "", 1),
+			}, map[string]ctypes.ConfigValue{"someInt": ctypes.ConfigValueInt{Value: 1}})
+			So(err, ShouldBeNil)
+			So(len(mts), ShouldEqual, 1)
+			So(mts[0].Namespace().String(), ShouldEqual, "/foo/bar")
+			So(mts[0].Data(), ShouldEqual, 1)
+		})
+	})
+
+	Convey("Publisher Client", t, func() {
+		p, err := NewPublisherHttpJSONRPCClient(fmt.Sprintf("http://%v", addr), 1*time.Second, &key.PublicKey, true)
+		So(err, ShouldBeNil)
+		So(p, ShouldNotBeNil)
+		cl := p.(*httpJSONRPCClient)
+		cl.encrypter.Key = symkey
+
+		Convey("Publish", func() {
+			err := p.Publish([]core.Metric{
+				plugin.NewMetricType(core.NewNamespace("foo", "bar"), time.Now(), nil, "", 1),
+			}, map[string]ctypes.ConfigValue{"file": ctypes.ConfigValueStr{Value: "/tmp/snap-published"}})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Publish returns the backpressure", func() {
+			bp, err := p.(BackpressurePublisher).PublishBackpressure([]core.Metric{
+				plugin.NewMetricType(core.NewNamespace("foo", "bar"), time.Now(), nil, "", 1),
+			}, nil)
+			So(err, ShouldBeNil)
+			So(bp, ShouldResemble, &plugin.Backpressure{QueueDepth: 1, Delay: time.Second})
+		})
+
+		Convey("Publish with a deferred ack", func() {
+			ap := p.(AckPublisher)
+			r, err := ap.PublishAck([]core.Metric{
+				plugin.NewMetricType(core.NewNamespace("foo", "bar"), time.Now(), nil, "", 1),
+			}, nil)
+			So(err, ShouldBeNil)
+			So(r.AckMode, ShouldEqual, plugin.AckDeferred)
+			batches, err := ap.PublishStatus([]string{r.BatchID})
+			So(err, ShouldBeNil)
+			So(batches, ShouldResemble, map[string]plugin.BatchStatus{"batch-1": {State: plugin.BatchSucceeded}})
+		})
+
+		Convey("Publish an empty batch", func() {
+			err := p.Publish([]core.Metric{}, nil)
+			So(err, ShouldNotBeNil)
+			// the failure comes back in the reply with its code
+			So(err, ShouldHaveSameTypeAs, &plugin.PluginError{})
+			So(plugin.ErrorCodeOf(err), ShouldEqual, plugin.ErrorCodeCallFailed)
+		})
+	})
+}
+
+func TestGetMetricTypePages(t *testing.T) {
+	Convey("getMetricTypePages", t, func() {
+		catalog := []plugin.MetricType{}
+		for i := 0; i < 7; i++ {
+			catalog = append(catalog, *plugin.NewMetricType(core.NewNamespace("foo", fmt.Sprintf("m%d", i)), time.Now(), nil, "", nil))
+		}
+		calls := 0
+		var ids []string
+		// fetch pages the catalog using the metric name as the token
+		fetch := func(args plugin.GetMetricTypesArgs) (*plugin.GetMetricTypesReply, error) {
+			calls++
+			ids = append(ids, args.RequestID)
+			r := &plugin.GetMetricTypesReply{}
+			for _, mt := range catalog {
+				if mt.Namespace()[1].Value <= args.Continue {
+					continue
+				}
+				if len(r.MetricTypes) == args.Limit {
+					r.More = true
+					break
+				}
+				r.MetricTypes = append(r.MetricTypes, mt)
+			}
+			if r.More {
+				r.Continue = r.MetricTypes[len(r.MetricTypes)-1].Namespace()[1].Value
+			}
+			return r, nil
+		}
+		Convey("iterates every page", func() {
+			mts, err := getMetricTypePages(plugin.GetMetricTypesArgs{Limit: 3}, fetch)
+			So(err, ShouldBeNil)
+			So(mts, ShouldResemble, catalog)
+			So(calls, ShouldEqual, 3)
+			So(ids[0], ShouldNotBeEmpty)
+			So(ids, ShouldResemble, []string{ids[0], ids[0], ids[0]})
+		})
+		Convey("keeps the request ID given", func() {
+			_, err := getMetricTypePages(plugin.GetMetricTypesArgs{RequestID: "req-1"}, fetch)
+			So(err, ShouldBeNil)
+			So(ids, ShouldResemble, []string{"req-1"})
+		})
+		Convey("uses the default page size", func() {
+			mts, err := getMetricTypePages(plugin.GetMetricTypesArgs{}, fetch)
+			So(err, ShouldBeNil)
+			So(len(mts), ShouldEqual, 7)
+			So(calls, ShouldEqual, 1)
+		})
+		Convey("returns fetch errors", func() {
+			_, err := getMetricTypePages(plugin.GetMetricTypesArgs{}, func(plugin.GetMetricTypesArgs) (*plugin.GetMetricTypesReply, error) {
+				return nil, errors.New("boom")
+			})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "boom")
+		})
+		Convey("rejects a token which does not advance", func() {
+			_, err := getMetricTypePages(plugin.GetMetricTypesArgs{}, func(plugin.GetMetricTypesArgs) (*plugin.GetMetricTypesReply, error) {
+				return &plugin.GetMetricTypesReply{More: true}, nil
+			})
+			So(err, ShouldEqual, ErrCatalogPaging)
+		})
+	})
 }
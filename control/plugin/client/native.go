@@ -20,13 +20,14 @@ limitations under the License.
 package client
 
 import (
-	"bytes"
 	"crypto/rsa"
+	"crypto/tls"
 	"encoding/gob"
 	"errors"
 	"fmt"
 	"net"
 	"net/rpc"
+	"net/rpc/jsonrpc"
 	"time"
 	"unicode"
 
@@ -48,43 +49,190 @@ type CallsRPC interface {
 
 // Native clients use golang net/rpc for communication to a native rpc server.
 type PluginNativeClient struct {
-	connection CallsRPC
-	pluginType plugin.PluginType
-	encoder    encoding.Encoder
-	encrypter  *encrypter.Encrypter
-	timeout    time.Duration
+	connection      CallsRPC
+	pluginType      plugin.PluginType
+	encoder         encoding.Encoder
+	encrypter       *encrypter.Encrypter
+	timeout         time.Duration
+	token           string
+	rpcVersion      int
+	contentType     string
+	contentEncoding string
 }
 
 func NewCollectorNativeClient(address string, timeout time.Duration, pub *rsa.PublicKey, secure bool) (PluginCollectorClient, error) {
-	return newNativeClient(address, timeout, plugin.CollectorPluginType, pub, secure)
+	return newNativeClient(address, timeout, plugin.CollectorPluginType, pub, secure, nil, plugin.GobCodec)
 }
 
 func NewPublisherNativeClient(address string, timeout time.Duration, pub *rsa.PublicKey, secure bool) (PluginPublisherClient, error) {
-	return newNativeClient(address, timeout, plugin.PublisherPluginType, pub, secure)
+	return newNativeClient(address, timeout, plugin.PublisherPluginType, pub, secure, nil, plugin.GobCodec)
 }
 
 func NewProcessorNativeClient(address string, timeout time.Duration, pub *rsa.PublicKey, secure bool) (PluginProcessorClient, error) {
-	return newNativeClient(address, timeout, plugin.ProcessorPluginType, pub, secure)
+	return newNativeClient(address, timeout, plugin.ProcessorPluginType, pub, secure, nil, plugin.GobCodec)
+}
+
+// NewStreamCollectorNativeClient returns a client draining a stream
+// collector.
+func NewStreamCollectorNativeClient(address string, timeout time.Duration, pub *rsa.PublicKey, secure bool) (PluginStreamCollectorClient, error) {
+	return newNativeClient(address, timeout, plugin.StreamCollectorPluginType, pub, secure, nil, plugin.GobCodec)
+}
+
+// NewCollectorNativeTLSClient returns a collector client for a plugin whose
+// Response has TLS set.  See plugin.ClientTLSConfig.
+func NewCollectorNativeTLSClient(address string, timeout time.Duration, pub *rsa.PublicKey, secure bool, tlsConfig *tls.Config) (PluginCollectorClient, error) {
+	return newNativeClient(address, timeout, plugin.CollectorPluginType, pub, secure, tlsConfig, plugin.GobCodec)
+}
+
+// NewPublisherNativeTLSClient returns a publisher client for a plugin whose
+// Response has TLS set.
+func NewPublisherNativeTLSClient(address string, timeout time.Duration, pub *rsa.PublicKey, secure bool, tlsConfig *tls.Config) (PluginPublisherClient, error) {
+	return newNativeClient(address, timeout, plugin.PublisherPluginType, pub, secure, tlsConfig, plugin.GobCodec)
+}
+
+// NewProcessorNativeTLSClient returns a processor client for a plugin whose
+// Response has TLS set.
+func NewProcessorNativeTLSClient(address string, timeout time.Duration, pub *rsa.PublicKey, secure bool, tlsConfig *tls.Config) (PluginProcessorClient, error) {
+	return newNativeClient(address, timeout, plugin.ProcessorPluginType, pub, secure, tlsConfig, plugin.GobCodec)
+}
+
+// NewCollectorNativeJSONClient returns a collector client for a plugin whose
+// Response has Codec set to plugin.JSONCodec.  tlsConfig may be nil.
+func NewCollectorNativeJSONClient(address string, timeout time.Duration, pub *rsa.PublicKey, secure bool, tlsConfig *tls.Config) (PluginCollectorClient, error) {
+	return newNativeClient(address, timeout, plugin.CollectorPluginType, pub, secure, tlsConfig, plugin.JSONCodec)
+}
+
+// NewPublisherNativeJSONClient returns a publisher client for a plugin whose
+// Response has Codec set to plugin.JSONCodec.
+func NewPublisherNativeJSONClient(address string, timeout time.Duration, pub *rsa.PublicKey, secure bool, tlsConfig *tls.Config) (PluginPublisherClient, error) {
+	return newNativeClient(address, timeout, plugin.PublisherPluginType, pub, secure, tlsConfig, plugin.JSONCodec)
+}
+
+// NewProcessorNativeJSONClient returns a processor client for a plugin whose
+// Response has Codec set to plugin.JSONCodec.
+func NewProcessorNativeJSONClient(address string, timeout time.Duration, pub *rsa.PublicKey, secure bool, tlsConfig *tls.Config) (PluginProcessorClient, error) {
+	return newNativeClient(address, timeout, plugin.ProcessorPluginType, pub, secure, tlsConfig, plugin.JSONCodec)
+}
+
+// SetToken sets the session token sent with each call to the plugin.
+func (p *PluginNativeClient) SetToken(token string) {
+	p.token = token
+}
+
+// SetRPCVersion sets the RPC version spoken by the plugin.  Methods it does
+// not serve then fail with ErrUnsupportedMethod.
+func (p *PluginNativeClient) SetRPCVersion(version int) {
+	if version == 0 {
+		// The plugin predates versioning
+		version = 1
+	}
+	p.rpcVersion = version
+}
+
+// SetContentType sets the content type of the metrics sent to the plugin,
+// as negotiated in its Response.
+func (p *PluginNativeClient) SetContentType(contentType string) {
+	p.contentType = contentType
+}
+
+// SetContentEncoding sets the encoding large payloads sent to the plugin are
+// compressed with, as selected from its Response.
+func (p *PluginNativeClient) SetContentEncoding(encoding string) {
+	p.contentEncoding = encoding
 }
 
 func (p *PluginNativeClient) Ping() error {
+	out, err := p.encoder.Encode(plugin.PingArgs{Token: p.token})
+	if err != nil {
+		return err
+	}
 	var reply []byte
-	err := p.connection.Call("SessionState.Ping", []byte{}, &reply)
+	err = p.connection.Call("SessionState.Ping", out, &reply)
 	return err
 }
 
+// PingStatus pings the plugin and returns its health.
+func (p *PluginNativeClient) PingStatus() (plugin.PingReply, error) {
+	if err := checkMethod(p.rpcVersion, "SessionState.PingStatus"); err != nil {
+		return plugin.PingReply{}, err
+	}
+	out, err := p.encoder.Encode(plugin.PingArgs{Token: p.token})
+	if err != nil {
+		return plugin.PingReply{}, err
+	}
+	var reply []byte
+	if err := p.connection.Call("SessionState.PingStatus", out, &reply); err != nil {
+		return plugin.PingReply{}, err
+	}
+	var r plugin.PingReply
+	err = p.encoder.Decode(reply, &r)
+	return r, err
+}
+
+// GetStats returns the plugin's session statistics.
+func (p *PluginNativeClient) GetStats() (plugin.SessionStats, error) {
+	if err := checkMethod(p.rpcVersion, "SessionState.GetStats"); err != nil {
+		return plugin.SessionStats{}, err
+	}
+	out, err := p.encoder.Encode(plugin.StatsArgs{Token: p.token})
+	if err != nil {
+		return plugin.SessionStats{}, err
+	}
+	var reply []byte
+	if err := p.connection.Call("SessionState.GetStats", out, &reply); err != nil {
+		return plugin.SessionStats{}, err
+	}
+	var st plugin.SessionStats
+	err = p.encoder.Decode(reply, &st)
+	return st, err
+}
+
+// getChunk fetches a chunk of a reply sent as a plugin.Transfer.
+func (p *PluginNativeClient) getChunk(args plugin.GetChunkArgs) (plugin.Chunk, error) {
+	if err := checkMethod(p.rpcVersion, "SessionState.GetChunk"); err != nil {
+		return plugin.Chunk{}, err
+	}
+	args.Token = p.token
+	out, err := p.encoder.Encode(args)
+	if err != nil {
+		return plugin.Chunk{}, err
+	}
+	var reply []byte
+	if err := p.connection.Call("SessionState.GetChunk", out, &reply); err != nil {
+		return plugin.Chunk{}, err
+	}
+	var c plugin.Chunk
+	err = p.encoder.Decode(reply, &c)
+	return c, err
+}
+
+// ackTransfer lets the plugin drop a transfer whose chunks were received.
+// A plugin which does not serve AckTransfer expires it instead.
+func (p *PluginNativeClient) ackTransfer(id string) {
+	if checkMethod(p.rpcVersion, "SessionState.AckTransfer") != nil {
+		return
+	}
+	out, err := p.encoder.Encode(plugin.AckTransferArgs{Token: p.token, Transfer: id})
+	if err != nil {
+		return
+	}
+	var reply []byte
+	p.connection.Call("SessionState.AckTransfer", out, &reply)
+}
+
 func (p *PluginNativeClient) SetKey() error {
 	out, err := p.encrypter.EncryptKey()
 	if err != nil {
 		return err
 	}
 	return p.connection.Call("SessionState.SetKey", plugin.SetKeyArgs{
-		Key: out,
+		Key:   out,
+		Token: p.token,
 	}, &[]byte{})
 }
 
 func (p *PluginNativeClient) Kill(reason string) error {
-	args := plugin.KillArgs{Reason: reason}
+	args := plugin.KillArgs{Reason: reason, ReasonCode: plugin.ParseKillReason(reason), Token: p.token}
 	out, err := p.encoder.Encode(args)
 	if err != nil {
 		return err
@@ -105,7 +253,9 @@ func checkTime(in time.Time) time.Time {
 	return in
 }
 
-func encodeMetrics(metrics []core.Metric) []byte {
+// encodeMetrics encodes the metrics in the content type negotiated with the
+// plugin, snap.gob when none was, and returns that content type.
+func encodeMetrics(contentType string, metrics []core.Metric) ([]byte, string, error) {
 	mts := make([]plugin.MetricType, len(metrics))
 	for i, m := range metrics {
 		mts[i] = plugin.MetricType{
@@ -120,16 +270,35 @@ func encodeMetrics(metrics []core.Metric) []byte {
 			Data_:               m.Data(),
 		}
 	}
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	enc.Encode(mts)
-	return buf.Bytes()
+	if contentType == "" {
+		contentType = plugin.SnapGOBContentType
+	}
+	b, err := plugin.EncodeMetrics(contentType, mts)
+	return b, contentType, err
+}
+
+// compressContent compresses content with the encoding negotiated with the
+// plugin when it exceeds plugin.DefaultCompressThreshold.
+func compressContent(encoding string, content []byte) ([]byte, string, error) {
+	if encoding == plugin.NoContentEncoding || len(content) <= plugin.DefaultCompressThreshold {
+		return content, plugin.NoContentEncoding, nil
+	}
+	b, err := plugin.CompressContent(encoding, content)
+	if err != nil {
+		return nil, "", err
+	}
+	return b, encoding, nil
 }
 
-func decodeMetrics(bts []byte) ([]core.Metric, error) {
-	var mts []plugin.MetricType
-	dec := gob.NewDecoder(bytes.NewBuffer(bts))
-	if err := dec.Decode(&mts); err != nil {
+// decodeMetrics decodes the metrics in the content type and encoding
+// returned by the plugin.
+func decodeMetrics(contentType, contentEncoding string, bts []byte) ([]core.Metric, error) {
+	bts, err := plugin.DecompressContent(contentEncoding, bts)
+	if err != nil {
+		return nil, err
+	}
+	mts, err := plugin.UnmarshallMetricTypes(contentType, bts)
+	if err != nil {
 		return nil, fmt.Errorf("Error decoding metrics: %v", err)
 	}
 	var cmetrics []core.Metric
@@ -151,31 +320,114 @@ func enforceTimeout(p *PluginNativeClient, dl time.Duration, done chan int) {
 }
 
 func (p *PluginNativeClient) Publish(metrics []core.Metric, config map[string]ctypes.ConfigValue) error {
+	_, err := p.PublishBackpressure(metrics, config)
+	return err
+}
+
+// PublishBackpressure publishes the metrics and returns the backpressure
+// advised by the plugin, see BackpressurePublisher.
+func (p *PluginNativeClient) PublishBackpressure(metrics []core.Metric, config map[string]ctypes.ConfigValue) (*plugin.Backpressure, error) {
+	r, err := p.PublishAck(metrics, config)
+	return r.Backpressure, err
+}
+
+// PublishAck publishes the metrics and returns the reply of the plugin, see
+// AckPublisher.
+func (p *PluginNativeClient) PublishAck(metrics []core.Metric, config map[string]ctypes.ConfigValue) (plugin.PublishReply, error) {
+	r := plugin.PublishReply{}
+	config, err := plugin.SealConfig(config, sessionEncrypter(p.encrypter))
+	if err != nil {
+		return r, err
+	}
 
+	content, contentType, err := encodeMetrics(p.contentType, metrics)
+	if err != nil {
+		return r, err
+	}
+	content, contentEncoding, err := compressContent(p.contentEncoding, content)
+	if err != nil {
+		return r, err
+	}
 	args := plugin.PublishArgs{
-		ContentType: plugin.SnapGOBContentType,
-		Content:     encodeMetrics(metrics),
-		Config:      config,
+		ContentType:     contentType,
+		Content:         content,
+		Config:          config,
+		Token:           p.token,
+		ContentEncoding: contentEncoding,
+		RequestID:       requestID(""),
+		Deadline:        callDeadline(p.timeout),
+		ReplyError:      true,
 	}
 
 	out, err := p.encoder.Encode(args)
 	if err != nil {
-		return err
+		return r, err
 	}
 	var reply []byte
 	done := make(chan int)
 	go enforceTimeout(p, p.timeout, done)
 	err = p.connection.Call("Publisher.Publish", out, &reply)
 	close(done)
-	return err
+	if err != nil || len(reply) == 0 {
+		return r, err
+	}
+	if err = p.encoder.Decode(reply, &r); err != nil {
+		return r, err
+	}
+	if r.Error != nil {
+		return r, r.Error
+	}
+	return r, nil
+}
+
+// PublishStatus returns the outcome of the deferred batches, see
+// AckPublisher.
+func (p *PluginNativeClient) PublishStatus(batchIDs []string) (map[string]plugin.BatchStatus, error) {
+	if err := checkMethod(p.rpcVersion, "Publisher.PublishStatus"); err != nil {
+		return nil, err
+	}
+	out, err := p.encoder.Encode(plugin.PublishStatusArgs{Token: p.token, BatchIDs: batchIDs})
+	if err != nil {
+		return nil, err
+	}
+	var reply []byte
+	if err := p.connection.Call("Publisher.PublishStatus", out, &reply); err != nil {
+		return nil, err
+	}
+	var r plugin.PublishStatusReply
+	err = p.encoder.Decode(reply, &r)
+	return r.Batches, err
 }
 
 func (p *PluginNativeClient) Process(metrics []core.Metric, config map[string]ctypes.ConfigValue) ([]core.Metric, error) {
+	return p.ProcessPipeline(metrics, config, plugin.PipelineInfo{})
+}
+
+// ProcessPipeline processes the metrics at the position of the workflow
+// given by pipeline, see PipelineProcessor.
+func (p *PluginNativeClient) ProcessPipeline(metrics []core.Metric, config map[string]ctypes.ConfigValue, pipeline plugin.PipelineInfo) ([]core.Metric, error) {
+	config, err := plugin.SealConfig(config, sessionEncrypter(p.encrypter))
+	if err != nil {
+		return nil, err
+	}
 
+	content, contentType, err := encodeMetrics(p.contentType, metrics)
+	if err != nil {
+		return nil, err
+	}
+	content, contentEncoding, err := compressContent(p.contentEncoding, content)
+	if err != nil {
+		return nil, err
+	}
 	args := plugin.ProcessorArgs{
-		ContentType: plugin.SnapGOBContentType,
-		Content:     encodeMetrics(metrics),
-		Config:      config,
+		ContentType:     contentType,
+		Content:         content,
+		Config:          config,
+		Token:           p.token,
+		ContentEncoding: contentEncoding,
+		RequestID:       requestID(""),
+		Deadline:        callDeadline(p.timeout),
+		Pipeline:        pipeline,
 	}
 
 	out, err := p.encoder.Encode(args)
@@ -197,7 +449,7 @@ func (p *PluginNativeClient) Process(metrics []core.Metric, config map[string]ct
 	if err != nil {
 		return nil, err
 	}
-	mts, err := decodeMetrics(r.Content)
+	mts, err := decodeMetrics(r.ContentType, r.ContentEncoding, r.Content)
 	if err != nil {
 		return nil, err
 	}
@@ -215,16 +467,25 @@ func (p *PluginNativeClient) CollectMetrics(mts []core.Metric) ([]core.Metric, e
 
 	metricsToCollect := make([]plugin.MetricType, len(mts))
 	for idx, mt := range mts {
+		cfg, err := plugin.SealConfigNode(mt.Config(), sessionEncrypter(p.encrypter))
+		if err != nil {
+			return nil, err
+		}
 		metricsToCollect[idx] = plugin.MetricType{
 			Namespace_:          mt.Namespace(),
 			LastAdvertisedTime_: mt.LastAdvertisedTime(),
 			Version_:            mt.Version(),
 			Tags_:               mt.Tags(),
-			Config_:             mt.Config(),
+			Config_:             cfg,
 		}
 	}
 
-	args := plugin.CollectMetricsArgs{MetricTypes: metricsToCollect}
+	args := plugin.CollectMetricsArgs{
+		MetricTypes: metricsToCollect,
+		Token:       p.token,
+		RequestID:   requestID(""),
+		Deadline:    callDeadline(p.timeout),
+	}
 	out, err := p.encoder.Encode(args)
 	if err != nil {
 		return nil, err
@@ -244,10 +505,25 @@ func (p *PluginNativeClient) CollectMetrics(mts []core.Metric) ([]core.Metric, e
 	if err != nil {
 		return nil, err
 	}
+	if r.Transfer != nil {
+		b, err := plugin.FetchTransfer(r.Transfer, p.getChunk)
+		if err != nil {
+			return nil, err
+		}
+		p.ackTransfer(r.Transfer.ID)
+		r = &plugin.CollectMetricsReply{}
+		if err := p.encoder.Decode(b, r); err != nil {
+			return nil, err
+		}
+	}
 
-	results = make([]core.Metric, len(r.PluginMetrics))
+	ms, err := r.Metrics()
+	if err != nil {
+		return nil, err
+	}
+	results = make([]core.Metric, len(ms))
 	idx := 0
-	for _, m := range r.PluginMetrics {
+	for _, m := range ms {
 		results[idx] = m
 		idx++
 	}
@@ -255,30 +531,159 @@ func (p *PluginNativeClient) CollectMetrics(mts []core.Metric) ([]core.Metric, e
 	return results, nil
 }
 
-func (p *PluginNativeClient) GetMetricTypes(config plugin.ConfigType) ([]core.Metric, error) {
+// Suspend makes the plugin fail collect, publish and process calls until
+// Resume is called.
+func (p *PluginNativeClient) Suspend(reason string) error {
+	return p.suspension("SessionState.Suspend", plugin.SuspendArgs{Reason: reason, Token: p.token})
+}
+
+// Resume ends a suspension of the plugin.
+func (p *PluginNativeClient) Resume() error {
+	return p.suspension("SessionState.Resume", plugin.ResumeArgs{Token: p.token})
+}
+
+func (p *PluginNativeClient) suspension(method string, args interface{}) error {
+	if err := checkMethod(p.rpcVersion, method); err != nil {
+		return err
+	}
+	out, err := p.encoder.Encode(args)
+	if err != nil {
+		return err
+	}
 	var reply []byte
+	return p.connection.Call(method, out, &reply)
+}
 
-	args := plugin.GetMetricTypesArgs{PluginConfig: config}
+// SetConfig replaces the config of the plugin.
+func (p *PluginNativeClient) SetConfig(config map[string]ctypes.ConfigValue) (plugin.SetConfigReply, error) {
+	if err := checkMethod(p.rpcVersion, "SessionState.SetConfig"); err != nil {
+		return plugin.SetConfigReply{}, err
+	}
+	sealed, err := plugin.SealConfig(config, sessionEncrypter(p.encrypter))
+	if err != nil {
+		return plugin.SetConfigReply{}, err
+	}
+	out, err := p.encoder.Encode(plugin.SetConfigArgs{Config: sealed, Token: p.token})
+	if err != nil {
+		return plugin.SetConfigReply{}, err
+	}
+	var reply []byte
+	if err := p.connection.Call("SessionState.SetConfig", out, &reply); err != nil {
+		return plugin.SetConfigReply{}, err
+	}
+	var r plugin.SetConfigReply
+	err = p.encoder.Decode(reply, &r)
+	return r, err
+}
 
+// SelfTest runs the self-test of the plugin with config completing its own.
+func (p *PluginNativeClient) SelfTest(config map[string]ctypes.ConfigValue) (plugin.SelfTestReply, error) {
+	if err := checkMethod(p.rpcVersion, "SessionState.SelfTest"); err != nil {
+		return plugin.SelfTestReply{}, err
+	}
+	sealed, err := plugin.SealConfig(config, sessionEncrypter(p.encrypter))
+	if err != nil {
+		return plugin.SelfTestReply{}, err
+	}
+	out, err := p.encoder.Encode(plugin.SelfTestArgs{Config: sealed, Token: p.token})
+	if err != nil {
+		return plugin.SelfTestReply{}, err
+	}
+	var reply []byte
+	if err := p.connection.Call("SessionState.SelfTest", out, &reply); err != nil {
+		return plugin.SelfTestReply{}, err
+	}
+	var r plugin.SelfTestReply
+	err = p.encoder.Decode(reply, &r)
+	return r, err
+}
+
+// SubscribeMetrics tells the collector that a task subscribed to mts.
+func (p *PluginNativeClient) SubscribeMetrics(mts []core.Metric) error {
+	return p.subscription("Collector.SubscribeMetrics", mts)
+}
+
+// UnsubscribeMetrics tells the collector that a task unsubscribed from mts.
+func (p *PluginNativeClient) UnsubscribeMetrics(mts []core.Metric) error {
+	return p.subscription("Collector.UnsubscribeMetrics", mts)
+}
+
+func (p *PluginNativeClient) subscription(method string, mts []core.Metric) error {
+	if err := checkMethod(p.rpcVersion, method); err != nil {
+		return err
+	}
+	args, err := subscriptionArgs(mts, p.encrypter, p.token)
+	if err != nil {
+		return err
+	}
 	out, err := p.encoder.Encode(args)
 	if err != nil {
-		log.Error("error while encoding args for getmetrictypes :(")
-		return nil, err
+		return err
 	}
+	var reply []byte
+	return p.connection.Call(method, out, &reply)
+}
 
-	err = p.connection.Call("Collector.GetMetricTypes", out, &reply)
+// DrainStream returns the metrics streamed since the previous drain.  The
+// reply also carries the plugin's drop counter and stream errors.
+func (p *PluginNativeClient) DrainStream(max int, wait time.Duration) ([]core.Metric, *plugin.DrainStreamReply, error) {
+	if err := checkMethod(p.rpcVersion, "StreamCollector.Drain"); err != nil {
+		return nil, nil, err
+	}
+	out, err := p.encoder.Encode(plugin.DrainStreamArgs{Token: p.token, Max: max, Wait: wait})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	r := &plugin.GetMetricTypesReply{}
-	err = p.encoder.Decode(reply, r)
+	var reply []byte
+	done := make(chan int)
+	go enforceTimeout(p, p.timeout+wait, done)
+	err = p.connection.Call("StreamCollector.Drain", out, &reply)
+	close(done)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := &plugin.DrainStreamReply{}
+	if err := p.encoder.Decode(reply, r); err != nil {
+		return nil, nil, err
+	}
+	results := make([]core.Metric, len(r.Metrics))
+	for i, m := range r.Metrics {
+		results[i] = m
+	}
+	return results, r, nil
+}
+
+func (p *PluginNativeClient) GetMetricTypes(config plugin.ConfigType) ([]core.Metric, error) {
+	args := plugin.GetMetricTypesArgs{PluginConfig: config, Token: p.token}
+
+	mts, err := getMetricTypePages(args, func(args plugin.GetMetricTypesArgs) (*plugin.GetMetricTypesReply, error) {
+		var reply []byte
+		out, err := p.encoder.Encode(args)
+		if err != nil {
+			log.Error("error while encoding args for getmetrictypes :(")
+			return nil, err
+		}
+
+		err = p.connection.Call("Collector.GetMetricTypes", out, &reply)
+		if err != nil {
+			return nil, err
+		}
+
+		r := &plugin.GetMetricTypesReply{}
+		err = p.encoder.Decode(reply, r)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	retMetricTypes := make([]core.Metric, len(r.MetricTypes))
-	for i, mt := range r.MetricTypes {
+	retMetricTypes := make([]core.Metric, len(mts))
+	for i, mt := range mts {
 		// Set the advertised time
 		mt.LastAdvertisedTime_ = time.Now()
 		retMetricTypes[i] = mt
@@ -287,8 +692,12 @@ func (p *PluginNativeClient) GetMetricTypes(config plugin.ConfigType) ([]core.Me
 }
 
 func (p *PluginNativeClient) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	out, err := p.encoder.Encode(plugin.GetConfigPolicyArgs{Token: p.token})
+	if err != nil {
+		return nil, err
+	}
 	var reply []byte
-	err := p.connection.Call("SessionState.GetConfigPolicy", []byte{}, &reply)
+	err = p.connection.Call("SessionState.GetConfigPolicy", out, &reply)
 	if err != nil {
 		return nil, err
 	}
@@ -308,21 +717,33 @@ func (p *PluginNativeClient) GetType() string {
 	return upcaseInitial(p.pluginType.String())
 }
 
-func newNativeClient(address string, timeout time.Duration, t plugin.PluginType, pub *rsa.PublicKey, secure bool) (*PluginNativeClient, error) {
+func newNativeClient(address string, timeout time.Duration, t plugin.PluginType, pub *rsa.PublicKey, secure bool, tlsConfig *tls.Config, codec string) (*PluginNativeClient, error) {
 	// Attempt to dial address error on timeout or problem
-	conn, err := net.DialTimeout("tcp", address, timeout)
+	var (
+		conn net.Conn
+		err  error
+	)
+	if tlsConfig != nil {
+		network, addr := plugin.SplitListenAddress(address)
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, network, addr, tlsConfig)
+	} else {
+		conn, err = plugin.DialListenAddress(address, timeout)
+	}
 	// Return nil RPCClient and err if encoutered
 	if err != nil {
 		return nil, err
 	}
-	r := rpc.NewClient(conn)
 	p := &PluginNativeClient{
-		connection: r,
 		pluginType: t,
 		timeout:    timeout,
 	}
-
-	p.encoder = encoding.NewGobEncoder()
+	if codec == plugin.JSONCodec {
+		p.connection = jsonrpc.NewClient(conn)
+		p.encoder = encoding.NewJsonEncoder()
+	} else {
+		p.connection = rpc.NewClient(conn)
+		p.encoder = encoding.NewGobEncoder()
+	}
 
 	if secure {
 		key, err := encrypter.GenerateKey()
@@ -343,6 +764,7 @@ func init() {
 	gob.RegisterName("conf_value_int", *(&ctypes.ConfigValueInt{}))
 	gob.RegisterName("conf_value_float", *(&ctypes.ConfigValueFloat{}))
 	gob.RegisterName("conf_value_bool", *(&ctypes.ConfigValueBool{}))
+	gob.RegisterName("conf_value_secure_string", *(&ctypes.ConfigValueSecureString{}))
 
 	gob.RegisterName("conf_policy_node", cpolicy.NewPolicyNode())
 	gob.RegisterName("conf_data_node", &cdata.ConfigDataNode{})
@@ -350,6 +772,7 @@ func init() {
 	gob.RegisterName("conf_policy_int", &cpolicy.IntRule{})
 	gob.RegisterName("conf_policy_float", &cpolicy.FloatRule{})
 	gob.RegisterName("conf_policy_bool", &cpolicy.BoolRule{})
+	gob.RegisterName("conf_policy_secure_string", &cpolicy.SecureStringRule{})
 }
 
 func upcaseInitial(str string) string {
@@ -0,0 +1,69 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultCloseTimeout is how long a Closer's Close may run when
+// Arg.CloseTimeout is not set.
+var DefaultCloseTimeout = 5 * time.Second
+
+// Closer may be implemented by a plugin to release its connections and
+// files as the session ends.  Close runs exactly once, whichever ended the
+// session, after calls in flight were drained and before KillChan and Done
+// are closed.  It is given Arg.CloseTimeout to return; its error is logged
+// and reported in Shutdown.CloseError.
+type Closer interface {
+	Close() error
+}
+
+// runClose calls the plugin's Close, if it implements Closer, and returns
+// its error or the error of a Close which did not return in time.
+func (s *SessionState) runClose() error {
+	c, ok := s.plugin.(Closer)
+	if !ok {
+		return nil
+	}
+	timeout := s.CloseTimeout
+	if timeout == 0 {
+		timeout = DefaultCloseTimeout
+	}
+	errc := make(chan error, 1)
+	go func() {
+		defer catchPluginPanic(s.Logger())
+		errc <- c.Close()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-errc:
+		if err != nil {
+			s.Logger().Errorf("Close failed: %v", err)
+		}
+		return err
+	case <-timer.C:
+		err := fmt.Errorf("Close did not return within %v", timeout)
+		s.Logger().Errorf("%v", err)
+		return err
+	}
+}
@@ -0,0 +1,130 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// closingPublisher counts its calls to Close, which returns err once
+// release is closed
+type closingPublisher struct {
+	MockPublisher
+
+	mutex   sync.Mutex
+	closes  int
+	err     error
+	release chan struct{}
+}
+
+func (c *closingPublisher) Close() error {
+	c.mutex.Lock()
+	c.closes++
+	c.mutex.Unlock()
+	if c.release != nil {
+		<-c.release
+	}
+	return c.err
+}
+
+func (c *closingPublisher) closeCalls() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closes
+}
+
+func TestClose(t *testing.T) {
+	Convey("A session serving a Closer", t, func() {
+		p := &closingPublisher{}
+		ss := &SessionState{
+			Arg:     &Arg{PingTimeoutDuration: time.Millisecond, PingTimeoutLimit: 1},
+			Encoder: encoding.NewGobEncoder(),
+			plugin:  p,
+			token:   "s3cr3t",
+			logger:  log.New(),
+		}
+
+		Convey("closes it when killed", func() {
+			in, err := ss.Encode(KillArgs{Reason: "unloading", Token: "s3cr3t"})
+			So(err, ShouldBeNil)
+			So(ss.Kill(in, &[]byte{}), ShouldBeNil)
+			<-ss.Done()
+			So(p.closeCalls(), ShouldEqual, 1)
+			So(ss.ShutdownReason().CloseError, ShouldBeEmpty)
+		})
+		Convey("closes it when the heartbeat expires", func() {
+			ss.heartbeatWatch()
+			<-ss.Done()
+			So(p.closeCalls(), ShouldEqual, 1)
+		})
+		Convey("closes it when signaled", func() {
+			ss.shutdown(KillReasonSignal, Shutdown{Reason: "terminated", Source: ShutdownSourceSignal})
+			<-ss.Done()
+			So(p.closeCalls(), ShouldEqual, 1)
+		})
+		Convey("closes it once when every source fires at the same time", func() {
+			in, err := ss.Encode(KillArgs{Reason: "unloading", Token: "s3cr3t"})
+			So(err, ShouldBeNil)
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(3)
+				go func() {
+					defer wg.Done()
+					ss.Kill(in, &[]byte{})
+				}()
+				go func() {
+					defer wg.Done()
+					ss.heartbeatWatch()
+				}()
+				go func() {
+					defer wg.Done()
+					ss.shutdown(KillReasonSignal, Shutdown{Reason: "terminated", Source: ShutdownSourceSignal})
+				}()
+			}
+			wg.Wait()
+			<-ss.Done()
+			So(p.closeCalls(), ShouldEqual, 1)
+		})
+		Convey("reports the error of Close", func() {
+			p.err = errors.New("connection reset")
+			ss.endSession(Shutdown{Reason: "interrupt", Source: ShutdownSourceSignal})
+			So(ss.ShutdownReason().CloseError, ShouldEqual, "connection reset")
+		})
+		Convey("does not wait past CloseTimeout for a hanging Close", func() {
+			p.release = make(chan struct{})
+			defer close(p.release)
+			ss.CloseTimeout = 50 * time.Millisecond
+			start := time.Now()
+			ss.endSession(Shutdown{Reason: "interrupt", Source: ShutdownSourceSignal})
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+			So(ss.ShutdownReason().CloseError, ShouldContainSubstring, "did not return within 50ms")
+		})
+	})
+}
@@ -0,0 +1,152 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type jsonRPCResponse struct {
+	ID     int         `json:"id"`
+	Result []byte      `json:"result"`
+	Error  interface{} `json:"error"`
+}
+
+// rawJSONRPCCall writes a JSON-RPC request frame by hand and reads the
+// response frame.  params is the JSON session payload which net/rpc
+// carries as a base64 encoded []byte.
+func rawJSONRPCCall(conn net.Conn, r *bufio.Reader, id int, method, params string) jsonRPCResponse {
+	frame := fmt.Sprintf(`{"method": %q, "params": [%q], "id": %d}`+"\n",
+		method, base64.StdEncoding.EncodeToString([]byte(params)), id)
+	_, err := conn.Write([]byte(frame))
+	So(err, ShouldBeNil)
+	line, err := r.ReadBytes('\n')
+	So(err, ShouldBeNil)
+	var resp jsonRPCResponse
+	So(json.Unmarshal(line, &resp), ShouldBeNil)
+	So(resp.ID, ShouldEqual, id)
+	return resp
+}
+
+func TestJSONCodec(t *testing.T) {
+	Convey("A plugin served with the JSON codec", t, func() {
+		args := fmt.Sprintf(`{"NoDaemon": true, "Codec": "json", "PingTimeoutDuration": %d}`, time.Minute)
+		resp, done := startTestCollector(args)
+		So(<-done, ShouldEqual, 0)
+		So(resp.Codec, ShouldEqual, JSONCodec)
+
+		conn, err := net.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+
+		Convey("answers hand written frames", func() {
+			pr := rawJSONRPCCall(conn, r, 1, "SessionState.Ping", fmt.Sprintf(`{"Token": %q}`, resp.Token))
+			So(pr.Error, ShouldBeNil)
+
+			mr := rawJSONRPCCall(conn, r, 2, "Collector.GetMetricTypes", fmt.Sprintf(`{"Token": %q}`, resp.Token))
+			So(mr.Error, ShouldBeNil)
+			var reply struct {
+				MetricTypes []struct {
+					Namespace []struct {
+						Value string
+					}
+				}
+			}
+			So(json.Unmarshal(mr.Result, &reply), ShouldBeNil)
+			So(len(reply.MetricTypes), ShouldEqual, 1)
+			So(reply.MetricTypes[0].Namespace[0].Value, ShouldEqual, "foo")
+
+			kr := rawJSONRPCCall(conn, r, 3, "SessionState.Kill", fmt.Sprintf(`{"Reason": "test", "Token": %q}`, resp.Token))
+			So(kr.Error, ShouldBeNil)
+		})
+		Convey("rejects frames with a bad token", func() {
+			pr := rawJSONRPCCall(conn, r, 1, "SessionState.Ping", `{"Token": "bad"}`)
+			So(pr.Error, ShouldEqual, ErrBadToken.Error())
+		})
+	})
+	Convey("The built-in handlers behave the same under both codecs", t, func() {
+		for _, codec := range []string{GobCodec, JSONCodec} {
+			args := fmt.Sprintf(`{"NoDaemon": true, "Codec": %q, "PingTimeoutDuration": %d}`, codec, time.Minute)
+			resp, done := startTestCollector(args)
+			So(<-done, ShouldEqual, 0)
+			So(resp.Codec, ShouldEqual, codec)
+
+			conn, err := net.Dial("tcp", resp.ListenAddress)
+			So(err, ShouldBeNil)
+			var (
+				client *rpc.Client
+				enc    encoding.Encoder
+			)
+			if codec == JSONCodec {
+				client, enc = jsonrpc.NewClient(conn), encoding.NewJsonEncoder()
+			} else {
+				client, enc = rpc.NewClient(conn), encoding.NewGobEncoder()
+			}
+			call := func(method string, in, out interface{}) error {
+				b, err := enc.Encode(in)
+				So(err, ShouldBeNil)
+				var reply []byte
+				if err := client.Call(method, b, &reply); err != nil {
+					return err
+				}
+				if out == nil {
+					return nil
+				}
+				return enc.Decode(reply, out)
+			}
+
+			So(call("SessionState.Ping", PingArgs{Token: resp.Token}, nil), ShouldBeNil)
+			So(call("SessionState.Ping", PingArgs{Token: "bad"}, nil).Error(), ShouldEqual, ErrBadToken.Error())
+
+			var mtr GetMetricTypesReply
+			So(call("Collector.GetMetricTypes", GetMetricTypesArgs{PluginConfig: NewPluginConfigType(), Token: resp.Token}, &mtr), ShouldBeNil)
+			So(len(mtr.MetricTypes), ShouldEqual, 1)
+			So(mtr.MetricTypes[0].Namespace().String(), ShouldEqual, "/foo/bar")
+
+			var cmr CollectMetricsReply
+			So(call("Collector.CollectMetrics", CollectMetricsArgs{MetricTypes: mtr.MetricTypes, Token: resp.Token}, &cmr), ShouldBeNil)
+			So(cmr.PluginMetrics, ShouldBeEmpty)
+
+			So(call("SessionState.Kill", KillArgs{Reason: "test", Token: resp.Token}, nil), ShouldBeNil)
+			client.Close()
+		}
+	})
+	Convey("An unknown codec is refused", t, func() {
+		m := NewPluginMeta("mock", 1, CollectorPluginType, []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+		err, rc := Start(m, new(MockPlugin), `{"NoDaemon": true, "Codec": "xml"}`)
+		So(err, ShouldEqual, ErrUnsupportedCodec)
+		So(rc, ShouldEqual, 2)
+	})
+}
@@ -25,6 +25,8 @@ package plugin
 // Collector plugin
 type CollectorPlugin interface {
 	Plugin
+	// CollectMetrics returns the requested metrics populated with data.
 	CollectMetrics([]MetricType) ([]MetricType, error)
+	// GetMetricTypes returns the catalog of metrics the plugin can collect.
 	GetMetricTypes(ConfigType) ([]MetricType, error)
 }
@@ -20,53 +20,175 @@ limitations under the License.
 package plugin
 
 import (
-	"errors"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
+	"golang.org/x/net/context"
+
+	"github.com/intelsdi-x/snap/core"
 	"github.com/intelsdi-x/snap/core/cdata"
 )
 
 // Arguments passed to CollectMetrics() for a Collector implementation
 type CollectMetricsArgs struct {
+	// MetricTypes each carry their own config, see MetricType.Config
 	MetricTypes []MetricType
+	Token       string
+	// RequestID identifies the call in the plugin log, the reply and any
+	// PluginError returned, see NewRequestID.
+	RequestID string `json:",omitempty"`
+	// Deadline, when set, fails the call with ErrDeadlineExceeded once it
+	// passes, see ContextCollector.
+	Deadline time.Time
 }
 
 // Reply assigned by a Collector implementation using CollectMetrics()
 type CollectMetricsReply struct {
 	PluginMetrics []MetricType
+	// ContentEncoding is set when PluginMetrics were too large and were
+	// encoded in snap.gob and compressed into Content instead.
+	ContentEncoding string `json:",omitempty"`
+	Content         []byte `json:",omitempty"`
+	// Transfer is set, alone, when the encoded reply exceeded
+	// Arg.ChunkSize.  The reply is then fetched with FetchTransfer.
+	Transfer *Transfer `json:",omitempty"`
+	// RequestID is the RequestID of the args
+	RequestID string `json:",omitempty"`
 }
 
 // GetMetricTypesArgs args passed to GetMetricTypes
 type GetMetricTypesArgs struct {
 	PluginConfig ConfigType
+	Token        string
+	// Prefix limits the reply to metrics whose namespace starts with it.
+	// An empty prefix returns the whole catalog.
+	Prefix []string
+	// Limit caps the number of metrics in the reply.  Zero disables paging.
+	Limit int
+	// Continue is the token from the previous reply's Continue field.
+	Continue string
+	// Since restricts the reply to metrics added or changed after the given
+	// time, taken from a previous reply's Timestamp.  A zero time returns
+	// the full catalog.
+	Since time.Time
+	// RequestID identifies the call, see CollectMetricsArgs.RequestID
+	RequestID string `json:",omitempty"`
 }
 
 // GetMetricTypesReply assigned by GetMetricTypes() implementation
 type GetMetricTypesReply struct {
 	MetricTypes []MetricType
+	// More is set when further pages remain; pass Continue back to fetch
+	// the next one.
+	More     bool
+	Continue string
+	// Removed lists the namespaces dropped from the catalog after Since.
+	Removed []core.Namespace
+	// Full is set when the reply holds the full catalog although Since was
+	// given, because the plugin no longer knows every removal after Since.
+	// The caller should replace its catalog rather than update it.
+	Full bool `json:",omitempty"`
+	// Timestamp is the catalog time of this reply, to be passed as Since on
+	// the next incremental request.
+	Timestamp time.Time
+	// RequestID is the RequestID of the args
+	RequestID string `json:",omitempty"`
 }
 
 type collectorPluginProxy struct {
 	Plugin  CollectorPlugin
 	Session Session
+	Meta    *PluginMeta
+	// cache, when the plugin has a CacheTTL, serves repeated collections
+	cache *metricCache
+	// config holds the config set with SetConfig
+	config *configStore
+	// compressor compresses large replies
+	compressor *contentCompressor
+	// chunks holds the replies too large to be sent at once
+	chunks *chunkStore
+	// suspension fails collections while the session is suspended
+	suspension *suspension
+	// init fails collections until the plugin's Init has succeeded
+	init *initialization
+	// requests follows the request IDs of the calls served
+	requests *requestTracker
+	// base is the parent context of the calls
+	base *sessionContext
+
+	catalogOnce sync.Once
+	catalog     *catalogTracker
+
+	subscriptionsOnce sync.Once
+	subscriptions     *subscriptionTracker
 }
 
-func (c *collectorPluginProxy) GetMetricTypes(args []byte, reply *[]byte) error {
-	defer catchPluginPanic(c.Session.Logger())
+func (c *collectorPluginProxy) GetMetricTypes(args []byte, reply *[]byte) (err error) {
+	call := c.requests.start("Collector.GetMetricTypes")
+	defer call.end(&err)
+	defer c.Session.recoverPanic("Collector.GetMetricTypes", &err)
 
-	c.Session.Logger().Debugln("GetMetricTypes called")
-	// Reset heartbeat
-	c.Session.ResetHeartbeat()
+	c.Session.Logger().Debugf("GetMetricTypes called")
 
 	dargs := &GetMetricTypesArgs{PluginConfig: ConfigType{ConfigDataNode: cdata.NewNode()}}
 	c.Session.Decode(args, dargs)
+	call.setID(dargs.RequestID)
+	if err := c.Session.CheckToken(dargs.Token); err != nil {
+		return err
+	}
+	// Reset heartbeat
+	c.Session.ResetHeartbeat()
+	if err := call.beginCall(c.base.context(), c.Session); err != nil {
+		return err
+	}
+	defer c.Session.endCall()
 
 	mts, err := c.Plugin.GetMetricTypes(dargs.PluginConfig)
 	if err != nil {
-		return errors.New(fmt.Sprintf("GetMetricTypes call error : %s", err.Error()))
+		return &PluginError{Code: ErrorCodeCallFailed, Message: fmt.Sprintf("GetMetricTypes call error : %s", err.Error())}
+	}
+	// Metrics which were not explicitly versioned get the plugin version
+	if c.Meta != nil {
+		for i := range mts {
+			if mts[i].Version() == 0 {
+				mts[i].SetVersion(c.Meta.Version)
+			}
+		}
+	}
+	c.catalogOnce.Do(func() {
+		if c.catalog == nil {
+			c.catalog = newCatalogTracker()
+		}
+	})
+	r := GetMetricTypesReply{Timestamp: c.catalog.update(mts), RequestID: dargs.RequestID}
+	if !dargs.Since.IsZero() {
+		removed, ok := c.catalog.removedSince(dargs.Since)
+		r.Full = !ok
+		if ok {
+			changed := []MetricType{}
+			for _, mt := range mts {
+				if mt.LastAdvertisedTime().After(dargs.Since) {
+					changed = append(changed, mt)
+				}
+			}
+			mts = changed
+		}
+		for _, ns := range removed {
+			if len(dargs.Prefix) == 0 || (MetricType{Namespace_: ns}).MatchPrefix(dargs.Prefix) {
+				r.Removed = append(r.Removed, ns)
+			}
+		}
+	}
+	if len(dargs.Prefix) > 0 {
+		mts = filterMetricTypes(mts, dargs.Prefix)
 	}
 
-	r := GetMetricTypesReply{MetricTypes: mts}
+	r.MetricTypes = mts
+	if dargs.Limit > 0 {
+		r.MetricTypes, r.Continue, r.More = pageMetricTypes(mts, dargs.Limit, dargs.Continue)
+	}
 	*reply, err = c.Session.Encode(r)
 	if err != nil {
 		return err
@@ -75,24 +197,161 @@ func (c *collectorPluginProxy) GetMetricTypes(args []byte, reply *[]byte) error
 	return nil
 }
 
-func (c *collectorPluginProxy) CollectMetrics(args []byte, reply *[]byte) error {
-	defer catchPluginPanic(c.Session.Logger())
-	c.Session.Logger().Debugln("CollectMetrics called")
-	// Reset heartbeat
-	c.Session.ResetHeartbeat()
+// filterMetricTypes returns the metrics matching the namespace prefix.
+func filterMetricTypes(mts []MetricType, prefix []string) []MetricType {
+	filtered := []MetricType{}
+	for _, mt := range mts {
+		if mt.MatchPrefix(prefix) {
+			filtered = append(filtered, mt)
+		}
+	}
+	return filtered
+}
+
+// catalogKey orders metrics by namespace and then version.  The separator
+// sorts before any namespace character so a namespace precedes its children.
+func catalogKey(mt MetricType) string {
+	return fmt.Sprintf("%s\x00%010d", mt.Namespace().String(), mt.Version())
+}
+
+type catalogEntry struct {
+	key string
+	mt  MetricType
+}
+
+type byCatalogKey []catalogEntry
+
+func (b byCatalogKey) Len() int           { return len(b) }
+func (b byCatalogKey) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byCatalogKey) Less(i, j int) bool { return b[i].key < b[j].key }
+
+// pageMetricTypes returns up to limit metrics ordered after the continue
+// token.  Pages are cut on the sorted catalog key rather than an offset so
+// the sequence stays consistent when the catalog is regenerated between calls.
+// Metrics advertised more than once under the same namespace and version are
+// told apart by their occurrence, so none is skipped at a page boundary.
+func pageMetricTypes(mts []MetricType, limit int, cont string) ([]MetricType, string, bool) {
+	entries := make(byCatalogKey, 0, len(mts))
+	seen := make(map[string]int, len(mts))
+	for _, mt := range mts {
+		k := catalogKey(mt)
+		n := seen[k]
+		seen[k]++
+		if n > 0 {
+			k = fmt.Sprintf("%s\x00%010d", k, n)
+		}
+		if k > cont {
+			entries = append(entries, catalogEntry{key: k, mt: mt})
+		}
+	}
+	sort.Sort(entries)
+	more := len(entries) > limit
+	if more {
+		entries = entries[:limit]
+	}
+	page := make([]MetricType, len(entries))
+	for i, e := range entries {
+		page[i] = e.mt
+	}
+	if !more {
+		return page, "", false
+	}
+	return page, entries[limit-1].key, true
+}
+
+func (c *collectorPluginProxy) CollectMetrics(args []byte, reply *[]byte) (err error) {
+	call := c.requests.start("Collector.CollectMetrics")
+	defer call.end(&err)
+	defer c.Session.recoverPanic("Collector.CollectMetrics", &err)
+	c.Session.Logger().Debugf("CollectMetrics called")
 
 	dargs := &CollectMetricsArgs{}
 	c.Session.Decode(args, dargs)
+	call.setID(dargs.RequestID)
+	call.setNamespaces(dargs.MetricTypes)
+	if err := c.Session.CheckToken(dargs.Token); err != nil {
+		return err
+	}
+	// Reset heartbeat
+	c.Session.ResetHeartbeat()
+	if err := c.suspension.check(); err != nil {
+		return err
+	}
+	if err := c.init.check(); err != nil {
+		return err
+	}
+	ctx, cancel := c.base.callContext(dargs.RequestID, dargs.Deadline)
+	defer cancel()
+	if err := checkDeadline(ctx); err != nil {
+		return err
+	}
+	if err := call.beginCall(ctx, c.Session); err != nil {
+		return err
+	}
+	defer c.Session.endCall()
 
-	ms, err := c.Plugin.CollectMetrics(dargs.MetricTypes)
+	for _, mt := range dargs.MetricTypes {
+		if mt.Config_ != nil {
+			openConfig(mt.Config_.Table(), c.Session.decrypter())
+		}
+	}
+	c.config.mergeMetrics(dargs.MetricTypes)
+
+	var ms []MetricType
+	err = callWithDeadline(ctx, c.Session, "Collector.CollectMetrics", func(ctx context.Context) (err error) {
+		p := collectorWithContext(ctx, c.Plugin)
+		if c.cache != nil {
+			ms, err = c.cache.collect(p, dargs.MetricTypes)
+		} else {
+			ms, err = p.CollectMetrics(dargs.MetricTypes)
+		}
+		if err != nil {
+			return &PluginError{Code: ErrorCodeCallFailed, Message: fmt.Sprintf("CollectMetrics call error : %s", err.Error())}
+		}
+		return nil
+	})
 	if err != nil {
-		return errors.New(fmt.Sprintf("CollectMetrics call error : %s", err.Error()))
+		return err
 	}
 
-	r := CollectMetricsReply{PluginMetrics: ms}
-	*reply, err = c.Session.Encode(r)
+	*reply, err = c.Session.Encode(CollectMetricsReply{PluginMetrics: ms, RequestID: dargs.RequestID})
 	if err != nil {
 		return err
 	}
-	return nil
+	// the metrics are only encoded again when the reply is large enough
+	// to be sent compressed
+	if len(ms) > 0 && c.compressor.exceeds(len(*reply)) {
+		b, err := EncodeMetrics(SnapGOBContentType, ms)
+		if err != nil {
+			return err
+		}
+		if b, err = CompressContent(c.compressor.encoding, b); err != nil {
+			return err
+		}
+		*reply, err = c.Session.Encode(CollectMetricsReply{ContentEncoding: c.compressor.encoding, Content: b, RequestID: dargs.RequestID})
+		if err != nil {
+			return err
+		}
+	}
+	t, err := c.chunks.put(*reply)
+	if err != nil {
+		return err
+	}
+	if t != nil {
+		*reply, err = c.Session.Encode(CollectMetricsReply{Transfer: t, RequestID: dargs.RequestID})
+	}
+	return err
+}
+
+// Metrics returns the collected metrics, decompressing them when they were
+// compressed into Content.
+func (r *CollectMetricsReply) Metrics() ([]MetricType, error) {
+	if r.ContentEncoding == NoContentEncoding {
+		return r.PluginMetrics, nil
+	}
+	b, err := DecompressContent(r.ContentEncoding, r.Content)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeMetrics(SnapGOBContentType, b)
 }
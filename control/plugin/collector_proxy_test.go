@@ -23,12 +23,18 @@ package plugin
 
 import (
 	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
 	"testing"
 	"time"
 
 	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
 	"github.com/intelsdi-x/snap/control/plugin/encoding"
 	"github.com/intelsdi-x/snap/core"
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/core/ctypes"
 
 	log "github.com/Sirupsen/logrus"
 	. "github.com/smartystreets/goconvey/convey"
@@ -79,6 +85,46 @@ func (p *mockPlugin) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
 	return cp, nil
 }
 
+// versionedPlugin advertises one metric without a version and one with an
+// explicit version.
+type versionedPlugin struct {
+	mockPlugin
+}
+
+func (p *versionedPlugin) GetMetricTypes(cfg ConfigType) ([]MetricType, error) {
+	m1 := NewMetricType(core.NewNamespace("foo", "bar"), time.Now(), nil, "", nil)
+	m2 := NewMetricType(core.NewNamespace("foo", "baz"), time.Now(), nil, "", nil)
+	m2.SetVersion(3)
+	return []MetricType{*m1, *m2}, nil
+}
+
+// catalogPlugin advertises a large catalog spread over several namespaces.
+type catalogPlugin struct {
+	mockPlugin
+	size int
+}
+
+func (p *catalogPlugin) GetMetricTypes(cfg ConfigType) ([]MetricType, error) {
+	groups := []string{"cpu", "mem", "disk", "net"}
+	mts := make([]MetricType, 0, p.size)
+	for i := 0; i < p.size; i++ {
+		ns := core.NewNamespace("intel", "psutil", groups[i%len(groups)], fmt.Sprintf("metric%d", i))
+		mts = append(mts, *NewMetricType(ns, time.Now(), nil, "", nil))
+	}
+	mts = append(mts, *NewMetricType(core.NewNamespace("intel").AddDynamicElement("host", "host name").AddStaticElement("load"), time.Now(), nil, "", nil))
+	return mts, nil
+}
+
+// pagedPlugin advertises whatever catalog the test currently holds.
+type pagedPlugin struct {
+	mockPlugin
+	mts []MetricType
+}
+
+func (p *pagedPlugin) GetMetricTypes(cfg ConfigType) ([]MetricType, error) {
+	return p.mts, nil
+}
+
 type mockErrorPlugin struct {
 }
 
@@ -121,6 +167,144 @@ func TestCollectorProxy(t *testing.T) {
 			So(mtr.MetricTypes[0].Namespace().String(), ShouldResemble, "/foo/*/bar")
 
 		})
+		Convey("Get Metric Types defaults the version to the plugin version", func() {
+			vp := &versionedPlugin{}
+			vc := &collectorPluginProxy{
+				Plugin:  vp,
+				Session: mockSessionState,
+				Meta:    &PluginMeta{Name: "mock", Version: 7},
+			}
+			var reply []byte
+			err := vc.GetMetricTypes([]byte{}, &reply)
+			So(err, ShouldBeNil)
+			var mtr GetMetricTypesReply
+			err = vc.Session.Decode(reply, &mtr)
+			So(err, ShouldBeNil)
+			So(mtr.MetricTypes[0].Version(), ShouldEqual, 7)
+			So(mtr.MetricTypes[1].Version(), ShouldEqual, 3)
+		})
+		Convey("Get Metric Types filtered by namespace prefix", func() {
+			cc := &collectorPluginProxy{
+				Plugin:  &catalogPlugin{size: 10000},
+				Session: mockSessionState,
+			}
+			getMetricTypes := func(prefix []string) []MetricType {
+				args, err := cc.Session.Encode(GetMetricTypesArgs{PluginConfig: NewPluginConfigType(), Prefix: prefix})
+				So(err, ShouldBeNil)
+				var reply []byte
+				err = cc.GetMetricTypes(args, &reply)
+				So(err, ShouldBeNil)
+				var mtr GetMetricTypesReply
+				err = cc.Session.Decode(reply, &mtr)
+				So(err, ShouldBeNil)
+				return mtr.MetricTypes
+			}
+			all := getMetricTypes(nil)
+			So(len(all), ShouldEqual, 10001)
+
+			cpu := getMetricTypes([]string{"intel", "psutil", "cpu"})
+			So(len(cpu), ShouldEqual, 2500)
+			want := map[string]bool{}
+			for _, mt := range all {
+				if ns := mt.Namespace().Strings(); len(ns) > 3 && ns[2] == "cpu" {
+					want[mt.Namespace().String()] = true
+				}
+			}
+			So(len(want), ShouldEqual, len(cpu))
+			for _, mt := range cpu {
+				So(mt.Namespace().Strings()[:3], ShouldResemble, []string{"intel", "psutil", "cpu"})
+				So(want[mt.Namespace().String()], ShouldBeTrue)
+			}
+
+			Convey("matching dynamic elements", func() {
+				mts := getMetricTypes([]string{"intel", "host1"})
+				So(len(mts), ShouldEqual, 1)
+				So(mts[0].Namespace().String(), ShouldEqual, "/intel/*/load")
+
+				mts = getMetricTypes([]string{"intel", "*", "mem"})
+				So(len(mts), ShouldEqual, 2500)
+			})
+			Convey("a prefix longer than any namespace matches nothing", func() {
+				mts := getMetricTypes([]string{"intel", "psutil", "cpu", "metric0", "extra"})
+				So(mts, ShouldBeEmpty)
+			})
+		})
+		Convey("Get Metric Types in pages", func() {
+			pp := &pagedPlugin{}
+			for i := 0; i < 6; i++ {
+				pp.mts = append(pp.mts, *NewMetricType(core.NewNamespace("foo", fmt.Sprintf("m%d", i)), time.Now(), nil, "", 1))
+			}
+			// advertise out of order; pages are sorted by namespace
+			pp.mts[0], pp.mts[5] = pp.mts[5], pp.mts[0]
+			pc := &collectorPluginProxy{
+				Plugin:  pp,
+				Session: mockSessionState,
+			}
+			getPage := func(limit int, cont string) GetMetricTypesReply {
+				args, err := pc.Session.Encode(GetMetricTypesArgs{PluginConfig: NewPluginConfigType(), Limit: limit, Continue: cont})
+				So(err, ShouldBeNil)
+				var reply []byte
+				err = pc.GetMetricTypes(args, &reply)
+				So(err, ShouldBeNil)
+				var mtr GetMetricTypesReply
+				err = pc.Session.Decode(reply, &mtr)
+				So(err, ShouldBeNil)
+				return mtr
+			}
+			names := func(mts []MetricType) []string {
+				out := []string{}
+				for _, mt := range mts {
+					out = append(out, mt.Namespace()[1].Value)
+				}
+				return out
+			}
+			Convey("catalog size an exact multiple of the page size", func() {
+				p1 := getPage(3, "")
+				So(names(p1.MetricTypes), ShouldResemble, []string{"m0", "m1", "m2"})
+				So(p1.More, ShouldBeTrue)
+				p2 := getPage(3, p1.Continue)
+				So(names(p2.MetricTypes), ShouldResemble, []string{"m3", "m4", "m5"})
+				So(p2.More, ShouldBeFalse)
+			})
+			Convey("last page shorter than the page size", func() {
+				p1 := getPage(4, "")
+				So(p1.More, ShouldBeTrue)
+				p2 := getPage(4, p1.Continue)
+				So(names(p2.MetricTypes), ShouldResemble, []string{"m4", "m5"})
+				So(p2.More, ShouldBeFalse)
+				So(p2.Continue, ShouldEqual, "")
+			})
+			Convey("catalog shrinking between pages", func() {
+				p1 := getPage(2, "")
+				So(names(p1.MetricTypes), ShouldResemble, []string{"m0", "m1"})
+				// drop m1, which was already returned, and m3, which was not
+				pp.mts = []MetricType{pp.mts[0], pp.mts[2], pp.mts[4], pp.mts[5]}
+				p2 := getPage(2, p1.Continue)
+				So(names(p2.MetricTypes), ShouldResemble, []string{"m2", "m4"})
+				So(p2.More, ShouldBeTrue)
+				p3 := getPage(2, p2.Continue)
+				So(names(p3.MetricTypes), ShouldResemble, []string{"m5"})
+				So(p3.More, ShouldBeFalse)
+			})
+			Convey("metrics advertised twice across a page boundary", func() {
+				dup := *NewMetricType(core.NewNamespace("foo", "m1"), time.Now(), nil, "", 1)
+				dup.Tags_ = map[string]string{"copy": "2"}
+				pp.mts = append(pp.mts, dup)
+				p1 := getPage(2, "")
+				So(names(p1.MetricTypes), ShouldResemble, []string{"m0", "m1"})
+				p2 := getPage(2, p1.Continue)
+				So(names(p2.MetricTypes), ShouldResemble, []string{"m1", "m2"})
+				So(p2.MetricTypes[0].Tags(), ShouldResemble, map[string]string{"copy": "2"})
+				p3 := getPage(4, p2.Continue)
+				So(names(p3.MetricTypes), ShouldResemble, []string{"m3", "m4", "m5"})
+				So(p3.More, ShouldBeFalse)
+			})
+			Convey("no limit returns everything", func() {
+				p := getPage(0, "")
+				So(len(p.MetricTypes), ShouldEqual, 6)
+				So(p.More, ShouldBeFalse)
+			})
+		})
 		Convey("Get error in Get Metric Type", func() {
 			mockErrorPlugin := &mockErrorPlugin{}
 			errC := &collectorPluginProxy{
@@ -129,7 +313,7 @@ func TestCollectorProxy(t *testing.T) {
 			}
 			var reply []byte
 			err := errC.GetMetricTypes([]byte{}, &reply)
-			So(err.Error(), ShouldResemble, "GetMetricTypes call error : Error in get Metric Type")
+			So(err.Error(), ShouldResemble, "[call-failed] GetMetricTypes call error : Error in get Metric Type")
 		})
 		Convey("Collect Metric ", func() {
 			args := CollectMetricsArgs{
@@ -164,3 +348,134 @@ func TestCollectorProxy(t *testing.T) {
 
 	})
 }
+
+func TestCollectorProxyRPC(t *testing.T) {
+	Convey("Test collector plugin proxy over net/rpc", t, func() {
+		mockSessionState := &MockSessionState{
+			Encoder:             encoding.NewGobEncoder(),
+			listenPort:          "0",
+			token:               "abcdef",
+			logger:              log.New(),
+			PingTimeoutDuration: time.Millisecond * 100,
+			killChan:            make(chan int),
+		}
+		server := rpc.NewServer()
+		err := server.RegisterName("Collector", &collectorPluginProxy{
+			Plugin:  &mockPlugin{},
+			Session: mockSessionState,
+		})
+		So(err, ShouldBeNil)
+		cconn, sconn := net.Pipe()
+		go server.ServeConn(sconn)
+		client := rpc.NewClient(cconn)
+		defer client.Close()
+
+		Convey("Get Metric Types", func() {
+			args, err := mockSessionState.Encode(GetMetricTypesArgs{PluginConfig: NewPluginConfigType()})
+			So(err, ShouldBeNil)
+			var reply []byte
+			err = client.Call("Collector.GetMetricTypes", args, &reply)
+			So(err, ShouldBeNil)
+			var mtr GetMetricTypesReply
+			err = mockSessionState.Decode(reply, &mtr)
+			So(err, ShouldBeNil)
+			So(len(mtr.MetricTypes), ShouldEqual, len(mockMetricType))
+			So(mtr.MetricTypes[0].Namespace().String(), ShouldEqual, "/foo/*/bar")
+			So(mtr.MetricTypes[1].Namespace(), ShouldResemble, core.NewNamespace("foo", "baz"))
+		})
+		Convey("Collect Metrics", func() {
+			args, err := mockSessionState.Encode(CollectMetricsArgs{MetricTypes: mockMetricType})
+			So(err, ShouldBeNil)
+			var reply []byte
+			err = client.Call("Collector.CollectMetrics", args, &reply)
+			So(err, ShouldBeNil)
+			var cmr CollectMetricsReply
+			err = mockSessionState.Decode(reply, &cmr)
+			So(err, ShouldBeNil)
+			So(len(cmr.PluginMetrics), ShouldEqual, len(mockMetricType))
+			So(cmr.PluginMetrics[0].Namespace().String(), ShouldEqual, "/foo/test/bar")
+			So(cmr.PluginMetrics[0].Data(), ShouldEqual, "data")
+		})
+		Convey("Collect Metrics returns the plugin error", func() {
+			errServer := rpc.NewServer()
+			errServer.RegisterName("Collector", &collectorPluginProxy{
+				Plugin:  &mockErrorPlugin{},
+				Session: mockSessionState,
+			})
+			econn, esconn := net.Pipe()
+			go errServer.ServeConn(esconn)
+			errClient := rpc.NewClient(econn)
+			defer errClient.Close()
+			args, err := mockSessionState.Encode(CollectMetricsArgs{MetricTypes: mockMetricType})
+			So(err, ShouldBeNil)
+			var reply []byte
+			err = errClient.Call("Collector.CollectMetrics", args, &reply)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "[call-failed] CollectMetrics call error : Error in collect Metric")
+		})
+	})
+}
+
+// configRecorder records the config of each metric it collects
+type configRecorder struct {
+	mockPlugin
+	configs map[string]*cdata.ConfigDataNode
+}
+
+func (p *configRecorder) CollectMetrics(mts []MetricType) ([]MetricType, error) {
+	for _, mt := range mts {
+		p.configs[mt.Namespace().String()] = mt.Config()
+		mt.Data_ = 1
+	}
+	return mts, nil
+}
+
+func TestCollectMetricConfig(t *testing.T) {
+	for _, codec := range []string{GobCodec, JSONCodec} {
+		Convey(fmt.Sprintf("Metrics collected over the %s codec", codec), t, func() {
+			p := &configRecorder{configs: map[string]*cdata.ConfigDataNode{}}
+			m := NewPluginMeta("config", 1, CollectorPluginType, nil, nil, Unsecure(true))
+			resp, done := startTestPlugin(m, p, fmt.Sprintf(`{"Codec": %q, "KillDelay": 1, "PingTimeoutDuration": %d}`, codec, time.Minute))
+			conn, err := net.Dial("tcp", resp.ListenAddress)
+			So(err, ShouldBeNil)
+			var client *rpc.Client
+			var enc encoding.Encoder
+			if codec == JSONCodec {
+				client, enc = jsonrpc.NewClient(conn), encoding.NewJsonEncoder()
+			} else {
+				client, enc = rpc.NewClient(conn), encoding.NewGobEncoder()
+			}
+			defer client.Close()
+
+			data, tmp := cdata.NewNode(), cdata.NewNode()
+			data.AddItem("mountpoint", ctypes.ConfigValueStr{Value: "/data"})
+			tmp.AddItem("mountpoint", ctypes.ConfigValueStr{Value: "/tmp"})
+			tmp.AddItem("recursive", ctypes.ConfigValueBool{Value: true})
+			args, err := enc.Encode(CollectMetricsArgs{
+				Token: resp.Token,
+				MetricTypes: []MetricType{
+					{Namespace_: core.NewNamespace("foo", "bar"), Config_: data},
+					{Namespace_: core.NewNamespace("foo", "baz"), Config_: tmp},
+					{Namespace_: core.NewNamespace("foo", "qux")},
+				},
+			})
+			So(err, ShouldBeNil)
+			So(client.Call("Collector.CollectMetrics", args, &[]byte{}), ShouldBeNil)
+
+			So(p.configs, ShouldHaveLength, 3)
+			So(p.configs["/foo/bar"].Table(), ShouldResemble, map[string]ctypes.ConfigValue{
+				"mountpoint": ctypes.ConfigValueStr{Value: "/data"},
+			})
+			So(p.configs["/foo/baz"].Table(), ShouldResemble, map[string]ctypes.ConfigValue{
+				"mountpoint": ctypes.ConfigValueStr{Value: "/tmp"},
+				"recursive":  ctypes.ConfigValueBool{Value: true},
+			})
+			So(p.configs["/foo/qux"], ShouldBeNil)
+
+			in, err := enc.Encode(KillArgs{Reason: "test", Token: resp.Token})
+			So(err, ShouldBeNil)
+			So(client.Call("SessionState.Kill", in, &[]byte{}), ShouldBeNil)
+			<-done
+		})
+	}
+}
@@ -22,9 +22,19 @@ limitations under the License.
 package plugin
 
 import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
 	"github.com/intelsdi-x/snap/core"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -51,6 +61,8 @@ func TestStartCollector(t *testing.T) {
 	Convey("Collector", t, func() {
 		Convey("start with dynamic port", func() {
 			m := &PluginMeta{
+				Name:    "mock",
+				Version: 1,
 				RPCType: JSONRPC,
 				Type:    CollectorPluginType,
 			}
@@ -58,9 +70,114 @@ func TestStartCollector(t *testing.T) {
 			err, rc := Start(m, c, "{}")
 			So(err, ShouldBeNil)
 			So(rc, ShouldEqual, 0)
-			Convey("RPC service already registered", func() {
-				So(func() { Start(m, c, "{}") }, ShouldPanic)
+			Convey("start a second plugin in the same process", func() {
+				err, rc := Start(m, c, `{"NoDaemon": true}`)
+				So(err, ShouldBeNil)
+				So(rc, ShouldEqual, 0)
 			})
 		})
 	})
 }
+
+func TestStartCollectorServesRPC(t *testing.T) {
+	Convey("A collector started with Start", t, func() {
+		pr, pw := io.Pipe()
+		responseWriter = pw
+		defer func() { responseWriter = os.Stdout }()
+
+		// Find a free port to ask the plugin to listen on
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		_, port, _ := net.SplitHostPort(l.Addr().String())
+		l.Close()
+
+		m := NewPluginMeta("mock", 1, CollectorPluginType, []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+		args := fmt.Sprintf(`{"ListenPort": %q, "PingTimeoutDuration": %d}`, port, time.Minute)
+		type result struct {
+			err error
+			rc  int
+		}
+		done := make(chan result)
+		go func() {
+			err, rc := Start(m, new(MockPlugin), args)
+			done <- result{err, rc}
+		}()
+
+		line, err := bufio.NewReader(pr).ReadString('\n')
+		So(err, ShouldBeNil)
+		var resp Response
+		So(json.Unmarshal([]byte(line), &resp), ShouldBeNil)
+		So(resp.State, ShouldEqual, PluginSuccess)
+		So(resp.Type, ShouldEqual, CollectorPluginType)
+		So(resp.Token, ShouldNotBeEmpty)
+		So(strings.HasSuffix(resp.ListenAddress, ":"+port), ShouldBeTrue)
+
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer client.Close()
+		enc := encoding.NewGobEncoder()
+
+		var reply []byte
+		in, err := enc.Encode(PingArgs{Token: "bad"})
+		So(err, ShouldBeNil)
+		So(client.Call("SessionState.Ping", in, &reply), ShouldNotBeNil)
+		in, err = enc.Encode(PingArgs{Token: resp.Token})
+		So(err, ShouldBeNil)
+		So(client.Call("SessionState.Ping", in, &reply), ShouldBeNil)
+
+		in, err = enc.Encode(GetMetricTypesArgs{PluginConfig: NewPluginConfigType(), Token: resp.Token})
+		So(err, ShouldBeNil)
+		So(client.Call("Collector.GetMetricTypes", in, &reply), ShouldBeNil)
+		var mtr GetMetricTypesReply
+		So(enc.Decode(reply, &mtr), ShouldBeNil)
+		So(len(mtr.MetricTypes), ShouldEqual, 1)
+		So(mtr.MetricTypes[0].Namespace().String(), ShouldEqual, "/foo/bar")
+
+		in, err = enc.Encode(KillArgs{Reason: "test", Token: resp.Token})
+		So(err, ShouldBeNil)
+		So(client.Call("SessionState.Kill", in, &reply), ShouldBeNil)
+		select {
+		case r := <-done:
+			So(r.err, ShouldBeNil)
+			So(r.rc, ShouldEqual, 0)
+		case <-time.After(10 * time.Second):
+			t.Fatal("Start did not return after Kill")
+		}
+	})
+	Convey("Start returns an error", t, func() {
+		Convey("for an unsupported RPC type", func() {
+			m := &PluginMeta{
+				Name:     "mock",
+				Version:  1,
+				RPCType:  RPCType(42),
+				Type:     CollectorPluginType,
+				Unsecure: true,
+			}
+			err, rc := Start(m, new(MockPlugin), `{"NoDaemon": true}`)
+			So(err, ShouldEqual, ErrUnsupportedRPCType)
+			So(rc, ShouldEqual, 2)
+		})
+		Convey("for an unknown transport", func() {
+			m := NewPluginMeta("mock", 1, CollectorPluginType, []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+			err, rc := Start(m, new(MockPlugin), `{"NoDaemon": true, "Transport": "carrier-pigeon"}`)
+			So(err, ShouldEqual, ErrUnsupportedRPCType)
+			So(rc, ShouldEqual, 2)
+		})
+		Convey("when the listen port is in use", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			defer l.Close()
+			_, port, _ := net.SplitHostPort(l.Addr().String())
+			m := &PluginMeta{
+				Name:     "mock",
+				Version:  1,
+				RPCType:  NativeRPC,
+				Type:     CollectorPluginType,
+				Unsecure: true,
+			}
+			err, rc := Start(m, new(MockPlugin), fmt.Sprintf(`{"NoDaemon": true, "ListenPort": %q}`, port))
+			So(err, ShouldNotBeNil)
+			So(rc, ShouldEqual, 2)
+		})
+	})
+}
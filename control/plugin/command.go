@@ -0,0 +1,151 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrUsage is returned by Start when the plugin was run with a flag it does
+// not know, see RunCommand.
+var ErrUsage = errors.New("invalid command line")
+
+// exitUsage is the exit status of a plugin run with an unknown flag
+const exitUsage = 2
+
+const usage = `usage: %s [--version | --meta | --json | --replay FILE | --bench [options]]
+
+A Snap plugin is started by snapteld with its arguments.  Run by hand it
+diagnoses itself, calling the plugin once and printing the results.
+
+  --version  print the name and version of the plugin
+  --meta     print the metadata and capabilities of the plugin as JSON
+  --json     print the diagnostic of the plugin as JSON
+  --replay   replay the calls recorded in FILE and print those whose
+             reply differs, see Arg.RPCRecordFile
+  --bench    measure the throughput and latency of CollectMetrics, with
+             --duration D, --iterations N, --concurrency N, --prefix NS
+             and --json
+`
+
+// RequestFromArgs returns the request string control passes a plugin as
+// its first argument, or "" when the plugin was run by hand without one.
+// A plugin's main passes it to Start.
+func RequestFromArgs() string {
+	if len(os.Args) < 2 {
+		return ""
+	}
+	return os.Args[1]
+}
+
+// isCommand returns whether the request string given to Start is not from
+// control, the plugin being run by hand with no argument or with flags.
+func isCommand(requestString string) bool {
+	return strings.TrimSpace(requestString) == "" || strings.HasPrefix(requestString, "-")
+}
+
+// commandArgs returns the command line of a plugin run by hand with
+// requestString.
+func commandArgs(requestString string) []string {
+	if len(os.Args) > 1 && os.Args[1] == requestString {
+		return os.Args[1:]
+	}
+	if strings.TrimSpace(requestString) == "" {
+		return nil
+	}
+	return []string{requestString}
+}
+
+// RunCommand serves the command line args of a plugin run by hand, before
+// any session is set up, and returns its exit status.  --version prints
+// the name and version of the plugin, --meta its PluginMeta and
+// capabilities as JSON, and no flag or --json its diagnostic, see Diagnose.
+// --replay FILE replays a recording, see ReplayRPC, and returns 1 when a
+// reply differs.  --bench benchmarks a collector, see Bench.  Other flags
+// print the usage to errOut and return 2.
+func RunCommand(m *PluginMeta, c Plugin, args []string, in io.Reader, out, errOut io.Writer) int {
+	flag := ""
+	if len(args) > 0 {
+		flag = args[0]
+	}
+	switch flag {
+	case "", "--json":
+		return Diagnose(m, c, args, in, out)
+	case "--version":
+		fmt.Fprintf(out, "%s %d\n", m.Name, m.Version)
+		return 0
+	case "--meta":
+		b, err := json.MarshalIndent(newPluginInfo(m, c), "", "  ")
+		if err != nil {
+			fmt.Fprintf(errOut, "unable to encode the plugin meta: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(out, "%s\n", b)
+		return 0
+	case "--bench":
+		return runBench(m, c, args[1:], out, errOut)
+	case "--replay":
+		if len(args) < 2 {
+			fmt.Fprintf(errOut, "--replay needs the recorded file\n")
+			fmt.Fprintf(errOut, usage, commandName())
+			return exitUsage
+		}
+		diffs, err := ReplayRPC(args[1], m, c, out)
+		if err != nil {
+			fmt.Fprintf(errOut, "unable to replay %s: %v\n", args[1], err)
+			return 1
+		}
+		fmt.Fprintf(out, "%d calls differ\n", diffs)
+		if diffs > 0 {
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(errOut, "unknown flag %s\n", flag)
+	fmt.Fprintf(errOut, usage, commandName())
+	return exitUsage
+}
+
+func commandName() string {
+	if len(os.Args) == 0 {
+		return "plugin"
+	}
+	return os.Args[0]
+}
+
+// pluginInfo is printed by --meta: the meta of the plugin and the
+// capabilities of a session started with the default arguments.
+type pluginInfo struct {
+	PluginMeta
+	Capabilities []string
+}
+
+func newPluginInfo(m *PluginMeta, c Plugin) pluginInfo {
+	info := pluginInfo{PluginMeta: *m}
+	if s, err, _ := NewSessionState("{}", c, m); err == nil {
+		info.Capabilities = s.capabilities(&Response{Type: m.Type, Meta: *m})
+	}
+	return info
+}
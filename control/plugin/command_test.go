@@ -0,0 +1,93 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRunCommand(t *testing.T) {
+	m := NewPluginMeta("toy", 3, CollectorPluginType, nil, nil, Unsecure(true))
+	run := func(args ...string) (int, string, string) {
+		var out, errOut bytes.Buffer
+		code := RunCommand(m, &toyCollector{}, args, nil, &out, &errOut)
+		return code, out.String(), errOut.String()
+	}
+
+	Convey("A plugin run by hand", t, func() {
+		Convey("with --version prints its name and version", func() {
+			code, out, errOut := run("--version")
+			So(code, ShouldEqual, 0)
+			So(out, ShouldEqual, "toy 3\n")
+			So(errOut, ShouldBeEmpty)
+		})
+		Convey("with --meta prints its meta and capabilities as JSON", func() {
+			code, out, _ := run("--meta")
+			So(code, ShouldEqual, 0)
+			var info struct {
+				Name         string
+				Version      int
+				Type         PluginType
+				Capabilities []string
+			}
+			So(json.Unmarshal([]byte(out), &info), ShouldBeNil)
+			So(info.Name, ShouldEqual, "toy")
+			So(info.Version, ShouldEqual, 3)
+			So(info.Type, ShouldEqual, CollectorPluginType)
+			So(info.Capabilities, ShouldContain, CapabilityMetricConfig)
+			So(info.Capabilities, ShouldContain, CapabilityStats)
+		})
+		Convey("ignores the arguments following its flag", func() {
+			code, out, _ := run("--version", "{not json")
+			So(code, ShouldEqual, 0)
+			So(out, ShouldEqual, "toy 3\n")
+		})
+		Convey("with an unknown flag prints the usage to stderr", func() {
+			code, out, errOut := run("--frobnicate")
+			So(code, ShouldEqual, 2)
+			So(out, ShouldBeEmpty)
+			So(errOut, ShouldStartWith, "unknown flag --frobnicate\nusage: ")
+			So(errOut, ShouldContainSubstring, "--meta")
+		})
+		Convey("without a flag diagnoses itself", func() {
+			code, out, _ := run()
+			So(code, ShouldEqual, 0)
+			So(out, ShouldContainSubstring, "CollectMetrics")
+		})
+	})
+	Convey("Start takes as a command", t, func() {
+		Convey("no request", func() {
+			So(isCommand(""), ShouldBeTrue)
+		})
+		Convey("a flag", func() {
+			So(isCommand("--version"), ShouldBeTrue)
+			So(commandArgs("--version"), ShouldResemble, []string{"--version"})
+		})
+		Convey("but not the arguments of control", func() {
+			So(isCommand(`{"NoDaemon": true}`), ShouldBeFalse)
+		})
+	})
+}
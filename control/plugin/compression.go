@@ -0,0 +1,185 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/golang/snappy"
+)
+
+// Content encodings of the payloads of PublishArgs, ProcessorArgs,
+// ProcessorReply and CollectMetricsReply
+const (
+	// NoContentEncoding is an uncompressed payload
+	NoContentEncoding = ""
+	// GzipContentEncoding is a payload compressed with gzip
+	GzipContentEncoding = "gzip"
+	// SnappyContentEncoding is a payload compressed in the snappy block
+	// format
+	SnappyContentEncoding = "snappy"
+)
+
+// ContentEncodings are the encodings this framework compresses and
+// decompresses, in order of preference.
+var ContentEncodings = []string{SnappyContentEncoding, GzipContentEncoding}
+
+// DefaultCompressThreshold is the size in bytes above which payloads are
+// compressed unless Arg.CompressThreshold says otherwise.
+const DefaultCompressThreshold = 64 * 1024
+
+// maxDecompressedContent bounds the size of a decompressed payload
+const maxDecompressedContent = 1 << 30
+
+// ContentEncodingError is returned for a payload which could not be
+// decompressed.
+type ContentEncodingError struct {
+	Encoding string
+	Err      error
+}
+
+func (e *ContentEncodingError) Error() string {
+	return fmt.Sprintf("corrupt %s content: %v", e.Encoding, e.Err)
+}
+
+// CompressContent returns b compressed with encoding.
+func CompressContent(encoding string, b []byte) ([]byte, error) {
+	switch encoding {
+	case NoContentEncoding:
+		return b, nil
+	case GzipContentEncoding:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case SnappyContentEncoding:
+		return snappy.Encode(nil, b), nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+}
+
+// DecompressContent returns the payload b compressed with encoding.  It
+// fails with a *ContentEncodingError when b is corrupt.
+func DecompressContent(encoding string, b []byte) ([]byte, error) {
+	switch encoding {
+	case NoContentEncoding:
+		return b, nil
+	case GzipContentEncoding:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, &ContentEncodingError{Encoding: encoding, Err: err}
+		}
+		out, err := ioutil.ReadAll(io.LimitReader(r, maxDecompressedContent+1))
+		if err == nil && len(out) > maxDecompressedContent {
+			err = fmt.Errorf("content exceeds %d bytes", maxDecompressedContent)
+		}
+		if err != nil {
+			return nil, &ContentEncodingError{Encoding: encoding, Err: err}
+		}
+		return out, nil
+	case SnappyContentEncoding:
+		n, err := snappy.DecodedLen(b)
+		if err == nil && n > maxDecompressedContent {
+			err = fmt.Errorf("content exceeds %d bytes", maxDecompressedContent)
+		}
+		if err != nil {
+			return nil, &ContentEncodingError{Encoding: encoding, Err: err}
+		}
+		out, err := snappy.Decode(nil, b)
+		if err != nil {
+			return nil, &ContentEncodingError{Encoding: encoding, Err: err}
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+}
+
+// SelectContentEncoding returns the first of ContentEncodings in
+// supported, the encodings of the peer, or NoContentEncoding.
+func SelectContentEncoding(supported []string) string {
+	for _, e := range ContentEncodings {
+		for _, s := range supported {
+			if e == s {
+				return e
+			}
+		}
+	}
+	return NoContentEncoding
+}
+
+// contentCompressor compresses the payloads of replies above threshold.  A
+// nil contentCompressor never compresses.
+type contentCompressor struct {
+	encoding  string
+	threshold int
+}
+
+// newContentCompressor returns the compressor of replies to a control
+// decompressing encodings, or nil when it does not decompress any.
+func newContentCompressor(encodings []string, threshold int) *contentCompressor {
+	e := SelectContentEncoding(encodings)
+	if e == NoContentEncoding || threshold < 0 {
+		return nil
+	}
+	if threshold == 0 {
+		threshold = DefaultCompressThreshold
+	}
+	return &contentCompressor{encoding: e, threshold: threshold}
+}
+
+// exceeds reports whether a payload of n bytes is compressed.
+func (c *contentCompressor) exceeds(n int) bool {
+	return c != nil && n > c.threshold
+}
+
+// compress returns b, compressed when it exceeds the threshold, and its
+// encoding.
+func (c *contentCompressor) compress(b []byte) ([]byte, string, error) {
+	if !c.exceeds(len(b)) {
+		return b, NoContentEncoding, nil
+	}
+	out, err := CompressContent(c.encoding, b)
+	if err != nil {
+		return nil, "", err
+	}
+	return out, c.encoding, nil
+}
+
+// decompressArgs returns the payload of args compressed with encoding, or a
+// PluginError.
+func decompressArgs(encoding string, b []byte) ([]byte, error) {
+	out, err := DecompressContent(encoding, b)
+	if err == nil {
+		return out, nil
+	}
+	if _, ok := err.(*ContentEncodingError); ok {
+		return nil, &PluginError{Code: ErrorCodeCorruptContent, Message: err.Error()}
+	}
+	return nil, &PluginError{Code: ErrorCodeUnsupported, Message: err.Error()}
+}
@@ -0,0 +1,269 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	"github.com/intelsdi-x/snap/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func compressionTestPayloads() map[string][]byte {
+	random := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(random)
+	metrics := make([]MetricType, 500)
+	for i := range metrics {
+		ns := core.NewNamespace("intel", "procfs", "meminfo", fmt.Sprintf("node-%d", i%7), "mem_available")
+		metrics[i] = *NewMetricType(ns, time.Now(), map[string]string{"plugin_running_on": "host-1"}, "B", i)
+	}
+	batch, err := EncodeMetrics(SnapJSONContentType, metrics)
+	if err != nil {
+		panic(err)
+	}
+	return map[string][]byte{
+		"empty":    {},
+		"short":    []byte("snap"),
+		"repeated": bytes.Repeat([]byte("a"), 70000),
+		"random":   random,
+		"metrics":  batch,
+	}
+}
+
+func TestContentEncoding(t *testing.T) {
+	for _, e := range ContentEncodings {
+		Convey(fmt.Sprintf("Content compressed with %s", e), t, func() {
+			Convey("round trips", func() {
+				for name, payload := range compressionTestPayloads() {
+					b, err := CompressContent(e, payload)
+					So(err, ShouldBeNil)
+					out, err := DecompressContent(e, b)
+					So(err, ShouldBeNil)
+					So(bytes.Equal(out, payload), ShouldBeTrue)
+					if name == "metrics" {
+						So(len(b), ShouldBeLessThan, len(payload)/4)
+					}
+				}
+			})
+			Convey("fails with a ContentEncodingError when corrupt", func() {
+				b, err := CompressContent(e, compressionTestPayloads()["metrics"])
+				So(err, ShouldBeNil)
+				for _, corrupt := range [][]byte{b[:len(b)/2], []byte("not compressed at all"), {0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}} {
+					_, err := DecompressContent(e, corrupt)
+					So(err, ShouldHaveSameTypeAs, &ContentEncodingError{})
+					So(err.Error(), ShouldStartWith, fmt.Sprintf("corrupt %s content", e))
+				}
+			})
+		})
+	}
+	Convey("snappy decodes literals and overlapping copies", t, func() {
+		// length 15, literal "abcd", 2 byte offset copy of 7 at 4, 1 byte
+		// offset copy of 4 at 1
+		out, err := DecompressContent(SnappyContentEncoding, []byte{15, 0x0c, 'a', 'b', 'c', 'd', 0x1a, 4, 0, 0x01, 1})
+		So(err, ShouldBeNil)
+		So(string(out), ShouldEqual, "abcdabcdabccccc")
+	})
+	Convey("An unknown content encoding is refused", t, func() {
+		_, err := CompressContent("br", []byte("snap"))
+		So(err, ShouldNotBeNil)
+		_, err = DecompressContent("br", []byte("snap"))
+		So(err, ShouldNotBeNil)
+		_, ok := err.(*ContentEncodingError)
+		So(ok, ShouldBeFalse)
+	})
+	Convey("SelectContentEncoding prefers snappy", t, func() {
+		So(SelectContentEncoding([]string{"gzip", "snappy"}), ShouldEqual, SnappyContentEncoding)
+		So(SelectContentEncoding([]string{"br", "gzip"}), ShouldEqual, GzipContentEncoding)
+		So(SelectContentEncoding(nil), ShouldEqual, NoContentEncoding)
+	})
+}
+
+func TestSessionCompression(t *testing.T) {
+	Convey("A collector replying to a control which decompresses gzip", t, func() {
+		m := NewPluginMeta("compress", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		resp, done := startTestPlugin(m, &mockPlugin{}, fmt.Sprintf(`{"ContentEncodings": ["gzip"], "CompressThreshold": 1, "PingTimeoutDuration": %d}`, time.Minute))
+		So(resp.ContentEncodings, ShouldResemble, ContentEncodings)
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer client.Close()
+
+		enc := encoding.NewGobEncoder()
+		in, err := enc.Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: resp.Token})
+		So(err, ShouldBeNil)
+		var out []byte
+		So(client.Call("Collector.CollectMetrics", in, &out), ShouldBeNil)
+		var r CollectMetricsReply
+		So(enc.Decode(out, &r), ShouldBeNil)
+		So(r.ContentEncoding, ShouldEqual, GzipContentEncoding)
+		So(r.PluginMetrics, ShouldBeEmpty)
+		ms, err := r.Metrics()
+		So(err, ShouldBeNil)
+		So(ms, ShouldHaveLength, len(mockMetricType))
+		So(ms[0].Data(), ShouldEqual, "data")
+
+		So(callKill(client, resp.Token), ShouldBeNil)
+		<-done
+	})
+	Convey("A collector does not compress for a control which does not decompress", t, func() {
+		m := NewPluginMeta("compress", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		resp, done := startTestPlugin(m, &mockPlugin{}, fmt.Sprintf(`{"CompressThreshold": 1, "PingTimeoutDuration": %d}`, time.Minute))
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer client.Close()
+
+		enc := encoding.NewGobEncoder()
+		in, err := enc.Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: resp.Token})
+		So(err, ShouldBeNil)
+		var out []byte
+		So(client.Call("Collector.CollectMetrics", in, &out), ShouldBeNil)
+		var r CollectMetricsReply
+		So(enc.Decode(out, &r), ShouldBeNil)
+		So(r.ContentEncoding, ShouldBeEmpty)
+		So(r.PluginMetrics, ShouldHaveLength, len(mockMetricType))
+
+		So(callKill(client, resp.Token), ShouldBeNil)
+		<-done
+	})
+	Convey("A collector compares the reply it encodes to the threshold", t, func() {
+		session := &MockSessionState{
+			Encoder:  encoding.NewGobEncoder(),
+			logger:   logrus.New(),
+			killChan: make(chan int),
+		}
+		c := &collectorPluginProxy{Plugin: &mockPlugin{}, Session: session}
+		in, err := session.Encode(CollectMetricsArgs{MetricTypes: mockMetricType})
+		So(err, ShouldBeNil)
+		collect := func() CollectMetricsReply {
+			var out []byte
+			So(c.CollectMetrics(in, &out), ShouldBeNil)
+			var r CollectMetricsReply
+			So(session.Decode(out, &r), ShouldBeNil)
+			return r
+		}
+		var plain []byte
+		So(c.CollectMetrics(in, &plain), ShouldBeNil)
+
+		c.compressor = newContentCompressor([]string{"snappy"}, len(plain))
+		r := collect()
+		So(r.ContentEncoding, ShouldBeEmpty)
+		So(r.PluginMetrics, ShouldHaveLength, len(mockMetricType))
+
+		c.compressor = newContentCompressor([]string{"snappy"}, len(plain)-1)
+		r = collect()
+		So(r.ContentEncoding, ShouldEqual, SnappyContentEncoding)
+		ms, err := r.Metrics()
+		So(err, ShouldBeNil)
+		So(ms, ShouldHaveLength, len(mockMetricType))
+	})
+	Convey("A processor", t, func() {
+		session := &MockSessionState{
+			Encoder:  encoding.NewGobEncoder(),
+			logger:   logrus.New(),
+			killChan: make(chan int),
+		}
+		p := &processorPluginProxy{Plugin: &passthruProcessor{}, Session: session, compressor: newContentCompressor([]string{"snappy"}, 10)}
+		content, ct, err := MarshalMetricTypes(SnapGOBContentType, mockMetricType)
+		So(err, ShouldBeNil)
+		process := func(args ProcessorArgs) (ProcessorReply, error) {
+			in, err := session.Encode(args)
+			So(err, ShouldBeNil)
+			var out []byte
+			if err := p.Process(in, &out); err != nil {
+				return ProcessorReply{}, err
+			}
+			var r ProcessorReply
+			So(session.Decode(out, &r), ShouldBeNil)
+			return r, nil
+		}
+		Convey("decompresses args and compresses its reply", func() {
+			gz, err := CompressContent(GzipContentEncoding, content)
+			So(err, ShouldBeNil)
+			r, err := process(ProcessorArgs{ContentType: ct, Content: gz, ContentEncoding: GzipContentEncoding})
+			So(err, ShouldBeNil)
+			So(r.ContentEncoding, ShouldEqual, SnappyContentEncoding)
+			b, err := DecompressContent(r.ContentEncoding, r.Content)
+			So(err, ShouldBeNil)
+			So(b, ShouldResemble, content)
+		})
+		Convey("refuses corrupt args with a typed error", func() {
+			_, err := process(ProcessorArgs{ContentType: ct, Content: content[:20], ContentEncoding: GzipContentEncoding})
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeCorruptContent)
+			So(err.Error(), ShouldContainSubstring, "corrupt gzip content")
+		})
+		Convey("refuses args in an unknown encoding", func() {
+			_, err := process(ProcessorArgs{ContentType: ct, Content: content, ContentEncoding: "br"})
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeUnsupported)
+		})
+	})
+	Convey("A publisher refuses corrupt args with a typed error", t, func() {
+		session := &MockSessionState{
+			Encoder:  encoding.NewGobEncoder(),
+			logger:   logrus.New(),
+			killChan: make(chan int),
+		}
+		p := &publisherPluginProxy{Plugin: new(MockPublisher), Session: session}
+		in, err := session.Encode(PublishArgs{ContentType: SnapGOBContentType, Content: []byte(strings.Repeat("x", 64)), ContentEncoding: SnappyContentEncoding})
+		So(err, ShouldBeNil)
+		var out []byte
+		err = p.Publish(in, &out)
+		So(ErrorCodeOf(err), ShouldEqual, ErrorCodeCorruptContent)
+	})
+}
+
+func benchmarkCompress(b *testing.B, encoding string) {
+	payload := compressionTestPayloads()["metrics"]
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := CompressContent(encoding, payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkDecompress(b *testing.B, encoding string) {
+	payload := compressionTestPayloads()["metrics"]
+	compressed, err := CompressContent(encoding, payload)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecompressContent(encoding, compressed); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompressGzip(b *testing.B)     { benchmarkCompress(b, GzipContentEncoding) }
+func BenchmarkCompressSnappy(b *testing.B)   { benchmarkCompress(b, SnappyContentEncoding) }
+func BenchmarkDecompressGzip(b *testing.B)   { benchmarkDecompress(b, GzipContentEncoding) }
+func BenchmarkDecompressSnappy(b *testing.B) { benchmarkDecompress(b, SnappyContentEncoding) }
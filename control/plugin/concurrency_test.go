@@ -0,0 +1,180 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// countingCollector records the most calls it ran at once, counting
+// collections and catalog calls alike
+type countingCollector struct {
+	mockPlugin
+
+	mutex   sync.Mutex
+	running int
+	most    int
+}
+
+func (c *countingCollector) CollectMetrics(mts []MetricType) ([]MetricType, error) {
+	c.run()
+	return mts, nil
+}
+
+func (c *countingCollector) GetMetricTypes(cfg ConfigType) ([]MetricType, error) {
+	c.run()
+	return c.mockPlugin.GetMetricTypes(cfg)
+}
+
+func (c *countingCollector) run() {
+	c.mutex.Lock()
+	c.running++
+	if c.running > c.most {
+		c.most = c.running
+	}
+	c.mutex.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	c.mutex.Lock()
+	c.running--
+	c.mutex.Unlock()
+}
+
+func TestConcurrencyCount(t *testing.T) {
+	const calls = 6
+	tests := []struct {
+		name string
+		opts []MetaOpt
+		most int
+	}{
+		{"no limit", nil, calls},
+		{"a ConcurrencyCount of 2", []MetaOpt{ConcurrencyCount(2)}, 2},
+		{"Exclusive", []MetaOpt{Exclusive(true)}, 1},
+	}
+	for _, test := range tests {
+		Convey(fmt.Sprintf("A collector with %s", test.name), t, func() {
+			opts := append([]MetaOpt{Unsecure(true)}, test.opts...)
+			m := NewPluginMeta("concurrency", 1, CollectorPluginType, nil, nil, opts...)
+			collector := &countingCollector{}
+			resp, done := startTestPlugin(m, collector, fmt.Sprintf(`{"PingTimeoutDuration": %d}`, time.Minute))
+			So(resp.Meta.ConcurrencyCount, ShouldEqual, m.ConcurrencyCount)
+			So(resp.Meta.Exclusive, ShouldEqual, m.Exclusive)
+			client, err := rpc.Dial("tcp", resp.ListenAddress)
+			So(err, ShouldBeNil)
+			defer client.Close()
+
+			in, err := encoding.NewGobEncoder().Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: resp.Token})
+			So(err, ShouldBeNil)
+			errs := make(chan error, calls)
+			for i := 0; i < calls; i++ {
+				go func() {
+					errs <- client.Call("Collector.CollectMetrics", in, &[]byte{})
+				}()
+			}
+			for i := 0; i < calls; i++ {
+				So(<-errs, ShouldBeNil)
+			}
+			if test.most == calls {
+				So(collector.most, ShouldBeGreaterThan, 1)
+			} else {
+				So(collector.most, ShouldEqual, test.most)
+			}
+			So(callKill(client, resp.Token), ShouldBeNil)
+			<-done
+		})
+	}
+}
+
+func TestExclusive(t *testing.T) {
+	const calls = 4
+	m := NewPluginMeta("exclusive", 1, CollectorPluginType, nil, nil, Unsecure(true), Exclusive(true))
+	enc := encoding.NewGobEncoder()
+	Convey("An Exclusive collector runs one call at a time", t, func() {
+		collector := &countingCollector{}
+		resp, done := startTestPlugin(m, collector, fmt.Sprintf(`{"PingTimeoutDuration": %d}`, time.Minute))
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer client.Close()
+
+		collect, err := enc.Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: resp.Token})
+		So(err, ShouldBeNil)
+		catalog, err := enc.Encode(GetMetricTypesArgs{PluginConfig: NewPluginConfigType(), Token: resp.Token})
+		So(err, ShouldBeNil)
+		errs := make(chan error, 2*calls)
+		for i := 0; i < calls; i++ {
+			go func() {
+				errs <- client.Call("Collector.CollectMetrics", collect, &[]byte{})
+			}()
+			go func() {
+				errs <- client.Call("Collector.GetMetricTypes", catalog, &[]byte{})
+			}()
+		}
+		for i := 0; i < 2*calls; i++ {
+			So(<-errs, ShouldBeNil)
+		}
+		So(collector.most, ShouldEqual, 1)
+		So(callKill(client, resp.Token), ShouldBeNil)
+		<-done
+	})
+	Convey("While an Exclusive collector runs a call", t, func() {
+		collector := &stuckCollector{release: make(chan struct{})}
+		resp, done := startTestPlugin(m, collector, fmt.Sprintf(`{"PingTimeoutDuration": %d, "LogLevel": "info"}`, time.Minute))
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer client.Close()
+
+		collect, err := enc.Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: resp.Token})
+		So(err, ShouldBeNil)
+		running := make(chan error, 1)
+		go func() {
+			running <- client.Call("Collector.CollectMetrics", collect, &[]byte{})
+		}()
+		for atomic.LoadInt32(&collector.calls) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		Convey("it is still pinged", func() {
+			ping, err := enc.Encode(PingArgs{Token: resp.Token})
+			So(err, ShouldBeNil)
+			So(client.Call("SessionState.Ping", ping, &[]byte{}), ShouldBeNil)
+		})
+		Convey("a waiting call fails at its deadline", func() {
+			late, err := enc.Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: resp.Token, Deadline: time.Now().Add(50 * time.Millisecond)})
+			So(err, ShouldBeNil)
+			err = client.Call("Collector.CollectMetrics", late, &[]byte{})
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeDeadlineExceeded)
+			So(atomic.LoadInt32(&collector.calls), ShouldEqual, 1)
+		})
+
+		close(collector.release)
+		So(<-running, ShouldBeNil)
+		So(callKill(client, resp.Token), ShouldBeNil)
+		<-done
+	})
+}
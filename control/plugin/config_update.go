@@ -0,0 +1,207 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+// ConfigUpdateHandler may be implemented by a plugin to apply a config set
+// with SetConfig, e.g. reconnect to a new endpoint.  An error rejects the
+// config and the previous one stays active.
+type ConfigUpdateHandler interface {
+	OnConfigUpdate(config map[string]ctypes.ConfigValue) error
+}
+
+// SetConfigArgs are the arguments of SetConfig.  They must be signed when
+// the plugin was started with a ControlPubKey, as a config may hold the
+// endpoint or credentials of a publisher.
+type SetConfigArgs struct {
+	Config map[string]ctypes.ConfigValue
+	Token  string
+	RequestSignature
+}
+
+// UnmarshalJSON restores the typed config values when SetConfigArgs are
+// received over JSON-RPC.
+func (a *SetConfigArgs) UnmarshalJSON(data []byte) error {
+	args := struct {
+		Config *cdata.ConfigDataNode
+		Token  string
+		RequestSignature
+	}{}
+	if err := json.Unmarshal(data, &args); err != nil {
+		return err
+	}
+	a.Token = args.Token
+	a.RequestSignature = args.RequestSignature
+	a.Config = nil
+	if args.Config != nil {
+		a.Config = args.Config.Table()
+	}
+	return nil
+}
+
+// SetConfigReply is the reply of SetConfig
+type SetConfigReply struct {
+	// Applied is set when the config replaced the previous one.  Otherwise
+	// Error tells why it was rejected.
+	Applied bool
+	Error   string
+	// Generation counts the configs applied during the session
+	Generation uint64
+}
+
+// sessionConfig is a config set with SetConfig.  It is never modified once
+// stored.
+type sessionConfig struct {
+	config     map[string]ctypes.ConfigValue
+	generation uint64
+}
+
+// configStore holds the config set with SetConfig.  Each call takes the
+// config current when it starts, so that a call in flight during an update
+// finishes with the config it started with.
+type configStore struct {
+	// update serializes SetConfig
+	update  sync.Mutex
+	current atomic.Value
+}
+
+func (c *configStore) load() sessionConfig {
+	if c == nil {
+		return sessionConfig{}
+	}
+	sc, _ := c.current.Load().(sessionConfig)
+	return sc
+}
+
+// merge returns config completed with the values set with SetConfig.  The
+// values sent with the call take precedence, as a task config does over
+// the plugin config.
+func (c *configStore) merge(config map[string]ctypes.ConfigValue) map[string]ctypes.ConfigValue {
+	stored := c.load().config
+	if len(stored) == 0 {
+		return config
+	}
+	m := make(map[string]ctypes.ConfigValue, len(stored)+len(config))
+	for k, v := range stored {
+		m[k] = v
+	}
+	for k, v := range config {
+		m[k] = v
+	}
+	return m
+}
+
+// mergeMetrics completes the config of each metric with the values set
+// with SetConfig.
+func (c *configStore) mergeMetrics(mts []MetricType) {
+	stored := c.load().config
+	if len(stored) == 0 {
+		return
+	}
+	for i := range mts {
+		if mts[i].Config_ == nil {
+			mts[i].Config_ = cdata.NewNode()
+		}
+		mts[i].Config_.ReverseMerge(cdata.FromTable(stored))
+	}
+}
+
+// set validates config against the root node of the plugin's policy, runs
+// the plugin's OnConfigUpdate and then makes config current.
+func (c *configStore) set(p Plugin, config map[string]ctypes.ConfigValue) SetConfigReply {
+	c.update.Lock()
+	defer c.update.Unlock()
+	prev := c.load()
+	reply := SetConfigReply{Generation: prev.generation}
+
+	policy, err := p.GetConfigPolicy()
+	if err != nil {
+		reply.Error = fmt.Sprintf("GetConfigPolicy call error : %s", err)
+		return reply
+	}
+	m := make(map[string]ctypes.ConfigValue, len(config))
+	for k, v := range config {
+		m[k] = v
+	}
+	if policy != nil {
+		res, perrs := policy.Get([]string{""}).Process(m)
+		if perrs.HasErrors() {
+			errs := make([]string, len(perrs.Errors()))
+			for i, e := range perrs.Errors() {
+				errs[i] = e.Error()
+			}
+			reply.Error = "invalid config: " + strings.Join(errs, "; ")
+			return reply
+		}
+		m = *res
+	}
+	if h, ok := p.(ConfigUpdateHandler); ok {
+		if err := h.OnConfigUpdate(m); err != nil {
+			reply.Error = fmt.Sprintf("OnConfigUpdate call error : %s", err)
+			return reply
+		}
+	}
+	reply.Applied = true
+	reply.Generation++
+	c.current.Store(sessionConfig{config: m, generation: reply.Generation})
+	return reply
+}
+
+// SetConfig replaces the config of the plugin without restarting it.  The
+// config is used by the calls started after SetConfig returns; an invalid
+// config, or one refused by the plugin, leaves the previous one active.
+func (s *SessionState) SetConfig(args []byte, reply *[]byte) (err error) {
+	defer s.recoverPanic("SessionState.SetConfig", &err)
+
+	a := &SetConfigArgs{}
+	if err := s.Decode(args, a); err != nil {
+		return err
+	}
+	if err := s.CheckToken(a.Token); err != nil {
+		return err
+	}
+	if err := s.VerifyRequest(a); err != nil {
+		s.Logger().Errorf("SetConfig rejected: %v", err)
+		return err
+	}
+	s.ResetHeartbeat()
+	if err := s.beginCall(s.base.context()); err != nil {
+		return err
+	}
+	defer s.endCall()
+
+	openConfig(a.Config, s.decrypter())
+	r := s.config.set(s.plugin, a.Config)
+	if !r.Applied {
+		s.Logger().Errorf("SetConfig rejected: %s", r.Error)
+	}
+	*reply, err = s.Encode(r)
+	return err
+}
@@ -0,0 +1,269 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// endpointPublisher records the endpoint of each publish.  A publish blocks
+// on gate when it is set.
+type endpointPublisher struct {
+	mutex     sync.Mutex
+	endpoints []string
+	updates   []string
+	started   chan struct{}
+	gate      chan struct{}
+}
+
+func (p *endpointPublisher) Publish(_ string, _ []byte, config map[string]ctypes.ConfigValue) error {
+	endpoint := ""
+	if v, ok := config["endpoint"]; ok {
+		endpoint = v.(ctypes.ConfigValueStr).Value
+	}
+	if p.gate != nil {
+		p.started <- struct{}{}
+		<-p.gate
+	}
+	p.mutex.Lock()
+	p.endpoints = append(p.endpoints, endpoint)
+	p.mutex.Unlock()
+	return nil
+}
+
+func (p *endpointPublisher) OnConfigUpdate(config map[string]ctypes.ConfigValue) error {
+	endpoint := config["endpoint"].(ctypes.ConfigValueStr).Value
+	if endpoint == "unreachable:9000" {
+		return errors.New("connection refused")
+	}
+	p.updates = append(p.updates, endpoint)
+	return nil
+}
+
+func (p *endpointPublisher) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	c := cpolicy.New()
+	n := cpolicy.NewPolicyNode()
+	r1, _ := cpolicy.NewStringRule("endpoint", false)
+	r2, _ := cpolicy.NewIntegerRule("retries", false, 3)
+	n.Add(r1, r2)
+	c.Add([]string{""}, n)
+	return c, nil
+}
+
+func TestSetConfig(t *testing.T) {
+	Convey("A publisher whose config is set while it runs", t, func() {
+		p := &endpointPublisher{}
+		m := NewPluginMeta("config", 1, PublisherPluginType, []string{SnapGOBContentType}, nil, Unsecure(true))
+		resp, done := startTestPlugin(m, p, fmt.Sprintf(`{"KillDelay": 1, "PingTimeoutDuration": %d}`, time.Minute))
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer func() {
+			So(callKill(client, resp.Token), ShouldBeNil)
+			client.Close()
+			<-done
+		}()
+		enc := encoding.NewGobEncoder()
+		content, err := EncodeMetrics(SnapGOBContentType, mockMetricType)
+		So(err, ShouldBeNil)
+		publishArgs := func(config map[string]ctypes.ConfigValue) []byte {
+			args, err := enc.Encode(PublishArgs{ContentType: SnapGOBContentType, Content: content, Config: config, Token: resp.Token})
+			So(err, ShouldBeNil)
+			return args
+		}
+		publish := func(config map[string]ctypes.ConfigValue) error {
+			return client.Call("Publisher.Publish", publishArgs(config), &[]byte{})
+		}
+		setConfig := func(config map[string]ctypes.ConfigValue) SetConfigReply {
+			args, err := enc.Encode(SetConfigArgs{Config: config, Token: resp.Token})
+			So(err, ShouldBeNil)
+			var reply []byte
+			So(client.Call("SessionState.SetConfig", args, &reply), ShouldBeNil)
+			var r SetConfigReply
+			So(enc.Decode(reply, &r), ShouldBeNil)
+			return r
+		}
+		endpoint := func(e string) map[string]ctypes.ConfigValue {
+			return map[string]ctypes.ConfigValue{"endpoint": ctypes.ConfigValueStr{Value: e}}
+		}
+
+		Convey("uses it from the next call", func() {
+			So(publish(nil), ShouldBeNil)
+			So(publish(nil), ShouldBeNil)
+			r := setConfig(endpoint("db1:9000"))
+			So(r, ShouldResemble, SetConfigReply{Applied: true, Generation: 1})
+			So(publish(nil), ShouldBeNil)
+			r = setConfig(endpoint("db2:9000"))
+			So(r.Generation, ShouldEqual, 2)
+			So(publish(nil), ShouldBeNil)
+			// The config of the call takes precedence
+			So(publish(endpoint("task:9000")), ShouldBeNil)
+			So(publish(nil), ShouldBeNil)
+			So(p.endpoints, ShouldResemble, []string{"", "", "db1:9000", "db2:9000", "task:9000", "db2:9000"})
+			So(p.updates, ShouldResemble, []string{"db1:9000", "db2:9000"})
+		})
+		Convey("keeps the previous config when the new one is invalid", func() {
+			So(setConfig(endpoint("db1:9000")).Applied, ShouldBeTrue)
+			r := setConfig(map[string]ctypes.ConfigValue{"endpoint": ctypes.ConfigValueInt{Value: 9000}})
+			So(r.Applied, ShouldBeFalse)
+			So(r.Generation, ShouldEqual, 1)
+			So(r.Error, ShouldStartWith, "invalid config: ")
+			So(publish(nil), ShouldBeNil)
+			So(p.endpoints, ShouldResemble, []string{"db1:9000"})
+		})
+		Convey("keeps the previous config when the plugin refuses the new one", func() {
+			So(setConfig(endpoint("db1:9000")).Applied, ShouldBeTrue)
+			r := setConfig(endpoint("unreachable:9000"))
+			So(r.Applied, ShouldBeFalse)
+			So(r.Error, ShouldEqual, "OnConfigUpdate call error : connection refused")
+			So(publish(nil), ShouldBeNil)
+			So(p.endpoints, ShouldResemble, []string{"db1:9000"})
+		})
+		Convey("lets a call in flight finish with the config it started with", func() {
+			So(setConfig(endpoint("db1:9000")).Applied, ShouldBeTrue)
+			p.started, p.gate = make(chan struct{}), make(chan struct{})
+			errc := make(chan error)
+			args := publishArgs(nil)
+			go func() { errc <- client.Call("Publisher.Publish", args, &[]byte{}) }()
+			<-p.started
+			So(setConfig(endpoint("db2:9000")).Applied, ShouldBeTrue)
+			close(p.gate)
+			So(<-errc, ShouldBeNil)
+			p.gate = nil
+			So(publish(nil), ShouldBeNil)
+			So(p.endpoints, ShouldResemble, []string{"db1:9000", "db2:9000"})
+		})
+	})
+}
+
+func TestSetConfigSigned(t *testing.T) {
+	Convey("SetConfig with a ControlPubKey", t, func() {
+		controlKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		So(err, ShouldBeNil)
+		p := &endpointPublisher{}
+		m := NewPluginMeta("config", 1, PublisherPluginType, []string{SnapGOBContentType}, nil, Unsecure(true))
+		arg, err := json.Marshal(Arg{ControlPubKey: &controlKey.PublicKey})
+		So(err, ShouldBeNil)
+		ss, err, _ := NewSessionState(string(arg), p, m)
+		So(err, ShouldBeNil)
+		setConfig := func(args *SetConfigArgs) (SetConfigReply, error) {
+			in, err := ss.Encode(args)
+			So(err, ShouldBeNil)
+			var out []byte
+			if err := ss.SetConfig(in, &out); err != nil {
+				return SetConfigReply{}, err
+			}
+			var r SetConfigReply
+			So(ss.Decode(out, &r), ShouldBeNil)
+			return r, nil
+		}
+		endpoint := func(e string) *SetConfigArgs {
+			return &SetConfigArgs{
+				Config: map[string]ctypes.ConfigValue{"endpoint": ctypes.ConfigValueStr{Value: e}},
+				Token:  ss.Token(),
+			}
+		}
+
+		Convey("refuses an unsigned config", func() {
+			_, err := setConfig(endpoint("db1:9000"))
+			So(err, ShouldEqual, ErrRequestUnsigned)
+			So(p.updates, ShouldBeEmpty)
+		})
+		Convey("refuses a config signed by another key", func() {
+			otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			So(err, ShouldBeNil)
+			args := endpoint("db1:9000")
+			So(SignRequest(args, otherKey), ShouldBeNil)
+			_, err = setConfig(args)
+			So(err, ShouldEqual, ErrRequestSignature)
+			So(p.updates, ShouldBeEmpty)
+		})
+		Convey("refuses a config altered after signing", func() {
+			args := endpoint("db1:9000")
+			So(SignRequest(args, controlKey), ShouldBeNil)
+			args.Config["endpoint"] = ctypes.ConfigValueStr{Value: "evil:9000"}
+			_, err = setConfig(args)
+			So(err, ShouldEqual, ErrRequestSignature)
+			So(p.updates, ShouldBeEmpty)
+		})
+		Convey("applies a config signed by control", func() {
+			args := endpoint("db1:9000")
+			So(SignRequest(args, controlKey), ShouldBeNil)
+			r, err := setConfig(args)
+			So(err, ShouldBeNil)
+			So(r.Applied, ShouldBeTrue)
+			So(p.updates, ShouldResemble, []string{"db1:9000"})
+
+			Convey("once", func() {
+				_, err := setConfig(args)
+				So(err, ShouldEqual, ErrRequestReplay)
+			})
+		})
+		Convey("verifies a signed config received over JSON-RPC", func() {
+			args := endpoint("db1:9000")
+			So(SignRequest(args, controlKey), ShouldBeNil)
+			b, err := json.Marshal(args)
+			So(err, ShouldBeNil)
+			var received SetConfigArgs
+			So(json.Unmarshal(b, &received), ShouldBeNil)
+			So(ss.VerifyRequest(&received), ShouldBeNil)
+		})
+	})
+}
+
+func TestConfigStoreMetrics(t *testing.T) {
+	Convey("The config set on a collector completes the config of each metric", t, func() {
+		c := &configStore{}
+		mts := []MetricType{{}, {Config_: cdata.NewNode()}}
+		c.mergeMetrics(mts)
+		So(mts[0].Config(), ShouldBeNil)
+
+		r := c.set(&mockPlugin{}, map[string]ctypes.ConfigValue{
+			"endpoint": ctypes.ConfigValueStr{Value: "db1:9000"},
+			"retries":  ctypes.ConfigValueInt{Value: 5},
+		})
+		So(r.Applied, ShouldBeTrue)
+		mts[1].Config_.AddItem("retries", ctypes.ConfigValueInt{Value: 1})
+		c.mergeMetrics(mts)
+		So(mts[0].Config().Table(), ShouldResemble, map[string]ctypes.ConfigValue{
+			"endpoint": ctypes.ConfigValueStr{Value: "db1:9000"},
+			"retries":  ctypes.ConfigValueInt{Value: 5},
+		})
+		So(mts[1].Config().Table(), ShouldResemble, map[string]ctypes.ConfigValue{
+			"endpoint": ctypes.ConfigValueStr{Value: "db1:9000"},
+			"retries":  ctypes.ConfigValueInt{Value: 1},
+		})
+	})
+}
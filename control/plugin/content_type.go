@@ -0,0 +1,119 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultContentTypes are the content types offered by a control which
+// does not send Arg.ContentTypes.
+var DefaultContentTypes = []string{SnapGOBContentType}
+
+// matchContentType returns the first of offered matching pattern, one of
+// the plugin's content types, where snap.* matches any snap type.
+func matchContentType(pattern string, offered []string) (string, bool) {
+	for _, ct := range offered {
+		if ct == pattern || (pattern == SnapAllContentType && strings.HasPrefix(ct, "snap.")) {
+			return ct, true
+		}
+	}
+	return "", false
+}
+
+// negotiateContentType returns the content type control and the plugin
+// both support, taking the plugin's types in their order of preference.
+func negotiateContentType(kind string, plugin, offered []string) (string, error) {
+	for _, p := range plugin {
+		if ct, ok := matchContentType(p, offered); ok {
+			return ct, nil
+		}
+	}
+	return "", &PluginError{
+		Code:    ErrorCodeUnsupported,
+		Message: fmt.Sprintf("no content type in common: the plugin %s %s, control supports %s", kind, strings.Join(plugin, ", "), strings.Join(offered, ", ")),
+	}
+}
+
+// negotiateContentTypes picks the content type of the metrics sent to a
+// processor or publisher, and of those returned by a processor, from the
+// types offered by control.  Collectors are not sent metrics.
+func (s *SessionState) negotiateContentTypes(meta *PluginMeta) error {
+	offered := s.ContentTypes
+	if len(offered) == 0 {
+		offered = DefaultContentTypes
+	}
+	if meta.RPCType == GRPC {
+		// gRPC calls always carry gob
+		offered = []string{SnapGOBContentType}
+	}
+	var err error
+	switch meta.Type {
+	case ProcessorPluginType:
+		if s.returnedContentType, err = negotiateContentType("returns", meta.ReturnedContentTypes, offered); err != nil {
+			return err
+		}
+		fallthrough
+	case PublisherPluginType:
+		s.contentType, err = negotiateContentType("accepts", meta.AcceptedContentTypes, offered)
+	}
+	return err
+}
+
+// returnedContentType returns the content type of the metrics a processor
+// returned from metrics in contentType, declaring them in returned.  A
+// processor which declares no type, or the type it was given, passes its
+// metrics through in contentType.  Any other type must be one of the
+// plugin's ReturnedContentTypes.
+func returnedContentType(meta *PluginMeta, contentType, returned string) (string, error) {
+	if returned == "" {
+		return contentType, nil
+	}
+	if returned == contentType || meta == nil {
+		return returned, nil
+	}
+	for _, p := range meta.ReturnedContentTypes {
+		if _, ok := matchContentType(p, []string{returned}); ok {
+			return returned, nil
+		}
+	}
+	return "", &PluginError{
+		Code:    ErrorCodeContentTypeMismatch,
+		Message: fmt.Sprintf("content type %q was returned, the plugin returns %s", returned, strings.Join(meta.ReturnedContentTypes, ", ")),
+	}
+}
+
+// checkContentType refuses the metrics of a call, in contentType, unless
+// the plugin accepts them.
+func checkContentType(meta *PluginMeta, contentType string) error {
+	if meta == nil {
+		return nil
+	}
+	for _, p := range meta.AcceptedContentTypes {
+		if _, ok := matchContentType(p, []string{contentType}); ok {
+			return nil
+		}
+	}
+	return &PluginError{
+		Code:    ErrorCodeUnsupported,
+		Message: fmt.Sprintf("content type %q is not accepted, the plugin accepts %s", contentType, strings.Join(meta.AcceptedContentTypes, ", ")),
+	}
+}
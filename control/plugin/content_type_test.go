@@ -0,0 +1,197 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	"github.com/intelsdi-x/snap/core"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// transcodingProcessor returns the metrics it is given in its content type
+// out, or passes them through without declaring a type when out is empty.
+type transcodingProcessor struct {
+	MockProcessor
+	out string
+}
+
+func (p *transcodingProcessor) Process(contentType string, content []byte, config map[string]ctypes.ConfigValue) (string, []byte, error) {
+	if p.out == "" {
+		return "", content, nil
+	}
+	mts, err := DecodeMetrics(contentType, content)
+	if err != nil {
+		return "", nil, err
+	}
+	content, err = EncodeMetrics(p.out, mts)
+	return p.out, content, err
+}
+
+func TestProcessorChain(t *testing.T) {
+	session := &MockSessionState{
+		Encoder:  encoding.NewGobEncoder(),
+		logger:   logrus.New(),
+		killChan: make(chan int),
+	}
+	processor := func(p ProcessorPlugin, accepted, returned string) *processorPluginProxy {
+		m := NewPluginMeta("chain", 1, ProcessorPluginType, []string{accepted}, []string{returned}, Unsecure(true))
+		return &processorPluginProxy{Plugin: p, Session: session, Meta: m}
+	}
+	process := func(p *processorPluginProxy, ct string, content []byte) (ProcessorReply, error) {
+		args, err := session.Encode(ProcessorArgs{ContentType: ct, Content: content})
+		So(err, ShouldBeNil)
+		var out []byte
+		if err := p.Process(args, &out); err != nil {
+			return ProcessorReply{}, err
+		}
+		var r ProcessorReply
+		So(session.Decode(out, &r), ShouldBeNil)
+		return r, nil
+	}
+	mts := []MetricType{*NewMetricType(core.NewNamespace("foo", "bar"), time.Now(), nil, "", 1)}
+	content, err := EncodeMetrics(SnapGOBContentType, mts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Processors chained hand their output type to the next", t, func() {
+		toJSON := processor(&transcodingProcessor{out: SnapJSONContentType}, SnapGOBContentType, SnapJSONContentType)
+		toGOB := processor(&transcodingProcessor{out: SnapGOBContentType}, SnapJSONContentType, SnapGOBContentType)
+		r, err := process(toJSON, SnapGOBContentType, content)
+		So(err, ShouldBeNil)
+		So(r.ContentType, ShouldEqual, SnapJSONContentType)
+		r, err = process(toGOB, r.ContentType, r.Content)
+		So(err, ShouldBeNil)
+		So(r.ContentType, ShouldEqual, SnapGOBContentType)
+		out, err := DecodeMetrics(r.ContentType, r.Content)
+		So(err, ShouldBeNil)
+		So(out, ShouldHaveLength, 1)
+		So(out[0].Namespace().String(), ShouldEqual, "/foo/bar")
+	})
+	Convey("A processor passing its metrics through keeps their type", t, func() {
+		passthru := processor(&transcodingProcessor{}, SnapAllContentType, SnapGOBContentType)
+		in, err := EncodeMetrics(SnapJSONContentType, mts)
+		So(err, ShouldBeNil)
+		r, err := process(passthru, SnapJSONContentType, in)
+		So(err, ShouldBeNil)
+		So(r.ContentType, ShouldEqual, SnapJSONContentType)
+		So(r.Content, ShouldResemble, in)
+	})
+	Convey("A processor returning a type it did not register fails", t, func() {
+		liar := processor(&transcodingProcessor{out: SnapJSONContentType}, SnapGOBContentType, SnapGOBContentType)
+		_, err := process(liar, SnapGOBContentType, content)
+		So(ErrorCodeOf(err), ShouldEqual, ErrorCodeContentTypeMismatch)
+		So(err.Error(), ShouldContainSubstring, `content type "snap.json" was returned`)
+	})
+}
+
+func TestContentTypeNegotiation(t *testing.T) {
+	quick := fmt.Sprintf(`"PingTimeoutDuration": %d`, time.Millisecond)
+	Convey("A publisher", t, func() {
+		Convey("receives the first type it accepts which control supports", func() {
+			m := NewPluginMeta("negotiate", 1, PublisherPluginType, []string{SnapJSONContentType, SnapGOBContentType}, nil, Unsecure(true))
+			resp, done := startTestPlugin(m, new(MockPublisher), fmt.Sprintf(`{"ContentTypes": ["snap.gob", "snap.json"], %s}`, quick))
+			So(resp.State, ShouldEqual, PluginSuccess)
+			So(resp.ContentType, ShouldEqual, SnapJSONContentType)
+			<-done
+		})
+		Convey("accepting snap.* receives control's preferred type", func() {
+			m := NewPluginMeta("negotiate", 1, PublisherPluginType, []string{SnapAllContentType}, nil, Unsecure(true))
+			resp, done := startTestPlugin(m, new(MockPublisher), fmt.Sprintf(`{"ContentTypes": ["snap.json", "snap.gob"], %s}`, quick))
+			So(resp.ContentType, ShouldEqual, SnapJSONContentType)
+			<-done
+		})
+		Convey("receives snap.gob from a control which does not offer types", func() {
+			m := NewPluginMeta("negotiate", 1, PublisherPluginType, []string{SnapJSONContentType, SnapGOBContentType}, nil, Unsecure(true))
+			resp, done := startTestPlugin(m, new(MockPublisher), fmt.Sprintf(`{%s}`, quick))
+			So(resp.ContentType, ShouldEqual, SnapGOBContentType)
+			<-done
+		})
+		Convey("accepting snap.protobuf or snap.msgpack receives it from this control", func() {
+			for _, ct := range []string{SnapProtoBufContentType, SnapMsgPackContentType} {
+				m := NewPluginMeta("negotiate", 1, PublisherPluginType, []string{ct}, nil, Unsecure(true))
+				arg := NewArg(0)
+				arg.PingTimeoutDuration = time.Millisecond
+				b, err := json.Marshal(arg)
+				So(err, ShouldBeNil)
+				resp, done := startTestPlugin(m, new(MockPublisher), string(b))
+				So(resp.ContentType, ShouldEqual, ct)
+				<-done
+			}
+		})
+		Convey("fails to start without a type in common", func() {
+			m := NewPluginMeta("negotiate", 1, PublisherPluginType, []string{SnapJSONContentType}, nil, Unsecure(true))
+			resp, done := startTestPlugin(m, new(MockPublisher), `{"ContentTypes": ["snap.gob"]}`)
+			So(<-done, ShouldEqual, 2)
+			So(resp.State, ShouldEqual, PluginFailure)
+			So(resp.ErrorCode, ShouldEqual, ErrorCodeUnsupported)
+			So(resp.ErrorMessage, ShouldEqual, "no content type in common: the plugin accepts snap.json, control supports snap.gob")
+		})
+	})
+	Convey("A processor negotiates the types it accepts and returns", t, func() {
+		m := NewPluginMeta("negotiate", 1, ProcessorPluginType, []string{SnapGOBContentType}, []string{SnapJSONContentType, SnapGOBContentType}, Unsecure(true))
+		resp, done := startTestPlugin(m, new(MockProcessor), fmt.Sprintf(`{"ContentTypes": ["snap.gob", "snap.json"], %s}`, quick))
+		So(resp.State, ShouldEqual, PluginSuccess)
+		So(resp.ContentType, ShouldEqual, SnapGOBContentType)
+		So(resp.ReturnedContentType, ShouldEqual, SnapJSONContentType)
+		<-done
+	})
+	Convey("A collector does not negotiate", t, func() {
+		m := NewPluginMeta("negotiate", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		resp, done := startTestPlugin(m, new(MockPlugin), fmt.Sprintf(`{"ContentTypes": ["snap.protobuf"], %s}`, quick))
+		So(resp.State, ShouldEqual, PluginSuccess)
+		So(resp.ContentType, ShouldBeEmpty)
+		<-done
+	})
+	Convey("A publisher refuses metrics in a type it does not accept", t, func() {
+		session := &MockSessionState{
+			Encoder:  encoding.NewGobEncoder(),
+			logger:   logrus.New(),
+			killChan: make(chan int),
+		}
+		m := NewPluginMeta("negotiate", 1, PublisherPluginType, []string{SnapGOBContentType}, nil, Unsecure(true))
+		p := &publisherPluginProxy{Plugin: new(MockPublisher), Session: session, Meta: m}
+		mts := []MetricType{*NewMetricType(core.NewNamespace("foo", "bar"), time.Now(), nil, "", 1)}
+
+		content, ct, err := MarshalMetricTypes(SnapJSONContentType, mts)
+		So(err, ShouldBeNil)
+		args, err := session.Encode(PublishArgs{ContentType: ct, Content: content})
+		So(err, ShouldBeNil)
+		var reply []byte
+		err = p.Publish(args, &reply)
+		So(ErrorCodeOf(err), ShouldEqual, ErrorCodeUnsupported)
+		So(err.Error(), ShouldContainSubstring, `content type "snap.json" is not accepted`)
+
+		content, ct, err = MarshalMetricTypes(SnapGOBContentType, mts)
+		So(err, ShouldBeNil)
+		args, err = session.Encode(PublishArgs{ContentType: ct, Content: content})
+		So(err, ShouldBeNil)
+		So(p.Publish(args, &reply), ShouldBeNil)
+	})
+}
@@ -0,0 +1,182 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+// CollectorPluginCtx is the flavor of CollectorPlugin given the context of
+// each call.  The context is done at the deadline of the call or once the
+// session is killed, and carries the request ID, see RequestIDFromContext.
+// A plugin implementing it is preferred to CollectorPlugin.
+type CollectorPluginCtx interface {
+	Plugin
+	CollectMetrics(context.Context, []MetricType) ([]MetricType, error)
+	GetMetricTypes(context.Context, ConfigType) ([]MetricType, error)
+}
+
+// PublisherPluginCtx is the flavor of PublisherPlugin given the context of
+// each call, see CollectorPluginCtx.
+type PublisherPluginCtx interface {
+	Plugin
+	Publish(ctx context.Context, contentType string, content []byte, config map[string]ctypes.ConfigValue) error
+}
+
+// ProcessorPluginCtx is the flavor of ProcessorPlugin given the context of
+// each call, see CollectorPluginCtx.
+type ProcessorPluginCtx interface {
+	Plugin
+	Process(ctx context.Context, contentType string, content []byte, config map[string]ctypes.ConfigValue) (string, []byte, error)
+}
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the request ID of the call whose context is
+// ctx, or "" when it has none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// sessionContext is the parent of the context of each call, cancelled when
+// the session is killed.  A nil sessionContext is never cancelled.
+type sessionContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newSessionContext() *sessionContext {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &sessionContext{ctx: ctx, cancel: cancel}
+}
+
+func (c *sessionContext) context() context.Context {
+	if c == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// end cancels the calls in progress
+func (c *sessionContext) end() {
+	if c == nil {
+		return
+	}
+	c.cancel()
+}
+
+// callContext returns the context of a call with the given request ID and
+// deadline.
+func (c *sessionContext) callContext(id string, deadline time.Time) (context.Context, context.CancelFunc) {
+	return deadlineContext(context.WithValue(c.context(), requestIDKey{}, id), deadline)
+}
+
+// bind returns ctx, the context of a gRPC call, also cancelled when the
+// session is killed.
+func (c *sessionContext) bind(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-c.context().Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// collectorCtx serves a CollectorPluginCtx as a CollectorPlugin and a
+// ContextCollector.  The calls which are not given a context by the proxies
+// get the session's.
+type collectorCtx struct {
+	CollectorPluginCtx
+	session *sessionContext
+}
+
+func (c collectorCtx) CollectMetrics(mts []MetricType) ([]MetricType, error) {
+	return c.CollectorPluginCtx.CollectMetrics(c.session.context(), mts)
+}
+
+func (c collectorCtx) CollectMetricsContext(ctx context.Context, mts []MetricType) ([]MetricType, error) {
+	return c.CollectorPluginCtx.CollectMetrics(ctx, mts)
+}
+
+func (c collectorCtx) GetMetricTypes(cfg ConfigType) ([]MetricType, error) {
+	return c.CollectorPluginCtx.GetMetricTypes(c.session.context(), cfg)
+}
+
+// publisherCtx serves a PublisherPluginCtx as a PublisherPlugin and a
+// ContextPublisher.
+type publisherCtx struct {
+	PublisherPluginCtx
+	session *sessionContext
+}
+
+func (p publisherCtx) Publish(contentType string, content []byte, config map[string]ctypes.ConfigValue) error {
+	return p.PublisherPluginCtx.Publish(p.session.context(), contentType, content, config)
+}
+
+func (p publisherCtx) PublishContext(ctx context.Context, contentType string, content []byte, config map[string]ctypes.ConfigValue) error {
+	return p.PublisherPluginCtx.Publish(ctx, contentType, content, config)
+}
+
+// processorCtx serves a ProcessorPluginCtx as a ProcessorPlugin and a
+// ContextProcessor.
+type processorCtx struct {
+	ProcessorPluginCtx
+	session *sessionContext
+}
+
+func (p processorCtx) Process(contentType string, content []byte, config map[string]ctypes.ConfigValue) (string, []byte, error) {
+	return p.ProcessorPluginCtx.Process(p.session.context(), contentType, content, config)
+}
+
+func (p processorCtx) ProcessContext(ctx context.Context, contentType string, content []byte, config map[string]ctypes.ConfigValue) (string, []byte, error) {
+	return p.ProcessorPluginCtx.Process(ctx, contentType, content, config)
+}
+
+// collectorPlugin returns p as a CollectorPlugin, preferring its ctx flavor.
+func collectorPlugin(p Plugin, s *sessionContext) CollectorPlugin {
+	if pc, ok := p.(CollectorPluginCtx); ok {
+		return collectorCtx{CollectorPluginCtx: pc, session: s}
+	}
+	return p.(CollectorPlugin)
+}
+
+// publisherPlugin returns p as a PublisherPlugin, preferring its ctx flavor.
+func publisherPlugin(p Plugin, s *sessionContext) PublisherPlugin {
+	if pc, ok := p.(PublisherPluginCtx); ok {
+		return publisherCtx{PublisherPluginCtx: pc, session: s}
+	}
+	return p.(PublisherPlugin)
+}
+
+// processorPlugin returns p as a ProcessorPlugin, preferring its ctx flavor.
+func processorPlugin(p Plugin, s *sessionContext) ProcessorPlugin {
+	if pc, ok := p.(ProcessorPluginCtx); ok {
+		return processorCtx{ProcessorPluginCtx: pc, session: s}
+	}
+	return p.(ProcessorPlugin)
+}
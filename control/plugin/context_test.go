@@ -0,0 +1,130 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// waitingCollector collects until the context of the call is done
+type waitingCollector struct {
+	started chan string
+	catalog chan context.Context
+}
+
+func (c *waitingCollector) CollectMetrics(ctx context.Context, mts []MetricType) ([]MetricType, error) {
+	c.started <- RequestIDFromContext(ctx)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (c *waitingCollector) GetMetricTypes(ctx context.Context, cfg ConfigType) ([]MetricType, error) {
+	c.catalog <- ctx
+	return mockMetricType, nil
+}
+
+func (c *waitingCollector) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	return cpolicy.New(), nil
+}
+
+// ctxProcessor echoes the request ID of each call as its content
+type ctxProcessor struct{}
+
+func (p *ctxProcessor) Process(ctx context.Context, contentType string, content []byte, config map[string]ctypes.ConfigValue) (string, []byte, error) {
+	return contentType, []byte(RequestIDFromContext(ctx)), nil
+}
+
+func (p *ctxProcessor) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	return cpolicy.New(), nil
+}
+
+func TestPluginCtx(t *testing.T) {
+	Convey("The plugin flavors", t, func() {
+		s := newSessionContext()
+		_, ok := collectorPlugin(&waitingCollector{}, s).(ContextCollector)
+		So(ok, ShouldBeTrue)
+		_, ok = processorPlugin(&ctxProcessor{}, s).(ContextProcessor)
+		So(ok, ShouldBeTrue)
+		legacy := &mockPlugin{}
+		So(collectorPlugin(legacy, s), ShouldEqual, legacy)
+	})
+	Convey("A CollectorPluginCtx session", t, func() {
+		m := NewPluginMeta("ctx", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		c := &waitingCollector{started: make(chan string, 1), catalog: make(chan context.Context, 1)}
+		ss, err, _ := NewSessionState(`{"KillDelay": 1}`, c, m)
+		So(err, ShouldBeNil)
+		proxy := &collectorPluginProxy{Plugin: collectorPlugin(c, ss.base), Session: ss, Meta: m, base: ss.base}
+
+		Convey("returns promptly from a collection when killed", func() {
+			in, err := ss.Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: ss.Token(), RequestID: "req-1"})
+			So(err, ShouldBeNil)
+			collected := make(chan error, 1)
+			go func() { collected <- proxy.CollectMetrics(in, &[]byte{}) }()
+			So(<-c.started, ShouldEqual, "req-1")
+
+			start := time.Now()
+			in, err = ss.Encode(KillArgs{Reason: "test", Token: ss.Token()})
+			So(err, ShouldBeNil)
+			var out []byte
+			So(ss.Kill(in, &out), ShouldBeNil)
+			So(time.Since(start), ShouldBeLessThan, DefaultKillDrainTimeout)
+			var r KillReply
+			So(ss.Decode(out, &r), ShouldBeNil)
+			So(r.Drained, ShouldBeTrue)
+
+			err = <-collected
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeCallFailed)
+			So(err.Error(), ShouldContainSubstring, "context canceled")
+			<-ss.Done()
+		})
+		Convey("gives the catalog the session's context", func() {
+			in, err := ss.Encode(GetMetricTypesArgs{Token: ss.Token()})
+			So(err, ShouldBeNil)
+			So(proxy.GetMetricTypes(in, &[]byte{}), ShouldBeNil)
+			ctx := <-c.catalog
+			So(ctx.Err(), ShouldBeNil)
+			ss.drain()
+			So(ctx.Err(), ShouldNotBeNil)
+		})
+	})
+	Convey("A ProcessorPluginCtx is given the request ID", t, func() {
+		m := NewPluginMeta("ctx", 1, ProcessorPluginType, []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+		p := &ctxProcessor{}
+		ss, err, _ := NewSessionState("{}", p, m)
+		So(err, ShouldBeNil)
+		proxy := &processorPluginProxy{Plugin: processorPlugin(p, ss.base), Session: ss, Meta: m, config: ss.config, base: ss.base}
+		in, err := ss.Encode(ProcessorArgs{ContentType: SnapGOBContentType, Token: ss.Token(), RequestID: "req-2"})
+		So(err, ShouldBeNil)
+		var out []byte
+		So(proxy.Process(in, &out), ShouldBeNil)
+		var r ProcessorReply
+		So(ss.Decode(out, &r), ShouldBeNil)
+		So(string(r.Content), ShouldEqual, "req-2")
+	})
+}
@@ -117,7 +117,9 @@ func (f *FloatRule) GobDecode(buf []byte) error {
 	var is_default_set bool
 	decoder.Decode(&is_default_set)
 	if is_default_set {
-		return decoder.Decode(&f.default_)
+		if err := decoder.Decode(&f.default_); err != nil {
+			return err
+		}
 	}
 	var is_minimum_set bool
 	decoder.Decode(&is_minimum_set)
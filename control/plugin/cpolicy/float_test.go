@@ -138,6 +138,22 @@ func TestConfigPolicyRuleFloat(t *testing.T) {
 				So(err2, ShouldBeNil)
 			})
 
+			Convey("keeps minimum and maximum through gob when a default is set", func() {
+				r, e := NewFloatRule("thekey", false, 5.5)
+				So(e, ShouldBeNil)
+				r.SetMinimum(1.1)
+				r.SetMaximum(10.1)
+
+				buf, err := r.GobEncode()
+				So(err, ShouldBeNil)
+				r2 := &FloatRule{}
+				err = r2.GobDecode(buf)
+				So(err, ShouldBeNil)
+				So(r2.Default(), ShouldResemble, ctypes.ConfigValueFloat{Value: 5.5})
+				So(r2.Minimum(), ShouldResemble, ctypes.ConfigValueFloat{Value: 1.1})
+				So(r2.Maximum(), ShouldResemble, ctypes.ConfigValueFloat{Value: 10.1})
+			})
+
 			Convey("error with value above maximum", func() {
 				r, e := NewFloatRule("thekey", true)
 				r.SetMaximum(127.127)
@@ -138,6 +138,22 @@ func TestConfigPolicyRuleInteger(t *testing.T) {
 				So(err2, ShouldBeNil)
 			})
 
+			Convey("keeps minimum and maximum through gob when a default is set", func() {
+				r, e := NewIntegerRule("thekey", false, 5)
+				So(e, ShouldBeNil)
+				r.SetMinimum(1)
+				r.SetMaximum(10)
+
+				buf, err := r.GobEncode()
+				So(err, ShouldBeNil)
+				r2 := &IntRule{}
+				err = r2.GobDecode(buf)
+				So(err, ShouldBeNil)
+				So(r2.Default(), ShouldResemble, ctypes.ConfigValueInt{Value: 5})
+				So(r2.Minimum(), ShouldResemble, ctypes.ConfigValueInt{Value: 1})
+				So(r2.Maximum(), ShouldResemble, ctypes.ConfigValueInt{Value: 10})
+			})
+
 			Convey("error with value above maximum", func() {
 				r, e := NewIntegerRule("thekey", true)
 				r.SetMaximum(127)
@@ -162,6 +162,13 @@ func (c *ConfigPolicyNode) Process(m map[string]ctypes.ConfigValue) (*map[string
 	for key, rule := range c.rules {
 		// items exists for rule
 		if cv, ok := m[key]; ok {
+			// Secrets provided as plain strings are protected from here on
+			if _, secure := rule.(*SecureStringRule); secure {
+				if str, ok := cv.(ctypes.ConfigValueStr); ok {
+					cv = ctypes.NewConfigValueSecureString(str.Value)
+					m[key] = cv
+				}
+			}
 			// Validate versus matching data
 			e := rule.Validate(cv)
 			if e != nil {
@@ -189,6 +196,26 @@ func (c *ConfigPolicyNode) Process(m map[string]ctypes.ConfigValue) (*map[string
 	return &m, pErrors
 }
 
+// MergeConfig returns the effective config for this policy node. Values from
+// the task config take precedence over values from the control config which in
+// turn take precedence over the rule defaults. The merged config is validated
+// against the rules and errors are returned for missing required keys and
+// type mismatches.
+func (c *ConfigPolicyNode) MergeConfig(task, control map[string]ctypes.ConfigValue) (map[string]ctypes.ConfigValue, *ProcessingErrors) {
+	m := make(map[string]ctypes.ConfigValue, len(task)+len(control))
+	for k, v := range control {
+		m[k] = v
+	}
+	for k, v := range task {
+		m[k] = v
+	}
+	res, pErrors := c.Process(m)
+	if pErrors.HasErrors() {
+		return nil, pErrors
+	}
+	return *res, pErrors
+}
+
 // AddDefaults validates and returns a processed policy node or nil and error if validation has failed
 func (c *ConfigPolicyNode) AddDefaults(m map[string]ctypes.ConfigValue) (*map[string]ctypes.ConfigValue, *ProcessingErrors) {
 	c.mutex.Lock()
@@ -274,6 +301,9 @@ func addRulesToConfigPolicyNode(rules map[string]interface{}, cpn *ConfigPolicyN
 					}
 				}
 
+				cpn.Add(r)
+			case "secure_string":
+				r, _ := NewSecureStringRule(k, req)
 				cpn.Add(r)
 			case "bool":
 				r, _ := NewBoolRule(k, req)
@@ -245,3 +245,105 @@ func TestConfigPolicyNode(t *testing.T) {
 	})
 
 }
+
+func TestConfigPolicyNodeMergeConfig(t *testing.T) {
+	Convey("MergeConfig", t, func() {
+		n := NewPolicyNode()
+		r1, _ := NewStringRule("username", true)
+		r2, _ := NewIntegerRule("port", false, 8080)
+		r3, _ := NewStringRule("host", false, "localhost")
+		n.Add(r1, r2, r3)
+
+		tcs := []struct {
+			name    string
+			task    map[string]ctypes.ConfigValue
+			control map[string]ctypes.ConfigValue
+			result  map[string]ctypes.ConfigValue
+			errors  []string
+		}{
+			{
+				name: "defaults are applied for missing keys",
+				task: map[string]ctypes.ConfigValue{"username": ctypes.ConfigValueStr{Value: "root"}},
+				result: map[string]ctypes.ConfigValue{
+					"username": ctypes.ConfigValueStr{Value: "root"},
+					"port":     ctypes.ConfigValueInt{Value: 8080},
+					"host":     ctypes.ConfigValueStr{Value: "localhost"},
+				},
+			},
+			{
+				name: "control config overrides the default",
+				task: map[string]ctypes.ConfigValue{"username": ctypes.ConfigValueStr{Value: "root"}},
+				control: map[string]ctypes.ConfigValue{
+					"port": ctypes.ConfigValueInt{Value: 9090},
+				},
+				result: map[string]ctypes.ConfigValue{
+					"username": ctypes.ConfigValueStr{Value: "root"},
+					"port":     ctypes.ConfigValueInt{Value: 9090},
+					"host":     ctypes.ConfigValueStr{Value: "localhost"},
+				},
+			},
+			{
+				name: "task config overrides control config and the default",
+				task: map[string]ctypes.ConfigValue{
+					"username": ctypes.ConfigValueStr{Value: "root"},
+					"port":     ctypes.ConfigValueInt{Value: 7070},
+				},
+				control: map[string]ctypes.ConfigValue{
+					"username": ctypes.ConfigValueStr{Value: "admin"},
+					"port":     ctypes.ConfigValueInt{Value: 9090},
+					"host":     ctypes.ConfigValueStr{Value: "remote"},
+				},
+				result: map[string]ctypes.ConfigValue{
+					"username": ctypes.ConfigValueStr{Value: "root"},
+					"port":     ctypes.ConfigValueInt{Value: 7070},
+					"host":     ctypes.ConfigValueStr{Value: "remote"},
+				},
+			},
+			{
+				name: "required key provided by control config",
+				control: map[string]ctypes.ConfigValue{
+					"username": ctypes.ConfigValueStr{Value: "admin"},
+				},
+				result: map[string]ctypes.ConfigValue{
+					"username": ctypes.ConfigValueStr{Value: "admin"},
+					"port":     ctypes.ConfigValueInt{Value: 8080},
+					"host":     ctypes.ConfigValueStr{Value: "localhost"},
+				},
+			},
+			{
+				name:   "required key missing at every level",
+				errors: []string{"required key missing (username)"},
+			},
+			{
+				name: "type mismatch",
+				task: map[string]ctypes.ConfigValue{
+					"username": ctypes.ConfigValueStr{Value: "root"},
+					"host":     ctypes.ConfigValueBool{Value: true},
+				},
+				errors: []string{"type mismatch (host wanted type 'string' but provided type 'bool')"},
+			},
+		}
+
+		for _, tc := range tcs {
+			Convey(tc.name, func() {
+				res, pe := n.MergeConfig(tc.task, tc.control)
+				if len(tc.errors) > 0 {
+					So(res, ShouldBeNil)
+					So(errorsMsg(pe.Errors()), ShouldResemble, tc.errors)
+				} else {
+					So(pe.HasErrors(), ShouldBeFalse)
+					So(res, ShouldResemble, tc.result)
+				}
+			})
+		}
+
+		Convey("does not modify the provided maps", func() {
+			task := map[string]ctypes.ConfigValue{"username": ctypes.ConfigValueStr{Value: "root"}}
+			control := map[string]ctypes.ConfigValue{"port": ctypes.ConfigValueInt{Value: 9090}}
+			_, pe := n.MergeConfig(task, control)
+			So(pe.HasErrors(), ShouldBeFalse)
+			So(len(task), ShouldEqual, 1)
+			So(len(control), ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,128 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cpolicy
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+const (
+	SecureStringType = "secure_string"
+)
+
+// A rule validating against secret string config such as passwords.  Values
+// provided as plain strings are converted to ctypes.ConfigValueSecureString
+// when the config is processed.  Secure string rules have no default since
+// the default would be published in the plugin's policy.
+type SecureStringRule struct {
+	rule
+
+	key      string
+	required bool
+}
+
+// Returns a new secure string rule. Arguments are key(string), required(bool).
+func NewSecureStringRule(key string, req bool) (*SecureStringRule, error) {
+	// Return error if key is empty
+	if key == "" {
+		return nil, EmptyKeyError
+	}
+
+	return &SecureStringRule{
+		key:      key,
+		required: req,
+	}, nil
+}
+
+func (s *SecureStringRule) Type() string {
+	return SecureStringType
+}
+
+// MarshalJSON marshals a SecureStringRule into JSON
+func (s *SecureStringRule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Key      string `json:"key"`
+		Required bool   `json:"required"`
+		Type     string `json:"type"`
+	}{
+		Key:      s.key,
+		Required: s.required,
+		Type:     SecureStringType,
+	})
+}
+
+// GobEncode encodes a SecureStringRule in to a GOB
+func (s *SecureStringRule) GobEncode() ([]byte, error) {
+	w := new(bytes.Buffer)
+	encoder := gob.NewEncoder(w)
+	if err := encoder.Encode(s.key); err != nil {
+		return nil, err
+	}
+	if err := encoder.Encode(s.required); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+// GobDecode decodes a GOB into a SecureStringRule
+func (s *SecureStringRule) GobDecode(buf []byte) error {
+	r := bytes.NewBuffer(buf)
+	decoder := gob.NewDecoder(r)
+	if err := decoder.Decode(&s.key); err != nil {
+		return err
+	}
+	return decoder.Decode(&s.required)
+}
+
+// Returns the key
+func (s *SecureStringRule) Key() string {
+	return s.key
+}
+
+// Validates a config value against this rule.
+func (s *SecureStringRule) Validate(cv ctypes.ConfigValue) error {
+	// Check that type is correct
+	if cv.Type() != SecureStringType {
+		return wrongType(s.key, cv.Type(), SecureStringType)
+	}
+	return nil
+}
+
+// Secure string rules have no default.
+func (s *SecureStringRule) Default() ctypes.ConfigValue {
+	return nil
+}
+
+// Indicates this rule is required.
+func (s *SecureStringRule) Required() bool {
+	return s.required
+}
+
+func (s *SecureStringRule) Minimum() ctypes.ConfigValue {
+	return nil
+}
+
+func (s *SecureStringRule) Maximum() ctypes.ConfigValue {
+	return nil
+}
@@ -0,0 +1,115 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+// ErrDeadlineExceeded is returned by a call which did not complete by the
+// Deadline of its args.
+var ErrDeadlineExceeded error = &PluginError{Code: ErrorCodeDeadlineExceeded, Message: "call deadline exceeded"}
+
+// ContextCollector may be implemented by a collector to be given the
+// context of each collection, which is done at the Deadline of the call.
+type ContextCollector interface {
+	CollectMetricsContext(ctx context.Context, mts []MetricType) ([]MetricType, error)
+}
+
+// ContextPublisher may be implemented by a publisher to be given the
+// context of each call, see ContextCollector.
+type ContextPublisher interface {
+	PublishContext(ctx context.Context, contentType string, content []byte, config map[string]ctypes.ConfigValue) error
+}
+
+// ContextProcessor may be implemented by a processor to be given the
+// context of each call, see ContextCollector.
+type ContextProcessor interface {
+	ProcessContext(ctx context.Context, contentType string, content []byte, config map[string]ctypes.ConfigValue) (string, []byte, error)
+}
+
+// deadlineContext returns the context of a call with the given deadline, or
+// none when it is zero.
+func deadlineContext(parent context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	if deadline.IsZero() {
+		return context.WithCancel(parent)
+	}
+	return context.WithDeadline(parent, deadline)
+}
+
+// checkDeadline fails a call whose deadline has already passed, before the
+// plugin is called.
+func checkDeadline(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ErrDeadlineExceeded
+	}
+	return nil
+}
+
+// callWithDeadline runs f, the plugin's part of a call to method.  When ctx
+// has a deadline f runs apart, so that the call fails with
+// ErrDeadlineExceeded once it passes even though the plugin does not watch
+// ctx.  The abandoned work still runs to completion, but its result is
+// dropped.
+func callWithDeadline(ctx context.Context, s Session, method string, f func(context.Context) error) error {
+	if err := checkDeadline(ctx); err != nil {
+		return err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		return f(ctx)
+	}
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		defer func() { done <- err }()
+		defer s.recoverPanic(method, &err)
+		err = f(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		s.Logger().Warnf("Abandoning %s still running at its deadline", method)
+		return ErrDeadlineExceeded
+	}
+}
+
+// contextCollector collects with the context of a call
+type contextCollector struct {
+	CollectorPlugin
+	ctx context.Context
+}
+
+func (c contextCollector) CollectMetrics(mts []MetricType) ([]MetricType, error) {
+	return c.CollectorPlugin.(ContextCollector).CollectMetricsContext(c.ctx, mts)
+}
+
+// collectorWithContext returns p, collecting with ctx when it is a
+// ContextCollector.
+func collectorWithContext(ctx context.Context, p CollectorPlugin) CollectorPlugin {
+	if _, ok := p.(ContextCollector); ok {
+		return contextCollector{CollectorPlugin: p, ctx: ctx}
+	}
+	return p
+}
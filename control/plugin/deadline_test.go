@@ -0,0 +1,145 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// stuckCollector collects once release is closed
+type stuckCollector struct {
+	mockPlugin
+	release chan struct{}
+	calls   int32
+}
+
+func (c *stuckCollector) CollectMetrics(mts []MetricType) ([]MetricType, error) {
+	atomic.AddInt32(&c.calls, 1)
+	<-c.release
+	return c.mockPlugin.CollectMetrics(mts)
+}
+
+// contextCollectorPlugin collects until the context of the call is done
+type contextCollectorPlugin struct {
+	mockPlugin
+	err chan error
+}
+
+func (c *contextCollectorPlugin) CollectMetricsContext(ctx context.Context, mts []MetricType) ([]MetricType, error) {
+	<-ctx.Done()
+	c.err <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+// contextPublisher records the deadline of each call
+type contextPublisher struct {
+	deadlines []time.Time
+}
+
+func (p *contextPublisher) Publish(contentType string, content []byte, config map[string]ctypes.ConfigValue) error {
+	panic("PublishContext must be called instead")
+}
+
+func (p *contextPublisher) PublishContext(ctx context.Context, contentType string, content []byte, config map[string]ctypes.ConfigValue) error {
+	d, _ := ctx.Deadline()
+	p.deadlines = append(p.deadlines, d)
+	return nil
+}
+
+func (p *contextPublisher) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	return cpolicy.New(), nil
+}
+
+func TestDeadline(t *testing.T) {
+	Convey("A collection with a deadline", t, func() {
+		m := NewPluginMeta("deadline", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		c := &stuckCollector{release: make(chan struct{})}
+		var once sync.Once
+		release := func() { once.Do(func() { close(c.release) }) }
+		defer release()
+		ss, err, _ := NewSessionState(`{"LogLevel": "info"}`, c, m)
+		So(err, ShouldBeNil)
+		var buf bytes.Buffer
+		ss.Logger().(*log.Entry).Logger.Out = &buf
+		collect := func(p CollectorPlugin, deadline time.Time) error {
+			proxy := &collectorPluginProxy{Plugin: p, Session: ss, Meta: m}
+			in, err := ss.Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: ss.Token(), Deadline: deadline})
+			So(err, ShouldBeNil)
+			return proxy.CollectMetrics(in, &[]byte{})
+		}
+
+		Convey("succeeds when the deadline is met", func() {
+			release()
+			So(collect(c, time.Now().Add(time.Minute)), ShouldBeNil)
+			So(atomic.LoadInt32(&c.calls), ShouldEqual, 1)
+		})
+		Convey("is abandoned once the deadline is exceeded", func() {
+			start := time.Now()
+			err := collect(c, start.Add(50*time.Millisecond))
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeDeadlineExceeded)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+			So(atomic.LoadInt32(&c.calls), ShouldEqual, 1)
+			So(buf.String(), ShouldContainSubstring, "Abandoning Collector.CollectMetrics still running at its deadline")
+		})
+		Convey("cancels the context given to a ContextCollector", func() {
+			cc := &contextCollectorPlugin{err: make(chan error, 1)}
+			err := collect(cc, time.Now().Add(50*time.Millisecond))
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeDeadlineExceeded)
+			So((<-cc.err).Error(), ShouldEqual, "context deadline exceeded")
+		})
+		Convey("fails without calling the plugin when the deadline has passed", func() {
+			err := collect(c, time.Now().Add(-time.Second))
+			So(err, ShouldEqual, ErrDeadlineExceeded)
+			So(atomic.LoadInt32(&c.calls), ShouldEqual, 0)
+		})
+	})
+	Convey("A ContextPublisher is given the deadline of the call", t, func() {
+		m := NewPluginMeta("deadline", 1, PublisherPluginType, []string{SnapGOBContentType}, nil, Unsecure(true))
+		p := &contextPublisher{}
+		ss, err, _ := NewSessionState("{}", p, m)
+		So(err, ShouldBeNil)
+		proxy := &publisherPluginProxy{Plugin: p, Session: ss, Meta: m, config: ss.config}
+		publish := func(deadline time.Time) error {
+			in, err := ss.Encode(PublishArgs{ContentType: SnapGOBContentType, Token: ss.Token(), Deadline: deadline})
+			So(err, ShouldBeNil)
+			return proxy.Publish(in, &[]byte{})
+		}
+		deadline := time.Now().Add(time.Minute)
+		So(publish(deadline), ShouldBeNil)
+		So(publish(time.Time{}), ShouldBeNil)
+		So(publish(time.Now().Add(-time.Second)), ShouldEqual, ErrDeadlineExceeded)
+		So(p.deadlines, ShouldHaveLength, 2)
+		So(p.deadlines[0].Equal(deadline), ShouldBeTrue)
+		So(p.deadlines[1].IsZero(), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,337 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+// ErrDiagnosticFailed is returned by Start when the plugin was run by hand
+// and a step of its diagnostic failed, see Diagnose.
+var ErrDiagnosticFailed = errors.New("plugin diagnostic failed")
+
+// DefaultDiagnosticStreamTimeout is how long the diagnostic of a stream
+// collector waits for its first batch of metrics.
+var DefaultDiagnosticStreamTimeout = 10 * time.Second
+
+// DiagnosticReport is what Diagnose found out about a plugin
+type DiagnosticReport struct {
+	Meta  PluginMeta
+	Steps []DiagnosticStep
+	// Metrics are those collected, or returned by a processor
+	Metrics []DiagnosticMetric `json:",omitempty"`
+	// OK is set when no step failed
+	OK bool
+}
+
+// DiagnosticStep is a call made to the plugin by Diagnose
+type DiagnosticStep struct {
+	Name     string
+	Duration time.Duration
+	Result   string `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+// DiagnosticMetric is a metric returned by the plugin to Diagnose
+type DiagnosticMetric struct {
+	Namespace string
+	Value     interface{}
+	Unit      string            `json:",omitempty"`
+	Tags      map[string]string `json:",omitempty"`
+	Timestamp time.Time
+}
+
+// Diagnose smoke-tests a plugin outside of control and writes the report to
+// out, as a table or as JSON when args hold --json.  A collector has its
+// catalog collected once with the default config of its policy.  A
+// processor or publisher has its policy validated and processes or
+// publishes the snap.json metrics read from in, when in is not nil.  It
+// returns the exit status of the plugin, 0 when every step succeeded.
+func Diagnose(m *PluginMeta, c Plugin, args []string, in io.Reader, out io.Writer) int {
+	r := diagnose(m, c, in)
+	var err error
+	if hasFlag(args, "--json") {
+		var b []byte
+		if b, err = json.MarshalIndent(r, "", "  "); err == nil {
+			_, err = fmt.Fprintf(out, "%s\n", b)
+		}
+	} else {
+		err = r.write(out)
+	}
+	if err != nil || !r.OK {
+		return 1
+	}
+	return 0
+}
+
+func hasFlag(args []string, flag string) bool {
+	for _, a := range args {
+		if a == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func diagnose(m *PluginMeta, c Plugin, in io.Reader) *DiagnosticReport {
+	r := &DiagnosticReport{Meta: *m, OK: true}
+	var policy *cpolicy.ConfigPolicy
+	if !r.step("GetConfigPolicy", func() (string, error) {
+		var err error
+		if policy, err = c.GetConfigPolicy(); err != nil {
+			return "", err
+		}
+		if policy == nil {
+			policy = cpolicy.New()
+		}
+		return fmt.Sprintf("%d policy nodes", len(policy.GetAll())), nil
+	}) {
+		return r
+	}
+	switch m.Type {
+	case CollectorPluginType, StreamCollectorPluginType:
+		r.diagnoseCollector(m, c, policy)
+	case ProcessorPluginType, PublisherPluginType:
+		r.diagnosePayload(m, c, policy, in)
+	}
+	return r
+}
+
+// step runs f as the step name and records its outcome, returning whether
+// it succeeded.  A panic of the plugin fails the step.
+func (r *DiagnosticReport) step(name string, f func() (string, error)) bool {
+	start := time.Now()
+	result, err := func() (result string, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panic: %v", p)
+			}
+		}()
+		return f()
+	}()
+	s := DiagnosticStep{Name: name, Duration: time.Since(start), Result: result}
+	if err != nil {
+		s.Error = err.Error()
+		r.OK = false
+	}
+	r.Steps = append(r.Steps, s)
+	return err == nil
+}
+
+func (r *DiagnosticReport) addMetrics(mts []MetricType) {
+	for _, mt := range mts {
+		r.Metrics = append(r.Metrics, DiagnosticMetric{
+			Namespace: mt.Namespace().String(),
+			Value:     mt.Data(),
+			Unit:      mt.Unit(),
+			Tags:      mt.Tags(),
+			Timestamp: mt.Timestamp(),
+		})
+	}
+}
+
+// defaultConfig returns the config made of the defaults of the policy node
+// of ns, failing when a required key has no default.
+func defaultConfig(policy *cpolicy.ConfigPolicy, ns []string) (map[string]ctypes.ConfigValue, error) {
+	res, perrs := policy.Get(ns).Process(map[string]ctypes.ConfigValue{})
+	if perrs.HasErrors() {
+		errs := make([]string, len(perrs.Errors()))
+		for i, e := range perrs.Errors() {
+			errs[i] = e.Error()
+		}
+		return nil, fmt.Errorf("invalid default config: %s", strings.Join(errs, "; "))
+	}
+	return *res, nil
+}
+
+func (r *DiagnosticReport) diagnoseCollector(m *PluginMeta, c Plugin, policy *cpolicy.ConfigPolicy) {
+	var catalog []MetricType
+	if !r.step("GetMetricTypes", func() (string, error) {
+		var err error
+		if m.Type == StreamCollectorPluginType {
+			catalog, err = c.(StreamCollector).GetMetricTypes(NewPluginConfigType())
+		} else {
+			catalog, err = collectorPlugin(c, nil).GetMetricTypes(NewPluginConfigType())
+		}
+		return fmt.Sprintf("%d metric types", len(catalog)), err
+	}) {
+		return
+	}
+	for i, mt := range catalog {
+		config, err := defaultConfig(policy, mt.Namespace().Strings())
+		if err != nil {
+			r.step("CollectMetrics", func() (string, error) {
+				return "", fmt.Errorf("%s: %v", mt.Namespace(), err)
+			})
+			return
+		}
+		catalog[i].Config_ = cdata.FromTable(config)
+	}
+	if m.Type == StreamCollectorPluginType {
+		r.step("StreamMetrics", func() (string, error) {
+			mts, err := firstStreamBatch(c.(StreamCollector), DefaultDiagnosticStreamTimeout)
+			r.addMetrics(mts)
+			return fmt.Sprintf("%d metrics", len(mts)), err
+		})
+		return
+	}
+	r.step("CollectMetrics", func() (string, error) {
+		mts, err := collectorPlugin(c, nil).CollectMetrics(catalog)
+		r.addMetrics(mts)
+		return fmt.Sprintf("%d metrics", len(mts)), err
+	})
+}
+
+// firstStreamBatch returns the first batch sent by a stream collector
+// within timeout, and stops the stream.
+func firstStreamBatch(sc StreamCollector, timeout time.Duration) ([]MetricType, error) {
+	send := make(chan []MetricType)
+	errs := make(chan error)
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- sc.StreamMetrics(send, errs, stop) }()
+	defer func() {
+		close(stop)
+		for {
+			select {
+			case <-send:
+			case <-errs:
+			case <-done:
+				return
+			}
+		}
+	}()
+	select {
+	case mts := <-send:
+		return mts, nil
+	case err := <-errs:
+		return nil, err
+	case err := <-done:
+		done <- err
+		if err == nil {
+			err = errors.New("stream ended without sending metrics")
+		}
+		return nil, err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("no metrics streamed within %v", timeout)
+	}
+}
+
+func (r *DiagnosticReport) diagnosePayload(m *PluginMeta, c Plugin, policy *cpolicy.ConfigPolicy, in io.Reader) {
+	var config map[string]ctypes.ConfigValue
+	if !r.step("ValidateConfigPolicy", func() (string, error) {
+		var err error
+		config, err = defaultConfig(policy, []string{""})
+		return fmt.Sprintf("%d keys with defaults", len(config)), err
+	}) {
+		return
+	}
+	if in == nil {
+		return
+	}
+	var mts []MetricType
+	if !r.step("ReadPayload", func() (string, error) {
+		b, err := ioutil.ReadAll(in)
+		if err != nil {
+			return "", err
+		}
+		if mts, err = DecodeMetrics(SnapJSONContentType, b); err != nil {
+			return "", fmt.Errorf("the sample payload is not snap.json metrics: %v", err)
+		}
+		return fmt.Sprintf("%d metrics", len(mts)), nil
+	}) {
+		return
+	}
+	contentType := SnapGOBContentType
+	if len(m.AcceptedContentTypes) > 0 && m.AcceptedContentTypes[0] != SnapAllContentType {
+		contentType = m.AcceptedContentTypes[0]
+	}
+	content, err := EncodeMetrics(contentType, mts)
+	if err != nil {
+		r.step("EncodePayload", func() (string, error) { return "", err })
+		return
+	}
+	if m.Type == PublisherPluginType {
+		r.step("Publish", func() (string, error) {
+			err := publisherPlugin(c, nil).Publish(contentType, content, config)
+			return fmt.Sprintf("%d metrics as %s", len(mts), contentType), err
+		})
+		return
+	}
+	r.step("Process", func() (string, error) {
+		ct, out, err := processorPlugin(c, nil).Process(contentType, content, config)
+		if err != nil {
+			return "", err
+		}
+		if ct, err = returnedContentType(m, contentType, ct); err != nil {
+			return "", err
+		}
+		processed, err := DecodeMetrics(ct, out)
+		r.addMetrics(processed)
+		return fmt.Sprintf("%d metrics returned as %s", len(processed), ct), err
+	})
+}
+
+// write writes the report as tables
+func (r *DiagnosticReport) write(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Plugin\t%s version %d (%s, %s)\n\n", r.Meta.Name, r.Meta.Version, r.Meta.Type, r.Meta.RPCType)
+	fmt.Fprintf(w, "STEP\tDURATION\tRESULT\n")
+	for _, s := range r.Steps {
+		result := s.Result
+		if s.Error != "" {
+			result = "FAILED: " + s.Error
+		}
+		fmt.Fprintf(w, "%s\t%v\t%s\n", s.Name, s.Duration, result)
+	}
+	if len(r.Metrics) > 0 {
+		fmt.Fprintf(w, "\nNAMESPACE\tVALUE\tUNIT\tTAGS\tTIMESTAMP\n")
+		for _, mt := range r.Metrics {
+			fmt.Fprintf(w, "%s\t%v\t%s\t%s\t%s\n", mt.Namespace, mt.Value, mt.Unit, formatTags(mt.Tags), mt.Timestamp.Format(time.RFC3339))
+		}
+	}
+	if r.OK {
+		fmt.Fprintf(w, "\nOK\n")
+	} else {
+		fmt.Fprintf(w, "\nFAILED\n")
+	}
+	return w.Flush()
+}
+
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,149 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// brokenCollector fails its collections with err, or panics without one
+type brokenCollector struct {
+	toyCollector
+	err error
+}
+
+func (c *brokenCollector) CollectMetrics(mts []MetricType) ([]MetricType, error) {
+	if c.err == nil {
+		panic("collector without an error")
+	}
+	return nil, c.err
+}
+
+// echoProcessor passes its metrics through, with a valid policy
+type echoProcessor struct {
+	transcodingProcessor
+}
+
+func (p *echoProcessor) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	return cpolicy.New(), nil
+}
+
+// strictSink requires a key its policy gives no default
+type strictSink struct {
+	MockPublisher
+}
+
+func (s *strictSink) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	policy := cpolicy.New()
+	node := cpolicy.NewPolicyNode()
+	r, err := cpolicy.NewStringRule("dsn", true)
+	if err != nil {
+		return nil, err
+	}
+	node.Add(r)
+	policy.Add([]string{""}, node)
+	return policy, nil
+}
+
+func TestDiagnose(t *testing.T) {
+	collector := NewPluginMeta("toy", 1, CollectorPluginType, nil, nil, Unsecure(true))
+	processor := NewPluginMeta("passthru", 1, ProcessorPluginType, []string{SnapAllContentType}, []string{SnapGOBContentType}, Unsecure(true))
+	publisher := NewPluginMeta("sink", 1, PublisherPluginType, []string{SnapGOBContentType}, nil, Unsecure(true))
+	payload := func() *bytes.Reader {
+		mts := []MetricType{*NewMetricType(core.NewNamespace("sample", "metric"), time.Now(), nil, "", 7)}
+		b, err := EncodeMetrics(SnapJSONContentType, mts)
+		So(err, ShouldBeNil)
+		return bytes.NewReader(b)
+	}
+
+	Convey("A collector run by hand", t, func() {
+		var out bytes.Buffer
+		Convey("collects its catalog once and prints a table", func() {
+			So(Diagnose(collector, &toyCollector{}, nil, nil, &out), ShouldEqual, 0)
+			So(out.String(), ShouldContainSubstring, "toy version 1 (collector, native)")
+			So(out.String(), ShouldContainSubstring, "2 metric types")
+			So(out.String(), ShouldContainSubstring, "/toy/calls")
+			So(out.String(), ShouldContainSubstring, "/toy/double")
+			So(out.String(), ShouldEndWith, "\nOK\n")
+		})
+		Convey("prints the report as JSON with --json", func() {
+			So(Diagnose(collector, &toyCollector{}, []string{"--json"}, nil, &out), ShouldEqual, 0)
+			var r DiagnosticReport
+			So(json.Unmarshal(out.Bytes(), &r), ShouldBeNil)
+			So(r.OK, ShouldBeTrue)
+			So(r.Meta.Name, ShouldEqual, "toy")
+			So(r.Steps, ShouldHaveLength, 3)
+			So([]string{r.Steps[0].Name, r.Steps[1].Name, r.Steps[2].Name}, ShouldResemble, []string{"GetConfigPolicy", "GetMetricTypes", "CollectMetrics"})
+			So(r.Metrics, ShouldHaveLength, 2)
+			So(r.Metrics[1].Value, ShouldEqual, 2)
+		})
+		Convey("reports a panic as a failed step", func() {
+			So(Diagnose(collector, &brokenCollector{}, nil, nil, &out), ShouldEqual, 1)
+			So(out.String(), ShouldContainSubstring, "FAILED: panic: ")
+		})
+		Convey("exits with 1 when the collection fails", func() {
+			So(Diagnose(collector, &brokenCollector{err: errors.New("no device")}, nil, nil, &out), ShouldEqual, 1)
+			So(out.String(), ShouldContainSubstring, "FAILED: no device")
+			So(out.String(), ShouldEndWith, "\nFAILED\n")
+		})
+	})
+	Convey("A processor run by hand processes the payload on stdin", t, func() {
+		var out bytes.Buffer
+		So(Diagnose(processor, &echoProcessor{}, []string{"--json"}, payload(), &out), ShouldEqual, 0)
+		var r DiagnosticReport
+		So(json.Unmarshal(out.Bytes(), &r), ShouldBeNil)
+		So(r.Steps[len(r.Steps)-1].Name, ShouldEqual, "Process")
+		So(r.Metrics, ShouldHaveLength, 1)
+		So(r.Metrics[0].Namespace, ShouldEqual, "/sample/metric")
+	})
+	Convey("A publisher run by hand", t, func() {
+		var out bytes.Buffer
+		Convey("publishes the payload on stdin", func() {
+			sink := new(flakySink)
+			So(Diagnose(publisher, sink, nil, payload(), &out), ShouldEqual, 0)
+			So(sink.calls, ShouldHaveLength, 1)
+			So(out.String(), ShouldContainSubstring, "1 metrics as snap.gob")
+		})
+		Convey("only validates its policy without a payload", func() {
+			sink := new(flakySink)
+			So(Diagnose(publisher, sink, nil, nil, &out), ShouldEqual, 0)
+			So(sink.calls, ShouldBeEmpty)
+		})
+		Convey("fails a payload which is not snap.json", func() {
+			So(Diagnose(publisher, new(flakySink), nil, strings.NewReader("garbage"), &out), ShouldEqual, 1)
+			So(out.String(), ShouldContainSubstring, "not snap.json metrics")
+		})
+		Convey("fails a policy requiring a key without default", func() {
+			So(Diagnose(publisher, new(strictSink), nil, payload(), &out), ShouldEqual, 1)
+			So(out.String(), ShouldContainSubstring, "required key missing (dsn)")
+		})
+	})
+}
@@ -0,0 +1,147 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	// DefaultKillDrainTimeout is how long Kill waits for in-flight calls
+	// when Arg.KillDrainTimeout is not set.
+	DefaultKillDrainTimeout = 5 * time.Second
+	// DefaultKillDelay is how long a killed plugin waits, once drained,
+	// before it exits when Arg.KillDelay is not set.  It gives the reply to
+	// Kill time to reach control.
+	DefaultKillDelay = 2 * time.Second
+)
+
+// ErrSessionDraining is returned by calls made after the session was killed
+var ErrSessionDraining error = &PluginError{Code: ErrorCodeUnavailable, Message: "session is shutting down"}
+
+// KillReply is the reply to Kill
+type KillReply struct {
+	// Drained is set when every in-flight call finished before the drain
+	// timeout
+	Drained bool
+	// InFlight is the number of calls still running when Kill gave up
+	InFlight int
+}
+
+// callTracker counts the calls in flight so that Kill can wait for them.
+type callTracker struct {
+	mutex    sync.Mutex
+	draining bool
+	inflight int
+	idle     chan struct{}
+}
+
+// begin counts a new call, unless the session is draining.
+func (t *callTracker) begin() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.draining {
+		return ErrSessionDraining
+	}
+	t.inflight++
+	return nil
+}
+
+func (t *callTracker) end() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.inflight--
+	if t.inflight == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+// drain refuses new calls and waits up to timeout for the calls in flight.
+// It returns whether they all finished and how many are still running.
+func (t *callTracker) drain(timeout time.Duration) (bool, int) {
+	t.mutex.Lock()
+	t.draining = true
+	if t.inflight == 0 {
+		t.mutex.Unlock()
+		return true, 0
+	}
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	idle := t.idle
+	t.mutex.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+		return true, 0
+	case <-timer.C:
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.inflight == 0, t.inflight
+}
+
+// beginCall counts a call to the plugin as in flight.  It fails once the
+// session has been killed.  When the plugin limits its concurrent calls the
+// call waits for a free worker, or fails with ErrBusy when the queue is
+// full.  The wait ends with ctx.  Each successful beginCall must be followed
+// by endCall.
+func (s *SessionState) beginCall(ctx context.Context) error {
+	if err := s.inflight.begin(); err != nil {
+		return err
+	}
+	if err := s.pool.acquire(ctx); err != nil {
+		s.inflight.end()
+		return err
+	}
+	return nil
+}
+
+func (s *SessionState) endCall() {
+	s.pool.release()
+	s.inflight.end()
+}
+
+// drain waits for the calls in flight before the session is killed.  The
+// context of the calls is cancelled first, so that the plugins watching it
+// return promptly.  The batches of a publisher are flushed last.
+func (s *SessionState) drain() (bool, int) {
+	s.base.end()
+	drained, n := s.inflight.drain(s.KillDrainTimeout)
+	if !drained {
+		s.Logger().Warnf("Abandoning %d calls still running after %v", n, s.KillDrainTimeout)
+	}
+	s.batches.flushAll()
+	return drained, n
+}
+
+// scheduleKill ends the session for control once KillDelay has passed.
+func (s *SessionState) scheduleKill(reason string) {
+	go func() {
+		time.Sleep(s.KillDelay)
+		s.endSession(Shutdown{Reason: reason, Source: ShutdownSourceControl})
+	}()
+}
@@ -0,0 +1,169 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// slowCollector takes delay to collect
+type slowCollector struct {
+	mockPlugin
+
+	delay   time.Duration
+	started chan struct{}
+}
+
+func (s *slowCollector) CollectMetrics(mts []MetricType) ([]MetricType, error) {
+	close(s.started)
+	time.Sleep(s.delay)
+	return mts, nil
+}
+
+func TestKillDrain(t *testing.T) {
+	Convey("Kill with a call in flight", t, func() {
+		collector := &slowCollector{started: make(chan struct{})}
+		ss := &SessionState{
+			Arg:      &Arg{PingTimeoutDuration: time.Minute},
+			Encoder:  encoding.NewGobEncoder(),
+			plugin:   collector,
+			token:    "s3cr3t",
+			logger:   log.New(),
+			killChan: make(chan int, 1),
+		}
+		proxy := &collectorPluginProxy{Plugin: collector, Session: ss}
+		collect := func() chan error {
+			errc := make(chan error, 1)
+			in, err := ss.Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: "s3cr3t"})
+			So(err, ShouldBeNil)
+			go func() {
+				errc <- proxy.CollectMetrics(in, &[]byte{})
+			}()
+			return errc
+		}
+		kill := func() KillReply {
+			in, err := ss.Encode(KillArgs{Reason: "testing", Token: "s3cr3t"})
+			So(err, ShouldBeNil)
+			var out []byte
+			So(ss.Kill(in, &out), ShouldBeNil)
+			var r KillReply
+			So(ss.Decode(out, &r), ShouldBeNil)
+			return r
+		}
+
+		Convey("waits for the call to finish", func() {
+			collector.delay = 200 * time.Millisecond
+			ss.KillDrainTimeout = 5 * time.Second
+			errc := collect()
+			<-collector.started
+
+			start := time.Now()
+			r := kill()
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 100*time.Millisecond)
+			So(r.Drained, ShouldBeTrue)
+			So(r.InFlight, ShouldEqual, 0)
+			So(<-errc, ShouldBeNil)
+
+			Convey("and refuses new calls", func() {
+				in, err := ss.Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: "s3cr3t"})
+				So(err, ShouldBeNil)
+				So(proxy.CollectMetrics(in, &[]byte{}), ShouldEqual, ErrSessionDraining)
+			})
+		})
+		Convey("gives up after the drain timeout", func() {
+			collector.delay = 2 * time.Second
+			ss.KillDrainTimeout = 100 * time.Millisecond
+			collect()
+			<-collector.started
+
+			start := time.Now()
+			r := kill()
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+			So(r.Drained, ShouldBeFalse)
+			So(r.InFlight, ShouldEqual, 1)
+		})
+		Convey("returns at once when nothing is in flight", func() {
+			ss.KillDrainTimeout = 5 * time.Second
+			start := time.Now()
+			r := kill()
+			So(time.Since(start), ShouldBeLessThan, 100*time.Millisecond)
+			So(r.Drained, ShouldBeTrue)
+		})
+	})
+}
+
+func TestKillDelay(t *testing.T) {
+	Convey("A killed session", t, func() {
+		ss := &SessionState{
+			Arg:      &Arg{PingTimeoutDuration: time.Minute, KillDelay: time.Millisecond},
+			Encoder:  encoding.NewGobEncoder(),
+			token:    "s3cr3t",
+			logger:   log.New(),
+			killChan: make(chan int, 1),
+		}
+		kill := func() {
+			in, err := ss.Encode(KillArgs{Reason: "testing", Token: "s3cr3t"})
+			So(err, ShouldBeNil)
+			So(ss.Kill(in, &[]byte{}), ShouldBeNil)
+		}
+
+		Convey("ends the session after the KillDelay", func() {
+			kill()
+			kill()
+			select {
+			case <-ss.Done():
+			case <-time.After(time.Second):
+				t.Fatal("the session did not end")
+			}
+			So(ss.ShutdownReason().Source, ShouldEqual, ShutdownSourceControl)
+			So(<-ss.KillChan(), ShouldEqual, 0)
+		})
+		Convey("does not block when nothing reads KillChan", func() {
+			ss.killChan = make(chan int)
+			before := runtime.NumGoroutine()
+			kill()
+			time.Sleep(50 * time.Millisecond)
+			So(runtime.NumGoroutine(), ShouldBeLessThanOrEqualTo, before)
+		})
+	})
+	Convey("NewSessionState", t, func() {
+		m := &PluginMeta{Name: "mock", Version: 1, RPCType: NativeRPC, Type: CollectorPluginType, Unsecure: true}
+		Convey("defaults the KillDelay", func() {
+			ss, err, _ := NewSessionState("{}", &MockPlugin{}, m)
+			So(err, ShouldBeNil)
+			So(ss.KillDelay, ShouldEqual, DefaultKillDelay)
+		})
+		Convey("takes the KillDelay from Arg", func() {
+			ss, err, _ := NewSessionState(fmt.Sprintf(`{"KillDelay": %d}`, 10*time.Millisecond), &MockPlugin{}, m)
+			So(err, ShouldBeNil)
+			So(ss.KillDelay, ShouldEqual, 10*time.Millisecond)
+		})
+	})
+}
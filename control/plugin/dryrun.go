@@ -0,0 +1,121 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+// DryRunKey is the config key which, set to true, makes the session of a
+// publisher log what Publish would be given instead of calling it, see
+// DryRunSummary.
+const DryRunKey = "dry_run"
+
+// DryRunSummary describes the payload of a Publish call skipped by a dry
+// run.
+type DryRunSummary struct {
+	ContentType string
+	// Bytes is the size of the payload, uncompressed
+	Bytes int
+	// Metrics is the number of metrics decoded from the payload, and
+	// FirstNamespace and LastNamespace the namespaces of the first and last
+	// of them
+	Metrics        int
+	FirstNamespace string `json:",omitempty"`
+	LastNamespace  string `json:",omitempty"`
+	// DecodeError is set when the payload could not be decoded
+	DecodeError string `json:",omitempty"`
+	// Preview is what the plugin would have written, see DryRunPreviewer
+	Preview string `json:",omitempty"`
+}
+
+func (d *DryRunSummary) String() string {
+	s := fmt.Sprintf("content type %s, %d bytes", d.ContentType, d.Bytes)
+	if d.DecodeError != "" {
+		s += fmt.Sprintf(", undecodable: %s", d.DecodeError)
+	} else {
+		s += fmt.Sprintf(", %d metrics", d.Metrics)
+	}
+	if d.Metrics > 0 {
+		s += fmt.Sprintf(" from %s to %s", d.FirstNamespace, d.LastNamespace)
+	}
+	if d.Preview != "" {
+		s += fmt.Sprintf(", preview: %s", d.Preview)
+	}
+	return s
+}
+
+// DryRunPreviewer is implemented by publishers which render what they would
+// write for the payload of a dry run, e.g. the SQL they would run.  The
+// preview is logged and returned in the DryRunSummary.
+type DryRunPreviewer interface {
+	PreviewPublish(contentType string, content []byte, config map[string]ctypes.ConfigValue) (string, error)
+}
+
+// AddDryRunRule adds the optional rule of the dry run key to the config
+// policy node of a publisher.
+func AddDryRunRule(node *cpolicy.ConfigPolicyNode) error {
+	r, err := cpolicy.NewBoolRule(DryRunKey, false, false)
+	if err != nil {
+		return err
+	}
+	node.Add(r)
+	return nil
+}
+
+// isDryRun returns whether a publish call with config is a dry run
+func isDryRun(config map[string]ctypes.ConfigValue) bool {
+	v, ok := config[DryRunKey].(ctypes.ConfigValueBool)
+	return ok && v.Value
+}
+
+// dryRunPreviewer returns the preview function of plugin, or nil when it
+// has none.
+func dryRunPreviewer(plugin Plugin) func(string, []byte, map[string]ctypes.ConfigValue) (string, error) {
+	if p, ok := plugin.(DryRunPreviewer); ok {
+		return p.PreviewPublish
+	}
+	return nil
+}
+
+// dryRun summarizes the payload of a publish call in place of publishing
+// it, logs the summary and counts the dry run in the stats of the call.
+func dryRun(session Session, call *requestCall, preview func(string, []byte, map[string]ctypes.ConfigValue) (string, error), contentType string, content []byte, config map[string]ctypes.ConfigValue) *DryRunSummary {
+	d := &DryRunSummary{ContentType: contentType, Bytes: len(content)}
+	if mts, err := DecodeMetrics(contentType, content); err != nil {
+		d.DecodeError = err.Error()
+	} else if len(mts) > 0 {
+		d.Metrics = len(mts)
+		d.FirstNamespace = mts[0].Namespace().String()
+		d.LastNamespace = mts[len(mts)-1].Namespace().String()
+	}
+	if preview != nil {
+		var err error
+		if d.Preview, err = preview(contentType, content, config); err != nil {
+			d.Preview = fmt.Sprintf("preview failed: %v", err)
+		}
+	}
+	session.Logger().Infof("Dry run of Publish: %s", d)
+	call.recordDryRun()
+	return d
+}
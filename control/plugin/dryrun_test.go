@@ -0,0 +1,119 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"errors"
+	stdlog "log"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/intelsdi-x/snap/core"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// previewSink counts its publishes and previews them as an SQL statement,
+// or fails to with err
+type previewSink struct {
+	MockPublisher
+	publishes int
+	err       error
+}
+
+func (s *previewSink) Publish(_ string, _ []byte, _ map[string]ctypes.ConfigValue) error {
+	s.publishes++
+	return nil
+}
+
+func (s *previewSink) PreviewPublish(contentType string, content []byte, _ map[string]ctypes.ConfigValue) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	return "INSERT INTO metrics VALUES (...)", nil
+}
+
+func TestDryRun(t *testing.T) {
+	Convey("A publisher called with dry_run", t, func() {
+		m := NewPluginMeta("dry", 1, PublisherPluginType, []string{SnapGOBContentType}, nil, Unsecure(true))
+		mts := []MetricType{
+			*NewMetricType(core.NewNamespace("foo", "first"), time.Now(), nil, "", 1),
+			*NewMetricType(core.NewNamespace("foo", "middle"), time.Now(), nil, "", 2),
+			*NewMetricType(core.NewNamespace("foo", "last"), time.Now(), nil, "", 3),
+		}
+		content, err := EncodeMetrics(SnapGOBContentType, mts)
+		So(err, ShouldBeNil)
+		publish := func(sink Plugin, config map[string]ctypes.ConfigValue) (*SessionState, string, PublishReply) {
+			ss, err, _ := NewSessionState("{}", sink, m)
+			So(err, ShouldBeNil)
+			var buf bytes.Buffer
+			ss.SetLogger(NewStdLogger(stdlog.New(&buf, "", 0), log.InfoLevel))
+			proxy := &publisherPluginProxy{Plugin: sink.(PublisherPlugin), Session: ss, Meta: m, config: ss.config, requests: ss.requests, preview: dryRunPreviewer(sink)}
+			in, err := ss.Encode(PublishArgs{ContentType: SnapGOBContentType, Content: content, Token: ss.Token(), Config: config})
+			So(err, ShouldBeNil)
+			var out []byte
+			So(proxy.Publish(in, &out), ShouldBeNil)
+			var reply PublishReply
+			So(ss.Decode(out, &reply), ShouldBeNil)
+			return ss, buf.String(), reply
+		}
+		dry := map[string]ctypes.ConfigValue{DryRunKey: ctypes.ConfigValueBool{Value: true}}
+
+		Convey("does not call the sink and logs a summary", func() {
+			sink := new(flakySink)
+			ss, logged, reply := publish(sink, dry)
+			So(sink.calls, ShouldBeEmpty)
+			So(reply.DryRun, ShouldResemble, &DryRunSummary{
+				ContentType:    SnapGOBContentType,
+				Bytes:          len(content),
+				Metrics:        3,
+				FirstNamespace: "/foo/first",
+				LastNamespace:  "/foo/last",
+			})
+			So(logged, ShouldContainSubstring, "[info] Dry run of Publish: content type snap.gob")
+			So(logged, ShouldContainSubstring, "3 metrics from /foo/first to /foo/last")
+			methods, _, _ := ss.stats.snapshot()
+			So(methods["Publisher.Publish"].DryRuns, ShouldEqual, 1)
+		})
+		Convey("returns the preview of a DryRunPreviewer", func() {
+			sink := new(previewSink)
+			_, logged, reply := publish(sink, dry)
+			So(sink.publishes, ShouldEqual, 0)
+			So(reply.DryRun.Preview, ShouldEqual, "INSERT INTO metrics VALUES (...)")
+			So(logged, ShouldContainSubstring, "preview: INSERT INTO metrics")
+		})
+		Convey("reports a preview which failed", func() {
+			sink := &previewSink{err: errors.New("no schema")}
+			_, _, reply := publish(sink, dry)
+			So(sink.publishes, ShouldEqual, 0)
+			So(reply.DryRun.Preview, ShouldEqual, "preview failed: no schema")
+		})
+		Convey("set to false publishes", func() {
+			sink := new(previewSink)
+			_, _, reply := publish(sink, map[string]ctypes.ConfigValue{DryRunKey: ctypes.ConfigValueBool{Value: false}})
+			So(sink.publishes, ShouldEqual, 1)
+			So(reply.DryRun, ShouldBeNil)
+		})
+	})
+}
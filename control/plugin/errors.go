@@ -0,0 +1,212 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// ErrorCode tells control why a plugin did not start, or why a call to it
+// failed
+type ErrorCode int
+
+const (
+	// ErrorCodeNone is the code of a successful Response
+	ErrorCodeNone ErrorCode = iota
+	// ErrorCodeInternal is any error not listed below, including codes
+	// unknown to this version of snap
+	ErrorCodeInternal
+	// ErrorCodeConfigInvalid means the plugin arguments or config are
+	// invalid
+	ErrorCodeConfigInvalid
+	// ErrorCodeBindFailed means the plugin could not listen at the requested
+	// address
+	ErrorCodeBindFailed
+	// ErrorCodePolicyViolation means the config does not satisfy the
+	// plugin's config policy
+	ErrorCodePolicyViolation
+	// ErrorCodeUnsupported means the requested transport, codec or feature
+	// is not supported
+	ErrorCodeUnsupported
+	// ErrorCodeUnauthorized means the call did not carry the session token
+	// or a valid signature
+	ErrorCodeUnauthorized
+	// ErrorCodeUnavailable means the session is shutting down
+	ErrorCodeUnavailable
+	// ErrorCodeCallFailed means the plugin returned an error from the call
+	ErrorCodeCallFailed
+	// ErrorCodeCorruptContent means a compressed payload could not be
+	// decompressed
+	ErrorCodeCorruptContent
+	// ErrorCodeSuspended means the session is suspended, see Suspend
+	ErrorCodeSuspended
+	// ErrorCodeNotReady means the plugin has not completed its Init, see
+	// Initializer
+	ErrorCodeNotReady
+	// ErrorCodeLogFailed means the plugin could not open its log file
+	ErrorCodeLogFailed
+	// ErrorCodeDeadlineExceeded means the call did not complete by its
+	// deadline
+	ErrorCodeDeadlineExceeded
+	// ErrorCodeBusy means the plugin had no worker free to take the call
+	ErrorCodeBusy
+	// ErrorCodeContentTypeMismatch means a processor returned its metrics
+	// in a content type it did not register
+	ErrorCodeContentTypeMismatch
+)
+
+var errorCodes = [...]string{
+	"none",
+	"internal",
+	"config-invalid",
+	"bind-failed",
+	"policy-violation",
+	"unsupported",
+	"unauthorized",
+	"unavailable",
+	"call-failed",
+	"corrupt-content",
+	"suspended",
+	"not-ready",
+	"log-failed",
+	"deadline-exceeded",
+	"busy",
+	"content-type-mismatch",
+}
+
+func (c ErrorCode) String() string {
+	if c < 0 || int(c) >= len(errorCodes) {
+		return errorCodes[ErrorCodeInternal]
+	}
+	return errorCodes[c]
+}
+
+// ParseErrorCode returns the ErrorCode named s, or ErrorCodeInternal.
+func ParseErrorCode(s string) ErrorCode {
+	for i, name := range errorCodes {
+		if s == name {
+			return ErrorCode(i)
+		}
+	}
+	return ErrorCodeInternal
+}
+
+// MarshalJSON encodes the code by name, so that a control which does not
+// know it reads ErrorCodeInternal.
+func (c ErrorCode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+func (c *ErrorCode) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*c = ParseErrorCode(s)
+	return nil
+}
+
+// PluginError is an error with an ErrorCode returned by an RPC call to the
+// plugin.  Whichever the transport or codec, errors reach control as their
+// Error string, "[code] message (request id)", from which ParsePluginError
+// recovers the PluginError, unless the reply carries it, see
+// PublishArgs.ReplyError.
+type PluginError struct {
+	Code    ErrorCode
+	Message string
+	// RequestID is the request ID of the failed call, if it had one
+	RequestID string
+}
+
+func (e *PluginError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("[%s] %s (request %s)", e.Code, e.Message, e.RequestID)
+	}
+	return fmt.Sprintf("[%s] %s", e.Code, e.Message)
+}
+
+var pluginErrorPattern = regexp.MustCompile(`(?s)^\[([a-z][a-z0-9-]*)\] (.*?)(?: \(request ([0-9A-Za-z_.-]+)\))?$`)
+
+// ParsePluginError reads back a PluginError from its Error string, e.g. as
+// returned by net/rpc.  A code unknown to this version of snap is read as
+// ErrorCodeInternal.  ok is false when msg is not a PluginError.
+func ParsePluginError(msg string) (e *PluginError, ok bool) {
+	m := pluginErrorPattern.FindStringSubmatch(msg)
+	if m == nil {
+		return nil, false
+	}
+	return &PluginError{Code: ParseErrorCode(m[1]), Message: m[2], RequestID: m[3]}, true
+}
+
+// toPluginError returns err as a PluginError, read back from its Error
+// string or with ErrorCodeInternal when it is not one.
+func toPluginError(err error) *PluginError {
+	if e, ok := err.(*PluginError); ok {
+		return e
+	}
+	if e, ok := ParsePluginError(err.Error()); ok {
+		return e
+	}
+	return &PluginError{Code: ErrorCodeInternal, Message: err.Error()}
+}
+
+// ErrorCodeOf returns the ErrorCode of an error returned by a call to a
+// plugin: ErrorCodeNone for nil and ErrorCodeInternal for an error which
+// does not carry a code.
+func ErrorCodeOf(err error) ErrorCode {
+	if err == nil {
+		return ErrorCodeNone
+	}
+	if e, ok := err.(*PluginError); ok {
+		return e.Code
+	}
+	if e, ok := ParsePluginError(err.Error()); ok {
+		return e.Code
+	}
+	return ErrorCodeInternal
+}
+
+// FailureCode returns the ErrorCode of a failed Response, ErrorCodeInternal
+// when it came from a plugin which does not set ErrorCode.
+func (r *Response) FailureCode() ErrorCode {
+	if r.State == PluginSuccess {
+		return ErrorCodeNone
+	}
+	if r.ErrorCode == ErrorCodeNone {
+		return ErrorCodeInternal
+	}
+	return r.ErrorCode
+}
+
+// NewErrorResponse returns the failure Response of a plugin which could not
+// start because of err.
+func NewErrorResponse(code ErrorCode, err error) *Response {
+	msg := err.Error()
+	if e, ok := err.(*PluginError); ok {
+		msg = e.Message
+	}
+	return &Response{
+		State:        PluginFailure,
+		ErrorCode:    code,
+		ErrorMessage: msg,
+	}
+}
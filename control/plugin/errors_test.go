@@ -0,0 +1,154 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestErrorCode(t *testing.T) {
+	Convey("ErrorCode", t, func() {
+		Convey("is marshaled by name", func() {
+			b, err := json.Marshal(ErrorCodeBindFailed)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, `"bind-failed"`)
+			for c := ErrorCodeNone; c <= ErrorCodeContentTypeMismatch; c++ {
+				b, err := json.Marshal(c)
+				So(err, ShouldBeNil)
+				var out ErrorCode
+				So(json.Unmarshal(b, &out), ShouldBeNil)
+				So(out, ShouldEqual, c)
+			}
+		})
+		Convey("unknown to this control is read as internal", func() {
+			var r Response
+			So(json.Unmarshal([]byte(`{"State": 1, "ErrorCode": "quota-exceeded", "ErrorMessage": "too many"}`), &r), ShouldBeNil)
+			So(r.ErrorCode, ShouldEqual, ErrorCodeInternal)
+			So(ErrorCode(42).String(), ShouldEqual, "internal")
+		})
+		Convey("of a Response from an older plugin is internal", func() {
+			var r Response
+			So(json.Unmarshal([]byte(`{"State": 1, "ErrorMessage": "failed"}`), &r), ShouldBeNil)
+			So(r.ErrorCode, ShouldEqual, ErrorCodeNone)
+			So(r.FailureCode(), ShouldEqual, ErrorCodeInternal)
+		})
+		Convey("survives a Response round trip", func() {
+			r := NewErrorResponse(ErrorCodeConfigInvalid, errors.New("bad config"))
+			r.ErrorFields = map[string]string{"field": "user"}
+			b, err := json.Marshal(r)
+			So(err, ShouldBeNil)
+			var out Response
+			So(json.Unmarshal(b, &out), ShouldBeNil)
+			So(out.State, ShouldEqual, PluginFailure)
+			So(out.FailureCode(), ShouldEqual, ErrorCodeConfigInvalid)
+			So(out.ErrorMessage, ShouldEqual, "bad config")
+			So(out.ErrorFields, ShouldResemble, map[string]string{"field": "user"})
+		})
+	})
+	Convey("ParsePluginError", t, func() {
+		e, ok := ParsePluginError((&PluginError{Code: ErrorCodePolicyViolation, Message: "port [0, 65535]"}).Error())
+		So(ok, ShouldBeTrue)
+		So(e, ShouldResemble, &PluginError{Code: ErrorCodePolicyViolation, Message: "port [0, 65535]"})
+
+		e, ok = ParsePluginError("[quota-exceeded] too many")
+		So(ok, ShouldBeTrue)
+		So(e, ShouldResemble, &PluginError{Code: ErrorCodeInternal, Message: "too many"})
+
+		_, ok = ParsePluginError("connection reset")
+		So(ok, ShouldBeFalse)
+		So(ErrorCodeOf(errors.New("connection reset")), ShouldEqual, ErrorCodeInternal)
+		So(ErrorCodeOf(nil), ShouldEqual, ErrorCodeNone)
+		So(ErrorCodeOf(ErrBadToken), ShouldEqual, ErrorCodeUnauthorized)
+	})
+}
+
+func TestPluginErrorWire(t *testing.T) {
+	for _, codec := range []string{GobCodec, JSONCodec} {
+		Convey(fmt.Sprintf("A PluginError over the %s codec", codec), t, func() {
+			m := NewPluginMeta("errors", 1, CollectorPluginType, []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+			resp, done := startTestPlugin(m, &failingCollector{}, fmt.Sprintf(`{"Codec": %q, "PingTimeoutDuration": %d}`, codec, time.Minute))
+			conn, err := net.Dial("tcp", resp.ListenAddress)
+			So(err, ShouldBeNil)
+			var client *rpc.Client
+			var enc encoding.Encoder
+			if codec == JSONCodec {
+				client, enc = jsonrpc.NewClient(conn), encoding.NewJsonEncoder()
+			} else {
+				client, enc = rpc.NewClient(conn), encoding.NewGobEncoder()
+			}
+			defer client.Close()
+			call := func(method string, args interface{}) error {
+				in, err := enc.Encode(args)
+				So(err, ShouldBeNil)
+				return client.Call(method, in, &[]byte{})
+			}
+
+			err = call("Collector.CollectMetrics", CollectMetricsArgs{MetricTypes: mockMetricType, Token: resp.Token})
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeCallFailed)
+			e, ok := ParsePluginError(err.Error())
+			So(ok, ShouldBeTrue)
+			So(e.Message, ShouldEqual, "CollectMetrics call error : device unreachable")
+
+			err = call("SessionState.Ping", PingArgs{Token: "bad"})
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeUnauthorized)
+
+			So(call("SessionState.Kill", KillArgs{Reason: "test", Token: resp.Token}), ShouldBeNil)
+			<-done
+		})
+	}
+}
+
+func TestStartErrorResponse(t *testing.T) {
+	Convey("A plugin unable to bind", t, func() {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer l.Close()
+		_, port, _ := net.SplitHostPort(l.Addr().String())
+		resp, done := startTestCollector(fmt.Sprintf(`{"ListenPort": %q}`, port))
+		So(<-done, ShouldEqual, 2)
+		So(resp.State, ShouldEqual, PluginFailure)
+		So(resp.ErrorCode, ShouldEqual, ErrorCodeBindFailed)
+		So(resp.ErrorFields["address"], ShouldEqual, "127.0.0.1:"+port)
+	})
+	Convey("A plugin given invalid arguments", t, func() {
+		resp, done := startTestCollector(`{"TokenLength": 1}`)
+		So(<-done, ShouldEqual, 2)
+		So(resp.State, ShouldEqual, PluginFailure)
+		So(resp.ErrorCode, ShouldEqual, ErrorCodeConfigInvalid)
+		So(resp.ErrorMessage, ShouldEqual, ErrTokenLength.Error())
+	})
+	Convey("A plugin asked for an unsupported codec", t, func() {
+		resp, done := startTestCollector(`{"Codec": "xml"}`)
+		So(<-done, ShouldEqual, 2)
+		So(resp.ErrorCode, ShouldEqual, ErrorCodeUnsupported)
+	})
+}
@@ -0,0 +1,126 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+)
+
+// FakeSession is a SessionState for unit tests of a plugin's handlers.  It
+// serves no listener and logs to a buffer read with Logs.
+type FakeSession struct {
+	*SessionState
+	t    *testing.T
+	logs *logBuffer
+}
+
+// logBuffer is a buffer written by the logger while the test reads it
+type logBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+// NewFakeSession returns a running session with the defaults of NewArg and
+// a session token, logging at debug level.  A killed fake session ends
+// without the KillDelay given to the reply to reach control.
+func NewFakeSession(t *testing.T) *FakeSession {
+	token, err := generateToken(DefaultTokenLength)
+	if err != nil {
+		t.Fatalf("generating the session token: %v", err)
+	}
+	arg := NewArg(int(log.DebugLevel))
+	arg.KillDrainTimeout = DefaultKillDrainTimeout
+	logs := &logBuffer{}
+	logger := log.New()
+	logger.Out = logs
+	logger.Level = log.DebugLevel
+	logger.Formatter = &log.TextFormatter{DisableTimestamp: true, DisableColors: true}
+	s := &SessionState{
+		Arg:         &arg,
+		Encoder:     encoding.NewGobEncoder(),
+		started:     time.Now(),
+		tokenIssued: time.Now(),
+		token:       token,
+		logger:      logger,
+	}
+	s.initShutdown()
+	return &FakeSession{SessionState: s, t: t, logs: logs}
+}
+
+// Logs returns the lines logged by the session
+func (f *FakeSession) Logs() []string {
+	out := strings.TrimSuffix(f.logs.String(), "\n")
+	if out == "" {
+		return nil
+	}
+	lines := strings.Split(out, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return lines
+}
+
+// FirePing pings the session as control does
+func (f *FakeSession) FirePing() error {
+	in, err := f.Encode(PingArgs{Token: f.Token()})
+	if err != nil {
+		f.t.Fatalf("encoding the ping: %v", err)
+	}
+	return f.Ping(in, &[]byte{})
+}
+
+// FireKill kills the session as control does, with reason
+func (f *FakeSession) FireKill(reason string) error {
+	in, err := f.Encode(KillArgs{Reason: reason, Token: f.Token()})
+	if err != nil {
+		f.t.Fatalf("encoding the kill: %v", err)
+	}
+	return f.Kill(in, &[]byte{})
+}
+
+// Killed tells whether the session ends within d, by Kill or otherwise
+func (f *FakeSession) Killed(d time.Duration) bool {
+	select {
+	case <-f.Done():
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
@@ -0,0 +1,341 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/control/plugin/rpc"
+	"github.com/intelsdi-x/snap/core"
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+// GRPCTokenKey is the gRPC metadata key carrying the session token on each
+// call to a plugin served with the GRPC RPCType.
+const GRPCTokenKey = "snap-token"
+
+// newGRPCServer returns a gRPC server for the plugin.  Every call is checked
+// against the session token and resets the session heartbeat, as calls over
+// net/rpc do.
+func newGRPCServer(t PluginType, p Plugin, s Session, tlsConfig *tls.Config) (*grpc.Server, error) {
+	var (
+		requests   *requestTracker
+		base       *sessionContext
+		simulation *simulation
+	)
+	if ss, ok := s.(*SessionState); ok {
+		requests, base, simulation = ss.requests, ss.base, ss.simulation
+	}
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(grpcSessionInterceptor(s, requests, base))}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(opts...)
+	proxy := gRPCPluginProxy{plugin: p, session: s}
+	switch t {
+	case CollectorPluginType:
+		rpc.RegisterCollectorServer(server, &gRPCCollectorProxy{gRPCPluginProxy: proxy, plugin: simulation.wrap(collectorPlugin(p, base))})
+	case PublisherPluginType:
+		rpc.RegisterPublisherServer(server, &gRPCPublisherProxy{gRPCPluginProxy: proxy, plugin: publisherPlugin(p, base), retryable: retryClassifier(p), preview: dryRunPreviewer(p)})
+	case ProcessorPluginType:
+		rpc.RegisterProcessorServer(server, &gRPCProcessorProxy{gRPCPluginProxy: proxy, plugin: processorPlugin(p, base)})
+	default:
+		return nil, fmt.Errorf("Invalid plugin type provided %v", t)
+	}
+	return server, nil
+}
+
+func grpcSessionInterceptor(s Session, requests *requestTracker, base *sessionContext) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		method := grpcMethodName(info.FullMethod)
+		defer func(start time.Time) {
+			s.recordCall(method, time.Since(start), err)
+		}(time.Now())
+		var call *requestCall
+		if tracedMethods[method] {
+			call = requests.start(method)
+			defer call.end(&err)
+		}
+		// Runs before recordCall so that a recovered panic is counted
+		defer s.recoverPanic(method, &err)
+
+		var token, requestID string
+		if md, ok := metadata.FromContext(ctx); ok {
+			if len(md[GRPCTokenKey]) > 0 {
+				token = md[GRPCTokenKey][0]
+			}
+			if len(md[GRPCRequestIDKey]) > 0 {
+				requestID = md[GRPCRequestIDKey][0]
+			}
+		}
+		if requestID != "" {
+			call.setID(requestID)
+			grpc.SendHeader(ctx, metadata.Pairs(GRPCRequestIDKey, requestID))
+		}
+		if err := s.CheckToken(token); err != nil {
+			return nil, err
+		}
+		s.ResetHeartbeat()
+		if tracedMethods[method] {
+			var cancel context.CancelFunc
+			ctx, cancel = base.bind(context.WithValue(ctx, requestIDKey{}, requestID))
+			defer cancel()
+		}
+		resp, err = handler(withRequestCall(ctx, call), req)
+		grpcReplyRequestID(resp, requestID)
+		return resp, err
+	}
+}
+
+// grpcReplyRequestID adds the request ID to the error carried in a reply.
+func grpcReplyRequestID(resp interface{}, id string) {
+	switch r := resp.(type) {
+	case *rpc.MetricsReply:
+		if r.Error != "" {
+			r.Error = withRequestID(errors.New(r.Error), id).Error()
+		}
+	case *rpc.ErrReply:
+		if r.Error != "" {
+			r.Error = withRequestID(errors.New(r.Error), id).Error()
+		}
+	}
+}
+
+// gRPCPluginProxy serves the calls common to all plugin types.
+type gRPCPluginProxy struct {
+	plugin  Plugin
+	session Session
+}
+
+func (g *gRPCPluginProxy) Ping(ctx context.Context, arg *rpc.Empty) (*rpc.ErrReply, error) {
+	g.session.Logger().Debugf("Ping received")
+	return &rpc.ErrReply{}, nil
+}
+
+func (g *gRPCPluginProxy) Kill(ctx context.Context, arg *rpc.KillArg) (*rpc.ErrReply, error) {
+	g.session.Logger().Warnf("Kill called by agent, reason: %s", arg.Reason)
+	if !g.session.stopHeartbeat() {
+		// The heartbeat already expired and ended the session
+		return &rpc.ErrReply{}, nil
+	}
+	drained, n := g.session.drain()
+	g.session.runOnKill(ParseKillReason(arg.Reason))
+	g.session.scheduleKill(arg.Reason)
+	if !drained {
+		return &rpc.ErrReply{Error: fmt.Sprintf("%d calls still running after the drain timeout", n)}, nil
+	}
+	return &rpc.ErrReply{}, nil
+}
+
+func (g *gRPCPluginProxy) GetConfigPolicy(ctx context.Context, arg *rpc.Empty) (*rpc.GetConfigPolicyReply, error) {
+	g.session.Logger().Debugf("GetConfigPolicy called")
+	policy, err := g.plugin.GetConfigPolicy()
+	if err != nil {
+		return &rpc.GetConfigPolicyReply{Error: (&PluginError{Code: ErrorCodeCallFailed, Message: fmt.Sprintf("GetConfigPolicy call error : %s", err.Error())}).Error()}, nil
+	}
+	if policy == nil {
+		policy = cpolicy.New()
+	}
+	return rpc.NewGetConfigPolicyReply(policy)
+}
+
+type gRPCCollectorProxy struct {
+	gRPCPluginProxy
+	plugin CollectorPlugin
+}
+
+func (g *gRPCCollectorProxy) GetMetricTypes(ctx context.Context, arg *rpc.GetMetricTypesArg) (*rpc.MetricsReply, error) {
+	if err := requestCallFrom(ctx).beginCall(ctx, g.session); err != nil {
+		return nil, err
+	}
+	defer g.session.endCall()
+	g.session.Logger().Debugf("GetMetricTypes called")
+	cfg := NewPluginConfigType()
+	if arg.Config != nil {
+		cfg.ConfigDataNode = cdata.FromTable(rpc.ParseConfig(arg.Config))
+	}
+	mts, err := g.plugin.GetMetricTypes(cfg)
+	if err != nil {
+		return &rpc.MetricsReply{Error: (&PluginError{Code: ErrorCodeCallFailed, Message: fmt.Sprintf("GetMetricTypes call error : %s", err.Error())}).Error()}, nil
+	}
+	ms, err := toGRPCMetrics(mts)
+	if err != nil {
+		return &rpc.MetricsReply{Error: err.Error()}, nil
+	}
+	return &rpc.MetricsReply{Metrics: ms}, nil
+}
+
+func (g *gRPCCollectorProxy) CollectMetrics(ctx context.Context, arg *rpc.MetricsArg) (*rpc.MetricsReply, error) {
+	if err := requestCallFrom(ctx).beginCall(ctx, g.session); err != nil {
+		return nil, err
+	}
+	defer g.session.endCall()
+	g.session.Logger().Debugf("CollectMetrics called")
+	mts := fromGRPCMetrics(arg.Metrics)
+	requestCallFrom(ctx).setNamespaces(mts)
+	err := callWithDeadline(ctx, g.session, "Collector.CollectMetrics", func(ctx context.Context) (err error) {
+		mts, err = collectorWithContext(ctx, g.plugin).CollectMetrics(mts)
+		if err != nil {
+			return &PluginError{Code: ErrorCodeCallFailed, Message: fmt.Sprintf("CollectMetrics call error : %s", err.Error())}
+		}
+		return nil
+	})
+	if err != nil {
+		return &rpc.MetricsReply{Error: err.Error()}, nil
+	}
+	ms, err := toGRPCMetrics(mts)
+	if err != nil {
+		return &rpc.MetricsReply{Error: err.Error()}, nil
+	}
+	return &rpc.MetricsReply{Metrics: ms}, nil
+}
+
+type gRPCPublisherProxy struct {
+	gRPCPluginProxy
+	plugin    PublisherPlugin
+	retryable func(error) bool
+	preview   func(string, []byte, map[string]ctypes.ConfigValue) (string, error)
+}
+
+func (g *gRPCPublisherProxy) Publish(ctx context.Context, arg *rpc.PubProcArg) (*rpc.ErrReply, error) {
+	if err := requestCallFrom(ctx).beginCall(ctx, g.session); err != nil {
+		return nil, err
+	}
+	defer g.session.endCall()
+	content, err := gobMetricTypes(fromGRPCMetrics(arg.Metrics))
+	if err != nil {
+		return &rpc.ErrReply{Error: err.Error()}, nil
+	}
+	config := rpc.ParseConfig(arg.Config)
+	openConfig(config, g.session.decrypter())
+	if isDryRun(config) {
+		dryRun(g.session, requestCallFrom(ctx), g.preview, SnapGOBContentType, content, config)
+		return &rpc.ErrReply{}, nil
+	}
+	err = callWithDeadline(ctx, g.session, "Publisher.Publish", func(ctx context.Context) error {
+		attempts, delay, err := retryPolicy(config).do(ctx, g.retryable, func() error {
+			if cp, ok := g.plugin.(ContextPublisher); ok {
+				return cp.PublishContext(ctx, SnapGOBContentType, content, config)
+			}
+			return g.plugin.Publish(SnapGOBContentType, content, config)
+		})
+		requestCallFrom(ctx).recordRetries(attempts, delay)
+		if err != nil {
+			return &PluginError{Code: ErrorCodeCallFailed, Message: fmt.Sprintf("Publish call error: %v", err.Error())}
+		}
+		return nil
+	})
+	if err != nil {
+		return &rpc.ErrReply{Error: err.Error()}, nil
+	}
+	return &rpc.ErrReply{}, nil
+}
+
+type gRPCProcessorProxy struct {
+	gRPCPluginProxy
+	plugin ProcessorPlugin
+}
+
+func (g *gRPCProcessorProxy) Process(ctx context.Context, arg *rpc.PubProcArg) (*rpc.MetricsReply, error) {
+	if err := requestCallFrom(ctx).beginCall(ctx, g.session); err != nil {
+		return nil, err
+	}
+	defer g.session.endCall()
+	content, err := gobMetricTypes(fromGRPCMetrics(arg.Metrics))
+	if err != nil {
+		return &rpc.MetricsReply{Error: err.Error()}, nil
+	}
+	config := rpc.ParseConfig(arg.Config)
+	openConfig(config, g.session.decrypter())
+	var contentType string
+	err = callWithDeadline(ctx, g.session, "Processor.Process", func(ctx context.Context) error {
+		var err error
+		if cp, ok := g.plugin.(ContextProcessor); ok {
+			contentType, content, err = cp.ProcessContext(ctx, SnapGOBContentType, content, config)
+		} else {
+			contentType, content, err = g.plugin.Process(SnapGOBContentType, content, config)
+		}
+		if err != nil {
+			return &PluginError{Code: ErrorCodeCallFailed, Message: fmt.Sprintf("Processor call error: %v", err.Error())}
+		}
+		return nil
+	})
+	if err != nil {
+		return &rpc.MetricsReply{Error: err.Error()}, nil
+	}
+	if contentType == "" {
+		contentType = SnapGOBContentType
+	}
+	mts, err := UnmarshallMetricTypes(contentType, content)
+	if err != nil {
+		return &rpc.MetricsReply{Error: err.Error()}, nil
+	}
+	ms, err := toGRPCMetrics(mts)
+	if err != nil {
+		return &rpc.MetricsReply{Error: err.Error()}, nil
+	}
+	return &rpc.MetricsReply{Metrics: ms}, nil
+}
+
+func gobMetricTypes(mts []MetricType) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(mts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func toGRPCMetrics(mts []MetricType) ([]*rpc.Metric, error) {
+	ms := make([]core.Metric, len(mts))
+	for i := range mts {
+		ms[i] = mts[i]
+	}
+	return rpc.NewMetrics(ms)
+}
+
+func fromGRPCMetrics(ms []*rpc.Metric) []MetricType {
+	mts := make([]MetricType, len(ms))
+	for i, m := range rpc.ToCoreMetrics(ms) {
+		mts[i] = MetricType{
+			Namespace_:          m.Namespace(),
+			Version_:            m.Version(),
+			Config_:             m.Config(),
+			Tags_:               m.Tags(),
+			Timestamp_:          m.Timestamp(),
+			LastAdvertisedTime_: m.LastAdvertisedTime(),
+			Unit_:               m.Unit(),
+			Description_:        m.Description(),
+			Data_:               m.Data(),
+		}
+	}
+	return mts
+}
@@ -0,0 +1,274 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net"
+	netrpc "net/rpc"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	"github.com/intelsdi-x/snap/control/plugin/encrypter"
+	"github.com/intelsdi-x/snap/control/plugin/rpc"
+	"github.com/intelsdi-x/snap/core"
+	"github.com/intelsdi-x/snap/core/ctypes"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// interopCollector returns the same metrics whichever transport serves it.
+type interopCollector struct{}
+
+func (c *interopCollector) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	return cpolicy.New(), nil
+}
+
+func (c *interopCollector) GetMetricTypes(_ ConfigType) ([]MetricType, error) {
+	return []MetricType{
+		{Namespace_: core.NewNamespace("intel", "interop", "int"), Unit_: "count", Description_: "an int", Version_: 1},
+		{Namespace_: core.NewNamespace("intel", "interop", "string"), Tags_: map[string]string{"a": "b"}, Version_: 1},
+	}, nil
+}
+
+func (c *interopCollector) CollectMetrics(mts []MetricType) ([]MetricType, error) {
+	ts := time.Unix(1451606400, 42)
+	for i := range mts {
+		mts[i].Timestamp_ = ts
+		switch mts[i].Namespace().Strings()[2] {
+		case "int":
+			mts[i].Data_ = int64(7)
+		case "string":
+			mts[i].Data_ = "seven"
+		}
+	}
+	return mts, nil
+}
+
+// oddConfigValue is a config value which no transport knows
+type oddConfigValue struct{}
+
+func (oddConfigValue) Type() string {
+	return "odd"
+}
+
+type interopResult struct {
+	catalog   []string
+	collected []string
+}
+
+func summarize(ms []MetricType) []string {
+	out := make([]string, len(ms))
+	for i, m := range ms {
+		out[i] = fmt.Sprintf("%s v%d %q %q %v %v %d", m.Namespace(), m.Version(), m.Unit(), m.Description(), m.Tags(), m.Data(), m.Timestamp().UnixNano())
+	}
+	return out
+}
+
+func grpcContext(token string) context.Context {
+	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
+	return metadata.NewContext(ctx, metadata.Pairs(GRPCTokenKey, token))
+}
+
+func interopNative(resp Response) interopResult {
+	client, err := netrpc.Dial("tcp", resp.ListenAddress)
+	So(err, ShouldBeNil)
+	defer client.Close()
+	enc := encoding.NewGobEncoder()
+	call := func(method string, in, out interface{}) {
+		b, err := enc.Encode(in)
+		So(err, ShouldBeNil)
+		var reply []byte
+		So(client.Call(method, b, &reply), ShouldBeNil)
+		if out != nil {
+			So(enc.Decode(reply, out), ShouldBeNil)
+		}
+	}
+	call("SessionState.Ping", PingArgs{Token: resp.Token}, nil)
+	var mtr GetMetricTypesReply
+	call("Collector.GetMetricTypes", GetMetricTypesArgs{PluginConfig: NewPluginConfigType(), Token: resp.Token}, &mtr)
+	var cmr CollectMetricsReply
+	call("Collector.CollectMetrics", CollectMetricsArgs{MetricTypes: mtr.MetricTypes, Token: resp.Token}, &cmr)
+	call("SessionState.Kill", KillArgs{Reason: "test", Token: resp.Token}, nil)
+	return interopResult{catalog: summarize(mtr.MetricTypes), collected: summarize(cmr.PluginMetrics)}
+}
+
+func interopGRPC(resp Response) interopResult {
+	conn, err := grpc.Dial(resp.ListenAddress, grpc.WithInsecure(), grpc.WithTimeout(5*time.Second))
+	So(err, ShouldBeNil)
+	defer conn.Close()
+	client := rpc.NewCollectorClient(conn)
+
+	_, err = client.Ping(grpcContext(resp.Token), &rpc.Empty{})
+	So(err, ShouldBeNil)
+	mtr, err := client.GetMetricTypes(grpcContext(resp.Token), &rpc.GetMetricTypesArg{})
+	So(err, ShouldBeNil)
+	So(mtr.Error, ShouldBeEmpty)
+	cmr, err := client.CollectMetrics(grpcContext(resp.Token), &rpc.MetricsArg{Metrics: mtr.Metrics})
+	So(err, ShouldBeNil)
+	So(cmr.Error, ShouldBeEmpty)
+	_, err = client.Kill(grpcContext(resp.Token), &rpc.KillArg{Reason: "test"})
+	So(err, ShouldBeNil)
+	return interopResult{catalog: summarize(fromGRPCMetrics(mtr.Metrics)), collected: summarize(fromGRPCMetrics(cmr.Metrics))}
+}
+
+func TestGRPCTransport(t *testing.T) {
+	Convey("The same collector served over net/rpc and gRPC", t, func() {
+		results := map[string]interopResult{}
+		for _, transport := range []string{"native", "grpc"} {
+			m := NewPluginMeta("interop", 1, CollectorPluginType, []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+			args := fmt.Sprintf(`{"NoDaemon": true, "Transport": %q, "PingTimeoutDuration": %d}`, transport, time.Minute)
+			resp, done := startTestPlugin(m, &interopCollector{}, args)
+			So(<-done, ShouldEqual, 0)
+			So(resp.Meta.RPCType.String(), ShouldEqual, transport)
+			if transport == "grpc" {
+				results[transport] = interopGRPC(resp)
+			} else {
+				results[transport] = interopNative(resp)
+			}
+		}
+		So(len(results["native"].catalog), ShouldEqual, 2)
+		So(len(results["native"].collected), ShouldEqual, 2)
+		So(results["grpc"], ShouldResemble, results["native"])
+	})
+	Convey("A gRPC session", t, func() {
+		m := NewPluginMeta("interop", 1, CollectorPluginType, []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+		m.RPCType = GRPC
+		s, err, _ := NewSessionState(fmt.Sprintf(`{"PingTimeoutDuration": %d}`, time.Minute), &interopCollector{}, m)
+		So(err, ShouldBeNil)
+		gs, err := newGRPCServer(CollectorPluginType, &interopCollector{}, s, nil)
+		So(err, ShouldBeNil)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		go gs.Serve(l)
+		defer gs.Stop()
+		conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure(), grpc.WithTimeout(5*time.Second))
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		client := rpc.NewCollectorClient(conn)
+
+		Convey("resets the heartbeat on Ping", func() {
+			last := time.Now().Add(-time.Hour)
+			s.SetLastPing(last)
+			_, err := client.Ping(grpcContext(s.Token()), &rpc.Empty{})
+			So(err, ShouldBeNil)
+			So(s.GetLastPing().After(last), ShouldBeTrue)
+		})
+		Convey("rejects calls without the session token", func() {
+			last := time.Now().Add(-time.Hour)
+			s.SetLastPing(last)
+			_, err := client.Ping(grpcContext("bad"), &rpc.Empty{})
+			So(grpc.ErrorDesc(err), ShouldEqual, ErrBadToken.Error())
+			_, err = client.GetMetricTypes(context.Background(), &rpc.GetMetricTypesArg{})
+			So(grpc.ErrorDesc(err), ShouldEqual, ErrBadToken.Error())
+			So(s.GetLastPing(), ShouldResemble, last)
+		})
+		Convey("serves the config policy", func() {
+			reply, err := client.GetConfigPolicy(grpcContext(s.Token()), &rpc.Empty{})
+			So(err, ShouldBeNil)
+			So(reply.Error, ShouldBeEmpty)
+		})
+	})
+	Convey("A gRPC publisher", t, func() {
+		m := NewPluginMeta("secret", 1, PublisherPluginType, []string{SnapGOBContentType}, nil, Unsecure(true))
+		m.RPCType = GRPC
+		p := &secretPublisher{}
+		s, err, _ := NewSessionState(fmt.Sprintf(`{"PingTimeoutDuration": %d}`, time.Minute), p, m)
+		So(err, ShouldBeNil)
+		gs, err := newGRPCServer(PublisherPluginType, p, s, nil)
+		So(err, ShouldBeNil)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		go gs.Serve(l)
+		defer gs.Stop()
+		conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure(), grpc.WithTimeout(5*time.Second))
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		client := rpc.NewPublisherClient(conn)
+
+		key, err := encrypter.GenerateKey()
+		So(err, ShouldBeNil)
+		sessionKey := encrypter.New(nil, nil)
+		sessionKey.Key = key
+
+		Convey("receives every type of config value", func() {
+			secret, err := ctypes.NewConfigValueSecureString(testSecret).Seal(sessionKey)
+			So(err, ShouldBeNil)
+			cm, err := rpc.ToConfigMap(map[string]ctypes.ConfigValue{
+				"password": secret,
+				"user":     ctypes.ConfigValueStr{Value: "admin"},
+				"port":     ctypes.ConfigValueInt{Value: 9000},
+				"ratio":    ctypes.ConfigValueFloat{Value: 0.5},
+				"tls":      ctypes.ConfigValueBool{Value: true},
+			})
+			So(err, ShouldBeNil)
+			reply, err := client.Publish(grpcContext(s.Token()), &rpc.PubProcArg{Config: cm})
+			So(err, ShouldBeNil)
+			So(reply.Error, ShouldBeEmpty)
+
+			So(p.config, ShouldHaveLength, 5)
+			So(p.config["user"], ShouldResemble, ctypes.ConfigValueStr{Value: "admin"})
+			So(p.config["port"], ShouldResemble, ctypes.ConfigValueInt{Value: 9000})
+			So(p.config["ratio"], ShouldResemble, ctypes.ConfigValueFloat{Value: 0.5})
+			So(p.config["tls"], ShouldResemble, ctypes.ConfigValueBool{Value: true})
+			received, ok := p.config["password"].(ctypes.ConfigValueSecureString)
+			So(ok, ShouldBeTrue)
+			So(received.Ciphertext, ShouldResemble, secret.Ciphertext)
+			plaintext, err := received.Open(sessionKey).Reveal()
+			So(err, ShouldBeNil)
+			So(plaintext, ShouldEqual, testSecret)
+		})
+		Convey("is not sent a secure string which was not sealed", func() {
+			_, err := rpc.ToConfigMap(map[string]ctypes.ConfigValue{"password": ctypes.NewConfigValueSecureString(testSecret)})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "not sealed")
+		})
+		Convey("is not sent a config value of an unknown type", func() {
+			_, err := rpc.ToConfigMap(map[string]ctypes.ConfigValue{"odd": oddConfigValue{}})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "unsupported type")
+		})
+	})
+	Convey("gRPC refuses a ControlPubKey", t, func() {
+		m := NewPluginMeta("interop", 1, CollectorPluginType, []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+		controlKey, err := rsa.GenerateKey(rand.Reader, 1024)
+		So(err, ShouldBeNil)
+		args, err := json.Marshal(Arg{
+			NoDaemon:      true,
+			Transport:     "grpc",
+			ControlPubKey: &controlKey.PublicKey,
+		})
+		So(err, ShouldBeNil)
+		err, rc := Start(m, &interopCollector{}, string(args))
+		So(err, ShouldEqual, ErrGRPCControlPubKey)
+		So(rc, ShouldEqual, 2)
+	})
+}
@@ -0,0 +1,295 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultHandshakeWindow is how far a handshake timestamp may be from the
+	// plugin's clock when Arg.HandshakeWindow is not set.
+	DefaultHandshakeWindow = 30 * time.Second
+
+	ErrHandshakeToken     = errors.New("handshake token does not match the session")
+	ErrHandshakeStale     = errors.New("handshake timestamp is outside the allowed window")
+	ErrHandshakeReplay    = errors.New("handshake has already been used")
+	ErrHandshakeSignature = errors.New("handshake signature is not valid")
+)
+
+const (
+	// maxHandshakeSize bounds the handshake message read from a new connection
+	maxHandshakeSize = 64 * 1024
+	// handshakeTimeout bounds the time a new connection may take to
+	// complete the handshake
+	handshakeTimeout = 5 * time.Second
+)
+
+// HandshakeRequest is the first message control sends on a new connection
+// to a plugin started with a ControlPubKey.
+type HandshakeRequest struct {
+	Token     string
+	Timestamp time.Time
+	// Key, when set, is the session key encrypted with the plugin's public
+	// key, as in SetKeyArgs.  It is covered by the signature, so the
+	// plugin only takes a session key which comes from control.
+	Key       []byte
+	Signature []byte
+}
+
+// HandshakeReply is the plugin's answer to a HandshakeRequest.  An empty
+// Error means the connection was accepted.
+type HandshakeReply struct {
+	Error string
+}
+
+func handshakeDigest(token string, ts time.Time, sessionKey []byte) []byte {
+	msg := fmt.Sprintf("%s\n%d", token, ts.UnixNano())
+	if len(sessionKey) > 0 {
+		msg += fmt.Sprintf("\n%x", sessionKey)
+	}
+	h := sha256.Sum256([]byte(msg))
+	return h[:]
+}
+
+// SignHandshake returns a HandshakeRequest for the session token signed with
+// control's private key.
+func SignHandshake(token string, ts time.Time, key *rsa.PrivateKey) (HandshakeRequest, error) {
+	return SignKeyHandshake(token, ts, nil, key)
+}
+
+// SignKeyHandshake is SignHandshake for a request which also hands the
+// plugin its session key, encrypted with the plugin's public key.
+func SignKeyHandshake(token string, ts time.Time, sessionKey []byte, key *rsa.PrivateKey) (HandshakeRequest, error) {
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, handshakeDigest(token, ts, sessionKey))
+	if err != nil {
+		return HandshakeRequest{}, err
+	}
+	return HandshakeRequest{Token: token, Timestamp: ts, Key: sessionKey, Signature: sig}, nil
+}
+
+// ClientHandshake authenticates a new connection to a plugin.  It must be
+// called by control before any RPC is made on conn.
+func ClientHandshake(conn net.Conn, token string, key *rsa.PrivateKey) error {
+	return ClientKeyHandshake(conn, token, nil, key)
+}
+
+// ClientKeyHandshake is ClientHandshake which also sets the session key of
+// the plugin, used to seal secure config values.  sessionKey is encrypted
+// with the plugin's public key, as by encrypter.Encrypter.EncryptKey.
+func ClientKeyHandshake(conn net.Conn, token string, sessionKey []byte, key *rsa.PrivateKey) error {
+	req, err := SignKeyHandshake(token, time.Now(), sessionKey, key)
+	if err != nil {
+		return err
+	}
+	if err := writeHandshakeMessage(conn, req); err != nil {
+		return err
+	}
+	var reply HandshakeReply
+	if err := readHandshakeMessage(conn, &reply); err != nil {
+		return err
+	}
+	if reply.Error != "" {
+		return errors.New(reply.Error)
+	}
+	return nil
+}
+
+// Handshake messages are length prefixed JSON so that nothing past the
+// message is consumed from the connection before it is handed to RPC.
+func writeHandshakeMessage(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(len(b))); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func readHandshakeMessage(r io.Reader, v interface{}) error {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return err
+	}
+	if n > maxHandshakeSize {
+		return fmt.Errorf("handshake message of %d bytes is too large", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// handshakeVerifier checks HandshakeRequests against the control public key
+// and remembers accepted signatures until they fall out of the window.
+type handshakeVerifier struct {
+	key    *rsa.PublicKey
+	token  string
+	window time.Duration
+	now    func() time.Time
+
+	mutex sync.Mutex
+	seen  map[string]time.Time
+}
+
+func newHandshakeVerifier(key *rsa.PublicKey, token string, window time.Duration) *handshakeVerifier {
+	if window <= 0 {
+		window = DefaultHandshakeWindow
+	}
+	return &handshakeVerifier{
+		key:    key,
+		token:  token,
+		window: window,
+		now:    time.Now,
+		seen:   map[string]time.Time{},
+	}
+}
+
+func (v *handshakeVerifier) verify(req HandshakeRequest) error {
+	if subtle.ConstantTimeCompare([]byte(req.Token), []byte(v.token)) != 1 {
+		return ErrHandshakeToken
+	}
+	now := v.now()
+	if d := now.Sub(req.Timestamp); d > v.window || d < -v.window {
+		return ErrHandshakeStale
+	}
+	if err := rsa.VerifyPKCS1v15(v.key, crypto.SHA256, handshakeDigest(req.Token, req.Timestamp, req.Key), req.Signature); err != nil {
+		return ErrHandshakeSignature
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	for sig, expires := range v.seen {
+		if now.After(expires) {
+			delete(v.seen, sig)
+		}
+	}
+	sig := hex.EncodeToString(req.Signature)
+	if _, ok := v.seen[sig]; ok {
+		return ErrHandshakeReplay
+	}
+	v.seen[sig] = req.Timestamp.Add(v.window)
+	return nil
+}
+
+// handshakeListener only returns connections which completed the handshake.
+// Any other connection is closed.  Each connection makes its handshake in
+// its own goroutine, so that a client which connects and stays silent does
+// not hold up the others.
+type handshakeListener struct {
+	net.Listener
+	verifier *handshakeVerifier
+	logger   Logger
+	// setKey takes the session key of a verified HandshakeRequest
+	setKey func([]byte) error
+
+	once sync.Once
+	// accepted passes the connections which completed the handshake to
+	// Accept, until done is closed with err, the error of the listener
+	accepted chan net.Conn
+	done     chan struct{}
+	err      error
+}
+
+func (l *handshakeListener) Accept() (net.Conn, error) {
+	l.once.Do(func() {
+		l.accepted = make(chan net.Conn)
+		l.done = make(chan struct{})
+		go l.acceptLoop()
+	})
+	select {
+	case conn := <-l.accepted:
+		return conn, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+// acceptLoop accepts the connections of the listener until it fails,
+// starting the handshake of each
+func (l *handshakeListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				// e.g. out of file descriptors, which may not last
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			l.err = err
+			close(l.done)
+			return
+		}
+		go l.admit(conn)
+	}
+}
+
+// admit hands conn to Accept once it completed the handshake
+func (l *handshakeListener) admit(conn net.Conn) {
+	if err := l.handshake(conn); err != nil {
+		l.logger.Errorf("Refused connection from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	select {
+	case l.accepted <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *handshakeListener) handshake(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	var req HandshakeRequest
+	if err := readHandshakeMessage(conn, &req); err != nil {
+		return err
+	}
+	verr := l.verifier.verify(req)
+	if verr == nil && len(req.Key) > 0 {
+		verr = l.setKey(req.Key)
+	}
+	reply := HandshakeReply{}
+	if verr != nil {
+		reply.Error = verr.Error()
+	}
+	if err := writeHandshakeMessage(conn, reply); err != nil {
+		return err
+	}
+	return verr
+}
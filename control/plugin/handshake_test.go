@@ -0,0 +1,240 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	"github.com/intelsdi-x/snap/control/plugin/encrypter"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHandshakeVerifier(t *testing.T) {
+	Convey("handshakeVerifier", t, func() {
+		controlKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		So(err, ShouldBeNil)
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		So(err, ShouldBeNil)
+		now := time.Now()
+		v := newHandshakeVerifier(&controlKey.PublicKey, "token", 10*time.Second)
+		v.now = func() time.Time { return now }
+
+		Convey("accepts a fresh signed challenge", func() {
+			req, err := SignHandshake("token", now.Add(-time.Second), controlKey)
+			So(err, ShouldBeNil)
+			So(v.verify(req), ShouldBeNil)
+
+			Convey("but not twice", func() {
+				So(v.verify(req), ShouldEqual, ErrHandshakeReplay)
+			})
+		})
+		Convey("rejects a stale challenge", func() {
+			req, err := SignHandshake("token", now.Add(-11*time.Second), controlKey)
+			So(err, ShouldBeNil)
+			So(v.verify(req), ShouldEqual, ErrHandshakeStale)
+		})
+		Convey("rejects a challenge from the future", func() {
+			req, err := SignHandshake("token", now.Add(11*time.Second), controlKey)
+			So(err, ShouldBeNil)
+			So(v.verify(req), ShouldEqual, ErrHandshakeStale)
+		})
+		Convey("rejects a challenge signed by another key", func() {
+			req, err := SignHandshake("token", now, otherKey)
+			So(err, ShouldBeNil)
+			So(v.verify(req), ShouldEqual, ErrHandshakeSignature)
+		})
+		Convey("rejects a challenge with a modified timestamp", func() {
+			req, err := SignHandshake("token", now.Add(-5*time.Second), controlKey)
+			So(err, ShouldBeNil)
+			req.Timestamp = now
+			So(v.verify(req), ShouldEqual, ErrHandshakeSignature)
+		})
+		Convey("rejects a challenge for another session", func() {
+			req, err := SignHandshake("other", now, controlKey)
+			So(err, ShouldBeNil)
+			So(v.verify(req), ShouldEqual, ErrHandshakeToken)
+		})
+		Convey("forgets signatures once they expire", func() {
+			req, err := SignHandshake("token", now, controlKey)
+			So(err, ShouldBeNil)
+			So(v.verify(req), ShouldBeNil)
+			now = now.Add(11 * time.Second)
+			So(v.verify(req), ShouldEqual, ErrHandshakeStale)
+			next, err := SignHandshake("token", now, controlKey)
+			So(err, ShouldBeNil)
+			So(v.verify(next), ShouldBeNil)
+			So(len(v.seen), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestHandshakeListener(t *testing.T) {
+	Convey("A plugin started with a ControlPubKey", t, func() {
+		controlKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		So(err, ShouldBeNil)
+		args, err := json.Marshal(Arg{
+			ControlPubKey:       &controlKey.PublicKey,
+			PingTimeoutDuration: time.Minute,
+		})
+		So(err, ShouldBeNil)
+		resp, done := startTestCollector(string(args))
+
+		Convey("serves connections which complete the handshake", func() {
+			conn, err := net.Dial("tcp", resp.ListenAddress)
+			So(err, ShouldBeNil)
+			So(ClientHandshake(conn, resp.Token, controlKey), ShouldBeNil)
+			client := rpc.NewClient(conn)
+			defer client.Close()
+			So(callPing(client, resp.Token), ShouldBeNil)
+		})
+		Convey("refuses connections signed with another key", func() {
+			otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			So(err, ShouldBeNil)
+			conn, err := net.Dial("tcp", resp.ListenAddress)
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			err = ClientHandshake(conn, resp.Token, otherKey)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, ErrHandshakeSignature.Error())
+		})
+		Convey("serves connections while another one stays silent", func() {
+			silent, err := net.Dial("tcp", resp.ListenAddress)
+			So(err, ShouldBeNil)
+			defer silent.Close()
+			// let the listener accept the silent connection first
+			time.Sleep(50 * time.Millisecond)
+
+			conn, err := net.Dial("tcp", resp.ListenAddress)
+			So(err, ShouldBeNil)
+			start := time.Now()
+			So(ClientHandshake(conn, resp.Token, controlKey), ShouldBeNil)
+			client := rpc.NewClient(conn)
+			defer client.Close()
+			So(callPing(client, resp.Token), ShouldBeNil)
+			So(time.Since(start), ShouldBeLessThan, handshakeTimeout/2)
+		})
+		Convey("refuses connections which skip the handshake", func() {
+			conn, err := net.Dial("tcp", resp.ListenAddress)
+			So(err, ShouldBeNil)
+			client := rpc.NewClient(conn)
+			defer client.Close()
+			So(callPing(client, resp.Token), ShouldNotBeNil)
+		})
+
+		conn, err := net.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		So(ClientHandshake(conn, resp.Token, controlKey), ShouldBeNil)
+		client := rpc.NewClient(conn)
+		// Kill must also be signed when a ControlPubKey is set
+		So(callKill(client, resp.Token), ShouldEqual, rpc.ServerError(ErrRequestUnsigned.Error()))
+		kill := &KillArgs{Reason: "test", Token: resp.Token}
+		So(SignRequest(kill, controlKey), ShouldBeNil)
+		in, err := encoding.NewGobEncoder().Encode(kill)
+		So(err, ShouldBeNil)
+		So(client.Call("SessionState.Kill", in, &[]byte{}), ShouldBeNil)
+		So(<-done, ShouldEqual, 0)
+		client.Close()
+	})
+}
+
+func TestHandshakeSessionKey(t *testing.T) {
+	Convey("A session started with a ControlPubKey", t, func() {
+		controlKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		So(err, ShouldBeNil)
+		m := NewPluginMeta("key", 1, PublisherPluginType, []string{SnapGOBContentType}, nil)
+		arg, err := json.Marshal(Arg{ControlPubKey: &controlKey.PublicKey})
+		So(err, ShouldBeNil)
+		ss, err, _ := NewSessionState(string(arg), &secretPublisher{}, m)
+		So(err, ShouldBeNil)
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer l.Close()
+		hl := &handshakeListener{
+			Listener: l,
+			verifier: newHandshakeVerifier(ss.ControlPubKey, ss.Token(), 0),
+			logger:   ss.Logger(),
+			setKey:   ss.handshakeKey,
+		}
+		// Accept hands over the connections once their handshake is done
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, err := hl.Accept()
+			if err == nil {
+				accepted <- conn
+			}
+		}()
+
+		key, err := encrypter.GenerateKey()
+		So(err, ShouldBeNil)
+		control := encrypter.New(&ss.privateKey.PublicKey, nil)
+		control.Key = key
+		sessionKey, err := control.EncryptKey()
+		So(err, ShouldBeNil)
+		secret, err := ctypes.NewConfigValueSecureString(testSecret).Seal(control)
+		So(err, ShouldBeNil)
+
+		Convey("takes its session key from the handshake", func() {
+			conn, err := net.Dial("tcp", l.Addr().String())
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			So(ClientKeyHandshake(conn, ss.Token(), sessionKey, controlKey), ShouldBeNil)
+			(<-accepted).Close()
+			So(ss.decrypter(), ShouldNotBeNil)
+			s, err := secret.Open(ss.decrypter()).Reveal()
+			So(err, ShouldBeNil)
+			So(s, ShouldEqual, testSecret)
+		})
+		Convey("refuses a session key the signature does not cover", func() {
+			other := encrypter.New(&ss.privateKey.PublicKey, nil)
+			other.Key, err = encrypter.GenerateKey()
+			So(err, ShouldBeNil)
+			otherKey, err := other.EncryptKey()
+			So(err, ShouldBeNil)
+			req, err := SignKeyHandshake(ss.Token(), time.Now(), sessionKey, controlKey)
+			So(err, ShouldBeNil)
+			req.Key = otherKey
+
+			conn, err := net.Dial("tcp", l.Addr().String())
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			So(writeHandshakeMessage(conn, req), ShouldBeNil)
+			var reply HandshakeReply
+			So(readHandshakeMessage(conn, &reply), ShouldBeNil)
+			So(reply.Error, ShouldEqual, ErrHandshakeSignature.Error())
+			So(ss.decrypter(), ShouldBeNil)
+		})
+		Convey("refuses SetKey", func() {
+			err := ss.SetKey(SetKeyArgs{Key: sessionKey, Token: ss.Token()}, &[]byte{})
+			So(err, ShouldEqual, ErrSessionKeyHandshake)
+			So(ss.decrypter(), ShouldBeNil)
+		})
+	})
+}
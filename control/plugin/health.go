@@ -0,0 +1,124 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"time"
+)
+
+// HealthState is the health a session reports to control in a PingReply
+type HealthState int
+
+const (
+	// HealthOK means the plugin is serving normally
+	HealthOK HealthState = iota
+	// HealthDegraded means the plugin is serving but calls are failing
+	HealthDegraded
+	// HealthNotReady means the plugin is not able to serve calls yet
+	HealthNotReady
+	// HealthSuspended means the session was suspended by control
+	HealthSuspended
+)
+
+var healthStates = [...]string{
+	"ok",
+	"degraded",
+	"not-ready",
+	"suspended",
+}
+
+func (h HealthState) String() string {
+	if h < 0 || int(h) >= len(healthStates) {
+		return "unknown"
+	}
+	return healthStates[h]
+}
+
+// HealthReporter may be implemented by a plugin to report its own health
+// in the PingReply.  A plugin which does not implement it is always
+// reported as HealthOK.
+type HealthReporter interface {
+	// Health returns the plugin's state and the last error it encountered,
+	// if any.
+	Health() (HealthState, error)
+}
+
+// PingReply is the reply to PingStatus
+type PingReply struct {
+	State     HealthState
+	LastError string
+	// Uptime is the time since the session started
+	Uptime time.Duration
+	// Calls is the number of calls served since the session started
+	Calls uint64
+	// Errors is the number of calls which returned an error
+	Errors uint64
+	// Methods holds the counters of each RPC method served
+	Methods map[string]MethodStats
+	// Init is the state of the plugin's Init, see Initializer
+	Init InitState
+}
+
+// PingStatus resets the heartbeat like Ping and replies with the session's
+// health.
+func (s *SessionState) PingStatus(arg []byte, reply *[]byte) error {
+	a := &PingArgs{}
+	s.Decode(arg, a)
+	if err := s.CheckToken(a.Token); err != nil {
+		return err
+	}
+	s.ResetHeartbeat()
+	s.countPing()
+	s.Logger().Debugf("PingStatus received")
+	out, err := s.Encode(s.pingReply())
+	if err != nil {
+		return err
+	}
+	*reply = out
+	return nil
+}
+
+func (s *SessionState) pingReply() PingReply {
+	r := PingReply{State: HealthOK}
+	if !s.started.IsZero() {
+		r.Uptime = time.Since(s.started)
+	}
+	s.pingMutex.Lock()
+	r.Calls = s.calls
+	s.pingMutex.Unlock()
+	r.Methods, r.Errors, r.LastError = s.stats.snapshot()
+	if hr, ok := s.plugin.(HealthReporter); ok {
+		state, err := hr.Health()
+		r.State = state
+		if err != nil {
+			r.LastError = err.Error()
+		}
+	}
+	if suspended, _ := s.suspension.state(); suspended {
+		r.State = HealthSuspended
+	}
+	if state, err := s.init.status(); state != InitComplete {
+		r.State, r.Init = HealthNotReady, state
+		if err != nil {
+			r.LastError = err.Error()
+		}
+	}
+	return r
+}
@@ -0,0 +1,115 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// failingCollector reports itself degraded once CollectMetrics has failed
+type failingCollector struct {
+	mockPlugin
+
+	mutex   sync.Mutex
+	lastErr error
+}
+
+func (f *failingCollector) CollectMetrics([]MetricType) ([]MetricType, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.lastErr = errors.New("device unreachable")
+	return nil, f.lastErr
+}
+
+func (f *failingCollector) Health() (HealthState, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.lastErr != nil {
+		return HealthDegraded, f.lastErr
+	}
+	return HealthOK, nil
+}
+
+func TestPingStatus(t *testing.T) {
+	Convey("PingStatus", t, func() {
+		collector := &failingCollector{}
+		ss := &SessionState{
+			Arg:     &Arg{PingTimeoutDuration: time.Minute},
+			Encoder: encoding.NewGobEncoder(),
+			plugin:  collector,
+			token:   "s3cr3t",
+			logger:  log.New(),
+			started: time.Now().Add(-time.Hour),
+		}
+		ping := func(token string) (PingReply, error) {
+			in, err := ss.Encode(PingArgs{Token: token})
+			So(err, ShouldBeNil)
+			var out []byte
+			if err := ss.PingStatus(in, &out); err != nil {
+				return PingReply{}, err
+			}
+			var r PingReply
+			So(ss.Decode(out, &r), ShouldBeNil)
+			return r, nil
+		}
+
+		Convey("rejects a wrong token", func() {
+			_, err := ping("wrong")
+			So(err, ShouldEqual, ErrBadToken)
+		})
+		Convey("reports a healthy plugin", func() {
+			r, err := ping("s3cr3t")
+			So(err, ShouldBeNil)
+			So(r.State, ShouldEqual, HealthOK)
+			So(r.LastError, ShouldBeEmpty)
+			So(r.Uptime, ShouldBeGreaterThanOrEqualTo, time.Hour)
+			So(r.Calls, ShouldEqual, 1)
+		})
+		Convey("reports a plugin as degraded after its collector fails", func() {
+			c := &collectorPluginProxy{Plugin: collector, Session: ss}
+			in, err := ss.Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: "s3cr3t"})
+			So(err, ShouldBeNil)
+			So(c.CollectMetrics(in, &[]byte{}), ShouldNotBeNil)
+
+			r, err := ping("s3cr3t")
+			So(err, ShouldBeNil)
+			So(r.State, ShouldEqual, HealthDegraded)
+			So(r.State.String(), ShouldEqual, "degraded")
+			So(r.LastError, ShouldEqual, "device unreachable")
+			So(r.Calls, ShouldEqual, 2)
+		})
+		Convey("reports a plugin without a HealthReporter as ok", func() {
+			ss.plugin = &mockPlugin{}
+			r, err := ping("s3cr3t")
+			So(err, ShouldBeNil)
+			So(r.State, ShouldEqual, HealthOK)
+		})
+	})
+}
@@ -0,0 +1,150 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultInitRetries is how many times a failed Init is retried
+	DefaultInitRetries = 3
+	// DefaultInitRetryInterval is the delay before a failed Init is retried
+	DefaultInitRetryInterval = time.Second
+)
+
+// ErrNotInitialized is returned by the collect, publish and process calls
+// of a session whose plugin has not completed its Init.
+var ErrNotInitialized error = &PluginError{Code: ErrorCodeNotReady, Message: "plugin is not initialized"}
+
+// Initializer may be implemented by a plugin which must do some work, e.g.
+// authenticate to an API or enumerate devices, before it can serve calls.
+// Init is called once the session is serving, with Arg.InitConfig, and
+// retried up to Arg.InitRetries times when it fails.  Until it succeeds the
+// session reports HealthNotReady and fails collect, publish and process
+// calls with ErrNotInitialized.
+type Initializer interface {
+	Init(config map[string]interface{}) error
+}
+
+// InitState tells whether the plugin's Init has completed
+type InitState int
+
+const (
+	// InitComplete means Init succeeded, or the plugin does not implement
+	// Initializer
+	InitComplete InitState = iota
+	// InitPending means Init has not succeeded yet and will be retried
+	InitPending
+	// InitFailed means Init failed InitRetries+1 times.  The session ends
+	// after KillDelay.
+	InitFailed
+)
+
+var initStates = [...]string{
+	"complete",
+	"pending",
+	"failed",
+}
+
+func (i InitState) String() string {
+	if i < 0 || int(i) >= len(initStates) {
+		return "unknown"
+	}
+	return initStates[i]
+}
+
+// initialization tracks the Init of a plugin implementing Initializer.  A
+// nil initialization is complete.
+type initialization struct {
+	mutex sync.Mutex
+	state InitState
+	err   error
+}
+
+func newInitialization() *initialization {
+	return &initialization{state: InitPending}
+}
+
+// check returns ErrNotInitialized until Init has succeeded.
+func (i *initialization) check() error {
+	if state, _ := i.status(); state != InitComplete {
+		return ErrNotInitialized
+	}
+	return nil
+}
+
+// status returns the state of Init and the error of its last attempt.
+func (i *initialization) status() (InitState, error) {
+	if i == nil {
+		return InitComplete, nil
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.state, i.err
+}
+
+func (i *initialization) set(state InitState, err error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.state, i.err = state, err
+}
+
+// runInit calls the plugin's Init until it succeeds or has been retried
+// InitRetries times, in which case the session ends after KillDelay.
+func (s *SessionState) runInit() {
+	p, ok := s.plugin.(Initializer)
+	if !ok || s.init == nil {
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		err := s.callInit(p)
+		if err == nil {
+			s.init.set(InitComplete, nil)
+			s.Logger().Infof("Init completed after %d attempt(s)", attempt)
+			return
+		}
+		if attempt > s.InitRetries {
+			s.init.set(InitFailed, err)
+			s.Logger().Errorf("Init failed after %d attempt(s), ending the session: %s", attempt, err)
+			time.Sleep(s.KillDelay)
+			s.shutdown(KillReasonInitFailed, Shutdown{
+				Reason: fmt.Sprintf("init failed: %s", err),
+				Source: ShutdownSourceInit,
+			})
+			return
+		}
+		s.init.set(InitPending, err)
+		s.Logger().Warnf("Init attempt %d failed, retrying in %v: %s", attempt, s.InitRetryInterval, err)
+		select {
+		case <-s.Done():
+			return
+		case <-time.After(s.InitRetryInterval):
+		}
+	}
+}
+
+// callInit calls Init, turning a panic into an error.
+func (s *SessionState) callInit(p Initializer) (err error) {
+	defer s.recoverPanic("Init", &err)
+	return p.Init(s.InitConfig)
+}
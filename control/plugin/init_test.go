@@ -0,0 +1,138 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// flakyInitCollector fails its first failures calls to Init
+type flakyInitCollector struct {
+	mockPlugin
+
+	mutex    sync.Mutex
+	failures int
+	calls    int
+	config   map[string]interface{}
+}
+
+func (f *flakyInitCollector) Init(config map[string]interface{}) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls++
+	f.config = config
+	if f.calls <= f.failures {
+		return errors.New("device unreachable")
+	}
+	return nil
+}
+
+func (f *flakyInitCollector) initCalls() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.calls
+}
+
+func TestInit(t *testing.T) {
+	Convey("A collector whose Init fails twice", t, func() {
+		p := &flakyInitCollector{failures: 2}
+		m := NewPluginMeta("init", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		args := fmt.Sprintf(`{"KillDelay": 1, "PingTimeoutDuration": %d, "InitRetryInterval": %d, "InitConfig": {"endpoint": "localhost"}}`,
+			time.Minute, 200*time.Millisecond)
+		resp, done := startTestPlugin(m, p, args)
+		So(resp.Init, ShouldEqual, InitPending)
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer func() {
+			So(callKill(client, resp.Token), ShouldBeNil)
+			client.Close()
+			<-done
+		}()
+		enc := encoding.NewGobEncoder()
+		collect := func() error {
+			in, err := enc.Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: resp.Token})
+			So(err, ShouldBeNil)
+			return client.Call("Collector.CollectMetrics", in, &[]byte{})
+		}
+		ping := func() PingReply {
+			in, err := enc.Encode(PingArgs{Token: resp.Token})
+			So(err, ShouldBeNil)
+			var out []byte
+			So(client.Call("SessionState.PingStatus", in, &out), ShouldBeNil)
+			var r PingReply
+			So(enc.Decode(out, &r), ShouldBeNil)
+			return r
+		}
+
+		Convey("is not ready until Init succeeds", func() {
+			So(ErrorCodeOf(collect()), ShouldEqual, ErrorCodeNotReady)
+			r := ping()
+			So(r.State, ShouldEqual, HealthNotReady)
+			So(r.Init, ShouldEqual, InitPending)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for ping().Init != InitComplete && time.Now().Before(deadline) {
+				time.Sleep(20 * time.Millisecond)
+			}
+			r = ping()
+			So(r.Init, ShouldEqual, InitComplete)
+			So(r.State, ShouldEqual, HealthOK)
+			So(p.initCalls(), ShouldEqual, 3)
+			So(p.config, ShouldResemble, map[string]interface{}{"endpoint": "localhost"})
+			So(collect(), ShouldBeNil)
+		})
+	})
+	Convey("A collector whose Init fails more than InitRetries times", t, func() {
+		p := &flakyInitCollector{failures: 2}
+		m := NewPluginMeta("init", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		resp, done := startTestPlugin(m, p, `{"KillDelay": 1, "InitRetries": 1, "InitRetryInterval": 1}`)
+		So(resp.Init, ShouldEqual, InitPending)
+		select {
+		case rc := <-done:
+			So(rc, ShouldEqual, 2)
+		case <-time.After(5 * time.Second):
+			So("the session did not end", ShouldBeEmpty)
+		}
+		So(p.initCalls(), ShouldEqual, 2)
+	})
+	Convey("A collector without Init is ready at once", t, func() {
+		resp, done := startTestCollector(`{"KillDelay": 1}`)
+		So(resp.Init, ShouldEqual, InitComplete)
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		So(callKill(client, resp.Token), ShouldBeNil)
+		client.Close()
+		<-done
+	})
+	Convey("InitState names", t, func() {
+		So(InitPending.String(), ShouldEqual, "pending")
+		So(InitState(9).String(), ShouldEqual, "unknown")
+	})
+}
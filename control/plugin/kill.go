@@ -0,0 +1,128 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultOnKillTimeout is how long the OnKill hook may run when
+// Arg.OnKillTimeout is not set.
+var DefaultOnKillTimeout = 5 * time.Second
+
+// KillReason tells a plugin why it is being killed
+type KillReason int
+
+const (
+	// KillReasonOther is any reason not listed below
+	KillReasonOther KillReason = iota
+	// KillReasonControlShutdown means snapd is stopping
+	KillReasonControlShutdown
+	// KillReasonUnload means the plugin is being unloaded
+	KillReasonUnload
+	// KillReasonHeartbeatTimeout means control stopped pinging the plugin
+	KillReasonHeartbeatTimeout
+	// KillReasonUpgrade means the plugin is being replaced by a newer version
+	KillReasonUpgrade
+	// KillReasonSignal means the plugin process received SIGTERM or SIGINT
+	KillReasonSignal
+	// KillReasonPanic means the plugin panicked too often
+	KillReasonPanic
+	// KillReasonInitFailed means the plugin's Init failed too often
+	KillReasonInitFailed
+)
+
+var killReasons = [...]string{
+	"other",
+	"control-shutdown",
+	"unload",
+	"heartbeat-timeout",
+	"upgrade",
+	"signal",
+	"panic",
+	"init-failed",
+}
+
+func (r KillReason) String() string {
+	if r < 0 || int(r) >= len(killReasons) {
+		return killReasons[KillReasonOther]
+	}
+	return killReasons[r]
+}
+
+// ParseKillReason returns the KillReason named s, or KillReasonOther.
+func ParseKillReason(s string) KillReason {
+	for i, name := range killReasons {
+		if strings.EqualFold(s, name) {
+			return KillReason(i)
+		}
+	}
+	return KillReasonOther
+}
+
+// KillHandler may be implemented by a plugin to clean up, e.g. flush and
+// close its connections, before it exits.  OnKill runs at most once and is
+// given Arg.OnKillTimeout to return; its error is logged.
+type KillHandler interface {
+	OnKill(reason KillReason) error
+}
+
+// killHook runs the OnKill hook of a session once.
+type killHook struct {
+	once sync.Once
+	fn   func(KillReason) error
+}
+
+// SetOnKill registers fn to run before the session signals KillChan.  It
+// replaces the OnKill method of a plugin implementing KillHandler.
+func (s *SessionState) SetOnKill(fn func(KillReason) error) {
+	s.onKill.fn = fn
+}
+
+// runOnKill runs the OnKill hook, if one is registered, the first time it
+// is called.  It returns once the hook returns or its time is up.
+func (s *SessionState) runOnKill(reason KillReason) {
+	s.onKill.once.Do(func() {
+		if s.onKill.fn == nil {
+			return
+		}
+		timeout := s.OnKillTimeout
+		if timeout == 0 {
+			timeout = DefaultOnKillTimeout
+		}
+		errc := make(chan error, 1)
+		go func() {
+			defer catchPluginPanic(s.Logger())
+			errc <- s.onKill.fn(reason)
+		}()
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case err := <-errc:
+			if err != nil {
+				s.Logger().Errorf("OnKill(%v) failed: %v", reason, err)
+			}
+		case <-timer.C:
+			s.Logger().Errorf("OnKill(%v) did not return within %v", reason, timeout)
+		}
+	})
+}
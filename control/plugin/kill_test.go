@@ -0,0 +1,149 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// cleanupPublisher records the reasons it was killed with
+type cleanupPublisher struct {
+	mockPlugin
+
+	mutex   sync.Mutex
+	reasons []KillReason
+}
+
+func (c *cleanupPublisher) OnKill(reason KillReason) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.reasons = append(c.reasons, reason)
+	return nil
+}
+
+func (c *cleanupPublisher) killedWith() []KillReason {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]KillReason{}, c.reasons...)
+}
+
+func TestKillReason(t *testing.T) {
+	Convey("KillReason", t, func() {
+		So(KillReasonUnload.String(), ShouldEqual, "unload")
+		So(KillReason(42).String(), ShouldEqual, "other")
+		So(ParseKillReason("control-shutdown"), ShouldEqual, KillReasonControlShutdown)
+		So(ParseKillReason("Upgrade"), ShouldEqual, KillReasonUpgrade)
+		So(ParseKillReason("plugin dead"), ShouldEqual, KillReasonOther)
+	})
+}
+
+func TestOnKill(t *testing.T) {
+	Convey("A session", t, func() {
+		ss := &SessionState{
+			Arg:      &Arg{PingTimeoutDuration: time.Minute, KillDelay: time.Millisecond},
+			Encoder:  encoding.NewGobEncoder(),
+			token:    "s3cr3t",
+			logger:   log.New(),
+			killChan: make(chan int, 1),
+		}
+		kill := func(reason KillReason) {
+			in, err := ss.Encode(KillArgs{Reason: "testing", ReasonCode: reason, Token: "s3cr3t"})
+			So(err, ShouldBeNil)
+			So(ss.Kill(in, &[]byte{}), ShouldBeNil)
+		}
+
+		Convey("runs the OnKill hook once before ending the session", func() {
+			var reasons []KillReason
+			ended := true
+			ss.KillDelay = 0
+			ss.SetOnKill(func(r KillReason) error {
+				select {
+				case <-ss.Done():
+				default:
+					ended = false
+				}
+				reasons = append(reasons, r)
+				return nil
+			})
+			kill(KillReasonUnload)
+			kill(KillReasonControlShutdown)
+			<-ss.Done()
+			So(ended, ShouldBeFalse)
+			So(reasons, ShouldResemble, []KillReason{KillReasonUnload})
+		})
+		Convey("runs the OnKill hook when the heartbeat expires", func() {
+			var reasons []KillReason
+			ss.SetOnKill(func(r KillReason) error {
+				reasons = append(reasons, r)
+				return nil
+			})
+			ss.PingTimeoutDuration = time.Millisecond
+			ss.PingTimeoutLimit = 1
+			ss.heartbeatWatch()
+			kill(KillReasonUnload)
+			So(reasons, ShouldResemble, []KillReason{KillReasonHeartbeatTimeout})
+		})
+		Convey("is killed when the OnKill hook fails", func() {
+			ss.SetOnKill(func(KillReason) error {
+				return errors.New("flush failed")
+			})
+			kill(KillReasonOther)
+			So(<-ss.KillChan(), ShouldEqual, 0)
+		})
+		Convey("does not wait for an OnKill hook past its timeout", func() {
+			ss.OnKillTimeout = 50 * time.Millisecond
+			block := make(chan struct{})
+			defer close(block)
+			ss.SetOnKill(func(KillReason) error {
+				<-block
+				return nil
+			})
+			start := time.Now()
+			kill(KillReasonOther)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+			So(<-ss.KillChan(), ShouldEqual, 0)
+		})
+		Convey("is killed without an OnKill hook", func() {
+			kill(KillReasonOther)
+			So(<-ss.KillChan(), ShouldEqual, 0)
+		})
+	})
+	Convey("A plugin implementing KillHandler", t, func() {
+		p := &cleanupPublisher{}
+		m := &PluginMeta{Name: "mock", Version: 1, RPCType: NativeRPC, Type: PublisherPluginType, Unsecure: true}
+		ss, err, _ := NewSessionState(`{"KillDelay": 1000000}`, p, m)
+		So(err, ShouldBeNil)
+		in, err := ss.Encode(KillArgs{Reason: "testing", ReasonCode: KillReasonUpgrade, Token: ss.Token()})
+		So(err, ShouldBeNil)
+		So(ss.Kill(in, &[]byte{}), ShouldBeNil)
+		So(<-ss.KillChan(), ShouldEqual, 0)
+		So(p.killedWith(), ShouldResemble, []KillReason{KillReasonUpgrade})
+	})
+}
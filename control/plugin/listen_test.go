@@ -0,0 +1,196 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// externalIP returns an address of this host which is not loopback, or
+// nil if there is none.
+func externalIP() net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() && n.IP.To4() != nil {
+			return n.IP
+		}
+	}
+	return nil
+}
+
+func TestListenAddr(t *testing.T) {
+	Convey("A plugin started with a ListenAddr", t, func() {
+		Convey("binds to the loopback address by default", func() {
+			resp, done := startTestCollector(fmt.Sprintf(`{"PingTimeoutDuration": %d}`, time.Minute))
+			host, port, err := net.SplitHostPort(resp.ListenAddress)
+			So(err, ShouldBeNil)
+			So(host, ShouldEqual, DefaultListenAddr)
+			So(port, ShouldNotEqual, "0")
+			client, err := rpc.Dial("tcp", resp.ListenAddress)
+			So(err, ShouldBeNil)
+			defer client.Close()
+			So(callKill(client, resp.Token), ShouldBeNil)
+			<-done
+		})
+		Convey("binds to a hostname", func() {
+			resp, done := startTestCollector(fmt.Sprintf(`{"ListenAddr": "localhost", "PingTimeoutDuration": %d}`, time.Minute))
+			host, port, err := net.SplitHostPort(resp.ListenAddress)
+			So(err, ShouldBeNil)
+			So(net.ParseIP(host).IsLoopback(), ShouldBeTrue)
+			So(port, ShouldNotEqual, "0")
+			client, err := rpc.Dial("tcp", resp.ListenAddress)
+			So(err, ShouldBeNil)
+			defer client.Close()
+			So(callKill(client, resp.Token), ShouldBeNil)
+			<-done
+		})
+		Convey("binds to loopback only", func() {
+			resp, done := startTestCollector(fmt.Sprintf(`{"ListenAddr": "127.0.0.1", "PingTimeoutDuration": %d}`, time.Minute))
+			host, port, err := net.SplitHostPort(resp.ListenAddress)
+			So(err, ShouldBeNil)
+			So(host, ShouldEqual, "127.0.0.1")
+			So(port, ShouldNotEqual, "0")
+
+			if ip := externalIP(); ip != nil {
+				_, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), port), time.Second)
+				So(err, ShouldNotBeNil)
+			}
+
+			client, err := rpc.Dial("tcp", resp.ListenAddress)
+			So(err, ShouldBeNil)
+			defer client.Close()
+			So(callKill(client, resp.Token), ShouldBeNil)
+			<-done
+		})
+		Convey("fails when the address is not local", func() {
+			m := &PluginMeta{
+				Name:     "mock",
+				Version:  1,
+				RPCType:  NativeRPC,
+				Type:     CollectorPluginType,
+				Unsecure: true,
+			}
+			err, rc := Start(m, new(MockPlugin), `{"NoDaemon": true, "ListenAddr": "192.0.2.1"}`)
+			So(err, ShouldNotBeNil)
+			So(rc, ShouldEqual, 2)
+		})
+	})
+}
+
+// bindPortRange listens on n consecutive loopback ports and returns the
+// listeners, first port first.
+func bindPortRange(n int) []net.Listener {
+	for attempt := 0; attempt < 100; attempt++ {
+		first := 20000 + rand.Intn(20000)
+		var ls []net.Listener
+		for port := first; port < first+n; port++ {
+			l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+			if err != nil {
+				break
+			}
+			ls = append(ls, l)
+		}
+		if len(ls) == n {
+			return ls
+		}
+		for _, l := range ls {
+			l.Close()
+		}
+	}
+	So("no free port range", ShouldBeEmpty)
+	return nil
+}
+
+func listenerPort(l net.Listener) int {
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestListenPortRange(t *testing.T) {
+	Convey("A plugin started with a ListenPortRange", t, func() {
+		ls := bindPortRange(4)
+		defer func() {
+			for _, l := range ls {
+				l.Close()
+			}
+		}()
+		first, last := listenerPort(ls[0]), listenerPort(ls[len(ls)-1])
+		portRange := fmt.Sprintf("%d-%d", first, last)
+
+		Convey("listens on a free port in the range", func() {
+			ls[2].Close()
+			resp, done := startTestCollector(fmt.Sprintf(`{"ListenPortRange": %q, "PingTimeoutDuration": %d}`, portRange, time.Minute))
+			So(resp.State, ShouldEqual, PluginSuccess)
+			_, port, err := net.SplitHostPort(resp.ListenAddress)
+			So(err, ShouldBeNil)
+			So(port, ShouldEqual, strconv.Itoa(first+2))
+			client, err := rpc.Dial("tcp", resp.ListenAddress)
+			So(err, ShouldBeNil)
+			defer client.Close()
+			So(callKill(client, resp.Token), ShouldBeNil)
+			<-done
+		})
+		Convey("fails when every port in the range is in use", func() {
+			resp, done := startTestCollector(fmt.Sprintf(`{"ListenPortRange": %q}`, portRange))
+			So(resp.State, ShouldEqual, PluginFailure)
+			So(resp.ErrorMessage, ShouldContainSubstring, "no port available in ListenPortRange "+portRange)
+			So(<-done, ShouldEqual, 2)
+		})
+		Convey("is ignored when a ListenPort is set", func() {
+			resp, done := startTestCollector(fmt.Sprintf(`{"ListenPort": "0", "ListenPortRange": %q, "PingTimeoutDuration": %d}`, portRange, time.Minute))
+			So(resp.State, ShouldEqual, PluginSuccess)
+			_, port, err := net.SplitHostPort(resp.ListenAddress)
+			So(err, ShouldBeNil)
+			p, _ := strconv.Atoi(port)
+			So(p, ShouldNotBeBetweenOrEqual, first, last)
+			client, err := rpc.Dial("tcp", resp.ListenAddress)
+			So(err, ShouldBeNil)
+			defer client.Close()
+			So(callKill(client, resp.Token), ShouldBeNil)
+			<-done
+		})
+	})
+	Convey("An invalid ListenPortRange", t, func() {
+		m := &PluginMeta{
+			Name:     "mock",
+			Version:  1,
+			RPCType:  NativeRPC,
+			Type:     CollectorPluginType,
+			Unsecure: true,
+		}
+		for _, r := range []string{"40000", "40000-", "a-b", "0-10", "40100-40000", "40000-70000"} {
+			err, rc := Start(m, new(MockPlugin), fmt.Sprintf(`{"NoDaemon": true, "ListenPortRange": %q}`, r))
+			So(err, ShouldNotBeNil)
+			So(rc, ShouldEqual, 2)
+		}
+	})
+}
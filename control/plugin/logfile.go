@@ -0,0 +1,188 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// LogPathStderr is the Arg.PluginLogPath of a plugin logging to stderr
+const LogPathStderr = "-"
+
+// DefaultLogMaxBackups is the number of rotated log files kept unless
+// Arg.LogMaxBackups says otherwise.
+const DefaultLogMaxBackups = 3
+
+// logBackupTimeFormat stamps the name of a rotated log file.  Its names
+// sort in the order the files were rotated.
+const logBackupTimeFormat = "20060102-150405.000000000"
+
+// rotatingFile is a log file renamed to a timestamped backup once it grows
+// past maxSize, keeping the latest maxBackups backups.  A rotation which
+// fails is reported to errOut and writing goes on in the current file.
+type rotatingFile struct {
+	mutex      sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	// retryAt is the size at which a failed rotation is tried again
+	retryAt int64
+
+	now    func() time.Time
+	rename func(oldpath, newpath string) error
+	errOut io.Writer
+}
+
+// openRotatingFile opens path for appending.  A maxSize of 0 never rotates
+// the file.
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		now:        time.Now,
+		rename:     os.Rename,
+		errOut:     os.Stderr,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.retryAt = file, info.Size(), 0
+	return nil
+}
+
+// Write writes p to the file, after rotating it when p would take it past
+// maxSize.  p is never split between two files.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize && f.size >= f.retryAt {
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(f.errOut, "log rotation of %s failed, writing on: %v\n", f.path, err)
+			f.retryAt = f.size + f.maxSize
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the file to a backup, opens a new one and prunes the
+// oldest backups.  The current file stays open until the new one is.
+func (f *rotatingFile) rotate() error {
+	backup := f.path + "." + f.now().Format(logBackupTimeFormat)
+	for i := 1; fileExists(backup); i++ {
+		backup = fmt.Sprintf("%s.%s.%d", f.path, f.now().Format(logBackupTimeFormat), i)
+	}
+	if err := f.rename(f.path, backup); err != nil {
+		return err
+	}
+	old := f.file
+	if err := f.open(); err != nil {
+		// Go on writing to the renamed file
+		return err
+	}
+	old.Close()
+	return f.prune()
+}
+
+// prune removes the backups beyond maxBackups, oldest first.
+func (f *rotatingFile) prune() error {
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > f.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// backups returns the paths of the rotated files, oldest first.
+func (f *rotatingFile) backups() ([]string, error) {
+	matches, err := filepath.Glob(f.path + ".[0-9]*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func (f *rotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Close()
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// logFileError is returned by NewSessionState when the PluginLogPath
+// cannot be opened.
+type logFileError struct {
+	path string
+	err  error
+}
+
+func (e *logFileError) Error() string {
+	return fmt.Sprintf("cannot open the log file: %v", e.err)
+}
+
+// fields returns the ErrorFields of the Response: the path, and the errno
+// of a system error.
+func (e *logFileError) fields() map[string]string {
+	fields := map[string]string{"path": e.path}
+	err := e.err
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	if errno, ok := err.(syscall.Errno); ok {
+		fields["errno"] = strconv.Itoa(int(errno))
+	}
+	return fields
+}
@@ -0,0 +1,207 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// readLogLines returns the lines of the log file at path and of its
+// backups
+func readLogLines(f *rotatingFile) []string {
+	backups, err := f.backups()
+	So(err, ShouldBeNil)
+	var lines []string
+	for _, p := range append(backups, f.path) {
+		b, err := ioutil.ReadFile(p)
+		So(err, ShouldBeNil)
+		lines = append(lines, strings.SplitAfter(string(b), "\n")...)
+	}
+	var out []string
+	for _, l := range lines {
+		if l != "" {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+func TestRotatingFile(t *testing.T) {
+	Convey("A rotating log file", t, func() {
+		dir, err := ioutil.TempDir("", "snap-plugin-log")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "plugin.log")
+
+		Convey("loses no line across rotations of concurrent writes", func() {
+			f, err := openRotatingFile(path, 1024, 1000)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			const writers, lines = 8, 200
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < lines; i++ {
+						fmt.Fprintf(f, "writer-%d line-%04d\n", w, i)
+					}
+				}(w)
+			}
+			wg.Wait()
+
+			backups, err := f.backups()
+			So(err, ShouldBeNil)
+			So(len(backups), ShouldBeGreaterThan, 10)
+			for _, b := range backups {
+				info, err := os.Stat(b)
+				So(err, ShouldBeNil)
+				So(info.Size(), ShouldBeLessThanOrEqualTo, 1024)
+			}
+			seen := map[string]bool{}
+			for _, l := range readLogLines(f) {
+				So(l, ShouldStartWith, "writer-")
+				So(l, ShouldEndWith, "\n")
+				seen[l] = true
+			}
+			So(seen, ShouldHaveLength, writers*lines)
+		})
+		Convey("keeps the latest LogMaxBackups backups", func() {
+			f, err := openRotatingFile(path, 100, 2)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			for i := 0; i < 100; i++ {
+				fmt.Fprintf(f, "line-%04d\n", i)
+			}
+			backups, err := f.backups()
+			So(err, ShouldBeNil)
+			So(backups, ShouldHaveLength, 2)
+			lines := readLogLines(f)
+			So(lines[len(lines)-1], ShouldEqual, "line-0099\n")
+			So(lines[0], ShouldNotEqual, "line-0000\n")
+		})
+		Convey("writes on in the current file when rotation fails", func() {
+			f, err := openRotatingFile(path, 100, 2)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			var warnings bytes.Buffer
+			f.errOut = &warnings
+			f.rename = func(string, string) error { return errors.New("read-only file system") }
+			for i := 0; i < 100; i++ {
+				_, err := fmt.Fprintf(f, "line-%04d\n", i)
+				So(err, ShouldBeNil)
+			}
+			backups, err := f.backups()
+			So(err, ShouldBeNil)
+			So(backups, ShouldBeEmpty)
+			So(readLogLines(f), ShouldHaveLength, 100)
+			So(warnings.String(), ShouldContainSubstring, "read-only file system")
+			// A failed rotation is only retried once maxSize more was written
+			So(strings.Count(warnings.String(), "\n"), ShouldBeLessThan, 20)
+		})
+		Convey("receives the session log", func() {
+			m := NewPluginMeta("log-file", 1, CollectorPluginType, nil, nil, Unsecure(true))
+			ss, err, _ := NewSessionState(fmt.Sprintf(`{"LogLevel": "info", "PluginLogPath": %q, "LogMaxSizeMB": 1}`, path), &mockPlugin{}, m)
+			So(err, ShouldBeNil)
+			So(ss.LogMaxBackups, ShouldEqual, DefaultLogMaxBackups)
+			ss.Logger().Infof("to the file")
+			b, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEndWith, "] to the file\n")
+		})
+		Convey("is not opened when the arguments are invalid", func() {
+			m := NewPluginMeta("log-file", 1, CollectorPluginType, nil, nil, Unsecure(true))
+			_, err, rc := NewSessionState(fmt.Sprintf(`{"PluginLogPath": %q, "Codec": "xml"}`, path), &mockPlugin{}, m)
+			So(err, ShouldEqual, ErrUnsupportedCodec)
+			So(rc, ShouldEqual, 2)
+			_, err = os.Stat(path)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+		Convey("is not opened for a plugin with its own logger", func() {
+			m := NewPluginMeta("log-file", 1, CollectorPluginType, nil, nil, Unsecure(true))
+			_, err, _ := NewSessionState(fmt.Sprintf(`{"PluginLogPath": %q}`, path), &loggingCollector{logger: &recordingLogger{}}, m)
+			So(err, ShouldBeNil)
+			_, err = os.Stat(path)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+	Convey("A plugin's PluginLogPath", t, func() {
+		dir, err := ioutil.TempDir("", "snap-plugin-log")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		m := NewPluginMeta("log-file", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		start := func(path string) (Response, chan int) {
+			return startTestPlugin(m, &mockPlugin{}, fmt.Sprintf(`{"KillDelay": 1, "PluginLogPath": %q}`, path))
+		}
+		kill := func(resp Response, done chan int) {
+			client, err := rpc.Dial("tcp", resp.ListenAddress)
+			So(err, ShouldBeNil)
+			So(callKill(client, resp.Token), ShouldBeNil)
+			client.Close()
+			So(<-done, ShouldEqual, 0)
+		}
+
+		Convey("logs to stderr when empty", func() {
+			resp, done := start("")
+			So(resp.State, ShouldEqual, PluginSuccess)
+			kill(resp, done)
+		})
+		Convey("logs to stderr when -", func() {
+			resp, done := start(LogPathStderr)
+			So(resp.State, ShouldEqual, PluginSuccess)
+			kill(resp, done)
+			_, err := os.Stat(LogPathStderr)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+		Convey("logs to a writable file", func() {
+			path := filepath.Join(dir, "plugin.log")
+			resp, done := start(path)
+			So(resp.State, ShouldEqual, PluginSuccess)
+			kill(resp, done)
+			_, err := os.Stat(path)
+			So(err, ShouldBeNil)
+		})
+		Convey("fails the plugin when it cannot be created", func() {
+			path := filepath.Join(dir, "missing", "plugin.log")
+			resp, done := start(path)
+			So(<-done, ShouldEqual, 3)
+			So(resp.State, ShouldEqual, PluginFailure)
+			So(resp.ErrorCode, ShouldEqual, ErrorCodeLogFailed)
+			So(resp.ErrorMessage, ShouldContainSubstring, path)
+			So(resp.ErrorFields["path"], ShouldEqual, path)
+			So(resp.ErrorFields["errno"], ShouldEqual, strconv.Itoa(int(syscall.ENOENT)))
+		})
+	})
+}
@@ -0,0 +1,270 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	stdlog "log"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Logger is the logger of a session.  A *logrus.Logger is a Logger, and
+// NewStdLogger adapts a logger of the standard library.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// LoggerProvider may be implemented by a plugin for the session to log to
+// the plugin's own logger, rather than to a logger writing to stderr at
+// Arg.LogLevel.
+type LoggerProvider interface {
+	Logger() Logger
+}
+
+// The formats of Arg.LogFormat
+const (
+	// LogFormatText logs the message alone on each line
+	LogFormatText = "text"
+	// LogFormatJSON logs each entry as a JSON object on a line of its own,
+	// with the time, level, plugin, version, pid, session and msg keys
+	LogFormatJSON = "json"
+)
+
+// sessionIDLength is the number of leading characters of the session token
+// which identify the session in its log
+const sessionIDLength = 8
+
+// sessionKeys are the fields identifying a session, in the order of the
+// prefix of text log lines
+var sessionKeys = []string{"plugin", "version", "pid", "session"}
+
+// sessionFields returns the fields identifying the session of the plugin
+// described by meta.
+func sessionFields(meta *PluginMeta, token string) log.Fields {
+	id := token
+	if len(id) > sessionIDLength {
+		id = id[:sessionIDLength]
+	}
+	return log.Fields{
+		"plugin":  meta.Name,
+		"version": meta.Version,
+		"pid":     os.Getpid(),
+		"session": id,
+	}
+}
+
+// sessionPrefix returns the prefix of the text log lines of the session
+// identified by fields, e.g. "[mock v1 pid=4242 session=Zm9vYmFy] ".
+func sessionPrefix(fields log.Fields) string {
+	return fmt.Sprintf("[%v v%v pid=%v session=%v] ", fields["plugin"], fields["version"], fields["pid"], fields["session"])
+}
+
+// defaultLogger is used by a session without a logger
+var defaultLogger Logger = log.StandardLogger()
+
+// NewStdLogger returns a Logger writing the messages of level or above to
+// l, prefixed with their level.
+func NewStdLogger(l *stdlog.Logger, level log.Level) Logger {
+	return &stdLogger{l: l, level: level}
+}
+
+type stdLogger struct {
+	l     *stdlog.Logger
+	level log.Level
+}
+
+func (s *stdLogger) logf(level log.Level, format string, args []interface{}) {
+	// logrus levels grow more verbose from PanicLevel to DebugLevel
+	if level > s.level {
+		return
+	}
+	s.l.Output(3, fmt.Sprintf("[%s] ", level)+fmt.Sprintf(format, args...))
+}
+
+func (s *stdLogger) Debugf(format string, args ...interface{}) {
+	s.logf(log.DebugLevel, format, args)
+}
+
+func (s *stdLogger) Infof(format string, args ...interface{}) {
+	s.logf(log.InfoLevel, format, args)
+}
+
+func (s *stdLogger) Warnf(format string, args ...interface{}) {
+	s.logf(log.WarnLevel, format, args)
+}
+
+func (s *stdLogger) Errorf(format string, args ...interface{}) {
+	s.logf(log.ErrorLevel, format, args)
+}
+
+// UnmarshalJSON reads an Arg whose LogLevel is a name or a number.
+func (a *Arg) UnmarshalJSON(data []byte) error {
+	type arg Arg
+	aux := struct {
+		*arg
+		LogLevel json.RawMessage
+	}{arg: (*arg)(a)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if len(aux.LogLevel) == 0 {
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(aux.LogLevel, &name); err != nil {
+		return json.Unmarshal(aux.LogLevel, &a.LogLevel)
+	}
+	a.LogLevel, a.logLevelErr = parseLogLevel(name)
+	return nil
+}
+
+// parseLogLevel returns the level named name, or InfoLevel and an error
+// when the name is unknown.
+func parseLogLevel(name string) (log.Level, error) {
+	level, err := log.ParseLevel(strings.ToLower(strings.TrimSpace(name)))
+	if err != nil {
+		return log.InfoLevel, fmt.Errorf("unknown log level %q, logging at info", name)
+	}
+	return level, nil
+}
+
+// newSessionLogger returns the logger of a session writing to out in
+// a.LogFormat, or in text and an error when the format is unknown.  Each
+// entry carries the sessionFields.
+func newSessionLogger(a *Arg, fields log.Fields, out io.Writer) (Logger, error) {
+	l := &log.Logger{
+		Out:       out,
+		Formatter: &simpleFormatter{},
+		Hooks:     make(log.LevelHooks),
+		Level:     a.LogLevel,
+	}
+	var err error
+	switch a.LogFormat {
+	case "", LogFormatText:
+	case LogFormatJSON:
+		l.Formatter = &log.JSONFormatter{}
+	default:
+		err = fmt.Errorf("unknown log format %q, logging text", a.LogFormat)
+	}
+	return l.WithFields(fields), err
+}
+
+// withSession returns l logging the sessionFields with each message: as
+// keys of a logrus logger, or as the prefix of the message of any other
+// Logger.
+func withSession(l Logger, fields log.Fields) Logger {
+	switch ll := l.(type) {
+	case *log.Logger:
+		return ll.WithFields(fields)
+	case *log.Entry:
+		return ll.WithFields(fields)
+	}
+	return &affixLogger{l: l, prefix: sessionPrefix(fields)}
+}
+
+// logWith returns l logging fields along with each message: as keys of a
+// logrus logger, or appended to the message of any other Logger.
+func logWith(l Logger, fields map[string]interface{}) Logger {
+	switch ll := l.(type) {
+	case *log.Logger:
+		return ll.WithFields(log.Fields(fields))
+	case *log.Entry:
+		return ll.WithFields(log.Fields(fields))
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	suffix := ""
+	for _, k := range keys {
+		suffix += fmt.Sprintf(" %s=%v", k, fields[k])
+	}
+	if a, ok := l.(*affixLogger); ok {
+		return &affixLogger{l: a.l, prefix: a.prefix, suffix: a.suffix + suffix}
+	}
+	return &affixLogger{l: l, suffix: suffix}
+}
+
+// affixLogger adds a prefix and a suffix to the messages of a Logger
+type affixLogger struct {
+	l      Logger
+	prefix string
+	suffix string
+}
+
+func (a *affixLogger) Debugf(format string, args ...interface{}) {
+	a.l.Debugf("%s%s%s", a.prefix, fmt.Sprintf(format, args...), a.suffix)
+}
+
+func (a *affixLogger) Infof(format string, args ...interface{}) {
+	a.l.Infof("%s%s%s", a.prefix, fmt.Sprintf(format, args...), a.suffix)
+}
+
+func (a *affixLogger) Warnf(format string, args ...interface{}) {
+	a.l.Warnf("%s%s%s", a.prefix, fmt.Sprintf(format, args...), a.suffix)
+}
+
+func (a *affixLogger) Errorf(format string, args ...interface{}) {
+	a.l.Errorf("%s%s%s", a.prefix, fmt.Sprintf(format, args...), a.suffix)
+}
+
+// simpleFormatter is a logrus formatter writing the message, prefixed with
+// the sessionFields and followed by any other field.
+type simpleFormatter struct{}
+
+func (*simpleFormatter) Format(entry *log.Entry) ([]byte, error) {
+	b := &bytes.Buffer{}
+	if _, ok := entry.Data["session"]; ok {
+		b.WriteString(sessionPrefix(entry.Data))
+	}
+	b.WriteString(entry.Message)
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		if !isSessionKey(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, " %s=%v", k, entry.Data[k])
+	}
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+func isSessionKey(k string) bool {
+	for _, sk := range sessionKeys {
+		if k == sk {
+			return true
+		}
+	}
+	return false
+}
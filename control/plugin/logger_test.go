@@ -0,0 +1,328 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	stdlog "log"
+	"net/rpc"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// recordingLogger records the messages logged at each level
+type recordingLogger struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (r *recordingLogger) record(level, format string, args []interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.messages = append(r.messages, level+" "+strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
+
+func (r *recordingLogger) Debugf(format string, args ...interface{}) { r.record("debug", format, args) }
+func (r *recordingLogger) Infof(format string, args ...interface{})  { r.record("info", format, args) }
+func (r *recordingLogger) Warnf(format string, args ...interface{})  { r.record("warn", format, args) }
+func (r *recordingLogger) Errorf(format string, args ...interface{}) { r.record("error", format, args) }
+
+// logged returns the messages starting with prefix
+func (r *recordingLogger) logged(prefix string) []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var out []string
+	for _, m := range r.messages {
+		if strings.HasPrefix(m, prefix) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// loggingCollector logs the session to its own logger
+type loggingCollector struct {
+	mockPlugin
+	logger *recordingLogger
+}
+
+func (l *loggingCollector) Logger() Logger {
+	return l.logger
+}
+
+func TestSessionLogger(t *testing.T) {
+	Convey("A session with a recording logger", t, func() {
+		rec := &recordingLogger{}
+		ss := &SessionState{
+			Arg:     &Arg{PingTimeoutDuration: time.Millisecond, PingTimeoutLimit: 1},
+			Encoder: encoding.NewGobEncoder(),
+			token:   "s3cr3t",
+		}
+		ss.SetLogger(rec)
+
+		Convey("logs pings and kills", func() {
+			in, err := ss.Encode(PingArgs{Token: "s3cr3t"})
+			So(err, ShouldBeNil)
+			So(ss.Ping(in, &[]byte{}), ShouldBeNil)
+			So(rec.logged("debug Ping received"), ShouldHaveLength, 1)
+
+			in, err = ss.Encode(KillArgs{Reason: "unloading", Token: "s3cr3t"})
+			So(err, ShouldBeNil)
+			So(ss.Kill(in, &[]byte{}), ShouldBeNil)
+			<-ss.Done()
+			So(rec.logged("warn Kill called by agent, reason: unloading"), ShouldHaveLength, 1)
+		})
+		Convey("logs the heartbeat timeout", func() {
+			ss.heartbeatWatch()
+			So(rec.logged("debug Heartbeat started"), ShouldHaveLength, 1)
+			So(rec.logged("error Heartbeat timeout expired"), ShouldHaveLength, 1)
+		})
+		Convey("logs rejected calls", func() {
+			in, err := ss.Encode(PingArgs{Token: "wrong"})
+			So(err, ShouldBeNil)
+			So(ss.Ping(in, &[]byte{}), ShouldNotBeNil)
+			So(rec.logged("debug Call rejected: invalid session token"), ShouldHaveLength, 1)
+		})
+	})
+	Convey("A session without a logger", t, func() {
+		ss := &SessionState{
+			Arg:     &Arg{PingTimeoutDuration: time.Millisecond, PingTimeoutLimit: 1},
+			Encoder: encoding.NewGobEncoder(),
+		}
+		So(ss.Logger(), ShouldNotBeNil)
+		So(ss.heartbeatWatch, ShouldNotPanic)
+		So(ss.ShutdownReason().Source, ShouldEqual, ShutdownSourceHeartbeat)
+	})
+	Convey("A plugin providing its logger", t, func() {
+		rec := &recordingLogger{}
+		m := NewPluginMeta("logging", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		resp, done := startTestPlugin(m, &loggingCollector{logger: rec}, `{"KillDelay": 1, "LogLevel": 5}`)
+		So(resp.State, ShouldEqual, PluginSuccess)
+		prefix := fmt.Sprintf("[logging v1 pid=%d session=%s] ", os.Getpid(), resp.Token[:sessionIDLength])
+		So(rec.logged("debug "+prefix+"Listening"), ShouldHaveLength, 1)
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		So(callKill(client, resp.Token), ShouldBeNil)
+		client.Close()
+		<-done
+		So(rec.logged("info "+prefix+"{"), ShouldHaveLength, 1)
+		So(rec.logged("warn "+prefix+"Kill called by agent"), ShouldHaveLength, 1)
+	})
+	Convey("A standard library logger", t, func() {
+		var buf bytes.Buffer
+		l := NewStdLogger(stdlog.New(&buf, "", 0), log.InfoLevel)
+		l.Debugf("hidden %d", 1)
+		l.Infof("shown %d", 2)
+		l.Errorf("shown %d", 3)
+		So(buf.String(), ShouldEqual, "[info] shown 2\n[error] shown 3\n")
+	})
+}
+
+func TestLogLevel(t *testing.T) {
+	Convey("Arg.LogLevel", t, func() {
+		levels := map[string]log.Level{
+			`"debug"`: log.DebugLevel,
+			`"Info"`:  log.InfoLevel,
+			`"warn"`:  log.WarnLevel,
+			`"error"`: log.ErrorLevel,
+			`5`:       log.DebugLevel,
+		}
+		for in, level := range levels {
+			var a Arg
+			So(json.Unmarshal([]byte(`{"LogLevel": `+in+`}`), &a), ShouldBeNil)
+			So(a.LogLevel, ShouldEqual, level)
+			So(a.logLevelErr, ShouldBeNil)
+		}
+		Convey("falls back to info when unknown", func() {
+			var a Arg
+			So(json.Unmarshal([]byte(`{"LogLevel": "verbose", "ListenPort": "0"}`), &a), ShouldBeNil)
+			So(a.LogLevel, ShouldEqual, log.InfoLevel)
+			So(a.logLevelErr, ShouldNotBeNil)
+			So(a.ListenPort, ShouldEqual, "0")
+
+			m := NewPluginMeta("levels", 1, CollectorPluginType, nil, nil, Unsecure(true))
+			ss, err, _ := NewSessionState(`{"LogLevel": "verbose"}`, &mockPlugin{}, m)
+			So(err, ShouldBeNil)
+			So(ss.LogLevel, ShouldEqual, log.InfoLevel)
+		})
+	})
+	Convey("A session logging at info", t, func() {
+		m := NewPluginMeta("levels", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		ss, err, _ := NewSessionState(fmt.Sprintf(`{"LogLevel": "info", "PingTimeoutDuration": %d, "PingTimeoutLimit": 2}`, time.Millisecond), &mockPlugin{}, m)
+		So(err, ShouldBeNil)
+		var buf bytes.Buffer
+		ss.Logger().(*log.Entry).Logger.Out = &buf
+
+		in, err := ss.Encode(PingArgs{Token: ss.Token()})
+		So(err, ShouldBeNil)
+		So(ss.Ping(in, &[]byte{}), ShouldBeNil)
+		ss.heartbeatWatch()
+		So(buf.String(), ShouldNotContainSubstring, "Ping received")
+		So(buf.String(), ShouldNotContainSubstring, "Heartbeat started")
+		So(buf.String(), ShouldContainSubstring, "Heartbeat timeout 1 of 2")
+		So(buf.String(), ShouldContainSubstring, "Heartbeat timeout expired")
+	})
+	Convey("A session logging at debug", t, func() {
+		m := NewPluginMeta("levels", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		ss, err, _ := NewSessionState(fmt.Sprintf(`{"LogLevel": "debug", "PingTimeoutDuration": %d, "PingTimeoutLimit": 1}`, time.Millisecond), &mockPlugin{}, m)
+		So(err, ShouldBeNil)
+		var buf bytes.Buffer
+		ss.Logger().(*log.Entry).Logger.Out = &buf
+		ss.heartbeatWatch()
+		So(buf.String(), ShouldContainSubstring, "Heartbeat started")
+		So(buf.String(), ShouldContainSubstring, "Heartbeat timeout expired")
+	})
+}
+
+func TestLogFormat(t *testing.T) {
+	Convey("A session logging JSON", t, func() {
+		m := NewPluginMeta("json-logs", 3, CollectorPluginType, nil, nil, Unsecure(true))
+		ss, err, _ := NewSessionState(fmt.Sprintf(`{"LogLevel": "debug", "LogFormat": "json", "PingTimeoutDuration": %d, "PingTimeoutLimit": 1}`, time.Millisecond), &mockPlugin{}, m)
+		So(err, ShouldBeNil)
+		var buf bytes.Buffer
+		ss.Logger().(*log.Entry).Logger.Out = &buf
+
+		in, err := ss.Encode(PingArgs{Token: ss.Token()})
+		So(err, ShouldBeNil)
+		So(ss.Ping(in, &[]byte{}), ShouldBeNil)
+		ss.recordCall("Collector.GetConfigPolicy", 1500*time.Millisecond, errors.New("device unreachable"))
+		ss.heartbeatWatch()
+
+		var entries []map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var e map[string]interface{}
+			So(json.Unmarshal([]byte(line), &e), ShouldBeNil)
+			entries = append(entries, e)
+		}
+		msgs := map[string]map[string]interface{}{}
+		for _, e := range entries {
+			for _, k := range []string{"time", "level", "plugin", "version", "session", "msg"} {
+				So(e, ShouldContainKey, k)
+			}
+			So(e["plugin"], ShouldEqual, "json-logs")
+			So(e["version"], ShouldEqual, 3)
+			So(e["pid"], ShouldEqual, os.Getpid())
+			So(ss.Token(), ShouldStartWith, e["session"])
+			So(e["session"], ShouldHaveLength, sessionIDLength)
+			msgs[e["msg"].(string)] = e
+		}
+		So(msgs, ShouldContainKey, "Ping received")
+		So(msgs["Ping received"]["level"], ShouldEqual, "debug")
+		So(msgs, ShouldContainKey, "Heartbeat started")
+		call := msgs["Call served"]
+		So(call["method"], ShouldEqual, "Collector.GetConfigPolicy")
+		So(call["duration"], ShouldEqual, "1.5s")
+		So(call["error"], ShouldEqual, "device unreachable")
+		var expired bool
+		for msg, e := range msgs {
+			if strings.HasPrefix(msg, "Heartbeat timeout expired") {
+				expired = true
+				So(e["level"], ShouldEqual, "error")
+			}
+		}
+		So(expired, ShouldBeTrue)
+	})
+	Convey("A session given an unknown log format logs text", t, func() {
+		m := NewPluginMeta("text-logs", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		ss, err, _ := NewSessionState(`{"LogFormat": "xml"}`, &mockPlugin{}, m)
+		So(err, ShouldBeNil)
+		_, ok := ss.Logger().(*log.Entry).Logger.Formatter.(*simpleFormatter)
+		So(ok, ShouldBeTrue)
+	})
+	Convey("Fields given to another Logger", t, func() {
+		rec := &recordingLogger{}
+		logWith(rec, map[string]interface{}{"method": "Collector.CollectMetrics", "duration": "1s"}).Infof("Call %s", "served")
+		So(rec.logged(""), ShouldResemble, []string{"info Call served duration=1s method=Collector.CollectMetrics"})
+	})
+}
+
+func TestSessionPrefix(t *testing.T) {
+	Convey("The log lines of a session", t, func() {
+		m := NewPluginMeta("prefixed", 2, CollectorPluginType, nil, nil, Unsecure(true))
+		args := fmt.Sprintf(`{"LogLevel": "debug", "PingTimeoutDuration": %d, "PingTimeoutLimit": 1}`, time.Millisecond)
+		// served pings, serves a call and is killed
+		served := func(ss *SessionState) {
+			in, err := ss.Encode(PingArgs{Token: ss.Token()})
+			So(err, ShouldBeNil)
+			So(ss.Ping(in, &[]byte{}), ShouldBeNil)
+			ss.recordCall("Collector.GetConfigPolicy", time.Millisecond, nil)
+			in, err = ss.Encode(KillArgs{Reason: "test", Token: ss.Token()})
+			So(err, ShouldBeNil)
+			So(ss.Kill(in, &[]byte{}), ShouldBeNil)
+		}
+		// shouldBePrefixed asserts that each of msgs was logged in lines,
+		// prefixed with the plugin, version, PID and session of ss
+		shouldBePrefixed := func(ss *SessionState, lines []string, msgs ...string) {
+			prefix := fmt.Sprintf("[prefixed v2 pid=%d session=%s] ", os.Getpid(), ss.Token()[:sessionIDLength])
+			for _, msg := range msgs {
+				var found []string
+				for _, l := range lines {
+					if strings.Contains(l, prefix+msg) {
+						found = append(found, l)
+					}
+				}
+				So(found, ShouldNotBeEmpty)
+			}
+		}
+
+		Convey("written by the session logger", func() {
+			var buf bytes.Buffer
+			ss, err, _ := NewSessionState(args, &mockPlugin{}, m)
+			So(err, ShouldBeNil)
+			ss.Logger().(*log.Entry).Logger.Out = &buf
+			served(ss)
+			expired, err, _ := NewSessionState(args, &mockPlugin{}, m)
+			So(err, ShouldBeNil)
+			expired.Logger().(*log.Entry).Logger.Out = &buf
+			expired.heartbeatWatch()
+
+			lines := strings.Split(buf.String(), "\n")
+			shouldBePrefixed(ss, lines, "Ping received", "Call served", "Kill called by agent")
+			shouldBePrefixed(expired, lines, "Heartbeat timeout expired")
+			So(buf.String(), ShouldContainSubstring, "Call served duration=1ms method=Collector.GetConfigPolicy\n")
+		})
+		Convey("written by the plugin's own logger", func() {
+			rec := &recordingLogger{}
+			ss, err, _ := NewSessionState(args, &loggingCollector{logger: rec}, m)
+			So(err, ShouldBeNil)
+			served(ss)
+			expired, err, _ := NewSessionState(args, &loggingCollector{logger: rec}, m)
+			So(err, ShouldBeNil)
+			expired.heartbeatWatch()
+
+			lines := rec.logged("")
+			shouldBePrefixed(ss, lines, "Ping received", "Call served", "Kill called by agent")
+			shouldBePrefixed(expired, lines, "Heartbeat timeout expired")
+		})
+	})
+}
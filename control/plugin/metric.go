@@ -42,8 +42,11 @@ const (
 	SnapGOBContentType = "snap.gob"
 	// SnapJSON snap metrics serialized into json
 	SnapJSONContentType = "snap.json"
-	// SnapProtoBuff snap metrics serialized into protocol buffers
-	// SnapProtoBuff = "snap.pb" // TO BE IMPLEMENTED
+	// SnapProtoBuf snap metrics serialized into protocol buffers, see
+	// metric.proto
+	SnapProtoBufContentType = "snap.protobuf"
+	// SnapMsgPack snap metrics serialized into MessagePack, see msgpack.go
+	SnapMsgPackContentType = "snap.msgpack"
 )
 
 type ConfigType struct {
@@ -103,6 +106,12 @@ type MetricType struct {
 	// The config data needed to collect a metric.
 	Config_ *cdata.ConfigDataNode `json:"config"`
 
+	// Data is the collected value.  When encoded as snap.gob the concrete
+	// type of the value (int, uint64, float64, string, []byte, ...) is
+	// preserved.  When encoded as snap.json numbers are decoded as float64
+	// and []byte as a base64 encoded string.  snap.protobuf preserves the
+	// types it has a field for, see MetricMessage, and snap.msgpack every
+	// number type.
 	Data_ interface{} `json:"data"`
 
 	// Tags are key value pairs that can be added by the framework or any
@@ -112,11 +121,11 @@ type MetricType struct {
 	// Unit represents the unit of magnitude of the measured quantity.
 	// See http://metrics20.org/spec/#units as a guideline for this
 	// field.
-	Unit_ string
+	Unit_ string `json:"Unit_,omitempty"`
 
 	// A (long) description for the metric.  The description is stored on the
 	// metric catalog and not sent through  collect -> process -> publish.
-	Description_ string `json:"description"`
+	Description_ string `json:"description,omitempty"`
 
 	// The timestamp from when the metric was created.
 	Timestamp_ time.Time `json:"timestamp"`
@@ -139,17 +148,57 @@ func (p MetricType) Namespace() core.Namespace {
 	return p.Namespace_
 }
 
+// Match returns true if the provided namespace resolves to this metric.  A
+// dynamic element (or "*") in the metric namespace matches any value at that
+// position, so a concrete namespace requested for collection can be mapped
+// back to its catalog entry.
+func (p MetricType) Match(query []string) bool {
+	if len(query) != len(p.Namespace_) {
+		return false
+	}
+	return p.matchElements(query)
+}
+
+// MatchPrefix returns true if the leading elements of this metric's namespace
+// match the provided prefix.  Dynamic elements are treated as in Match.  An
+// empty prefix matches every metric.
+func (p MetricType) MatchPrefix(prefix []string) bool {
+	if len(prefix) > len(p.Namespace_) {
+		return false
+	}
+	return p.matchElements(prefix)
+}
+
+func (p MetricType) matchElements(query []string) bool {
+	for i, q := range query {
+		e := p.Namespace_[i]
+		if e.IsDynamic() || e.Value == "*" || q == "*" {
+			if q == "" {
+				return false
+			}
+			continue
+		}
+		if e.Value != q {
+			return false
+		}
+	}
+	return true
+}
+
 // Returns the last time this metric type was received from the plugin.
 func (p MetricType) LastAdvertisedTime() time.Time {
 	return p.LastAdvertisedTime_
 }
 
-// Returns the namespace.
+// Returns the version.
 func (p MetricType) Version() int {
 	return p.Version_
 }
 
-// Config returns the map of config data for this metric
+// Config returns the map of config data for this metric.  On a metric
+// requested by CollectMetrics it is the config of that metric alone, already
+// merged by control from the task, the plugin config and the policy
+// defaults.  It is nil when the metric was requested without config.
 func (p MetricType) Config() *cdata.ConfigDataNode {
 	return p.Config_
 }
@@ -183,6 +232,71 @@ func (p *MetricType) AddData(data interface{}) {
 	p.Data_ = data
 }
 
+// SetVersion sets the metric version.  Metrics advertised without a version
+// are given the version of the plugin.
+func (p *MetricType) SetVersion(v int) {
+	p.Version_ = v
+}
+
+// AddTag adds a tag to the metric replacing the value of an existing tag with
+// the same key.
+func (p *MetricType) AddTag(key, value string) {
+	if p.Tags_ == nil {
+		p.Tags_ = map[string]string{}
+	}
+	p.Tags_[key] = value
+}
+
+// MergeTags merges the provided tags (e.g. from the task) into the metric
+// tags.  The provided tags win when a key exists on both.
+func (p *MetricType) MergeTags(tags map[string]string) {
+	for k, v := range tags {
+		p.AddTag(k, v)
+	}
+}
+
+// EncodeMetrics returns metrics serialized in the content type provided,
+// one of snap.gob, snap.json, snap.protobuf or snap.msgpack.
+func EncodeMetrics(contentType string, metrics []MetricType) ([]byte, error) {
+	switch contentType {
+	case SnapGOBContentType:
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(metrics); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case SnapJSONContentType:
+		return json.Marshal(metrics)
+	case SnapProtoBufContentType:
+		return encodeProtobufMetrics(metrics)
+	case SnapMsgPackContentType:
+		return encodeMsgpackMetrics(metrics)
+	}
+	return nil, fmt.Errorf("invalid snap content type: %s", contentType)
+}
+
+// DecodeMetrics returns the metrics serialized in payload by EncodeMetrics.
+func DecodeMetrics(contentType string, payload []byte) ([]MetricType, error) {
+	var metrics []MetricType
+	switch contentType {
+	case SnapGOBContentType:
+		if err := gob.NewDecoder(bytes.NewBuffer(payload)).Decode(&metrics); err != nil {
+			return nil, err
+		}
+		return metrics, nil
+	case SnapJSONContentType:
+		if err := json.Unmarshal(payload, &metrics); err != nil {
+			return nil, err
+		}
+		return metrics, nil
+	case SnapProtoBufContentType:
+		return decodeProtobufMetrics(payload)
+	case SnapMsgPackContentType:
+		return decodeMsgpackMetrics(payload)
+	}
+	return nil, fmt.Errorf("invalid snap content type for unmarshalling: %s", contentType)
+}
+
 // MarshalMetricTypes returns a []byte containing a serialized version of []MetricType using the content type provided.
 func MarshalMetricTypes(contentType string, metrics []MetricType) ([]byte, string, error) {
 	// If we have an empty slice we return an error
@@ -195,85 +309,34 @@ func MarshalMetricTypes(contentType string, metrics []MetricType) ([]byte, strin
 		}).Error("error while marshalling")
 		return nil, "", errors.New(es)
 	}
-	// Switch on content type
-	switch contentType {
-	case SnapAllContentType, SnapGOBContentType:
-		// NOTE: A snap All wildcard will result in GOB
-		var buf bytes.Buffer
-		enc := gob.NewEncoder(&buf)
-		err := enc.Encode(metrics)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"_module": "control-plugin",
-				"block":   "marshal-content-type",
-				"error":   err.Error(),
-			}).Error("error while marshalling")
-			return nil, "", err
-		}
-		// contentType := SnapGOBContentType
-		return buf.Bytes(), SnapGOBContentType, nil
-	case SnapJSONContentType:
-		// Serialize into JSON
-		b, err := json.Marshal(metrics)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"_module": "control-plugin",
-				"block":   "marshal-content-type",
-				"error":   err.Error(),
-			}).Error("error while marshalling")
-			return nil, "", err
-		}
-		return b, SnapJSONContentType, nil
-	default:
-		// We don't recognize this content type. Log and return error.
-		es := fmt.Sprintf("invalid snap content type: %s", contentType)
+	// NOTE: A snap All wildcard will result in GOB
+	if contentType == SnapAllContentType {
+		contentType = SnapGOBContentType
+	}
+	b, err := EncodeMetrics(contentType, metrics)
+	if err != nil {
 		log.WithFields(log.Fields{
 			"_module": "control-plugin",
 			"block":   "marshal-content-type",
-			"error":   es,
+			"error":   err.Error(),
 		}).Error("error while marshalling")
-		return nil, "", errors.New(es)
+		return nil, "", err
 	}
+	return b, contentType, nil
 }
 
 // UnmarshallMetricTypes takes a content type and []byte payload and returns a []MetricType
 func UnmarshallMetricTypes(contentType string, payload []byte) ([]MetricType, error) {
-	switch contentType {
-	case SnapGOBContentType:
-		var metrics []MetricType
-		r := bytes.NewBuffer(payload)
-		err := gob.NewDecoder(r).Decode(&metrics)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"_module": "control-plugin",
-				"block":   "unmarshal-content-type",
-				"error":   err.Error(),
-			}).Error("error while unmarshalling")
-			return nil, err
-		}
-		return metrics, nil
-	case SnapJSONContentType:
-		var metrics []MetricType
-		err := json.Unmarshal(payload, &metrics)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"_module": "control-plugin",
-				"block":   "unmarshal-content-type",
-				"error":   err.Error(),
-			}).Error("error while unmarshalling")
-			return nil, err
-		}
-		return metrics, nil
-	default:
-		// We don't recognize this content type as one we can unmarshal. Log and return error.
-		es := fmt.Sprintf("invalid snap content type for unmarshalling: %s", contentType)
+	metrics, err := DecodeMetrics(contentType, payload)
+	if err != nil {
 		log.WithFields(log.Fields{
 			"_module": "control-plugin",
 			"block":   "unmarshal-content-type",
-			"error":   es,
+			"error":   err.Error(),
 		}).Error("error while unmarshalling")
-		return nil, errors.New(es)
+		return nil, err
 	}
+	return metrics, nil
 }
 
 // SwapMetricContentType swaps a payload with one content type to another one.
@@ -0,0 +1,761 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by protoc-gen-go.
+// source: github.com/intelsdi-x/snap/control/plugin/metric.proto
+// DO NOT EDIT!
+
+/*
+Package plugin is a generated protocol buffer package.
+
+It is generated from these files:
+
+	github.com/intelsdi-x/snap/control/plugin/metric.proto
+
+It has these top-level messages:
+
+	MetricBatch
+	MetricMessage
+	NamespaceElementMessage
+	TimeMessage
+	ConfigMessage
+	ConfigValueMessage
+*/
+package plugin
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+// MetricBatch is a batch of metrics in the snap.protobuf content type.
+type MetricBatch struct {
+	Metrics []*MetricMessage `protobuf:"bytes,1,rep,name=metrics" json:"metrics,omitempty"`
+}
+
+func (m *MetricBatch) Reset()                    { *m = MetricBatch{} }
+func (m *MetricBatch) String() string            { return proto.CompactTextString(m) }
+func (*MetricBatch) ProtoMessage()               {}
+func (*MetricBatch) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+func (m *MetricBatch) GetMetrics() []*MetricMessage {
+	if m != nil {
+		return m.Metrics
+	}
+	return nil
+}
+
+// MetricMessage is a MetricType.  The Go type of its data is kept by the
+// field of the data oneof it is set in.
+type MetricMessage struct {
+	Namespace          []*NamespaceElementMessage `protobuf:"bytes,1,rep,name=namespace" json:"namespace,omitempty"`
+	Version            int64                      `protobuf:"varint,2,opt,name=version" json:"version,omitempty"`
+	Config             *ConfigMessage             `protobuf:"bytes,3,opt,name=config" json:"config,omitempty"`
+	LastAdvertisedTime *TimeMessage               `protobuf:"bytes,4,opt,name=last_advertised_time,json=lastAdvertisedTime" json:"last_advertised_time,omitempty"`
+	Tags               map[string]string          `protobuf:"bytes,5,rep,name=tags" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Timestamp          *TimeMessage               `protobuf:"bytes,6,opt,name=timestamp" json:"timestamp,omitempty"`
+	Unit               string                     `protobuf:"bytes,7,opt,name=unit" json:"unit,omitempty"`
+	Description        string                     `protobuf:"bytes,8,opt,name=description" json:"description,omitempty"`
+	// Types that are valid to be assigned to Data:
+	//	*MetricMessage_StringData
+	//	*MetricMessage_BytesData
+	//	*MetricMessage_BoolData
+	//	*MetricMessage_Float32Data
+	//	*MetricMessage_Float64Data
+	//	*MetricMessage_IntData
+	//	*MetricMessage_Int32Data
+	//	*MetricMessage_Int64Data
+	//	*MetricMessage_UintData
+	//	*MetricMessage_Uint32Data
+	//	*MetricMessage_Uint64Data
+	Data isMetricMessage_Data `protobuf_oneof:"data"`
+}
+
+func (m *MetricMessage) Reset()                    { *m = MetricMessage{} }
+func (m *MetricMessage) String() string            { return proto.CompactTextString(m) }
+func (*MetricMessage) ProtoMessage()               {}
+func (*MetricMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+type isMetricMessage_Data interface{ isMetricMessage_Data() }
+
+type MetricMessage_StringData struct {
+	StringData string `protobuf:"bytes,9,opt,name=string_data,json=stringData,oneof"`
+}
+type MetricMessage_BytesData struct {
+	BytesData []byte `protobuf:"bytes,10,opt,name=bytes_data,json=bytesData,proto3,oneof"`
+}
+type MetricMessage_BoolData struct {
+	BoolData bool `protobuf:"varint,11,opt,name=bool_data,json=boolData,oneof"`
+}
+type MetricMessage_Float32Data struct {
+	Float32Data float32 `protobuf:"fixed32,12,opt,name=float32_data,json=float32Data,oneof"`
+}
+type MetricMessage_Float64Data struct {
+	Float64Data float64 `protobuf:"fixed64,13,opt,name=float64_data,json=float64Data,oneof"`
+}
+type MetricMessage_IntData struct {
+	IntData int64 `protobuf:"varint,14,opt,name=int_data,json=intData,oneof"`
+}
+type MetricMessage_Int32Data struct {
+	Int32Data int32 `protobuf:"varint,15,opt,name=int32_data,json=int32Data,oneof"`
+}
+type MetricMessage_Int64Data struct {
+	Int64Data int64 `protobuf:"varint,16,opt,name=int64_data,json=int64Data,oneof"`
+}
+type MetricMessage_UintData struct {
+	UintData uint64 `protobuf:"varint,17,opt,name=uint_data,json=uintData,oneof"`
+}
+type MetricMessage_Uint32Data struct {
+	Uint32Data uint32 `protobuf:"varint,18,opt,name=uint32_data,json=uint32Data,oneof"`
+}
+type MetricMessage_Uint64Data struct {
+	Uint64Data uint64 `protobuf:"varint,19,opt,name=uint64_data,json=uint64Data,oneof"`
+}
+
+func (*MetricMessage_StringData) isMetricMessage_Data()  {}
+func (*MetricMessage_BytesData) isMetricMessage_Data()   {}
+func (*MetricMessage_BoolData) isMetricMessage_Data()    {}
+func (*MetricMessage_Float32Data) isMetricMessage_Data() {}
+func (*MetricMessage_Float64Data) isMetricMessage_Data() {}
+func (*MetricMessage_IntData) isMetricMessage_Data()     {}
+func (*MetricMessage_Int32Data) isMetricMessage_Data()   {}
+func (*MetricMessage_Int64Data) isMetricMessage_Data()   {}
+func (*MetricMessage_UintData) isMetricMessage_Data()    {}
+func (*MetricMessage_Uint32Data) isMetricMessage_Data()  {}
+func (*MetricMessage_Uint64Data) isMetricMessage_Data()  {}
+
+func (m *MetricMessage) GetData() isMetricMessage_Data {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *MetricMessage) GetNamespace() []*NamespaceElementMessage {
+	if m != nil {
+		return m.Namespace
+	}
+	return nil
+}
+
+func (m *MetricMessage) GetConfig() *ConfigMessage {
+	if m != nil {
+		return m.Config
+	}
+	return nil
+}
+
+func (m *MetricMessage) GetLastAdvertisedTime() *TimeMessage {
+	if m != nil {
+		return m.LastAdvertisedTime
+	}
+	return nil
+}
+
+func (m *MetricMessage) GetTags() map[string]string {
+	if m != nil {
+		return m.Tags
+	}
+	return nil
+}
+
+func (m *MetricMessage) GetTimestamp() *TimeMessage {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
+func (m *MetricMessage) GetStringData() string {
+	if x, ok := m.GetData().(*MetricMessage_StringData); ok {
+		return x.StringData
+	}
+	return ""
+}
+
+func (m *MetricMessage) GetBytesData() []byte {
+	if x, ok := m.GetData().(*MetricMessage_BytesData); ok {
+		return x.BytesData
+	}
+	return nil
+}
+
+func (m *MetricMessage) GetBoolData() bool {
+	if x, ok := m.GetData().(*MetricMessage_BoolData); ok {
+		return x.BoolData
+	}
+	return false
+}
+
+func (m *MetricMessage) GetFloat32Data() float32 {
+	if x, ok := m.GetData().(*MetricMessage_Float32Data); ok {
+		return x.Float32Data
+	}
+	return 0
+}
+
+func (m *MetricMessage) GetFloat64Data() float64 {
+	if x, ok := m.GetData().(*MetricMessage_Float64Data); ok {
+		return x.Float64Data
+	}
+	return 0
+}
+
+func (m *MetricMessage) GetIntData() int64 {
+	if x, ok := m.GetData().(*MetricMessage_IntData); ok {
+		return x.IntData
+	}
+	return 0
+}
+
+func (m *MetricMessage) GetInt32Data() int32 {
+	if x, ok := m.GetData().(*MetricMessage_Int32Data); ok {
+		return x.Int32Data
+	}
+	return 0
+}
+
+func (m *MetricMessage) GetInt64Data() int64 {
+	if x, ok := m.GetData().(*MetricMessage_Int64Data); ok {
+		return x.Int64Data
+	}
+	return 0
+}
+
+func (m *MetricMessage) GetUintData() uint64 {
+	if x, ok := m.GetData().(*MetricMessage_UintData); ok {
+		return x.UintData
+	}
+	return 0
+}
+
+func (m *MetricMessage) GetUint32Data() uint32 {
+	if x, ok := m.GetData().(*MetricMessage_Uint32Data); ok {
+		return x.Uint32Data
+	}
+	return 0
+}
+
+func (m *MetricMessage) GetUint64Data() uint64 {
+	if x, ok := m.GetData().(*MetricMessage_Uint64Data); ok {
+		return x.Uint64Data
+	}
+	return 0
+}
+
+// XXX_OneofFuncs is for the internal use of the proto package.
+func (*MetricMessage) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), func(msg proto.Message) (n int), []interface{}) {
+	return _MetricMessage_OneofMarshaler, _MetricMessage_OneofUnmarshaler, _MetricMessage_OneofSizer, []interface{}{
+		(*MetricMessage_StringData)(nil),
+		(*MetricMessage_BytesData)(nil),
+		(*MetricMessage_BoolData)(nil),
+		(*MetricMessage_Float32Data)(nil),
+		(*MetricMessage_Float64Data)(nil),
+		(*MetricMessage_IntData)(nil),
+		(*MetricMessage_Int32Data)(nil),
+		(*MetricMessage_Int64Data)(nil),
+		(*MetricMessage_UintData)(nil),
+		(*MetricMessage_Uint32Data)(nil),
+		(*MetricMessage_Uint64Data)(nil),
+	}
+}
+
+func _MetricMessage_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
+	m := msg.(*MetricMessage)
+	// data
+	switch x := m.Data.(type) {
+	case *MetricMessage_StringData:
+		b.EncodeVarint(9<<3 | proto.WireBytes)
+		b.EncodeStringBytes(x.StringData)
+	case *MetricMessage_BytesData:
+		b.EncodeVarint(10<<3 | proto.WireBytes)
+		b.EncodeRawBytes(x.BytesData)
+	case *MetricMessage_BoolData:
+		t := uint64(0)
+		if x.BoolData {
+			t = 1
+		}
+		b.EncodeVarint(11<<3 | proto.WireVarint)
+		b.EncodeVarint(t)
+	case *MetricMessage_Float32Data:
+		b.EncodeVarint(12<<3 | proto.WireFixed32)
+		b.EncodeFixed32(uint64(math.Float32bits(x.Float32Data)))
+	case *MetricMessage_Float64Data:
+		b.EncodeVarint(13<<3 | proto.WireFixed64)
+		b.EncodeFixed64(math.Float64bits(x.Float64Data))
+	case *MetricMessage_IntData:
+		b.EncodeVarint(14<<3 | proto.WireVarint)
+		b.EncodeVarint(uint64(x.IntData))
+	case *MetricMessage_Int32Data:
+		b.EncodeVarint(15<<3 | proto.WireVarint)
+		b.EncodeVarint(uint64(x.Int32Data))
+	case *MetricMessage_Int64Data:
+		b.EncodeVarint(16<<3 | proto.WireVarint)
+		b.EncodeVarint(uint64(x.Int64Data))
+	case *MetricMessage_UintData:
+		b.EncodeVarint(17<<3 | proto.WireVarint)
+		b.EncodeVarint(uint64(x.UintData))
+	case *MetricMessage_Uint32Data:
+		b.EncodeVarint(18<<3 | proto.WireVarint)
+		b.EncodeVarint(uint64(x.Uint32Data))
+	case *MetricMessage_Uint64Data:
+		b.EncodeVarint(19<<3 | proto.WireVarint)
+		b.EncodeVarint(uint64(x.Uint64Data))
+	case nil:
+	default:
+		return fmt.Errorf("MetricMessage.Data has unexpected type %T", x)
+	}
+	return nil
+}
+
+func _MetricMessage_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error) {
+	m := msg.(*MetricMessage)
+	switch tag {
+	case 9: // data.string_data
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeStringBytes()
+		m.Data = &MetricMessage_StringData{x}
+		return true, err
+	case 10: // data.bytes_data
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeRawBytes(true)
+		m.Data = &MetricMessage_BytesData{x}
+		return true, err
+	case 11: // data.bool_data
+		if wire != proto.WireVarint {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeVarint()
+		m.Data = &MetricMessage_BoolData{x != 0}
+		return true, err
+	case 12: // data.float32_data
+		if wire != proto.WireFixed32 {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeFixed32()
+		m.Data = &MetricMessage_Float32Data{math.Float32frombits(uint32(x))}
+		return true, err
+	case 13: // data.float64_data
+		if wire != proto.WireFixed64 {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeFixed64()
+		m.Data = &MetricMessage_Float64Data{math.Float64frombits(x)}
+		return true, err
+	case 14: // data.int_data
+		if wire != proto.WireVarint {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeVarint()
+		m.Data = &MetricMessage_IntData{int64(x)}
+		return true, err
+	case 15: // data.int32_data
+		if wire != proto.WireVarint {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeVarint()
+		m.Data = &MetricMessage_Int32Data{int32(x)}
+		return true, err
+	case 16: // data.int64_data
+		if wire != proto.WireVarint {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeVarint()
+		m.Data = &MetricMessage_Int64Data{int64(x)}
+		return true, err
+	case 17: // data.uint_data
+		if wire != proto.WireVarint {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeVarint()
+		m.Data = &MetricMessage_UintData{x}
+		return true, err
+	case 18: // data.uint32_data
+		if wire != proto.WireVarint {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeVarint()
+		m.Data = &MetricMessage_Uint32Data{uint32(x)}
+		return true, err
+	case 19: // data.uint64_data
+		if wire != proto.WireVarint {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeVarint()
+		m.Data = &MetricMessage_Uint64Data{x}
+		return true, err
+	default:
+		return false, nil
+	}
+}
+
+func _MetricMessage_OneofSizer(msg proto.Message) (n int) {
+	m := msg.(*MetricMessage)
+	// data
+	switch x := m.Data.(type) {
+	case *MetricMessage_StringData:
+		n += proto.SizeVarint(9<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(len(x.StringData)))
+		n += len(x.StringData)
+	case *MetricMessage_BytesData:
+		n += proto.SizeVarint(10<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(len(x.BytesData)))
+		n += len(x.BytesData)
+	case *MetricMessage_BoolData:
+		n += proto.SizeVarint(11<<3 | proto.WireVarint)
+		n += 1
+	case *MetricMessage_Float32Data:
+		n += proto.SizeVarint(12<<3 | proto.WireFixed32)
+		n += 4
+	case *MetricMessage_Float64Data:
+		n += proto.SizeVarint(13<<3 | proto.WireFixed64)
+		n += 8
+	case *MetricMessage_IntData:
+		n += proto.SizeVarint(14<<3 | proto.WireVarint)
+		n += proto.SizeVarint(uint64(x.IntData))
+	case *MetricMessage_Int32Data:
+		n += proto.SizeVarint(15<<3 | proto.WireVarint)
+		n += proto.SizeVarint(uint64(x.Int32Data))
+	case *MetricMessage_Int64Data:
+		n += proto.SizeVarint(16<<3 | proto.WireVarint)
+		n += proto.SizeVarint(uint64(x.Int64Data))
+	case *MetricMessage_UintData:
+		n += proto.SizeVarint(17<<3 | proto.WireVarint)
+		n += proto.SizeVarint(uint64(x.UintData))
+	case *MetricMessage_Uint32Data:
+		n += proto.SizeVarint(18<<3 | proto.WireVarint)
+		n += proto.SizeVarint(uint64(x.Uint32Data))
+	case *MetricMessage_Uint64Data:
+		n += proto.SizeVarint(19<<3 | proto.WireVarint)
+		n += proto.SizeVarint(uint64(x.Uint64Data))
+	case nil:
+	default:
+		panic(fmt.Sprintf("proto: unexpected type %T in oneof", x))
+	}
+	return n
+}
+
+type NamespaceElementMessage struct {
+	Value       string `protobuf:"bytes,1,opt,name=value" json:"value,omitempty"`
+	Description string `protobuf:"bytes,2,opt,name=description" json:"description,omitempty"`
+	Name        string `protobuf:"bytes,3,opt,name=name" json:"name,omitempty"`
+}
+
+func (m *NamespaceElementMessage) Reset()                    { *m = NamespaceElementMessage{} }
+func (m *NamespaceElementMessage) String() string            { return proto.CompactTextString(m) }
+func (*NamespaceElementMessage) ProtoMessage()               {}
+func (*NamespaceElementMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+// TimeMessage is a time with nanosecond precision.  The zero time is not
+// set.
+type TimeMessage struct {
+	Sec  int64 `protobuf:"varint,1,opt,name=sec" json:"sec,omitempty"`
+	Nsec int32 `protobuf:"varint,2,opt,name=nsec" json:"nsec,omitempty"`
+}
+
+func (m *TimeMessage) Reset()                    { *m = TimeMessage{} }
+func (m *TimeMessage) String() string            { return proto.CompactTextString(m) }
+func (*TimeMessage) ProtoMessage()               {}
+func (*TimeMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+type ConfigMessage struct {
+	Values map[string]*ConfigValueMessage `protobuf:"bytes,1,rep,name=values" json:"values,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *ConfigMessage) Reset()                    { *m = ConfigMessage{} }
+func (m *ConfigMessage) String() string            { return proto.CompactTextString(m) }
+func (*ConfigMessage) ProtoMessage()               {}
+func (*ConfigMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *ConfigMessage) GetValues() map[string]*ConfigValueMessage {
+	if m != nil {
+		return m.Values
+	}
+	return nil
+}
+
+// ConfigValueMessage is a ctypes.ConfigValue.  A secure string is sent as
+// its ciphertext.
+type ConfigValueMessage struct {
+	// Types that are valid to be assigned to Value:
+	//	*ConfigValueMessage_IntValue
+	//	*ConfigValueMessage_FloatValue
+	//	*ConfigValueMessage_BoolValue
+	//	*ConfigValueMessage_StringValue
+	//	*ConfigValueMessage_SecureValue
+	Value isConfigValueMessage_Value `protobuf_oneof:"value"`
+}
+
+func (m *ConfigValueMessage) Reset()                    { *m = ConfigValueMessage{} }
+func (m *ConfigValueMessage) String() string            { return proto.CompactTextString(m) }
+func (*ConfigValueMessage) ProtoMessage()               {}
+func (*ConfigValueMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+type isConfigValueMessage_Value interface{ isConfigValueMessage_Value() }
+
+type ConfigValueMessage_IntValue struct {
+	IntValue int64 `protobuf:"varint,1,opt,name=int_value,json=intValue,oneof"`
+}
+type ConfigValueMessage_FloatValue struct {
+	FloatValue float64 `protobuf:"fixed64,2,opt,name=float_value,json=floatValue,oneof"`
+}
+type ConfigValueMessage_BoolValue struct {
+	BoolValue bool `protobuf:"varint,3,opt,name=bool_value,json=boolValue,oneof"`
+}
+type ConfigValueMessage_StringValue struct {
+	StringValue string `protobuf:"bytes,4,opt,name=string_value,json=stringValue,oneof"`
+}
+type ConfigValueMessage_SecureValue struct {
+	SecureValue []byte `protobuf:"bytes,5,opt,name=secure_value,json=secureValue,proto3,oneof"`
+}
+
+func (*ConfigValueMessage_IntValue) isConfigValueMessage_Value()    {}
+func (*ConfigValueMessage_FloatValue) isConfigValueMessage_Value()  {}
+func (*ConfigValueMessage_BoolValue) isConfigValueMessage_Value()   {}
+func (*ConfigValueMessage_StringValue) isConfigValueMessage_Value() {}
+func (*ConfigValueMessage_SecureValue) isConfigValueMessage_Value() {}
+
+func (m *ConfigValueMessage) GetValue() isConfigValueMessage_Value {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *ConfigValueMessage) GetIntValue() int64 {
+	if x, ok := m.GetValue().(*ConfigValueMessage_IntValue); ok {
+		return x.IntValue
+	}
+	return 0
+}
+
+func (m *ConfigValueMessage) GetFloatValue() float64 {
+	if x, ok := m.GetValue().(*ConfigValueMessage_FloatValue); ok {
+		return x.FloatValue
+	}
+	return 0
+}
+
+func (m *ConfigValueMessage) GetBoolValue() bool {
+	if x, ok := m.GetValue().(*ConfigValueMessage_BoolValue); ok {
+		return x.BoolValue
+	}
+	return false
+}
+
+func (m *ConfigValueMessage) GetStringValue() string {
+	if x, ok := m.GetValue().(*ConfigValueMessage_StringValue); ok {
+		return x.StringValue
+	}
+	return ""
+}
+
+func (m *ConfigValueMessage) GetSecureValue() []byte {
+	if x, ok := m.GetValue().(*ConfigValueMessage_SecureValue); ok {
+		return x.SecureValue
+	}
+	return nil
+}
+
+// XXX_OneofFuncs is for the internal use of the proto package.
+func (*ConfigValueMessage) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), func(msg proto.Message) (n int), []interface{}) {
+	return _ConfigValueMessage_OneofMarshaler, _ConfigValueMessage_OneofUnmarshaler, _ConfigValueMessage_OneofSizer, []interface{}{
+		(*ConfigValueMessage_IntValue)(nil),
+		(*ConfigValueMessage_FloatValue)(nil),
+		(*ConfigValueMessage_BoolValue)(nil),
+		(*ConfigValueMessage_StringValue)(nil),
+		(*ConfigValueMessage_SecureValue)(nil),
+	}
+}
+
+func _ConfigValueMessage_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
+	m := msg.(*ConfigValueMessage)
+	// value
+	switch x := m.Value.(type) {
+	case *ConfigValueMessage_IntValue:
+		b.EncodeVarint(1<<3 | proto.WireVarint)
+		b.EncodeVarint(uint64(x.IntValue))
+	case *ConfigValueMessage_FloatValue:
+		b.EncodeVarint(2<<3 | proto.WireFixed64)
+		b.EncodeFixed64(math.Float64bits(x.FloatValue))
+	case *ConfigValueMessage_BoolValue:
+		t := uint64(0)
+		if x.BoolValue {
+			t = 1
+		}
+		b.EncodeVarint(3<<3 | proto.WireVarint)
+		b.EncodeVarint(t)
+	case *ConfigValueMessage_StringValue:
+		b.EncodeVarint(4<<3 | proto.WireBytes)
+		b.EncodeStringBytes(x.StringValue)
+	case *ConfigValueMessage_SecureValue:
+		b.EncodeVarint(5<<3 | proto.WireBytes)
+		b.EncodeRawBytes(x.SecureValue)
+	case nil:
+	default:
+		return fmt.Errorf("ConfigValueMessage.Value has unexpected type %T", x)
+	}
+	return nil
+}
+
+func _ConfigValueMessage_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error) {
+	m := msg.(*ConfigValueMessage)
+	switch tag {
+	case 1: // value.int_value
+		if wire != proto.WireVarint {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeVarint()
+		m.Value = &ConfigValueMessage_IntValue{int64(x)}
+		return true, err
+	case 2: // value.float_value
+		if wire != proto.WireFixed64 {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeFixed64()
+		m.Value = &ConfigValueMessage_FloatValue{math.Float64frombits(x)}
+		return true, err
+	case 3: // value.bool_value
+		if wire != proto.WireVarint {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeVarint()
+		m.Value = &ConfigValueMessage_BoolValue{x != 0}
+		return true, err
+	case 4: // value.string_value
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeStringBytes()
+		m.Value = &ConfigValueMessage_StringValue{x}
+		return true, err
+	case 5: // value.secure_value
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeRawBytes(true)
+		m.Value = &ConfigValueMessage_SecureValue{x}
+		return true, err
+	default:
+		return false, nil
+	}
+}
+
+func _ConfigValueMessage_OneofSizer(msg proto.Message) (n int) {
+	m := msg.(*ConfigValueMessage)
+	// value
+	switch x := m.Value.(type) {
+	case *ConfigValueMessage_IntValue:
+		n += proto.SizeVarint(1<<3 | proto.WireVarint)
+		n += proto.SizeVarint(uint64(x.IntValue))
+	case *ConfigValueMessage_FloatValue:
+		n += proto.SizeVarint(2<<3 | proto.WireFixed64)
+		n += 8
+	case *ConfigValueMessage_BoolValue:
+		n += proto.SizeVarint(3<<3 | proto.WireVarint)
+		n += 1
+	case *ConfigValueMessage_StringValue:
+		n += proto.SizeVarint(4<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(len(x.StringValue)))
+		n += len(x.StringValue)
+	case *ConfigValueMessage_SecureValue:
+		n += proto.SizeVarint(5<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(len(x.SecureValue)))
+		n += len(x.SecureValue)
+	case nil:
+	default:
+		panic(fmt.Sprintf("proto: unexpected type %T in oneof", x))
+	}
+	return n
+}
+
+func init() {
+	proto.RegisterType((*MetricBatch)(nil), "plugin.MetricBatch")
+	proto.RegisterType((*MetricMessage)(nil), "plugin.MetricMessage")
+	proto.RegisterType((*NamespaceElementMessage)(nil), "plugin.NamespaceElementMessage")
+	proto.RegisterType((*TimeMessage)(nil), "plugin.TimeMessage")
+	proto.RegisterType((*ConfigMessage)(nil), "plugin.ConfigMessage")
+	proto.RegisterType((*ConfigValueMessage)(nil), "plugin.ConfigValueMessage")
+}
+
+func init() {
+	proto.RegisterFile("github.com/intelsdi-x/snap/control/plugin/metric.proto", fileDescriptor0)
+}
+
+var fileDescriptor0 = []byte{
+	// 691 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x94, 0xdd, 0x6e, 0xd3, 0x30,
+	0x14, 0xc7, 0xeb, 0x7e, 0xe7, 0xa4, 0x85, 0xe1, 0x0d, 0x11, 0x0d, 0xa1, 0x65, 0xdd, 0x4d, 0x6e,
+	0xd6, 0xc2, 0x3a, 0x8d, 0x0f, 0x09, 0x24, 0x06, 0x93, 0x7a, 0x33, 0x2e, 0xa2, 0xc1, 0x6d, 0xe5,
+	0xa6, 0x5e, 0x67, 0x91, 0x8f, 0x2a, 0x76, 0x26, 0xf6, 0x38, 0xbc, 0x00, 0x0f, 0xc2, 0x53, 0x21,
+	0x1f, 0xc7, 0x4d, 0xca, 0xb6, 0x3b, 0xe7, 0xef, 0xdf, 0x39, 0xc7, 0xf6, 0x39, 0xff, 0xc0, 0xd9,
+	0x4a, 0xa8, 0x9b, 0x62, 0x31, 0x8e, 0xb2, 0x64, 0x22, 0x52, 0xc5, 0x63, 0xb9, 0x14, 0xc7, 0xbf,
+	0x26, 0x32, 0x65, 0xeb, 0x49, 0x94, 0xa5, 0x2a, 0xcf, 0xe2, 0xc9, 0x3a, 0x2e, 0x56, 0x22, 0x9d,
+	0x24, 0x5c, 0xe5, 0x22, 0x1a, 0xaf, 0xf3, 0x4c, 0x65, 0xb4, 0x6b, 0xc4, 0xd1, 0x27, 0x70, 0x2f,
+	0x51, 0x3f, 0x67, 0x2a, 0xba, 0xa1, 0x13, 0xe8, 0x19, 0x4c, 0x7a, 0xc4, 0x6f, 0x05, 0xee, 0xc9,
+	0xf3, 0xb1, 0x01, 0xc7, 0x86, 0xba, 0xe4, 0x52, 0xb2, 0x15, 0x0f, 0x2d, 0x35, 0xfa, 0xd3, 0x85,
+	0xe1, 0xd6, 0x16, 0xfd, 0x08, 0x4e, 0xca, 0x12, 0x2e, 0xd7, 0x2c, 0xe2, 0x65, 0x92, 0x03, 0x9b,
+	0xe4, 0x9b, 0xdd, 0xb8, 0x88, 0x79, 0xc2, 0x53, 0x65, 0xd3, 0x55, 0x11, 0xd4, 0x83, 0xde, 0x2d,
+	0xcf, 0xa5, 0xc8, 0x52, 0xaf, 0xe9, 0x93, 0xa0, 0x15, 0xda, 0x4f, 0x7a, 0x0c, 0xdd, 0x28, 0x4b,
+	0xaf, 0xc5, 0xca, 0x6b, 0xf9, 0xa4, 0x7e, 0xb4, 0x2f, 0xa8, 0xda, 0x5c, 0x25, 0x44, 0x2f, 0x60,
+	0x2f, 0x66, 0x52, 0xcd, 0xd9, 0xf2, 0x96, 0xe7, 0x4a, 0x48, 0xbe, 0x9c, 0x2b, 0x91, 0x70, 0xaf,
+	0x8d, 0xc1, 0xbb, 0x36, 0xf8, 0x4a, 0x24, 0xdc, 0x86, 0x52, 0x1d, 0xf0, 0x79, 0xc3, 0xeb, 0x2d,
+	0x3a, 0x85, 0xb6, 0x62, 0x2b, 0xe9, 0x75, 0xb6, 0x6f, 0xb2, 0x75, 0xe7, 0xf1, 0x15, 0x5b, 0xc9,
+	0x8b, 0x54, 0xe5, 0x77, 0x21, 0xc2, 0xf4, 0x0d, 0x38, 0xba, 0x96, 0x54, 0x2c, 0x59, 0x7b, 0xdd,
+	0xc7, 0x0b, 0x56, 0x14, 0xa5, 0xd0, 0x2e, 0x52, 0xa1, 0xbc, 0x9e, 0x4f, 0x02, 0x27, 0xc4, 0x35,
+	0xf5, 0xc1, 0x5d, 0x72, 0x19, 0xe5, 0x62, 0xad, 0xf4, 0x7b, 0xf4, 0x71, 0xab, 0x2e, 0xd1, 0x43,
+	0x70, 0xa5, 0xca, 0x45, 0xba, 0x9a, 0x2f, 0x99, 0x62, 0x9e, 0xa3, 0x89, 0x59, 0x23, 0x04, 0x23,
+	0x7e, 0x65, 0x8a, 0xd1, 0x03, 0x80, 0xc5, 0x9d, 0xe2, 0xd2, 0x10, 0xe0, 0x93, 0x60, 0x30, 0x6b,
+	0x84, 0x0e, 0x6a, 0x08, 0xbc, 0x02, 0x67, 0x91, 0x65, 0xb1, 0xd9, 0x77, 0x7d, 0x12, 0xf4, 0x67,
+	0x8d, 0xb0, 0xaf, 0x25, 0xdc, 0x3e, 0x82, 0xc1, 0x75, 0x9c, 0x31, 0x35, 0x3d, 0x31, 0xc4, 0xc0,
+	0x27, 0x41, 0x73, 0xd6, 0x08, 0xdd, 0x52, 0xdd, 0x82, 0xce, 0x4e, 0x0d, 0x34, 0xf4, 0x49, 0x40,
+	0x36, 0xd0, 0xd9, 0x29, 0x42, 0x2f, 0xa1, 0x2f, 0x52, 0x65, 0x80, 0x27, 0xba, 0xb7, 0xb3, 0x46,
+	0xd8, 0x13, 0xa9, 0xb2, 0xc7, 0x14, 0xe9, 0xa6, 0xc8, 0x53, 0x9f, 0x04, 0x1d, 0x7d, 0x4c, 0xd4,
+	0x6a, 0x80, 0x2d, 0xb0, 0x53, 0xc6, 0x3b, 0xa8, 0xd9, 0x7b, 0x14, 0x9b, 0xfc, 0xcf, 0x7c, 0x12,
+	0xb4, 0xf5, 0x3d, 0x0a, 0x5b, 0xe0, 0x10, 0xdc, 0xa2, 0x56, 0x81, 0xfa, 0x24, 0x18, 0xea, 0xa7,
+	0x2a, 0xaa, 0x12, 0x25, 0x62, 0x6b, 0xec, 0x96, 0x39, 0xa0, 0xd8, 0x14, 0xd9, 0x7f, 0x0b, 0xce,
+	0xa6, 0xd9, 0x74, 0x07, 0x5a, 0x3f, 0xf9, 0x9d, 0x47, 0xb0, 0x2f, 0x7a, 0x49, 0xf7, 0xa0, 0x73,
+	0xcb, 0xe2, 0x82, 0xe3, 0xec, 0x3a, 0xa1, 0xf9, 0xf8, 0xd0, 0x7c, 0x47, 0xce, 0xbb, 0xd0, 0xd6,
+	0x49, 0x47, 0x1c, 0x5e, 0x3c, 0xe2, 0x82, 0x2a, 0x98, 0xd4, 0x82, 0xff, 0x1f, 0x82, 0xe6, 0xfd,
+	0x21, 0xa0, 0xd0, 0xd6, 0xfe, 0x41, 0x5b, 0x38, 0x21, 0xae, 0x47, 0x53, 0x70, 0x6b, 0x83, 0xa6,
+	0x4f, 0x2a, 0x79, 0x84, 0x89, 0x5b, 0xa1, 0x5e, 0x62, 0x90, 0x96, 0x74, 0xbe, 0x4e, 0x88, 0xeb,
+	0xd1, 0x6f, 0x02, 0xc3, 0x2d, 0x33, 0xd1, 0xf7, 0xd0, 0xc5, 0x53, 0xd8, 0xdf, 0xc1, 0xe1, 0x83,
+	0x9e, 0x1b, 0xff, 0x40, 0xc6, 0x38, 0xa0, 0x0c, 0xd8, 0xff, 0x0e, 0x6e, 0x4d, 0x7e, 0xe0, 0xad,
+	0x5e, 0xd7, 0xdf, 0xca, 0x3d, 0xd9, 0xdf, 0x4e, 0x8d, 0xb1, 0xd6, 0x27, 0xd5, 0x3b, 0x8e, 0xfe,
+	0x12, 0xa0, 0xf7, 0x09, 0xdd, 0x7c, 0xdd, 0xfb, 0xea, 0xfd, 0xf4, 0x70, 0xe8, 0x71, 0x43, 0x4a,
+	0x77, 0x16, 0x27, 0x71, 0x5e, 0x55, 0xd4, 0xe3, 0x09, 0x28, 0x1a, 0x44, 0xfb, 0x44, 0xdb, 0xc0,
+	0x10, 0xad, 0xd2, 0x07, 0x68, 0x0d, 0x03, 0x1c, 0xc1, 0xa0, 0xf4, 0x9a, 0x41, 0xda, 0xa5, 0xd9,
+	0x4a, 0x07, 0x56, 0x10, 0x8f, 0x8a, 0x9c, 0x97, 0x50, 0xa7, 0xf4, 0x9b, 0x6b, 0x54, 0x84, 0xce,
+	0x7b, 0xe5, 0xcd, 0x17, 0x5d, 0xfc, 0x19, 0x4f, 0xff, 0x0d, 0x00, 0xa1, 0x8c, 0xe8, 0x21, 0xc6,
+	0x05, 0x00, 0x00,
+}
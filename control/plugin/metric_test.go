@@ -22,6 +22,7 @@ limitations under the License.
 package plugin
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -245,3 +246,183 @@ func TestMetric(t *testing.T) {
 		So(b, ShouldBeNil)
 	})
 }
+
+func TestMetricTypeJSON(t *testing.T) {
+	Convey("MetricType JSON round trip", t, func() {
+		ts := time.Unix(1460000000, 123).UTC()
+		cfg := cdata.NewNode()
+		cfg.AddItem("user", ctypes.ConfigValueStr{Value: "foo"})
+		m1 := NewMetricType(core.NewNamespace("intel", "mock").AddDynamicElement("host", "host name").AddStaticElement("cpu"), ts, map[string]string{"dc": "us-west"}, "%", "data")
+		m1.Version_ = 2
+		m1.Config_ = cfg
+		m1.Description_ = "cpu utilization"
+		m1.LastAdvertisedTime_ = ts.Add(time.Second)
+		m2 := NewMetricType(core.NewNamespace("intel", "mock", "mem"), ts, nil, "B", "more data")
+		mts := []MetricType{*m1, *m2}
+
+		b, err := json.Marshal(mts)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldContainSubstring, `"namespace"`)
+		So(string(b), ShouldContainSubstring, `"Unit_":"%"`)
+
+		var out []MetricType
+		err = json.Unmarshal(b, &out)
+		So(err, ShouldBeNil)
+		So(out, ShouldResemble, mts)
+		So(out[0].Namespace(), ShouldResemble, m1.Namespace())
+		So(out[0].LastAdvertisedTime(), ShouldResemble, m1.LastAdvertisedTime())
+		So(out[0].Unit(), ShouldEqual, "%")
+		So(out[0].Config().Table()["user"], ShouldResemble, ctypes.ConfigValueStr{Value: "foo"})
+
+		Convey("decodes a payload from an older peer", func() {
+			b := []byte(`[{"namespace":[{"Value":"intel","Description":"","Name":""},{"Value":"mock","Description":"","Name":""}],` +
+				`"last_advertised_time":"0001-01-01T00:00:00Z","version":1,"config":null,"data":3,"tags":null,` +
+				`"Unit_":"B","description":"","timestamp":"2016-04-07T03:33:20Z"}]`)
+			var old []MetricType
+			So(json.Unmarshal(b, &old), ShouldBeNil)
+			So(old, ShouldHaveLength, 1)
+			So(old[0].Namespace().Strings(), ShouldResemble, []string{"intel", "mock"})
+			So(old[0].Version(), ShouldEqual, 1)
+			So(old[0].Unit(), ShouldEqual, "B")
+		})
+	})
+}
+
+func TestMetricTypeTags(t *testing.T) {
+	Convey("MetricType tags", t, func() {
+		Convey("AddTag on a metric without tags", func() {
+			m := NewMetricType(core.NewNamespace("foo", "bar"), time.Now(), nil, "", 1)
+			So(m.Tags(), ShouldBeNil)
+			m.AddTag("host", "hostname")
+			So(m.Tags(), ShouldResemble, map[string]string{"host": "hostname"})
+		})
+		Convey("MergeTags gives precedence to the provided tags", func() {
+			m := NewMetricType(core.NewNamespace("foo", "bar"), time.Now(), map[string]string{"host": "plugin", "dc": "us-west"}, "", 1)
+			m.MergeTags(map[string]string{"host": "task", "device": "sda"})
+			So(m.Tags(), ShouldResemble, map[string]string{"host": "task", "dc": "us-west", "device": "sda"})
+		})
+		Convey("MergeTags with empty and nil maps", func() {
+			m := NewMetricType(core.NewNamespace("foo", "bar"), time.Now(), map[string]string{"dc": "us-west"}, "", 1)
+			m.MergeTags(nil)
+			m.MergeTags(map[string]string{})
+			So(m.Tags(), ShouldResemble, map[string]string{"dc": "us-west"})
+			n := NewMetricType(core.NewNamespace("foo", "bar"), time.Now(), nil, "", 1)
+			n.MergeTags(map[string]string{})
+			So(n.Tags(), ShouldBeNil)
+		})
+		Convey("tags survive serialization", func() {
+			m := NewMetricType(core.NewNamespace("foo", "bar"), time.Now(), nil, "", 1)
+			m.AddTag("host", "hostname")
+			for _, ct := range []string{SnapGOBContentType, SnapJSONContentType} {
+				b, _, err := MarshalMetricTypes(ct, []MetricType{*m})
+				So(err, ShouldBeNil)
+				mts, err := UnmarshallMetricTypes(ct, b)
+				So(err, ShouldBeNil)
+				So(mts[0].Tags(), ShouldResemble, map[string]string{"host": "hostname"})
+			}
+		})
+	})
+}
+
+func TestMetricTypeUnitDescription(t *testing.T) {
+	Convey("MetricType unit and description", t, func() {
+		m := NewMetricType(core.NewNamespace("foo", "bar"), time.Now(), nil, "ms", 1)
+		m.Description_ = "latency"
+		So(m.Unit(), ShouldEqual, "ms")
+		So(m.Description(), ShouldEqual, "latency")
+
+		Convey("are carried in JSON", func() {
+			b, err := json.Marshal(m)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldContainSubstring, `"Unit_":"ms"`)
+			So(string(b), ShouldContainSubstring, `"description":"latency"`)
+			out := &MetricType{}
+			So(json.Unmarshal(b, out), ShouldBeNil)
+			So(out.Unit(), ShouldEqual, "ms")
+			So(out.Description(), ShouldEqual, "latency")
+		})
+		Convey("are omitted from JSON when empty", func() {
+			b, err := json.Marshal(NewMetricType(core.NewNamespace("foo", "bar"), time.Now(), nil, "", 1))
+			So(err, ShouldBeNil)
+			So(string(b), ShouldNotContainSubstring, `"Unit_"`)
+			So(string(b), ShouldNotContainSubstring, `"description"`)
+		})
+	})
+}
+
+func TestMetricTypeData(t *testing.T) {
+	Convey("MetricType data", t, func() {
+		data := []interface{}{int(-42), uint64(18446744073709551615), float64(3.14), "string", []byte("bytes")}
+		mts := make([]MetricType, len(data))
+		for i, d := range data {
+			mts[i] = *NewMetricType(core.NewNamespace("foo", "bar"), time.Now(), nil, "", d)
+		}
+		Convey("keeps its type through snap.gob", func() {
+			b, _, err := MarshalMetricTypes(SnapGOBContentType, mts)
+			So(err, ShouldBeNil)
+			out, err := UnmarshallMetricTypes(SnapGOBContentType, b)
+			So(err, ShouldBeNil)
+			for i, d := range data {
+				So(out[i].Data(), ShouldResemble, d)
+			}
+		})
+		Convey("is decoded as float64 and string through snap.json", func() {
+			b, _, err := MarshalMetricTypes(SnapJSONContentType, mts)
+			So(err, ShouldBeNil)
+			out, err := UnmarshallMetricTypes(SnapJSONContentType, b)
+			So(err, ShouldBeNil)
+			So(out[0].Data(), ShouldEqual, float64(-42))
+			So(out[1].Data(), ShouldHaveSameTypeAs, float64(0))
+			So(out[2].Data(), ShouldEqual, float64(3.14))
+			So(out[3].Data(), ShouldEqual, "string")
+			So(out[4].Data(), ShouldEqual, "Ynl0ZXM=")
+		})
+	})
+}
+
+func TestMetricTypeMatch(t *testing.T) {
+	Convey("MetricType.Match", t, func() {
+		Convey("static namespace", func() {
+			m := NewMetricType(core.NewNamespace("intel", "mock", "foo"), time.Now(), nil, "", nil)
+			So(m.Match([]string{"intel", "mock", "foo"}), ShouldBeTrue)
+			So(m.Match([]string{"intel", "mock", "bar"}), ShouldBeFalse)
+			So(m.Match([]string{"intel", "mock"}), ShouldBeFalse)
+			So(m.Match([]string{"intel", "mock", "foo", "bar"}), ShouldBeFalse)
+		})
+		Convey("dynamic element in the last position", func() {
+			m := NewMetricType(core.NewNamespace("intel", "disk").AddDynamicElement("disk_id", "disk id"), time.Now(), nil, "", nil)
+			So(m.Match([]string{"intel", "disk", "sda"}), ShouldBeTrue)
+			So(m.Match([]string{"intel", "disk", "*"}), ShouldBeTrue)
+			So(m.Match([]string{"intel", "disk", ""}), ShouldBeFalse)
+			So(m.Match([]string{"intel", "cpu", "sda"}), ShouldBeFalse)
+		})
+		Convey("multiple dynamic elements", func() {
+			ns := core.NewNamespace("intel").
+				AddDynamicElement("host", "host name").
+				AddStaticElement("cpu").
+				AddDynamicElement("cpu_id", "cpu id").
+				AddStaticElement("utilization")
+			m := NewMetricType(ns, time.Now(), nil, "", nil)
+			So(m.Match([]string{"intel", "host1", "cpu", "0", "utilization"}), ShouldBeTrue)
+			So(m.Match([]string{"intel", "host2", "cpu", "15", "utilization"}), ShouldBeTrue)
+			So(m.Match([]string{"intel", "host1", "mem", "0", "utilization"}), ShouldBeFalse)
+			So(m.Match([]string{"intel", "host1", "cpu", "0", "idle"}), ShouldBeFalse)
+		})
+	})
+}
+
+func TestMetricTypeMatchPrefix(t *testing.T) {
+	Convey("MetricType.MatchPrefix", t, func() {
+		ns := core.NewNamespace("intel", "psutil").
+			AddDynamicElement("host", "host name").
+			AddStaticElement("cpu")
+		m := NewMetricType(ns, time.Now(), nil, "", nil)
+		So(m.MatchPrefix(nil), ShouldBeTrue)
+		So(m.MatchPrefix([]string{"intel"}), ShouldBeTrue)
+		So(m.MatchPrefix([]string{"intel", "psutil", "host1"}), ShouldBeTrue)
+		So(m.MatchPrefix([]string{"intel", "psutil", "*", "cpu"}), ShouldBeTrue)
+		So(m.MatchPrefix([]string{"intel", "psutil", ""}), ShouldBeFalse)
+		So(m.MatchPrefix([]string{"intel", "mock"}), ShouldBeFalse)
+		So(m.MatchPrefix([]string{"intel", "psutil", "host1", "cpu", "0"}), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,323 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/intelsdi-x/snap/core"
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+// A batch in the snap.msgpack content type is a MessagePack array of
+// metrics.  Each metric is a map keyed like its snap.json encoding, with
+// timestamps in the MessagePack timestamp extension.  Numbers are written in
+// their most compact format, so the Go type of the data of a metric is named
+// by "data_type" and int and int64 are told apart.  A sealed secure string
+// in the config is sent as bin.
+
+// msgpackHandle writes bin, str8 and the timestamp extension of the current
+// MessagePack spec, and reads str as string and maps keyed by string.
+var msgpackHandle = newMsgpackHandle()
+
+func newMsgpackHandle() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{RawToString: true, WriteExt: true}
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	// the timestamp extension is type -1
+	if err := h.AddExt(reflect.TypeOf(time.Time{}), 0xff, encodeMsgpackTime, decodeMsgpackTime); err != nil {
+		panic(err)
+	}
+	return h
+}
+
+type msgpackNamespaceElement struct {
+	Value       string `codec:"value"`
+	Description string `codec:"description"`
+	Name        string `codec:"name"`
+}
+
+type msgpackMetric struct {
+	Namespace          []msgpackNamespaceElement `codec:"namespace"`
+	LastAdvertisedTime time.Time                 `codec:"last_advertised_time"`
+	Version            int                       `codec:"version"`
+	Config             map[string]interface{}    `codec:"config"`
+	Data               interface{}               `codec:"data"`
+	DataType           string                    `codec:"data_type"`
+	Tags               map[string]string         `codec:"tags"`
+	Unit               string                    `codec:"unit"`
+	Description        string                    `codec:"description"`
+	Timestamp          time.Time                 `codec:"timestamp"`
+}
+
+func encodeMsgpackMetrics(metrics []MetricType) ([]byte, error) {
+	out := make([]msgpackMetric, len(metrics))
+	for i, m := range metrics {
+		mm, err := newMsgpackMetric(m)
+		if err != nil {
+			return nil, fmt.Errorf("metric %s: %v", m.Namespace_, err)
+		}
+		out[i] = mm
+	}
+	var b []byte
+	if err := codec.NewEncoderBytes(&b, msgpackHandle).Encode(out); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func decodeMsgpackMetrics(payload []byte) ([]MetricType, error) {
+	var in []msgpackMetric
+	if err := codec.NewDecoderBytes(payload, msgpackHandle).Decode(&in); err != nil {
+		return nil, fmt.Errorf("msgpack: %v", err)
+	}
+	metrics := make([]MetricType, len(in))
+	for i, mm := range in {
+		m, err := mm.metric()
+		if err != nil {
+			return nil, fmt.Errorf("metric %s: %v", m.Namespace_, err)
+		}
+		metrics[i] = m
+	}
+	return metrics, nil
+}
+
+func newMsgpackMetric(m MetricType) (msgpackMetric, error) {
+	mm := msgpackMetric{
+		LastAdvertisedTime: m.LastAdvertisedTime_,
+		Version:            m.Version_,
+		Data:               m.Data_,
+		Tags:               m.Tags_,
+		Unit:               m.Unit_,
+		Description:        m.Description_,
+		Timestamp:          m.Timestamp_,
+	}
+	if mm.Tags == nil {
+		mm.Tags = map[string]string{}
+	}
+	mm.Namespace = make([]msgpackNamespaceElement, len(m.Namespace_))
+	for i, e := range m.Namespace_ {
+		mm.Namespace[i] = msgpackNamespaceElement{Value: e.Value, Description: e.Description, Name: e.Name}
+	}
+	if m.Config_ != nil {
+		mm.Config = map[string]interface{}{}
+		for k, v := range m.Config_.Table() {
+			switch t := v.(type) {
+			case ctypes.ConfigValueInt:
+				mm.Config[k] = t.Value
+			case ctypes.ConfigValueFloat:
+				mm.Config[k] = t.Value
+			case ctypes.ConfigValueBool:
+				mm.Config[k] = t.Value
+			case ctypes.ConfigValueStr:
+				mm.Config[k] = t.Value
+			case ctypes.ConfigValueSecureString:
+				// like its JSON encoding, a secure string is redacted
+				// unless it is sealed
+				if t.Ciphertext == nil {
+					mm.Config[k] = ctypes.Redacted
+				} else {
+					mm.Config[k] = t.Ciphertext
+				}
+			default:
+				return mm, fmt.Errorf("config %s of type %T cannot be encoded as %s", k, v, SnapMsgPackContentType)
+			}
+		}
+	}
+	switch m.Data_.(type) {
+	case nil:
+	case string, []byte, bool, float32, float64,
+		int8, int16, int32, int64, int, uint8, uint16, uint32, uint64, uint:
+		mm.DataType = fmt.Sprintf("%T", m.Data_)
+	default:
+		return mm, fmt.Errorf("data of type %T cannot be encoded as %s", m.Data_, SnapMsgPackContentType)
+	}
+	return mm, nil
+}
+
+func (mm msgpackMetric) metric() (MetricType, error) {
+	m := MetricType{
+		LastAdvertisedTime_: mm.LastAdvertisedTime,
+		Version_:            mm.Version,
+		Tags_:               mm.Tags,
+		Unit_:               mm.Unit,
+		Description_:        mm.Description,
+		Timestamp_:          mm.Timestamp,
+	}
+	if mm.Namespace != nil {
+		m.Namespace_ = make(core.Namespace, len(mm.Namespace))
+		for i, e := range mm.Namespace {
+			m.Namespace_[i] = core.NamespaceElement{Value: e.Value, Description: e.Description, Name: e.Name}
+		}
+	}
+	if mm.Config != nil {
+		m.Config_ = cdata.NewNode()
+		for k, v := range mm.Config {
+			switch t := v.(type) {
+			case int64:
+				m.Config_.AddItem(k, ctypes.ConfigValueInt{Value: int(t)})
+			case uint64:
+				m.Config_.AddItem(k, ctypes.ConfigValueInt{Value: int(t)})
+			case float32:
+				m.Config_.AddItem(k, ctypes.ConfigValueFloat{Value: float64(t)})
+			case float64:
+				m.Config_.AddItem(k, ctypes.ConfigValueFloat{Value: t})
+			case bool:
+				m.Config_.AddItem(k, ctypes.ConfigValueBool{Value: t})
+			case string:
+				m.Config_.AddItem(k, ctypes.ConfigValueStr{Value: t})
+			case []byte:
+				m.Config_.AddItem(k, ctypes.ConfigValueSecureString{Ciphertext: t})
+			default:
+				return m, fmt.Errorf("config %s is a %T", k, v)
+			}
+		}
+	}
+	data, err := msgpackConvert(mm.Data, mm.DataType)
+	if err != nil {
+		return m, err
+	}
+	m.Data_ = data
+	return m, nil
+}
+
+// encodeMsgpackTime writes t in the 96 bit timestamp extension, the zero time
+// as nil.
+func encodeMsgpackTime(rv reflect.Value) ([]byte, error) {
+	t := rv.Interface().(time.Time)
+	if t.IsZero() {
+		return nil, nil
+	}
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint32(b[:4], uint32(t.Nanosecond()))
+	binary.BigEndian.PutUint64(b[4:], uint64(t.Unix()))
+	return b, nil
+}
+
+// decodeMsgpackTime reads the timestamp extension in any of its three
+// formats.
+func decodeMsgpackTime(rv reflect.Value, b []byte) error {
+	var t time.Time
+	switch len(b) {
+	case 4:
+		t = time.Unix(int64(binary.BigEndian.Uint32(b)), 0)
+	case 8:
+		v := binary.BigEndian.Uint64(b)
+		t = time.Unix(int64(v&(1<<34-1)), int64(v>>34))
+	case 12:
+		t = time.Unix(int64(binary.BigEndian.Uint64(b[4:])), int64(binary.BigEndian.Uint32(b[:4])))
+	default:
+		return fmt.Errorf("bad timestamp length %d", len(b))
+	}
+	rv.Set(reflect.ValueOf(t))
+	return nil
+}
+
+// msgpackConvert returns v, as read, in the Go type named by typ.  It fails
+// rather than change the sign or truncate a number.  An empty typ, from an
+// encoder which does not send data_type, leaves v as read.
+func msgpackConvert(v interface{}, typ string) (interface{}, error) {
+	if v == nil || typ == "" {
+		return v, nil
+	}
+	var i int64
+	var u uint64
+	var signed, unsigned bool
+	switch n := v.(type) {
+	case int64:
+		i, signed = n, true
+		u, unsigned = uint64(n), n >= 0
+	case uint64:
+		u, unsigned = n, true
+		i, signed = int64(n), n <= math.MaxInt64
+	}
+	fits := func(ok bool, min, max int64) bool {
+		return ok && i >= min && i <= max
+	}
+	switch typ {
+	case "int":
+		if fits(signed, math.MinInt64, math.MaxInt64) && int64(int(i)) == i {
+			return int(i), nil
+		}
+	case "int8":
+		if fits(signed, math.MinInt8, math.MaxInt8) {
+			return int8(i), nil
+		}
+	case "int16":
+		if fits(signed, math.MinInt16, math.MaxInt16) {
+			return int16(i), nil
+		}
+	case "int32":
+		if fits(signed, math.MinInt32, math.MaxInt32) {
+			return int32(i), nil
+		}
+	case "int64":
+		if signed {
+			return i, nil
+		}
+	case "uint":
+		if unsigned && uint64(uint(u)) == u {
+			return uint(u), nil
+		}
+	case "uint8":
+		if unsigned && u <= math.MaxUint8 {
+			return uint8(u), nil
+		}
+	case "uint16":
+		if unsigned && u <= math.MaxUint16 {
+			return uint16(u), nil
+		}
+	case "uint32":
+		if unsigned && u <= math.MaxUint32 {
+			return uint32(u), nil
+		}
+	case "uint64":
+		if unsigned {
+			return u, nil
+		}
+	case "float32":
+		switch f := v.(type) {
+		case float32:
+			return f, nil
+		case float64:
+			if float64(float32(f)) == f || math.IsNaN(f) {
+				return float32(f), nil
+			}
+		}
+	case "float64":
+		switch f := v.(type) {
+		case float32:
+			return float64(f), nil
+		case float64:
+			return f, nil
+		}
+	default:
+		// string, []uint8 and bool are read as such
+		if fmt.Sprintf("%T", v) == typ {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("data %v does not fit %s", v, typ)
+}
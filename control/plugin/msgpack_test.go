@@ -0,0 +1,162 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/intelsdi-x/snap/core"
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var msgpackTestData = []interface{}{
+	nil, "up", "a string longer than thirty-one bytes", []byte{0, 1, 2}, true, false,
+	float32(-1.5), 2.25, math.MaxFloat64,
+	-1, int8(-128), int16(-300), int32(math.MinInt32), int64(math.MinInt64), math.MaxInt64,
+	uint(7), uint8(255), uint16(65535), uint32(math.MaxUint32), uint64(math.MaxUint64),
+}
+
+func msgpackTestMetrics() []MetricType {
+	ts := time.Date(2016, 9, 1, 12, 30, 15, 123456789, time.UTC)
+	config := cdata.NewNode()
+	config.AddItem("user", ctypes.ConfigValueStr{Value: "admin"})
+	config.AddItem("port", ctypes.ConfigValueInt{Value: -8086})
+	config.AddItem("ratio", ctypes.ConfigValueFloat{Value: 0.5})
+	config.AddItem("debug", ctypes.ConfigValueBool{Value: true})
+	config.AddItem("password", ctypes.ConfigValueSecureString{Ciphertext: []byte("sealed")})
+	ns := core.NewNamespace("intel", "mock").AddDynamicElement("host", "the host").AddStaticElement("value")
+	metrics := make([]MetricType, len(msgpackTestData))
+	for i, data := range msgpackTestData {
+		m := NewMetricType(ns, ts, map[string]string{"host": "node-1"}, "B", data)
+		m.Version_ = 300
+		m.Config_ = config
+		m.Description_ = "a mock metric"
+		metrics[i] = *m
+	}
+	return metrics
+}
+
+func TestMsgpackContentType(t *testing.T) {
+	Convey("Metrics encoded as snap.msgpack", t, func() {
+		metrics := msgpackTestMetrics()
+		b, err := EncodeMetrics(SnapMsgPackContentType, metrics)
+		So(err, ShouldBeNil)
+		out, err := DecodeMetrics(SnapMsgPackContentType, b)
+		So(err, ShouldBeNil)
+		So(len(out), ShouldEqual, len(metrics))
+
+		Convey("keep the Go type and sign of their data", func() {
+			for i := range metrics {
+				So(out[i].Data(), ShouldResemble, metrics[i].Data())
+			}
+		})
+		Convey("keep the rest of the metric", func() {
+			for i, m := range out {
+				So(m.Namespace(), ShouldResemble, metrics[i].Namespace())
+				So(m.Tags(), ShouldResemble, metrics[i].Tags())
+				So(m.Timestamp().Equal(metrics[i].Timestamp()), ShouldBeTrue)
+				So(m.LastAdvertisedTime().Equal(metrics[i].LastAdvertisedTime()), ShouldBeTrue)
+				So(m.Version(), ShouldEqual, 300)
+				So(m.Unit(), ShouldEqual, "B")
+				So(m.Description(), ShouldEqual, "a mock metric")
+				So(m.Config().Table(), ShouldResemble, metrics[i].Config().Table())
+			}
+		})
+		Convey("are smaller than snap.json", func() {
+			jb, err := EncodeMetrics(SnapJSONContentType, metrics)
+			So(err, ShouldBeNil)
+			So(len(b), ShouldBeLessThan, len(jb))
+		})
+	})
+	Convey("A zero time and a missing config survive snap.msgpack", t, func() {
+		m := NewMetricType(core.NewNamespace("a", "b"), time.Time{}, nil, "", 1)
+		b, err := EncodeMetrics(SnapMsgPackContentType, []MetricType{*m})
+		So(err, ShouldBeNil)
+		out, err := DecodeMetrics(SnapMsgPackContentType, b)
+		So(err, ShouldBeNil)
+		So(out[0].Timestamp().IsZero(), ShouldBeTrue)
+		So(out[0].Config(), ShouldBeNil)
+		So(out[0].Tags(), ShouldBeEmpty)
+	})
+	Convey("Data which does not fit its data_type is refused", t, func() {
+		for typ, data := range map[string]interface{}{
+			"int8":   int64(200),
+			"uint32": int64(-1),
+			"uint":   int64(-5),
+			"int64":  uint64(math.MaxUint64),
+			"string": int64(1),
+		} {
+			var b []byte
+			in := []map[string]interface{}{{"data": data, "data_type": typ}}
+			So(codec.NewEncoderBytes(&b, msgpackHandle).Encode(in), ShouldBeNil)
+			_, err := DecodeMetrics(SnapMsgPackContentType, b)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "does not fit "+typ)
+		}
+	})
+	Convey("A metric with data of an unsupported type is not encoded", t, func() {
+		m := NewMetricType(core.NewNamespace("a", "b"), time.Now(), nil, "", []int{1})
+		_, err := EncodeMetrics(SnapMsgPackContentType, []MetricType{*m})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "data of type []int cannot be encoded as snap.msgpack")
+	})
+	Convey("A truncated payload is refused", t, func() {
+		b, err := EncodeMetrics(SnapMsgPackContentType, msgpackTestMetrics())
+		So(err, ShouldBeNil)
+		_, err = DecodeMetrics(SnapMsgPackContentType, b[:len(b)-3])
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func benchmarkEncode(b *testing.B, contentType string) {
+	metrics := msgpackTestMetrics()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := EncodeMetrics(contentType, metrics); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkDecode(b *testing.B, contentType string) {
+	payload, err := EncodeMetrics(contentType, msgpackTestMetrics())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeMetrics(contentType, payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeMsgpack(b *testing.B) { benchmarkEncode(b, SnapMsgPackContentType) }
+func BenchmarkEncodeJSON(b *testing.B)    { benchmarkEncode(b, SnapJSONContentType) }
+func BenchmarkDecodeMsgpack(b *testing.B) { benchmarkDecode(b, SnapMsgPackContentType) }
+func BenchmarkDecodeJSON(b *testing.B)    { benchmarkDecode(b, SnapJSONContentType) }
@@ -0,0 +1,104 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultPanicLimit is how many panics within the PanicWindow end the
+	// session when Arg.PanicLimit is not set.
+	DefaultPanicLimit = 5
+	// DefaultPanicWindow is used when Arg.PanicWindow is not set.
+	DefaultPanicWindow = time.Minute
+)
+
+// PanicError is returned to control in place of a panic in an RPC call to
+// the plugin.
+type PanicError struct {
+	// Method is the RPC method, e.g. "Collector.CollectMetrics"
+	Method string
+	// Value is the value passed to panic
+	Value string
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s panicked: %s", e.Method, e.Value)
+}
+
+// panicTracker keeps the time of the panics within the window.
+type panicTracker struct {
+	mutex sync.Mutex
+	times []time.Time
+}
+
+// add records a panic at t and returns how many panics happened within
+// window before it, t included.
+func (p *panicTracker) add(t time.Time, window time.Duration) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	recent := p.times[:0]
+	for _, pt := range p.times {
+		if t.Sub(pt) < window {
+			recent = append(recent, pt)
+		}
+	}
+	p.times = append(recent, t)
+	return len(p.times)
+}
+
+// recoverPanic must be deferred by each RPC handler, with the handler's
+// named error result.  A panic in the plugin is logged with its stack,
+// counted in the session stats and returned as a PanicError, instead of
+// ending the plugin process.  Once PanicLimit panics happened within
+// PanicWindow the session ends.
+func (s *SessionState) recoverPanic(method string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	trace := make([]byte, 4096)
+	n := runtime.Stack(trace, false)
+	s.Logger().Errorf("Recovered from panic in %s: %v\n%s", method, r, trace[:n])
+	*err = &PanicError{Method: method, Value: fmt.Sprint(r)}
+	s.stats.recordPanic(method)
+
+	if s.PanicLimit < 0 {
+		return
+	}
+	limit, window := s.PanicLimit, s.PanicWindow
+	if limit == 0 {
+		limit = DefaultPanicLimit
+	}
+	if window == 0 {
+		window = DefaultPanicWindow
+	}
+	if count := s.panics.add(time.Now(), window); count >= limit {
+		s.Logger().Errorf("%d panics within %v, ending the session", count, window)
+		go s.shutdown(KillReasonPanic, Shutdown{
+			Reason: fmt.Sprintf("%d panics within %v", count, window),
+			Source: ShutdownSourcePanic,
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// panickingCollector panics on every collection
+type panickingCollector struct {
+	mockPlugin
+}
+
+func (p *panickingCollector) CollectMetrics([]MetricType) ([]MetricType, error) {
+	panic("boom")
+}
+
+func TestRecoverPanic(t *testing.T) {
+	Convey("A plugin panicking in CollectMetrics", t, func() {
+		m := NewPluginMeta("panic", 1, CollectorPluginType, []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+		resp, done := startTestPlugin(m, &panickingCollector{}, fmt.Sprintf(`{"PanicLimit": 2, "PingTimeoutDuration": %d}`, time.Minute))
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer client.Close()
+		collect := func() error {
+			in, err := encoding.NewGobEncoder().Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: resp.Token})
+			So(err, ShouldBeNil)
+			return client.Call("Collector.CollectMetrics", in, &[]byte{})
+		}
+
+		err = collect()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "Collector.CollectMetrics panicked: boom")
+
+		Convey("stays alive and counts the panic", func() {
+			So(callPing(client, resp.Token), ShouldBeNil)
+			in, err := encoding.NewGobEncoder().Encode(StatsArgs{Token: resp.Token})
+			So(err, ShouldBeNil)
+			var out []byte
+			So(client.Call("SessionState.GetStats", in, &out), ShouldBeNil)
+			var st SessionStats
+			So(encoding.NewGobEncoder().Decode(out, &st), ShouldBeNil)
+			So(st.Panics, ShouldEqual, 1)
+			So(st.Methods["Collector.CollectMetrics"].Panics, ShouldEqual, 1)
+			So(st.Methods["Collector.CollectMetrics"].Errors, ShouldEqual, 1)
+			So(callKill(client, resp.Token), ShouldBeNil)
+			<-done
+		})
+		Convey("ends the session after PanicLimit panics", func() {
+			So(collect(), ShouldNotBeNil)
+			select {
+			case rc := <-done:
+				So(rc, ShouldEqual, 0)
+			case <-time.After(5 * time.Second):
+				t.Fatal("the session did not end")
+			}
+		})
+	})
+}
+
+func TestPanicTracker(t *testing.T) {
+	Convey("panicTracker counts the panics within the window", t, func() {
+		var p panicTracker
+		now := time.Now()
+		So(p.add(now, time.Minute), ShouldEqual, 1)
+		So(p.add(now.Add(30*time.Second), time.Minute), ShouldEqual, 2)
+		So(p.add(now.Add(70*time.Second), time.Minute), ShouldEqual, 2)
+		So(p.add(now.Add(3*time.Minute), time.Minute), ShouldEqual, 1)
+	})
+}
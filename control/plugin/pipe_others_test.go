@@ -0,0 +1,44 @@
+// +build legacy,!windows
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNamedPipeUnsupported(t *testing.T) {
+	Convey("Named pipes outside of Windows", t, func() {
+		Convey("can't be listened on", func() {
+			m := NewPluginMeta("mock", 1, CollectorPluginType, []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+			err, rc := Start(m, new(MockPlugin), `{"NoDaemon": true, "PipeName": "snap-plugin-test"}`)
+			So(err, ShouldEqual, ErrPipeUnsupported)
+			So(rc, ShouldEqual, 2)
+		})
+		Convey("can't be dialed", func() {
+			_, err := DialListenAddress(NamedPipeScheme+`\\.\pipe\snap-plugin-test`, time.Second)
+			So(err, ShouldEqual, ErrPipeUnsupported)
+		})
+	})
+}
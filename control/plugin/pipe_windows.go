@@ -0,0 +1,43 @@
+// +build windows
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"net"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// pipeSecurityDescriptor grants access to the pipe to its owner and to
+// the local system only.
+const pipeSecurityDescriptor = "D:P(A;;GA;;;OW)(A;;GA;;;SY)"
+
+func listenPipe(name string) (net.Listener, error) {
+	return winio.ListenPipe(pipePath(name), &winio.PipeConfig{
+		SecurityDescriptor: pipeSecurityDescriptor,
+	})
+}
+
+func dialPipe(path string, timeout time.Duration) (net.Conn, error) {
+	return winio.DialPipe(path, &timeout)
+}
@@ -0,0 +1,73 @@
+// +build legacy,windows
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"net/rpc"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNamedPipeListener(t *testing.T) {
+	Convey("A plugin listening on a named pipe", t, func() {
+		name := fmt.Sprintf("snap-plugin-test-%d", os.Getpid())
+		args := fmt.Sprintf(`{"PipeName": %q, "PingTimeoutDuration": %d}`, name, time.Minute)
+		resp, done := startTestCollector(args)
+		So(resp.State, ShouldEqual, PluginSuccess)
+		So(resp.ListenAddress, ShouldEqual, NamedPipeScheme+`\\.\pipe\`+name)
+
+		conn, err := DialListenAddress(resp.ListenAddress, 5*time.Second)
+		So(err, ShouldBeNil)
+		client := rpc.NewClient(conn)
+		defer client.Close()
+		enc := encoding.NewGobEncoder()
+		call := func(method string, in, out interface{}) {
+			b, err := enc.Encode(in)
+			So(err, ShouldBeNil)
+			var reply []byte
+			So(client.Call(method, b, &reply), ShouldBeNil)
+			if out != nil {
+				So(enc.Decode(reply, out), ShouldBeNil)
+			}
+		}
+
+		So(callPing(client, resp.Token), ShouldBeNil)
+		var mtr GetMetricTypesReply
+		call("Collector.GetMetricTypes", GetMetricTypesArgs{PluginConfig: NewPluginConfigType(), Token: resp.Token}, &mtr)
+		So(len(mtr.MetricTypes), ShouldEqual, 1)
+		var cmr CollectMetricsReply
+		call("Collector.CollectMetrics", CollectMetricsArgs{MetricTypes: mtr.MetricTypes, Token: resp.Token}, &cmr)
+		So(callKill(client, resp.Token), ShouldBeNil)
+		select {
+		case rc := <-done:
+			So(rc, ShouldEqual, 0)
+		case <-time.After(10 * time.Second):
+			t.Fatal("Start did not return after Kill")
+		}
+	})
+}
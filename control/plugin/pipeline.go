@@ -0,0 +1,65 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import "golang.org/x/net/context"
+
+// PipelineInfo locates a processor in the workflow of a task.  It is filled
+// in by control and passed as is to the plugin, which finds it in the
+// context of the call with PipelineInfoFromContext.  The zero value is sent
+// by a control which does not know the pipeline, a Length of 0 meaning the
+// position is unknown.
+type PipelineInfo struct {
+	// Position is the index of the processor in the chain, from 0
+	Position int
+	// Length is the number of processors in the chain
+	Length int
+	// UpstreamName is the name of the plugin whose output is processed
+	UpstreamName string `json:",omitempty"`
+	// UpstreamType is the type of that plugin, "collector" or "processor"
+	UpstreamType string `json:",omitempty"`
+	// TaskID is the ID of the task running the workflow
+	TaskID string `json:",omitempty"`
+}
+
+// First returns whether the processor is first in a known chain, that is
+// processes the raw output of the collectors.
+func (p PipelineInfo) First() bool {
+	return p.Length > 0 && p.Position == 0
+}
+
+// Last returns whether the processor is last in a known chain, its output
+// going to the publishers.
+func (p PipelineInfo) Last() bool {
+	return p.Length > 0 && p.Position == p.Length-1
+}
+
+type pipelineInfoKey struct{}
+
+// PipelineInfoFromContext returns the PipelineInfo of the Process call whose
+// context is ctx, or the zero PipelineInfo when it has none.
+func PipelineInfoFromContext(ctx context.Context) PipelineInfo {
+	info, _ := ctx.Value(pipelineInfoKey{}).(PipelineInfo)
+	return info
+}
+
+func withPipelineInfo(ctx context.Context, info PipelineInfo) context.Context {
+	return context.WithValue(ctx, pipelineInfoKey{}, info)
+}
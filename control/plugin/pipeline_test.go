@@ -0,0 +1,104 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// pipelineProcessor records the PipelineInfo of its last call
+type pipelineProcessor struct {
+	info PipelineInfo
+}
+
+func (p *pipelineProcessor) Process(ctx context.Context, contentType string, content []byte, config map[string]ctypes.ConfigValue) (string, []byte, error) {
+	p.info = PipelineInfoFromContext(ctx)
+	return contentType, content, nil
+}
+
+func (p *pipelineProcessor) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	return cpolicy.New(), nil
+}
+
+// oldProcessorArgs are the ProcessorArgs of a control which does not send a
+// PipelineInfo
+type oldProcessorArgs struct {
+	ContentType string
+	Content     []byte
+	Config      map[string]ctypes.ConfigValue
+	Token       string
+	RequestID   string
+	Deadline    time.Time
+}
+
+func TestPipelineInfo(t *testing.T) {
+	m := NewPluginMeta("pipeline", 1, ProcessorPluginType, []string{SnapAllContentType}, []string{SnapGOBContentType}, Unsecure(true))
+	info := PipelineInfo{Position: 1, Length: 3, UpstreamName: "passthru", UpstreamType: "processor", TaskID: "task-1"}
+	encoders := map[string]encoding.Encoder{
+		"gob":  encoding.NewGobEncoder(),
+		"json": encoding.NewJsonEncoder(),
+	}
+	process := func(p Plugin, enc encoding.Encoder, args interface{}) error {
+		session := &MockSessionState{Encoder: enc, logger: logrus.New(), killChan: make(chan int)}
+		proxy := &processorPluginProxy{Plugin: processorPlugin(p, nil), Session: session, Meta: m}
+		in, err := session.Encode(args)
+		So(err, ShouldBeNil)
+		var out []byte
+		return proxy.Process(in, &out)
+	}
+
+	for name, enc := range encoders {
+		Convey("Over "+name, t, func() {
+			Convey("the PipelineInfo sent by control reaches the processor intact", func() {
+				p := &pipelineProcessor{}
+				So(process(p, enc, ProcessorArgs{ContentType: SnapGOBContentType, Pipeline: info}), ShouldBeNil)
+				So(p.info, ShouldResemble, info)
+				So(p.info.First(), ShouldBeFalse)
+				So(p.info.Last(), ShouldBeFalse)
+			})
+			Convey("the args of an old control give the zero PipelineInfo", func() {
+				p := &pipelineProcessor{}
+				So(process(p, enc, oldProcessorArgs{ContentType: SnapGOBContentType}), ShouldBeNil)
+				So(p.info, ShouldResemble, PipelineInfo{})
+				So(p.info.First(), ShouldBeFalse)
+				So(p.info.Last(), ShouldBeFalse)
+			})
+			Convey("a processor ignoring the PipelineInfo is unaffected", func() {
+				So(process(new(MockProcessor), enc, ProcessorArgs{ContentType: SnapGOBContentType, Pipeline: info}), ShouldBeNil)
+			})
+		})
+	}
+	Convey("The ends of a known chain are found", t, func() {
+		So(PipelineInfo{Position: 0, Length: 2}.First(), ShouldBeTrue)
+		So(PipelineInfo{Position: 1, Length: 2}.Last(), ShouldBeTrue)
+		So(PipelineInfo{Position: 0, Length: 1}.Last(), ShouldBeTrue)
+	})
+}
@@ -23,13 +23,16 @@ package plugin
 import (
 	"bytes"
 	"crypto/rsa"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io" // Don't use "fmt.Print*"
 	"net"
 	"net/http"
 	"net/rpc"
 	"net/rpc/jsonrpc"
+	"os"
 	"regexp"
 	"runtime"
 	"time"
@@ -42,24 +45,86 @@ import (
 // Plugin type
 type PluginType int
 
-// Returns string for matching enum plugin type
+// Returns string for matching enum plugin type, or "unknown(<n>)" for a
+// type this version of snap does not know
 func (p PluginType) String() string {
+	if !p.IsValid() {
+		return fmt.Sprintf("unknown(%d)", int(p))
+	}
 	return types[p]
 }
 
+// IsValid reports whether p is a known plugin type
+func (p PluginType) IsValid() bool {
+	return p >= 0 && int(p) < len(types)
+}
+
+// PluginTypeFromString returns the PluginType named s, one of "collector",
+// "processor", "publisher" or "stream-collector".
+func PluginTypeFromString(s string) (PluginType, error) {
+	for i, name := range types {
+		if s == name {
+			return PluginType(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid plugin type %q", s)
+}
+
+// MarshalJSON encodes the plugin type by name
+func (p PluginType) MarshalJSON() ([]byte, error) {
+	if !p.IsValid() {
+		return nil, fmt.Errorf("invalid plugin type %d", int(p))
+	}
+	return json.Marshal(p.String())
+}
+
+// UnmarshalJSON reads a plugin type by name, or by number as written by
+// plugins which predate named types.
+func (p *PluginType) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		if !PluginType(n).IsValid() {
+			return fmt.Errorf("invalid plugin type %d", n)
+		}
+		*p = PluginType(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	t, err := PluginTypeFromString(s)
+	if err != nil {
+		return err
+	}
+	*p = t
+	return nil
+}
+
 const (
 	CollectorPluginType PluginType = iota
 	ProcessorPluginType
 	PublisherPluginType
+	// StreamCollectorPluginType is a StreamCollector, drained by control
+	// rather than polled
+	StreamCollectorPluginType
 )
 
 type RoutingStrategyType int
 
 // Returns string for matching enum RoutingStrategy type
 func (p RoutingStrategyType) String() string {
+	if !p.IsValid() {
+		return fmt.Sprintf("unknown(%d)", int(p))
+	}
 	return routingStrategyTypes[p]
 }
 
+// IsValid reports whether p is a known routing strategy
+func (p RoutingStrategyType) IsValid() bool {
+	return p >= 0 && int(p) < len(routingStrategyTypes)
+}
+
 const (
 	// DefaultRouting is a least recently used strategy.
 	DefaultRouting RoutingStrategyType = iota
@@ -68,18 +133,94 @@ const (
 	StickyRouting
 	// ConfigRouting is routing to plugins based on the config provided to the plugin.
 	// Using this strategy enables a running database plugin that has the same connection info between
-	// two tasks to be shared.
+	// two tasks to be shared.  PluginMeta.RoutingKeys may restrict the
+	// config compared to some of its keys.
 	ConfigRouting
 )
 
+const (
+	// LRURouting is another name for DefaultRouting
+	LRURouting = DefaultRouting
+	// ConfigBasedRouting is another name for ConfigRouting
+	ConfigBasedRouting = ConfigRouting
+)
+
 // Plugin response states
 type PluginResponseState int
 
+const (
+	// PluginUnknown is a state sent by a newer plugin which this version of
+	// snap does not know.  A plugin may not respond with it.
+	PluginUnknown PluginResponseState = -1
+)
+
 const (
 	PluginSuccess PluginResponseState = iota
 	PluginFailure
+	// PluginLoading means the plugin started but is still warming up
+	PluginLoading
+	// PluginNotReady means the plugin started but can't serve calls yet,
+	// e.g. as a backend it depends on is down
+	PluginNotReady
+	// PluginDisabled means the plugin was disabled by policy
+	PluginDisabled
 )
 
+// responseStates matches PluginResponseState to the names used in a
+// Response
+var responseStates = [...]string{
+	"success",
+	"failure",
+	"loading",
+	"not-ready",
+	"disabled",
+}
+
+// Returns string for matching enum plugin response state
+func (p PluginResponseState) String() string {
+	if !p.valid() {
+		return "unknown"
+	}
+	return responseStates[p]
+}
+
+func (p PluginResponseState) valid() bool {
+	return p >= 0 && int(p) < len(responseStates)
+}
+
+// MarshalJSON encodes the state by name.  It fails for an undefined state.
+func (p PluginResponseState) MarshalJSON() ([]byte, error) {
+	if !p.valid() {
+		return nil, fmt.Errorf("undefined plugin response state %d", int(p))
+	}
+	return json.Marshal(p.String())
+}
+
+// UnmarshalJSON reads a state by name, or by number from a plugin which
+// predates named states.  A state it does not know is read as
+// PluginUnknown.
+func (p *PluginResponseState) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		*p = PluginResponseState(n)
+		if !p.valid() {
+			*p = PluginUnknown
+		}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*p = PluginUnknown
+	for i, name := range responseStates {
+		if s == name {
+			*p = PluginResponseState(i)
+		}
+	}
+	return nil
+}
+
 type RPCType int
 
 const (
@@ -88,11 +229,48 @@ const (
 	GRPC
 )
 
+// rpcTypes maps RPCType to the names used by Arg.Transport
+var rpcTypes = [...]string{
+	"native",
+	"jsonrpc",
+	"grpc",
+}
+
+func (r RPCType) String() string {
+	if r < 0 || int(r) >= len(rpcTypes) {
+		return fmt.Sprintf("RPCType(%d)", int(r))
+	}
+	return rpcTypes[r]
+}
+
+// ParseRPCType returns the RPCType named by s, one of "native", "jsonrpc"
+// or "grpc".
+func ParseRPCType(s string) (RPCType, error) {
+	for i, name := range rpcTypes {
+		if name == s {
+			return RPCType(i), nil
+		}
+	}
+	return 0, ErrUnsupportedRPCType
+}
+
+// Codecs a NativeRPC session may be served with
+const (
+	// GobCodec serves net/rpc with encoding/gob.  This is the default.
+	GobCodec = "gob"
+	// JSONCodec serves net/rpc/jsonrpc so that plugins and clients are not
+	// tied to Go.  Call arguments and replies are JSON encoded.
+	JSONCodec = "json"
+)
+
 var (
 	// Timeout settings
 	// How much time must elapse before a lack of Ping results in a timeout
 	PingTimeoutDurationDefault = time.Millisecond * 1500
 	// How many successive PingTimeouts must occur to equal a failure.
+	//
+	// Deprecated: PingTimeoutLimit is only the default for sessions which
+	// do not set Arg.PingTimeoutLimit.
 	PingTimeoutLimit = 3
 
 	// Array matching plugin type enum to a string
@@ -101,6 +279,7 @@ var (
 		"collector",
 		"processor",
 		"publisher",
+		"stream-collector",
 	}
 
 	routingStrategyTypes = [...]string{
@@ -108,6 +287,19 @@ var (
 		"sticky",
 		"config",
 	}
+
+	// ErrUnsupportedRPCType is returned by Start for an RPC type it can't serve
+	ErrUnsupportedRPCType = errors.New("Unsupported RPC type")
+	// ErrGRPCControlPubKey is returned by Start when a gRPC plugin is given
+	// a ControlPubKey, since neither the handshake nor signed requests are
+	// carried over gRPC
+	ErrGRPCControlPubKey = errors.New("ControlPubKey is not supported with gRPC")
+	// ErrUnsupportedCodec is returned by Start for a codec it can't serve
+	// with the plugin's RPC type
+	ErrUnsupportedCodec = errors.New("Unsupported RPC codec")
+
+	// responseWriter receives the Response written by Start
+	responseWriter io.Writer = os.Stdout
 )
 
 type Plugin interface {
@@ -122,86 +314,333 @@ type PluginMeta struct {
 	RPCType    RPCType
 	RPCVersion int
 	// AcceptedContentTypes are types accepted by this plugin in priority order.
-	// snap.* means any snap type.
+	// snap.* means any snap type.  Defaults to snap.gob.
 	AcceptedContentTypes []string
 	// ReturnedContentTypes are content types returned in priority order.
-	// This is only applicable on processors.
+	// This is only applicable on processors.  Defaults to snap.gob.
 	ReturnedContentTypes []string
 	// ConcurrencyCount is the max number concurrent calls the plugin may take.
 	// If there are 5 tasks using the plugin and concurrency count is 2 there
-	// will be 3 plugins running.
+	// will be 3 plugins running.  Calls beyond it wait for a running call to
+	// return.  0 does not limit the calls, and control runs one task per
+	// plugin.
 	ConcurrencyCount int
 	// Exclusive results in a single instance of the plugin running regardless
-	// the number of tasks using the plugin.
+	// the number of tasks using the plugin, taking one call at a time.
 	Exclusive bool
 	// Unsecure results in unencrypted communication with this plugin.
 	Unsecure bool
 	// CacheTTL will override the default cache TTL for the provided plugin.
+	// The plugin session also serves a metric collected again within
+	// CacheTTL from its cache, without calling CollectMetrics.  0 disables
+	// the session cache.
 	CacheTTL time.Duration
 	// RoutingStrategy will override the routing strategy this plugin requires.
 	// The default routing strategy round-robin.
 	RoutingStrategy RoutingStrategyType
+	// RoutingKeys are the config keys whose values select the instance of
+	// the plugin with ConfigRouting, e.g. the address of the target.  By
+	// default the whole config is compared.
+	RoutingKeys []string
+	// MinFrameworkVersion is the oldest FrameworkVersion, e.g. "0.14.0",
+	// of the control the plugin agrees to run under.  By default any
+	// control.
+	MinFrameworkVersion string `json:",omitempty"`
+
+	// Author, License, URL and Description are free text describing the
+	// plugin to operators, of at most MaxMetaTextLength bytes each.
+	Author      string `json:",omitempty"`
+	License     string `json:",omitempty"`
+	URL         string `json:",omitempty"`
+	Description string `json:",omitempty"`
+}
+
+// MaxMetaTextLength is the maximum length in bytes of the Author, License,
+// URL and Description of a PluginMeta.
+const MaxMetaTextLength = 1024
+
+// ValidateRouting checks that RoutingStrategy is known, and that
+// RoutingKeys are only given, non-empty, with ConfigRouting.
+func (m *PluginMeta) ValidateRouting() error {
+	if !m.RoutingStrategy.IsValid() {
+		return &PluginError{Code: ErrorCodeConfigInvalid, Message: fmt.Sprintf("unknown routing strategy %d", int(m.RoutingStrategy))}
+	}
+	if len(m.RoutingKeys) == 0 {
+		return nil
+	}
+	if m.RoutingStrategy != ConfigRouting {
+		return &PluginError{Code: ErrorCodeConfigInvalid, Message: fmt.Sprintf("routing keys require the config routing strategy, not %s", m.RoutingStrategy)}
+	}
+	for _, k := range m.RoutingKeys {
+		if k == "" {
+			return &PluginError{Code: ErrorCodeConfigInvalid, Message: "empty routing key"}
+		}
+	}
+	return nil
+}
+
+// contentTypePattern matches a well-formed content type, e.g. "snap.gob",
+// "snap.json" or "snap.*".
+var contentTypePattern = regexp.MustCompile(`^[a-z0-9*]+\.[a-z0-9*]+$`)
+
+// pluginNamePattern matches a valid plugin name, e.g. "mock-file"
+var pluginNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// defaultContentTypes defaults empty content types to SnapGOBContentType,
+// for a PluginMeta not made by NewPluginMeta.
+func (m *PluginMeta) defaultContentTypes() {
+	if len(m.AcceptedContentTypes) == 0 {
+		m.AcceptedContentTypes = []string{SnapGOBContentType}
+	}
+	if len(m.ReturnedContentTypes) == 0 {
+		m.ReturnedContentTypes = []string{SnapGOBContentType}
+	}
+}
+
+// Validate checks the PluginMeta before the plugin starts: the Type, a
+// Name made of letters, digits, '.', '_' and '-', a Version of at least 1,
+// well-formed content types, a ConcurrencyCount which is not negative nor
+// above 1 for an Exclusive plugin, a CacheTTL which is not negative, the
+// length of the descriptive fields, the MinFrameworkVersion and the routing
+// strategy.
+func (m *PluginMeta) Validate() error {
+	invalid := func(format string, a ...interface{}) error {
+		return &PluginError{Code: ErrorCodeConfigInvalid, Message: fmt.Sprintf(format, a...)}
+	}
+	if !m.Type.IsValid() {
+		return invalid("invalid plugin type %d", int(m.Type))
+	}
+	if !pluginNamePattern.MatchString(m.Name) {
+		return invalid("invalid plugin name %q", m.Name)
+	}
+	if m.Version < 1 {
+		return invalid("invalid plugin version %d, it must be at least 1", m.Version)
+	}
+	if err := checkContentTypes("accepted", m.AcceptedContentTypes); err != nil {
+		return invalid("%s", err)
+	}
+	if err := checkContentTypes("returned", m.ReturnedContentTypes); err != nil {
+		return invalid("%s", err)
+	}
+	if m.ConcurrencyCount < 0 {
+		return invalid("invalid concurrency count %d", m.ConcurrencyCount)
+	}
+	if m.Exclusive && m.ConcurrencyCount > 1 {
+		return invalid("an exclusive plugin takes one call at a time, not a concurrency count of %d", m.ConcurrencyCount)
+	}
+	if m.CacheTTL < 0 {
+		return invalid("invalid cache TTL %v", m.CacheTTL)
+	}
+	if _, err := parseSemver(m.MinFrameworkVersion); err != nil {
+		return invalid("minimum framework version: %s", err)
+	}
+	for _, f := range []struct{ name, value string }{
+		{"author", m.Author},
+		{"license", m.License},
+		{"URL", m.URL},
+		{"description", m.Description},
+	} {
+		if len(f.value) > MaxMetaTextLength {
+			return invalid("plugin %s is %d bytes long, the limit is %d", f.name, len(f.value), MaxMetaTextLength)
+		}
+	}
+	return m.ValidateRouting()
 }
 
-type metaOp func(m *PluginMeta)
+// callLimit returns how many calls the plugin takes at once, 0 for no limit.
+func (m *PluginMeta) callLimit() int {
+	if m.Exclusive {
+		return 1
+	}
+	if m.ConcurrencyCount > 0 {
+		return m.ConcurrencyCount
+	}
+	return 0
+}
+
+// workers returns the number of calls the session serves at once, or zero
+// when it is not limited.
+func (a *Arg) workers(m *PluginMeta) int {
+	if a.Workers > 0 && !m.Exclusive {
+		return a.Workers
+	}
+	return m.callLimit()
+}
+
+// MetaOpt is an option of NewPluginMeta and NewValidPluginMeta.  It returns
+// an error when given an invalid value.
+type MetaOpt func(m *PluginMeta) error
 
 // ConcurrencyCount is an option that can be be provided to the func NewPluginMeta.
-func ConcurrencyCount(cc int) metaOp {
-	return func(m *PluginMeta) {
+func ConcurrencyCount(cc int) MetaOpt {
+	return func(m *PluginMeta) error {
+		if cc < 0 {
+			return fmt.Errorf("invalid concurrency count %d", cc)
+		}
 		m.ConcurrencyCount = cc
+		return nil
 	}
 }
 
 // Exclusive is an option that can be be provided to the func NewPluginMeta.
-func Exclusive(e bool) metaOp {
-	return func(m *PluginMeta) {
+func Exclusive(e bool) MetaOpt {
+	return func(m *PluginMeta) error {
 		m.Exclusive = e
+		return nil
 	}
 }
 
 // Unsecure is an option that can be be provided to the func NewPluginMeta.
-func Unsecure(e bool) metaOp {
-	return func(m *PluginMeta) {
+func Unsecure(e bool) MetaOpt {
+	return func(m *PluginMeta) error {
 		m.Unsecure = e
+		return nil
 	}
 }
 
 // RoutingStrategy is an option that can be be provided to the func NewPluginMeta.
-func RoutingStrategy(r RoutingStrategyType) metaOp {
-	return func(m *PluginMeta) {
+func RoutingStrategy(r RoutingStrategyType) MetaOpt {
+	return func(m *PluginMeta) error {
+		if !r.IsValid() {
+			return fmt.Errorf("unknown routing strategy %d", int(r))
+		}
 		m.RoutingStrategy = r
+		return nil
+	}
+}
+
+// RoutingKeys is an option that can be be provided to the func NewPluginMeta.
+func RoutingKeys(keys ...string) MetaOpt {
+	return func(m *PluginMeta) error {
+		for _, k := range keys {
+			if k == "" {
+				return errors.New("empty routing key")
+			}
+		}
+		m.RoutingKeys = keys
+		return nil
+	}
+}
+
+// AcceptedContentTypes is an option that can be be provided to the func
+// NewValidPluginMeta.
+func AcceptedContentTypes(types ...string) MetaOpt {
+	return func(m *PluginMeta) error {
+		if err := checkContentTypes("accepted", types); err != nil {
+			return err
+		}
+		m.AcceptedContentTypes = types
+		return nil
+	}
+}
+
+// ReturnedContentTypes is an option that can be be provided to the func
+// NewValidPluginMeta.
+func ReturnedContentTypes(types ...string) MetaOpt {
+	return func(m *PluginMeta) error {
+		if err := checkContentTypes("returned", types); err != nil {
+			return err
+		}
+		m.ReturnedContentTypes = types
+		return nil
+	}
+}
+
+func checkContentTypes(kind string, types []string) error {
+	for _, s := range types {
+		if !contentTypePattern.MatchString(s) {
+			return fmt.Errorf("bad %s content type %q", kind, s)
+		}
+	}
+	return nil
+}
+
+// textOpt returns an option setting one of the descriptive fields
+func textOpt(name, value string, set func(m *PluginMeta)) MetaOpt {
+	return func(m *PluginMeta) error {
+		if len(value) > MaxMetaTextLength {
+			return fmt.Errorf("plugin %s is %d bytes long, the limit is %d", name, len(value), MaxMetaTextLength)
+		}
+		set(m)
+		return nil
+	}
+}
+
+// Author is an option that can be be provided to the func NewPluginMeta.
+func Author(a string) MetaOpt {
+	return textOpt("author", a, func(m *PluginMeta) { m.Author = a })
+}
+
+// License is an option that can be be provided to the func NewPluginMeta.
+func License(l string) MetaOpt {
+	return textOpt("license", l, func(m *PluginMeta) { m.License = l })
+}
+
+// URL is an option that can be be provided to the func NewPluginMeta.
+func URL(u string) MetaOpt {
+	return textOpt("URL", u, func(m *PluginMeta) { m.URL = u })
+}
+
+// Description is an option that can be be provided to the func NewPluginMeta.
+func Description(d string) MetaOpt {
+	return textOpt("description", d, func(m *PluginMeta) { m.Description = d })
+}
+
+// MinFrameworkVersion is an option that can be be provided to the func NewPluginMeta.
+func MinFrameworkVersion(v string) MetaOpt {
+	return func(m *PluginMeta) error {
+		if _, err := parseSemver(v); err != nil {
+			return err
+		}
+		m.MinFrameworkVersion = v
+		return nil
 	}
 }
 
 // CacheTTL is an option that can be be provided to the func NewPluginMeta.
-func CacheTTL(t time.Duration) metaOp {
-	return func(m *PluginMeta) {
+func CacheTTL(t time.Duration) MetaOpt {
+	return func(m *PluginMeta) error {
+		if t < 0 {
+			return fmt.Errorf("invalid cache TTL %v", t)
+		}
 		m.CacheTTL = t
+		return nil
 	}
 }
 
-// NewPluginMeta constructs and returns a PluginMeta struct
-func NewPluginMeta(name string, version int, pluginType PluginType, acceptContentTypes, returnContentTypes []string, opts ...metaOp) *PluginMeta {
-	// An empty accepted content type default to "snap.*"
-	if len(acceptContentTypes) == 0 {
-		acceptContentTypes = append(acceptContentTypes, "snap.*")
+// NewValidPluginMeta constructs a PluginMeta from its options, and returns
+// the first error of an option or of Validate.  Content types default to
+// snap.gob.
+func NewValidPluginMeta(name string, version int, pluginType PluginType, opts ...MetaOpt) (*PluginMeta, error) {
+	p := &PluginMeta{
+		Name:    name,
+		Version: version,
+		Type:    pluginType,
 	}
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return nil, &PluginError{Code: ErrorCodeConfigInvalid, Message: err.Error()}
+		}
+	}
+	p.defaultContentTypes()
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// NewPluginMeta constructs and returns a PluginMeta struct.  It panics when
+// given an invalid content type or option, and leaves the other checks of
+// Validate to Start.
+func NewPluginMeta(name string, version int, pluginType PluginType, acceptContentTypes, returnContentTypes []string, opts ...MetaOpt) *PluginMeta {
 	// Validate content type formats
 	for _, s := range acceptContentTypes {
-		b, e := regexp.MatchString(`^[a-z0-9*]+\.[a-z0-9*]+$`, s)
-		if e != nil {
-			panic(e)
-		}
-		if !b {
+		if !contentTypePattern.MatchString(s) {
 			panic(fmt.Sprintf("Bad accept content type [%s] for [%d] [%s]", name, version, s))
 		}
 	}
 	for _, s := range returnContentTypes {
-		b, e := regexp.MatchString(`^[a-z0-9*]+\.[a-z0-9*]+$`, s)
-		if e != nil {
-			panic(e)
-		}
-		if !b {
+		if !contentTypePattern.MatchString(s) {
 			panic(fmt.Sprintf("Bad return content type [%s] for [%d] [%s]", name, version, s))
 		}
 	}
@@ -212,34 +651,237 @@ func NewPluginMeta(name string, version int, pluginType PluginType, acceptConten
 		Type:                 pluginType,
 		AcceptedContentTypes: acceptContentTypes,
 		ReturnedContentTypes: returnContentTypes,
-
-		//set the default for concurrency count to 1
-		ConcurrencyCount: 1,
 	}
 
 	for _, opt := range opts {
-		opt(p)
+		if err := opt(p); err != nil {
+			panic(fmt.Sprintf("Bad option for [%s] [%d]: %v", name, version, err))
+		}
 	}
+	// Empty content types default to the native gob encoding
+	p.defaultContentTypes()
 
 	return p
 }
 
 // Arguments passed to startup of Plugin
 type Arg struct {
-	// Plugin log level
+	// LogLevel filters the messages of the session logger.  It is given
+	// by name, "debug", "info", "warn" or "error", or as a logrus level
+	// number.  An unknown name falls back to info.
 	LogLevel log.Level
+	// LogFormat is LogFormatText, the default, or LogFormatJSON.  An
+	// unknown format falls back to text.
+	LogFormat string
+	// PluginLogPath is the file the session logs to.  Empty or
+	// LogPathStderr logs to stderr.  A plugin which cannot open the file
+	// fails to start with ErrorCodeLogFailed.
+	PluginLogPath string
+	// LogMaxSizeMB is the size in megabytes past which the PluginLogPath
+	// file is renamed to a timestamped backup and a new file started.  0
+	// never rotates it.
+	LogMaxSizeMB int
+	// LogMaxBackups is the number of backups kept, the oldest being
+	// removed.  Defaults to DefaultLogMaxBackups.
+	LogMaxBackups int
+	// RPCVersion is the RPC protocol version spoken by control.  A plugin
+	// refuses to start when it is older than MinRPCVersion.
+	RPCVersion int
+	// FrameworkVersion is the version of the plugin framework run by
+	// control.  A plugin refuses to start when it is older than its
+	// MinFrameworkVersion.
+	FrameworkVersion string
+	// ContentTypes are the content types of the metrics control sends and
+	// receives, e.g. snap.gob.  A processor or publisher refuses to start
+	// when it supports none of them.  Defaults to DefaultContentTypes.
+	ContentTypes []string
+	// ContentEncodings are the encodings control decompresses, see
+	// ContentEncodings.  Payloads of replies are only compressed with one
+	// of them.
+	ContentEncodings []string
+	// CompressThreshold is the size in bytes above which the payloads of
+	// replies are compressed.  0 is DefaultCompressThreshold and a negative
+	// value disables compression.
+	CompressThreshold int
+	// ChunkSize is the size in bytes above which a reply is replaced by a
+	// Transfer and fetched in chunks of that size.  0, from a control
+	// which does not fetch chunks, disables chunking.
+	ChunkSize int
+	// ChunkTimeout is how long a chunked reply waits for its next chunk to
+	// be fetched.  Defaults to DefaultChunkTimeout.
+	ChunkTimeout time.Duration
+	// StreamBufferSize is the number of metrics a stream collector buffers
+	// between two drains before it drops the oldest.  Defaults to
+	// DefaultStreamBufferSize.
+	StreamBufferSize int
+	// PushAddress is the address at which control serves PushMethod.  When
+	// set, a PushCollector may push metrics to control.
+	PushAddress string
+	// PushQueueSize is the number of batches waiting to be pushed before
+	// the oldest is dropped.  Defaults to DefaultPushQueueSize.
+	PushQueueSize int
+	// PushRetryInterval is the first delay before a failed push is retried.
+	// Defaults to DefaultPushRetryInterval.
+	PushRetryInterval time.Duration
+	// InitConfig is passed to the Init of a plugin implementing
+	// Initializer
+	InitConfig map[string]interface{}
+	// InitRetries is how many times a failed Init is retried before the
+	// session ends.  Defaults to DefaultInitRetries; a negative value
+	// disables retries.
+	InitRetries int
+	// InitRetryInterval defaults to DefaultInitRetryInterval
+	InitRetryInterval time.Duration
 	// Ping timeout duration
 	PingTimeoutDuration time.Duration
+	// PingTimeoutLimit is how many successive ping timeouts end the
+	// session.  Defaults to the package PingTimeoutLimit.
+	PingTimeoutLimit int
+	// KillDrainTimeout is how long Kill waits for calls in flight before
+	// the plugin exits.  Defaults to DefaultKillDrainTimeout.
+	KillDrainTimeout time.Duration
+	// KillDelay is how long a killed plugin waits, once drained, before it
+	// exits.  Defaults to DefaultKillDelay.
+	KillDelay time.Duration
+	// OnKillTimeout bounds the time a KillHandler's OnKill may take.
+	// Defaults to DefaultOnKillTimeout.
+	OnKillTimeout time.Duration
+	// CloseTimeout bounds the time a Closer's Close may take.  Defaults to
+	// DefaultCloseTimeout.
+	CloseTimeout time.Duration
+	// PanicLimit is how many panics recovered within PanicWindow end the
+	// session.  Defaults to DefaultPanicLimit; a negative limit never ends
+	// it.
+	PanicLimit int
+	// PanicWindow defaults to DefaultPanicWindow
+	PanicWindow time.Duration
+	// Workers overrides the ConcurrencyCount of the plugin's meta, the
+	// number of calls served at once.  It does not apply to an Exclusive
+	// plugin.
+	Workers int
+	// CallQueueLength is how many calls may wait for a worker once all are
+	// busy, beyond which calls fail with ErrBusy.  Defaults to
+	// DefaultCallQueueLength; a negative length does not bound the queue.
+	CallQueueLength int
+	// SlowCallThreshold is the duration over which a collect, catalog,
+	// publish or process call is logged as slow and counted in the stats,
+	// not counting the time it waited for a concurrency slot.  Defaults to
+	// DefaultSlowCallThreshold; a negative threshold disables it.
+	SlowCallThreshold time.Duration
+	// AckRetention is how long a publisher's deferred batches are tracked,
+	// see DeferredPublisher.  Defaults to DefaultAckRetention.
+	AckRetention time.Duration
+	// SpoolDir is the directory where a publisher spools the payloads it
+	// failed to publish with a retryable error, to replay them once its
+	// sink is back.  Spooling is off when it is empty.
+	SpoolDir string
+	// SpoolSegmentSize caps the size of the spool files.  Defaults to
+	// DefaultSpoolSegmentSize.
+	SpoolSegmentSize int64
+	// SpoolRetryInterval is the wait between two attempts to replay the
+	// spool.  Defaults to DefaultSpoolRetryInterval.
+	SpoolRetryInterval time.Duration
+	// ReplayFile is a recording, in JSON lines of metrics, which a
+	// collector session answers CollectMetrics from instead of calling the
+	// plugin, one collection per call.  ReplayLoop starts the recording
+	// over once it is exhausted, and ReplayShiftTime moves the timestamps
+	// of each collection replayed so that the earliest is now.
+	ReplayFile      string
+	ReplayLoop      bool
+	ReplayShiftTime bool
+	// RecordFile is a recording which a collector session appends the
+	// metrics collected by the plugin to, for a later ReplayFile.
+	RecordFile string
+	// RPCRecordFile is a file which the session appends each call it
+	// serves to, as a RecordedCall, for ReplayRPC.  Calls served over gRPC
+	// are not recorded.  The file is rotated like the PluginLogPath once
+	// it grows past RPCRecordMaxSizeMB, which defaults to
+	// DefaultRPCRecordMaxSizeMB, keeping RPCRecordMaxBackups backups,
+	// which defaults to DefaultLogMaxBackups.
+	RPCRecordFile       string
+	RPCRecordMaxSizeMB  int
+	RPCRecordMaxBackups int
+	// SelfTestTimeout bounds the SelfTest calls which do not set their own
+	// timeout.  Defaults to DefaultSelfTestTimeout.
+	SelfTestTimeout time.Duration
+	// EnableProfiling serves the pprof endpoints under /debug/pprof/ on
+	// ProfilingAddr and ProfilingPort until the session ends.  The port is
+	// returned in Response.ProfilingPort; an empty ProfilingPort lets the
+	// OS select one.  ProfilingAddr defaults to DefaultProfilingAddr and
+	// must be a loopback address unless ProfilingAllowRemote is set.
+	EnableProfiling      bool
+	ProfilingAddr        string
+	ProfilingPort        string
+	ProfilingAllowRemote bool
 
 	NoDaemon bool
-	// The listen port
-	listenPort string
+	// NoTokenCheck disables session token validation on RPC calls.  It is
+	// meant for debugging a plugin by hand and must not be used otherwise.
+	NoTokenCheck bool
+	// TokenLength is the number of random bytes in the session token.
+	// Defaults to DefaultTokenLength and may not be less than MinTokenLength.
+	TokenLength int
+	// ListenAddr is the IP or hostname to bind to.  Defaults to
+	// DefaultListenAddr.
+	ListenAddr string
+	// The listen port.  If empty the OS selects a free port.
+	ListenPort string
+	// ListenPortRange confines the listen port to a range such as
+	// "40000-40100" when ListenPort is empty.  The first free port in the
+	// range is used.
+	ListenPortRange string
+	// ListenSocket is the path of a unix socket to listen on instead of a
+	// TCP port.  A socket file left behind by a previous instance is
+	// replaced and the socket is removed when the plugin is killed.
+	ListenSocket string
+	// ListenSocketMode is the permission of the ListenSocket.  Defaults to
+	// DefaultListenSocketMode.
+	ListenSocketMode os.FileMode
+	// PipeName is the name of a Windows named pipe to listen on instead of
+	// a TCP port, e.g. "snap-collector-mock" for \\.\pipe\snap-collector-mock.
+	// Named pipes are only supported on Windows.
+	PipeName string
+	// AdvertiseAddress is returned in Response.ListenAddress in place of the
+	// address the plugin is bound to, e.g. when control reaches the plugin
+	// through NAT or a proxy.  It must be in host:port form.
+	AdvertiseAddress string
+	// CertPath and KeyPath enable TLS on the RPC listener when both are set.
+	// snapd does not set them; TLS serves clients which dial with
+	// ClientTLSConfig, e.g. client.NewCollectorNativeTLSClient.
+	CertPath string
+	KeyPath  string
+	// CAPath requires clients to present a certificate signed by this CA
+	CAPath string
+	// ControlPubKey, when set, requires each connection to start with a
+	// HandshakeRequest signed by the matching private key, and destructive
+	// calls such as Kill to carry a RequestSignature.  The session key
+	// sealing secure config values is then taken from the handshake, see
+	// ClientKeyHandshake, and SetKey is refused.
+	ControlPubKey *rsa.PublicKey
+	// HandshakeWindow is the maximum age of a handshake.  Defaults to
+	// DefaultHandshakeWindow.
+	HandshakeWindow time.Duration
+	// Transport overrides the RPCType in the plugin's meta for this
+	// session.  It is one of "native", "jsonrpc" or "grpc".  The RPCType
+	// being served is returned in Response.Meta.
+	Transport string
+	// Codec selects the wire format of a NativeRPC session, GobCodec or
+	// JSONCodec.  Defaults to GobCodec.  JSONRPC sessions always use JSON.
+	Codec string
+
+	// logLevelErr tells why LogLevel fell back to info
+	logLevelErr error
 }
 
 func NewArg(logLevel int) Arg {
 	return Arg{
 		LogLevel:            log.Level(logLevel),
 		PingTimeoutDuration: PingTimeoutDurationDefault,
+		RPCVersion:          RPCVersion,
+		FrameworkVersion:    FrameworkVersion,
+		ContentTypes:        []string{SnapGOBContentType, SnapJSONContentType, SnapProtoBufContentType, SnapMsgPackContentType},
+		ContentEncodings:    ContentEncodings,
+		ChunkSize:           DefaultChunkSize,
 	}
 }
 
@@ -253,34 +895,313 @@ type Response struct {
 	// its own loading requirements
 	State        PluginResponseState
 	ErrorMessage string
-	PublicKey    *rsa.PublicKey
+	// ErrorCode classifies the error of a failed Response
+	ErrorCode ErrorCode
+	// ErrorFields may hold details of the error, e.g. the address which
+	// could not be bound
+	ErrorFields map[string]string
+	PublicKey   *rsa.PublicKey
+	// Codec is the wire format served at ListenAddress
+	Codec string
+	// RPCVersion is the RPC protocol version spoken by the plugin, so that
+	// control does not call methods it does not serve
+	RPCVersion int
+	// Capabilities lists the optional features of the plugin, see
+	// HasCapability
+	Capabilities []string `json:",omitempty"`
+	// ContentType is the content type of the metrics sent to a processor
+	// or publisher, and ReturnedContentType that of the metrics returned
+	// by a processor, as negotiated from Arg.ContentTypes
+	ContentType         string `json:",omitempty"`
+	ReturnedContentType string `json:",omitempty"`
+	// ContentEncodings are the encodings the plugin decompresses.  Control
+	// may compress the payloads of args with one of them.
+	ContentEncodings []string `json:",omitempty"`
+	// Init is InitPending when the plugin implements Initializer.  The
+	// plugin is ready once PingStatus reports InitComplete.
+	Init InitState
+	// ProfilingPort is the port serving the pprof endpoints, see
+	// Arg.EnableProfiling
+	ProfilingPort int `json:",omitempty"`
+
+	// The process serving the plugin, for information only
+	PID       int
+	StartedAt time.Time
+	GoVersion string
+	OS        string
+	Arch      string
 }
 
 // Start starts a plugin where:
 // PluginMeta - base information about plugin
-// Plugin - CollectorPlugin, ProcessorPlugin or PublisherPlugin
+// Plugin - CollectorPlugin, ProcessorPlugin, PublisherPlugin or StreamCollector
 // requestString - plugins arguments (marshaled json of control/plugin Arg struct)
 // returns an error and exitCode (exitCode from SessionState initilization or plugin termination code)
+//
+// A plugin run by hand, with an empty requestString or a flag, serves its
+// command line instead, see RunCommand.
 func Start(m *PluginMeta, c Plugin, requestString string) (error, int) {
+	if isCommand(requestString) {
+		var in io.Reader
+		// a sample payload is only read when stdin is not a terminal
+		if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice == 0 {
+			in = os.Stdin
+		}
+		switch code := RunCommand(m, c, commandArgs(requestString), in, os.Stdout, os.Stderr); code {
+		case 0:
+			return nil, 0
+		case exitUsage:
+			return ErrUsage, code
+		default:
+			return ErrDiagnosticFailed, code
+		}
+	}
+	return Serve(responseWriter, m, c, requestString)
+}
+
+// Serve is Start without the command line: it writes the Response to w
+// rather than stdout, so a harness can run the session in-process the way
+// control runs it in a child process.
+func Serve(w io.Writer, m *PluginMeta, c Plugin, requestString string) (error, int) {
 	s, sErr, retCode := NewSessionState(requestString, c, m)
 	if sErr != nil {
+		code := ErrorCodeConfigInvalid
+		if e, ok := sErr.(*PluginError); ok {
+			code = e.Code
+		} else if sErr == ErrUnsupportedRPCType || sErr == ErrUnsupportedCodec {
+			code = ErrorCodeUnsupported
+		}
+		resp := NewErrorResponse(code, sErr)
+		if e, ok := sErr.(*logFileError); ok {
+			resp.ErrorCode = ErrorCodeLogFailed
+			resp.ErrorFields = e.fields()
+		}
+		writeErrorResponse(w, m, resp)
 		return sErr, retCode
 	}
 
+	var exitCode int = 0
+
+	server, r, e := newServer(s, m, c)
+	if e != nil {
+		s.Logger().Errorf("%v", e)
+		writeErrorResponse(w, m, NewErrorResponse(ErrorCodeInternal, e))
+		return e, 2
+	}
+
+	tlsConfig, err := ServerTLSConfig(s.Arg)
+	if err != nil {
+		s.Logger().Errorf("%v", err)
+		writeErrorResponse(w, m, NewErrorResponse(ErrorCodeConfigInvalid, err))
+		return err, 2
+	}
+	if r.Meta.RPCType == GRPC && s.ControlPubKey != nil {
+		s.Logger().Errorf("%v", ErrGRPCControlPubKey)
+		writeErrorResponse(w, m, NewErrorResponse(ErrorCodeUnsupported, ErrGRPCControlPubKey))
+		return ErrGRPCControlPubKey, 2
+	}
+
 	var (
-		r        *Response
-		exitCode int = 0
+		l    net.Listener
+		addr string
 	)
+	if s.PipeName != "" {
+		addr = s.PipeName
+		l, err = listenPipe(s.PipeName)
+	} else if s.ListenSocket != "" {
+		addr = s.ListenSocket
+		l, err = listenUnix(s.ListenSocket, s.ListenSocketMode)
+	} else if s.portRange != nil {
+		addr = net.JoinHostPort(s.ListenAddr, s.ListenPortRange)
+		l, err = listenPortRange(s.ListenAddr, s.portRange)
+	} else {
+		addr = net.JoinHostPort(s.ListenAddr, s.ListenPort())
+		l, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		s.Logger().Errorf("%v", err)
+		code := ErrorCodeBindFailed
+		if err == ErrPipeUnsupported {
+			code = ErrorCodeUnsupported
+		}
+		resp := NewErrorResponse(code, err)
+		resp.ErrorFields = map[string]string{"address": addr}
+		writeErrorResponse(w, m, resp)
+		return err, 2
+	}
+	// gRPC negotiates TLS with its own credentials
+	if tlsConfig != nil && r.Meta.RPCType != GRPC {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	if s.ControlPubKey != nil {
+		l = &handshakeListener{
+			Listener: l,
+			verifier: newHandshakeVerifier(s.ControlPubKey, s.Token(), s.HandshakeWindow),
+			logger:   s.Logger(),
+			setKey:   s.handshakeKey,
+		}
+	}
+	stopSignals := func() {}
+	if s.PipeName != "" {
+		s.SetListenAddress(NamedPipeScheme + pipePath(s.PipeName))
+	} else if s.ListenSocket != "" {
+		s.SetListenAddress(UnixSocketScheme + s.ListenSocket)
+		if !s.isDaemon() {
+			// A daemon removes the socket as it shuts down on a signal
+			stopSignals = removeSocketOnSignal(s.ListenSocket)
+		}
+	} else {
+		s.SetListenAddress(l.Addr().String())
+	}
+	s.Logger().Debugf("Listening %s", l.Addr())
+	s.Logger().Debugf("Session token %s", s.Token())
+
+	stop := func() { l.Close() }
+	switch r.Meta.RPCType {
+	case JSONRPC:
+		mux := http.NewServeMux()
+		mux.Handle(rpc.DefaultRPCPath, server)
+		mux.HandleFunc("/rpc", func(w http.ResponseWriter, req *http.Request) {
+			defer req.Body.Close()
+			w.Header().Set("Content-Type", "application/json")
+			if req.ContentLength == 0 {
+				encoder := json.NewEncoder(w)
+				encoder.Encode(&struct {
+					Id     interface{} `json:"id"`
+					Result interface{} `json:"result"`
+					Error  interface{} `json:"error"`
+				}{
+					Id:     nil,
+					Result: nil,
+					Error:  "rpc: method request ill-formed",
+				})
+				return
+			}
+			rr := newRPCRequest(req.Body, server)
+			rr.stats = &s.stats
+			rr.recorder = s.recorder
+			res := rr.Call()
+			io.Copy(w, res)
+		})
+		go http.Serve(l, mux)
+	case NativeRPC:
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					// The listener has been closed
+					s.Logger().Debugf("%v", err)
+					return
+				}
+				if s.Codec == JSONCodec {
+					go server.ServeCodec(s.recorder.wrap(newStatsCodec(jsonrpc.NewServerCodec(conn), &s.stats)))
+				} else {
+					go server.ServeCodec(s.recorder.wrap(newStatsCodec(newGobServerCodec(conn), &s.stats)))
+				}
+			}
+		}()
+	case GRPC:
+		gs, err := newGRPCServer(r.Type, c, s, tlsConfig)
+		if err != nil {
+			stop()
+			stopSignals()
+			s.Logger().Errorf("%v", err)
+			writeErrorResponse(w, m, NewErrorResponse(ErrorCodeUnsupported, err))
+			return err, 2
+		}
+		go gs.Serve(l)
+		// Stopping the server also closes the listener
+		stop = gs.Stop
+	default:
+		stop()
+		stopSignals()
+		writeErrorResponse(w, m, NewErrorResponse(ErrorCodeUnsupported, ErrUnsupportedRPCType))
+		return ErrUnsupportedRPCType, 2
+	}
+
+	prof, err := startProfiler(s.Arg)
+	if err != nil {
+		stop()
+		stopSignals()
+		s.Logger().Errorf("%v", err)
+		resp := NewErrorResponse(ErrorCodeBindFailed, err)
+		resp.ErrorFields = map[string]string{"address": net.JoinHostPort(s.ProfilingAddr, s.ProfilingPort)}
+		writeErrorResponse(w, m, resp)
+		return err, 2
+	}
+	s.setProfiler(prof)
+	if prof != nil {
+		s.Logger().Infof("Profiling on %s", prof.l.Addr())
+	}
+	r.ProfilingPort = prof.port()
+
+	if s.isDaemon() {
+		stopSignals = s.killOnSignal()
+	}
+
+	r.Codec = s.Codec
+	r.Init, _ = s.init.status()
+	resp, err := s.generateResponse(r)
+	if err != nil {
+		stop()
+		stopSignals()
+		prof.close()
+		s.Logger().Errorf("%v", err)
+		writeErrorResponse(w, m, NewErrorResponse(ErrorCodeInternal, err))
+		return err, 2
+	}
+	// Output response to stdout
+	fmt.Fprintln(w, string(resp))
+	s.Logger().Infof("%s", resp)
+	go s.heartbeatWatch()
+	go s.runInit()
+	s.startPush()
+
+	if s.isDaemon() {
+		<-s.Done()
+		sd := s.ShutdownReason()
+		s.Logger().Infof("Session ended by %s: %s", sd.Source, sd.Reason)
+		s.stopHeartbeat()
+		stop()
+		stopSignals()
+		s.recorder.close()
+		if sd.Source == ShutdownSourceInit {
+			return errors.New(sd.Reason), 2
+		}
+	}
+
+	return nil, exitCode
+}
+
+// newServer returns the RPC server of the session s of plugin c, with the
+// proxy of its type and the methods of the session registered, and the
+// Response to complete once it listens.
+func newServer(s *SessionState, m *PluginMeta, c Plugin) (*rpc.Server, *Response, error) {
+	var r *Response
+
+	// Each plugin gets its own RPC server so that Start does not depend on
+	// (or pollute) the process wide rpc.DefaultServer.
+	server := rpc.NewServer()
 
 	switch m.Type {
 	case CollectorPluginType:
 		// Create our proxy
 		proxy := &collectorPluginProxy{
-			Plugin:  c.(CollectorPlugin),
+			Plugin:  s.simulation.wrap(collectorPlugin(c, s.base)),
 			Session: s,
+			Meta:    m,
+			cache:   s.cache,
+			config:  s.config,
+
+			compressor: s.compressor,
+			chunks:     s.chunks,
+			suspension: s.suspension,
+			init:       s.init,
+			requests:   s.requests,
+			base:       s.base,
 		}
 		// Register the proxy under the "Collector" namespace
-		rpc.RegisterName("Collector", proxy)
+		server.RegisterName("Collector", proxy)
 
 		r = &Response{
 			Type:  CollectorPluginType,
@@ -301,12 +1222,28 @@ func Start(m *PluginMeta, c Plugin, requestString string) (error, int) {
 		}
 		// Create our proxy
 		proxy := &publisherPluginProxy{
-			Plugin:  c.(PublisherPlugin),
+			Plugin:  publisherPlugin(c, s.base),
 			Session: s,
+			Meta:    m,
+			config:  s.config,
+
+			suspension: s.suspension,
+			init:       s.init,
+			requests:   s.requests,
+			base:       s.base,
+
+			backpressure: s.backpressure,
+			retryable:    retryClassifier(c),
+			acks:         s.acks,
+			spool:        s.spool,
+			preview:      dryRunPreviewer(c),
 		}
+		proxy.batches = newBatcher(s, proxy.publishBatch)
+		s.batches = proxy.batches
+		s.spool.start(proxy.Plugin.Publish, s.Done())
 
 		// Register the proxy under the "Publisher" namespace
-		rpc.RegisterName("Publisher", proxy)
+		server.RegisterName("Publisher", proxy)
 	case ProcessorPluginType:
 		r = &Response{
 			Type:  ProcessorPluginType,
@@ -318,94 +1255,90 @@ func Start(m *PluginMeta, c Plugin, requestString string) (error, int) {
 		}
 		// Create our proxy
 		proxy := &processorPluginProxy{
-			Plugin:  c.(ProcessorPlugin),
+			Plugin:  processorPlugin(c, s.base),
 			Session: s,
+			Meta:    m,
+			config:  s.config,
+
+			compressor: s.compressor,
+			suspension: s.suspension,
+			init:       s.init,
+			requests:   s.requests,
+			base:       s.base,
 		}
 		// Register the proxy under the "Publisher" namespace
-		rpc.RegisterName("Processor", proxy)
-	}
-
-	// Register common plugin methods used for utility reasons
-	e := rpc.Register(s)
-	if e != nil {
-		if e.Error() != "rpc: service already defined: SessionState" {
-			s.Logger().Error(e.Error())
-			return e, 2
+		server.RegisterName("Processor", proxy)
+	case StreamCollectorPluginType:
+		sc := c.(StreamCollector)
+		proxy := &streamCollectorPluginProxy{
+			Plugin:  sc,
+			Session: s,
+			stream:  newStream(sc, s.StreamBufferSize),
 		}
-	}
-
-	l, err := net.Listen("tcp", "127.0.0.1:"+s.ListenPort())
-	if err != nil {
-		s.Logger().Error(err.Error())
-		panic(err)
-	}
-	s.SetListenAddress(l.Addr().String())
-	s.Logger().Debugf("Listening %s\n", l.Addr())
-	s.Logger().Debugf("Session token %s\n", s.Token())
+		server.RegisterName("StreamCollector", proxy)
+		// The catalog is served like a collector's
+		server.RegisterName("Collector", &collectorPluginProxy{
+			Plugin:  streamCatalog{sc},
+			Session: s,
+			Meta:    m,
 
-	switch r.Meta.RPCType {
-	case JSONRPC:
-		rpc.HandleHTTP()
-		http.HandleFunc("/rpc", func(w http.ResponseWriter, req *http.Request) {
-			defer req.Body.Close()
-			w.Header().Set("Content-Type", "application/json")
-			if req.ContentLength == 0 {
-				encoder := json.NewEncoder(w)
-				encoder.Encode(&struct {
-					Id     interface{} `json:"id"`
-					Result interface{} `json:"result"`
-					Error  interface{} `json:"error"`
-				}{
-					Id:     nil,
-					Result: nil,
-					Error:  "rpc: method request ill-formed",
-				})
-				return
-			}
-			res := NewRPCRequest(req.Body).Call()
-			io.Copy(w, res)
+			requests: s.requests,
 		})
-		go http.Serve(l, nil)
-	case NativeRPC:
 		go func() {
-			for {
-				conn, err := l.Accept()
-				if err != nil {
-					panic(err)
-				}
-				go rpc.ServeConn(conn)
-			}
+			<-s.Done()
+			proxy.stream.halt()
 		}()
-	default:
-		panic("Unsupported RPC type")
-	}
 
-	resp := s.generateResponse(r)
-	// Output response to stdout
-	fmt.Println(string(resp))
-	s.Logger().Println(string(resp))
-	go s.heartbeatWatch(s.KillChan())
+		r = &Response{
+			Type:  StreamCollectorPluginType,
+			State: PluginSuccess,
+			Meta:  *m,
+		}
+		if !m.Unsecure {
+			r.PublicKey = &s.privateKey.PublicKey
+		}
+	}
 
-	if s.isDaemon() {
-		exitCode = <-s.KillChan() // Closing of channel kills
+	// Register common plugin methods used for utility reasons
+	if err := server.Register(s); err != nil {
+		return nil, nil, err
 	}
+	return server, r, nil
+}
 
-	return nil, exitCode
+// writeErrorResponse lets control know why the plugin did not start
+func writeErrorResponse(w io.Writer, m *PluginMeta, r *Response) {
+	r.RPCVersion = RPCVersion
+	// A Response with an invalid type can't be marshaled
+	if m.Type.IsValid() {
+		r.Type = m.Type
+		r.Meta = *m
+	}
+	resp, _ := json.Marshal(r)
+	fmt.Fprintln(w, string(resp))
 }
 
 // rpcRequest represents a RPC request.
 // rpcRequest implements the io.ReadWriteCloser interface.
 type rpcRequest struct {
-	r    io.Reader     // holds the JSON formated RPC request
-	rw   io.ReadWriter // holds the JSON formated RPC response
-	done chan bool     // signals then end of the RPC request
+	r      io.Reader     // holds the JSON formated RPC request
+	rw     io.ReadWriter // holds the JSON formated RPC response
+	done   chan bool     // signals then end of the RPC request
+	server *rpc.Server   // serves the request
+	stats  *sessionStats // records the call when set
+
+	recorder *rpcRecorder // records the call in Arg.RPCRecordFile
 }
 
-// NewRPCRequest returns a new rpcRequest.
+// NewRPCRequest returns a new rpcRequest served by rpc.DefaultServer.
 func NewRPCRequest(r io.Reader) *rpcRequest {
+	return newRPCRequest(r, rpc.DefaultServer)
+}
+
+func newRPCRequest(r io.Reader, server *rpc.Server) *rpcRequest {
 	var buf bytes.Buffer
 	done := make(chan bool)
-	return &rpcRequest{r, &buf, done}
+	return &rpcRequest{r: r, rw: &buf, done: done, server: server}
 }
 
 // Read implements the io.ReadWriteCloser Read method.
@@ -427,17 +1360,22 @@ func (r *rpcRequest) Close() error {
 
 // Call invokes the RPC request, waits for it to complete, and returns the results.
 func (r *rpcRequest) Call() io.Reader {
-	go jsonrpc.ServeConn(r)
+	codec := jsonrpc.NewServerCodec(r)
+	if r.stats != nil {
+		codec = newStatsCodec(codec, r.stats)
+	}
+	codec = r.recorder.wrap(codec)
+	go r.server.ServeCodec(codec)
 	<-r.done
 	return r.rw
 }
 
-func catchPluginPanic(l *log.Logger) {
+func catchPluginPanic(l Logger) {
 	if err := recover(); err != nil {
 		trace := make([]byte, 4096)
 		count := runtime.Stack(trace, true)
-		l.Printf("Recover from panic: %s\n", err)
-		l.Printf("Stack of %d bytes: %s\n", count, trace)
+		l.Errorf("Recover from panic: %s", err)
+		l.Errorf("Stack of %d bytes: %s", count, trace)
 		panic(err)
 	}
 }
@@ -22,6 +22,9 @@ limitations under the License.
 package plugin
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -36,6 +39,317 @@ func TestPluginType(t *testing.T) {
 			p := PluginType(0)
 			So(p.String(), ShouldEqual, "collector")
 		})
+		Convey("it does not panic for an unknown type", func() {
+			So(PluginType(-1).String(), ShouldEqual, "unknown(-1)")
+			So(PluginType(4).String(), ShouldEqual, "unknown(4)")
+			So(PluginType(42).String(), ShouldEqual, "unknown(42)")
+		})
+	})
+	Convey(".IsValid()", t, func() {
+		So(CollectorPluginType.IsValid(), ShouldBeTrue)
+		So(PublisherPluginType.IsValid(), ShouldBeTrue)
+		So(PluginType(-1).IsValid(), ShouldBeFalse)
+		So(StreamCollectorPluginType.IsValid(), ShouldBeTrue)
+		So(PluginType(4).IsValid(), ShouldBeFalse)
+	})
+	Convey("A plugin of an unknown type", t, func() {
+		m := NewPluginMeta("mock", 1, PluginType(7), []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+		Convey("has no session", func() {
+			_, err, rc := NewSessionState("{}", &MockPlugin{}, m)
+			So(err, ShouldNotBeNil)
+			So(rc, ShouldEqual, 2)
+		})
+		Convey("fails to start", func() {
+			resp, done := startTestPlugin(m, &MockPlugin{}, "{}")
+			So(<-done, ShouldEqual, 2)
+			So(resp.State, ShouldEqual, PluginFailure)
+			So(resp.ErrorCode, ShouldEqual, ErrorCodeConfigInvalid)
+			So(resp.ErrorMessage, ShouldEqual, "invalid plugin type 7")
+		})
+		Convey("gets no Response", func() {
+			ss := &SessionState{Arg: &Arg{}}
+			_, err := ss.generateResponse(&Response{Type: PluginType(-1)})
+			So(err, ShouldNotBeNil)
+		})
+	})
+	Convey("PluginTypeFromString", t, func() {
+		for _, p := range []PluginType{CollectorPluginType, ProcessorPluginType, PublisherPluginType, StreamCollectorPluginType} {
+			t, err := PluginTypeFromString(p.String())
+			So(err, ShouldBeNil)
+			So(t, ShouldEqual, p)
+		}
+		_, err := PluginTypeFromString("exporter")
+		So(err, ShouldNotBeNil)
+		_, err = PluginTypeFromString("Collector")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("A Response", t, func() {
+		Convey("round trips every type by name", func() {
+			for _, p := range []PluginType{CollectorPluginType, ProcessorPluginType, PublisherPluginType, StreamCollectorPluginType} {
+				b, err := json.Marshal(&Response{Type: p})
+				So(err, ShouldBeNil)
+				So(string(b), ShouldContainSubstring, `"Type":"`+p.String()+`"`)
+				var r Response
+				So(json.Unmarshal(b, &r), ShouldBeNil)
+				So(r.Type, ShouldEqual, p)
+			}
+		})
+		Convey("reads the numeric type of an older plugin", func() {
+			var r Response
+			So(json.Unmarshal([]byte(`{"Type": 2}`), &r), ShouldBeNil)
+			So(r.Type, ShouldEqual, PublisherPluginType)
+		})
+		Convey("rejects an unknown type", func() {
+			var r Response
+			So(json.Unmarshal([]byte(`{"Type": "exporter"}`), &r), ShouldNotBeNil)
+			So(json.Unmarshal([]byte(`{"Type": 4}`), &r), ShouldNotBeNil)
+			_, err := json.Marshal(&Response{Type: PluginType(4)})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestPluginMetaValidate(t *testing.T) {
+	valid := func() *PluginMeta {
+		return NewPluginMeta("mock-file_2.x", 1, CollectorPluginType, nil, nil)
+	}
+	Convey("A valid PluginMeta", t, func() {
+		So(valid().Validate(), ShouldBeNil)
+	})
+	Convey("An invalid PluginMeta", t, func() {
+		tests := []struct {
+			name   string
+			modify func(*PluginMeta)
+			err    string
+		}{
+			{"type", func(m *PluginMeta) { m.Type = PluginType(7) }, "invalid plugin type 7"},
+			{"empty name", func(m *PluginMeta) { m.Name = "" }, `invalid plugin name ""`},
+			{"name", func(m *PluginMeta) { m.Name = "../mock" }, `invalid plugin name "../mock"`},
+			{"name with a space", func(m *PluginMeta) { m.Name = "my mock" }, `invalid plugin name "my mock"`},
+			{"version", func(m *PluginMeta) { m.Version = 0 }, "invalid plugin version 0, it must be at least 1"},
+			{"negative version", func(m *PluginMeta) { m.Version = -2 }, "invalid plugin version -2, it must be at least 1"},
+			{"accepted content type", func(m *PluginMeta) { m.AcceptedContentTypes = []string{"snap.gob", "json"} }, `bad accepted content type "json"`},
+			{"returned content type", func(m *PluginMeta) { m.ReturnedContentTypes = []string{"Snap.GOB"} }, `bad returned content type "Snap.GOB"`},
+			{"concurrency count", func(m *PluginMeta) { m.ConcurrencyCount = -1 }, "invalid concurrency count -1"},
+			{"routing strategy", func(m *PluginMeta) { m.RoutingStrategy = RoutingStrategyType(5) }, "unknown routing strategy 5"},
+			{"author", func(m *PluginMeta) { m.Author = strings.Repeat("a", 1025) }, "plugin author is 1025 bytes long, the limit is 1024"},
+			{"license", func(m *PluginMeta) { m.License = strings.Repeat("l", 2048) }, "plugin license is 2048 bytes long, the limit is 1024"},
+			{"URL", func(m *PluginMeta) { m.URL = strings.Repeat("u", 1025) }, "plugin URL is 1025 bytes long, the limit is 1024"},
+			{"description", func(m *PluginMeta) { m.Description = strings.Repeat("d", 1025) }, "plugin description is 1025 bytes long, the limit is 1024"},
+		}
+		for _, test := range tests {
+			Convey(fmt.Sprintf("with an invalid %s", test.name), func() {
+				m := valid()
+				test.modify(m)
+				So(m.Validate(), ShouldResemble, &PluginError{Code: ErrorCodeConfigInvalid, Message: test.err})
+			})
+		}
+	})
+	Convey("Descriptive fields", t, func() {
+		m := NewPluginMeta("mock", 1, CollectorPluginType, nil, nil,
+			Author("Jane Doe <jane@example.com>"),
+			License("Apache-2.0"),
+			URL("https://github.com/intelsdi-x/snap"),
+			Description(strings.Repeat("d", MaxMetaTextLength)))
+		So(m.Validate(), ShouldBeNil)
+		Convey("round trip through the Response", func() {
+			b, err := json.Marshal(&Response{Meta: *m, Type: CollectorPluginType, State: PluginSuccess})
+			So(err, ShouldBeNil)
+			var r Response
+			So(json.Unmarshal(b, &r), ShouldBeNil)
+			So(r.Meta.Author, ShouldEqual, "Jane Doe <jane@example.com>")
+			So(r.Meta.License, ShouldEqual, "Apache-2.0")
+			So(r.Meta.URL, ShouldEqual, "https://github.com/intelsdi-x/snap")
+			So(r.Meta.Description, ShouldEqual, m.Description)
+		})
+		Convey("are omitted from the JSON when empty", func() {
+			b, err := json.Marshal(NewPluginMeta("mock", 1, CollectorPluginType, nil, nil, License("MIT")))
+			So(err, ShouldBeNil)
+			So(string(b), ShouldContainSubstring, `"License":"MIT"`)
+			for _, field := range []string{"Author", "URL", "Description"} {
+				So(string(b), ShouldNotContainSubstring, fmt.Sprintf("%q", field))
+			}
+		})
+	})
+	Convey("A plugin with an invalid PluginMeta fails to start", t, func() {
+		m := &PluginMeta{Type: CollectorPluginType, Version: 1}
+		resp, done := startTestPlugin(m, new(MockPlugin), "{}")
+		So(<-done, ShouldEqual, 2)
+		So(resp.State, ShouldEqual, PluginFailure)
+		So(resp.ErrorCode, ShouldEqual, ErrorCodeConfigInvalid)
+		So(resp.ErrorMessage, ShouldEqual, `invalid plugin name ""`)
+		So(resp.Token, ShouldBeEmpty)
+		So(resp.ListenAddress, ShouldBeEmpty)
+	})
+}
+
+func TestNewValidPluginMeta(t *testing.T) {
+	Convey("NewValidPluginMeta", t, func() {
+		Convey("defaults the content types", func() {
+			m, err := NewValidPluginMeta("mock", 1, CollectorPluginType)
+			So(err, ShouldBeNil)
+			So(m.AcceptedContentTypes, ShouldResemble, []string{SnapGOBContentType})
+			So(m.ReturnedContentTypes, ShouldResemble, []string{SnapGOBContentType})
+			So(m.ConcurrencyCount, ShouldEqual, 0)
+		})
+		Convey("applies the options", func() {
+			m, err := NewValidPluginMeta("mock", 2, ProcessorPluginType,
+				AcceptedContentTypes(SnapJSONContentType, SnapGOBContentType),
+				ReturnedContentTypes(SnapJSONContentType),
+				ConcurrencyCount(4),
+				CacheTTL(time.Second),
+				RoutingStrategy(ConfigBasedRouting),
+				RoutingKeys("address"),
+				Unsecure(true),
+				Author("snap"))
+			So(err, ShouldBeNil)
+			So(m, ShouldResemble, &PluginMeta{
+				Name:                 "mock",
+				Version:              2,
+				Type:                 ProcessorPluginType,
+				AcceptedContentTypes: []string{SnapJSONContentType, SnapGOBContentType},
+				ReturnedContentTypes: []string{SnapJSONContentType},
+				ConcurrencyCount:     4,
+				CacheTTL:             time.Second,
+				RoutingStrategy:      ConfigBasedRouting,
+				RoutingKeys:          []string{"address"},
+				Unsecure:             true,
+				Author:               "snap",
+			})
+		})
+		Convey("accepts an exclusive plugin", func() {
+			for _, opts := range [][]MetaOpt{
+				{Exclusive(true)},
+				{Exclusive(true), ConcurrencyCount(1)},
+				{ConcurrencyCount(1), Exclusive(true)},
+				{Exclusive(false), ConcurrencyCount(8)},
+			} {
+				_, err := NewValidPluginMeta("mock", 1, CollectorPluginType, opts...)
+				So(err, ShouldBeNil)
+			}
+		})
+		Convey("rejects", func() {
+			tests := []struct {
+				name string
+				opts []MetaOpt
+				err  string
+			}{
+				{"Exclusive with a ConcurrencyCount", []MetaOpt{Exclusive(true), ConcurrencyCount(2)}, "an exclusive plugin takes one call at a time, not a concurrency count of 2"},
+				{"a ConcurrencyCount with Exclusive", []MetaOpt{ConcurrencyCount(3), Exclusive(true)}, "an exclusive plugin takes one call at a time, not a concurrency count of 3"},
+				{"routing keys without config routing", []MetaOpt{RoutingKeys("address"), RoutingStrategy(StickyRouting)}, "routing keys require the config routing strategy, not sticky"},
+				{"a negative ConcurrencyCount", []MetaOpt{ConcurrencyCount(-1)}, "invalid concurrency count -1"},
+				{"a negative CacheTTL", []MetaOpt{CacheTTL(-time.Second)}, "invalid cache TTL -1s"},
+				{"an unknown routing strategy", []MetaOpt{RoutingStrategy(RoutingStrategyType(4))}, "unknown routing strategy 4"},
+				{"an empty routing key", []MetaOpt{RoutingKeys("")}, "empty routing key"},
+				{"a bad accepted content type", []MetaOpt{AcceptedContentTypes("gob")}, `bad accepted content type "gob"`},
+				{"a bad returned content type", []MetaOpt{ReturnedContentTypes("snap.gob", "snap json")}, `bad returned content type "snap json"`},
+				{"a long author", []MetaOpt{Author(strings.Repeat("a", MaxMetaTextLength+1))}, "plugin author is 1025 bytes long, the limit is 1024"},
+			}
+			for _, test := range tests {
+				Convey(test.name, func() {
+					m, err := NewValidPluginMeta("mock", 1, CollectorPluginType, test.opts...)
+					So(m, ShouldBeNil)
+					So(err, ShouldResemble, &PluginError{Code: ErrorCodeConfigInvalid, Message: test.err})
+				})
+			}
+			Convey("an invalid name, version or type", func() {
+				_, err := NewValidPluginMeta("", 1, CollectorPluginType)
+				So(err, ShouldNotBeNil)
+				_, err = NewValidPluginMeta("mock", 0, CollectorPluginType)
+				So(err, ShouldNotBeNil)
+				_, err = NewValidPluginMeta("mock", 1, PluginType(4))
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+	Convey("NewPluginMeta panics on an invalid option", t, func() {
+		So(func() {
+			NewPluginMeta("test", 1, CollectorPluginType, nil, nil, ConcurrencyCount(-1))
+		}, ShouldPanicWith, "Bad option for [test] [1]: invalid concurrency count -1")
+	})
+}
+
+func TestRoutingStrategy(t *testing.T) {
+	Convey(".String()", t, func() {
+		So(LRURouting.String(), ShouldEqual, "least-recently-used")
+		So(StickyRouting.String(), ShouldEqual, "sticky")
+		So(ConfigBasedRouting.String(), ShouldEqual, "config")
+		So(RoutingStrategyType(9).String(), ShouldEqual, "unknown(9)")
+	})
+	Convey("ValidateRouting", t, func() {
+		tests := []struct {
+			meta PluginMeta
+			err  string
+		}{
+			{PluginMeta{}, ""},
+			{PluginMeta{RoutingStrategy: StickyRouting}, ""},
+			{PluginMeta{RoutingStrategy: ConfigBasedRouting, RoutingKeys: []string{"address", "port"}}, ""},
+			{PluginMeta{RoutingStrategy: RoutingStrategyType(9)}, "unknown routing strategy 9"},
+			{PluginMeta{RoutingStrategy: -1}, "unknown routing strategy -1"},
+			{PluginMeta{RoutingStrategy: StickyRouting, RoutingKeys: []string{"address"}}, "routing keys require the config routing strategy, not sticky"},
+			{PluginMeta{RoutingStrategy: ConfigBasedRouting, RoutingKeys: []string{""}}, "empty routing key"},
+		}
+		for _, test := range tests {
+			err := test.meta.ValidateRouting()
+			if test.err == "" {
+				So(err, ShouldBeNil)
+				continue
+			}
+			So(err, ShouldResemble, &PluginError{Code: ErrorCodeConfigInvalid, Message: test.err})
+		}
+	})
+	Convey("A Response round trips the routing strategy and keys", t, func() {
+		m := NewPluginMeta("test", 1, CollectorPluginType, nil, nil, RoutingStrategy(ConfigBasedRouting), RoutingKeys("address"))
+		b, err := json.Marshal(&Response{Meta: *m, Type: CollectorPluginType, State: PluginSuccess})
+		So(err, ShouldBeNil)
+		var r Response
+		So(json.Unmarshal(b, &r), ShouldBeNil)
+		So(r.Meta.RoutingStrategy, ShouldEqual, ConfigBasedRouting)
+		So(r.Meta.RoutingKeys, ShouldResemble, []string{"address"})
+		So(r.Meta.ValidateRouting(), ShouldBeNil)
+	})
+	Convey("A plugin with an invalid routing strategy fails to start", t, func() {
+		m := NewPluginMeta("test", 1, CollectorPluginType, nil, nil, RoutingKeys("address"))
+		resp, done := startTestPlugin(m, new(MockPlugin), "{}")
+		So(<-done, ShouldEqual, 2)
+		So(resp.State, ShouldEqual, PluginFailure)
+		So(resp.ErrorCode, ShouldEqual, ErrorCodeConfigInvalid)
+	})
+}
+
+func TestPluginResponseState(t *testing.T) {
+	Convey(".String()", t, func() {
+		So(PluginNotReady.String(), ShouldEqual, "not-ready")
+		So(PluginUnknown.String(), ShouldEqual, "unknown")
+		So(PluginResponseState(42).String(), ShouldEqual, "unknown")
+	})
+	Convey("A Response", t, func() {
+		Convey("round trips every state by name", func() {
+			for state := PluginSuccess; state <= PluginDisabled; state++ {
+				b, err := json.Marshal(&Response{State: state})
+				So(err, ShouldBeNil)
+				So(string(b), ShouldContainSubstring, `"State":"`+state.String()+`"`)
+				var r Response
+				So(json.Unmarshal(b, &r), ShouldBeNil)
+				So(r.State, ShouldEqual, state)
+			}
+		})
+		Convey("reads the numeric state of an older plugin", func() {
+			var r Response
+			So(json.Unmarshal([]byte(`{"State": 1}`), &r), ShouldBeNil)
+			So(r.State, ShouldEqual, PluginFailure)
+		})
+		Convey("reads a state from a newer plugin as unknown", func() {
+			var r Response
+			So(json.Unmarshal([]byte(`{"State": "hibernating"}`), &r), ShouldBeNil)
+			So(r.State, ShouldEqual, PluginUnknown)
+			So(json.Unmarshal([]byte(`{"State": 9}`), &r), ShouldBeNil)
+			So(r.State, ShouldEqual, PluginUnknown)
+		})
+		Convey("with an undefined state is not marshaled", func() {
+			_, err := json.Marshal(&Response{State: PluginUnknown})
+			So(err, ShouldNotBeNil)
+		})
 	})
 }
 
@@ -95,6 +409,38 @@ func TestPlugin(t *testing.T) {
 			NewPluginMeta("test", 1, CollectorPluginType, a, b)
 		}, ShouldPanicWith, "Bad return content type [test] for [1] [wat]")
 	})
+	Convey("Content types", t, func() {
+		Convey("default to snap.gob", func() {
+			m := NewPluginMeta("test", 1, ProcessorPluginType, nil, nil)
+			So(m.AcceptedContentTypes, ShouldResemble, []string{SnapGOBContentType})
+			So(m.ReturnedContentTypes, ShouldResemble, []string{SnapGOBContentType})
+		})
+		Convey("round trip through the Response", func() {
+			m := NewPluginMeta("test", 1, ProcessorPluginType, []string{SnapJSONContentType, SnapGOBContentType}, []string{SnapJSONContentType})
+			b, err := json.Marshal(&Response{Meta: *m, Type: ProcessorPluginType, State: PluginSuccess})
+			So(err, ShouldBeNil)
+			var r Response
+			So(json.Unmarshal(b, &r), ShouldBeNil)
+			So(r.Meta.AcceptedContentTypes, ShouldResemble, []string{SnapJSONContentType, SnapGOBContentType})
+			So(r.Meta.ReturnedContentTypes, ShouldResemble, []string{SnapJSONContentType})
+		})
+		Convey("are reported by a started plugin", func() {
+			m := &PluginMeta{Name: "test", Version: 1, Type: CollectorPluginType, Unsecure: true}
+			resp, done := startTestPlugin(m, new(MockPlugin), fmt.Sprintf(`{"PingTimeoutDuration": %d}`, time.Millisecond))
+			So(resp.State, ShouldEqual, PluginSuccess)
+			So(resp.Meta.AcceptedContentTypes, ShouldResemble, []string{SnapGOBContentType})
+			So(resp.Meta.ReturnedContentTypes, ShouldResemble, []string{SnapGOBContentType})
+			<-done
+		})
+		Convey("which are malformed fail the plugin", func() {
+			m := &PluginMeta{Name: "test", Version: 1, Type: CollectorPluginType, ReturnedContentTypes: []string{"gob"}}
+			resp, done := startTestPlugin(m, new(MockPlugin), "{}")
+			So(<-done, ShouldEqual, 2)
+			So(resp.State, ShouldEqual, PluginFailure)
+			So(resp.ErrorCode, ShouldEqual, ErrorCodeConfigInvalid)
+			So(resp.ErrorMessage, ShouldEqual, `bad returned content type "gob"`)
+		})
+	})
 	Convey("Plugin CacheTTL", t, func() {
 		mockPluginMeta := NewPluginMeta("test", 1, CollectorPluginType, a, b)
 		mockPluginMeta.CacheTTL = time.Duration(100 * time.Millisecond)
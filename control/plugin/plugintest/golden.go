@@ -0,0 +1,226 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugintest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/core"
+)
+
+// update regenerates the golden files rather than comparing them, with
+// `go test -update`
+var update = flag.Bool("update", false, "update the golden files of plugintest.CompareGolden")
+
+// masked replaces the value of a field ignored by the comparison
+const masked = "*"
+
+// GoldenMetric is the canonical form of a metric in a golden file
+type GoldenMetric struct {
+	Namespace string            `json:"namespace"`
+	Version   int               `json:"version"`
+	Unit      string            `json:"unit,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Data      json.RawMessage   `json:"data"`
+	Timestamp string            `json:"timestamp"`
+}
+
+// GoldenOpt changes which fields of the metrics are compared
+type GoldenOpt func(*goldenOpts)
+
+type goldenOpts struct {
+	timestamps bool
+	source     bool
+}
+
+// ExactTimestamps compares the timestamps of the metrics, which are masked
+// by default
+func ExactTimestamps() GoldenOpt {
+	return func(o *goldenOpts) { o.timestamps = true }
+}
+
+// ExactSource compares the plugin_running_on tag of the metrics, the
+// hostname which is masked by default
+func ExactSource() GoldenOpt {
+	return func(o *goldenOpts) { o.source = true }
+}
+
+// Canonical returns mts in the form of a golden file: sorted by namespace,
+// then data and tags, with the timestamps and source hostname masked
+// unless opts ask for them.
+func Canonical(mts []core.Metric, opts ...GoldenOpt) ([]byte, error) {
+	gms, err := canonical(mts, opts)
+	if err != nil {
+		return nil, err
+	}
+	out, err := json.MarshalIndent(gms, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+func canonical(mts []core.Metric, opts []GoldenOpt) ([]GoldenMetric, error) {
+	o := &goldenOpts{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	gms := make([]GoldenMetric, len(mts))
+	for i, m := range mts {
+		data, err := json.Marshal(m.Data())
+		if err != nil {
+			return nil, fmt.Errorf("%s: encoding data: %v", m.Namespace(), err)
+		}
+		gm := GoldenMetric{
+			Namespace: m.Namespace().String(),
+			Version:   m.Version(),
+			Unit:      m.Unit(),
+			Data:      data,
+			Timestamp: masked,
+		}
+		if o.timestamps {
+			gm.Timestamp = m.Timestamp().UTC().Format(time.RFC3339Nano)
+		}
+		if len(m.Tags()) > 0 {
+			gm.Tags = make(map[string]string, len(m.Tags()))
+			for k, v := range m.Tags() {
+				if k == core.STD_TAG_PLUGIN_RUNNING_ON && !o.source {
+					v = masked
+				}
+				gm.Tags[k] = v
+			}
+		}
+		gms[i] = gm
+	}
+	sort.Sort(byCanonicalOrder(gms))
+	return gms, nil
+}
+
+// byCanonicalOrder sorts metrics by namespace, data and tags, so that the
+// order in which a plugin returns them does not matter
+type byCanonicalOrder []GoldenMetric
+
+func (b byCanonicalOrder) Len() int      { return len(b) }
+func (b byCanonicalOrder) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byCanonicalOrder) Less(i, j int) bool {
+	if b[i].Namespace != b[j].Namespace {
+		return b[i].Namespace < b[j].Namespace
+	}
+	if c := bytes.Compare(b[i].Data, b[j].Data); c != 0 {
+		return c < 0
+	}
+	ti, _ := json.Marshal(b[i].Tags)
+	tj, _ := json.Marshal(b[j].Tags)
+	return bytes.Compare(ti, tj) < 0
+}
+
+// CompareGolden compares mts with the golden file at path, see Canonical,
+// and returns an error naming the first metric which differs.  With the
+// -update flag it writes mts to the file instead.
+func CompareGolden(path string, mts []core.Metric, opts ...GoldenOpt) error {
+	if *update {
+		out, err := Canonical(mts, opts...)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, out, 0644)
+	}
+	got, err := canonical(mts, opts)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("golden file %s is missing, run the test with -update to create it", path)
+		}
+		return err
+	}
+	var want []GoldenMetric
+	if err := json.Unmarshal(b, &want); err != nil {
+		return fmt.Errorf("golden file %s: %v", path, err)
+	}
+	return diffGolden(path, got, want)
+}
+
+// diffGolden returns an error describing the first difference between the
+// canonical metrics got and want
+func diffGolden(path string, got, want []GoldenMetric) error {
+	for i := 0; i < len(got) && i < len(want); i++ {
+		g, w := got[i], want[i]
+		if g.Namespace != w.Namespace {
+			return fmt.Errorf("%s: metric %d is %s, want %s", path, i, g.Namespace, w.Namespace)
+		}
+		if gd, wd := compact(g.Data), compact(w.Data); gd != wd {
+			return fmt.Errorf("%s: %s has data %s, want %s", path, g.Namespace, gd, wd)
+		}
+		if g.Version != w.Version {
+			return fmt.Errorf("%s: %s has version %d, want %d", path, g.Namespace, g.Version, w.Version)
+		}
+		if g.Unit != w.Unit {
+			return fmt.Errorf("%s: %s has unit %q, want %q", path, g.Namespace, g.Unit, w.Unit)
+		}
+		if gt, wt := tagsString(g.Tags), tagsString(w.Tags); gt != wt {
+			return fmt.Errorf("%s: %s has tags %s, want %s", path, g.Namespace, gt, wt)
+		}
+		if g.Timestamp != w.Timestamp {
+			return fmt.Errorf("%s: %s has timestamp %s, want %s", path, g.Namespace, g.Timestamp, w.Timestamp)
+		}
+	}
+	switch {
+	case len(got) > len(want):
+		return fmt.Errorf("%s: %d metrics, want %d: unexpected %s", path, len(got), len(want), got[len(want)].Namespace)
+	case len(got) < len(want):
+		return fmt.Errorf("%s: %d metrics, want %d: missing %s", path, len(got), len(want), want[len(got)].Namespace)
+	}
+	return nil
+}
+
+func compact(data json.RawMessage) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return string(data)
+	}
+	return buf.String()
+}
+
+func tagsString(tags map[string]string) string {
+	if len(tags) == 0 {
+		return "{}"
+	}
+	b, _ := json.Marshal(tags)
+	return string(b)
+}
+
+// AssertGolden fails t unless mts match the golden file at path, see
+// CompareGolden
+func AssertGolden(t *testing.T, path string, mts []core.Metric, opts ...GoldenOpt) {
+	if err := CompareGolden(path, mts, opts...); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,126 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugintest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin"
+	"github.com/intelsdi-x/snap/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func goldenMetric(ns string, data interface{}, ts time.Time, host string) core.Metric {
+	return plugin.MetricType{
+		Namespace_: core.NewNamespace("intel", "golden", ns),
+		Data_:      data,
+		Timestamp_: ts,
+		Tags_:      map[string]string{core.STD_TAG_PLUGIN_RUNNING_ON: host},
+	}
+}
+
+func TestCompareGolden(t *testing.T) {
+	Convey("A golden file", t, func() {
+		dir, err := ioutil.TempDir("", "snap-plugintest-golden")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "metrics.golden")
+
+		then := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+		mts := []core.Metric{
+			goldenMetric("foo", 1, then, "host-a"),
+			goldenMetric("bar", "x", then, "host-a"),
+		}
+		out, err := Canonical(mts)
+		So(err, ShouldBeNil)
+		So(ioutil.WriteFile(path, out, 0644), ShouldBeNil)
+
+		Convey("is sorted by namespace and masks timestamps and hosts", func() {
+			So(string(out), ShouldStartWith, "[\n  {\n    \"namespace\": \"/intel/golden/bar\"")
+			So(string(out), ShouldNotContainSubstring, "host-a")
+			So(string(out), ShouldNotContainSubstring, "2016")
+		})
+		Convey("matches the metrics in another order, at another time and host", func() {
+			now := time.Now()
+			So(CompareGolden(path, []core.Metric{
+				goldenMetric("bar", "x", now, "host-b"),
+				goldenMetric("foo", 1, now, "host-b"),
+			}), ShouldBeNil)
+		})
+		Convey("reports the first value which differs", func() {
+			err := CompareGolden(path, []core.Metric{
+				goldenMetric("foo", 2, then, "host-a"),
+				goldenMetric("bar", "x", then, "host-a"),
+			})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, path+": /intel/golden/foo has data 2, want 1")
+		})
+		Convey("reports a missing metric", func() {
+			err := CompareGolden(path, mts[:1])
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, path+": metric 0 is /intel/golden/foo, want /intel/golden/bar")
+		})
+		Convey("reports an unexpected metric", func() {
+			err := CompareGolden(path, append(mts, goldenMetric("qux", 3, then, "host-a")))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, path+": 3 metrics, want 2: unexpected /intel/golden/qux")
+		})
+		Convey("compares timestamps and hosts on request", func() {
+			out, err := Canonical(mts, ExactTimestamps(), ExactSource())
+			So(err, ShouldBeNil)
+			So(ioutil.WriteFile(path, out, 0644), ShouldBeNil)
+			So(CompareGolden(path, mts, ExactTimestamps(), ExactSource()), ShouldBeNil)
+
+			err = CompareGolden(path, []core.Metric{
+				goldenMetric("foo", 1, then, "host-a"),
+				goldenMetric("bar", "x", then.Add(time.Second), "host-a"),
+			}, ExactTimestamps(), ExactSource())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, path+": /intel/golden/bar has timestamp 2016-01-01T00:00:01Z, want 2016-01-01T00:00:00Z")
+
+			err = CompareGolden(path, []core.Metric{
+				goldenMetric("foo", 1, then, "host-b"),
+				goldenMetric("bar", "x", then, "host-a"),
+			}, ExactTimestamps(), ExactSource())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "/intel/golden/foo has tags")
+		})
+		Convey("which is missing asks for -update", func() {
+			err := CompareGolden(filepath.Join(dir, "missing.golden"), mts)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "-update")
+		})
+		Convey("is written with -update", func() {
+			*update = true
+			defer func() { *update = false }()
+			other := filepath.Join(dir, "other.golden")
+			So(CompareGolden(other, mts), ShouldBeNil)
+			b, err := ioutil.ReadFile(other)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, string(out))
+		})
+	})
+}
@@ -0,0 +1,308 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package plugintest runs a plugin in-process behind a MockControl, which
+// calls it over the RPC interface exactly as control does.  A plugin author
+// can test the whole lifecycle of a plugin, from the Response to the
+// session ending, without building its binary or starting snapd.
+package plugintest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin"
+	"github.com/intelsdi-x/snap/control/plugin/client"
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/core"
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+// DefaultTimeout is the timeout of the calls made by a MockControl, as
+// control's DefaultClientTimeout
+const DefaultTimeout = 5 * time.Second
+
+var (
+	// ErrTimeout is returned by Wait when the session is still running
+	ErrTimeout = errors.New("timed out waiting for the plugin to exit")
+	// ErrUnsupportedCall is returned for a call the type of the plugin
+	// does not serve, e.g. Publish on a collector
+	ErrUnsupportedCall = errors.New("the plugin does not serve this call")
+	// ErrExited is returned for a call made after the session ended, when
+	// the process of a plugin would have exited
+	ErrExited = errors.New("the plugin has exited")
+)
+
+// MockControl is control to a plugin started in-process by Start.  It
+// reads the plugin's Response and builds its client the way control does,
+// and pings the session until StopPinging or Kill.
+type MockControl struct {
+	// Response is the Response written by the plugin
+	Response plugin.Response
+
+	client client.PluginClient
+	done   chan struct{}
+	err    error
+	code   int
+
+	mutex    sync.Mutex
+	stopPing chan struct{}
+}
+
+// Start starts p with the session arguments arg, see plugin.NewArg, and
+// connects to it.  An error is returned when the plugin does not start,
+// with the message of its Response if it wrote one.  The session must be
+// ended with Kill, or by its heartbeat timing out after StopPinging.
+func Start(m *plugin.PluginMeta, p plugin.Plugin, arg plugin.Arg) (*MockControl, error) {
+	// The session ends with Kill or its heartbeat, as under control
+	arg.NoDaemon = false
+	args, err := json.Marshal(arg)
+	if err != nil {
+		return nil, err
+	}
+	mc := &MockControl{done: make(chan struct{})}
+	pr, pw := io.Pipe()
+	go func() {
+		defer close(mc.done)
+		mc.err, mc.code = plugin.Serve(pw, m, p, string(args))
+		pw.Close()
+	}()
+	line, err := bufio.NewReader(pr).ReadBytes('\n')
+	// Nothing else is written to the pipe, let Serve return
+	go io.Copy(ioutil.Discard, pr)
+	if err != nil {
+		return nil, fmt.Errorf("reading the plugin's response: %v", err)
+	}
+	if err := json.Unmarshal(line, &mc.Response); err != nil {
+		return nil, fmt.Errorf("parsing the plugin's response: %v", err)
+	}
+	if mc.Response.State != plugin.PluginSuccess {
+		<-mc.done
+		return nil, fmt.Errorf("plugin failed to start: %s", mc.Response.ErrorMessage)
+	}
+	if mc.client, err = newClient(mc.Response); err != nil {
+		mc.Kill("mock control failed to connect")
+		return nil, err
+	}
+	if mc.Response.Meta.Unsecure {
+		err = mc.client.Ping()
+	} else {
+		err = mc.client.SetKey()
+	}
+	if err != nil {
+		mc.Kill("mock control failed to connect")
+		return nil, err
+	}
+	mc.stopPing = make(chan struct{})
+	go mc.ping(arg.PingTimeoutDuration/2, mc.stopPing)
+	return mc, nil
+}
+
+// newClient returns a client of the plugin which sent resp, as control's
+// newAvailablePlugin does
+func newClient(resp plugin.Response) (client.PluginClient, error) {
+	var (
+		c   client.PluginClient
+		err error
+	)
+	addr := resp.ListenAddress
+	secure := !resp.Meta.Unsecure
+	jsonCodec := resp.Codec == plugin.JSONCodec
+	switch resp.Meta.RPCType {
+	case plugin.JSONRPC:
+		if resp.Type != plugin.CollectorPluginType {
+			return nil, errors.New("Invalid RPCTYPE")
+		}
+		c, err = client.NewCollectorHttpJSONRPCClient(fmt.Sprintf("http://%v/rpc", addr), DefaultTimeout, resp.PublicKey, secure)
+	case plugin.NativeRPC:
+		switch {
+		case resp.Type == plugin.CollectorPluginType && jsonCodec:
+			c, err = client.NewCollectorNativeJSONClient(addr, DefaultTimeout, resp.PublicKey, secure, nil)
+		case resp.Type == plugin.CollectorPluginType:
+			c, err = client.NewCollectorNativeClient(addr, DefaultTimeout, resp.PublicKey, secure)
+		case resp.Type == plugin.ProcessorPluginType && jsonCodec:
+			c, err = client.NewProcessorNativeJSONClient(addr, DefaultTimeout, resp.PublicKey, secure, nil)
+		case resp.Type == plugin.ProcessorPluginType:
+			c, err = client.NewProcessorNativeClient(addr, DefaultTimeout, resp.PublicKey, secure)
+		case resp.Type == plugin.PublisherPluginType && jsonCodec:
+			c, err = client.NewPublisherNativeJSONClient(addr, DefaultTimeout, resp.PublicKey, secure, nil)
+		case resp.Type == plugin.PublisherPluginType:
+			c, err = client.NewPublisherNativeClient(addr, DefaultTimeout, resp.PublicKey, secure)
+		default:
+			return nil, errors.New("Cannot create a client for a plugin of the type: " + resp.Type.String())
+		}
+	case plugin.GRPC:
+		switch resp.Type {
+		case plugin.CollectorPluginType:
+			c, err = client.NewCollectorGrpcClient(addr, DefaultTimeout, resp.PublicKey, secure)
+		case plugin.ProcessorPluginType:
+			c, err = client.NewProcessorGrpcClient(addr, DefaultTimeout, resp.PublicKey, secure)
+		case plugin.PublisherPluginType:
+			c, err = client.NewPublisherGrpcClient(addr, DefaultTimeout, resp.PublicKey, secure)
+		default:
+			return nil, errors.New("Cannot create a client for a plugin of the type: " + resp.Type.String())
+		}
+	default:
+		return nil, errors.New("Invalid RPCTYPE")
+	}
+	if err != nil {
+		return nil, errors.New("error while creating client connection: " + err.Error())
+	}
+	if ts, ok := c.(client.TokenSetter); ok {
+		ts.SetToken(resp.Token)
+	}
+	if vs, ok := c.(client.RPCVersionSetter); ok {
+		vs.SetRPCVersion(resp.RPCVersion)
+	}
+	if cs, ok := c.(client.ContentTypeSetter); ok {
+		cs.SetContentType(resp.ContentType)
+	}
+	if es, ok := c.(client.ContentEncodingSetter); ok {
+		es.SetContentEncoding(plugin.SelectContentEncoding(resp.ContentEncodings))
+	}
+	return c, nil
+}
+
+// ping pings the session every interval until stop is closed
+func (mc *MockControl) ping(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-mc.done:
+			return
+		case <-ticker.C:
+			mc.client.Ping()
+		}
+	}
+}
+
+// StopPinging stops the heartbeat of the session, which ends once it has
+// missed PingTimeoutLimit pings
+func (mc *MockControl) StopPinging() {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	if mc.stopPing != nil {
+		close(mc.stopPing)
+		mc.stopPing = nil
+	}
+}
+
+// Client returns the client of the plugin, for the calls MockControl does
+// not wrap
+func (mc *MockControl) Client() client.PluginClient {
+	return mc.client
+}
+
+// check returns the error of a call to a plugin of type t, nil when it
+// can be made
+func (mc *MockControl) check(t plugin.PluginType) error {
+	select {
+	case <-mc.done:
+		// The listener is closed but a connection kept alive would
+		// still be served
+		return ErrExited
+	default:
+	}
+	if mc.Response.Type != t {
+		return ErrUnsupportedCall
+	}
+	return nil
+}
+
+// Ping pings the session
+func (mc *MockControl) Ping() error {
+	if err := mc.check(mc.Response.Type); err != nil {
+		return err
+	}
+	return mc.client.Ping()
+}
+
+// Kill stops pinging the session and ends it with reason
+func (mc *MockControl) Kill(reason string) error {
+	mc.StopPinging()
+	if err := mc.check(mc.Response.Type); err != nil {
+		return err
+	}
+	return mc.client.Kill(reason)
+}
+
+// GetConfigPolicy returns the config policy of the plugin
+func (mc *MockControl) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	if err := mc.check(mc.Response.Type); err != nil {
+		return nil, err
+	}
+	return mc.client.GetConfigPolicy()
+}
+
+// GetMetricTypes returns the catalog of a collector
+func (mc *MockControl) GetMetricTypes(cfg plugin.ConfigType) ([]core.Metric, error) {
+	if err := mc.check(plugin.CollectorPluginType); err != nil {
+		return nil, err
+	}
+	return mc.client.(client.PluginCollectorClient).GetMetricTypes(cfg)
+}
+
+// CollectMetrics collects mts from a collector
+func (mc *MockControl) CollectMetrics(mts []core.Metric) ([]core.Metric, error) {
+	if err := mc.check(plugin.CollectorPluginType); err != nil {
+		return nil, err
+	}
+	return mc.client.(client.PluginCollectorClient).CollectMetrics(mts)
+}
+
+// Process processes mts with a processor
+func (mc *MockControl) Process(mts []core.Metric, config map[string]ctypes.ConfigValue) ([]core.Metric, error) {
+	if err := mc.check(plugin.ProcessorPluginType); err != nil {
+		return nil, err
+	}
+	return mc.client.(client.PluginProcessorClient).Process(mts, config)
+}
+
+// Publish publishes mts with a publisher
+func (mc *MockControl) Publish(mts []core.Metric, config map[string]ctypes.ConfigValue) error {
+	if err := mc.check(plugin.PublisherPluginType); err != nil {
+		return err
+	}
+	return mc.client.(client.PluginPublisherClient).Publish(mts, config)
+}
+
+// Done is closed once the session has ended
+func (mc *MockControl) Done() <-chan struct{} {
+	return mc.done
+}
+
+// Wait waits up to timeout for the session to end and returns the error
+// and exit code of plugin.Start, or ErrTimeout.
+func (mc *MockControl) Wait(timeout time.Duration) (int, error) {
+	select {
+	case <-mc.done:
+		return mc.code, mc.err
+	case <-time.After(timeout):
+		return 0, ErrTimeout
+	}
+}
@@ -0,0 +1,82 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugintest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin"
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/core"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// echoProcessor returns the metrics it is sent
+type echoProcessor struct{}
+
+func (echoProcessor) Process(contentType string, content []byte, config map[string]ctypes.ConfigValue) (string, []byte, error) {
+	return contentType, content, nil
+}
+
+func (echoProcessor) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	return cpolicy.New(), nil
+}
+
+func TestMockControl(t *testing.T) {
+	Convey("A MockControl", t, func() {
+		meta := plugin.NewPluginMeta("echo", 1, plugin.ProcessorPluginType, []string{plugin.SnapGOBContentType}, []string{plugin.SnapGOBContentType})
+		arg := plugin.NewArg(int(0))
+		arg.KillDelay = 10 * time.Millisecond
+
+		Convey("returns the error of a plugin which fails to start", func() {
+			arg.Codec = "bogus"
+			mc, err := Start(meta, echoProcessor{}, arg)
+			So(mc, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, plugin.ErrUnsupportedCodec.Error())
+		})
+
+		Convey("calls a secure processor as control does", func() {
+			mc, err := Start(meta, echoProcessor{}, arg)
+			So(err, ShouldBeNil)
+			So(mc.Response.Type, ShouldEqual, plugin.ProcessorPluginType)
+
+			mts := []core.Metric{plugin.MetricType{Namespace_: core.NewNamespace("a", "b"), Data_: 1}}
+			out, err := mc.Process(mts, nil)
+			So(err, ShouldBeNil)
+			So(len(out), ShouldEqual, 1)
+			So(out[0].Data(), ShouldEqual, 1)
+
+			_, err = mc.CollectMetrics(mts)
+			So(err, ShouldEqual, ErrUnsupportedCall)
+
+			So(mc.Kill("test"), ShouldBeNil)
+			code, err := mc.Wait(time.Second)
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 0)
+			_, err = mc.Process(mts, nil)
+			So(err, ShouldEqual, ErrExited)
+		})
+	})
+}
@@ -0,0 +1,108 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultCallQueueLength is how many calls may wait for a worker when
+// Arg.CallQueueLength is not set.
+const DefaultCallQueueLength = 64
+
+// ErrBusy is returned by a call made while every worker is busy and the
+// queue is full.
+var ErrBusy error = &PluginError{Code: ErrorCodeBusy, Message: "all workers are busy and the call queue is full"}
+
+// workerPool bounds the calls served at once to its workers, with at most
+// queueLength more calls waiting for one.  The calls beyond fail with
+// ErrBusy.  A nil workerPool takes any number of calls.
+type workerPool struct {
+	workers chan struct{}
+	// queueLength is the most calls waiting, or unbounded when negative
+	queueLength int
+
+	mutex    sync.Mutex
+	waiting  int
+	rejected uint64
+}
+
+func newWorkerPool(workers, queueLength int) *workerPool {
+	return &workerPool{workers: make(chan struct{}, workers), queueLength: queueLength}
+}
+
+// acquire takes a worker, waiting in the queue while all are busy.  A call
+// still waiting when ctx is done fails with ErrDeadlineExceeded, or with
+// ErrSessionDraining when the session was killed.
+func (p *workerPool) acquire(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	select {
+	case p.workers <- struct{}{}:
+		return nil
+	default:
+	}
+	p.mutex.Lock()
+	if p.queueLength >= 0 && p.waiting >= p.queueLength {
+		p.rejected++
+		p.mutex.Unlock()
+		return ErrBusy
+	}
+	p.waiting++
+	p.mutex.Unlock()
+
+	var err error
+	select {
+	case p.workers <- struct{}{}:
+	case <-ctx.Done():
+		err = ErrSessionDraining
+		if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+			err = ErrDeadlineExceeded
+		}
+	}
+
+	p.mutex.Lock()
+	p.waiting--
+	p.mutex.Unlock()
+	return err
+}
+
+// release frees the worker taken by acquire
+func (p *workerPool) release() {
+	if p == nil {
+		return
+	}
+	<-p.workers
+}
+
+// counts returns the number of calls waiting for a worker and of the calls
+// rejected with ErrBusy.
+func (p *workerPool) counts() (waiting int, rejected uint64) {
+	if p == nil {
+		return 0, 0
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.waiting, p.rejected
+}
@@ -0,0 +1,145 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"net/rpc"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWorkerPool(t *testing.T) {
+	Convey("A workerPool", t, func() {
+		ctx := context.Background()
+		p := newWorkerPool(1, 1)
+		So(p.acquire(ctx), ShouldBeNil)
+		queued := make(chan error, 1)
+		go func() { queued <- p.acquire(ctx) }()
+		for {
+			if waiting, _ := p.counts(); waiting == 1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		So(p.acquire(ctx), ShouldEqual, ErrBusy)
+		waiting, rejected := p.counts()
+		So(waiting, ShouldEqual, 1)
+		So(rejected, ShouldEqual, 1)
+		p.release()
+		So(<-queued, ShouldBeNil)
+		p.release()
+		waiting, _ = p.counts()
+		So(waiting, ShouldEqual, 0)
+
+		Convey("gives up waiting at the deadline", func() {
+			So(p.acquire(ctx), ShouldBeNil)
+			defer p.release()
+			dctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+			defer cancel()
+			So(p.acquire(dctx), ShouldEqual, ErrDeadlineExceeded)
+			waiting, _ := p.counts()
+			So(waiting, ShouldEqual, 0)
+		})
+		Convey("gives up waiting when the session is killed", func() {
+			So(p.acquire(ctx), ShouldBeNil)
+			defer p.release()
+			kctx, cancel := context.WithCancel(ctx)
+			go cancel()
+			So(p.acquire(kctx), ShouldEqual, ErrSessionDraining)
+		})
+	})
+	Convey("A nil workerPool takes any call", t, func() {
+		ctx := context.Background()
+		var p *workerPool
+		So(p.acquire(ctx), ShouldBeNil)
+		p.release()
+	})
+	Convey("The workers of a session", t, func() {
+		m := NewPluginMeta("pool", 1, CollectorPluginType, nil, nil, Unsecure(true), ConcurrencyCount(2))
+		a := &Arg{}
+		So(a.workers(m), ShouldEqual, 2)
+		a.Workers = 4
+		So(a.workers(m), ShouldEqual, 4)
+		So(a.workers(NewPluginMeta("pool", 1, CollectorPluginType, nil, nil, Exclusive(true))), ShouldEqual, 1)
+	})
+}
+
+func TestWorkerPoolLoad(t *testing.T) {
+	Convey("A collector flooded with more calls than workers and queue", t, func() {
+		const (
+			calls    = 100
+			admitted = 2 + 3
+		)
+		m := NewPluginMeta("pool", 1, CollectorPluginType, nil, nil, Unsecure(true), ConcurrencyCount(2))
+		c := &stuckCollector{release: make(chan struct{})}
+		resp, done := startTestPlugin(m, c, fmt.Sprintf(`{"PingTimeoutDuration": %d, "CallQueueLength": 3, "KillDelay": 1}`, time.Minute))
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer client.Close()
+		enc := encoding.NewGobEncoder()
+		stats := func() SessionStats {
+			in, err := enc.Encode(StatsArgs{Token: resp.Token})
+			So(err, ShouldBeNil)
+			var out []byte
+			So(client.Call("SessionState.GetStats", in, &out), ShouldBeNil)
+			var st SessionStats
+			So(enc.Decode(out, &st), ShouldBeNil)
+			return st
+		}
+
+		in, err := enc.Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: resp.Token})
+		So(err, ShouldBeNil)
+		before := runtime.NumGoroutine()
+		errs := make(chan error, calls)
+		for i := 0; i < calls; i++ {
+			go func() {
+				errs <- client.Call("Collector.CollectMetrics", in, &[]byte{})
+			}()
+		}
+		// The calls beyond the workers and queue are rejected while the
+		// admitted ones are still stuck
+		for i := 0; i < calls-admitted; i++ {
+			So(ErrorCodeOf(<-errs), ShouldEqual, ErrorCodeBusy)
+		}
+		So(runtime.NumGoroutine()-before, ShouldBeLessThan, 4*admitted)
+		st := stats()
+		So(st.QueueDepth, ShouldEqual, 3)
+		So(st.Rejected, ShouldEqual, calls-admitted)
+
+		close(c.release)
+		for i := 0; i < admitted; i++ {
+			So(<-errs, ShouldBeNil)
+		}
+		st = stats()
+		So(st.QueueDepth, ShouldEqual, 0)
+		So(st.Rejected, ShouldEqual, calls-admitted)
+		So(callKill(client, resp.Token), ShouldBeNil)
+		<-done
+	})
+}
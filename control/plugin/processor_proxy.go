@@ -20,9 +20,13 @@ limitations under the License.
 package plugin
 
 import (
-	"errors"
+	"encoding/json"
 	"fmt"
+	"time"
 
+	"golang.org/x/net/context"
+
+	"github.com/intelsdi-x/snap/core/cdata"
 	"github.com/intelsdi-x/snap/core/ctypes"
 )
 
@@ -31,32 +35,136 @@ type ProcessorArgs struct {
 	ContentType string
 	Content     []byte
 	Config      map[string]ctypes.ConfigValue
+	Token       string
+	// ContentEncoding is the compression of Content, see ContentEncodings
+	ContentEncoding string `json:",omitempty"`
+	// RequestID identifies the call, see CollectMetricsArgs.RequestID
+	RequestID string `json:",omitempty"`
+	// Deadline of the call, see CollectMetricsArgs.Deadline
+	Deadline time.Time
+	// Pipeline locates the processor in the workflow, see PipelineInfo
+	Pipeline PipelineInfo
+}
+
+// UnmarshalJSON restores the typed config values when ProcessorArgs are
+// received over JSON-RPC.
+func (p *ProcessorArgs) UnmarshalJSON(data []byte) error {
+	args := struct {
+		ContentType string
+		Content     []byte
+		Config      *cdata.ConfigDataNode
+		Token       string
+
+		ContentEncoding string
+		RequestID       string
+		Deadline        time.Time
+		Pipeline        PipelineInfo
+	}{}
+	if err := json.Unmarshal(data, &args); err != nil {
+		return err
+	}
+	p.ContentType = args.ContentType
+	p.Content = args.Content
+	p.Token = args.Token
+	p.ContentEncoding = args.ContentEncoding
+	p.RequestID = args.RequestID
+	p.Deadline = args.Deadline
+	p.Pipeline = args.Pipeline
+	if args.Config != nil {
+		p.Config = args.Config.Table()
+	}
+	return nil
 }
 
 type ProcessorReply struct {
 	ContentType string
 	Content     []byte
+	// ContentEncoding is the compression of Content, see ContentEncodings
+	ContentEncoding string `json:",omitempty"`
+	// RequestID is the RequestID of the args
+	RequestID string `json:",omitempty"`
 }
 
 type processorPluginProxy struct {
 	Plugin  ProcessorPlugin
 	Session Session
+	Meta    *PluginMeta
+	// config holds the config set with SetConfig
+	config *configStore
+	// compressor compresses large replies
+	compressor *contentCompressor
+	// suspension fails processing while the session is suspended
+	suspension *suspension
+	// init fails processing until the plugin's Init has succeeded
+	init *initialization
+	// requests follows the request IDs of the calls served
+	requests *requestTracker
+	// base is the parent context of the calls
+	base *sessionContext
 }
 
-func (p *processorPluginProxy) Process(args []byte, reply *[]byte) error {
-	defer catchPluginPanic(p.Session.Logger())
-	p.Session.ResetHeartbeat()
+func (p *processorPluginProxy) Process(args []byte, reply *[]byte) (err error) {
+	call := p.requests.start("Processor.Process")
+	defer call.end(&err)
+	defer p.Session.recoverPanic("Processor.Process", &err)
 
 	dargs := &ProcessorArgs{}
-	err := p.Session.Decode(args, dargs)
+	err = p.Session.Decode(args, dargs)
 	if err != nil {
 		return err
 	}
+	call.setID(dargs.RequestID)
+	if err := p.Session.CheckToken(dargs.Token); err != nil {
+		return err
+	}
+	p.Session.ResetHeartbeat()
+	if err := p.suspension.check(); err != nil {
+		return err
+	}
+	if err := p.init.check(); err != nil {
+		return err
+	}
+	ctx, cancel := p.base.callContext(dargs.RequestID, dargs.Deadline)
+	defer cancel()
+	ctx = withPipelineInfo(ctx, dargs.Pipeline)
+	if err := checkDeadline(ctx); err != nil {
+		return err
+	}
+	if err := call.beginCall(ctx, p.Session); err != nil {
+		return err
+	}
+	defer p.Session.endCall()
 
-	r := ProcessorReply{}
-	r.ContentType, r.Content, err = p.Plugin.Process(dargs.ContentType, dargs.Content, dargs.Config)
+	if err := checkContentType(p.Meta, dargs.ContentType); err != nil {
+		return err
+	}
+	content, err := decompressArgs(dargs.ContentEncoding, dargs.Content)
+	if err != nil {
+		return err
+	}
+	openConfig(dargs.Config, p.Session.decrypter())
+	r := ProcessorReply{RequestID: dargs.RequestID}
+	config := p.config.merge(dargs.Config)
+	err = callWithDeadline(ctx, p.Session, "Processor.Process", func(ctx context.Context) error {
+		var err error
+		if cp, ok := p.Plugin.(ContextProcessor); ok {
+			r.ContentType, r.Content, err = cp.ProcessContext(ctx, dargs.ContentType, content, config)
+		} else {
+			r.ContentType, r.Content, err = p.Plugin.Process(dargs.ContentType, content, config)
+		}
+		if err != nil {
+			return &PluginError{Code: ErrorCodeCallFailed, Message: fmt.Sprintf("Processor call error: %v", err.Error())}
+		}
+		return nil
+	})
 	if err != nil {
-		return errors.New(fmt.Sprintf("Processor call error: %v", err.Error()))
+		return err
+	}
+	if r.ContentType, err = returnedContentType(p.Meta, dargs.ContentType, r.ContentType); err != nil {
+		return err
+	}
+	if r.Content, r.ContentEncoding, err = p.compressor.compress(r.Content); err != nil {
+		return err
 	}
 
 	*reply, err = p.Session.Encode(r)
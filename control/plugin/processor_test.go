@@ -22,12 +22,16 @@ limitations under the License.
 package plugin
 
 import (
-	"log"
 	"testing"
 	"time"
 
 	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	"github.com/intelsdi-x/snap/core"
 	"github.com/intelsdi-x/snap/core/ctypes"
+	"golang.org/x/net/context"
+
+	"github.com/Sirupsen/logrus"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -44,13 +48,40 @@ func (f *MockProcessor) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
 	return &cpolicy.ConfigPolicy{}, nil
 }
 
+// passthruProcessor returns the content it is given untouched.
+type passthruProcessor struct {
+	MockProcessor
+}
+
+func (p *passthruProcessor) Process(contentType string, content []byte, config map[string]ctypes.ConfigValue) (string, []byte, error) {
+	return contentType, content, nil
+}
+
+// renameProcessor prefixes every namespace with "renamed" and returns the
+// metrics as JSON.
+type renameProcessor struct {
+	MockProcessor
+}
+
+func (p *renameProcessor) Process(contentType string, content []byte, config map[string]ctypes.ConfigValue) (string, []byte, error) {
+	mts, err := UnmarshallMetricTypes(contentType, content)
+	if err != nil {
+		return "", nil, err
+	}
+	for i := range mts {
+		mts[i].Namespace_ = append(core.NewNamespace("renamed"), mts[i].Namespace_...)
+	}
+	b, ct, err := MarshalMetricTypes(SnapJSONContentType, mts)
+	return ct, b, err
+}
+
 type MockProcessorSessionState struct {
 	PingTimeoutDuration time.Duration
 	Daemon              bool
 	listenAddress       string
 	listenPort          string
 	token               string
-	logger              *log.Logger
+	logger              Logger
 	killChan            chan int
 }
 
@@ -63,7 +94,7 @@ func (s *MockProcessorSessionState) Kill(arg KillArgs, b *bool) error {
 	return nil
 }
 
-func (s *MockProcessorSessionState) Logger() *log.Logger {
+func (s *MockProcessorSessionState) Logger() Logger {
 	return s.logger
 }
 
@@ -91,33 +122,112 @@ func (s *MockProcessorSessionState) KillChan() chan int {
 	return s.killChan
 }
 
+// Done is closed once Kill or heartbeatWatch has signaled killChan
+func (s *MockProcessorSessionState) Done() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		<-s.killChan
+		close(done)
+	}()
+	return done
+}
+
+func (s *MockProcessorSessionState) ShutdownReason() Shutdown {
+	return Shutdown{}
+}
+
 func (s *MockProcessorSessionState) isDaemon() bool {
 	return !s.Daemon
 }
 
-func (s *MockProcessorSessionState) generateResponse(r *Response) []byte {
-	return []byte("mockResponse")
+func (s *MockProcessorSessionState) generateResponse(r *Response) ([]byte, error) {
+	return []byte("mockResponse"), nil
 }
 
-func (s *MockProcessorSessionState) heartbeatWatch(killChan chan int) {
+func (s *MockProcessorSessionState) heartbeatWatch() {
 	time.Sleep(time.Millisecond * 200)
-	killChan <- 0
+	s.killChan <- 0
+}
+
+func (s *MockProcessorSessionState) stopHeartbeat() bool {
+	return true
 }
 
+func (s *MockProcessorSessionState) recordCall(string, time.Duration, error) {}
+
+func (s *MockProcessorSessionState) beginCall(context.Context) error {
+	return nil
+}
+
+func (s *MockProcessorSessionState) endCall() {}
+
+func (s *MockProcessorSessionState) drain() (bool, int) {
+	return true, 0
+}
+
+func (s *MockProcessorSessionState) scheduleKill(string) {}
+
+func (s *MockProcessorSessionState) runOnKill(KillReason) {}
+
+func (s *MockProcessorSessionState) recoverPanic(string, *error) {}
+
 func TestStartProcessor(t *testing.T) {
 	Convey("Processor", t, func() {
 		Convey("start with dynamic port", func() {
 			c := new(MockProcessor)
 			m := &PluginMeta{
+				Name:    "mock",
+				Version: 1,
 				RPCType: JSONRPC,
 				Type:    ProcessorPluginType,
 			}
-			// we will panic since rpc.HandleHttp has already
-			// been called during TestStartCollector
-			Convey("RPC service already registered", func() {
-				So(func() { Start(m, c, "{}") }, ShouldPanic)
-			})
+			err, rc := Start(m, c, `{"NoDaemon": true}`)
+			So(err, ShouldBeNil)
+			So(rc, ShouldEqual, 0)
 
 		})
 	})
 }
+
+func TestProcessorProxy(t *testing.T) {
+	Convey("Test processor plugin proxy", t, func() {
+		session := &MockSessionState{
+			Encoder:  encoding.NewGobEncoder(),
+			logger:   logrus.New(),
+			killChan: make(chan int),
+		}
+		mts := []MetricType{
+			*NewMetricType(core.NewNamespace("foo", "bar"), time.Now(), nil, "", 1),
+			*NewMetricType(core.NewNamespace("foo", "baz"), time.Now(), nil, "", 2),
+		}
+		content, ct, err := MarshalMetricTypes(SnapGOBContentType, mts)
+		So(err, ShouldBeNil)
+		args, err := session.Encode(ProcessorArgs{ContentType: ct, Content: content})
+		So(err, ShouldBeNil)
+
+		Convey("Passthru processor", func() {
+			p := &processorPluginProxy{Plugin: &passthruProcessor{}, Session: session}
+			var reply []byte
+			err := p.Process(args, &reply)
+			So(err, ShouldBeNil)
+			r := ProcessorReply{}
+			So(session.Decode(reply, &r), ShouldBeNil)
+			So(r.ContentType, ShouldEqual, SnapGOBContentType)
+			So(r.Content, ShouldResemble, content)
+		})
+		Convey("Processor changing namespaces and content type", func() {
+			p := &processorPluginProxy{Plugin: &renameProcessor{}, Session: session}
+			var reply []byte
+			err := p.Process(args, &reply)
+			So(err, ShouldBeNil)
+			r := ProcessorReply{}
+			So(session.Decode(reply, &r), ShouldBeNil)
+			So(r.ContentType, ShouldEqual, SnapJSONContentType)
+			out, err := UnmarshallMetricTypes(r.ContentType, r.Content)
+			So(err, ShouldBeNil)
+			So(len(out), ShouldEqual, 2)
+			So(out[0].Namespace().String(), ShouldEqual, "/renamed/foo/bar")
+			So(out[1].Namespace().String(), ShouldEqual, "/renamed/foo/baz")
+		})
+	})
+}
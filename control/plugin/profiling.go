@@ -0,0 +1,251 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProfilingAddr is the address the profiling server binds to when
+// Arg.ProfilingAddr is not set
+const DefaultProfilingAddr = "127.0.0.1"
+
+// ErrProfilingNotLoopback is returned when Arg.ProfilingAddr is not a
+// loopback address and Arg.ProfilingAllowRemote is not set
+var ErrProfilingNotLoopback = errors.New("profiling address is not a loopback address")
+
+// profiler serves the pprof endpoints for the session, see
+// Arg.EnableProfiling
+type profiler struct {
+	l      net.Listener
+	server *http.Server
+	once   sync.Once
+
+	// connsMutex guards conns, the connections open on server
+	connsMutex sync.Mutex
+	conns      map[net.Conn]struct{}
+	closed     bool
+}
+
+// checkProfilingAddr fails unless addr is a loopback address or remote
+// profiling is allowed
+func checkProfilingAddr(addr string, allowRemote bool) error {
+	if allowRemote || addr == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(addr); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return ErrProfilingNotLoopback
+}
+
+// startProfiler serves the pprof handlers at addr and port, nil when arg
+// does not set EnableProfiling
+func startProfiler(arg *Arg) (*profiler, error) {
+	if !arg.EnableProfiling {
+		return nil, nil
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(arg.ProfilingAddr, arg.ProfilingPort))
+	if err != nil {
+		return nil, err
+	}
+	// The handlers are registered on a private mux; importing net/http/pprof
+	// would add them to http.DefaultServeMux of the plugin as well.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprofIndex)
+	mux.HandleFunc("/debug/pprof/cmdline", pprofCmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprofProfile)
+	mux.HandleFunc("/debug/pprof/symbol", pprofSymbol)
+	mux.HandleFunc("/debug/pprof/trace", pprofTrace)
+	p := &profiler{l: l, conns: map[net.Conn]struct{}{}}
+	p.server = &http.Server{Handler: mux, ConnState: p.trackConn}
+	p.server.SetKeepAlivesEnabled(false)
+	go p.server.Serve(l)
+	return p, nil
+}
+
+// trackConn records the connections open on the server so close can stop
+// the requests in flight
+func (p *profiler) trackConn(c net.Conn, state http.ConnState) {
+	p.connsMutex.Lock()
+	defer p.connsMutex.Unlock()
+	switch state {
+	case http.StateNew:
+		if p.closed {
+			c.Close()
+			return
+		}
+		p.conns[c] = struct{}{}
+	case http.StateHijacked, http.StateClosed:
+		delete(p.conns, c)
+	}
+}
+
+// port returns the port the profiler listens on, 0 when profiling is off
+func (p *profiler) port() int {
+	if p == nil {
+		return 0
+	}
+	_, port, _ := net.SplitHostPort(p.l.Addr().String())
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// close stops the profiler, releases its port and drops the requests in
+// flight, such as a CPU profile or trace still being taken
+func (p *profiler) close() {
+	if p == nil {
+		return
+	}
+	p.once.Do(func() {
+		p.l.Close()
+		p.connsMutex.Lock()
+		defer p.connsMutex.Unlock()
+		p.closed = true
+		for c := range p.conns {
+			c.Close()
+			delete(p.conns, c)
+		}
+	})
+}
+
+// setProfiler hands p to the session, which stops it as it ends.  A session
+// which already ended stops it at once.
+func (s *SessionState) setProfiler(p *profiler) {
+	s.profilerMutex.Lock()
+	defer s.profilerMutex.Unlock()
+	s.profiler = p
+	select {
+	case <-s.Done():
+		p.close()
+	default:
+	}
+}
+
+// pprofIndex serves the profile named by the path, such as
+// /debug/pprof/heap, or lists the profiles
+func pprofIndex(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/")
+	if name == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, prof := range pprof.Profiles() {
+			fmt.Fprintf(w, "%d\t%s\n", prof.Count(), prof.Name())
+		}
+		return
+	}
+	prof := pprof.Lookup(name)
+	if prof == nil {
+		http.Error(w, "unknown profile: "+name, http.StatusNotFound)
+		return
+	}
+	debug, _ := strconv.Atoi(r.FormValue("debug"))
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	prof.WriteTo(w, debug)
+}
+
+// pprofCmdline serves the command line of the plugin, NUL separated
+func pprofCmdline(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, strings.Join(os.Args, "\x00"))
+}
+
+// pprofProfile serves a CPU profile taken over the seconds parameter,
+// 30 seconds by default
+func pprofProfile(w http.ResponseWriter, r *http.Request) {
+	sec, _ := strconv.ParseInt(r.FormValue("seconds"), 10, 64)
+	if sec <= 0 {
+		sec = 30
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := pprof.StartCPUProfile(w); err != nil {
+		http.Error(w, "could not enable CPU profiling: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	time.Sleep(time.Duration(sec) * time.Second)
+	pprof.StopCPUProfile()
+}
+
+// pprofTrace serves an execution trace taken over the seconds parameter,
+// 1 second by default
+func pprofTrace(w http.ResponseWriter, r *http.Request) {
+	sec, _ := strconv.ParseFloat(r.FormValue("seconds"), 64)
+	if sec <= 0 {
+		sec = 1
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := trace.Start(w); err != nil {
+		http.Error(w, "could not enable tracing: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	time.Sleep(time.Duration(sec * float64(time.Second)))
+	trace.Stop()
+}
+
+// pprofSymbol maps the program counters posted as "0x1234+0x5678" to
+// function names, as go tool pprof expects
+func pprofSymbol(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	var buf bytes.Buffer
+	// A non-zero count tells pprof that symbols are available
+	fmt.Fprintf(&buf, "num_symbols: 1\n")
+	var b *bufio.Reader
+	if r.Method == "POST" {
+		b = bufio.NewReader(r.Body)
+	} else {
+		b = bufio.NewReader(strings.NewReader(r.URL.RawQuery))
+	}
+	for {
+		word, err := b.ReadSlice('+')
+		if err == nil {
+			word = word[:len(word)-1]
+		}
+		pc, _ := strconv.ParseUint(string(word), 0, 64)
+		if pc != 0 {
+			if f := runtime.FuncForPC(uintptr(pc)); f != nil {
+				fmt.Fprintf(&buf, "%#x %s\n", pc, f.Name())
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintf(&buf, "reading request: %v\n", err)
+			}
+			break
+		}
+	}
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,133 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestProfiling(t *testing.T) {
+	kill := func(resp Response, done chan int) {
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer client.Close()
+		So(callKill(client, resp.Token), ShouldBeNil)
+		So(<-done, ShouldEqual, 0)
+	}
+
+	Convey("A plugin session", t, func() {
+		Convey("does not serve pprof by default", func() {
+			resp, done := startTestCollector(fmt.Sprintf(`{"PingTimeoutDuration": %d, "KillDelay": %d}`, time.Minute, time.Millisecond))
+			So(resp.State, ShouldEqual, PluginSuccess)
+			So(resp.ProfilingPort, ShouldEqual, 0)
+			kill(resp, done)
+		})
+		Convey("started with EnableProfiling", func() {
+			resp, done := startTestCollector(fmt.Sprintf(`{"EnableProfiling": true, "PingTimeoutDuration": %d, "KillDelay": %d}`, time.Minute, time.Millisecond))
+			So(resp.State, ShouldEqual, PluginSuccess)
+			So(resp.ProfilingPort, ShouldBeGreaterThan, 0)
+			addr := net.JoinHostPort(DefaultProfilingAddr, strconv.Itoa(resp.ProfilingPort))
+
+			Convey("serves the heap profile on loopback", func() {
+				res, err := http.Get("http://" + addr + "/debug/pprof/heap")
+				So(err, ShouldBeNil)
+				body, err := ioutil.ReadAll(res.Body)
+				res.Body.Close()
+				So(err, ShouldBeNil)
+				So(res.StatusCode, ShouldEqual, http.StatusOK)
+				So(body, ShouldNotBeEmpty)
+
+				if ip := externalIP(); ip != nil {
+					_, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(resp.ProfilingPort)), time.Second)
+					So(err, ShouldNotBeNil)
+				}
+				kill(resp, done)
+			})
+			Convey("leaves http.DefaultServeMux alone", func() {
+				_, pattern := http.DefaultServeMux.Handler(&http.Request{Method: "GET", URL: &url.URL{Path: "/debug/pprof/"}})
+				So(pattern, ShouldBeEmpty)
+				kill(resp, done)
+			})
+			Convey("drops a profile in flight once killed", func() {
+				errc := make(chan error, 1)
+				go func() {
+					res, err := http.Get("http://" + addr + "/debug/pprof/profile?seconds=5")
+					if err == nil {
+						_, err = ioutil.ReadAll(res.Body)
+						res.Body.Close()
+					}
+					errc <- err
+				}()
+				// Let the request reach the handler before killing
+				time.Sleep(200 * time.Millisecond)
+				kill(resp, done)
+				select {
+				case err := <-errc:
+					So(err, ShouldNotBeNil)
+				case <-time.After(3 * time.Second):
+					So("profile request still running", ShouldBeEmpty)
+				}
+			})
+			Convey("releases the port once killed", func() {
+				kill(resp, done)
+				_, err := http.Get("http://" + addr + "/debug/pprof/heap")
+				So(err, ShouldNotBeNil)
+				l, err := net.Listen("tcp", addr)
+				So(err, ShouldBeNil)
+				l.Close()
+			})
+		})
+		Convey("fails to start when the profiling port is in use", func() {
+			l, err := net.Listen("tcp", net.JoinHostPort(DefaultProfilingAddr, "0"))
+			So(err, ShouldBeNil)
+			defer l.Close()
+			port := strconv.Itoa(listenerPort(l))
+			resp, done := startTestCollector(fmt.Sprintf(`{"EnableProfiling": true, "ProfilingPort": %q}`, port))
+			So(resp.State, ShouldEqual, PluginFailure)
+			So(resp.ErrorCode, ShouldEqual, ErrorCodeBindFailed)
+			So(resp.ErrorFields["address"], ShouldEqual, net.JoinHostPort(DefaultProfilingAddr, port))
+			So(<-done, ShouldEqual, 2)
+		})
+		Convey("refuses to profile on an address other than loopback", func() {
+			m := NewPluginMeta("mock", 1, CollectorPluginType, nil, nil, Unsecure(true))
+			err, rc := Start(m, new(MockPlugin), `{"NoDaemon": true, "EnableProfiling": true, "ProfilingAddr": "0.0.0.0"}`)
+			So(err, ShouldEqual, ErrProfilingNotLoopback)
+			So(rc, ShouldEqual, 2)
+		})
+		Convey("profiles on any address when forced", func() {
+			resp, done := startTestCollector(fmt.Sprintf(`{"EnableProfiling": true, "ProfilingAddr": "0.0.0.0", "ProfilingAllowRemote": true, "PingTimeoutDuration": %d, "KillDelay": %d}`, time.Minute, time.Millisecond))
+			So(resp.State, ShouldEqual, PluginSuccess)
+			So(resp.ProfilingPort, ShouldBeGreaterThan, 0)
+			kill(resp, done)
+		})
+	})
+}
@@ -0,0 +1,213 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/intelsdi-x/snap/core"
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+// encodeProtobufMetrics encodes metrics as a MetricBatch.  It fails for data
+// of a type which has no field in MetricMessage.
+func encodeProtobufMetrics(metrics []MetricType) ([]byte, error) {
+	batch := &MetricBatch{Metrics: make([]*MetricMessage, len(metrics))}
+	for i, m := range metrics {
+		pm, err := toMetricMessage(m)
+		if err != nil {
+			return nil, err
+		}
+		batch.Metrics[i] = pm
+	}
+	return proto.Marshal(batch)
+}
+
+func decodeProtobufMetrics(payload []byte) ([]MetricType, error) {
+	batch := &MetricBatch{}
+	if err := proto.Unmarshal(payload, batch); err != nil {
+		return nil, err
+	}
+	metrics := make([]MetricType, len(batch.Metrics))
+	for i, pm := range batch.Metrics {
+		metrics[i] = fromMetricMessage(pm)
+	}
+	return metrics, nil
+}
+
+func toMetricMessage(m MetricType) (*MetricMessage, error) {
+	pm := &MetricMessage{
+		Namespace:          make([]*NamespaceElementMessage, len(m.Namespace_)),
+		Version:            int64(m.Version_),
+		LastAdvertisedTime: toTimeMessage(m.LastAdvertisedTime_),
+		Tags:               m.Tags_,
+		Timestamp:          toTimeMessage(m.Timestamp_),
+		Unit:               m.Unit_,
+		Description:        m.Description_,
+	}
+	for i, e := range m.Namespace_ {
+		pm.Namespace[i] = &NamespaceElementMessage{Value: e.Value, Description: e.Description, Name: e.Name}
+	}
+	if m.Config_ != nil {
+		cm, err := toConfigMessage(m.Config_)
+		if err != nil {
+			return nil, fmt.Errorf("metric %s: %v", m.Namespace_, err)
+		}
+		pm.Config = cm
+	}
+	switch v := m.Data_.(type) {
+	case nil:
+	case string:
+		pm.Data = &MetricMessage_StringData{v}
+	case []byte:
+		pm.Data = &MetricMessage_BytesData{v}
+	case bool:
+		pm.Data = &MetricMessage_BoolData{v}
+	case float32:
+		pm.Data = &MetricMessage_Float32Data{v}
+	case float64:
+		pm.Data = &MetricMessage_Float64Data{v}
+	case int:
+		pm.Data = &MetricMessage_IntData{int64(v)}
+	case int32:
+		pm.Data = &MetricMessage_Int32Data{v}
+	case int64:
+		pm.Data = &MetricMessage_Int64Data{v}
+	case uint:
+		pm.Data = &MetricMessage_UintData{uint64(v)}
+	case uint32:
+		pm.Data = &MetricMessage_Uint32Data{v}
+	case uint64:
+		pm.Data = &MetricMessage_Uint64Data{v}
+	default:
+		return nil, fmt.Errorf("metric %s: data of type %T cannot be encoded as %s", m.Namespace_, v, SnapProtoBufContentType)
+	}
+	return pm, nil
+}
+
+func fromMetricMessage(pm *MetricMessage) MetricType {
+	m := MetricType{
+		Namespace_:          make(core.Namespace, len(pm.Namespace)),
+		Version_:            int(pm.Version),
+		LastAdvertisedTime_: fromTimeMessage(pm.LastAdvertisedTime),
+		Tags_:               pm.Tags,
+		Timestamp_:          fromTimeMessage(pm.Timestamp),
+		Unit_:               pm.Unit,
+		Description_:        pm.Description,
+	}
+	for i, e := range pm.Namespace {
+		m.Namespace_[i] = core.NamespaceElement{Value: e.Value, Description: e.Description, Name: e.Name}
+	}
+	if pm.Config != nil {
+		m.Config_ = fromConfigMessage(pm.Config)
+	}
+	switch d := pm.Data.(type) {
+	case *MetricMessage_StringData:
+		m.Data_ = d.StringData
+	case *MetricMessage_BytesData:
+		m.Data_ = d.BytesData
+	case *MetricMessage_BoolData:
+		m.Data_ = d.BoolData
+	case *MetricMessage_Float32Data:
+		m.Data_ = d.Float32Data
+	case *MetricMessage_Float64Data:
+		m.Data_ = d.Float64Data
+	case *MetricMessage_IntData:
+		m.Data_ = int(d.IntData)
+	case *MetricMessage_Int32Data:
+		m.Data_ = d.Int32Data
+	case *MetricMessage_Int64Data:
+		m.Data_ = d.Int64Data
+	case *MetricMessage_UintData:
+		m.Data_ = uint(d.UintData)
+	case *MetricMessage_Uint32Data:
+		m.Data_ = d.Uint32Data
+	case *MetricMessage_Uint64Data:
+		m.Data_ = d.Uint64Data
+	}
+	return m
+}
+
+func toTimeMessage(t time.Time) *TimeMessage {
+	if t.IsZero() {
+		return nil
+	}
+	return &TimeMessage{Sec: t.Unix(), Nsec: int32(t.Nanosecond())}
+}
+
+func fromTimeMessage(t *TimeMessage) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return time.Unix(t.Sec, int64(t.Nsec))
+}
+
+// toConfigMessage encodes the config of a metric.  Like its JSON encoding,
+// a secure string is redacted unless it is sealed.
+func toConfigMessage(node *cdata.ConfigDataNode) (*ConfigMessage, error) {
+	table := node.Table()
+	cm := &ConfigMessage{Values: make(map[string]*ConfigValueMessage, len(table))}
+	for k, v := range table {
+		cv := &ConfigValueMessage{}
+		switch t := v.(type) {
+		case ctypes.ConfigValueInt:
+			cv.Value = &ConfigValueMessage_IntValue{int64(t.Value)}
+		case ctypes.ConfigValueFloat:
+			cv.Value = &ConfigValueMessage_FloatValue{t.Value}
+		case ctypes.ConfigValueBool:
+			cv.Value = &ConfigValueMessage_BoolValue{t.Value}
+		case ctypes.ConfigValueStr:
+			cv.Value = &ConfigValueMessage_StringValue{t.Value}
+		case ctypes.ConfigValueSecureString:
+			if t.Ciphertext == nil {
+				cv.Value = &ConfigValueMessage_StringValue{ctypes.Redacted}
+			} else {
+				cv.Value = &ConfigValueMessage_SecureValue{t.Ciphertext}
+			}
+		default:
+			return nil, fmt.Errorf("config %s of type %T cannot be encoded as %s", k, v, SnapProtoBufContentType)
+		}
+		cm.Values[k] = cv
+	}
+	return cm, nil
+}
+
+func fromConfigMessage(cm *ConfigMessage) *cdata.ConfigDataNode {
+	node := cdata.NewNode()
+	for k, cv := range cm.Values {
+		switch v := cv.Value.(type) {
+		case *ConfigValueMessage_IntValue:
+			node.AddItem(k, ctypes.ConfigValueInt{Value: int(v.IntValue)})
+		case *ConfigValueMessage_FloatValue:
+			node.AddItem(k, ctypes.ConfigValueFloat{Value: v.FloatValue})
+		case *ConfigValueMessage_BoolValue:
+			node.AddItem(k, ctypes.ConfigValueBool{Value: v.BoolValue})
+		case *ConfigValueMessage_StringValue:
+			node.AddItem(k, ctypes.ConfigValueStr{Value: v.StringValue})
+		case *ConfigValueMessage_SecureValue:
+			node.AddItem(k, ctypes.ConfigValueSecureString{Ciphertext: v.SecureValue})
+		}
+	}
+	return node
+}
@@ -0,0 +1,159 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/core"
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func protobufTestMetrics() []MetricType {
+	ts := time.Date(2016, 9, 1, 12, 30, 15, 123456789, time.UTC)
+	config := cdata.NewNode()
+	config.AddItem("user", ctypes.ConfigValueStr{Value: "admin"})
+	config.AddItem("port", ctypes.ConfigValueInt{Value: 8086})
+	config.AddItem("ratio", ctypes.ConfigValueFloat{Value: 0.5})
+	config.AddItem("debug", ctypes.ConfigValueBool{Value: true})
+	ns := core.NewNamespace("intel", "mock").AddDynamicElement("host", "the host").AddStaticElement("value")
+	var metrics []MetricType
+	for _, data := range []interface{}{
+		"up", []byte{0, 1, 2}, true, float32(1.5), 2.25,
+		-3, int32(-4), int64(-5), uint(6), uint32(7), uint64(1 << 63), nil,
+	} {
+		m := NewMetricType(ns, ts, map[string]string{"host": "node-1", "dc": "east"}, "B", data)
+		m.Version_ = 2
+		m.Config_ = config
+		m.Description_ = "a mock metric"
+		m.LastAdvertisedTime_ = ts.Add(-time.Nanosecond)
+		metrics = append(metrics, *m)
+	}
+	return metrics
+}
+
+func TestProtobufContentType(t *testing.T) {
+	Convey("Metrics encoded as snap.protobuf", t, func() {
+		metrics := protobufTestMetrics()
+		b, err := EncodeMetrics(SnapProtoBufContentType, metrics)
+		So(err, ShouldBeNil)
+		out, err := DecodeMetrics(SnapProtoBufContentType, b)
+		So(err, ShouldBeNil)
+		So(len(out), ShouldEqual, len(metrics))
+
+		Convey("keep the Go type of their data", func() {
+			for i := range metrics {
+				So(out[i].Data(), ShouldResemble, metrics[i].Data())
+			}
+		})
+		Convey("keep namespaces, tags and timestamps to the nanosecond", func() {
+			for i, m := range out {
+				So(m.Namespace(), ShouldResemble, metrics[i].Namespace())
+				So(m.Namespace()[2].IsDynamic(), ShouldBeTrue)
+				So(m.Tags(), ShouldResemble, metrics[i].Tags())
+				So(m.Timestamp().Equal(metrics[i].Timestamp()), ShouldBeTrue)
+				So(m.LastAdvertisedTime().Equal(metrics[i].LastAdvertisedTime()), ShouldBeTrue)
+				So(m.Timestamp().Nanosecond(), ShouldEqual, 123456789)
+				So(m.Version(), ShouldEqual, 2)
+				So(m.Unit(), ShouldEqual, "B")
+				So(m.Description(), ShouldEqual, "a mock metric")
+				So(m.Config().Table(), ShouldResemble, metrics[i].Config().Table())
+			}
+		})
+		Convey("keep a zero time and a missing config", func() {
+			m := NewMetricType(core.NewNamespace("a", "b"), time.Time{}, nil, "", 1)
+			b, err := EncodeMetrics(SnapProtoBufContentType, []MetricType{*m})
+			So(err, ShouldBeNil)
+			out, err := DecodeMetrics(SnapProtoBufContentType, b)
+			So(err, ShouldBeNil)
+			So(out[0].Timestamp().IsZero(), ShouldBeTrue)
+			So(out[0].Config(), ShouldBeNil)
+		})
+		Convey("decode to the same batch as snap.json", func() {
+			jb, err := EncodeMetrics(SnapJSONContentType, metrics)
+			So(err, ShouldBeNil)
+			fromJSON, err := DecodeMetrics(SnapJSONContentType, jb)
+			So(err, ShouldBeNil)
+			So(len(fromJSON), ShouldEqual, len(out))
+			for i := range out {
+				So(out[i].Namespace().String(), ShouldEqual, fromJSON[i].Namespace().String())
+				So(out[i].Tags(), ShouldResemble, fromJSON[i].Tags())
+				So(out[i].Timestamp().Equal(fromJSON[i].Timestamp()), ShouldBeTrue)
+				So(out[i].Unit(), ShouldEqual, fromJSON[i].Unit())
+				So(out[i].Version(), ShouldEqual, fromJSON[i].Version())
+			}
+			// snap.json turns every number into a float64
+			for _, i := range []int{3, 4, 5, 6, 7, 8, 9} {
+				So(fromJSON[i].Data(), ShouldEqual, toFloat64(out[i].Data()))
+			}
+			So(fromJSON[0].Data(), ShouldEqual, out[0].Data())
+			So(fromJSON[2].Data(), ShouldEqual, out[2].Data())
+			So(fromJSON[11].Data(), ShouldBeNil)
+			So(len(b), ShouldBeLessThan, len(jb))
+		})
+	})
+	Convey("A metric with data of an unsupported type is not encoded", t, func() {
+		m := NewMetricType(core.NewNamespace("a", "b"), time.Now(), nil, "", map[string]int{"a": 1})
+		_, _, err := MarshalMetricTypes(SnapProtoBufContentType, []MetricType{*m})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "data of type map[string]int cannot be encoded as snap.protobuf")
+	})
+	Convey("A sealed secure string survives snap.protobuf", t, func() {
+		config := cdata.NewNode()
+		config.AddItem("password", ctypes.ConfigValueSecureString{Ciphertext: []byte("sealed")})
+		config.AddItem("token", ctypes.NewConfigValueSecureString("plaintext"))
+		m := NewMetricType(core.NewNamespace("a", "b"), time.Now(), nil, "", 1)
+		m.Config_ = config
+		b, err := EncodeMetrics(SnapProtoBufContentType, []MetricType{*m})
+		So(err, ShouldBeNil)
+		out, err := DecodeMetrics(SnapProtoBufContentType, b)
+		So(err, ShouldBeNil)
+		table := out[0].Config().Table()
+		So(table["password"].(ctypes.ConfigValueSecureString).Ciphertext, ShouldResemble, []byte("sealed"))
+		So(table["token"], ShouldResemble, ctypes.ConfigValueStr{Value: ctypes.Redacted})
+	})
+}
+
+func toFloat64(v interface{}) float64 {
+	switch n := v.(type) {
+	case float32:
+		return float64(n)
+	case float64:
+		return n
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case uint:
+		return float64(n)
+	case uint32:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	}
+	return 0
+}
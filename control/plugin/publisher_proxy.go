@@ -20,9 +20,13 @@ limitations under the License.
 package plugin
 
 import (
-	"errors"
+	"encoding/json"
 	"fmt"
+	"time"
 
+	"golang.org/x/net/context"
+
+	"github.com/intelsdi-x/snap/core/cdata"
 	"github.com/intelsdi-x/snap/core/ctypes"
 )
 
@@ -30,45 +34,278 @@ type PublishArgs struct {
 	ContentType string
 	Content     []byte
 	Config      map[string]ctypes.ConfigValue
+	Token       string
+	// ContentEncoding is the compression of Content, see ContentEncodings
+	ContentEncoding string `json:",omitempty"`
+	// RequestID identifies the call, see CollectMetricsArgs.RequestID
+	RequestID string `json:",omitempty"`
+	// Deadline of the call, see CollectMetricsArgs.Deadline
+	Deadline time.Time
+	// ReplyError asks for a failure of the call to be returned in
+	// PublishReply.Error rather than as the error string of the RPC.
+	// Older plugins ignore it and keep returning the error string.
+	ReplyError bool `json:",omitempty"`
+}
+
+// UnmarshalJSON restores the typed config values when PublishArgs are
+// received over JSON-RPC.
+func (p *PublishArgs) UnmarshalJSON(data []byte) error {
+	args := struct {
+		ContentType string
+		Content     []byte
+		Config      *cdata.ConfigDataNode
+		Token       string
+
+		ContentEncoding string
+		RequestID       string
+		Deadline        time.Time
+		ReplyError      bool
+	}{}
+	if err := json.Unmarshal(data, &args); err != nil {
+		return err
+	}
+	p.ContentType = args.ContentType
+	p.Content = args.Content
+	p.Token = args.Token
+	p.ContentEncoding = args.ContentEncoding
+	p.RequestID = args.RequestID
+	p.Deadline = args.Deadline
+	p.ReplyError = args.ReplyError
+	if args.Config != nil {
+		p.Config = args.Config.Table()
+	}
+	return nil
 }
 
 type PublishReply struct {
+	// RequestID is the RequestID of the args
+	RequestID string `json:",omitempty"`
+	// Backpressure is the plugin's advice to slow down, nil for none
+	Backpressure *Backpressure `json:",omitempty"`
+	// Attempts is the number of times Publish was called, and RetryDelay
+	// the time waited between them, see RetryPolicy
+	Attempts   int           `json:",omitempty"`
+	RetryDelay time.Duration `json:",omitempty"`
+	// Batched is set when the metrics were added to a batch, to be
+	// published later, see BatchSizeKey
+	Batched bool `json:",omitempty"`
+	// AckMode tells when the metrics are persisted.  The outcome of a
+	// deferred publish is polled with PublishStatus and BatchID.
+	AckMode AckMode `json:",omitempty"`
+	BatchID string  `json:",omitempty"`
+	// Spooled is set when the metrics were spooled, to be published once
+	// the sink is back, see Arg.SpoolDir
+	Spooled bool `json:",omitempty"`
+	// DryRun summarizes the metrics which were not published because the
+	// config of the call set DryRunKey
+	DryRun *DryRunSummary `json:",omitempty"`
+	// Error is the failure of the call when the args set ReplyError
+	Error *PluginError `json:",omitempty"`
 }
 
 type publisherPluginProxy struct {
 	Plugin  PublisherPlugin
 	Session Session
+	Meta    *PluginMeta
+	// config holds the config set with SetConfig
+	config *configStore
+	// suspension fails publishing while the session is suspended
+	suspension *suspension
+	// init fails publishing until the plugin's Init has succeeded
+	init *initialization
+	// requests follows the request IDs of the calls served
+	requests *requestTracker
+	// base is the parent context of the calls
+	base *sessionContext
+	// backpressure is reported in the replies
+	backpressure *backpressure
+	// retryable tells the errors of the plugin which are retried, see
+	// RetryableError
+	retryable func(error) bool
+	// batches holds the metrics of the calls whose config turns on
+	// batching
+	batches *batcher
+	// acks follows the batches of a DeferredPublisher
+	acks *ackTracker
+	// spool keeps the payloads the plugin failed to publish
+	spool *spool
+	// preview renders the payload of a dry run, nil when the plugin is no
+	// DryRunPreviewer
+	preview func(string, []byte, map[string]ctypes.ConfigValue) (string, error)
 }
 
-func (p *publisherPluginProxy) Publish(args []byte, reply *[]byte) error {
-	defer catchPluginPanic(p.Session.Logger())
-	p.Session.ResetHeartbeat()
-
+func (p *publisherPluginProxy) Publish(args []byte, reply *[]byte) (err error) {
 	dargs := &PublishArgs{}
-	err := p.Session.Decode(args, dargs)
+	defer func() {
+		if err != nil && dargs.ReplyError {
+			err = p.replyError(dargs.RequestID, err, reply)
+		}
+	}()
+	call := p.requests.start("Publisher.Publish")
+	defer call.end(&err)
+	defer p.Session.recoverPanic("Publisher.Publish", &err)
+
+	err = p.Session.Decode(args, dargs)
 	if err != nil {
 		return err
 	}
+	call.setID(dargs.RequestID)
+	if err := p.Session.CheckToken(dargs.Token); err != nil {
+		return err
+	}
+	p.Session.ResetHeartbeat()
+	if err := p.suspension.check(); err != nil {
+		return err
+	}
+	if err := p.init.check(); err != nil {
+		return err
+	}
+	ctx, cancel := p.base.callContext(dargs.RequestID, dargs.Deadline)
+	defer cancel()
+	if err := checkDeadline(ctx); err != nil {
+		return err
+	}
+	if err := call.beginCall(ctx, p.Session); err != nil {
+		return err
+	}
+	defer p.Session.endCall()
 
-	err = p.Plugin.Publish(dargs.ContentType, dargs.Content, dargs.Config)
+	if err := checkContentType(p.Meta, dargs.ContentType); err != nil {
+		return err
+	}
+	content, err := decompressArgs(dargs.ContentEncoding, dargs.Content)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Publish call error: %v", err.Error()))
+		return err
+	}
+	openConfig(dargs.Config, p.Session.decrypter())
+	config := p.config.merge(dargs.Config)
+	if isDryRun(config) {
+		*reply, err = p.Session.Encode(PublishReply{
+			RequestID:    dargs.RequestID,
+			Backpressure: p.backpressure.report(),
+			DryRun:       dryRun(p.Session, call, p.preview, dargs.ContentType, content, config),
+		})
+		return err
+	}
+	if size, timeout, ok := batchConfig(config); ok && p.batches != nil {
+		if err := p.batches.add(dargs.ContentType, content, config, size, timeout); err != nil {
+			return &PluginError{Code: ErrorCodeCallFailed, Message: fmt.Sprintf("Publish call error: %v", err.Error())}
+		}
+		*reply, err = p.Session.Encode(PublishReply{
+			RequestID:    dargs.RequestID,
+			Backpressure: p.backpressure.report(),
+			Batched:      true,
+		})
+		return err
 	}
+	if p.spool.pending() {
+		return p.spoolPayload(dargs.RequestID, dargs.ContentType, content, config, reply)
+	}
+	var (
+		attempts int
+		delay    time.Duration
+		failure  error
+	)
+	batchID := p.acks.begin()
+	err = callWithDeadline(ctx, p.Session, "Publisher.Publish", func(ctx context.Context) error {
+		var err error
+		attempts, delay, err = retryPolicy(config).do(ctx, p.isRetryable, func() error {
+			if batchID != "" {
+				return p.acks.publisher.PublishDeferred(batchID, dargs.ContentType, content, config)
+			}
+			if cp, ok := p.Plugin.(ContextPublisher); ok {
+				return cp.PublishContext(ctx, dargs.ContentType, content, config)
+			}
+			return p.Plugin.Publish(dargs.ContentType, content, config)
+		})
+		call.recordRetries(attempts, delay)
+		if err != nil {
+			failure = err
+			return &PluginError{Code: ErrorCodeCallFailed, Message: fmt.Sprintf("Publish call error: %v", err.Error())}
+		}
+		return nil
+	})
+	if err != nil {
+		p.acks.drop(batchID)
+		// failure is only set once the plugin returned
+		if err != ErrDeadlineExceeded && failure != nil && p.spool != nil && p.isRetryable(failure) {
+			return p.spoolPayload(dargs.RequestID, dargs.ContentType, content, config, reply)
+		}
+		return err
+	}
+	r := PublishReply{
+		RequestID:    dargs.RequestID,
+		Backpressure: p.backpressure.report(),
+		Attempts:     attempts,
+		RetryDelay:   delay,
+	}
+	if batchID != "" {
+		r.AckMode, r.BatchID = AckDeferred, batchID
+	}
+	*reply, err = p.Session.Encode(r)
+	return err
+}
+
+// replyError replies to a Publish call with err in PublishReply.Error.  err
+// is returned as the error of the call should the reply fail to encode.
+func (p *publisherPluginProxy) replyError(requestID string, err error, reply *[]byte) error {
+	b, eerr := p.Session.Encode(PublishReply{RequestID: requestID, Error: toPluginError(err)})
+	if eerr != nil {
+		return err
+	}
+	*reply = b
 	return nil
 }
 
-/*
-type gRPCPublisherProxy struct {
-	Plugin  PublisherPlugin
-	Session Session
-	gRPCPluginProxy
+// PublishStatus replies with the outcome of the deferred batches of the
+// args, see DeferredPublisher.
+func (p *publisherPluginProxy) PublishStatus(args []byte, reply *[]byte) (err error) {
+	defer p.Session.recoverPanic("Publisher.PublishStatus", &err)
+
+	dargs := &PublishStatusArgs{}
+	if err := p.Session.Decode(args, dargs); err != nil {
+		return err
+	}
+	if err := p.Session.CheckToken(dargs.Token); err != nil {
+		return err
+	}
+	p.Session.ResetHeartbeat()
+	*reply, err = p.Session.Encode(PublishStatusReply{Batches: p.acks.status(dargs.BatchIDs)})
+	return err
 }
 
-func (p *gRPCPublisherProxy) Publish(ctx context.Context, arg *rpc.PublishArg) (*common.Empty, error) {
-	defer catchPluginPanic(p.Session.Logger())
-	err := p.Plugin.Publish(arg.ContentType, arg.Content, common.ParseConfig(arg.Config))
-	if err != nil {
-		return &common.Empty{}, err
+func (p *publisherPluginProxy) isRetryable(err error) bool {
+	if p.retryable == nil {
+		return isRetryableError(err)
+	}
+	return p.retryable(err)
+}
+
+// publishBatch publishes a batch of metrics, retrying and spooling as a
+// call would.
+func (p *publisherPluginProxy) publishBatch(contentType string, content []byte, config map[string]ctypes.ConfigValue) error {
+	if p.spool.pending() {
+		return p.spool.add(contentType, content, config)
 	}
-	return &common.Empty{}, nil
-}*/
+	_, _, err := retryPolicy(config).do(context.Background(), p.isRetryable, func() error {
+		return p.Plugin.Publish(contentType, content, config)
+	})
+	if err != nil && p.spool != nil && p.isRetryable(err) {
+		return p.spool.add(contentType, content, config)
+	}
+	return err
+}
+
+// spoolPayload spools a payload for the sink to take later, and replies to
+// the call that it was.
+func (p *publisherPluginProxy) spoolPayload(requestID, contentType string, content []byte, config map[string]ctypes.ConfigValue, reply *[]byte) (err error) {
+	if err := p.spool.add(contentType, content, config); err != nil {
+		return &PluginError{Code: ErrorCodeCallFailed, Message: fmt.Sprintf("Publish call error: spooling failed: %v", err)}
+	}
+	*reply, err = p.Session.Encode(PublishReply{
+		RequestID:    requestID,
+		Backpressure: p.backpressure.report(),
+		Spooled:      true,
+	})
+	return err
+}
@@ -22,12 +22,19 @@ limitations under the License.
 package plugin
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/rpc"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
 	"github.com/intelsdi-x/snap/core/ctypes"
+	"golang.org/x/net/context"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -40,8 +47,24 @@ func (f *MockPublisher) Publish(_ string, _ []byte, _ map[string]ctypes.ConfigVa
 	return nil
 }
 
-func (f *MockPublisher) GetConfigPolicy() cpolicy.ConfigPolicy {
-	return cpolicy.ConfigPolicy{}
+func (f *MockPublisher) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	return cpolicy.New(), nil
+}
+
+// filePublisher writes the content it is given to the file named by the
+// "file" config value.
+type filePublisher struct{}
+
+func (f *filePublisher) Publish(contentType string, content []byte, config map[string]ctypes.ConfigValue) error {
+	v, ok := config["file"].(ctypes.ConfigValueStr)
+	if !ok {
+		return errors.New("no file to publish to")
+	}
+	return ioutil.WriteFile(v.Value, content, 0600)
+}
+
+func (f *filePublisher) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	return cpolicy.New(), nil
 }
 
 type MockPublisherSessionState struct {
@@ -50,7 +73,7 @@ type MockPublisherSessionState struct {
 	listenAddress       string
 	listenPort          string
 	token               string
-	logger              *log.Logger
+	logger              Logger
 	killChan            chan int
 }
 
@@ -63,7 +86,7 @@ func (s *MockPublisherSessionState) Kill(arg KillArgs, b *bool) error {
 	return nil
 }
 
-func (s *MockPublisherSessionState) Logger() *log.Logger {
+func (s *MockPublisherSessionState) Logger() Logger {
 	return s.logger
 }
 
@@ -91,33 +114,132 @@ func (s *MockPublisherSessionState) KillChan() chan int {
 	return s.killChan
 }
 
+// Done is closed once Kill or heartbeatWatch has signaled killChan
+func (s *MockPublisherSessionState) Done() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		<-s.killChan
+		close(done)
+	}()
+	return done
+}
+
+func (s *MockPublisherSessionState) ShutdownReason() Shutdown {
+	return Shutdown{}
+}
+
 func (s *MockPublisherSessionState) isDaemon() bool {
 	return s.Daemon
 }
 
-func (s *MockPublisherSessionState) generateResponse(r *Response) []byte {
-	return []byte("mockResponse")
+func (s *MockPublisherSessionState) generateResponse(r *Response) ([]byte, error) {
+	return []byte("mockResponse"), nil
 }
 
-func (s *MockPublisherSessionState) heartbeatWatch(killChan chan int) {
+func (s *MockPublisherSessionState) heartbeatWatch() {
 	time.Sleep(time.Millisecond * 200)
-	killChan <- 0
+	s.killChan <- 0
+}
+
+func (s *MockPublisherSessionState) stopHeartbeat() bool {
+	return true
+}
+
+func (s *MockPublisherSessionState) recordCall(string, time.Duration, error) {}
+
+func (s *MockPublisherSessionState) beginCall(context.Context) error {
+	return nil
 }
 
+func (s *MockPublisherSessionState) endCall() {}
+
+func (s *MockPublisherSessionState) drain() (bool, int) {
+	return true, 0
+}
+
+func (s *MockPublisherSessionState) scheduleKill(string) {}
+
+func (s *MockPublisherSessionState) runOnKill(KillReason) {}
+
+func (s *MockPublisherSessionState) recoverPanic(string, *error) {}
+
 func TestStartPublisher(t *testing.T) {
 	Convey("Publisher", t, func() {
 		Convey("start with dynamic port", func() {
-			c := new(MockProcessor)
+			c := new(MockPublisher)
 			m := &PluginMeta{
+				Name:    "mock",
+				Version: 1,
 				RPCType: JSONRPC,
 				Type:    PublisherPluginType,
 			}
-			// we will panic since rpc.HandleHttp has already
-			// been called during TestStartCollector
-			Convey("RPC service already registered", func() {
-				So(func() { Start(m, c, "{}") }, ShouldPanic)
-			})
+			err, rc := Start(m, c, `{"NoDaemon": true}`)
+			So(err, ShouldBeNil)
+			So(rc, ShouldEqual, 0)
+		})
+	})
+}
 
+func TestFilePublisher(t *testing.T) {
+	Convey("A file publisher", t, func() {
+		dir, err := ioutil.TempDir("", "snap-publisher")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		m := NewPluginMeta("file", 1, PublisherPluginType, []string{SnapGOBContentType}, nil, Unsecure(true))
+		resp, done := startTestPlugin(m, &filePublisher{}, fmt.Sprintf(`{"PingTimeoutDuration": %d}`, time.Minute))
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer client.Close()
+
+		enc := encoding.NewGobEncoder()
+		content, _, err := MarshalMetricTypes(SnapGOBContentType, mockMetricType)
+		So(err, ShouldBeNil)
+		publish := func(file string, replyError bool) (PublishReply, error) {
+			in, err := enc.Encode(PublishArgs{
+				ContentType: SnapGOBContentType,
+				Content:     content,
+				Config:      map[string]ctypes.ConfigValue{"file": ctypes.ConfigValueStr{Value: file}},
+				Token:       resp.Token,
+				RequestID:   "publish-1",
+				ReplyError:  replyError,
+			})
+			So(err, ShouldBeNil)
+			var out []byte
+			if err := client.Call("Publisher.Publish", in, &out); err != nil {
+				return PublishReply{}, err
+			}
+			var r PublishReply
+			So(enc.Decode(out, &r), ShouldBeNil)
+			return r, nil
+		}
+
+		Convey("writes the published metrics to the file", func() {
+			file := filepath.Join(dir, "metrics")
+			r, err := publish(file, true)
+			So(err, ShouldBeNil)
+			So(r.Error, ShouldBeNil)
+			b, err := ioutil.ReadFile(file)
+			So(err, ShouldBeNil)
+			mts, err := UnmarshallMetricTypes(SnapGOBContentType, b)
+			So(err, ShouldBeNil)
+			So(mts, ShouldHaveLength, len(mockMetricType))
+			So(mts[0].Namespace(), ShouldResemble, mockMetricType[0].Namespace())
 		})
+		Convey("returns a failure in the reply when asked to", func() {
+			r, err := publish(filepath.Join(dir, "missing", "metrics"), true)
+			So(err, ShouldBeNil)
+			So(r.Error, ShouldNotBeNil)
+			So(r.Error.Code, ShouldEqual, ErrorCodeCallFailed)
+			So(r.Error.RequestID, ShouldEqual, "publish-1")
+			So(r.Error.Message, ShouldContainSubstring, "no such file or directory")
+		})
+		Convey("returns a failure as the error of the call otherwise", func() {
+			_, err := publish(filepath.Join(dir, "missing", "metrics"), false)
+			So(err, ShouldNotBeNil)
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeCallFailed)
+		})
+
+		So(callKill(client, resp.Token), ShouldBeNil)
+		So(<-done, ShouldEqual, 0)
 	})
 }
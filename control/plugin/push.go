@@ -0,0 +1,238 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultPushQueueSize is the number of batches waiting to be pushed
+	// to control unless Arg.PushQueueSize says otherwise.
+	DefaultPushQueueSize = 100
+	// DefaultPushRetryInterval is the first delay before a failed push is
+	// retried unless Arg.PushRetryInterval says otherwise.  It doubles
+	// with each failure up to MaxPushRetryInterval.
+	DefaultPushRetryInterval = time.Second
+	// MaxPushRetryInterval caps the delay between two push attempts
+	MaxPushRetryInterval = 30 * time.Second
+)
+
+// ErrPushDisabled is returned by Push in a session started without
+// Arg.PushAddress.
+var ErrPushDisabled error = &PluginError{Code: ErrorCodeUnsupported, Message: "control did not give a push address"}
+
+// PushMethod is the RPC method, served by control at Arg.PushAddress, to
+// which pushed metrics are delivered.
+const PushMethod = "Control.PushMetrics"
+
+// PushMetricsArgs are the arguments of PushMethod.  They are encoded with
+// the session's codec and encrypter, and sent over net/rpc with gob.
+type PushMetricsArgs struct {
+	// Token is the session token, which identifies the plugin
+	Token string
+	// Seq numbers the batches pushed by the session from 1, so that control
+	// can tell a batch was dropped or redelivered.
+	Seq     uint64
+	Metrics []MetricType
+	// Dropped is the number of batches dropped since the session started
+	// because the queue was full.
+	Dropped uint64
+}
+
+// Pusher hands metrics to control in push mode.  It is implemented by the
+// session of a plugin started with Arg.PushAddress.
+type Pusher interface {
+	// Push queues a batch of metrics to be delivered, in order, to
+	// control.  It does not block: once the queue is full the oldest
+	// batch is dropped.
+	Push([]MetricType) error
+}
+
+// PushCollector is implemented by plugins which push metrics to control,
+// e.g. log tailers or trap receivers.  StartPush is called once the plugin
+// is served, with the Pusher of its session, and must return promptly.
+type PushCollector interface {
+	StartPush(Pusher)
+}
+
+type pushBatch struct {
+	seq     uint64
+	metrics []MetricType
+}
+
+// pushQueue delivers the batches handed to Push to control, retrying with
+// an exponential backoff until they are accepted.
+type pushQueue struct {
+	address  string
+	size     int
+	interval time.Duration
+	// deliver sends a batch to control
+	deliver func(pushBatch, uint64) error
+
+	mutex   sync.Mutex
+	batches []pushBatch
+	seq     uint64
+	dropped uint64
+	ready   chan struct{}
+}
+
+func newPushQueue(address string, size int, interval time.Duration) *pushQueue {
+	if size <= 0 {
+		size = DefaultPushQueueSize
+	}
+	if interval <= 0 {
+		interval = DefaultPushRetryInterval
+	}
+	return &pushQueue{
+		address:  address,
+		size:     size,
+		interval: interval,
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+// push queues mts, dropping the oldest batch when the queue is full.
+func (q *pushQueue) push(mts []MetricType) {
+	q.mutex.Lock()
+	q.seq++
+	q.batches = append(q.batches, pushBatch{seq: q.seq, metrics: mts})
+	if len(q.batches) > q.size {
+		q.batches = q.batches[1:]
+		q.dropped++
+	}
+	q.mutex.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the oldest batch without removing it.
+func (q *pushQueue) next() (pushBatch, uint64, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.batches) == 0 {
+		return pushBatch{}, q.dropped, false
+	}
+	return q.batches[0], q.dropped, true
+}
+
+// delivered removes batch seq, unless it was dropped meanwhile.
+func (q *pushQueue) delivered(seq uint64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.batches) > 0 && q.batches[0].seq == seq {
+		q.batches = q.batches[1:]
+	}
+}
+
+// run delivers the queued batches until done is closed.
+func (q *pushQueue) run(done <-chan struct{}, logger Logger) {
+	wait := q.interval
+	for {
+		b, dropped, ok := q.next()
+		if !ok {
+			select {
+			case <-q.ready:
+				continue
+			case <-done:
+				return
+			}
+		}
+		if err := q.deliver(b, dropped); err != nil {
+			logger.Debugf("Push of batch %d to %s failed, retrying in %s: %v", b.seq, q.address, wait, err)
+			select {
+			case <-time.After(wait):
+			case <-done:
+				return
+			}
+			if wait *= 2; wait > MaxPushRetryInterval {
+				wait = MaxPushRetryInterval
+			}
+			continue
+		}
+		wait = q.interval
+		q.delivered(b.seq)
+	}
+}
+
+// pushClient is the connection to control at Arg.PushAddress, dialed on
+// the first push and again after a failure.
+type pushClient struct {
+	s      *SessionState
+	client *rpc.Client
+}
+
+func (c *pushClient) deliver(b pushBatch, dropped uint64) error {
+	args, err := c.s.Encode(PushMetricsArgs{Token: c.s.Token(), Seq: b.seq, Metrics: b.metrics, Dropped: dropped})
+	if err != nil {
+		return err
+	}
+	if c.client == nil {
+		conn, err := DialListenAddress(c.s.PushAddress, c.s.PingTimeoutDuration)
+		if err != nil {
+			return err
+		}
+		c.client = rpc.NewClient(conn)
+	}
+	if err := c.client.Call(PushMethod, args, &[]byte{}); err != nil {
+		if _, ok := err.(rpc.ServerError); !ok {
+			c.client.Close()
+			c.client = nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Push queues mts to be delivered to control at Arg.PushAddress.
+func (s *SessionState) Push(mts []MetricType) error {
+	if s.pushes == nil {
+		return ErrPushDisabled
+	}
+	if len(mts) == 0 {
+		return errors.New("no metrics to push")
+	}
+	s.pushes.push(mts)
+	return nil
+}
+
+// startPush delivers pushed metrics until the session ends, and hands the
+// session to a PushCollector.
+func (s *SessionState) startPush() {
+	if s.pushes == nil {
+		return
+	}
+	c := &pushClient{s: s}
+	s.pushes.deliver = c.deliver
+	go func() {
+		s.pushes.run(s.Done(), s.Logger())
+		if c.client != nil {
+			c.client.Close()
+		}
+	}()
+	if pc, ok := s.plugin.(PushCollector); ok {
+		pc.StartPush(s)
+	}
+}
@@ -0,0 +1,195 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	"github.com/intelsdi-x/snap/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// pushingPlugin is a collector which pushes metrics to control
+type pushingPlugin struct {
+	mockPlugin
+	pusher chan Pusher
+}
+
+func (p *pushingPlugin) StartPush(pusher Pusher) {
+	p.pusher <- pusher
+}
+
+// fakeControl serves PushMethod.  The first fail calls fail, and each
+// call waits for gate when it is set.
+type fakeControl struct {
+	calls  chan PushMetricsArgs
+	gate   chan struct{}
+	failed chan struct{}
+	fail   int
+}
+
+func (c *fakeControl) PushMetrics(args []byte, reply *[]byte) error {
+	var a PushMetricsArgs
+	if err := encoding.NewGobEncoder().Decode(args, &a); err != nil {
+		return err
+	}
+	if c.fail > 0 {
+		c.fail--
+		c.failed <- struct{}{}
+		return errors.New("control is busy")
+	}
+	c.calls <- a
+	if c.gate != nil {
+		<-c.gate
+	}
+	return nil
+}
+
+func startFakeControl(c *fakeControl) net.Listener {
+	server := rpc.NewServer()
+	So(server.RegisterName("Control", c), ShouldBeNil)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+	go server.Accept(l)
+	return l
+}
+
+func pushBatchOf(i int) []MetricType {
+	return []MetricType{{Namespace_: core.NewNamespace("foo", "trap"), Data_: i}}
+}
+
+func TestPushQueue(t *testing.T) {
+	Convey("A full push queue drops its oldest batch", t, func() {
+		q := newPushQueue("control", 3, 0)
+		for i := 1; i <= 5; i++ {
+			q.push(pushBatchOf(i))
+		}
+		b, dropped, ok := q.next()
+		So(ok, ShouldBeTrue)
+		So(dropped, ShouldEqual, 2)
+		So(b.seq, ShouldEqual, 3)
+		So(b.metrics[0].Data(), ShouldEqual, 3)
+		q.delivered(3)
+		b, _, _ = q.next()
+		So(b.seq, ShouldEqual, 4)
+		// A batch dropped while it was delivered is not removed twice
+		q.delivered(3)
+		b, _, _ = q.next()
+		So(b.seq, ShouldEqual, 4)
+	})
+}
+
+func TestPush(t *testing.T) {
+	Convey("A plugin started without a push address", t, func() {
+		p := &pushingPlugin{pusher: make(chan Pusher, 1)}
+		m := NewPluginMeta("push", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		resp, done := startTestPlugin(m, p, fmt.Sprintf(`{"KillDelay": 1, "PingTimeoutDuration": %d}`, time.Minute))
+		So(resp.HasCapability(CapabilityPush), ShouldBeFalse)
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer client.Close()
+		So(p.pusher, ShouldBeEmpty)
+		So(callKill(client, resp.Token), ShouldBeNil)
+		<-done
+
+		s := &SessionState{Arg: &Arg{}}
+		So(s.Push(pushBatchOf(1)), ShouldEqual, ErrPushDisabled)
+	})
+	Convey("A plugin pushing to control", t, func() {
+		fc := &fakeControl{calls: make(chan PushMetricsArgs, 10), failed: make(chan struct{}, 10)}
+		start := func(queueSize int) (Response, chan int, Pusher) {
+			l := startFakeControl(fc)
+			Reset(func() { l.Close() })
+			p := &pushingPlugin{pusher: make(chan Pusher, 1)}
+			m := NewPluginMeta("push", 1, CollectorPluginType, nil, nil, Unsecure(true))
+			args := fmt.Sprintf(`{"PushAddress": %q, "PushQueueSize": %d, "PushRetryInterval": %d, "KillDelay": 1, "PingTimeoutDuration": %d}`,
+				l.Addr().String(), queueSize, time.Millisecond, time.Minute)
+			resp, done := startTestPlugin(m, p, args)
+			So(resp.HasCapability(CapabilityPush), ShouldBeTrue)
+			return resp, done, <-p.pusher
+		}
+		stop := func(resp Response, done chan int) {
+			client, err := rpc.Dial("tcp", resp.ListenAddress)
+			So(err, ShouldBeNil)
+			defer client.Close()
+			So(callKill(client, resp.Token), ShouldBeNil)
+			<-done
+		}
+		receive := func() PushMetricsArgs {
+			select {
+			case a := <-fc.calls:
+				return a
+			case <-time.After(5 * time.Second):
+				So("no batch was pushed", ShouldBeEmpty)
+				return PushMetricsArgs{}
+			}
+		}
+
+		Convey("delivers batches in order", func() {
+			resp, done, pusher := start(10)
+			for i := 1; i <= 5; i++ {
+				So(pusher.Push(pushBatchOf(i)), ShouldBeNil)
+			}
+			for i := 1; i <= 5; i++ {
+				a := receive()
+				So(a.Token, ShouldEqual, resp.Token)
+				So(a.Seq, ShouldEqual, i)
+				So(a.Metrics[0].Data(), ShouldEqual, i)
+				So(a.Dropped, ShouldEqual, 0)
+			}
+			So(pusher.Push(nil), ShouldNotBeNil)
+			stop(resp, done)
+		})
+		Convey("retries a failed push", func() {
+			fc.fail = 2
+			resp, done, pusher := start(10)
+			So(pusher.Push(pushBatchOf(1)), ShouldBeNil)
+			So(pusher.Push(pushBatchOf(2)), ShouldBeNil)
+			So(receive().Seq, ShouldEqual, 1)
+			So(receive().Seq, ShouldEqual, 2)
+			So(fc.failed, ShouldHaveLength, 2)
+			stop(resp, done)
+		})
+		Convey("drops the oldest batches when the queue overflows", func() {
+			fc.gate = make(chan struct{})
+			resp, done, pusher := start(2)
+			So(pusher.Push(pushBatchOf(1)), ShouldBeNil)
+			So(receive().Seq, ShouldEqual, 1)
+			// Batch 1 is still queued while it is being delivered
+			for i := 2; i <= 5; i++ {
+				So(pusher.Push(pushBatchOf(i)), ShouldBeNil)
+			}
+			close(fc.gate)
+			a := receive()
+			So(a.Seq, ShouldEqual, 4)
+			So(a.Dropped, ShouldEqual, 3)
+			So(receive().Seq, ShouldEqual, 5)
+			stop(resp, done)
+		})
+	})
+}
@@ -0,0 +1,247 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// requestIDLength is the number of random bytes in a request ID
+const requestIDLength = 8
+
+// DefaultSlowCallThreshold is the duration over which a call is logged as
+// slow when Arg.SlowCallThreshold is not set.
+var DefaultSlowCallThreshold = 5 * time.Second
+
+// GRPCRequestIDKey is the gRPC metadata key carrying the request ID of a
+// call, sent by control and echoed back in the reply header.
+const GRPCRequestIDKey = "snap-request-id"
+
+// NewRequestID returns a new ID for a call to a plugin, used to correlate
+// the call across the control and plugin logs.
+func NewRequestID() string {
+	b := make([]byte, requestIDLength)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// RequestIDReader gives the request ID of the call being served.
+type RequestIDReader interface {
+	// RequestID returns the ID of the call being served, or "" when no call
+	// with a request ID is in progress.  With calls served concurrently (see
+	// PluginMeta.ConcurrencyCount) it is the ID of the latest call started.
+	RequestID() string
+}
+
+// RequestTracer may be implemented by a plugin to read the request ID of
+// the call it serves, e.g. to pass it on to the helpers it calls.
+type RequestTracer interface {
+	TraceRequests(RequestIDReader)
+}
+
+// tracedMethods are logged by the requestTracker, with their request ID,
+// rather than by the session stats.
+var tracedMethods = map[string]bool{
+	"Collector.CollectMetrics": true,
+	"Collector.GetMetricTypes": true,
+	"Publisher.Publish":        true,
+	"Processor.Process":        true,
+}
+
+// requestTracker holds the request IDs of the calls in progress and logs
+// each call with its request ID, warning of those slower than the
+// threshold.  A nil requestTracker tracks nothing.
+type requestTracker struct {
+	mutex  sync.Mutex
+	ids    []string
+	logger func() Logger
+	// stats counts the slow calls, when set
+	stats *sessionStats
+	// threshold is the duration over which a call is slow, or zero
+	threshold time.Duration
+}
+
+func newRequestTracker(logger func() Logger, stats *sessionStats, threshold time.Duration) *requestTracker {
+	if threshold < 0 {
+		threshold = 0
+	}
+	return &requestTracker{logger: logger, stats: stats, threshold: threshold}
+}
+
+// requestCall is a call followed by a requestTracker
+type requestCall struct {
+	tracker *requestTracker
+	method  string
+	id      string
+	start   time.Time
+	// queued is the time the call waited for a concurrency slot
+	queued time.Duration
+	// namespaces are the metrics requested by the call
+	namespaces []string
+}
+
+type requestCallKey struct{}
+
+// withRequestCall returns ctx carrying call, for the gRPC proxies
+func withRequestCall(ctx context.Context, call *requestCall) context.Context {
+	return context.WithValue(ctx, requestCallKey{}, call)
+}
+
+// requestCallFrom returns the requestCall carried by ctx, or nil
+func requestCallFrom(ctx context.Context) *requestCall {
+	call, _ := ctx.Value(requestCallKey{}).(*requestCall)
+	return call
+}
+
+// start begins following a call to method.  Its request ID is set once the
+// args are decoded.
+func (t *requestTracker) start(method string) *requestCall {
+	if t == nil {
+		return nil
+	}
+	return &requestCall{tracker: t, method: method, start: time.Now()}
+}
+
+// setID sets the request ID of the call, making it the current one.
+func (c *requestCall) setID(id string) {
+	if c == nil || id == "" {
+		return
+	}
+	c.id = id
+	c.tracker.mutex.Lock()
+	c.tracker.ids = append(c.tracker.ids, id)
+	c.tracker.mutex.Unlock()
+}
+
+// beginCall begins the call on s, see Session.beginCall.  The time spent
+// waiting for a concurrency slot is not counted against the slow call
+// threshold.
+func (c *requestCall) beginCall(ctx context.Context, s Session) error {
+	if c == nil {
+		return s.beginCall(ctx)
+	}
+	start := time.Now()
+	err := s.beginCall(ctx)
+	c.queued = time.Since(start)
+	return err
+}
+
+// setNamespaces records the metrics requested by the call
+func (c *requestCall) setNamespaces(mts []MetricType) {
+	if c == nil {
+		return
+	}
+	c.namespaces = make([]string, len(mts))
+	for i, mt := range mts {
+		c.namespaces[i] = mt.Namespace().String()
+	}
+}
+
+// recordRetries counts the attempts beyond the first made by the call
+func (c *requestCall) recordRetries(attempts int, delay time.Duration) {
+	if c == nil || attempts < 2 || c.tracker.stats == nil {
+		return
+	}
+	c.tracker.stats.recordRetries(c.method, attempts-1, delay)
+}
+
+// recordDryRun counts the call as skipped by a dry run
+func (c *requestCall) recordDryRun() {
+	if c == nil || c.tracker.stats == nil {
+		return
+	}
+	c.tracker.stats.recordDryRun(c.method)
+}
+
+// end logs the call and adds its request ID to the error it returned.
+func (c *requestCall) end(err *error) {
+	if c == nil {
+		return
+	}
+	d := time.Since(c.start) - c.queued
+	if c.id != "" {
+		c.tracker.remove(c.id)
+		*err = withRequestID(*err, c.id)
+	}
+	fields := map[string]interface{}{
+		"method":     c.method,
+		"duration":   d.String(),
+		"request_id": c.id,
+	}
+	if *err != nil {
+		fields["error"] = (*err).Error()
+	}
+	l := c.tracker.logger()
+	logWith(l, fields).Debugf("Call served")
+	if t := c.tracker.threshold; t > 0 && d > t {
+		if c.tracker.stats != nil {
+			c.tracker.stats.recordSlow(c.method)
+		}
+		fields["queued"] = c.queued.String()
+		if len(c.namespaces) > 0 {
+			fields["namespaces"] = strings.Join(c.namespaces, ",")
+		}
+		logWith(l, fields).Warnf("Slow call to %s took %v, over %v", c.method, d, t)
+	}
+}
+
+// remove drops the latest occurrence of id from the calls in progress
+func (t *requestTracker) remove(id string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for i := len(t.ids) - 1; i >= 0; i-- {
+		if t.ids[i] == id {
+			t.ids = append(t.ids[:i], t.ids[i+1:]...)
+			return
+		}
+	}
+}
+
+// current returns the request ID of the latest call in progress.
+func (t *requestTracker) current() string {
+	if t == nil {
+		return ""
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.ids) == 0 {
+		return ""
+	}
+	return t.ids[len(t.ids)-1]
+}
+
+// withRequestID returns err as a PluginError carrying the request ID.
+func withRequestID(err error, id string) error {
+	if err == nil || id == "" {
+		return err
+	}
+	re := *toPluginError(err)
+	re.RequestID = id
+	return &re
+}
@@ -0,0 +1,254 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// tracingCollector records the request ID it reads while collecting
+type tracingCollector struct {
+	mockPlugin
+	requests RequestIDReader
+	seen     []string
+	fail     bool
+}
+
+func (c *tracingCollector) TraceRequests(r RequestIDReader) {
+	c.requests = r
+}
+
+func (c *tracingCollector) CollectMetrics(mts []MetricType) ([]MetricType, error) {
+	c.seen = append(c.seen, c.requests.RequestID())
+	if c.fail {
+		return nil, errors.New("device unreachable")
+	}
+	return c.mockPlugin.CollectMetrics(mts)
+}
+
+// tracingProcessor passes the request ID of each call on to a helper
+type tracingProcessor struct {
+	requests RequestIDReader
+	helped   []string
+}
+
+func (p *tracingProcessor) TraceRequests(r RequestIDReader) {
+	p.requests = r
+}
+
+func (p *tracingProcessor) helper() {
+	p.helped = append(p.helped, p.requests.RequestID())
+}
+
+func (p *tracingProcessor) Process(contentType string, content []byte, config map[string]ctypes.ConfigValue) (string, []byte, error) {
+	p.helper()
+	return contentType, content, nil
+}
+
+func (p *tracingProcessor) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	return cpolicy.New(), nil
+}
+
+// laggingCollector takes delay to serve its first collection
+type laggingCollector struct {
+	mockPlugin
+	delay   time.Duration
+	calls   int32
+	started chan struct{}
+}
+
+func (c *laggingCollector) CollectMetrics(mts []MetricType) ([]MetricType, error) {
+	if atomic.AddInt32(&c.calls, 1) == 1 {
+		close(c.started)
+		time.Sleep(c.delay)
+	}
+	return c.mockPlugin.CollectMetrics(mts)
+}
+
+func TestRequestID(t *testing.T) {
+	Convey("NewRequestID", t, func() {
+		id := NewRequestID()
+		So(id, ShouldHaveLength, 2*requestIDLength)
+		So(NewRequestID(), ShouldNotEqual, id)
+	})
+	Convey("A PluginError with a request ID", t, func() {
+		err := &PluginError{Code: ErrorCodeCallFailed, Message: "boom (twice)", RequestID: "abc-123"}
+		So(err.Error(), ShouldEqual, "[call-failed] boom (twice) (request abc-123)")
+		e, ok := ParsePluginError(err.Error())
+		So(ok, ShouldBeTrue)
+		So(e, ShouldResemble, err)
+		e, ok = ParsePluginError("[call-failed] boom (twice)")
+		So(ok, ShouldBeTrue)
+		So(e.Message, ShouldEqual, "boom (twice)")
+		So(e.RequestID, ShouldBeEmpty)
+	})
+	Convey("withRequestID", t, func() {
+		So(withRequestID(nil, "abc"), ShouldBeNil)
+		So(withRequestID(ErrSuspended, ""), ShouldEqual, ErrSuspended)
+		err := withRequestID(ErrSuspended, "abc").(*PluginError)
+		So(err.Code, ShouldEqual, ErrorCodeSuspended)
+		So(err.RequestID, ShouldEqual, "abc")
+		So(ErrSuspended.(*PluginError).RequestID, ShouldBeEmpty)
+		err = withRequestID(errors.New("plain"), "abc").(*PluginError)
+		So(err.Code, ShouldEqual, ErrorCodeInternal)
+		So(err.Message, ShouldEqual, "plain")
+	})
+	Convey("A collector session", t, func() {
+		m := NewPluginMeta("traced", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		c := &tracingCollector{}
+		ss, err, _ := NewSessionState(`{"LogLevel": "debug"}`, c, m)
+		So(err, ShouldBeNil)
+		var buf bytes.Buffer
+		ss.Logger().(*log.Entry).Logger.Out = &buf
+		proxy := &collectorPluginProxy{Plugin: c, Session: ss, Meta: m, suspension: ss.suspension, requests: ss.requests}
+		collect := func(id string) (*CollectMetricsReply, error) {
+			in, err := ss.Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: ss.Token(), RequestID: id})
+			So(err, ShouldBeNil)
+			var out []byte
+			if err := proxy.CollectMetrics(in, &out); err != nil {
+				return nil, err
+			}
+			r := &CollectMetricsReply{}
+			So(ss.Decode(out, r), ShouldBeNil)
+			return r, nil
+		}
+
+		Convey("carries the request ID to the plugin, the log and the reply", func() {
+			r, err := collect("req-1")
+			So(err, ShouldBeNil)
+			So(r.RequestID, ShouldEqual, "req-1")
+			So(c.seen, ShouldResemble, []string{"req-1"})
+			So(ss.RequestID(), ShouldBeEmpty)
+			So(buf.String(), ShouldContainSubstring, "Call served duration=")
+			So(buf.String(), ShouldContainSubstring, " method=Collector.CollectMetrics request_id=req-1\n")
+		})
+		Convey("carries the request ID to the error", func() {
+			c.fail = true
+			_, err := collect("req-2")
+			So(err, ShouldNotBeNil)
+			e, ok := ParsePluginError(err.Error())
+			So(ok, ShouldBeTrue)
+			So(e.Code, ShouldEqual, ErrorCodeCallFailed)
+			So(e.RequestID, ShouldEqual, "req-2")
+			So(buf.String(), ShouldContainSubstring, fmt.Sprintf("error=%s method=Collector.CollectMetrics request_id=req-2", err))
+		})
+		Convey("carries the request ID of a rejected call", func() {
+			ss.suspension.set(true)
+			_, err := collect("req-3")
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeSuspended)
+			So(err.(*PluginError).RequestID, ShouldEqual, "req-3")
+			So(c.seen, ShouldBeEmpty)
+		})
+		Convey("logs a call without a request ID", func() {
+			r, err := collect("")
+			So(err, ShouldBeNil)
+			So(r.RequestID, ShouldBeEmpty)
+			So(c.seen, ShouldResemble, []string{""})
+			So(buf.String(), ShouldContainSubstring, "method=Collector.CollectMetrics request_id=\n")
+		})
+		Convey("logs each call once", func() {
+			_, err := collect("req-4")
+			So(err, ShouldBeNil)
+			ss.recordCall("Collector.CollectMetrics", 0, nil)
+			So(strings.Count(buf.String(), "Call served"), ShouldEqual, 1)
+		})
+	})
+	Convey("A processor's helper reads the request ID from the session", t, func() {
+		m := NewPluginMeta("traced", 1, ProcessorPluginType, []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+		p := &tracingProcessor{}
+		ss, err, _ := NewSessionState("{}", p, m)
+		So(err, ShouldBeNil)
+		proxy := &processorPluginProxy{Plugin: p, Session: ss, Meta: m, config: ss.config, requests: ss.requests}
+		in, err := ss.Encode(ProcessorArgs{ContentType: SnapGOBContentType, Content: []byte("metrics"), Token: ss.Token(), RequestID: "req-5"})
+		So(err, ShouldBeNil)
+		var out []byte
+		So(proxy.Process(in, &out), ShouldBeNil)
+		r := ProcessorReply{}
+		So(ss.Decode(out, &r), ShouldBeNil)
+		So(r.RequestID, ShouldEqual, "req-5")
+		So(r.Content, ShouldResemble, []byte("metrics"))
+		So(p.helped, ShouldResemble, []string{"req-5"})
+	})
+}
+
+func TestSlowCall(t *testing.T) {
+	Convey("A session with a SlowCallThreshold", t, func() {
+		m := NewPluginMeta("slow", 1, CollectorPluginType, nil, nil, Unsecure(true), Exclusive(true))
+		c := &laggingCollector{delay: 100 * time.Millisecond, started: make(chan struct{})}
+		ss, err, _ := NewSessionState(fmt.Sprintf(`{"LogLevel": "info", "SlowCallThreshold": %d}`, 50*time.Millisecond), c, m)
+		So(err, ShouldBeNil)
+		var buf bytes.Buffer
+		ss.Logger().(*log.Entry).Logger.Out = &buf
+		proxy := &collectorPluginProxy{Plugin: c, Session: ss, Meta: m, requests: ss.requests}
+		collect := func(id string) error {
+			in, err := ss.Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: ss.Token(), RequestID: id})
+			if err != nil {
+				return err
+			}
+			return proxy.CollectMetrics(in, &[]byte{})
+		}
+
+		Convey("warns once of a slow call, not of the call queued behind it", func() {
+			slow := make(chan error, 1)
+			go func() { slow <- collect("slow-1") }()
+			<-c.started
+			So(collect("queued-1"), ShouldBeNil)
+			So(<-slow, ShouldBeNil)
+
+			So(strings.Count(buf.String(), "Slow call"), ShouldEqual, 1)
+			So(buf.String(), ShouldContainSubstring, "Slow call to Collector.CollectMetrics took ")
+			So(buf.String(), ShouldContainSubstring, " method=Collector.CollectMetrics namespaces=/foo/*/bar,/foo/baz queued=")
+			So(buf.String(), ShouldContainSubstring, " request_id=slow-1\n")
+			So(buf.String(), ShouldNotContainSubstring, "request_id=queued-1")
+
+			in, err := ss.Encode(StatsArgs{Token: ss.Token()})
+			So(err, ShouldBeNil)
+			var out []byte
+			So(ss.GetStats(in, &out), ShouldBeNil)
+			var st SessionStats
+			So(ss.Decode(out, &st), ShouldBeNil)
+			So(st.SlowCalls, ShouldEqual, 1)
+		})
+	})
+	Convey("The SlowCallThreshold", t, func() {
+		m := NewPluginMeta("slow", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		ss, err, _ := NewSessionState("{}", &mockPlugin{}, m)
+		So(err, ShouldBeNil)
+		So(ss.SlowCallThreshold, ShouldEqual, DefaultSlowCallThreshold)
+		So(ss.requests.threshold, ShouldEqual, DefaultSlowCallThreshold)
+		ss, err, _ = NewSessionState(`{"SlowCallThreshold": -1}`, &mockPlugin{}, m)
+		So(err, ShouldBeNil)
+		So(ss.requests.threshold, ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,185 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"math/rand"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+// The config keys overriding the DefaultRetryPolicy of a publisher, see
+// AddRetryRules.
+const (
+	RetryMaxAttemptsKey    = "retry_max_attempts"
+	RetryInitialBackoffKey = "retry_initial_backoff_ms"
+	RetryMaxBackoffKey     = "retry_max_backoff_ms"
+	RetryJitterKey         = "retry_jitter"
+)
+
+// RetryPolicy is how the session retries a Publish call which failed with a
+// retryable error.
+type RetryPolicy struct {
+	// MaxAttempts is the most times Publish is called, 1 for no retry
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry.  It doubles with
+	// each retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter spreads each wait by up to this fraction of it either way, so
+	// that publishers failing together do not retry together.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is applied to a Publish call whose config does not
+// set the retry keys.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Jitter:         0.2,
+}
+
+// RetryableError is returned by a publisher for a transient failure, e.g.
+// a sink which is unreachable for now, so that the session retries the
+// call.  Other errors fail the call at once.
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+// Retryable marks err as transient, see RetryableError
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &RetryableError{Err: err}
+}
+
+// RetryClassifier may be implemented by a publisher to decide itself which
+// of its errors are retried, in place of RetryableError.
+type RetryClassifier interface {
+	IsRetryable(error) bool
+}
+
+func isRetryableError(err error) bool {
+	_, ok := err.(*RetryableError)
+	return ok
+}
+
+// retryClassifier returns the classifier of the errors of plugin
+func retryClassifier(plugin Plugin) func(error) bool {
+	if rc, ok := plugin.(RetryClassifier); ok {
+		return rc.IsRetryable
+	}
+	return isRetryableError
+}
+
+// AddRetryRules adds the optional rules of the retry keys to the config
+// policy node of a publisher.
+func AddRetryRules(node *cpolicy.ConfigPolicyNode) error {
+	attempts, err := cpolicy.NewIntegerRule(RetryMaxAttemptsKey, false, DefaultRetryPolicy.MaxAttempts)
+	if err != nil {
+		return err
+	}
+	attempts.SetMinimum(1)
+	initial, err := cpolicy.NewIntegerRule(RetryInitialBackoffKey, false, int(DefaultRetryPolicy.InitialBackoff/time.Millisecond))
+	if err != nil {
+		return err
+	}
+	initial.SetMinimum(0)
+	max, err := cpolicy.NewIntegerRule(RetryMaxBackoffKey, false, int(DefaultRetryPolicy.MaxBackoff/time.Millisecond))
+	if err != nil {
+		return err
+	}
+	max.SetMinimum(0)
+	jitter, err := cpolicy.NewFloatRule(RetryJitterKey, false, DefaultRetryPolicy.Jitter)
+	if err != nil {
+		return err
+	}
+	jitter.SetMinimum(0)
+	jitter.SetMaximum(1)
+	node.Add(attempts, initial, max, jitter)
+	return nil
+}
+
+// retryPolicy returns the DefaultRetryPolicy overridden by the retry keys
+// of config.
+func retryPolicy(config map[string]ctypes.ConfigValue) RetryPolicy {
+	r := DefaultRetryPolicy
+	if v, ok := config[RetryMaxAttemptsKey].(ctypes.ConfigValueInt); ok && v.Value > 0 {
+		r.MaxAttempts = v.Value
+	}
+	if v, ok := config[RetryInitialBackoffKey].(ctypes.ConfigValueInt); ok && v.Value >= 0 {
+		r.InitialBackoff = time.Duration(v.Value) * time.Millisecond
+	}
+	if v, ok := config[RetryMaxBackoffKey].(ctypes.ConfigValueInt); ok && v.Value >= 0 {
+		r.MaxBackoff = time.Duration(v.Value) * time.Millisecond
+	}
+	if v, ok := config[RetryJitterKey].(ctypes.ConfigValueFloat); ok && v.Value >= 0 && v.Value <= 1 {
+		r.Jitter = v.Value
+	}
+	return r
+}
+
+// backoff returns the wait before the given retry, counted from 1
+func (r RetryPolicy) backoff(retry int) time.Duration {
+	d := r.InitialBackoff
+	for i := 1; i < retry && d < r.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	if r.Jitter > 0 {
+		d += time.Duration(r.Jitter * (2*rand.Float64() - 1) * float64(d))
+	}
+	return d
+}
+
+// do calls f until it succeeds, fails with an error retryable does not
+// accept, or has been called MaxAttempts times.  The waits end early with
+// ctx, returning the last error.  It returns the number of calls and the
+// time spent waiting between them.
+func (r RetryPolicy) do(ctx context.Context, retryable func(error) bool, f func() error) (attempts int, delay time.Duration, err error) {
+	for {
+		attempts++
+		err = f()
+		if err == nil || attempts >= r.MaxAttempts || !retryable(err) {
+			return attempts, delay, err
+		}
+		wait := r.backoff(attempts)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempts, delay, err
+		}
+		delay += wait
+	}
+}
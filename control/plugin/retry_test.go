@@ -0,0 +1,136 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// flakySink fails its first publishes with err
+type flakySink struct {
+	MockPublisher
+	failures int
+	err      error
+
+	mutex sync.Mutex
+	calls []time.Time
+}
+
+func (s *flakySink) Publish(_ string, _ []byte, _ map[string]ctypes.ConfigValue) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.calls = append(s.calls, time.Now())
+	if len(s.calls) <= s.failures {
+		return s.err
+	}
+	return nil
+}
+
+func TestRetry(t *testing.T) {
+	Convey("A publisher whose sink fails", t, func() {
+		m := NewPluginMeta("retry", 1, PublisherPluginType, []string{SnapGOBContentType}, nil, Unsecure(true))
+		config := map[string]ctypes.ConfigValue{
+			RetryInitialBackoffKey: ctypes.ConfigValueInt{Value: 20},
+			RetryJitterKey:         ctypes.ConfigValueFloat{Value: 0},
+		}
+		publish := func(sink *flakySink) (*SessionState, PublishReply, error) {
+			ss, err, _ := NewSessionState("{}", sink, m)
+			So(err, ShouldBeNil)
+			proxy := &publisherPluginProxy{Plugin: sink, Session: ss, Meta: m, config: ss.config, requests: ss.requests, retryable: retryClassifier(sink)}
+			in, err := ss.Encode(PublishArgs{ContentType: SnapGOBContentType, Token: ss.Token(), Config: config})
+			So(err, ShouldBeNil)
+			var out []byte
+			var reply PublishReply
+			if err := proxy.Publish(in, &out); err != nil {
+				return ss, reply, err
+			}
+			So(ss.Decode(out, &reply), ShouldBeNil)
+			return ss, reply, nil
+		}
+
+		Convey("with a retryable error retries it with growing gaps", func() {
+			sink := &flakySink{failures: 2, err: Retryable(errors.New("sink unreachable"))}
+			ss, reply, err := publish(sink)
+			So(err, ShouldBeNil)
+			So(sink.calls, ShouldHaveLength, 3)
+			first, second := sink.calls[1].Sub(sink.calls[0]), sink.calls[2].Sub(sink.calls[1])
+			So(first, ShouldBeGreaterThanOrEqualTo, 20*time.Millisecond)
+			So(second, ShouldBeGreaterThanOrEqualTo, 40*time.Millisecond)
+			So(reply.Attempts, ShouldEqual, 3)
+			So(reply.RetryDelay, ShouldEqual, 60*time.Millisecond)
+			methods, _, _ := ss.stats.snapshot()
+			So(methods["Publisher.Publish"].Retries, ShouldEqual, 2)
+			So(methods["Publisher.Publish"].RetryDelay, ShouldEqual, 60*time.Millisecond)
+		})
+		Convey("more times than its attempts fails with the last error", func() {
+			sink := &flakySink{failures: 5, err: Retryable(errors.New("sink unreachable"))}
+			_, _, err := publish(sink)
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeCallFailed)
+			So(err.Error(), ShouldContainSubstring, "sink unreachable")
+			So(sink.calls, ShouldHaveLength, DefaultRetryPolicy.MaxAttempts)
+		})
+		Convey("with an error which is not retryable fails at once", func() {
+			sink := &flakySink{failures: 2, err: errors.New("bad payload")}
+			ss, _, err := publish(sink)
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeCallFailed)
+			So(sink.calls, ShouldHaveLength, 1)
+			methods, _, _ := ss.stats.snapshot()
+			So(methods["Publisher.Publish"].Retries, ShouldEqual, 0)
+		})
+	})
+	Convey("A RetryPolicy", t, func() {
+		r := RetryPolicy{MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+		So(r.backoff(1), ShouldEqual, 100*time.Millisecond)
+		So(r.backoff(2), ShouldEqual, 200*time.Millisecond)
+		So(r.backoff(3), ShouldEqual, 300*time.Millisecond)
+		So(r.backoff(10), ShouldEqual, 300*time.Millisecond)
+		r.Jitter = 0.5
+		for i := 0; i < 20; i++ {
+			So(r.backoff(1), ShouldBeBetweenOrEqual, 50*time.Millisecond, 150*time.Millisecond)
+		}
+
+		Convey("is read from the config", func() {
+			r := retryPolicy(map[string]ctypes.ConfigValue{
+				RetryMaxAttemptsKey:    ctypes.ConfigValueInt{Value: 7},
+				RetryInitialBackoffKey: ctypes.ConfigValueInt{Value: 10},
+				RetryMaxBackoffKey:     ctypes.ConfigValueInt{Value: 1000},
+				RetryJitterKey:         ctypes.ConfigValueFloat{Value: 2},
+			})
+			So(r, ShouldResemble, RetryPolicy{MaxAttempts: 7, InitialBackoff: 10 * time.Millisecond, MaxBackoff: time.Second, Jitter: DefaultRetryPolicy.Jitter})
+			So(retryPolicy(nil), ShouldResemble, DefaultRetryPolicy)
+		})
+		Convey("has config rules", func() {
+			node := cpolicy.NewPolicyNode()
+			So(AddRetryRules(node), ShouldBeNil)
+			So(node.RulesAsTable(), ShouldHaveLength, 4)
+			_, errs := node.Process(map[string]ctypes.ConfigValue{RetryMaxAttemptsKey: ctypes.ConfigValueInt{Value: 0}})
+			So(errs.HasErrors(), ShouldBeTrue)
+		})
+	})
+}
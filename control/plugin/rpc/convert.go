@@ -0,0 +1,258 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rpc
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/intelsdi-x/snap/core"
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+type metric struct {
+	namespace          core.Namespace
+	version            int
+	config             *cdata.ConfigDataNode
+	lastAdvertisedTime time.Time
+	timeStamp          time.Time
+	data               interface{}
+	tags               map[string]string
+	description        string
+	unit               string
+}
+
+func (m *metric) Namespace() core.Namespace     { return m.namespace }
+func (m *metric) Config() *cdata.ConfigDataNode { return m.config }
+func (m *metric) Version() int                  { return m.version }
+func (m *metric) Data() interface{}             { return m.data }
+func (m *metric) Tags() map[string]string       { return m.tags }
+func (m *metric) LastAdvertisedTime() time.Time { return m.lastAdvertisedTime }
+func (m *metric) Timestamp() time.Time          { return m.timeStamp }
+func (m *metric) Description() string           { return m.description }
+func (m *metric) Unit() string                  { return m.unit }
+
+func ToCoreMetrics(mts []*Metric) []core.Metric {
+	metrics := make([]core.Metric, len(mts))
+	for i, mt := range mts {
+		metrics[i] = ToCoreMetric(mt)
+	}
+	return metrics
+}
+
+func ToCoreMetric(mt *Metric) core.Metric {
+	ret := &metric{
+		namespace:          ToCoreNamespace(mt.Namespace),
+		version:            int(mt.Version),
+		tags:               mt.Tags,
+		timeStamp:          fromTime(mt.Timestamp),
+		lastAdvertisedTime: fromTime(mt.LastAdvertisedTime),
+		config:             ConfigMapToConfig(mt.Config),
+		description:        mt.Description,
+		unit:               mt.Unit,
+	}
+
+	switch mt.Data.(type) {
+	case *Metric_BytesData:
+		ret.data = mt.GetBytesData()
+	case *Metric_StringData:
+		ret.data = mt.GetStringData()
+	case *Metric_Float32Data:
+		ret.data = mt.GetFloat32Data()
+	case *Metric_Float64Data:
+		ret.data = mt.GetFloat64Data()
+	case *Metric_Int32Data:
+		ret.data = mt.GetInt32Data()
+	case *Metric_Int64Data:
+		ret.data = mt.GetInt64Data()
+	case *Metric_BoolData:
+		ret.data = mt.GetBoolData()
+	}
+
+	return ret
+}
+
+func NewMetrics(ms []core.Metric) ([]*Metric, error) {
+	metrics := make([]*Metric, len(ms))
+	for i, m := range ms {
+		var err error
+		if metrics[i], err = ToMetric(m); err != nil {
+			return nil, err
+		}
+	}
+	return metrics, nil
+}
+
+func ToMetric(co core.Metric) (*Metric, error) {
+	cm := &Metric{
+		Namespace:          ToNamespace(co.Namespace()),
+		Version:            int64(co.Version()),
+		Tags:               co.Tags(),
+		Timestamp:          ToTime(co.Timestamp()),
+		LastAdvertisedTime: ToTime(co.LastAdvertisedTime()),
+		Unit:               co.Unit(),
+		Description:        co.Description(),
+	}
+	if co.Config() != nil {
+		var err error
+		if cm.Config, err = ConfigToConfigMap(co.Config()); err != nil {
+			return nil, fmt.Errorf("%s: %v", co.Namespace(), err)
+		}
+	}
+	switch t := co.Data().(type) {
+	case string:
+		cm.Data = &Metric_StringData{t}
+	case float64:
+		cm.Data = &Metric_Float64Data{t}
+	case float32:
+		cm.Data = &Metric_Float32Data{t}
+	case int32:
+		cm.Data = &Metric_Int32Data{t}
+	case int:
+		cm.Data = &Metric_Int64Data{int64(t)}
+	case int64:
+		cm.Data = &Metric_Int64Data{t}
+	case []byte:
+		cm.Data = &Metric_BytesData{t}
+	case bool:
+		cm.Data = &Metric_BoolData{t}
+	case nil:
+		cm.Data = nil
+	default:
+		log.Error(fmt.Sprintf("unsupported type: %s", t))
+	}
+	return cm, nil
+}
+
+func ToCoreNamespace(n []*NamespaceElement) core.Namespace {
+	var namespace core.Namespace
+	for _, val := range n {
+		ele := core.NamespaceElement{
+			Value:       val.Value,
+			Description: val.Description,
+			Name:        val.Name,
+		}
+		namespace = append(namespace, ele)
+	}
+	return namespace
+}
+
+func ConfigMapToConfig(cfg *ConfigMap) *cdata.ConfigDataNode {
+	if cfg == nil {
+		return nil
+	}
+	config := cdata.FromTable(ParseConfig(cfg))
+	return config
+}
+
+// ToConfigMap fails on a config value of a type the ConfigMap does not
+// carry, and on a secure string which was not sealed: only its Ciphertext
+// is sent.
+func ToConfigMap(cv map[string]ctypes.ConfigValue) (*ConfigMap, error) {
+	newConfig := &ConfigMap{
+		IntMap:          make(map[string]int64),
+		FloatMap:        make(map[string]float64),
+		StringMap:       make(map[string]string),
+		BoolMap:         make(map[string]bool),
+		SecureStringMap: make(map[string][]byte),
+	}
+	for k, v := range cv {
+		switch t := v.(type) {
+		case ctypes.ConfigValueInt:
+			newConfig.IntMap[k] = int64(t.Value)
+		case ctypes.ConfigValueFloat:
+			newConfig.FloatMap[k] = t.Value
+		case ctypes.ConfigValueStr:
+			newConfig.StringMap[k] = t.Value
+		case ctypes.ConfigValueBool:
+			newConfig.BoolMap[k] = t.Value
+		case ctypes.ConfigValueSecureString:
+			if t.Ciphertext == nil {
+				return nil, fmt.Errorf("config %q: secure string is not sealed", k)
+			}
+			newConfig.SecureStringMap[k] = t.Ciphertext
+		default:
+			return nil, fmt.Errorf("config %q: unsupported type %T", k, v)
+		}
+	}
+	return newConfig, nil
+}
+
+func ToNamespace(n core.Namespace) []*NamespaceElement {
+	elements := make([]*NamespaceElement, 0, len(n))
+	for _, value := range n {
+		ne := &NamespaceElement{
+			Value:       value.Value,
+			Description: value.Description,
+			Name:        value.Name,
+		}
+		elements = append(elements, ne)
+	}
+	return elements
+}
+
+func ConfigToConfigMap(cd *cdata.ConfigDataNode) (*ConfigMap, error) {
+	if cd == nil {
+		return nil, nil
+	}
+	return ToConfigMap(cd.Table())
+}
+
+func ParseConfig(config *ConfigMap) map[string]ctypes.ConfigValue {
+	c := make(map[string]ctypes.ConfigValue)
+	for k, v := range config.IntMap {
+		ival := ctypes.ConfigValueInt{Value: int(v)}
+		c[k] = ival
+	}
+	for k, v := range config.FloatMap {
+		fval := ctypes.ConfigValueFloat{Value: v}
+		c[k] = fval
+	}
+	for k, v := range config.StringMap {
+		sval := ctypes.ConfigValueStr{Value: v}
+		c[k] = sval
+	}
+	for k, v := range config.BoolMap {
+		bval := ctypes.ConfigValueBool{Value: v}
+		c[k] = bval
+	}
+	for k, v := range config.SecureStringMap {
+		c[k] = ctypes.ConfigValueSecureString{Ciphertext: v}
+	}
+	return c
+}
+
+func ToTime(t time.Time) *Time {
+	return &Time{
+		Sec:  t.Unix(),
+		Nsec: int64(t.Nanosecond()),
+	}
+}
+
+// fromTime returns the time.Time for t or the zero time when t is not set.
+func fromTime(t *Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return time.Unix(t.Sec, t.Nsec)
+}
@@ -430,6 +430,8 @@ type ConfigMap struct {
 	// double is float64
 	FloatMap map[string]float64 `protobuf:"bytes,3,rep,name=FloatMap,json=floatMap" json:"FloatMap,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"fixed64,2,opt,name=value"`
 	BoolMap  map[string]bool    `protobuf:"bytes,4,rep,name=BoolMap,json=boolMap" json:"BoolMap,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	// ciphertext of ctypes.ConfigValueSecureString
+	SecureStringMap map[string][]byte `protobuf:"bytes,5,rep,name=SecureStringMap,json=secureStringMap" json:"SecureStringMap,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
 }
 
 func (m *ConfigMap) Reset()                    { *m = ConfigMap{} }
@@ -465,6 +467,13 @@ func (m *ConfigMap) GetBoolMap() map[string]bool {
 	return nil
 }
 
+func (m *ConfigMap) GetSecureStringMap() map[string][]byte {
+	if m != nil {
+		return m.SecureStringMap
+	}
+	return nil
+}
+
 type KillArg struct {
 	Reason string `protobuf:"bytes,1,opt,name=Reason,json=reason" json:"Reason,omitempty"`
 }
@@ -1238,90 +1247,92 @@ func init() {
 }
 
 var fileDescriptor0 = []byte{
-	// 1345 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xcc, 0x58, 0xdb, 0x6e, 0x13, 0x47,
-	0x18, 0xf6, 0x66, 0x7d, 0xda, 0x7f, 0x1d, 0x27, 0x19, 0x51, 0xea, 0x1a, 0x10, 0x66, 0x29, 0x60,
-	0x0a, 0x75, 0x5a, 0x87, 0x52, 0x08, 0xed, 0x05, 0x94, 0x94, 0x00, 0x0d, 0xb2, 0x96, 0x94, 0x9b,
-	0x4a, 0x45, 0xeb, 0xf5, 0xc4, 0x19, 0x75, 0x4f, 0x9d, 0x1d, 0x47, 0xf1, 0x13, 0x54, 0x42, 0xaa,
-	0xd4, 0xe7, 0xe9, 0x55, 0x2f, 0x7a, 0x51, 0xf5, 0x25, 0xfa, 0x12, 0x7d, 0x80, 0x6a, 0x0e, 0x6b,
-	0xcf, 0xfa, 0x80, 0xc9, 0x45, 0x25, 0xee, 0xe6, 0x3f, 0x7c, 0x9f, 0xe7, 0xff, 0xe6, 0x9f, 0x99,
-	0x1d, 0xc3, 0xee, 0x90, 0xb0, 0xe3, 0x51, 0xbf, 0xe3, 0xc7, 0xe1, 0x36, 0x89, 0x18, 0x0e, 0xd2,
-	0x01, 0xf9, 0xf4, 0x74, 0x3b, 0x8d, 0xbc, 0x64, 0xdb, 0x8f, 0x23, 0x46, 0xe3, 0x60, 0x3b, 0x09,
-	0x46, 0x43, 0x12, 0x6d, 0xd3, 0xc4, 0x57, 0xc3, 0x4e, 0x42, 0x63, 0x16, 0x23, 0x93, 0x26, 0xbe,
-	0x53, 0x81, 0xd2, 0x5e, 0x98, 0xb0, 0xb1, 0xd3, 0x82, 0xea, 0x1e, 0xa5, 0x2e, 0x4e, 0x82, 0x31,
-	0x3a, 0x07, 0x25, 0x4c, 0x69, 0x4c, 0x1b, 0x46, 0xcb, 0x68, 0x5b, 0xae, 0x34, 0x9c, 0xdb, 0x50,
-	0x3c, 0x24, 0x21, 0x46, 0x9b, 0x60, 0xa6, 0xd8, 0x17, 0x31, 0xd3, 0xe5, 0x43, 0x84, 0xa0, 0x18,
-	0x71, 0xd7, 0x9a, 0x70, 0x89, 0xb1, 0xf3, 0x23, 0x6c, 0xbe, 0xf0, 0x42, 0x9c, 0x26, 0x9e, 0x8f,
-	0xf7, 0x02, 0x1c, 0xe2, 0x88, 0x71, 0xde, 0x57, 0x5e, 0x30, 0xc2, 0x19, 0xef, 0x09, 0x37, 0x50,
-	0x0b, 0xec, 0xc7, 0x38, 0xf5, 0x29, 0x49, 0x18, 0x89, 0x23, 0x41, 0x62, 0xb9, 0xf6, 0x60, 0xea,
-	0xe2, 0xfc, 0x9c, 0xab, 0x61, 0x8a, 0x50, 0x31, 0xf2, 0x42, 0xec, 0xfc, 0x00, 0xd0, 0x1b, 0xf5,
-	0x7b, 0x34, 0xf6, 0x1f, 0xd2, 0x21, 0xba, 0x06, 0x95, 0x03, 0xcc, 0x28, 0xf1, 0xd3, 0x86, 0xd1,
-	0x32, 0xdb, 0x76, 0xd7, 0xee, 0xd0, 0xc4, 0xef, 0x48, 0x9f, 0x5b, 0x09, 0x65, 0x0c, 0x5d, 0x87,
-	0xf2, 0x37, 0x71, 0x74, 0x44, 0x86, 0xe2, 0x57, 0xec, 0x6e, 0x5d, 0x64, 0x49, 0xd7, 0x81, 0x97,
-	0xb8, 0x65, 0x5f, 0x0c, 0x9d, 0x7f, 0x8b, 0x50, 0x96, 0x58, 0xb4, 0x03, 0xd6, 0xa4, 0x0e, 0xc5,
-	0xfd, 0x81, 0x40, 0xcd, 0x56, 0xe7, 0x5a, 0x51, 0xe6, 0x41, 0x0d, 0xa8, 0xbc, 0xc2, 0x34, 0xcd,
-	0xca, 0x31, 0xdd, 0xca, 0x89, 0x34, 0xb5, 0x19, 0x98, 0x6f, 0x9b, 0x01, 0xba, 0x0f, 0xe8, 0x3b,
-	0x2f, 0x65, 0x0f, 0x07, 0x27, 0x98, 0x32, 0x92, 0xe2, 0x01, 0x97, 0xbe, 0x51, 0x14, 0x18, 0x4b,
-	0x60, 0xb8, 0xc3, 0x45, 0xc1, 0x5c, 0x12, 0xba, 0x09, 0xc5, 0x43, 0x6f, 0x98, 0x36, 0x4a, 0xda,
-	0x64, 0x65, 0x31, 0x1d, 0xee, 0xdf, 0x8b, 0x18, 0x1d, 0xbb, 0x45, 0xe6, 0x0d, 0x53, 0x74, 0x03,
-	0x2c, 0x0e, 0x49, 0x99, 0x17, 0x26, 0x8d, 0xf2, 0x2c, 0xb9, 0xc5, 0xb2, 0x18, 0x5f, 0x81, 0xef,
-	0x23, 0xc2, 0x1a, 0x15, 0xb9, 0x02, 0xa3, 0x88, 0xb0, 0xd9, 0x75, 0xab, 0xce, 0xaf, 0xdb, 0x15,
-	0xb0, 0x53, 0x46, 0x49, 0x34, 0x7c, 0x3d, 0xf0, 0x98, 0xd7, 0xb0, 0x78, 0xc6, 0x7e, 0xc1, 0x05,
-	0xe9, 0x7c, 0xec, 0x31, 0x0f, 0x5d, 0x85, 0xda, 0x51, 0x10, 0x7b, 0x6c, 0xa7, 0x2b, 0x73, 0xa0,
-	0x65, 0xb4, 0xd7, 0xf6, 0x0b, 0xae, 0xad, 0xbc, 0xb9, 0xa4, 0xbb, 0x77, 0x64, 0x92, 0xdd, 0x32,
-	0xda, 0xc6, 0x24, 0xe9, 0xee, 0x1d, 0x91, 0x74, 0x19, 0x80, 0x44, 0x13, 0x9e, 0x5a, 0xcb, 0x68,
-	0x97, 0xf6, 0x0b, 0xae, 0x25, 0x7c, 0x5a, 0x42, 0xc6, 0xb1, 0xce, 0xd7, 0x45, 0x25, 0x4c, 0x19,
-	0xfa, 0x63, 0x86, 0x53, 0x99, 0x50, 0x6f, 0x19, 0xed, 0x1a, 0x4f, 0x10, 0x3e, 0x91, 0x70, 0x09,
-	0xac, 0x7e, 0x1c, 0x07, 0x32, 0xbe, 0xd1, 0x32, 0xda, 0xd5, 0xfd, 0x82, 0x5b, 0xe5, 0x2e, 0x1e,
-	0x6e, 0x7e, 0x09, 0xd6, 0x44, 0x60, 0xbe, 0x4b, 0x7e, 0xc2, 0x63, 0xd5, 0xe9, 0x7c, 0xc8, 0xbb,
-	0x5f, 0x34, 0xbc, 0xea, 0x70, 0x69, 0xec, 0xae, 0xdd, 0x33, 0x1e, 0x95, 0xa1, 0xc8, 0x29, 0x9d,
-	0x7f, 0x4c, 0xb0, 0x26, 0xad, 0x80, 0xba, 0x50, 0x7e, 0x1a, 0xb1, 0x03, 0x2f, 0x51, 0x6d, 0xd7,
-	0xcc, 0xb7, 0x4a, 0x47, 0x06, 0xe5, 0x72, 0x96, 0x89, 0x30, 0xd0, 0x03, 0xb0, 0x5e, 0x0a, 0x71,
-	0x39, 0x6c, 0x4d, 0xc0, 0x2e, 0xcd, 0xc0, 0x26, 0x71, 0x89, 0xb4, 0xd2, 0xcc, 0x46, 0xf7, 0xa0,
-	0xfa, 0x2d, 0x17, 0x94, 0x63, 0x4d, 0x81, 0xbd, 0x38, 0x83, 0xcd, 0xc2, 0x12, 0x5a, 0x3d, 0x52,
-	0x26, 0xfa, 0x02, 0x2a, 0x8f, 0xe2, 0x38, 0xe0, 0xc0, 0xa2, 0x00, 0x5e, 0x98, 0x01, 0xaa, 0xa8,
-	0xc4, 0x55, 0xfa, 0xd2, 0x6a, 0xde, 0x07, 0x5b, 0x2b, 0x62, 0x95, 0x64, 0xa6, 0x26, 0x59, 0xf3,
-	0x2b, 0xa8, 0xe7, 0x0b, 0x39, 0x8b, 0xe0, 0xcd, 0x07, 0xb0, 0x9e, 0x2b, 0x65, 0x15, 0xd8, 0xd0,
-	0xc1, 0xbb, 0x50, 0xd3, 0xcb, 0x59, 0x85, 0xad, 0x6a, 0x58, 0xe7, 0x0a, 0x54, 0x9e, 0x93, 0x20,
-	0xe0, 0x47, 0xd6, 0x79, 0x28, 0xbb, 0xd8, 0x4b, 0xe3, 0x48, 0x21, 0xcb, 0x54, 0x58, 0xce, 0xef,
-	0x25, 0x38, 0xf7, 0x04, 0x33, 0xa9, 0x5d, 0x2f, 0x0e, 0x88, 0x3f, 0x7e, 0xcb, 0xa9, 0x8c, 0x9e,
-	0x81, 0x2d, 0x7a, 0x32, 0x11, 0x99, 0x6a, 0xcd, 0x6f, 0x0a, 0xf9, 0x17, 0xb1, 0x88, 0x95, 0x90,
-	0xb6, 0x5c, 0x0c, 0xe8, 0x4f, 0x1c, 0xe8, 0x40, 0xed, 0xb3, 0x8c, 0x4c, 0x36, 0xc1, 0x27, 0xcb,
-	0xc9, 0x84, 0x88, 0x3a, 0x9b, 0xdc, 0x91, 0x8a, 0xee, 0x25, 0xd4, 0xf9, 0x9d, 0x34, 0xc4, 0x34,
-	0x23, 0x94, 0xcd, 0x71, 0x7b, 0x39, 0xe1, 0x53, 0x99, 0xaf, 0x53, 0xae, 0x13, 0xdd, 0x87, 0x7a,
-	0xb0, 0xae, 0xce, 0x14, 0xc5, 0x29, 0x8f, 0xb9, 0x5b, 0xcb, 0x39, 0x65, 0x9f, 0xe8, 0x94, 0xb5,
-	0x54, 0x73, 0x35, 0x5f, 0xc0, 0xc6, 0x8c, 0x28, 0x0b, 0x96, 0xf4, 0x9a, 0xbe, 0xa4, 0x76, 0x77,
-	0x43, 0xfc, 0xdc, 0x14, 0xa6, 0xf7, 0x47, 0x0f, 0x36, 0x67, 0x75, 0x59, 0x40, 0x78, 0x3d, 0x4f,
-	0xb8, 0x29, 0x08, 0x35, 0x9c, 0xce, 0x78, 0x08, 0x68, 0x5e, 0x98, 0x05, 0x9c, 0xed, 0x3c, 0x27,
-	0x12, 0x9c, 0x39, 0xa4, 0xce, 0xea, 0xc2, 0xd6, 0x9c, 0x34, 0x0b, 0x48, 0x6f, 0xe4, 0x49, 0xb7,
-	0x04, 0xa9, 0x0e, 0xd4, 0xfb, 0xdb, 0x83, 0x2a, 0x17, 0xc5, 0x1d, 0x05, 0x18, 0x35, 0xa1, 0x4a,
-	0xf1, 0xcf, 0x23, 0x42, 0xf1, 0x40, 0xf0, 0x55, 0xdd, 0x89, 0xcd, 0x2f, 0xc8, 0x01, 0x3e, 0xf2,
-	0x46, 0x01, 0x53, 0x7b, 0x24, 0x33, 0xd1, 0x65, 0xb0, 0x8f, 0xbd, 0xf4, 0x75, 0x16, 0x35, 0x45,
-	0x14, 0x8e, 0xbd, 0xf4, 0xb1, 0xf4, 0x38, 0xbf, 0x18, 0x00, 0x53, 0xe1, 0xd1, 0x67, 0x50, 0xa2,
-	0xa3, 0x00, 0xa7, 0xb9, 0x43, 0x72, 0x1a, 0xef, 0xf0, 0xa9, 0xa8, 0x3b, 0x4f, 0x26, 0x36, 0x9f,
-	0x00, 0x4c, 0x9d, 0x0b, 0x0a, 0xbe, 0x9a, 0x2f, 0x78, 0x7d, 0xc2, 0xc8, 0x51, 0x7a, 0xb1, 0x7f,
-	0x19, 0x60, 0x89, 0x15, 0x7b, 0x97, 0x72, 0x43, 0x12, 0x91, 0x70, 0x14, 0xaa, 0xe3, 0x24, 0x33,
-	0x45, 0xc4, 0x3b, 0x15, 0x11, 0x53, 0x45, 0xa4, 0xa9, 0x4b, 0x54, 0x94, 0x91, 0x25, 0x12, 0x95,
-	0x66, 0x25, 0x42, 0x1f, 0x42, 0x85, 0x27, 0x84, 0x24, 0x12, 0x97, 0x7a, 0xd5, 0x2d, 0x1f, 0x7b,
-	0xe9, 0x01, 0x89, 0x26, 0x01, 0xef, 0x54, 0xdc, 0xe4, 0x2a, 0xe0, 0x9d, 0x3a, 0x6f, 0x0c, 0xb0,
-	0xb5, 0xe6, 0x43, 0x9f, 0xe7, 0x55, 0xbd, 0x30, 0xdb, 0x9d, 0x0b, 0x64, 0xdd, 0x5f, 0x21, 0xeb,
-	0xc7, 0x79, 0x59, 0xeb, 0x53, 0xca, 0x59, 0x5d, 0xff, 0x36, 0xc4, 0xbd, 0xc0, 0xbb, 0xf6, 0xac,
-	0xca, 0x9a, 0x4b, 0x95, 0x35, 0x97, 0x2a, 0x6b, 0xfe, 0xaf, 0xca, 0xfe, 0x66, 0xc0, 0x7a, 0x6e,
-	0x0b, 0xa2, 0x9d, 0xbc, 0xb6, 0x97, 0xe6, 0x77, 0xe9, 0x02, 0x75, 0x9f, 0xad, 0x50, 0x77, 0xe1,
-	0x71, 0xa2, 0x89, 0xa8, 0xeb, 0xeb, 0x03, 0xc8, 0xfd, 0x7b, 0xd6, 0x6d, 0x6a, 0x9d, 0x61, 0x9b,
-	0xfe, 0x6a, 0x40, 0x4d, 0x3f, 0x25, 0x50, 0x37, 0x5f, 0xf6, 0xc5, 0xb9, 0x73, 0x64, 0x41, 0xd5,
-	0x4f, 0x57, 0x54, 0xbd, 0xf0, 0x54, 0x9e, 0xd6, 0xa6, 0x17, 0xbd, 0x03, 0xa0, 0x5e, 0x08, 0xea,
-	0xbd, 0x10, 0xae, 0x7e, 0x2f, 0x38, 0xcf, 0xa1, 0xa6, 0x40, 0xf2, 0x0a, 0x7e, 0x37, 0xd8, 0xf4,
-	0xa6, 0x5e, 0xd3, 0xdf, 0x4f, 0x0f, 0x60, 0xeb, 0x09, 0x66, 0x32, 0xf7, 0x70, 0x9c, 0x60, 0x31,
-	0x91, 0xeb, 0xa0, 0xbe, 0xf8, 0x45, 0x59, 0x4b, 0xdf, 0x03, 0xdd, 0x37, 0x6b, 0xfc, 0xd3, 0x30,
-	0x08, 0xb0, 0xcf, 0x62, 0x8a, 0xee, 0x42, 0x5d, 0x19, 0x6a, 0x7a, 0x68, 0x43, 0x9b, 0x08, 0x27,
-	0x6e, 0x6e, 0xe9, 0x0e, 0x31, 0x7b, 0xa7, 0x80, 0xbe, 0x86, 0x7a, 0x7e, 0x0a, 0xe8, 0x7c, 0x76,
-	0x6f, 0xe6, 0xe7, 0xb5, 0x18, 0x7e, 0x15, 0x8a, 0x3d, 0x12, 0x0d, 0x11, 0x88, 0xa0, 0x78, 0x37,
-	0x36, 0xe5, 0xf1, 0x98, 0x3d, 0x1d, 0x9d, 0x02, 0xba, 0x06, 0x45, 0xfe, 0x89, 0x83, 0x6a, 0x22,
-	0xa0, 0xbe, 0x76, 0xe6, 0xd3, 0x76, 0x61, 0x63, 0xe6, 0xb6, 0xce, 0xd1, 0x7e, 0xb4, 0xf4, 0x3e,
-	0x77, 0x0a, 0xdd, 0x3f, 0x0d, 0xb0, 0xf8, 0xcb, 0x0f, 0xa7, 0x69, 0x4c, 0xd1, 0x36, 0x54, 0x94,
-	0xa1, 0x54, 0x98, 0xbe, 0x0b, 0xdf, 0xef, 0x32, 0xfe, 0xe0, 0x65, 0x8c, 0xfa, 0x01, 0x49, 0x8f,
-	0x31, 0x45, 0xb7, 0xa0, 0xa2, 0x8c, 0xf9, 0x32, 0xe6, 0x7e, 0xf6, 0x3d, 0x29, 0xa1, 0x5f, 0x16,
-	0x7f, 0x25, 0xec, 0xfc, 0x17, 0x00, 0x00, 0xff, 0xff, 0x25, 0xb7, 0x84, 0x93, 0x88, 0x10, 0x00,
-	0x00,
+	// 1378 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xcc, 0x58, 0xdd, 0x6e, 0x13, 0x47,
+	0x14, 0xf6, 0x66, 0xfd, 0xb7, 0x67, 0x1d, 0x3b, 0x19, 0x51, 0xea, 0x1a, 0x10, 0x66, 0x53, 0xc0,
+	0x14, 0xea, 0xb4, 0x0e, 0xa5, 0x10, 0xda, 0x0b, 0x28, 0x29, 0x01, 0x1a, 0x14, 0x2d, 0x29, 0x37,
+	0x95, 0x8a, 0xc6, 0xeb, 0x89, 0xb3, 0xea, 0x7a, 0x77, 0x3b, 0x3b, 0x46, 0xf1, 0x13, 0x54, 0x42,
+	0xaa, 0xd4, 0xe7, 0xe9, 0x55, 0x2f, 0x7a, 0x51, 0xf5, 0x71, 0xaa, 0x3e, 0x40, 0x35, 0x3f, 0x6b,
+	0xcf, 0xda, 0x6b, 0x4c, 0x2e, 0x2a, 0x71, 0xb7, 0xe7, 0xe7, 0xfb, 0x32, 0xe7, 0x3b, 0x67, 0x66,
+	0x3c, 0x81, 0xdd, 0xa1, 0xcf, 0x4e, 0xc6, 0xfd, 0xae, 0x17, 0x8d, 0xb6, 0xfd, 0x90, 0x91, 0x20,
+	0x19, 0xf8, 0x9f, 0x9e, 0x6e, 0x27, 0x21, 0x8e, 0xb7, 0xbd, 0x28, 0x64, 0x34, 0x0a, 0xb6, 0xe3,
+	0x60, 0x3c, 0xf4, 0xc3, 0x6d, 0x1a, 0x7b, 0xea, 0xb3, 0x1b, 0xd3, 0x88, 0x45, 0xc8, 0xa4, 0xb1,
+	0xe7, 0x54, 0xa0, 0xb4, 0x37, 0x8a, 0xd9, 0xc4, 0x69, 0x43, 0x75, 0x8f, 0x52, 0x97, 0xc4, 0xc1,
+	0x04, 0x9d, 0x83, 0x12, 0xa1, 0x34, 0xa2, 0x4d, 0xa3, 0x6d, 0x74, 0x2c, 0x57, 0x1a, 0xce, 0x2d,
+	0x28, 0x1e, 0xf9, 0x23, 0x82, 0x36, 0xc0, 0x4c, 0x88, 0x27, 0x62, 0xa6, 0xcb, 0x3f, 0x11, 0x82,
+	0x62, 0xc8, 0x5d, 0x6b, 0xc2, 0x25, 0xbe, 0x9d, 0x1f, 0x61, 0xe3, 0x39, 0x1e, 0x91, 0x24, 0xc6,
+	0x1e, 0xd9, 0x0b, 0xc8, 0x88, 0x84, 0x8c, 0xf3, 0xbe, 0xc4, 0xc1, 0x98, 0xa4, 0xbc, 0xaf, 0xb9,
+	0x81, 0xda, 0x60, 0x3f, 0x22, 0x89, 0x47, 0xfd, 0x98, 0xf9, 0x51, 0x28, 0x48, 0x2c, 0xd7, 0x1e,
+	0xcc, 0x5c, 0x9c, 0x9f, 0x73, 0x35, 0x4d, 0x11, 0x2a, 0x86, 0x78, 0x44, 0x9c, 0x1f, 0x00, 0x0e,
+	0xc7, 0xfd, 0x43, 0x1a, 0x79, 0x0f, 0xe8, 0x10, 0x5d, 0x85, 0xca, 0x01, 0x61, 0xd4, 0xf7, 0x92,
+	0xa6, 0xd1, 0x36, 0x3b, 0x76, 0xcf, 0xee, 0xd2, 0xd8, 0xeb, 0x4a, 0x9f, 0x5b, 0x19, 0xc9, 0x18,
+	0xba, 0x06, 0xe5, 0x6f, 0xa2, 0xf0, 0xd8, 0x1f, 0x8a, 0xbf, 0x62, 0xf7, 0xea, 0x22, 0x4b, 0xba,
+	0x0e, 0x70, 0xec, 0x96, 0x3d, 0xf1, 0xe9, 0xfc, 0x5b, 0x84, 0xb2, 0xc4, 0xa2, 0x1d, 0xb0, 0xa6,
+	0x75, 0x28, 0xee, 0x0f, 0x04, 0x6a, 0xbe, 0x3a, 0xd7, 0x0a, 0x53, 0x0f, 0x6a, 0x42, 0xe5, 0x25,
+	0xa1, 0x49, 0x5a, 0x8e, 0xe9, 0x56, 0x5e, 0x4b, 0x53, 0x5b, 0x81, 0xf9, 0xb6, 0x15, 0xa0, 0x7b,
+	0x80, 0xbe, 0xc3, 0x09, 0x7b, 0x30, 0x78, 0x4d, 0x28, 0xf3, 0x13, 0x32, 0xe0, 0xd2, 0x37, 0x8b,
+	0x02, 0x63, 0x09, 0x0c, 0x77, 0xb8, 0x28, 0x58, 0x48, 0x42, 0x37, 0xa0, 0x78, 0x84, 0x87, 0x49,
+	0xb3, 0xa4, 0x2d, 0x56, 0x16, 0xd3, 0xe5, 0xfe, 0xbd, 0x90, 0xd1, 0x89, 0x5b, 0x64, 0x78, 0x98,
+	0xa0, 0xeb, 0x60, 0x71, 0x48, 0xc2, 0xf0, 0x28, 0x6e, 0x96, 0xe7, 0xc9, 0x2d, 0x96, 0xc6, 0x78,
+	0x07, 0xbe, 0x0f, 0x7d, 0xd6, 0xac, 0xc8, 0x0e, 0x8c, 0x43, 0x9f, 0xcd, 0xf7, 0xad, 0xba, 0xd8,
+	0xb7, 0x2b, 0x60, 0x27, 0x8c, 0xfa, 0xe1, 0xf0, 0xd5, 0x00, 0x33, 0xdc, 0xb4, 0x78, 0xc6, 0x7e,
+	0xc1, 0x05, 0xe9, 0x7c, 0x84, 0x19, 0x46, 0x5b, 0x50, 0x3b, 0x0e, 0x22, 0xcc, 0x76, 0x7a, 0x32,
+	0x07, 0xda, 0x46, 0x67, 0x6d, 0xbf, 0xe0, 0xda, 0xca, 0x9b, 0x49, 0xba, 0x73, 0x5b, 0x26, 0xd9,
+	0x6d, 0xa3, 0x63, 0x4c, 0x93, 0xee, 0xdc, 0x16, 0x49, 0x97, 0x01, 0xfc, 0x70, 0xca, 0x53, 0x6b,
+	0x1b, 0x9d, 0xd2, 0x7e, 0xc1, 0xb5, 0x84, 0x4f, 0x4b, 0x48, 0x39, 0xd6, 0x79, 0x5f, 0x54, 0xc2,
+	0x8c, 0xa1, 0x3f, 0x61, 0x24, 0x91, 0x09, 0xf5, 0xb6, 0xd1, 0xa9, 0xf1, 0x04, 0xe1, 0x13, 0x09,
+	0x97, 0xc0, 0xea, 0x47, 0x51, 0x20, 0xe3, 0x8d, 0xb6, 0xd1, 0xa9, 0xee, 0x17, 0xdc, 0x2a, 0x77,
+	0xf1, 0x70, 0xeb, 0x4b, 0xb0, 0xa6, 0x02, 0xf3, 0x5d, 0xf2, 0x13, 0x99, 0xa8, 0x49, 0xe7, 0x9f,
+	0x7c, 0xfa, 0xc5, 0xc0, 0xab, 0x09, 0x97, 0xc6, 0xee, 0xda, 0x5d, 0xe3, 0x61, 0x19, 0x8a, 0x9c,
+	0xd2, 0xf9, 0xa7, 0x08, 0xd6, 0x74, 0x14, 0x50, 0x0f, 0xca, 0x4f, 0x42, 0x76, 0x80, 0x63, 0x35,
+	0x76, 0xad, 0xec, 0xa8, 0x74, 0x65, 0x50, 0xb6, 0xb3, 0xec, 0x0b, 0x03, 0xdd, 0x07, 0xeb, 0x85,
+	0x10, 0x97, 0xc3, 0xd6, 0x04, 0xec, 0xd2, 0x1c, 0x6c, 0x1a, 0x97, 0x48, 0x2b, 0x49, 0x6d, 0x74,
+	0x17, 0xaa, 0xdf, 0x72, 0x41, 0x39, 0xd6, 0x14, 0xd8, 0x8b, 0x73, 0xd8, 0x34, 0x2c, 0xa1, 0xd5,
+	0x63, 0x65, 0xa2, 0x2f, 0xa0, 0xf2, 0x30, 0x8a, 0x02, 0x0e, 0x2c, 0x0a, 0xe0, 0x85, 0x39, 0xa0,
+	0x8a, 0x4a, 0x5c, 0xa5, 0x2f, 0x2d, 0x74, 0x00, 0x8d, 0x17, 0xc4, 0x1b, 0x53, 0x32, 0x5b, 0xb3,
+	0x1c, 0xda, 0xad, 0xf9, 0x35, 0x67, 0xb3, 0x24, 0x4d, 0x23, 0xc9, 0x7a, 0x5b, 0xf7, 0xc0, 0xd6,
+	0x34, 0x59, 0xd5, 0x01, 0x53, 0xeb, 0x40, 0xeb, 0x2b, 0xa8, 0x67, 0xd9, 0xcf, 0xd2, 0xbf, 0xd6,
+	0x7d, 0x58, 0xcf, 0x28, 0xb3, 0x0a, 0x6c, 0xe8, 0xe0, 0x5d, 0xa8, 0xe9, 0xea, 0xac, 0xc2, 0x56,
+	0x75, 0xec, 0x43, 0x38, 0x97, 0x27, 0xcd, 0x2a, 0x8e, 0x9a, 0xc6, 0xe1, 0x5c, 0x81, 0xca, 0x33,
+	0x3f, 0x08, 0xf8, 0x29, 0x7a, 0x1e, 0xca, 0x2e, 0xc1, 0x49, 0x14, 0x2a, 0x64, 0x99, 0x0a, 0xcb,
+	0xf9, 0xbd, 0x04, 0xe7, 0x1e, 0x13, 0x26, 0xfb, 0x71, 0x18, 0x05, 0xbe, 0x37, 0x79, 0xcb, 0x45,
+	0x81, 0x9e, 0x82, 0x2d, 0xb6, 0x49, 0x2c, 0x32, 0xd5, 0x18, 0xde, 0x10, 0x2d, 0xcd, 0x63, 0x11,
+	0xc3, 0x21, 0x6d, 0xd9, 0x58, 0xe8, 0x4f, 0x1d, 0xe8, 0x40, 0x6d, 0xfd, 0x94, 0x4c, 0xce, 0xe5,
+	0x27, 0xcb, 0xc9, 0x44, 0x23, 0x74, 0x36, 0xfb, 0x78, 0xe6, 0x41, 0x2f, 0xa0, 0xce, 0xaf, 0xc9,
+	0x21, 0xa1, 0x29, 0xa1, 0x9c, 0xd7, 0x5b, 0xcb, 0x09, 0x9f, 0xc8, 0x7c, 0x9d, 0x72, 0xdd, 0xd7,
+	0x7d, 0xe8, 0x10, 0xd6, 0xd5, 0x31, 0xa7, 0x38, 0xe5, 0x10, 0xdf, 0x5c, 0xce, 0x29, 0xdb, 0xa5,
+	0x53, 0xd6, 0x12, 0xcd, 0xd5, 0x7a, 0x0e, 0x8d, 0x39, 0x51, 0x72, 0x5a, 0x7a, 0x55, 0x6f, 0xa9,
+	0xdd, 0x6b, 0x88, 0x3f, 0x37, 0x83, 0xe9, 0x73, 0x72, 0x08, 0x1b, 0xf3, 0xba, 0xe4, 0x10, 0x5e,
+	0xcb, 0x12, 0x6e, 0x08, 0x42, 0x0d, 0xa7, 0x33, 0x1e, 0x01, 0x5a, 0x14, 0x26, 0x87, 0xb3, 0x93,
+	0xe5, 0x44, 0x82, 0x33, 0x83, 0xd4, 0x59, 0x5d, 0xd8, 0x5c, 0x90, 0x26, 0x87, 0xf4, 0x7a, 0x96,
+	0x74, 0x53, 0x90, 0xea, 0x40, 0x7d, 0xbe, 0x31, 0x54, 0xb9, 0x28, 0xee, 0x38, 0x20, 0xa8, 0x05,
+	0x55, 0x4a, 0x7e, 0x1e, 0xfb, 0x94, 0x0c, 0x04, 0x5f, 0xd5, 0x9d, 0xda, 0xfc, 0xce, 0x1e, 0x90,
+	0x63, 0x3c, 0x0e, 0x98, 0xda, 0x67, 0xa9, 0x89, 0x2e, 0x83, 0x7d, 0x82, 0x93, 0x57, 0x69, 0xd4,
+	0x14, 0x51, 0x38, 0xc1, 0xc9, 0x23, 0xe9, 0x71, 0x7e, 0x31, 0x00, 0x66, 0xc2, 0xa3, 0xcf, 0xa0,
+	0x44, 0xc7, 0x01, 0x49, 0x32, 0xe7, 0xf6, 0x2c, 0xde, 0xe5, 0x4b, 0x51, 0xd7, 0xb0, 0x4c, 0x6c,
+	0x3d, 0x06, 0x98, 0x39, 0x73, 0x0a, 0xde, 0xca, 0x16, 0xbc, 0x3e, 0x65, 0xe4, 0x28, 0xbd, 0xd8,
+	0xbf, 0x0c, 0xb0, 0x44, 0xc7, 0xde, 0xa5, 0xdc, 0x91, 0x1f, 0xfa, 0xa3, 0xf1, 0x48, 0x1d, 0x49,
+	0xa9, 0x29, 0x22, 0xf8, 0x54, 0x44, 0x4c, 0x15, 0xc1, 0xa7, 0x69, 0x24, 0x15, 0xa1, 0x28, 0x23,
+	0x4b, 0x24, 0x2a, 0xcd, 0x4b, 0x84, 0x3e, 0x84, 0x0a, 0x4f, 0x18, 0xf9, 0xa1, 0xf8, 0x9d, 0x51,
+	0x75, 0xcb, 0x27, 0x38, 0x39, 0xf0, 0xc3, 0x69, 0x00, 0x9f, 0x36, 0x2b, 0xb3, 0x00, 0x3e, 0x75,
+	0xde, 0x18, 0x60, 0x6b, 0xc3, 0x87, 0x3e, 0xcf, 0xaa, 0x7a, 0x61, 0x7e, 0x3a, 0x73, 0x64, 0xdd,
+	0x5f, 0x21, 0xeb, 0xc7, 0x59, 0x59, 0xeb, 0x33, 0xca, 0x79, 0x5d, 0xff, 0x36, 0xc0, 0x56, 0x53,
+	0x7b, 0x56, 0x65, 0xcd, 0xa5, 0xca, 0x9a, 0x4b, 0x95, 0x35, 0xff, 0x57, 0x65, 0x7f, 0x33, 0x60,
+	0x3d, 0xb3, 0x05, 0xd1, 0x4e, 0x56, 0xdb, 0x4b, 0x8b, 0xbb, 0x34, 0x47, 0xdd, 0xa7, 0x2b, 0xd4,
+	0xcd, 0x3d, 0x4e, 0x34, 0x11, 0x75, 0x7d, 0x3d, 0x00, 0xb9, 0x7f, 0xcf, 0xba, 0x4d, 0xad, 0x33,
+	0x6c, 0xd3, 0x5f, 0x0d, 0xa8, 0xe9, 0xa7, 0x04, 0xea, 0x65, 0xcb, 0xbe, 0xb8, 0x70, 0x8e, 0xe4,
+	0x54, 0xfd, 0x64, 0x45, 0xd5, 0xb9, 0xa7, 0xf2, 0xac, 0x36, 0xbd, 0xe8, 0x1d, 0x00, 0xf5, 0x68,
+	0x51, 0x4f, 0x98, 0xd1, 0xea, 0x27, 0x8c, 0xf3, 0x0c, 0x6a, 0x0a, 0x24, 0xaf, 0xe0, 0x77, 0x83,
+	0xcd, 0x6e, 0xea, 0x35, 0xfd, 0x49, 0x77, 0x1f, 0x36, 0x1f, 0x13, 0x26, 0x73, 0x8f, 0x26, 0x31,
+	0x11, 0x0b, 0xb9, 0x06, 0xea, 0x11, 0xd2, 0x34, 0xb4, 0x6d, 0xb1, 0xf0, 0x44, 0xe9, 0xbd, 0x59,
+	0xe3, 0xbf, 0x56, 0x83, 0x80, 0x78, 0x2c, 0xa2, 0xe8, 0x0e, 0xd4, 0x95, 0xa1, 0x96, 0x87, 0x1a,
+	0xda, 0x42, 0x38, 0x71, 0x6b, 0x53, 0x77, 0x88, 0xd5, 0x3b, 0x05, 0xf4, 0x35, 0xd4, 0xb3, 0x4b,
+	0x40, 0xe7, 0xd3, 0x7b, 0x33, 0xbb, 0xae, 0x7c, 0xf8, 0x16, 0x14, 0x0f, 0xfd, 0x70, 0x88, 0x40,
+	0x04, 0xc5, 0x53, 0xb6, 0x25, 0x8f, 0xc7, 0xf4, 0x35, 0xeb, 0x14, 0xd0, 0x55, 0x28, 0xf2, 0x9f,
+	0x38, 0xa8, 0x26, 0x02, 0xea, 0xd7, 0xce, 0x62, 0xda, 0x2e, 0x34, 0xe6, 0x6e, 0xeb, 0x0c, 0xed,
+	0x47, 0x4b, 0xef, 0x73, 0xa7, 0xd0, 0xfb, 0xd3, 0x00, 0x8b, 0x3f, 0x46, 0x49, 0x92, 0x44, 0x14,
+	0x6d, 0x43, 0x45, 0x19, 0x4a, 0x85, 0xd9, 0x53, 0xf5, 0xfd, 0x2e, 0xe3, 0x0f, 0x5e, 0xc6, 0xb8,
+	0x1f, 0xf8, 0xc9, 0x09, 0xa1, 0xe8, 0x26, 0x54, 0x94, 0xb1, 0x58, 0xc6, 0xc2, 0x9f, 0x7d, 0x4f,
+	0x4a, 0xe8, 0x97, 0xc5, 0x7f, 0x37, 0x76, 0xfe, 0x1b, 0x00, 0x4c, 0xd5, 0xae, 0xef, 0x1b, 0x11,
+	0x00, 0x00,
 }
@@ -0,0 +1,429 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+// DefaultRPCRecordMaxSizeMB is the size in megabytes past which the
+// Arg.RPCRecordFile is rotated unless Arg.RPCRecordMaxSizeMB says
+// otherwise.
+const DefaultRPCRecordMaxSizeMB = 10
+
+// invalidToken replaces, in a recording, a session token which was not
+// valid, so that the call fails again when replayed
+const invalidToken = "invalid"
+
+// RecordedCall is a line of an Arg.RPCRecordFile: a call served by the
+// session, in JSON.  Args and Reply show the decoded args and reply of the
+// methods known to the recorder, with the secure config values redacted.
+// The session token is removed, or replaced with "invalid" when the call
+// did not carry the token of the session.  Payload holds the redacted args
+// in gob, for ReplayRPC.
+type RecordedCall struct {
+	Time      time.Time       `json:"time"`
+	Method    string          `json:"method"`
+	RequestID string          `json:"request_id,omitempty"`
+	Args      json.RawMessage `json:"args,omitempty"`
+	Reply     json.RawMessage `json:"reply,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Duration  time.Duration   `json:"duration"`
+	Payload   []byte          `json:"payload,omitempty"`
+}
+
+// recordedMethods returns new args and reply of the methods whose calls
+// are decoded in a recording.  A nil reply is not decoded.
+var recordedMethods = map[string]func() (interface{}, interface{}){
+	"SessionState.Ping":            func() (interface{}, interface{}) { return &PingArgs{}, nil },
+	"SessionState.Kill":            func() (interface{}, interface{}) { return &KillArgs{}, &KillReply{} },
+	"SessionState.GetConfigPolicy": func() (interface{}, interface{}) { return &GetConfigPolicyArgs{}, &GetConfigPolicyReply{} },
+	"SessionState.SetConfig":       func() (interface{}, interface{}) { return &SetConfigArgs{}, &SetConfigReply{} },
+	"Collector.CollectMetrics":     func() (interface{}, interface{}) { return &CollectMetricsArgs{}, &CollectMetricsReply{} },
+	"Collector.GetMetricTypes":     func() (interface{}, interface{}) { return &GetMetricTypesArgs{}, &GetMetricTypesReply{} },
+	"Processor.Process":            func() (interface{}, interface{}) { return &ProcessorArgs{}, &ProcessorReply{} },
+	"Publisher.Publish":            func() (interface{}, interface{}) { return &PublishArgs{}, &PublishReply{} },
+}
+
+// rpcRecorder appends the calls served by a session to Arg.RPCRecordFile.
+// A nil rpcRecorder records nothing.
+type rpcRecorder struct {
+	out     io.WriteCloser
+	session *SessionState
+}
+
+// newRPCRecorder returns the recorder of the session, nil when arg does not
+// set RPCRecordFile
+func newRPCRecorder(arg *Arg, s *SessionState) (*rpcRecorder, error) {
+	if arg.RPCRecordFile == "" {
+		return nil, nil
+	}
+	out, err := openRotatingFile(arg.RPCRecordFile, int64(arg.RPCRecordMaxSizeMB)<<20, arg.RPCRecordMaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return &rpcRecorder{out: out, session: s}, nil
+}
+
+// wrap returns c recording the calls it serves
+func (r *rpcRecorder) wrap(c rpc.ServerCodec) rpc.ServerCodec {
+	if r == nil {
+		return c
+	}
+	return &recordingCodec{ServerCodec: c, recorder: r, pending: map[uint64]*pendingRecord{}}
+}
+
+func (r *rpcRecorder) close() {
+	if r == nil {
+		return
+	}
+	r.out.Close()
+}
+
+// record appends a call to method with the encoded args and reply
+func (r *rpcRecorder) record(method string, start time.Time, args, reply []byte, errMsg string) {
+	call := RecordedCall{
+		Time:     start,
+		Method:   method,
+		Error:    errMsg,
+		Duration: time.Since(start),
+	}
+	if newArgs, ok := recordedMethods[method]; ok {
+		a, rp := newArgs()
+		if len(args) > 0 && r.session.Decode(args, a) == nil {
+			if token := argsToken(a); token != nil {
+				if r.session.CheckToken(*token) == nil {
+					*token = ""
+				} else {
+					*token = invalidToken
+				}
+			}
+			redactArgs(a)
+			call.RequestID = requestIDOf(a)
+			call.Args, _ = json.Marshal(a)
+			var buf bytes.Buffer
+			if gob.NewEncoder(&buf).Encode(a) == nil {
+				call.Payload = buf.Bytes()
+			}
+		}
+		if rp != nil && len(reply) > 0 && r.session.Decode(reply, rp) == nil {
+			call.Reply, _ = json.Marshal(rp)
+		}
+	}
+	line, err := json.Marshal(call)
+	if err != nil {
+		r.session.Logger().Warnf("Recording %s failed: %v", method, err)
+		return
+	}
+	if _, err := r.out.Write(append(line, '\n')); err != nil {
+		r.session.Logger().Warnf("Recording %s failed: %v", method, err)
+	}
+}
+
+// argsToken returns the session token carried by the args of a call
+func argsToken(args interface{}) *string {
+	switch a := args.(type) {
+	case *PingArgs:
+		return &a.Token
+	case *KillArgs:
+		return &a.Token
+	case *GetConfigPolicyArgs:
+		return &a.Token
+	case *SetConfigArgs:
+		return &a.Token
+	case *CollectMetricsArgs:
+		return &a.Token
+	case *GetMetricTypesArgs:
+		return &a.Token
+	case *ProcessorArgs:
+		return &a.Token
+	case *PublishArgs:
+		return &a.Token
+	}
+	return nil
+}
+
+// redactArgs removes the secure config values from the args of a recorded
+// call
+func redactArgs(args interface{}) {
+	switch a := args.(type) {
+	case *SetConfigArgs:
+		a.Config = redactConfig(a.Config)
+	case *CollectMetricsArgs:
+		for i, mt := range a.MetricTypes {
+			if mt.Config_ != nil {
+				a.MetricTypes[i].Config_ = cdata.FromTable(redactConfig(mt.Config_.Table()))
+			}
+		}
+	case *GetMetricTypesArgs:
+		if a.PluginConfig.ConfigDataNode != nil {
+			a.PluginConfig.ConfigDataNode = cdata.FromTable(redactConfig(a.PluginConfig.Table()))
+		}
+	case *ProcessorArgs:
+		a.Config = redactConfig(a.Config)
+	case *PublishArgs:
+		a.Config = redactConfig(a.Config)
+	}
+}
+
+// redactConfig returns a copy of config with each secure string replaced
+// by ctypes.Redacted
+func redactConfig(config map[string]ctypes.ConfigValue) map[string]ctypes.ConfigValue {
+	if !hasSecureValues(config) {
+		return config
+	}
+	redacted := make(map[string]ctypes.ConfigValue, len(config))
+	for k, v := range config {
+		if _, ok := v.(ctypes.ConfigValueSecureString); ok {
+			v = ctypes.ConfigValueStr{Value: ctypes.Redacted}
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
+// requestIDOf returns the request ID of the args of a call, if any
+func requestIDOf(args interface{}) string {
+	switch a := args.(type) {
+	case *CollectMetricsArgs:
+		return a.RequestID
+	case *GetMetricTypesArgs:
+		return a.RequestID
+	case *ProcessorArgs:
+		return a.RequestID
+	case *PublishArgs:
+		return a.RequestID
+	}
+	return ""
+}
+
+// recordingCodec records each call served through the wrapped codec
+type recordingCodec struct {
+	rpc.ServerCodec
+	recorder *rpcRecorder
+
+	mutex   sync.Mutex
+	pending map[uint64]*pendingRecord
+	// seq is the call whose body is read next
+	seq uint64
+}
+
+type pendingRecord struct {
+	method string
+	start  time.Time
+	args   []byte
+}
+
+func (c *recordingCodec) ReadRequestHeader(r *rpc.Request) error {
+	if err := c.ServerCodec.ReadRequestHeader(r); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.pending[r.Seq] = &pendingRecord{method: r.ServiceMethod, start: time.Now()}
+	c.seq = r.Seq
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *recordingCodec) ReadRequestBody(x interface{}) error {
+	if err := c.ServerCodec.ReadRequestBody(x); err != nil {
+		return err
+	}
+	if b, ok := x.(*[]byte); ok {
+		c.mutex.Lock()
+		if p, ok := c.pending[c.seq]; ok {
+			p.args = append([]byte(nil), *b...)
+		}
+		c.mutex.Unlock()
+	}
+	return nil
+}
+
+func (c *recordingCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.mutex.Lock()
+	p, ok := c.pending[r.Seq]
+	delete(c.pending, r.Seq)
+	c.mutex.Unlock()
+	if ok {
+		var reply []byte
+		if b, isBytes := body.(*[]byte); isBytes && b != nil {
+			reply = *b
+		}
+		c.recorder.record(p.method, p.start, p.args, reply, r.Error)
+	}
+	return c.ServerCodec.WriteResponse(r, body)
+}
+
+// readRecordedCalls returns the calls recorded at path, in order
+func readRecordedCalls(path string) ([]RecordedCall, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var calls []RecordedCall
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var call RecordedCall
+		if err := json.Unmarshal(line, &call); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		calls = append(calls, call)
+	}
+	return calls, scanner.Err()
+}
+
+// ReplayRPC calls the plugin c with the calls recorded at path, see
+// Arg.RPCRecordFile, in a session of its own which serves no listener.  It
+// writes to out each call whose reply or error differs from the recording
+// and returns their number.  Kill is not replayed, nor are the calls
+// recorded without a Payload.  The deadlines of the calls are dropped, and
+// their redacted config values are replayed as ctypes.Redacted.  The
+// catalog times stamped by the session are not compared.
+func ReplayRPC(path string, m *PluginMeta, c Plugin, out io.Writer) (int, error) {
+	calls, err := readRecordedCalls(path)
+	if err != nil {
+		return 0, err
+	}
+	// The recorded args are replayed in gob, without encryption
+	meta := *m
+	meta.RPCType = NativeRPC
+	meta.Unsecure = true
+	s, err, _ := NewSessionState("{}", c, &meta)
+	if err != nil {
+		return 0, err
+	}
+	defer s.endSession(Shutdown{Reason: "replay done", Source: ShutdownSourceControl})
+	server, _, err := newServer(s, &meta, c)
+	if err != nil {
+		return 0, err
+	}
+	s.runInit()
+	serverConn, clientConn := net.Pipe()
+	go server.ServeCodec(newGobServerCodec(serverConn))
+	client := rpc.NewClient(clientConn)
+	defer client.Close()
+
+	diffs := 0
+	for i, call := range calls {
+		newArgs, ok := recordedMethods[call.Method]
+		if !ok || call.Method == "SessionState.Kill" || len(call.Payload) == 0 {
+			continue
+		}
+		a, rp := newArgs()
+		if err := gob.NewDecoder(bytes.NewReader(call.Payload)).Decode(a); err != nil {
+			return diffs, fmt.Errorf("%s: call %d: %v", path, i+1, err)
+		}
+		clearDeadline(a)
+		if token := argsToken(a); token != nil && *token == "" {
+			*token = s.Token()
+		}
+		in, err := s.Encode(a)
+		if err != nil {
+			return diffs, fmt.Errorf("%s: call %d: %v", path, i+1, err)
+		}
+		var reply []byte
+		var replyView json.RawMessage
+		errMsg := ""
+		if err := client.Call(call.Method, in, &reply); err != nil {
+			errMsg = err.Error()
+		}
+		if rp != nil && len(reply) > 0 && s.Decode(reply, rp) == nil {
+			replyView, _ = json.Marshal(rp)
+		}
+		name := call.Method
+		if call.RequestID != "" {
+			name = fmt.Sprintf("%s (request %s)", call.Method, call.RequestID)
+		}
+		if errMsg != call.Error {
+			diffs++
+			fmt.Fprintf(out, "call %d %s: error differs\n  recorded: %q\n  replayed: %q\n", i+1, name, call.Error, errMsg)
+			continue
+		}
+		if !bytes.Equal(normalizeReply(call.Method, replyView), normalizeReply(call.Method, call.Reply)) {
+			diffs++
+			fmt.Fprintf(out, "call %d %s: reply differs\n  recorded: %s\n  replayed: %s\n", i+1, name, call.Reply, replyView)
+		}
+	}
+	return diffs, nil
+}
+
+// clearDeadline drops the deadline of replayed args, long passed
+func clearDeadline(args interface{}) {
+	switch a := args.(type) {
+	case *CollectMetricsArgs:
+		a.Deadline = time.Time{}
+	case *ProcessorArgs:
+		a.Deadline = time.Time{}
+	case *PublishArgs:
+		a.Deadline = time.Time{}
+	}
+}
+
+// normalizeReply returns the JSON reply of a call to method without the
+// catalog times stamped by the session, for comparison
+func normalizeReply(method string, reply []byte) []byte {
+	var v interface{}
+	if len(reply) == 0 || json.Unmarshal(reply, &v) != nil {
+		return reply
+	}
+	if m, ok := v.(map[string]interface{}); ok && method == "Collector.GetMetricTypes" {
+		delete(m, "Timestamp")
+	}
+	dropAdvertisedTimes(v)
+	b, err := json.Marshal(v)
+	if err != nil {
+		return reply
+	}
+	return b
+}
+
+// dropAdvertisedTimes removes the last_advertised_time of the metrics in v
+func dropAdvertisedTimes(v interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		delete(t, "last_advertised_time")
+		for _, e := range t {
+			dropAdvertisedTimes(e)
+		}
+	case []interface{}:
+		for _, e := range t {
+			dropAdvertisedTimes(e)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	"github.com/intelsdi-x/snap/core"
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRPCRecordReplay(t *testing.T) {
+	Convey("A session recording its calls", t, func() {
+		dir, err := ioutil.TempDir("", "snap-rpc-record")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "calls.jsonl")
+
+		m := NewPluginMeta("toy", 1, CollectorPluginType, []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+		resp, done := startTestPlugin(m, &toyCollector{}, fmt.Sprintf(`{"RPCRecordFile": %q, "PingTimeoutDuration": %d, "KillDelay": %d}`, path, time.Minute, time.Millisecond))
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer client.Close()
+		enc := encoding.NewGobEncoder()
+		call := func(method string, args interface{}) error {
+			in, err := enc.Encode(args)
+			So(err, ShouldBeNil)
+			var reply []byte
+			return client.Call(method, in, &reply)
+		}
+
+		secret := ctypes.ConfigValueSecureString{Ciphertext: []byte("sealed-password")}
+		node := cdata.FromTable(map[string]ctypes.ConfigValue{"user": ctypes.ConfigValueStr{Value: "bob"}, "password": secret})
+		mts := []MetricType{{Namespace_: core.NewNamespace("toy", "calls"), Config_: node}}
+		So(callPing(client, resp.Token), ShouldBeNil)
+		So(call("SessionState.GetConfigPolicy", GetConfigPolicyArgs{Token: resp.Token}), ShouldBeNil)
+		So(call("Collector.GetMetricTypes", GetMetricTypesArgs{Token: resp.Token, PluginConfig: NewPluginConfigType()}), ShouldBeNil)
+		So(call("Collector.CollectMetrics", CollectMetricsArgs{Token: resp.Token, MetricTypes: mts, RequestID: "first", Deadline: time.Now().Add(time.Minute)}), ShouldBeNil)
+		So(call("Collector.CollectMetrics", CollectMetricsArgs{Token: resp.Token, MetricTypes: mts, RequestID: "second"}), ShouldBeNil)
+		So(call("Collector.CollectMetrics", CollectMetricsArgs{Token: "wrong", MetricTypes: mts}), ShouldNotBeNil)
+		So(callKill(client, resp.Token), ShouldBeNil)
+		<-done
+
+		Convey("appends each call to the file", func() {
+			calls, err := readRecordedCalls(path)
+			So(err, ShouldBeNil)
+			So(len(calls), ShouldEqual, 7)
+			So(calls[0].Method, ShouldEqual, "SessionState.Ping")
+			So(calls[3].Method, ShouldEqual, "Collector.CollectMetrics")
+			So(calls[3].RequestID, ShouldEqual, "first")
+			So(string(calls[3].Reply), ShouldContainSubstring, `"RequestID":"first"`)
+			So(calls[5].Error, ShouldEqual, ErrBadToken.Error())
+			So(calls[6].Method, ShouldEqual, "SessionState.Kill")
+		})
+		Convey("without the token or the secure config values", func() {
+			b, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldNotContainSubstring, resp.Token)
+			So(bytes.Contains(b, []byte("sealed-password")), ShouldBeFalse)
+			calls, err := readRecordedCalls(path)
+			So(err, ShouldBeNil)
+			So(bytes.Contains(calls[3].Payload, []byte("sealed-password")), ShouldBeFalse)
+			So(string(calls[3].Args), ShouldContainSubstring, "bob")
+		})
+		Convey("replays against the same implementation without differences", func() {
+			var out bytes.Buffer
+			diffs, err := ReplayRPC(path, m, &toyCollector{}, &out)
+			So(err, ShouldBeNil)
+			So(out.String(), ShouldEqual, "")
+			So(diffs, ShouldEqual, 0)
+		})
+		Convey("reports the replies which differ", func() {
+			var out bytes.Buffer
+			diffs, err := ReplayRPC(path, m, &toyCollector{calls: 5}, &out)
+			So(err, ShouldBeNil)
+			So(diffs, ShouldEqual, 2)
+			So(out.String(), ShouldContainSubstring, "call 4 Collector.CollectMetrics (request first): reply differs")
+			So(out.String(), ShouldContainSubstring, "call 5 Collector.CollectMetrics (request second): reply differs")
+		})
+		Convey("from the command line", func() {
+			var out, errOut bytes.Buffer
+			So(RunCommand(m, &toyCollector{}, []string{"--replay", path}, nil, &out, &errOut), ShouldEqual, 0)
+			So(out.String(), ShouldEqual, "0 calls differ\n")
+			So(RunCommand(m, &toyCollector{}, []string{"--replay"}, nil, &out, &errOut), ShouldEqual, exitUsage)
+		})
+	})
+	Convey("A recording past its size", t, func() {
+		dir, err := ioutil.TempDir("", "snap-rpc-record")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "calls.jsonl")
+		out, err := openRotatingFile(path, 1024, 2)
+		So(err, ShouldBeNil)
+		fs := NewFakeSession(t)
+		r := &rpcRecorder{out: out, session: fs.SessionState}
+		in, err := fs.Encode(PingArgs{Token: fs.Token()})
+		So(err, ShouldBeNil)
+		for i := 0; i < 100; i++ {
+			r.record("SessionState.Ping", time.Now(), in, nil, "")
+		}
+		r.close()
+
+		Convey("is rotated, keeping its latest backups", func() {
+			backups, err := filepath.Glob(path + ".*")
+			So(err, ShouldBeNil)
+			So(len(backups), ShouldEqual, 2)
+			for _, p := range append(backups, path) {
+				fi, err := os.Stat(p)
+				So(err, ShouldBeNil)
+				So(fi.Size(), ShouldBeLessThanOrEqualTo, 1024)
+			}
+			b, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(strings.Count(string(b), "\n"), ShouldBeGreaterThan, 0)
+		})
+	})
+}
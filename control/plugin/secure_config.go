@@ -0,0 +1,95 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+var (
+	// ErrInsecureSession is returned when secure config values would be
+	// sent to a plugin without a session key to encrypt them.
+	ErrInsecureSession error = &PluginError{Code: ErrorCodeConfigInvalid, Message: "secure config values require an encrypted plugin session"}
+	// ErrSessionKeyHandshake is returned by SetKey to a plugin started with
+	// a ControlPubKey, which takes its session key from the handshake.
+	ErrSessionKeyHandshake error = &PluginError{Code: ErrorCodeUnauthorized, Message: "the session key must be set in the handshake"}
+)
+
+// SealConfig returns a copy of config with each secure string encrypted with
+// the session key in e.  The config itself is returned when it holds no secure
+// strings.  A nil e is only allowed in that case.
+func SealConfig(config map[string]ctypes.ConfigValue, e ctypes.Encrypter) (map[string]ctypes.ConfigValue, error) {
+	if !hasSecureValues(config) {
+		return config, nil
+	}
+	if e == nil {
+		return nil, ErrInsecureSession
+	}
+	sealed := make(map[string]ctypes.ConfigValue, len(config))
+	for k, v := range config {
+		if s, ok := v.(ctypes.ConfigValueSecureString); ok {
+			var err error
+			if v, err = s.Seal(e); err != nil {
+				return nil, err
+			}
+		}
+		sealed[k] = v
+	}
+	return sealed, nil
+}
+
+// SealConfigNode is SealConfig for the config of a metric.
+func SealConfigNode(n *cdata.ConfigDataNode, e ctypes.Encrypter) (*cdata.ConfigDataNode, error) {
+	if n == nil {
+		return nil, nil
+	}
+	table := n.Table()
+	if !hasSecureValues(table) {
+		return n, nil
+	}
+	sealed, err := SealConfig(table, e)
+	if err != nil {
+		return nil, err
+	}
+	return cdata.FromTable(sealed), nil
+}
+
+func hasSecureValues(config map[string]ctypes.ConfigValue) bool {
+	for _, v := range config {
+		if _, ok := v.(ctypes.ConfigValueSecureString); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// openConfig lets the secure strings received in config be decrypted with
+// the session key when the plugin reads them.
+func openConfig(config map[string]ctypes.ConfigValue, d ctypes.Decrypter) {
+	if d == nil {
+		return
+	}
+	for k, v := range config {
+		if s, ok := v.(ctypes.ConfigValueSecureString); ok {
+			config[k] = s.Open(d)
+		}
+	}
+}
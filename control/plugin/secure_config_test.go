@@ -0,0 +1,152 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	"github.com/intelsdi-x/snap/control/plugin/encrypter"
+	"github.com/intelsdi-x/snap/core/ctypes"
+
+	log "github.com/Sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testSecret = "correct horse battery staple"
+
+type secretPublisher struct {
+	config map[string]ctypes.ConfigValue
+}
+
+func (s *secretPublisher) Publish(contentType string, content []byte, config map[string]ctypes.ConfigValue) error {
+	s.config = config
+	return nil
+}
+
+func (s *secretPublisher) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	return cpolicy.New(), nil
+}
+
+func TestSecureConfig(t *testing.T) {
+	Convey("Secure string config values", t, func() {
+		key, err := encrypter.GenerateKey()
+		So(err, ShouldBeNil)
+		control := encrypter.New(nil, nil)
+		control.Key = key
+		session := encrypter.New(nil, nil)
+		session.Key = key
+
+		rule, err := cpolicy.NewSecureStringRule("password", true)
+		So(err, ShouldBeNil)
+		node := cpolicy.NewPolicyNode()
+		node.Add(rule)
+		config, perr := node.Process(map[string]ctypes.ConfigValue{
+			"password": ctypes.ConfigValueStr{Value: testSecret},
+			"user":     ctypes.ConfigValueStr{Value: "admin"},
+		})
+		So(perr.HasErrors(), ShouldBeFalse)
+		So((*config)["password"].Type(), ShouldEqual, cpolicy.SecureStringType)
+
+		var logged bytes.Buffer
+		logger := log.New()
+		logger.Out = &logged
+		mockSessionState := &MockSessionState{
+			Encoder:             encoding.NewGobEncoder(),
+			listenPort:          "0",
+			token:               "abcdef",
+			logger:              logger,
+			PingTimeoutDuration: time.Millisecond * 100,
+			killChan:            make(chan int),
+			secretKey:           session,
+		}
+
+		Convey("are redacted when logged", func() {
+			logger.Infof("%v", *config)
+			logger.Infof("%+v", *config)
+			logger.Infof("%#v", *config)
+			logger.WithField("config", *config).Info("processed")
+			So(logged.String(), ShouldContainSubstring, ctypes.Redacted)
+			So(logged.String(), ShouldNotContainSubstring, testSecret)
+		})
+		Convey("require a session key to be sealed", func() {
+			_, err := SealConfig(*config, nil)
+			So(err, ShouldEqual, ErrInsecureSession)
+		})
+		Convey("do not need a session key when none are present", func() {
+			plain := map[string]ctypes.ConfigValue{"user": ctypes.ConfigValueStr{Value: "admin"}}
+			sealed, err := SealConfig(plain, nil)
+			So(err, ShouldBeNil)
+			So(sealed, ShouldResemble, plain)
+		})
+		Convey("are encrypted on the wire and revealed by the plugin", func() {
+			sealed, err := SealConfig(*config, control)
+			So(err, ShouldBeNil)
+			// the config owned by control keeps its plaintext
+			s, err := (*config)["password"].(ctypes.ConfigValueSecureString).Reveal()
+			So(err, ShouldBeNil)
+			So(s, ShouldEqual, testSecret)
+
+			args, err := mockSessionState.Encode(PublishArgs{
+				ContentType: SnapGOBContentType,
+				Config:      sealed,
+				Token:       mockSessionState.Token(),
+			})
+			So(err, ShouldBeNil)
+			So(bytes.Contains(args, []byte(testSecret)), ShouldBeFalse)
+
+			pub := &secretPublisher{}
+			p := &publisherPluginProxy{Plugin: pub, Session: mockSessionState}
+			var reply []byte
+			So(p.Publish(args, &reply), ShouldBeNil)
+
+			received, ok := pub.config["password"].(ctypes.ConfigValueSecureString)
+			So(ok, ShouldBeTrue)
+			s, err = received.Reveal()
+			So(err, ShouldBeNil)
+			So(s, ShouldEqual, testSecret)
+
+			logger.Infof("%v %+v %#v", pub.config, pub.config, pub.config)
+			So(logged.String(), ShouldNotContainSubstring, testSecret)
+		})
+		Convey("survive JSON-RPC encoding", func() {
+			sealed, err := SealConfig(*config, control)
+			So(err, ShouldBeNil)
+			b, err := json.Marshal(PublishArgs{Config: sealed})
+			So(err, ShouldBeNil)
+			So(bytes.Contains(b, []byte(testSecret)), ShouldBeFalse)
+
+			var args PublishArgs
+			So(json.Unmarshal(b, &args), ShouldBeNil)
+			received := args.Config["password"].(ctypes.ConfigValueSecureString)
+			_, err = received.Reveal()
+			So(err, ShouldEqual, ctypes.ErrSecureStringSealed)
+			s, err := received.Open(session).Reveal()
+			So(err, ShouldBeNil)
+			So(s, ShouldEqual, testSecret)
+		})
+	})
+}
@@ -0,0 +1,180 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+// DefaultSelfTestTimeout bounds a SelfTest call when neither its args nor
+// Arg.SelfTestTimeout set a timeout.
+var DefaultSelfTestTimeout = 10 * time.Second
+
+// SelfTester may be implemented by a plugin to check its own dependencies,
+// e.g. connect to its database with the credentials of its config, so
+// that control learns of a broken setup right after loading the plugin
+// rather than when the first task fires.
+type SelfTester interface {
+	SelfTest(config map[string]ctypes.ConfigValue) error
+}
+
+// SelfTestResult is the outcome of a SelfTest call
+type SelfTestResult int
+
+const (
+	// SelfTestPassed means the plugin's SelfTest returned no error
+	SelfTestPassed SelfTestResult = iota
+	// SelfTestFailed means the SelfTest returned an error, or did not
+	// return within its timeout
+	SelfTestFailed
+	// SelfTestNotSupported means the plugin is no SelfTester
+	SelfTestNotSupported
+)
+
+var selfTestResults = [...]string{
+	"passed",
+	"failed",
+	"not supported",
+}
+
+func (r SelfTestResult) String() string {
+	if r < 0 || int(r) >= len(selfTestResults) {
+		return "unknown"
+	}
+	return selfTestResults[r]
+}
+
+// SelfTestArgs are the arguments of SelfTest.  Config completes the config
+// set with SetConfig for the test, and Timeout overrides
+// Arg.SelfTestTimeout when it is set.
+type SelfTestArgs struct {
+	Config  map[string]ctypes.ConfigValue
+	Timeout time.Duration
+	Token   string
+}
+
+// UnmarshalJSON restores the typed config values when SelfTestArgs are
+// received over JSON-RPC.
+func (a *SelfTestArgs) UnmarshalJSON(data []byte) error {
+	args := struct {
+		Config  *cdata.ConfigDataNode
+		Timeout time.Duration
+		Token   string
+	}{}
+	if err := json.Unmarshal(data, &args); err != nil {
+		return err
+	}
+	a.Timeout = args.Timeout
+	a.Token = args.Token
+	a.Config = nil
+	if args.Config != nil {
+		a.Config = args.Config.Table()
+	}
+	return nil
+}
+
+// SelfTestReply is the reply of SelfTest
+type SelfTestReply struct {
+	Result SelfTestResult
+	// Message is the error of a failed test
+	Message  string `json:",omitempty"`
+	Duration time.Duration
+}
+
+// SelfTest runs the SelfTest of the plugin with its current config and
+// replies with the outcome.  The call is bounded by a timeout: a test which
+// does not return in time fails, and keeps its concurrency slot until it
+// returns.
+func (s *SessionState) SelfTest(args []byte, reply *[]byte) (err error) {
+	defer s.recoverPanic("SessionState.SelfTest", &err)
+
+	a := &SelfTestArgs{}
+	if err := s.Decode(args, a); err != nil {
+		return err
+	}
+	if err := s.CheckToken(a.Token); err != nil {
+		return err
+	}
+	s.ResetHeartbeat()
+
+	var r SelfTestReply
+	st, ok := s.plugin.(SelfTester)
+	if !ok {
+		r.Result = SelfTestNotSupported
+		*reply, err = s.Encode(r)
+		return err
+	}
+	timeout := a.Timeout
+	if timeout <= 0 {
+		timeout = s.selfTestTimeout()
+	}
+	ctx, cancel := deadlineContext(s.base.context(), time.Now().Add(timeout))
+	defer cancel()
+	start := time.Now()
+	if err := s.beginCall(ctx); err != nil {
+		return err
+	}
+	openConfig(a.Config, s.decrypter())
+	config := s.config.merge(a.Config)
+	done := make(chan error, 1)
+	go func() {
+		defer s.endCall()
+		var err error
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("SelfTest panicked: %v", p)
+			}
+			done <- err
+		}()
+		err = st.SelfTest(config)
+	}()
+	select {
+	case err := <-done:
+		r.Duration = time.Since(start)
+		if err != nil {
+			r.Result, r.Message = SelfTestFailed, err.Error()
+		}
+	case <-ctx.Done():
+		r.Duration = time.Since(start)
+		r.Result, r.Message = SelfTestFailed, fmt.Sprintf("self-test timed out after %v", timeout)
+		if ctx.Err() != context.DeadlineExceeded {
+			r.Message = "self-test interrupted: the session is ending"
+		}
+	}
+	if r.Result == SelfTestFailed {
+		s.Logger().Errorf("SelfTest failed: %s", r.Message)
+	}
+	*reply, err = s.Encode(r)
+	return err
+}
+
+func (s *SessionState) selfTestTimeout() time.Duration {
+	if s.Arg == nil || s.Arg.SelfTestTimeout <= 0 {
+		return DefaultSelfTestTimeout
+	}
+	return s.Arg.SelfTestTimeout
+}
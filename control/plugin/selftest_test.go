@@ -0,0 +1,114 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// checkedSink tests its connection with the dsn of its config, failing
+// with err or blocking until release is closed
+type checkedSink struct {
+	MockPublisher
+	err     error
+	release chan struct{}
+	dsn     string
+}
+
+func (s *checkedSink) SelfTest(config map[string]ctypes.ConfigValue) error {
+	if v, ok := config["dsn"].(ctypes.ConfigValueStr); ok {
+		s.dsn = v.Value
+	}
+	if s.release != nil {
+		<-s.release
+	}
+	return s.err
+}
+
+func TestSelfTest(t *testing.T) {
+	m := NewPluginMeta("selftest", 1, PublisherPluginType, []string{SnapGOBContentType}, nil, Unsecure(true))
+	selfTest := func(p Plugin, args SelfTestArgs) (SelfTestReply, error) {
+		ss, err, _ := NewSessionState(`{"SelfTestTimeout": 1000000000}`, p, m)
+		So(err, ShouldBeNil)
+		ss.config.set(p, map[string]ctypes.ConfigValue{"dsn": ctypes.ConfigValueStr{Value: "db:5432"}})
+		args.Token = ss.Token()
+		in, err := ss.Encode(args)
+		So(err, ShouldBeNil)
+		var out []byte
+		var r SelfTestReply
+		if err := ss.SelfTest(in, &out); err != nil {
+			return r, err
+		}
+		So(ss.Decode(out, &r), ShouldBeNil)
+		return r, nil
+	}
+
+	Convey("A self-test", t, func() {
+		Convey("passes when the plugin's SelfTest returns no error", func() {
+			sink := &checkedSink{}
+			r, err := selfTest(sink, SelfTestArgs{})
+			So(err, ShouldBeNil)
+			So(r.Result, ShouldEqual, SelfTestPassed)
+			So(r.Message, ShouldBeEmpty)
+			So(sink.dsn, ShouldEqual, "db:5432")
+		})
+		Convey("is given the config of the args over that of the session", func() {
+			sink := &checkedSink{}
+			_, err := selfTest(sink, SelfTestArgs{Config: map[string]ctypes.ConfigValue{"dsn": ctypes.ConfigValueStr{Value: "other:5432"}}})
+			So(err, ShouldBeNil)
+			So(sink.dsn, ShouldEqual, "other:5432")
+		})
+		Convey("fails with the error of the plugin", func() {
+			r, err := selfTest(&checkedSink{err: errors.New("authentication failed")}, SelfTestArgs{})
+			So(err, ShouldBeNil)
+			So(r.Result, ShouldEqual, SelfTestFailed)
+			So(r.Message, ShouldEqual, "authentication failed")
+		})
+		Convey("is not supported by a plugin without SelfTest", func() {
+			r, err := selfTest(new(MockPublisher), SelfTestArgs{})
+			So(err, ShouldBeNil)
+			So(r.Result, ShouldEqual, SelfTestNotSupported)
+			So(r.Result.String(), ShouldEqual, "not supported")
+		})
+		Convey("which hangs fails once its timeout passed", func() {
+			sink := &checkedSink{release: make(chan struct{})}
+			defer close(sink.release)
+			start := time.Now()
+			r, err := selfTest(sink, SelfTestArgs{Timeout: 50 * time.Millisecond})
+			So(err, ShouldBeNil)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+			So(r.Result, ShouldEqual, SelfTestFailed)
+			So(r.Message, ShouldEqual, "self-test timed out after 50ms")
+			So(r.Duration, ShouldBeGreaterThanOrEqualTo, 50*time.Millisecond)
+		})
+	})
+	Convey("A plugin with SelfTest advertises it", t, func() {
+		ss, err, _ := NewSessionState("{}", &checkedSink{}, m)
+		So(err, ShouldBeNil)
+		So(ss.capabilities(&Response{Type: m.Type, Meta: *m}), ShouldContain, CapabilitySelfTest)
+	})
+}
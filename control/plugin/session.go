@@ -20,24 +20,27 @@ limitations under the License.
 package plugin
 
 import (
-	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/gob"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"runtime"
+	"strconv"
+	"sync"
 	"time"
 
-	log "github.com/Sirupsen/logrus"
-
 	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
 	"github.com/intelsdi-x/snap/control/plugin/encoding"
 	"github.com/intelsdi-x/snap/control/plugin/encrypter"
 	"github.com/intelsdi-x/snap/core/cdata"
 	"github.com/intelsdi-x/snap/core/ctypes"
+	"golang.org/x/net/context"
 )
 
 // Session interface
@@ -45,16 +48,29 @@ type Session interface {
 	Ping([]byte, *[]byte) error
 	Kill([]byte, *[]byte) error
 	GetConfigPolicy([]byte, *[]byte) error
-	Logger() *log.Logger
+	Logger() Logger
 	ListenAddress() string
 	SetListenAddress(string)
 	ListenPort() string
 	Token() string
+	CheckToken(string) error
+	// Deprecated: KillChan is closed when the session ends.  Use Done and
+	// ShutdownReason, which also tell why it ended.
 	KillChan() chan int
+	Done() <-chan struct{}
+	ShutdownReason() Shutdown
 	ResetHeartbeat()
 
-	generateResponse(r *Response) []byte
-	heartbeatWatch(killChan chan int)
+	generateResponse(r *Response) ([]byte, error)
+	heartbeatWatch()
+	stopHeartbeat() bool
+	recordCall(method string, d time.Duration, err error)
+	beginCall(context.Context) error
+	endCall()
+	drain() (bool, int)
+	scheduleKill(reason string)
+	runOnKill(KillReason)
+	recoverPanic(method string, err *error)
 	isDaemon() bool
 
 	SetKey(SetKeyArgs, *[]byte) error
@@ -64,13 +80,37 @@ type Session interface {
 	Decode([]byte, interface{}) error
 
 	DecryptKey([]byte) ([]byte, error)
+	decrypter() ctypes.Decrypter
 }
 
+const (
+	// DefaultTokenLength is the number of random bytes in a session token
+	DefaultTokenLength = 32
+	// MinTokenLength is the smallest token length accepted in Arg
+	MinTokenLength = 16
+)
+
+var (
+	// ErrBadToken is returned when an RPC call does not carry the session token
+	ErrBadToken error = &PluginError{Code: ErrorCodeUnauthorized, Message: "invalid session token"}
+	// ErrTokenLength is returned when Arg asks for a token that is too short
+	ErrTokenLength = fmt.Errorf("session token length must be at least %d bytes", MinTokenLength)
+
+	// randReader is the source of session tokens
+	randReader io.Reader = rand.Reader
+)
+
 // Arguments passed to ping
-type PingArgs struct{}
+type PingArgs struct {
+	Token string
+}
 
 type KillArgs struct {
 	Reason string
+	// ReasonCode is passed to the plugin's OnKill hook
+	ReasonCode KillReason
+	Token      string
+	RequestSignature
 }
 
 // Started plugin session state
@@ -79,32 +119,120 @@ type SessionState struct {
 	*encrypter.Encrypter
 	encoding.Encoder
 
-	LastPing time.Time
+	// pingMutex guards lastPing, calls and pings, which are updated by RPC
+	// calls and read by heartbeatWatch
+	pingMutex sync.Mutex
+	lastPing  time.Time
+	calls     uint64
+	pings     uint64
+	started   time.Time
+	// tokenIssued is when the session token was generated
+	tokenIssued time.Time
+
+	// stats is updated by the codec or interceptor serving each call
+	stats sessionStats
+	// inflight counts the calls to the plugin which Kill waits for
+	inflight callTracker
+	// contentType and returnedContentType were negotiated with control
+	contentType         string
+	returnedContentType string
+	// cache holds the collected metrics for the plugin's CacheTTL
+	cache *metricCache
+	// compressor compresses the payloads of large replies
+	compressor *contentCompressor
+	// chunks holds the replies fetched in chunks
+	chunks *chunkStore
+	// config holds the config set with SetConfig
+	config *configStore
+	// suspension fails calls while control suspends the session
+	suspension *suspension
+	// init fails calls until the plugin's Init has succeeded, nil when
+	// the plugin does not implement Initializer
+	init *initialization
+	// pushes holds the batches pushed to control in push mode
+	pushes *pushQueue
+	// requests holds the request IDs of the calls in progress
+	requests *requestTracker
+	// base is the parent context of the calls, cancelled by Kill
+	base *sessionContext
+	// backpressure is reported in the Publish replies of a publisher
+	backpressure *backpressure
+	// batches holds the metrics batched by a publisher, flushed by Kill
+	batches *batcher
+	// acks follows the batches of a DeferredPublisher
+	acks *ackTracker
+	// spool keeps the payloads a publisher failed to publish, see
+	// Arg.SpoolDir
+	spool *spool
+	// simulation replays or records the collections of a collector, see
+	// Arg.ReplayFile and Arg.RecordFile
+	simulation *simulation
+	// recorder records the calls served, see Arg.RPCRecordFile
+	recorder *rpcRecorder
+	// profilerMutex guards profiler, the pprof server stopped as the
+	// session ends, see Arg.EnableProfiling
+	profilerMutex sync.Mutex
+	profiler      *profiler
+	// pool holds a worker per call running, when the plugin limits its
+	// concurrent calls
+	pool *workerPool
+	// onKill runs before the session ends
+	onKill killHook
+	// panics holds the recent panics recovered from RPC handlers
+	panics panicTracker
+
+	// heartbeatStop is closed once by whichever of Kill, teardown or an
+	// expired heartbeat ends the session first
+	heartbeatOnce sync.Once
+	heartbeatStop chan struct{}
+	stopOnce      sync.Once
+
+	// done and killChan are closed once, by endSession, after recording
+	// shutdownReason
+	shutdownInit   sync.Once
+	shutdownOnce   sync.Once
+	done           chan struct{}
+	shutdownReason Shutdown
 
 	plugin        Plugin
 	token         string
 	listenAddress string
+	portRange     *portRange
 	killChan      chan int
-	logger        *log.Logger
+	logger        Logger
 	privateKey    *rsa.PrivateKey
 	encoder       encoding.Encoder
+	nonces        nonceSet
 }
 
-type GetConfigPolicyArgs struct{}
+type GetConfigPolicyArgs struct {
+	Token string
+}
 
 type GetConfigPolicyReply struct {
 	Policy *cpolicy.ConfigPolicy
 }
 
 // GetConfigPolicy returns the plugin's policy
-func (s *SessionState) GetConfigPolicy(args []byte, reply *[]byte) error {
-	defer catchPluginPanic(s.Logger())
+func (s *SessionState) GetConfigPolicy(args []byte, reply *[]byte) (err error) {
+	defer s.recoverPanic("SessionState.GetConfigPolicy", &err)
+
+	s.Logger().Debugf("GetConfigPolicy called")
 
-	s.logger.Debug("GetConfigPolicy called")
+	a := &GetConfigPolicyArgs{}
+	s.Decode(args, a)
+	if err := s.CheckToken(a.Token); err != nil {
+		return err
+	}
 
 	policy, err := s.plugin.GetConfigPolicy()
 	if err != nil {
-		return errors.New(fmt.Sprintf("GetConfigPolicy call error : %s", err.Error()))
+		return &PluginError{Code: ErrorCodeCallFailed, Message: fmt.Sprintf("GetConfigPolicy call error : %s", err.Error())}
+	}
+	// A plugin without requirements may return a nil policy which control
+	// is not able to process.
+	if policy == nil {
+		policy = cpolicy.New()
 	}
 
 	r := GetConfigPolicyReply{Policy: policy}
@@ -121,8 +249,14 @@ func (s *SessionState) Ping(arg []byte, reply *[]byte) error {
 	// For now we return nil. We can return an error if we are shutting
 	// down or otherwise in a state we should signal poor health.
 	// Reply should contain any context.
+	a := &PingArgs{}
+	s.Decode(arg, a)
+	if err := s.CheckToken(a.Token); err != nil {
+		return err
+	}
 	s.ResetHeartbeat()
-	s.logger.Debug("Ping received")
+	s.countPing()
+	s.Logger().Debugf("Ping received")
 	*reply = []byte{}
 	return nil
 }
@@ -134,28 +268,55 @@ func (s *SessionState) Kill(args []byte, reply *[]byte) error {
 	if err != nil {
 		return err
 	}
-	s.logger.Debugf("Kill called by agent, reason: %s\n", a.Reason)
-	go func() {
-		time.Sleep(time.Second * 2)
-		s.killChan <- 0
-	}()
-	*reply = []byte{}
-	return nil
+	if err := s.CheckToken(a.Token); err != nil {
+		return err
+	}
+	if err := s.VerifyRequest(a); err != nil {
+		s.Logger().Errorf("Kill rejected: %v", err)
+		return err
+	}
+	s.Logger().Warnf("Kill called by agent, reason: %s (%v)", a.Reason, a.ReasonCode)
+	if !s.stopHeartbeat() {
+		// The heartbeat already expired and ended the session
+		*reply = []byte{}
+		return nil
+	}
+	drained, n := s.drain()
+	s.runOnKill(a.ReasonCode)
+	s.scheduleKill(a.Reason)
+	*reply, err = s.Encode(KillReply{Drained: drained, InFlight: n})
+	return err
 }
 
-// Logger gets the SessionState logger
-func (s *SessionState) Logger() *log.Logger {
+// Logger gets the SessionState logger, or a default logger when none was
+// set
+func (s *SessionState) Logger() Logger {
+	if s.logger == nil {
+		return defaultLogger
+	}
 	return s.logger
 }
 
+// SetLogger replaces the logger of the session.  A nil logger restores the
+// default.
+func (s *SessionState) SetLogger(l Logger) {
+	s.logger = l
+}
+
+// RequestID returns the request ID of the call being served, see
+// RequestIDReader.
+func (s *SessionState) RequestID() string {
+	return s.requests.current()
+}
+
 // ListenAddress gets the SessionState listen address
 func (s *SessionState) ListenAddress() string {
 	return s.listenAddress
 }
 
-//ListenPort gets the SessionState listen port
+// ListenPort gets the SessionState listen port
 func (s *SessionState) ListenPort() string {
-	return s.listenPort
+	return s.Arg.ListenPort
 }
 
 // SetListenAddress sets SessionState listen address
@@ -163,8 +324,33 @@ func (s *SessionState) SetListenAddress(a string) {
 	s.listenAddress = a
 }
 
+// ResetHeartbeat is called by each call from control which carried the
+// session token.
 func (s *SessionState) ResetHeartbeat() {
-	s.LastPing = time.Now()
+	s.pingMutex.Lock()
+	s.lastPing = time.Now()
+	s.calls++
+	s.pingMutex.Unlock()
+}
+
+func (s *SessionState) countPing() {
+	s.pingMutex.Lock()
+	s.pings++
+	s.pingMutex.Unlock()
+}
+
+// SetLastPing sets the time of the last call from control
+func (s *SessionState) SetLastPing(t time.Time) {
+	s.pingMutex.Lock()
+	s.lastPing = t
+	s.pingMutex.Unlock()
+}
+
+// GetLastPing gets the time of the last call from control
+func (s *SessionState) GetLastPing() time.Time {
+	s.pingMutex.Lock()
+	defer s.pingMutex.Unlock()
+	return s.lastPing
 }
 
 // Token gets the SessionState token
@@ -172,8 +358,31 @@ func (s *SessionState) Token() string {
 	return s.token
 }
 
-// KillChan gets the SessionState killchan
+// CheckToken returns ErrBadToken unless the token matches the session token
+// or token validation has been disabled with Arg.NoTokenCheck.
+func (s *SessionState) CheckToken(token string) error {
+	if s.NoTokenCheck {
+		return nil
+	}
+	if !s.ValidateToken(token) {
+		s.Logger().Debugf("Call rejected: invalid session token")
+		return ErrBadToken
+	}
+	return nil
+}
+
+// ValidateToken reports whether token is the session token.  The comparison
+// takes constant time so the token can't be guessed from response times.
+func (s *SessionState) ValidateToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// KillChan gets the SessionState killchan, which is closed when the session
+// ends.
+//
+// Deprecated: use Done and ShutdownReason.
 func (s *SessionState) KillChan() chan int {
+	s.initShutdown()
 	return s.killChan
 }
 
@@ -182,11 +391,22 @@ func (s *SessionState) isDaemon() bool {
 }
 
 type SetKeyArgs struct {
-	Key []byte
+	Key   []byte
+	Token string
 }
 
+// SetKey sets the session key, encrypted with the plugin's public key.  A
+// plugin started with a ControlPubKey refuses it: its session key is only
+// taken from the signed HandshakeRequest of a connection.
 func (s *SessionState) SetKey(args SetKeyArgs, reply *[]byte) error {
-	s.logger.Debug("SetKey called")
+	s.Logger().Debugf("SetKey called")
+	if err := s.CheckToken(args.Token); err != nil {
+		return err
+	}
+	if s.Arg != nil && s.ControlPubKey != nil {
+		s.Logger().Errorf("SetKey rejected: %v", ErrSessionKeyHandshake)
+		return ErrSessionKeyHandshake
+	}
 	out, err := s.DecryptKey(args.Key)
 	if err != nil {
 		return err
@@ -195,42 +415,151 @@ func (s *SessionState) SetKey(args SetKeyArgs, reply *[]byte) error {
 	return nil
 }
 
+// handshakeKey sets the session key from a verified HandshakeRequest.
+func (s *SessionState) handshakeKey(key []byte) error {
+	if s.Encrypter == nil {
+		return ErrInsecureSession
+	}
+	out, err := s.DecryptKey(key)
+	if err != nil {
+		return err
+	}
+	s.setKey(out)
+	return nil
+}
+
+// decrypter returns the session key used to open secure config values, or
+// nil when the session is not encrypted.
+func (s *SessionState) decrypter() ctypes.Decrypter {
+	if s.Encrypter == nil || s.Encrypter.Key == nil {
+		return nil
+	}
+	return s.Encrypter
+}
+
 func (s *SessionState) setKey(key []byte) {
 	s.Key = key
 }
 
-func (s *SessionState) generateResponse(r *Response) []byte {
+// generateResponse returns the Response to write for control.  It fails if
+// the Response has an undefined State or Type.
+func (s *SessionState) generateResponse(r *Response) ([]byte, error) {
 	// Add common plugin response properties
 	r.ListenAddress = s.listenAddress
+	if s.AdvertiseAddress != "" {
+		r.ListenAddress = s.AdvertiseAddress
+	}
 	r.Token = s.token
-	rs, _ := json.Marshal(r)
-	return rs
+	r.PID = os.Getpid()
+	r.StartedAt = s.started
+	r.GoVersion = runtime.Version()
+	r.OS = runtime.GOOS
+	r.Arch = runtime.GOARCH
+	r.RPCVersion = RPCVersion
+	if !r.Type.IsValid() {
+		return nil, fmt.Errorf("invalid plugin type %d", int(r.Type))
+	}
+	r.Capabilities = s.capabilities(r)
+	r.ContentType = s.contentType
+	r.ReturnedContentType = s.returnedContentType
+	if r.Meta.RPCType != GRPC {
+		r.ContentEncodings = ContentEncodings
+	}
+	return json.Marshal(r)
 }
 
-func (s *SessionState) heartbeatWatch(killChan chan int) {
-	s.logger.Debug("Heartbeat started")
+// heartbeatWatch ends the session once control has failed to call it
+// PingTimeoutLimit times in a row.  It returns without ending the session
+// when the heartbeat is stopped.
+func (s *SessionState) heartbeatWatch() {
+	s.Logger().Debugf("Heartbeat started")
+	// Control has had no chance to ping a session which just started
+	s.SetLastPing(time.Now())
+	limit := s.PingTimeoutLimit
+	if limit == 0 {
+		limit = PingTimeoutLimit
+	}
+	stop := s.stopChan()
+	ticker := time.NewTicker(s.PingTimeoutDuration)
+	defer ticker.Stop()
 	count := 0
 	for {
-		if time.Since(s.LastPing) >= s.PingTimeoutDuration {
-			count++
-			s.logger.Infof("Heartbeat timeout %v of %v.  (Duration between checks %v)", count, PingTimeoutLimit, s.PingTimeoutDuration)
-			if count >= PingTimeoutLimit {
-				s.logger.Error("Heartbeat timeout expired")
-				defer close(killChan)
-				return
+		select {
+		case <-stop:
+			s.Logger().Debugf("Heartbeat stopped")
+			return
+		case <-ticker.C:
+		}
+		since := time.Since(s.GetLastPing())
+		if since < s.PingTimeoutDuration {
+			if count > 0 {
+				s.Logger().Debugf("Heartbeat timeout reset after %v of %v", count, limit)
 			}
-		} else {
-			// Reset count
 			count = 0
+			continue
 		}
-		time.Sleep(s.PingTimeoutDuration)
+		count++
+		s.Logger().Warnf("Heartbeat timeout %v of %v.  (Duration between checks %v, %s)", count, limit, s.PingTimeoutDuration, s.stats.summary())
+		if count >= limit {
+			if s.stopHeartbeat() {
+				s.Logger().Errorf("Heartbeat timeout expired, last ping %v ago, %s", since, s.stats.summary())
+				s.runOnKill(KillReasonHeartbeatTimeout)
+				s.endSession(Shutdown{
+					Reason: fmt.Sprintf("no ping for %v", since),
+					Source: ShutdownSourceHeartbeat,
+				})
+			}
+			return
+		}
+	}
+}
+
+// logCall logs a call recorded in the session stats.  The tracedMethods
+// are logged with their request ID instead.
+func (s *SessionState) logCall(method string, d time.Duration, errMsg string) {
+	if tracedMethods[method] {
+		return
+	}
+	fields := map[string]interface{}{"method": method, "duration": d.String()}
+	if errMsg != "" {
+		fields["error"] = errMsg
+	}
+	logWith(s.Logger(), fields).Debugf("Call served")
+}
+
+// recordCall counts a call to method in the session stats.
+func (s *SessionState) recordCall(method string, d time.Duration, err error) {
+	var msg string
+	if err != nil {
+		msg = err.Error()
 	}
+	s.stats.record(method, d, msg)
+}
+
+func (s *SessionState) stopChan() chan struct{} {
+	s.heartbeatOnce.Do(func() {
+		s.heartbeatStop = make(chan struct{})
+	})
+	return s.heartbeatStop
+}
+
+// stopHeartbeat stops heartbeatWatch.  It returns false if the heartbeat
+// was already stopped, in which case the session is already ending.
+func (s *SessionState) stopHeartbeat() bool {
+	stop := s.stopChan()
+	stopped := false
+	s.stopOnce.Do(func() {
+		close(stop)
+		stopped = true
+	})
+	return stopped
 }
 
 // NewSessionState takes the plugin args and returns a SessionState
 // returns State or error and returnCode:
 // 0 - ok
-// 2 - error when unmarshaling pluginArgs
+// 2 - error when unmarshaling pluginArgs, selecting the transport or codec
+// or generating the session token
 // 3 - cannot open error files
 func NewSessionState(pluginArgsMsg string, plugin Plugin, meta *PluginMeta) (*SessionState, error, int) {
 	pluginArg := &Arg{}
@@ -238,50 +567,248 @@ func NewSessionState(pluginArgsMsg string, plugin Plugin, meta *PluginMeta) (*Se
 	if err != nil {
 		return nil, err, 2
 	}
+	meta.defaultContentTypes()
+	if err := meta.Validate(); err != nil {
+		return nil, err, 2
+	}
+	if err := checkRPCVersion(pluginArg.RPCVersion); err != nil {
+		return nil, err, 2
+	}
+	if err := checkFrameworkVersion(pluginArg.FrameworkVersion, meta.MinFrameworkVersion); err != nil {
+		return nil, err, 2
+	}
 
-	// If no port was provided we let the OS select a port for us.
-	// This is safe as address is returned in the Response and keep
-	// alive prevents unattended plugins.
-	if pluginArg.listenPort == "" {
-		pluginArg.listenPort = "0"
+	// If no port was provided we let the OS select a port for us, or one
+	// from the ListenPortRange.  This is safe as address is returned in the
+	// Response and keep alive prevents unattended plugins.
+	var pr *portRange
+	if pluginArg.ListenPort == "" && pluginArg.ListenPortRange != "" {
+		pr, err = parsePortRange(pluginArg.ListenPortRange)
+		if err != nil {
+			return nil, err, 2
+		}
+	} else if pluginArg.ListenPort == "" {
+		pluginArg.ListenPort = "0"
+	}
+	if pluginArg.ListenAddr == "" {
+		pluginArg.ListenAddr = DefaultListenAddr
 	}
 
 	// If no PingTimeoutDuration was provided we need to set it
 	if pluginArg.PingTimeoutDuration == 0 {
 		pluginArg.PingTimeoutDuration = PingTimeoutDurationDefault
 	}
+	if pluginArg.PingTimeoutLimit == 0 {
+		pluginArg.PingTimeoutLimit = PingTimeoutLimit
+	}
+	if pluginArg.KillDrainTimeout == 0 {
+		pluginArg.KillDrainTimeout = DefaultKillDrainTimeout
+	}
+	if pluginArg.KillDelay == 0 {
+		pluginArg.KillDelay = DefaultKillDelay
+	}
+	if pluginArg.PanicLimit == 0 {
+		pluginArg.PanicLimit = DefaultPanicLimit
+	}
+	if pluginArg.PanicWindow == 0 {
+		pluginArg.PanicWindow = DefaultPanicWindow
+	}
+	if pluginArg.CallQueueLength == 0 {
+		pluginArg.CallQueueLength = DefaultCallQueueLength
+	}
+	if pluginArg.SlowCallThreshold == 0 {
+		pluginArg.SlowCallThreshold = DefaultSlowCallThreshold
+	}
+	if pluginArg.AckRetention == 0 {
+		pluginArg.AckRetention = DefaultAckRetention
+	}
+	if pluginArg.SpoolSegmentSize == 0 {
+		pluginArg.SpoolSegmentSize = DefaultSpoolSegmentSize
+	}
+	if pluginArg.SpoolRetryInterval == 0 {
+		pluginArg.SpoolRetryInterval = DefaultSpoolRetryInterval
+	}
+	if pluginArg.LogMaxBackups == 0 {
+		pluginArg.LogMaxBackups = DefaultLogMaxBackups
+	}
+	if pluginArg.RPCRecordMaxSizeMB == 0 {
+		pluginArg.RPCRecordMaxSizeMB = DefaultRPCRecordMaxSizeMB
+	}
+	if pluginArg.RPCRecordMaxBackups == 0 {
+		pluginArg.RPCRecordMaxBackups = DefaultLogMaxBackups
+	}
+	if pluginArg.InitRetries == 0 {
+		pluginArg.InitRetries = DefaultInitRetries
+	}
+	if pluginArg.InitRetryInterval == 0 {
+		pluginArg.InitRetryInterval = DefaultInitRetryInterval
+	}
+
+	if pluginArg.ProfilingAddr == "" {
+		pluginArg.ProfilingAddr = DefaultProfilingAddr
+	}
+	if pluginArg.ProfilingPort == "" {
+		pluginArg.ProfilingPort = "0"
+	}
+	if pluginArg.EnableProfiling {
+		if err := checkProfilingAddr(pluginArg.ProfilingAddr, pluginArg.ProfilingAllowRemote); err != nil {
+			return nil, err, 2
+		}
+	}
+
+	if pluginArg.AdvertiseAddress != "" {
+		if err := validateAdvertiseAddress(pluginArg.AdvertiseAddress); err != nil {
+			return nil, err, 2
+		}
+	}
 
 	// Generate random token for this session
-	rb := make([]byte, 32)
-	rand.Read(rb)
-	rs := base64.URLEncoding.EncodeToString(rb)
+	if pluginArg.TokenLength == 0 {
+		pluginArg.TokenLength = DefaultTokenLength
+	}
+	rs, err := generateToken(pluginArg.TokenLength)
+	if err != nil {
+		return nil, err, 2
+	}
+	now := time.Now()
 
-	logger := &log.Logger{
-		Out:       os.Stderr,
-		Formatter: &simpleFormatter{},
-		Hooks:     make(log.LevelHooks),
-		Level:     pluginArg.LogLevel,
+	if pluginArg.Transport != "" {
+		t, err := ParseRPCType(pluginArg.Transport)
+		if err != nil {
+			return nil, err, 2
+		}
+		meta.RPCType = t
 	}
 
 	var enc encoding.Encoder
 	switch meta.RPCType {
 	case JSONRPC:
+		if pluginArg.Codec != "" && pluginArg.Codec != JSONCodec {
+			return nil, ErrUnsupportedCodec, 2
+		}
+		pluginArg.Codec = JSONCodec
 		enc = encoding.NewJsonEncoder()
 	case NativeRPC:
-		enc = encoding.NewGobEncoder()
+		switch pluginArg.Codec {
+		case "", GobCodec:
+			pluginArg.Codec = GobCodec
+			enc = encoding.NewGobEncoder()
+		case JSONCodec:
+			enc = encoding.NewJsonEncoder()
+		default:
+			return nil, ErrUnsupportedCodec, 2
+		}
 	case GRPC:
+		if pluginArg.Codec != "" {
+			return nil, ErrUnsupportedCodec, 2
+		}
 		enc = encoding.NewGobEncoder()
 		//TODO(CDR): re-think once content-types is settled
 	}
+
+	// The log file is opened once the arguments are known to be valid, and
+	// only for the session logger; it is closed unless the session starts.
+	var (
+		logger    Logger
+		formatErr error
+		logFile   *rotatingFile
+		started   bool
+	)
+	defer func() {
+		if logFile != nil && !started {
+			logFile.Close()
+		}
+	}()
+	fields := sessionFields(meta, rs)
+	if lp, ok := plugin.(LoggerProvider); ok && lp.Logger() != nil {
+		logger = withSession(lp.Logger(), fields)
+	} else {
+		var logOut io.Writer = os.Stderr
+		if p := pluginArg.PluginLogPath; p != "" && p != LogPathStderr {
+			logFile, err = openRotatingFile(p, int64(pluginArg.LogMaxSizeMB)<<20, pluginArg.LogMaxBackups)
+			if err != nil {
+				return nil, &logFileError{path: p, err: err}, 3
+			}
+			logOut = logFile
+		}
+		logger, formatErr = newSessionLogger(pluginArg, fields, logOut)
+	}
+	if pluginArg.logLevelErr != nil {
+		logger.Warnf("%v", pluginArg.logLevelErr)
+	}
+	if formatErr != nil {
+		logger.Warnf("%v", formatErr)
+	}
+
 	ss := &SessionState{
 		Arg:     pluginArg,
 		Encoder: enc,
 
-		plugin:   plugin,
-		token:    rs,
-		killChan: make(chan int),
-		logger:   logger,
+		plugin:      plugin,
+		token:       rs,
+		portRange:   pr,
+		started:     now,
+		tokenIssued: now,
+		logger:      logger,
+	}
+
+	if err := ss.negotiateContentTypes(meta); err != nil {
+		return nil, err, 2
+	}
+	ss.cache = newMetricCache(meta.CacheTTL)
+	if meta.RPCType != GRPC {
+		ss.compressor = newContentCompressor(pluginArg.ContentEncodings, pluginArg.CompressThreshold)
+		ss.chunks = newChunkStore(pluginArg.ChunkSize, pluginArg.ChunkTimeout)
+	}
+	ss.config = &configStore{}
+	ss.stats.onCall = ss.logCall
+	ss.suspension = &suspension{}
+	ss.base = newSessionContext()
+	ss.requests = newRequestTracker(ss.Logger, &ss.stats, pluginArg.SlowCallThreshold)
+	if _, ok := plugin.(Initializer); ok {
+		ss.init = newInitialization()
+	}
+	if pluginArg.PushAddress != "" {
+		ss.pushes = newPushQueue(pluginArg.PushAddress, pluginArg.PushQueueSize, pluginArg.PushRetryInterval)
+	}
+	if n := pluginArg.workers(meta); n > 0 {
+		ss.pool = newWorkerPool(n, pluginArg.CallQueueLength)
+	}
+	if kh, ok := plugin.(KillHandler); ok {
+		ss.SetOnKill(kh.OnKill)
+	}
+	if rt, ok := plugin.(RequestTracer); ok {
+		rt.TraceRequests(ss)
+	}
+	if meta.Type == PublisherPluginType {
+		ss.backpressure = newBackpressure(plugin)
+		if tp, ok := plugin.(ThrottledPublisher); ok {
+			tp.SetThrottler(ss)
+		}
+		if dp, ok := plugin.(DeferredPublisher); ok {
+			ss.acks = newAckTracker(dp, pluginArg.AckRetention, ss.Logger)
+			dp.SetAcker(ss)
+		}
+		if pluginArg.SpoolDir != "" {
+			sp, err := openSpool(pluginArg.SpoolDir, pluginArg.SpoolSegmentSize, pluginArg.SpoolRetryInterval, ss)
+			if err != nil {
+				return nil, err, 2
+			}
+			ss.spool = sp
+		}
+	}
+	if meta.Type == CollectorPluginType {
+		sim, err := newSimulation(pluginArg)
+		if err != nil {
+			return nil, err, 2
+		}
+		ss.simulation = sim
+	}
+	rec, err := newRPCRecorder(pluginArg, ss)
+	if err != nil {
+		return nil, err, 2
 	}
+	ss.recorder = rec
 
 	if !meta.Unsecure {
 		key, err := rsa.GenerateKey(rand.Reader, 2048)
@@ -293,14 +820,45 @@ func NewSessionState(pluginArgsMsg string, plugin Plugin, meta *PluginMeta) (*Se
 		ss.Encrypter = encrypt
 		ss.privateKey = key
 	}
+	started = true
 	return ss, nil, 0
 }
 
+// generateToken returns n random bytes from crypto/rand, base64 encoded.
+// There is no fallback: a session without an unguessable token must not start.
+func generateToken(n int) (string, error) {
+	if n < MinTokenLength {
+		return "", ErrTokenLength
+	}
+	rb := make([]byte, n)
+	if _, err := io.ReadFull(randReader, rb); err != nil {
+		return "", fmt.Errorf("unable to generate session token: %v", err)
+	}
+	return base64.URLEncoding.EncodeToString(rb), nil
+}
+
+// validateAdvertiseAddress checks that addr is a host:port that control is
+// able to dial.
+func validateAdvertiseAddress(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid AdvertiseAddress %q: %v", addr, err)
+	}
+	if host == "" {
+		return fmt.Errorf("invalid AdvertiseAddress %q: missing host", addr)
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return fmt.Errorf("invalid AdvertiseAddress %q: invalid port", addr)
+	}
+	return nil
+}
+
 func init() {
 	gob.RegisterName("conf_value_string", *(&ctypes.ConfigValueStr{}))
 	gob.RegisterName("conf_value_int", *(&ctypes.ConfigValueInt{}))
 	gob.RegisterName("conf_value_float", *(&ctypes.ConfigValueFloat{}))
 	gob.RegisterName("conf_value_bool", *(&ctypes.ConfigValueBool{}))
+	gob.RegisterName("conf_value_secure_string", *(&ctypes.ConfigValueSecureString{}))
 
 	gob.RegisterName("conf_policy_node", cpolicy.NewPolicyNode())
 	gob.RegisterName("conf_data_node", &cdata.ConfigDataNode{})
@@ -308,13 +866,5 @@ func init() {
 	gob.RegisterName("conf_policy_int", &cpolicy.IntRule{})
 	gob.RegisterName("conf_policy_float", &cpolicy.FloatRule{})
 	gob.RegisterName("conf_policy_bool", &cpolicy.BoolRule{})
-}
-
-// simpleFormatter is a logrus formatter that includes only the message.
-type simpleFormatter struct{}
-
-func (*simpleFormatter) Format(entry *log.Entry) ([]byte, error) {
-	b := &bytes.Buffer{}
-	fmt.Fprintf(b, "%s\n", entry.Message)
-	return b.Bytes(), nil
+	gob.RegisterName("conf_policy_secure_string", &cpolicy.SecureStringRule{})
 }
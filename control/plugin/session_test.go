@@ -22,8 +22,16 @@ limitations under the License.
 package plugin
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"io"
+	"os"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 
@@ -31,6 +39,9 @@ import (
 
 	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
 	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	"golang.org/x/net/context"
+
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -44,6 +55,7 @@ type MockSessionState struct {
 	token               string
 	logger              *log.Logger
 	killChan            chan int
+	secretKey           ctypes.Decrypter
 }
 
 func (s *MockSessionState) Ping(arg []byte, reply *[]byte) error {
@@ -63,7 +75,7 @@ func (s *MockSessionState) GetConfigPolicy(arg []byte, reply *[]byte) error {
 
 func (s *MockSessionState) SetKey(SetKeyArgs, *[]byte) error { return nil }
 
-func (s *MockSessionState) Logger() *log.Logger {
+func (s *MockSessionState) Logger() Logger {
 	return s.logger
 }
 
@@ -83,6 +95,10 @@ func (s *MockSessionState) Token() string {
 	return s.token
 }
 
+func (s *MockSessionState) CheckToken(token string) error {
+	return nil
+}
+
 func (m *MockSessionState) ResetHeartbeat() {
 
 }
@@ -91,19 +107,55 @@ func (s *MockSessionState) KillChan() chan int {
 	return s.killChan
 }
 
+// Done is closed once Kill or heartbeatWatch has signaled killChan
+func (s *MockSessionState) Done() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		<-s.killChan
+		close(done)
+	}()
+	return done
+}
+
+func (s *MockSessionState) ShutdownReason() Shutdown {
+	return Shutdown{}
+}
+
 func (s *MockSessionState) isDaemon() bool {
 	return s.Daemon
 }
 
-func (s *MockSessionState) generateResponse(r *Response) []byte {
-	return []byte("mockResponse")
+func (s *MockSessionState) generateResponse(r *Response) ([]byte, error) {
+	return []byte("mockResponse"), nil
 }
 
-func (s *MockSessionState) heartbeatWatch(killChan chan int) {
+func (s *MockSessionState) heartbeatWatch() {
 	time.Sleep(time.Millisecond * 200)
-	killChan <- 0
+	s.killChan <- 0
 }
 
+func (s *MockSessionState) stopHeartbeat() bool {
+	return true
+}
+
+func (s *MockSessionState) recordCall(string, time.Duration, error) {}
+
+func (s *MockSessionState) beginCall(context.Context) error {
+	return nil
+}
+
+func (s *MockSessionState) endCall() {}
+
+func (s *MockSessionState) drain() (bool, int) {
+	return true, 0
+}
+
+func (s *MockSessionState) scheduleKill(string) {}
+
+func (s *MockSessionState) runOnKill(KillReason) {}
+
+func (s *MockSessionState) recoverPanic(string, *error) {}
+
 func (s *MockSessionState) setKey(key []byte) {
 }
 
@@ -111,6 +163,10 @@ func (s *MockSessionState) DecryptKey(in []byte) ([]byte, error) {
 	return []byte{}, nil
 }
 
+func (s *MockSessionState) decrypter() ctypes.Decrypter {
+	return s.secretKey
+}
+
 type errSessionState struct {
 	*MockSessionState
 }
@@ -122,36 +178,61 @@ func (e *errSessionState) GetConfigPolicy(arg []byte, reply *[]byte) error {
 func TestSessionState(t *testing.T) {
 	Convey("SessionState", t, func() {
 		now := time.Now()
-		ss := &SessionState{
-			LastPing: now,
-			Arg:      &Arg{PingTimeoutDuration: 500 * time.Millisecond},
-			Encoder:  encoding.NewJsonEncoder(),
-		}
-		ss.logger = log.New()
+		fs := NewFakeSession(t)
+		fs.PingTimeoutDuration = 500 * time.Millisecond
+		fs.SetLastPing(now)
+		ss := fs.SessionState
 		Convey("Ping", func() {
-
-			ss.Ping([]byte{}, &[]byte{})
-			So(ss.LastPing.Nanosecond(), ShouldBeGreaterThan, now.Nanosecond())
+			So(fs.FirePing(), ShouldBeNil)
+			So(ss.GetLastPing().After(now), ShouldBeTrue)
+			So(fs.Logs(), ShouldContain, `level=debug msg="Ping received"`)
 		})
 		Convey("Kill", func() {
-			args := KillArgs{Reason: "testing"}
-			out, err := ss.Encode(args)
-			err = ss.Kill(out, &[]byte{})
-			So(err, ShouldBeNil)
+			So(fs.FireKill("testing"), ShouldBeNil)
+			So(fs.Killed(time.Second), ShouldBeTrue)
+			So(ss.ShutdownReason().Source, ShouldEqual, ShutdownSourceControl)
 		})
 		Convey("GenerateResponse", func() {
 			r := &Response{}
 			ss.listenAddress = "1234"
 			ss.token = "asdf"
-			response := ss.generateResponse(r)
+			response, err := ss.generateResponse(r)
+			So(err, ShouldBeNil)
 			So(response, ShouldHaveSameTypeAs, []byte{})
 			json.Unmarshal(response, &r)
 			So(r.ListenAddress, ShouldEqual, "1234")
 			So(r.Token, ShouldEqual, "asdf")
 		})
+		Convey("GenerateResponse describes the plugin process", func() {
+			ss.started = time.Now().Add(-time.Minute).Round(time.Second)
+			out, err := ss.generateResponse(&Response{})
+			So(err, ShouldBeNil)
+			var r Response
+			So(json.Unmarshal(out, &r), ShouldBeNil)
+			So(r.PID, ShouldEqual, os.Getpid())
+			So(r.StartedAt.Equal(ss.started), ShouldBeTrue)
+			So(r.GoVersion, ShouldEqual, runtime.Version())
+			So(r.OS, ShouldEqual, runtime.GOOS)
+			So(r.Arch, ShouldEqual, runtime.GOARCH)
+		})
+		Convey("A Response without process details", func() {
+			var r Response
+			So(json.Unmarshal([]byte(`{"ListenAddress": "127.0.0.1:1234", "Token": "asdf", "Type": 0, "State": 0}`), &r), ShouldBeNil)
+			So(r.ListenAddress, ShouldEqual, "127.0.0.1:1234")
+			So(r.PID, ShouldEqual, 0)
+			So(r.StartedAt.IsZero(), ShouldBeTrue)
+		})
+		Convey("GenerateResponse with an undefined state", func() {
+			for _, state := range []PluginResponseState{PluginUnknown, PluginResponseState(len(responseStates))} {
+				_, err := ss.generateResponse(&Response{State: state})
+				So(err, ShouldNotBeNil)
+			}
+		})
 		Convey("InitSessionState", func() {
 			var mockPluginArgs string = "{\"RunAsDaemon\": true, \"PingTimeoutDuration\": 2000000000}"
 			m := PluginMeta{
+				Name:    "mock",
+				Version: 1,
 				RPCType: JSONRPC,
 				Type:    CollectorPluginType,
 			}
@@ -161,10 +242,58 @@ func TestSessionState(t *testing.T) {
 			So(err, ShouldBeNil)
 			So(sessionState, ShouldNotBeNil)
 			So(sessionState.PingTimeoutDuration, ShouldResemble, 2*time.Second)
+			So(sessionState.PingTimeoutLimit, ShouldEqual, PingTimeoutLimit)
+		})
+		Convey("InitSessionState with a PingTimeoutLimit", func() {
+			m := PluginMeta{
+				Name:    "mock",
+				Version: 1,
+				RPCType: JSONRPC,
+				Type:    CollectorPluginType,
+			}
+			sessionState, err, rc := NewSessionState(`{"PingTimeoutLimit": 7}`, &MockPlugin{Meta: m}, &m)
+			So(rc, ShouldEqual, 0)
+			So(err, ShouldBeNil)
+			So(sessionState.PingTimeoutLimit, ShouldEqual, 7)
+			So(sessionState.PingTimeoutDuration, ShouldEqual, PingTimeoutDurationDefault)
+		})
+		Convey("GenerateResponse with an AdvertiseAddress", func() {
+			ss.listenAddress = "127.0.0.1:1234"
+			for _, addr := range []string{"plugin.example.com:8182", "10.0.0.5:8182", "[fe80::1]:8182"} {
+				ss.AdvertiseAddress = addr
+				r := &Response{}
+				out, err := ss.generateResponse(r)
+				So(err, ShouldBeNil)
+				So(json.Unmarshal(out, r), ShouldBeNil)
+				So(r.ListenAddress, ShouldEqual, addr)
+			}
+		})
+		Convey("InitSessionState with an AdvertiseAddress", func() {
+			m := PluginMeta{
+				Name:    "mock",
+				Version: 1,
+				RPCType: JSONRPC,
+				Type:    CollectorPluginType,
+			}
+			for _, addr := range []string{"plugin.example.com:8182", "10.0.0.5:8182", "[fe80::1]:8182"} {
+				args, _ := json.Marshal(Arg{AdvertiseAddress: addr})
+				sess, err, rc := NewSessionState(string(args), &MockPlugin{Meta: m}, &m)
+				So(err, ShouldBeNil)
+				So(rc, ShouldEqual, 0)
+				So(sess.AdvertiseAddress, ShouldEqual, addr)
+			}
+			for _, addr := range []string{"plugin.example.com", "fe80::1:8182", ":8182", "10.0.0.5:http", "10.0.0.5:0", "10.0.0.5:70000"} {
+				args, _ := json.Marshal(Arg{AdvertiseAddress: addr})
+				_, err, rc := NewSessionState(string(args), &MockPlugin{Meta: m}, &m)
+				So(err, ShouldNotBeNil)
+				So(rc, ShouldEqual, 2)
+			}
 		})
 		Convey("InitSessionState with invalid args", func() {
 			var mockPluginArgs string
 			m := PluginMeta{
+				Name:    "mock",
+				Version: 1,
 				RPCType: JSONRPC,
 				Type:    CollectorPluginType,
 			}
@@ -174,6 +303,8 @@ func TestSessionState(t *testing.T) {
 		Convey("InitSessionState with a custom log path", func() {
 			var mockPluginArgs string = "{\"RunAsDaemon\": false, \"PluginLogPath\": \"/var/tmp/snap_plugin.log\"}"
 			m := PluginMeta{
+				Name:    "mock",
+				Version: 1,
 				RPCType: JSONRPC,
 				Type:    CollectorPluginType,
 			}
@@ -183,19 +314,133 @@ func TestSessionState(t *testing.T) {
 			So(sess, ShouldNotBeNil)
 		})
 		Convey("heartbeatWatch timeout expired", func() {
-			PingTimeoutLimit = 1
-			ss.LastPing = now.Truncate(time.Minute)
-			killChan := make(chan int)
-			ss.heartbeatWatch(killChan)
-			rc := <-killChan
+			fs.PingTimeoutLimit = 1
+			ss.SetLastPing(now.Truncate(time.Minute))
+			ss.heartbeatWatch()
+			<-ss.Done()
+			sd := ss.ShutdownReason()
+			So(sd.Source, ShouldEqual, ShutdownSourceHeartbeat)
+			So(sd.Time.IsZero(), ShouldBeFalse)
+			// KillChan is still closed for code reading it
+			rc := <-ss.KillChan()
 			So(rc, ShouldEqual, 0)
 		})
+		Convey("heartbeatWatch uses the session's timeout settings", func() {
+			fast := NewFakeSession(t)
+			fast.PingTimeoutDuration = 10 * time.Millisecond
+			fast.PingTimeoutLimit = 1
+			slow := NewFakeSession(t)
+			slow.PingTimeoutDuration = 50 * time.Millisecond
+			slow.PingTimeoutLimit = 10
+			go fast.heartbeatWatch()
+			go slow.heartbeatWatch()
+
+			start := time.Now()
+			<-fast.Done()
+			fastElapsed := time.Since(start)
+			<-slow.Done()
+			slowElapsed := time.Since(start)
+			So(fastElapsed, ShouldBeLessThan, 200*time.Millisecond)
+			So(slowElapsed, ShouldBeGreaterThanOrEqualTo, 450*time.Millisecond)
+		})
+		Convey("heartbeatWatch starts the heartbeat", func() {
+			fresh := NewFakeSession(t)
+			fresh.PingTimeoutDuration = 200 * time.Millisecond
+			fresh.PingTimeoutLimit = 1
+			So(fresh.GetLastPing().IsZero(), ShouldBeTrue)
+			start := time.Now()
+			go fresh.heartbeatWatch()
+			<-fresh.Done()
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 200*time.Millisecond)
+			So(fresh.GetLastPing().Before(start), ShouldBeFalse)
+		})
+		Convey("heartbeatWatch while pings arrive", func() {
+			busy := NewFakeSession(t)
+			busy.PingTimeoutDuration = time.Millisecond
+			busy.PingTimeoutLimit = 50
+			go busy.heartbeatWatch()
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for deadline := time.Now().Add(100 * time.Millisecond); time.Now().Before(deadline); {
+						busy.FirePing()
+					}
+				}()
+			}
+			wg.Wait()
+			last := busy.GetLastPing()
+			<-busy.Done()
+			So(busy.GetLastPing(), ShouldResemble, last)
+		})
 		Convey("heatbeatWatch reset", func() {
-			PingTimeoutLimit = 2
-			killChan := make(chan int)
-			ss.heartbeatWatch(killChan)
-			rc := <-killChan
-			So(rc, ShouldEqual, 0)
+			fs.PingTimeoutLimit = 2
+			ss.heartbeatWatch()
+			<-ss.Done()
+			So(ss.ShutdownReason().Source, ShouldEqual, ShutdownSourceHeartbeat)
+		})
+	})
+}
+
+func TestHeartbeatWatchStop(t *testing.T) {
+	Convey("A running heartbeatWatch", t, func() {
+		ss := NewFakeSession(t)
+		ss.PingTimeoutDuration = 10 * time.Millisecond
+		ss.PingTimeoutLimit = 1000
+		before := runtime.NumGoroutine()
+		done := make(chan struct{})
+		go func() {
+			ss.heartbeatWatch()
+			close(done)
+		}()
+
+		Convey("returns when the heartbeat is stopped", func() {
+			So(ss.stopHeartbeat(), ShouldBeTrue)
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("heartbeatWatch did not return")
+			}
+			So(ss.stopHeartbeat(), ShouldBeFalse)
+			// The session is left running for whoever stopped the heartbeat
+			select {
+			case <-ss.Done():
+				t.Fatal("the session ended")
+			default:
+			}
+			time.Sleep(50 * time.Millisecond)
+			So(runtime.NumGoroutine(), ShouldBeLessThanOrEqualTo, before)
+		})
+		Convey("returns when the session is killed", func() {
+			So(ss.FireKill("testing"), ShouldBeNil)
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("heartbeatWatch did not return")
+			}
+			So(ss.Killed(time.Second), ShouldBeTrue)
+			So(ss.ShutdownReason(), ShouldResemble, Shutdown{
+				Reason: "testing",
+				Source: ShutdownSourceControl,
+				Time:   ss.ShutdownReason().Time,
+			})
+		})
+	})
+	Convey("An expired heartbeat", t, func() {
+		ss := NewFakeSession(t)
+		ss.PingTimeoutDuration = 10 * time.Millisecond
+		ss.PingTimeoutLimit = 1
+		ss.heartbeatWatch()
+		_, ok := <-ss.KillChan()
+		So(ok, ShouldBeFalse)
+		So(ss.Logs(), ShouldNotBeEmpty)
+
+		Convey("is not killed again", func() {
+			So(ss.FireKill("testing"), ShouldBeNil)
+			// Closing KillChan again would panic
+			time.Sleep(100 * time.Millisecond)
+			So(ss.ShutdownReason().Source, ShouldEqual, ShutdownSourceHeartbeat)
 		})
 	})
 }
@@ -246,3 +491,271 @@ func TestGetConfigPolicy(t *testing.T) {
 		So(err.Error(), ShouldResemble, "GetConfigPolicy call error : Error in get config policy")
 	})
 }
+
+type policyPlugin struct {
+	mockPlugin
+}
+
+func (p *policyPlugin) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	cp := cpolicy.New()
+	cpn := cpolicy.NewPolicyNode()
+	r1, _ := cpolicy.NewStringRule("username", true)
+	r2, _ := cpolicy.NewIntegerRule("port", false, 8080)
+	cpn.Add(r1, r2)
+	cp.Add([]string{"intel", "mock"}, cpn)
+	return cp, nil
+}
+
+func TestSessionStateGetConfigPolicy(t *testing.T) {
+	encoders := map[string]encoding.Encoder{
+		"gob":  encoding.NewGobEncoder(),
+		"json": encoding.NewJsonEncoder(),
+	}
+	for name, enc := range encoders {
+		Convey("GetConfigPolicy over "+name, t, func() {
+			ss := &SessionState{
+				Arg:     &Arg{},
+				Encoder: enc,
+				plugin:  &policyPlugin{},
+				logger:  log.New(),
+			}
+			var reply []byte
+			err := ss.GetConfigPolicy([]byte{}, &reply)
+			So(err, ShouldBeNil)
+			var cpr GetConfigPolicyReply
+			err = ss.Decode(reply, &cpr)
+			So(err, ShouldBeNil)
+			So(cpr.Policy, ShouldNotBeNil)
+			rules := cpr.Policy.Get([]string{"intel", "mock"}).RulesAsTable()
+			So(len(rules), ShouldEqual, 2)
+			for _, r := range rules {
+				switch r.Name {
+				case "username":
+					So(r.Type, ShouldEqual, cpolicy.StringType)
+					So(r.Required, ShouldBeTrue)
+					So(r.Default, ShouldBeNil)
+				case "port":
+					So(r.Type, ShouldEqual, cpolicy.IntegerType)
+					So(r.Required, ShouldBeFalse)
+					So(r.Default, ShouldResemble, ctypes.ConfigValueInt{Value: 8080})
+				default:
+					t.Errorf("unexpected rule %s", r.Name)
+				}
+			}
+		})
+	}
+}
+
+type nilPolicyPlugin struct {
+	mockPlugin
+}
+
+func (p *nilPolicyPlugin) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	return nil, nil
+}
+
+func TestSessionStateGetNilConfigPolicy(t *testing.T) {
+	Convey("GetConfigPolicy with a nil policy returns an empty policy", t, func() {
+		ss := &SessionState{
+			Arg:     &Arg{},
+			Encoder: encoding.NewGobEncoder(),
+			plugin:  &nilPolicyPlugin{},
+			logger:  log.New(),
+		}
+		var reply []byte
+		err := ss.GetConfigPolicy([]byte{}, &reply)
+		So(err, ShouldBeNil)
+		var cpr GetConfigPolicyReply
+		err = ss.Decode(reply, &cpr)
+		So(err, ShouldBeNil)
+		So(cpr.Policy, ShouldNotBeNil)
+		So(cpr.Policy.GetAll(), ShouldBeEmpty)
+	})
+}
+
+func TestSessionStateCheckToken(t *testing.T) {
+	Convey("SessionState token validation", t, func() {
+		then := time.Now().Add(-time.Minute)
+		ss := &SessionState{
+			lastPing: then,
+			Arg:      &Arg{PingTimeoutDuration: 500 * time.Millisecond},
+			Encoder:  encoding.NewGobEncoder(),
+			plugin:   &policyPlugin{},
+			token:    "s3cr3t",
+			logger:   log.New(),
+		}
+		encode := func(in interface{}) []byte {
+			out, err := ss.Encode(in)
+			So(err, ShouldBeNil)
+			return out
+		}
+		Convey("Ping with a wrong token is rejected", func() {
+			err := ss.Ping(encode(PingArgs{Token: "wrong"}), &[]byte{})
+			So(err, ShouldEqual, ErrBadToken)
+			So(ss.GetLastPing(), ShouldResemble, then)
+		})
+		Convey("Ping without args is rejected", func() {
+			err := ss.Ping([]byte{}, &[]byte{})
+			So(err, ShouldEqual, ErrBadToken)
+			So(ss.GetLastPing(), ShouldResemble, then)
+		})
+		Convey("Ping with the session token resets the heartbeat", func() {
+			err := ss.Ping(encode(PingArgs{Token: "s3cr3t"}), &[]byte{})
+			So(err, ShouldBeNil)
+			So(ss.GetLastPing().After(then), ShouldBeTrue)
+		})
+		Convey("Kill with a wrong token is rejected", func() {
+			err := ss.Kill(encode(KillArgs{Reason: "testing", Token: "wrong"}), &[]byte{})
+			So(err, ShouldEqual, ErrBadToken)
+		})
+		Convey("GetConfigPolicy with a wrong token is rejected", func() {
+			var reply []byte
+			err := ss.GetConfigPolicy(encode(GetConfigPolicyArgs{Token: "wrong"}), &reply)
+			So(err, ShouldEqual, ErrBadToken)
+			So(reply, ShouldBeEmpty)
+		})
+		Convey("SetKey with a wrong token is rejected", func() {
+			err := ss.SetKey(SetKeyArgs{Token: "wrong"}, &[]byte{})
+			So(err, ShouldEqual, ErrBadToken)
+		})
+		Convey("proxy calls with a wrong token are rejected", func() {
+			c := &collectorPluginProxy{Plugin: &mockPlugin{}, Session: ss}
+			var reply []byte
+			err := c.GetMetricTypes(encode(GetMetricTypesArgs{PluginConfig: NewPluginConfigType(), Token: "wrong"}), &reply)
+			So(err, ShouldEqual, ErrBadToken)
+			err = c.CollectMetrics(encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: "wrong"}), &reply)
+			So(err, ShouldEqual, ErrBadToken)
+			So(ss.GetLastPing(), ShouldResemble, then)
+
+			err = c.GetMetricTypes(encode(GetMetricTypesArgs{PluginConfig: NewPluginConfigType(), Token: "s3cr3t"}), &reply)
+			So(err, ShouldBeNil)
+			So(ss.GetLastPing().After(then), ShouldBeTrue)
+		})
+		Convey("enforcement can be disabled", func() {
+			ss.NoTokenCheck = true
+			err := ss.Ping(encode(PingArgs{Token: "wrong"}), &[]byte{})
+			So(err, ShouldBeNil)
+			So(ss.GetLastPing().After(then), ShouldBeTrue)
+		})
+	})
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("entropy source unavailable")
+}
+
+func TestSessionStateTokenGeneration(t *testing.T) {
+	m := &PluginMeta{
+		Name:     "mock",
+		Version:  1,
+		RPCType:  NativeRPC,
+		Type:     CollectorPluginType,
+		Unsecure: true,
+	}
+	Convey("NewSessionState token generation", t, func() {
+		Convey("uses the default token length", func() {
+			ss, err, rc := NewSessionState("{}", &MockPlugin{}, m)
+			So(err, ShouldBeNil)
+			So(rc, ShouldEqual, 0)
+			b, err := base64.URLEncoding.DecodeString(ss.Token())
+			So(err, ShouldBeNil)
+			So(len(b), ShouldEqual, DefaultTokenLength)
+		})
+		Convey("honors a configured token length", func() {
+			ss, err, _ := NewSessionState(`{"TokenLength": 64}`, &MockPlugin{}, m)
+			So(err, ShouldBeNil)
+			b, err := base64.URLEncoding.DecodeString(ss.Token())
+			So(err, ShouldBeNil)
+			So(len(b), ShouldEqual, 64)
+		})
+		Convey("rejects a token length below the minimum", func() {
+			ss, err, rc := NewSessionState(`{"TokenLength": 8}`, &MockPlugin{}, m)
+			So(ss, ShouldBeNil)
+			So(err, ShouldEqual, ErrTokenLength)
+			So(rc, ShouldEqual, 2)
+		})
+		Convey("fails when crypto/rand fails", func() {
+			randReader = failingReader{}
+			defer func() { randReader = rand.Reader }()
+			ss, err, rc := NewSessionState("{}", &MockPlugin{}, m)
+			So(ss, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "entropy source unavailable")
+			So(rc, ShouldEqual, 2)
+		})
+		Convey("fails on a short read", func() {
+			randReader = io.LimitReader(rand.Reader, 4)
+			defer func() { randReader = rand.Reader }()
+			_, err, _ := NewSessionState("{}", &MockPlugin{}, m)
+			So(err, ShouldNotBeNil)
+		})
+	})
+	Convey("ValidateToken", t, func() {
+		ss := &SessionState{token: "s3cr3t"}
+		So(ss.ValidateToken("s3cr3t"), ShouldBeTrue)
+		So(ss.ValidateToken("s3cr3"), ShouldBeFalse)
+		So(ss.ValidateToken("s3cr3t!"), ShouldBeFalse)
+		So(ss.ValidateToken(""), ShouldBeFalse)
+	})
+}
+
+func TestSessionStateSignedKill(t *testing.T) {
+	Convey("Kill with a ControlPubKey", t, func() {
+		controlKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		So(err, ShouldBeNil)
+		logs := &bytes.Buffer{}
+		logger := log.New()
+		logger.Out = logs
+		ss := &SessionState{
+			Arg:      &Arg{ControlPubKey: &controlKey.PublicKey},
+			Encoder:  encoding.NewGobEncoder(),
+			killChan: make(chan int, 1),
+			logger:   logger,
+		}
+		kill := func(args *KillArgs) error {
+			out, err := ss.Encode(args)
+			So(err, ShouldBeNil)
+			return ss.Kill(out, &[]byte{})
+		}
+		killed := func() bool {
+			select {
+			case <-ss.KillChan():
+				return true
+			case <-time.After(3 * time.Second):
+				return false
+			}
+		}
+
+		Convey("an unsigned Kill is ignored and logged", func() {
+			err := kill(&KillArgs{Reason: "testing"})
+			So(err, ShouldEqual, ErrRequestUnsigned)
+			So(logs.String(), ShouldContainSubstring, "Kill rejected")
+			So(killed(), ShouldBeFalse)
+		})
+		Convey("a Kill signed by another key is ignored", func() {
+			otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			So(err, ShouldBeNil)
+			args := &KillArgs{Reason: "testing"}
+			So(SignRequest(args, otherKey), ShouldBeNil)
+			So(kill(args), ShouldEqual, ErrRequestSignature)
+		})
+		Convey("a Kill altered after signing is ignored", func() {
+			args := &KillArgs{Reason: "testing"}
+			So(SignRequest(args, controlKey), ShouldBeNil)
+			args.Reason = "something else"
+			So(kill(args), ShouldEqual, ErrRequestSignature)
+		})
+		Convey("a validly signed Kill shuts the plugin down", func() {
+			args := &KillArgs{Reason: "testing"}
+			So(SignRequest(args, controlKey), ShouldBeNil)
+			So(kill(args), ShouldBeNil)
+			So(killed(), ShouldBeTrue)
+
+			Convey("and can't be replayed", func() {
+				So(kill(args), ShouldEqual, ErrRequestReplay)
+			})
+		})
+	})
+}
@@ -0,0 +1,105 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import "time"
+
+// The sources which may end a session
+const (
+	// ShutdownSourceControl is a Kill call from control
+	ShutdownSourceControl = "control"
+	// ShutdownSourceHeartbeat is control failing to ping the plugin
+	ShutdownSourceHeartbeat = "heartbeat"
+	// ShutdownSourceSignal is SIGTERM or SIGINT sent to the plugin
+	ShutdownSourceSignal = "signal"
+	// ShutdownSourcePanic is the plugin panicking PanicLimit times within
+	// PanicWindow
+	ShutdownSourcePanic = "panic"
+	// ShutdownSourceInit is the plugin's Init failing InitRetries+1 times
+	ShutdownSourceInit = "init"
+)
+
+// Shutdown tells why a session ended
+type Shutdown struct {
+	// Reason is the reason given to Kill, or a description of the timeout
+	// or signal
+	Reason string
+	// Source is one of the ShutdownSource constants
+	Source string
+	// Time is when the session ended
+	Time time.Time
+	// CloseError is the error of the plugin's Close, see Closer
+	CloseError string
+}
+
+// Done returns a channel which is closed when the session ends.  Once it is
+// closed ShutdownReason tells why.
+func (s *SessionState) Done() <-chan struct{} {
+	s.initShutdown()
+	return s.done
+}
+
+// ShutdownReason returns why the session ended, or the zero Shutdown while
+// the session is running.
+func (s *SessionState) ShutdownReason() Shutdown {
+	s.initShutdown()
+	select {
+	case <-s.done:
+		return s.shutdownReason
+	default:
+		return Shutdown{}
+	}
+}
+
+func (s *SessionState) initShutdown() {
+	s.shutdownInit.Do(func() {
+		s.done = make(chan struct{})
+		if s.killChan == nil {
+			s.killChan = make(chan int)
+		}
+	})
+}
+
+// endSession closes the plugin, records why the session ended and closes
+// Done and KillChan.  Only the first call has any effect, so every source
+// may call it without knowing whether another one got there first.
+func (s *SessionState) endSession(sd Shutdown) {
+	s.initShutdown()
+	ended := false
+	s.shutdownOnce.Do(func() {
+		s.base.end()
+		if err := s.runClose(); err != nil {
+			sd.CloseError = err.Error()
+		}
+		if sd.Time.IsZero() {
+			sd.Time = time.Now()
+		}
+		s.shutdownReason = sd
+		close(s.done)
+		close(s.killChan)
+		s.profilerMutex.Lock()
+		s.profiler.close()
+		s.profilerMutex.Unlock()
+		ended = true
+	})
+	if !ended {
+		s.Logger().Debugf("Session already ended, ignoring shutdown from %s", sd.Source)
+	}
+}
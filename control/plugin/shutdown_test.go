@@ -0,0 +1,93 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestShutdown(t *testing.T) {
+	Convey("A running session", t, func() {
+		ss := &SessionState{
+			Arg:     &Arg{PingTimeoutDuration: time.Millisecond, PingTimeoutLimit: 1},
+			Encoder: encoding.NewGobEncoder(),
+			token:   "s3cr3t",
+			logger:  log.New(),
+		}
+		So(ss.ShutdownReason(), ShouldResemble, Shutdown{})
+
+		Convey("tells why it was killed", func() {
+			start := time.Now()
+			in, err := ss.Encode(KillArgs{Reason: "unloading", Token: "s3cr3t"})
+			So(err, ShouldBeNil)
+			So(ss.Kill(in, &[]byte{}), ShouldBeNil)
+			<-ss.Done()
+			sd := ss.ShutdownReason()
+			So(sd.Reason, ShouldEqual, "unloading")
+			So(sd.Source, ShouldEqual, ShutdownSourceControl)
+			So(sd.Time.Before(start), ShouldBeFalse)
+		})
+		Convey("ends once when every source fires at the same time", func() {
+			ss.KillDelay = 0
+			in, err := ss.Encode(KillArgs{Reason: "unloading", Token: "s3cr3t"})
+			So(err, ShouldBeNil)
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(4)
+				go func() {
+					defer wg.Done()
+					ss.Kill(in, &[]byte{})
+				}()
+				go func() {
+					defer wg.Done()
+					ss.heartbeatWatch()
+				}()
+				go func() {
+					defer wg.Done()
+					ss.shutdown(KillReasonSignal, Shutdown{Reason: "terminated", Source: ShutdownSourceSignal})
+				}()
+				go func() {
+					defer wg.Done()
+					ss.endSession(Shutdown{Reason: "interrupt", Source: ShutdownSourceSignal})
+				}()
+			}
+			wg.Wait()
+			select {
+			case <-ss.Done():
+			case <-time.After(time.Second):
+				t.Fatal("the session did not end")
+			}
+			sd := ss.ShutdownReason()
+			So(sd.Source, ShouldBeIn, []string{ShutdownSourceControl, ShutdownSourceHeartbeat, ShutdownSourceSignal})
+			So(ss.ShutdownReason(), ShouldResemble, sd)
+			_, ok := <-ss.KillChan()
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
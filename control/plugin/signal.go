@@ -0,0 +1,64 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// killOnSignal shuts the session down like Kill when the plugin receives
+// SIGTERM or SIGINT.  A second signal while the session is draining ends
+// it at once.  The returned func stops watching for signals.
+func (s *SessionState) killOnSignal() func() {
+	c := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(c)
+		select {
+		case sig := <-c:
+			s.Logger().Infof("Received %v, shutting down", sig)
+			go s.shutdown(KillReasonSignal, Shutdown{Reason: sig.String(), Source: ShutdownSourceSignal})
+		case <-done:
+			return
+		}
+		select {
+		case sig := <-c:
+			s.Logger().Warnf("Received %v again, exiting without draining", sig)
+			s.endSession(Shutdown{Reason: sig.String(), Source: ShutdownSourceSignal})
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// shutdown ends the session on the plugin's own initiative, draining calls
+// and running the OnKill hook as Kill does.
+func (s *SessionState) shutdown(reason KillReason, sd Shutdown) {
+	if !s.stopHeartbeat() {
+		// The session is already ending
+		return
+	}
+	s.drain()
+	s.runOnKill(reason)
+	s.endSession(sd)
+}
@@ -0,0 +1,114 @@
+// +build legacy,!windows
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// signalCollector is a slow collector with an OnKill hook
+type signalCollector struct {
+	slowCollector
+	killed chan KillReason
+}
+
+func (s *signalCollector) OnKill(reason KillReason) error {
+	s.killed <- reason
+	return nil
+}
+
+func raise(sig os.Signal) {
+	p, err := os.FindProcess(os.Getpid())
+	So(err, ShouldBeNil)
+	So(p.Signal(sig), ShouldBeNil)
+}
+
+func waitDone(done chan int, timeout time.Duration) (int, bool) {
+	select {
+	case rc := <-done:
+		return rc, true
+	case <-time.After(timeout):
+		return 0, false
+	}
+}
+
+func TestKillOnSignal(t *testing.T) {
+	Convey("A plugin receiving SIGTERM", t, func() {
+		dir, err := ioutil.TempDir("", "snap-plugin-signal")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "plugin.sock")
+		p := &cleanupPublisher{}
+		m := NewPluginMeta("signal", 1, CollectorPluginType, []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+		resp, done := startTestPlugin(m, p, fmt.Sprintf(`{"ListenSocket": %q, "PingTimeoutDuration": %d}`, path, time.Minute))
+		So(resp.State, ShouldEqual, PluginSuccess)
+
+		raise(syscall.SIGTERM)
+		rc, ok := waitDone(done, 10*time.Second)
+		So(ok, ShouldBeTrue)
+		So(rc, ShouldEqual, 0)
+		So(p.killedWith(), ShouldResemble, []KillReason{KillReasonSignal})
+		_, err = os.Stat(path)
+		So(os.IsNotExist(err), ShouldBeTrue)
+		_, err = net.Dial("unix", path)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("A plugin receiving SIGINT with a call in flight", t, func() {
+		c := &signalCollector{
+			slowCollector: slowCollector{delay: 10 * time.Second, started: make(chan struct{})},
+			killed:        make(chan KillReason, 1),
+		}
+		m := NewPluginMeta("signal", 1, CollectorPluginType, []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+		resp, done := startTestPlugin(m, c, fmt.Sprintf(`{"KillDrainTimeout": %d, "PingTimeoutDuration": %d}`, time.Minute, time.Minute))
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer client.Close()
+		in, err := encoding.NewGobEncoder().Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: resp.Token})
+		So(err, ShouldBeNil)
+		go client.Call("Collector.CollectMetrics", in, &[]byte{})
+		<-c.started
+
+		raise(syscall.SIGINT)
+		_, ok := waitDone(done, 500*time.Millisecond)
+		So(ok, ShouldBeFalse)
+
+		Convey("exits without draining on a second signal", func() {
+			raise(syscall.SIGINT)
+			rc, ok := waitDone(done, 5*time.Second)
+			So(ok, ShouldBeTrue)
+			So(rc, ShouldEqual, 0)
+			// The hook was not reached as the call never finished draining
+			So(len(c.killed), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,126 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+)
+
+var (
+	ErrRequestUnsigned  error = &PluginError{Code: ErrorCodeUnauthorized, Message: "request is not signed"}
+	ErrRequestSignature error = &PluginError{Code: ErrorCodeUnauthorized, Message: "request signature is not valid"}
+	ErrRequestReplay    error = &PluginError{Code: ErrorCodeUnauthorized, Message: "request nonce has already been used"}
+)
+
+// RequestSignature is carried by the args of destructive RPCs.  When the
+// plugin was started with a ControlPubKey the call is only honored if
+// Signature is control's signature over the args, including Nonce.
+type RequestSignature struct {
+	Nonce     string
+	Signature []byte
+}
+
+func (r *RequestSignature) requestSignature() *RequestSignature {
+	return r
+}
+
+// SignedArgs is implemented by RPC args which embed a RequestSignature.
+type SignedArgs interface {
+	requestSignature() *RequestSignature
+}
+
+// requestDigest hashes the JSON encoding of args with the signature removed.
+func requestDigest(args SignedArgs) ([]byte, error) {
+	rs := args.requestSignature()
+	sig := rs.Signature
+	rs.Signature = nil
+	b, err := json.Marshal(args)
+	rs.Signature = sig
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(b)
+	return h[:], nil
+}
+
+// SignRequest sets a fresh nonce on args and signs them with control's key.
+func SignRequest(args SignedArgs, key *rsa.PrivateKey) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	rs := args.requestSignature()
+	rs.Nonce = hex.EncodeToString(nonce)
+	digest, err := requestDigest(args)
+	if err != nil {
+		return err
+	}
+	rs.Signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest)
+	return err
+}
+
+// nonceSet records the nonces of accepted requests.
+type nonceSet struct {
+	mutex sync.Mutex
+	seen  map[string]struct{}
+}
+
+// add returns false if the nonce was seen before.
+func (n *nonceSet) add(nonce string) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.seen == nil {
+		n.seen = map[string]struct{}{}
+	}
+	if _, ok := n.seen[nonce]; ok {
+		return false
+	}
+	n.seen[nonce] = struct{}{}
+	return true
+}
+
+// VerifyRequest checks the signature on args against ControlPubKey.  It
+// always succeeds when the session has no ControlPubKey.
+func (s *SessionState) VerifyRequest(args SignedArgs) error {
+	if s.Arg == nil || s.ControlPubKey == nil {
+		return nil
+	}
+	rs := args.requestSignature()
+	if len(rs.Signature) == 0 || rs.Nonce == "" {
+		return ErrRequestUnsigned
+	}
+	digest, err := requestDigest(args)
+	if err != nil {
+		return err
+	}
+	if err := rsa.VerifyPKCS1v15(s.ControlPubKey, crypto.SHA256, digest, rs.Signature); err != nil {
+		return ErrRequestSignature
+	}
+	if !s.nonces.add(rs.Nonce) {
+		return ErrRequestReplay
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ErrReplayExhausted is returned by the CollectMetrics calls of a session
+// replaying a recording which has no collection left and does not loop.
+var ErrReplayExhausted = errors.New("replay file exhausted")
+
+// recordedMetric is a line of a recording: a metric, in the JSON of
+// snap.json, and the collection it was returned by.  Lines without a
+// collection are all in collection 0.
+type recordedMetric struct {
+	Collection int `json:"collection"`
+	MetricType
+}
+
+// readRecording returns the collections of the recording at path, in the
+// order they were recorded.  Consecutive lines of the same collection make
+// up the reply of one CollectMetrics call.
+func readRecording(path string) ([][]MetricType, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var (
+		collections [][]MetricType
+		last        int
+	)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var m recordedMetric
+		if err := json.Unmarshal(line, &m); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		if len(collections) == 0 || m.Collection != last {
+			collections = append(collections, nil)
+			last = m.Collection
+		}
+		collections[len(collections)-1] = append(collections[len(collections)-1], m.MetricType)
+	}
+	return collections, scanner.Err()
+}
+
+// simulation replays a recording in place of a collector, see
+// Arg.ReplayFile, or records what the collector returns, see Arg.RecordFile.
+// A nil simulation does neither.
+type simulation struct {
+	mutex sync.Mutex
+	// replay holds the collections replayed, next the index of the next
+	// one
+	replay [][]MetricType
+	next   int
+	loop   bool
+	shift  bool
+	// record is the path of the recording appended to, and collection the
+	// number of the last collection in it
+	record     string
+	collection int
+}
+
+func newSimulation(arg *Arg) (*simulation, error) {
+	if arg.ReplayFile == "" && arg.RecordFile == "" {
+		return nil, nil
+	}
+	s := &simulation{loop: arg.ReplayLoop, shift: arg.ReplayShiftTime, record: arg.RecordFile}
+	if arg.ReplayFile != "" {
+		replay, err := readRecording(arg.ReplayFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the replay file: %v", err)
+		}
+		s.replay = replay
+	}
+	if arg.RecordFile != "" {
+		// number the collections on from those already recorded
+		if _, err := os.Stat(arg.RecordFile); err == nil {
+			recorded, err := readRecording(arg.RecordFile)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read the record file: %v", err)
+			}
+			s.collection = len(recorded)
+		}
+	}
+	return s, nil
+}
+
+// wrap returns p, replaying or recording its collections as configured
+func (s *simulation) wrap(p CollectorPlugin) CollectorPlugin {
+	if s == nil {
+		return p
+	}
+	if s.replay != nil {
+		return replayingCollector{CollectorPlugin: p, simulation: s}
+	}
+	if s.record != "" {
+		return recordingCollector{CollectorPlugin: p, simulation: s}
+	}
+	return p
+}
+
+// collect returns the next collection of the replay, its timestamps moved
+// so that the earliest is now when shift is set.
+func (s *simulation) collect() ([]MetricType, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.next == len(s.replay) {
+		if !s.loop || len(s.replay) == 0 {
+			return nil, ErrReplayExhausted
+		}
+		s.next = 0
+	}
+	recorded := s.replay[s.next]
+	s.next++
+	mts := make([]MetricType, len(recorded))
+	copy(mts, recorded)
+	if s.shift && len(mts) > 0 {
+		earliest := mts[0].Timestamp_
+		for _, mt := range mts[1:] {
+			if mt.Timestamp_.Before(earliest) {
+				earliest = mt.Timestamp_
+			}
+		}
+		d := time.Since(earliest)
+		for i := range mts {
+			mts[i].Timestamp_ = mts[i].Timestamp_.Add(d)
+		}
+	}
+	return mts, nil
+}
+
+// save appends a collection to the recording
+func (s *simulation) save(mts []MetricType) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, mt := range mts {
+		if err := enc.Encode(recordedMetric{Collection: s.collection + 1, MetricType: mt}); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(s.record, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	s.collection++
+	return nil
+}
+
+// replayingCollector answers CollectMetrics from the replay, without
+// calling the plugin, which still serves its catalog.
+type replayingCollector struct {
+	CollectorPlugin
+	simulation *simulation
+}
+
+func (c replayingCollector) CollectMetrics(mts []MetricType) ([]MetricType, error) {
+	return c.simulation.collect()
+}
+
+// recordingCollector records the metrics the plugin collects
+type recordingCollector struct {
+	CollectorPlugin
+	simulation *simulation
+}
+
+func (c recordingCollector) CollectMetrics(mts []MetricType) ([]MetricType, error) {
+	return c.CollectMetricsContext(context.Background(), mts)
+}
+
+func (c recordingCollector) CollectMetricsContext(ctx context.Context, mts []MetricType) ([]MetricType, error) {
+	mts, err := collectorWithContext(ctx, c.CollectorPlugin).CollectMetrics(mts)
+	if err != nil {
+		return nil, err
+	}
+	if len(mts) > 0 {
+		if err := c.simulation.save(mts); err != nil {
+			return nil, fmt.Errorf("unable to record the metrics collected: %v", err)
+		}
+	}
+	return mts, nil
+}
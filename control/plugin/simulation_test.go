@@ -0,0 +1,159 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// toyCollector collects its number of calls under two namespaces, at a
+// fixed time in the past
+type toyCollector struct {
+	calls int
+}
+
+var toyTime = time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func (c *toyCollector) CollectMetrics(mts []MetricType) ([]MetricType, error) {
+	c.calls++
+	ts := toyTime.Add(time.Duration(c.calls) * time.Minute)
+	return []MetricType{
+		*NewMetricType(core.NewNamespace("toy", "calls"), ts, map[string]string{"call": "yes"}, "", float64(c.calls)),
+		*NewMetricType(core.NewNamespace("toy", "double"), ts.Add(time.Second), nil, "", float64(2*c.calls)),
+	}, nil
+}
+
+func (c *toyCollector) GetMetricTypes(_ ConfigType) ([]MetricType, error) {
+	return []MetricType{
+		*NewMetricType(core.NewNamespace("toy", "calls"), time.Time{}, nil, "", nil),
+		*NewMetricType(core.NewNamespace("toy", "double"), time.Time{}, nil, "", nil),
+	}, nil
+}
+
+func (c *toyCollector) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	return cpolicy.New(), nil
+}
+
+func TestSimulation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simulation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "toy.jsonl")
+	m := NewPluginMeta("toy", 1, CollectorPluginType, nil, nil, Unsecure(true))
+	session := func(c *toyCollector, arg Arg) (*SessionState, *collectorPluginProxy) {
+		b, err := json.Marshal(arg)
+		So(err, ShouldBeNil)
+		ss, err, _ := NewSessionState(string(b), c, m)
+		So(err, ShouldBeNil)
+		return ss, &collectorPluginProxy{Plugin: ss.simulation.wrap(collectorPlugin(c, ss.base)), Session: ss, Meta: m, base: ss.base}
+	}
+	collect := func(ss *SessionState, proxy *collectorPluginProxy) ([]MetricType, error) {
+		in, err := ss.Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: ss.Token()})
+		So(err, ShouldBeNil)
+		var out []byte
+		if err := proxy.CollectMetrics(in, &out); err != nil {
+			return nil, err
+		}
+		var r CollectMetricsReply
+		So(ss.Decode(out, &r), ShouldBeNil)
+		return r.PluginMetrics, nil
+	}
+
+	Convey("A collector session", t, func() {
+		toy := &toyCollector{}
+		ss, proxy := session(toy, Arg{RecordFile: path})
+		var recorded [][]MetricType
+		for i := 0; i < 3; i++ {
+			mts, err := collect(ss, proxy)
+			So(err, ShouldBeNil)
+			recorded = append(recorded, mts)
+		}
+		So(toy.calls, ShouldEqual, 3)
+
+		Convey("records each collection to the record file", func() {
+			collections, err := readRecording(path)
+			So(err, ShouldBeNil)
+			So(collections, ShouldHaveLength, 3)
+			So(collections[2], ShouldHaveLength, 2)
+		})
+		Convey("replays the recording without calling the plugin", func() {
+			replay := &toyCollector{}
+			ss, proxy := session(replay, Arg{ReplayFile: path})
+			for _, want := range recorded {
+				mts, err := collect(ss, proxy)
+				So(err, ShouldBeNil)
+				So(mts, ShouldHaveLength, len(want))
+				for i, mt := range mts {
+					So(mt.Namespace().String(), ShouldEqual, want[i].Namespace().String())
+					So(mt.Data(), ShouldEqual, want[i].Data())
+					So(mt.Tags(), ShouldResemble, want[i].Tags())
+					So(mt.Timestamp().Equal(want[i].Timestamp()), ShouldBeTrue)
+				}
+			}
+			So(replay.calls, ShouldEqual, 0)
+			_, err := collect(ss, proxy)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, ErrReplayExhausted.Error())
+		})
+		Convey("loops over the recording with its timestamps moved to now", func() {
+			ss, proxy := session(&toyCollector{}, Arg{ReplayFile: path, ReplayLoop: true, ReplayShiftTime: true})
+			for i := 0; i < 4; i++ {
+				before := time.Now()
+				mts, err := collect(ss, proxy)
+				So(err, ShouldBeNil)
+				want := recorded[i%3]
+				So(mts[0].Data(), ShouldEqual, want[0].Data())
+				So(mts[0].Timestamp(), ShouldHappenOnOrBetween, before, time.Now())
+				So(mts[1].Timestamp().Sub(mts[0].Timestamp()), ShouldEqual, time.Second)
+			}
+		})
+		Convey("appends to a recording numbering its collections on", func() {
+			ss, proxy := session(toy, Arg{RecordFile: path})
+			_, err := collect(ss, proxy)
+			So(err, ShouldBeNil)
+			collections, err := readRecording(path)
+			So(err, ShouldBeNil)
+			So(collections, ShouldHaveLength, 4)
+		})
+		Reset(func() {
+			os.Remove(path)
+		})
+	})
+	Convey("A replay file which does not exist fails the session", t, func() {
+		b, err := json.Marshal(Arg{ReplayFile: filepath.Join(dir, "missing.jsonl")})
+		So(err, ShouldBeNil)
+		_, err, code := NewSessionState(string(b), &toyCollector{}, m)
+		So(err, ShouldNotBeNil)
+		So(code, ShouldEqual, 2)
+	})
+}
@@ -0,0 +1,187 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// UnixSocketScheme prefixes Response.ListenAddress when the plugin
+	// listens on a unix socket
+	UnixSocketScheme = "unix://"
+	// NamedPipeScheme prefixes Response.ListenAddress when the plugin
+	// listens on a Windows named pipe
+	NamedPipeScheme = "npipe://"
+	// DefaultListenAddr is the address a TCP listener binds to when
+	// Arg.ListenAddr is not set
+	DefaultListenAddr = "127.0.0.1"
+	// DefaultListenSocketMode restricts the socket to the owning user
+	DefaultListenSocketMode os.FileMode = 0600
+)
+
+var (
+	// ErrSocketInUse is returned when another process is listening on
+	// Arg.ListenSocket
+	ErrSocketInUse = errors.New("listen socket is in use by another process")
+	// ErrPipeUnsupported is returned when Arg.PipeName is set on a platform
+	// other than Windows
+	ErrPipeUnsupported = errors.New("named pipes are unsupported on this platform")
+)
+
+// SplitListenAddress returns the network and address to dial for a
+// Response.ListenAddress.  The network is "tcp", "unix" or "npipe".
+func SplitListenAddress(address string) (network, addr string) {
+	switch {
+	case strings.HasPrefix(address, UnixSocketScheme):
+		return "unix", strings.TrimPrefix(address, UnixSocketScheme)
+	case strings.HasPrefix(address, NamedPipeScheme):
+		return "npipe", strings.TrimPrefix(address, NamedPipeScheme)
+	}
+	return "tcp", address
+}
+
+// DialListenAddress connects to the plugin at a Response.ListenAddress.
+func DialListenAddress(address string, timeout time.Duration) (net.Conn, error) {
+	network, addr := SplitListenAddress(address)
+	if network == "npipe" {
+		return dialPipe(addr, timeout)
+	}
+	return net.DialTimeout(network, addr, timeout)
+}
+
+// portRange is a parsed Arg.ListenPortRange.
+type portRange struct {
+	first, last int
+}
+
+func (r *portRange) String() string {
+	return fmt.Sprintf("%d-%d", r.first, r.last)
+}
+
+// parsePortRange parses a range of ports in the form "40000-40100".
+func parsePortRange(s string) (*portRange, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid ListenPortRange %q: expected first-last", s)
+	}
+	first, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 16)
+	if err != nil || first == 0 {
+		return nil, fmt.Errorf("invalid ListenPortRange %q: invalid first port", s)
+	}
+	last, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 16)
+	if err != nil || last == 0 {
+		return nil, fmt.Errorf("invalid ListenPortRange %q: invalid last port", s)
+	}
+	if last < first {
+		return nil, fmt.Errorf("invalid ListenPortRange %q: last port is before first", s)
+	}
+	return &portRange{first: int(first), last: int(last)}, nil
+}
+
+// listenPortRange listens on the first port in r which is free on addr.
+func listenPortRange(addr string, r *portRange) (net.Listener, error) {
+	var err error
+	for port := r.first; port <= r.last; port++ {
+		var l net.Listener
+		l, err = net.Listen("tcp", net.JoinHostPort(addr, strconv.Itoa(port)))
+		if err == nil {
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("no port available in ListenPortRange %s: %v", r, err)
+}
+
+// pipePath returns the path of the named pipe called name.
+func pipePath(name string) string {
+	return `\\.\pipe\` + name
+}
+
+// listenUnix listens on a unix socket at path, replacing a socket file left
+// behind by a plugin which did not exit cleanly.  The socket is created
+// private to the plugin's user before it is given mode.  The socket file is
+// removed when the listener is closed.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	l, err := listenUnixPrivate(path)
+	if err != nil {
+		return nil, err
+	}
+	if mode == 0 {
+		mode = DefaultListenSocketMode
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// removeStaleSocket removes the socket file at path unless a process is
+// still listening on it.  Anything other than a socket is left alone.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return ErrSocketInUse
+	}
+	return os.Remove(path)
+}
+
+// removeSocketOnSignal removes the socket file when the plugin is
+// interrupted or terminated, then lets the signal take its course.  The
+// returned func stops watching for signals.
+func removeSocketOnSignal(path string) func() {
+	c := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-c:
+			os.Remove(path)
+			signal.Stop(c)
+			if p, err := os.FindProcess(os.Getpid()); err == nil {
+				p.Signal(sig)
+			}
+		case <-done:
+			signal.Stop(c)
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,45 @@
+// +build !windows
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"net"
+	"sync"
+	"syscall"
+)
+
+// umaskMutex serializes the changes to the umask of the process made by
+// listenUnixPrivate
+var umaskMutex sync.Mutex
+
+// listenUnixPrivate listens on a unix socket at path which is created
+// readable and writable by its owner only, so that no other user may
+// connect to it before its mode is set.  The umask applies to the whole
+// process while the socket is created: files created at the same time by
+// other goroutines are only made more private.
+func listenUnixPrivate(path string) (net.Listener, error) {
+	umaskMutex.Lock()
+	defer umaskMutex.Unlock()
+	old := syscall.Umask(0177)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
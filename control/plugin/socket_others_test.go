@@ -0,0 +1,62 @@
+// +build legacy,!windows
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestListenUnixPrivate(t *testing.T) {
+	Convey("A unix socket", t, func() {
+		dir, err := ioutil.TempDir("", "snap-plugin-socket")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "plugin.sock")
+		umask := syscall.Umask(0)
+		syscall.Umask(umask)
+
+		Convey("is created private to its owner", func() {
+			l, err := listenUnixPrivate(path)
+			So(err, ShouldBeNil)
+			defer l.Close()
+			fi, err := os.Stat(path)
+			So(err, ShouldBeNil)
+			So(fi.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+		})
+		Convey("leaves the umask of the process as it was", func() {
+			l, err := listenUnix(path, 0660)
+			So(err, ShouldBeNil)
+			defer l.Close()
+			fi, err := os.Stat(path)
+			So(err, ShouldBeNil)
+			So(fi.Mode().Perm(), ShouldEqual, os.FileMode(0660))
+			now := syscall.Umask(umask)
+			So(now, ShouldEqual, umask)
+		})
+	})
+}
@@ -0,0 +1,117 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnixSocketListener(t *testing.T) {
+	Convey("A plugin listening on a unix socket", t, func() {
+		dir, err := ioutil.TempDir("", "snap-plugin-socket")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "plugin.sock")
+		args := fmt.Sprintf(`{"ListenSocket": %q, "PingTimeoutDuration": %d}`, path, time.Minute)
+
+		Convey("is reachable at the advertised address", func() {
+			resp, done := startTestCollector(args)
+			So(resp.ListenAddress, ShouldEqual, UnixSocketScheme+path)
+
+			fi, err := os.Stat(path)
+			So(err, ShouldBeNil)
+			So(fi.Mode()&os.ModeSocket, ShouldNotEqual, 0)
+			So(fi.Mode().Perm(), ShouldEqual, DefaultListenSocketMode)
+
+			client, err := rpc.Dial(SplitListenAddress(resp.ListenAddress))
+			So(err, ShouldBeNil)
+			defer client.Close()
+			So(callPing(client, resp.Token), ShouldBeNil)
+
+			Convey("and removes the socket when killed", func() {
+				So(callKill(client, resp.Token), ShouldBeNil)
+				select {
+				case <-done:
+				case <-time.After(10 * time.Second):
+					t.Fatal("Start did not return after Kill")
+				}
+				_, err := os.Stat(path)
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+		})
+		Convey("replaces a stale socket file", func() {
+			l, err := net.Listen("unix", path)
+			So(err, ShouldBeNil)
+			// Leave the socket file behind as a crashed plugin would
+			l.(*net.UnixListener).SetUnlinkOnClose(false)
+			l.Close()
+			_, err = os.Stat(path)
+			So(err, ShouldBeNil)
+
+			resp, done := startTestCollector(args)
+			So(resp.State, ShouldEqual, PluginSuccess)
+			client, err := rpc.Dial(SplitListenAddress(resp.ListenAddress))
+			So(err, ShouldBeNil)
+			defer client.Close()
+			So(callPing(client, resp.Token), ShouldBeNil)
+			So(callKill(client, resp.Token), ShouldBeNil)
+			<-done
+		})
+		Convey("refuses a socket in use", func() {
+			l, err := net.Listen("unix", path)
+			So(err, ShouldBeNil)
+			defer l.Close()
+			go func() {
+				for {
+					conn, err := l.Accept()
+					if err != nil {
+						return
+					}
+					conn.Close()
+				}
+			}()
+			m := NewPluginMeta("mock", 1, CollectorPluginType, []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+			err, rc := Start(m, new(MockPlugin), args)
+			So(err, ShouldEqual, ErrSocketInUse)
+			So(rc, ShouldEqual, 2)
+		})
+		Convey("leaves other files alone", func() {
+			So(ioutil.WriteFile(path, []byte("data"), 0600), ShouldBeNil)
+			m := NewPluginMeta("mock", 1, CollectorPluginType, []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+			err, rc := Start(m, new(MockPlugin), args)
+			So(err, ShouldNotBeNil)
+			So(rc, ShouldEqual, 2)
+			b, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "data")
+		})
+	})
+}
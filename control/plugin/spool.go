@@ -0,0 +1,382 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+var (
+	// DefaultSpoolSegmentSize caps the size of a spool segment file when
+	// Arg.SpoolSegmentSize is not set.
+	DefaultSpoolSegmentSize int64 = 4 << 20
+	// DefaultSpoolRetryInterval is the wait between two attempts to replay
+	// the spool to an unreachable sink when Arg.SpoolRetryInterval is not
+	// set.
+	DefaultSpoolRetryInterval = 5 * time.Second
+)
+
+const (
+	spoolSuffix     = ".spool"
+	spoolCursorFile = "cursor"
+	// spoolHeaderSize is the length and CRC-32 of each record
+	spoolHeaderSize = 8
+)
+
+// spoolRecord is a payload spooled by a publisher
+type spoolRecord struct {
+	ContentType string
+	Content     []byte
+	Config      map[string]ctypes.ConfigValue
+}
+
+// spool keeps the payloads a publisher failed to publish in segment files
+// under dir, and replays them in order once the sink is back.  Each record
+// is its length and CRC-32, both big endian, followed by the gob of a
+// spoolRecord.  The progress of the replay is saved in the cursor file, so
+// that a record is not replayed twice across restarts.  A nil spool keeps
+// nothing.
+type spool struct {
+	dir         string
+	segmentSize int64
+	interval    time.Duration
+	session     Session
+	// publish calls the plugin with a spooled payload
+	publish func(contentType string, content []byte, config map[string]ctypes.ConfigValue) error
+
+	mutex    sync.Mutex
+	segments []uint64
+	// writer appends to the last segment, written bytes long.  It is nil
+	// until the first record of the session is spooled.
+	writer  *os.File
+	writing uint64
+	written int64
+	// offset is where the replay is in the first segment
+	offset int64
+	wake   chan struct{}
+}
+
+func openSpool(dir string, segmentSize int64, interval time.Duration, s Session) (*spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	sp := &spool{dir: dir, segmentSize: segmentSize, interval: interval, session: s, wake: make(chan struct{}, 1)}
+	names, err := filepath.Glob(filepath.Join(dir, "*"+spoolSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), spoolSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		sp.segments = append(sp.segments, seq)
+	}
+	sort.Sort(uint64s(sp.segments))
+	if b, err := ioutil.ReadFile(filepath.Join(dir, spoolCursorFile)); err == nil && len(sp.segments) > 0 {
+		var seq uint64
+		var offset int64
+		if _, err := fmt.Sscanf(string(b), "%d %d", &seq, &offset); err == nil && seq == sp.segments[0] {
+			sp.offset = offset
+		}
+	}
+	return sp, nil
+}
+
+func (sp *spool) segmentPath(seq uint64) string {
+	return filepath.Join(sp.dir, fmt.Sprintf("%020d%s", seq, spoolSuffix))
+}
+
+// pending reports whether payloads are waiting to be replayed, in which
+// case the payloads published meanwhile are spooled behind them to keep
+// their order.
+func (sp *spool) pending() bool {
+	if sp == nil {
+		return false
+	}
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	return len(sp.segments) > 0
+}
+
+// add appends a payload to the spool
+func (sp *spool) add(contentType string, content []byte, config map[string]ctypes.ConfigValue) error {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, spoolHeaderSize))
+	if err := gob.NewEncoder(&buf).Encode(spoolRecord{ContentType: contentType, Content: content, Config: config}); err != nil {
+		return err
+	}
+	record := buf.Bytes()
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(record)-spoolHeaderSize))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(record[spoolHeaderSize:]))
+
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	if sp.writer == nil || (sp.written > 0 && sp.written+int64(len(record)) > sp.segmentSize) {
+		if err := sp.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := sp.writer.Write(record); err != nil {
+		return err
+	}
+	sp.written += int64(len(record))
+	select {
+	case sp.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// rotate starts a new segment.  The caller holds the mutex.
+func (sp *spool) rotate() error {
+	if sp.writer != nil {
+		sp.writer.Close()
+	}
+	seq := uint64(1)
+	if n := len(sp.segments); n > 0 {
+		seq = sp.segments[n-1] + 1
+	}
+	f, err := os.OpenFile(sp.segmentPath(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		sp.writer = nil
+		return err
+	}
+	sp.writer, sp.writing, sp.written = f, seq, 0
+	sp.segments = append(sp.segments, seq)
+	return nil
+}
+
+// next returns the next record to replay and the offset after it, or false
+// when the spool is drained.  The segments replayed to their end are
+// deleted, and the corrupt records skipped.
+func (sp *spool) next() (*spoolRecord, int64, bool) {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	for len(sp.segments) > 0 {
+		seq := sp.segments[0]
+		tail := sp.writer != nil && seq == sp.writing
+		rec, next, err := readSpoolRecord(sp.segmentPath(seq), sp.offset)
+		switch {
+		case err == nil:
+			return rec, next, true
+		case err == errSpoolCorrupt:
+			sp.session.Logger().Warnf("Skipping a corrupt record at %d of spool segment %d", sp.offset, seq)
+			sp.offset = next
+			sp.saveCursor()
+			continue
+		case err != io.EOF && err != io.ErrUnexpectedEOF:
+			sp.session.Logger().Errorf("Reading spool segment %d failed: %v", seq, err)
+		case err == io.ErrUnexpectedEOF && !tail:
+			sp.session.Logger().Warnf("Skipping a partial record at the end of spool segment %d", seq)
+		}
+		if tail && sp.offset < sp.written {
+			return nil, 0, false
+		}
+		if tail {
+			sp.writer.Close()
+			sp.writer = nil
+		}
+		os.Remove(sp.segmentPath(seq))
+		sp.segments = sp.segments[1:]
+		sp.offset = 0
+		sp.saveCursor()
+	}
+	return nil, 0, false
+}
+
+// commit records that the replay got to offset in the first segment
+func (sp *spool) commit(offset int64) {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	sp.offset = offset
+	sp.saveCursor()
+}
+
+// saveCursor writes the progress of the replay.  The caller holds the
+// mutex.
+func (sp *spool) saveCursor() {
+	path := filepath.Join(sp.dir, spoolCursorFile)
+	if len(sp.segments) == 0 {
+		os.Remove(path)
+		return
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(fmt.Sprintf("%d %d", sp.segments[0], sp.offset)), 0600); err == nil {
+		os.Rename(tmp, path)
+	}
+}
+
+var errSpoolCorrupt = errors.New("corrupt spool record")
+
+// readSpoolRecord reads the record at offset of a segment.  It returns
+// errSpoolCorrupt, with the offset of the next record, for a record failing
+// its checksum, and io.EOF or io.ErrUnexpectedEOF at the end of the
+// segment.
+func readSpoolRecord(path string, offset int64) (*spoolRecord, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, 0); err != nil {
+		return nil, 0, err
+	}
+	header := make([]byte, spoolHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, 0, err
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if fi, err := f.Stat(); err != nil {
+		return nil, 0, err
+	} else if int64(size) > fi.Size()-offset-spoolHeaderSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	next := offset + spoolHeaderSize + int64(size)
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, next, errSpoolCorrupt
+	}
+	rec := &spoolRecord{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(rec); err != nil {
+		return nil, next, errSpoolCorrupt
+	}
+	return rec, next, nil
+}
+
+// replay publishes the spooled records in order until the spool is drained
+// or a record fails, which is retried after the interval.  A record holding
+// secure strings waits for the session key, and is dropped when they were
+// sealed with the key of a previous session.
+func (sp *spool) replay() bool {
+	for {
+		rec, next, ok := sp.next()
+		if !ok {
+			return true
+		}
+		if hasSecureValues(rec.Config) {
+			d := sp.session.decrypter()
+			if d == nil {
+				return false
+			}
+			if err := openSpoolRecord(rec, d); err != nil {
+				sp.session.Logger().Errorf("Dropping a spooled payload sealed with the key of a previous session: %v", err)
+				sp.commit(next)
+				continue
+			}
+		}
+		if err := sp.session.beginCall(context.Background()); err != nil {
+			return false
+		}
+		err := sp.publish(rec.ContentType, rec.Content, rec.Config)
+		sp.session.endCall()
+		if err != nil {
+			sp.session.Logger().Debugf("Replaying the spool failed: %v", err)
+			return false
+		}
+		sp.commit(next)
+	}
+}
+
+// openSpoolRecord lets the secure strings of rec be revealed with d, and
+// fails when one of them cannot be
+func openSpoolRecord(rec *spoolRecord, d ctypes.Decrypter) error {
+	openConfig(rec.Config, d)
+	for k, v := range rec.Config {
+		if s, ok := v.(ctypes.ConfigValueSecureString); ok {
+			if _, err := s.Reveal(); err != nil {
+				return fmt.Errorf("config %q: %v", k, err)
+			}
+		}
+	}
+	return nil
+}
+
+// start replays the spool with publish until done is closed
+func (sp *spool) start(publish func(string, []byte, map[string]ctypes.ConfigValue) error, done <-chan struct{}) {
+	if sp == nil {
+		return
+	}
+	sp.publish = publish
+	go sp.run(done)
+}
+
+// run replays the spool whenever a payload is spooled, and after the
+// interval while the sink fails, until done is closed.
+func (sp *spool) run(done <-chan struct{}) {
+	for {
+		var retry <-chan time.Time
+		if !sp.replay() {
+			retry = time.After(sp.interval)
+		}
+		select {
+		case <-done:
+			sp.close()
+			return
+		case <-sp.wake:
+			if retry != nil {
+				// wait out the interval once the sink failed
+				select {
+				case <-done:
+					sp.close()
+					return
+				case <-retry:
+				}
+			}
+		case <-retry:
+		}
+	}
+}
+
+func (sp *spool) close() {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	if sp.writer != nil {
+		sp.writer.Close()
+		sp.writer = nil
+	}
+}
+
+type uint64s []uint64
+
+func (u uint64s) Len() int           { return len(u) }
+func (u uint64s) Less(i, j int) bool { return u[i] < u[j] }
+func (u uint64s) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
@@ -0,0 +1,231 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encrypter"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// outageSink fails with a retryable error while it is down, and records
+// the payloads it took.
+type outageSink struct {
+	MockPublisher
+
+	mutex    sync.Mutex
+	down     bool
+	payloads []string
+}
+
+func (s *outageSink) Publish(_ string, content []byte, _ map[string]ctypes.ConfigValue) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.down {
+		return Retryable(errors.New("sink unreachable"))
+	}
+	s.payloads = append(s.payloads, string(content))
+	return nil
+}
+
+func (s *outageSink) setDown(down bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.down = down
+}
+
+func (s *outageSink) taken() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.payloads...)
+}
+
+func spoolSegments(dir string) []string {
+	names, _ := filepath.Glob(filepath.Join(dir, "*"+spoolSuffix))
+	return names
+}
+
+func TestSpool(t *testing.T) {
+	Convey("A publisher spooling to disk", t, func() {
+		dir, err := ioutil.TempDir("", "snap-plugin-spool")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		m := NewPluginMeta("spool", 1, PublisherPluginType, []string{SnapGOBContentType}, nil, Unsecure(true))
+		sink := &outageSink{down: true}
+		args := fmt.Sprintf(`{"LogLevel": "info", "SpoolDir": %q, "SpoolSegmentSize": 256, "SpoolRetryInterval": %d}`, dir, 10*time.Millisecond)
+		ss, err, _ := NewSessionState(args, sink, m)
+		So(err, ShouldBeNil)
+		defer ss.endSession(Shutdown{Reason: "test"})
+		proxy := &publisherPluginProxy{Plugin: sink, Session: ss, Meta: m, config: ss.config, spool: ss.spool}
+		ss.spool.start(sink.Publish, ss.Done())
+		config := map[string]ctypes.ConfigValue{RetryMaxAttemptsKey: ctypes.ConfigValueInt{Value: 1}}
+		publish := func(payload string) PublishReply {
+			in, err := ss.Encode(PublishArgs{ContentType: SnapGOBContentType, Content: []byte(payload), Token: ss.Token(), Config: config})
+			So(err, ShouldBeNil)
+			var out []byte
+			So(proxy.Publish(in, &out), ShouldBeNil)
+			var reply PublishReply
+			So(ss.Decode(out, &reply), ShouldBeNil)
+			return reply
+		}
+
+		var want []string
+		for i := 0; i < 8; i++ {
+			want = append(want, fmt.Sprintf("payload %d", i))
+			So(publish(want[i]).Spooled, ShouldBeTrue)
+		}
+		So(sink.taken(), ShouldBeEmpty)
+		So(len(spoolSegments(dir)), ShouldBeGreaterThan, 1)
+
+		Convey("replays it in order once the sink is back", func() {
+			sink.setDown(false)
+			deadline := time.Now().Add(5 * time.Second)
+			for len(spoolSegments(dir)) > 0 && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			So(spoolSegments(dir), ShouldBeEmpty)
+			So(sink.taken(), ShouldResemble, want)
+			So(publish("live").Spooled, ShouldBeFalse)
+			So(sink.taken(), ShouldResemble, append(want, "live"))
+		})
+	})
+	Convey("A spool", t, func() {
+		dir, err := ioutil.TempDir("", "snap-plugin-spool")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		ss, err, _ := NewSessionState(`{"LogLevel": "info"}`, &MockPublisher{}, NewPluginMeta("spool", 1, PublisherPluginType, nil, nil, Unsecure(true)))
+		So(err, ShouldBeNil)
+		sp, err := openSpool(dir, 1<<20, time.Millisecond, ss)
+		So(err, ShouldBeNil)
+		for i := 0; i < 4; i++ {
+			So(sp.add(SnapGOBContentType, []byte(fmt.Sprintf("payload %d", i)), nil), ShouldBeNil)
+		}
+		sp.close()
+		var replayed []string
+		sp.publish = func(_ string, content []byte, _ map[string]ctypes.ConfigValue) error {
+			replayed = append(replayed, string(content))
+			return nil
+		}
+
+		Convey("skips a corrupt record and a partial one", func() {
+			segment := spoolSegments(dir)[0]
+			b, err := ioutil.ReadFile(segment)
+			So(err, ShouldBeNil)
+			_, second, err := readSpoolRecord(segment, 0)
+			So(err, ShouldBeNil)
+			// flip a byte of the second record, and leave half a record
+			// behind as a crash while writing would
+			b[second+spoolHeaderSize+1] ^= 0xff
+			b = append(b, b[:second/2]...)
+			So(ioutil.WriteFile(segment, b, 0600), ShouldBeNil)
+
+			sp, err := openSpool(dir, 1<<20, time.Millisecond, ss)
+			So(err, ShouldBeNil)
+			sp.publish = func(_ string, content []byte, _ map[string]ctypes.ConfigValue) error {
+				replayed = append(replayed, string(content))
+				return nil
+			}
+			So(sp.replay(), ShouldBeTrue)
+			So(replayed, ShouldResemble, []string{"payload 0", "payload 2", "payload 3"})
+			So(spoolSegments(dir), ShouldBeEmpty)
+		})
+		Convey("resumes its replay where it stopped", func() {
+			failing := errors.New("sink unreachable")
+			sp.publish = func(_ string, content []byte, _ map[string]ctypes.ConfigValue) error {
+				if len(replayed) == 2 {
+					return failing
+				}
+				replayed = append(replayed, string(content))
+				return nil
+			}
+			So(sp.replay(), ShouldBeFalse)
+
+			sp, err := openSpool(dir, 1<<20, time.Millisecond, ss)
+			So(err, ShouldBeNil)
+			sp.publish = func(_ string, content []byte, _ map[string]ctypes.ConfigValue) error {
+				replayed = append(replayed, string(content))
+				return nil
+			}
+			So(sp.replay(), ShouldBeTrue)
+			So(replayed, ShouldResemble, []string{"payload 0", "payload 1", "payload 2", "payload 3"})
+		})
+	})
+}
+
+func TestSpoolSecureConfig(t *testing.T) {
+	Convey("A spool holding secure config values", t, func() {
+		dir, err := ioutil.TempDir("", "snap-plugin-spool")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		ss, err, _ := NewSessionState(`{"LogLevel": "info"}`, &MockPublisher{}, NewPluginMeta("spool", 1, PublisherPluginType, nil, nil, Unsecure(true)))
+		So(err, ShouldBeNil)
+		newKey := func() *encrypter.Encrypter {
+			key, err := encrypter.GenerateKey()
+			So(err, ShouldBeNil)
+			e := encrypter.New(nil, nil)
+			e.Key = key
+			return e
+		}
+		previous, current := newKey(), newKey()
+		secret := func(e *encrypter.Encrypter, s string) map[string]ctypes.ConfigValue {
+			sealed, err := ctypes.NewConfigValueSecureString(s).Seal(e)
+			So(err, ShouldBeNil)
+			return map[string]ctypes.ConfigValue{"password": sealed}
+		}
+
+		sp, err := openSpool(dir, 1<<20, time.Millisecond, ss)
+		So(err, ShouldBeNil)
+		So(sp.add(SnapGOBContentType, []byte("previous session"), secret(previous, "old")), ShouldBeNil)
+		So(sp.add(SnapGOBContentType, []byte("this session"), secret(current, testSecret)), ShouldBeNil)
+		var replayed, passwords []string
+		sp.publish = func(_ string, content []byte, config map[string]ctypes.ConfigValue) error {
+			p, err := config["password"].(ctypes.ConfigValueSecureString).Reveal()
+			if err != nil {
+				return err
+			}
+			replayed = append(replayed, string(content))
+			passwords = append(passwords, p)
+			return nil
+		}
+
+		Convey("waits for the session key", func() {
+			So(sp.replay(), ShouldBeFalse)
+			So(replayed, ShouldBeEmpty)
+		})
+		Convey("drops those sealed by a previous session and replays the others", func() {
+			ss.Encrypter = current
+			So(sp.replay(), ShouldBeTrue)
+			So(replayed, ShouldResemble, []string{"this session"})
+			So(passwords, ShouldResemble, []string{testSecret})
+			So(spoolSegments(dir), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,354 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net/rpc"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MethodStats counts the calls served by one RPC method
+type MethodStats struct {
+	Calls  uint64
+	Errors uint64
+	// LastDuration is how long the most recent call took
+	LastDuration time.Duration
+	MinDuration  time.Duration
+	AvgDuration  time.Duration
+	MaxDuration  time.Duration
+	// LastError is the error returned by the most recent failed call
+	LastError string
+	// Panics counts the calls which panicked.  They are also counted in
+	// Errors.
+	Panics uint64
+	// SlowCalls counts the calls which took over Arg.SlowCallThreshold
+	SlowCalls uint64
+	// Retries counts the retried attempts of the calls, and RetryDelay the
+	// time spent waiting between them, see RetryPolicy
+	Retries    uint64
+	RetryDelay time.Duration
+	// DryRuns counts the calls skipped by a dry run, see DryRunKey
+	DryRuns uint64
+
+	total time.Duration
+}
+
+// StatsArgs are the arguments of GetStats
+type StatsArgs struct {
+	Token string
+}
+
+// SessionStats is the reply of GetStats
+type SessionStats struct {
+	// Uptime is the time since the session started
+	Uptime time.Duration
+	// TokenAge is the time since the session token was issued
+	TokenAge time.Duration
+	// Pings is the number of pings received with the session token
+	Pings uint64
+	// Calls and Errors total the Methods
+	Calls   uint64
+	Errors  uint64
+	Panics  uint64
+	Methods map[string]MethodStats
+	// SlowCalls totals the Methods' SlowCalls
+	SlowCalls uint64
+	// Retries and RetryDelay total the Methods' Retries and RetryDelay
+	Retries    uint64
+	RetryDelay time.Duration
+	// DryRuns totals the Methods' DryRuns
+	DryRuns uint64
+	// QueueDepth is the number of calls waiting for a worker, and Rejected
+	// the number of calls which failed with ErrBusy, see Arg.Workers
+	QueueDepth int
+	Rejected   uint64
+	// CacheHits and CacheMisses count the metric types requested while the
+	// plugin has a CacheTTL, served from the cache or by the plugin
+	CacheHits   uint64
+	CacheMisses uint64
+	// Suspended is set while the session is suspended, since
+	// SuspendedSince
+	Suspended      bool
+	SuspendedSince time.Time
+}
+
+// GetStats replies with the SessionStats of the session and of each RPC
+// method it served.
+func (s *SessionState) GetStats(args []byte, reply *[]byte) error {
+	a := &StatsArgs{}
+	s.Decode(args, a)
+	if err := s.CheckToken(a.Token); err != nil {
+		return err
+	}
+	s.ResetHeartbeat()
+	s.Logger().Debugf("GetStats called")
+
+	st := SessionStats{}
+	now := time.Now()
+	if !s.started.IsZero() {
+		st.Uptime = now.Sub(s.started)
+	}
+	if !s.tokenIssued.IsZero() {
+		st.TokenAge = now.Sub(s.tokenIssued)
+	}
+	s.pingMutex.Lock()
+	st.Pings = s.pings
+	s.pingMutex.Unlock()
+	st.Methods, st.Errors, _ = s.stats.snapshot()
+	for _, m := range st.Methods {
+		st.Calls += m.Calls
+		st.Panics += m.Panics
+		st.SlowCalls += m.SlowCalls
+		st.Retries += m.Retries
+		st.RetryDelay += m.RetryDelay
+		st.DryRuns += m.DryRuns
+	}
+	if s.cache != nil {
+		st.CacheHits, st.CacheMisses = s.cache.counts()
+	}
+	st.Suspended, st.SuspendedSince = s.suspension.state()
+	st.QueueDepth, st.Rejected = s.pool.counts()
+	out, err := s.Encode(st)
+	if err != nil {
+		return err
+	}
+	*reply = out
+	return nil
+}
+
+// sessionStats collects MethodStats for each RPC method served
+type sessionStats struct {
+	mutex     sync.Mutex
+	methods   map[string]MethodStats
+	errors    uint64
+	lastError string
+
+	// onCall, when set, is called with each call recorded, e.g. to log it
+	onCall func(method string, d time.Duration, errMsg string)
+}
+
+func (st *sessionStats) record(method string, d time.Duration, errMsg string) {
+	if st.onCall != nil {
+		defer st.onCall(method, d, errMsg)
+	}
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if st.methods == nil {
+		st.methods = map[string]MethodStats{}
+	}
+	m := st.methods[method]
+	m.Calls++
+	m.LastDuration = d
+	m.total += d
+	if m.Calls == 1 || d < m.MinDuration {
+		m.MinDuration = d
+	}
+	if d > m.MaxDuration {
+		m.MaxDuration = d
+	}
+	m.AvgDuration = m.total / time.Duration(m.Calls)
+	if errMsg != "" {
+		m.Errors++
+		m.LastError = errMsg
+		st.errors++
+		st.lastError = errMsg
+	}
+	st.methods[method] = m
+}
+
+// recordPanic counts a panic in method.  The call itself is recorded as
+// usual with the error it was turned into.
+func (st *sessionStats) recordPanic(method string) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if st.methods == nil {
+		st.methods = map[string]MethodStats{}
+	}
+	m := st.methods[method]
+	m.Panics++
+	st.methods[method] = m
+}
+
+// recordSlow counts a call to method which took over the slow call
+// threshold.
+func (st *sessionStats) recordSlow(method string) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if st.methods == nil {
+		st.methods = map[string]MethodStats{}
+	}
+	m := st.methods[method]
+	m.SlowCalls++
+	st.methods[method] = m
+}
+
+// recordRetries counts the retries of a call to method and the time spent
+// waiting between them.
+func (st *sessionStats) recordRetries(method string, retries int, delay time.Duration) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if st.methods == nil {
+		st.methods = map[string]MethodStats{}
+	}
+	m := st.methods[method]
+	m.Retries += uint64(retries)
+	m.RetryDelay += delay
+	st.methods[method] = m
+}
+
+// recordDryRun counts a call to method skipped by a dry run
+func (st *sessionStats) recordDryRun(method string) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if st.methods == nil {
+		st.methods = map[string]MethodStats{}
+	}
+	m := st.methods[method]
+	m.DryRuns++
+	st.methods[method] = m
+}
+
+// snapshot returns a copy of the stats which is safe to use without the lock
+func (st *sessionStats) snapshot() (methods map[string]MethodStats, errors uint64, lastError string) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	methods = make(map[string]MethodStats, len(st.methods))
+	for k, v := range st.methods {
+		methods[k] = v
+	}
+	return methods, st.errors, st.lastError
+}
+
+// summary describes the errors seen by the session for the log
+func (st *sessionStats) summary() string {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if st.errors == 0 {
+		return "no errors"
+	}
+	return fmt.Sprintf("%d errors, last error: %s", st.errors, st.lastError)
+}
+
+// statsCodec records the method, duration and error of each call served
+// through the wrapped codec.
+type statsCodec struct {
+	rpc.ServerCodec
+	stats *sessionStats
+
+	mutex   sync.Mutex
+	pending map[uint64]pendingCall
+}
+
+type pendingCall struct {
+	method string
+	start  time.Time
+}
+
+func newStatsCodec(c rpc.ServerCodec, stats *sessionStats) rpc.ServerCodec {
+	return &statsCodec{
+		ServerCodec: c,
+		stats:       stats,
+		pending:     map[uint64]pendingCall{},
+	}
+}
+
+func (c *statsCodec) ReadRequestHeader(r *rpc.Request) error {
+	if err := c.ServerCodec.ReadRequestHeader(r); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.pending[r.Seq] = pendingCall{method: r.ServiceMethod, start: time.Now()}
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *statsCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.mutex.Lock()
+	call, ok := c.pending[r.Seq]
+	delete(c.pending, r.Seq)
+	c.mutex.Unlock()
+	if ok {
+		c.stats.record(call.method, time.Since(call.start), r.Error)
+	}
+	return c.ServerCodec.WriteResponse(r, body)
+}
+
+// grpcMethodName returns the net/rpc style name, e.g. "Collector.Ping", of
+// a gRPC method such as "/rpc.Collector/Ping".
+func grpcMethodName(fullMethod string) string {
+	parts := strings.SplitN(strings.TrimPrefix(fullMethod, "/"), "/", 2)
+	if len(parts) != 2 {
+		return fullMethod
+	}
+	service := parts[0]
+	if i := strings.LastIndex(service, "."); i >= 0 {
+		service = service[i+1:]
+	}
+	return service + "." + parts[1]
+}
+
+// gobServerCodec is the codec net/rpc uses for ServeConn, which it does not
+// export.
+type gobServerCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+}
+
+func newGobServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	buf := bufio.NewWriter(conn)
+	return &gobServerCodec{
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(buf),
+		encBuf: buf,
+	}
+}
+
+func (c *gobServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobServerCodec) ReadRequestBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	if err := c.enc.Encode(r); err != nil {
+		c.Close()
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		c.Close()
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *gobServerCodec) Close() error {
+	return c.rwc.Close()
+}
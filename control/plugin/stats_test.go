@@ -0,0 +1,174 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSessionStats(t *testing.T) {
+	Convey("A session serving a failing collector", t, func() {
+		m := NewPluginMeta("failing", 1, CollectorPluginType, []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+		resp, done := startTestPlugin(m, &failingCollector{}, fmt.Sprintf(`{"PingTimeoutDuration": %d}`, time.Minute))
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer client.Close()
+		enc := encoding.NewGobEncoder()
+
+		in, err := enc.Encode(CollectMetricsArgs{MetricTypes: mockMetricType, Token: resp.Token})
+		So(err, ShouldBeNil)
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				client.Call("Collector.CollectMetrics", in, &[]byte{})
+			}()
+		}
+		wg.Wait()
+		So(callPing(client, resp.Token), ShouldBeNil)
+		So(callPing(client, "wrong"), ShouldNotBeNil)
+
+		in, err = enc.Encode(PingArgs{Token: resp.Token})
+		So(err, ShouldBeNil)
+		var out []byte
+		So(client.Call("SessionState.PingStatus", in, &out), ShouldBeNil)
+		var r PingReply
+		So(enc.Decode(out, &r), ShouldBeNil)
+
+		So(r.Errors, ShouldEqual, 5)
+		So(r.LastError, ShouldEqual, "device unreachable")
+		collect := r.Methods["Collector.CollectMetrics"]
+		So(collect.Calls, ShouldEqual, 4)
+		So(collect.Errors, ShouldEqual, 4)
+		So(collect.LastError, ShouldContainSubstring, "device unreachable")
+		So(collect.LastDuration, ShouldBeGreaterThan, 0)
+		ping := r.Methods["SessionState.Ping"]
+		So(ping.Calls, ShouldEqual, 2)
+		So(ping.Errors, ShouldEqual, 1)
+		So(ping.LastError, ShouldEqual, ErrBadToken.Error())
+
+		So(callKill(client, resp.Token), ShouldBeNil)
+		<-done
+	})
+}
+
+func TestGRPCMethodName(t *testing.T) {
+	Convey("grpcMethodName", t, func() {
+		So(grpcMethodName("/rpc.Collector/CollectMetrics"), ShouldEqual, "Collector.CollectMetrics")
+		So(grpcMethodName("/Publisher/Publish"), ShouldEqual, "Publisher.Publish")
+		So(grpcMethodName("bogus"), ShouldEqual, "bogus")
+	})
+}
+
+func TestGetStats(t *testing.T) {
+	for _, codec := range []string{GobCodec, JSONCodec} {
+		Convey("GetStats over the "+codec+" codec", t, func() {
+			args := fmt.Sprintf(`{"Codec": %q, "PingTimeoutDuration": %d}`, codec, time.Minute)
+			resp, done := startTestCollector(args)
+			conn, err := net.Dial("tcp", resp.ListenAddress)
+			So(err, ShouldBeNil)
+			var client *rpc.Client
+			var enc encoding.Encoder
+			if codec == JSONCodec {
+				client = jsonrpc.NewClient(conn)
+				enc = encoding.NewJsonEncoder()
+			} else {
+				client = rpc.NewClient(conn)
+				enc = encoding.NewGobEncoder()
+			}
+			defer client.Close()
+			call := func(method string, args interface{}) ([]byte, error) {
+				in, err := enc.Encode(args)
+				So(err, ShouldBeNil)
+				var out []byte
+				err = client.Call(method, in, &out)
+				return out, err
+			}
+
+			for i := 0; i < 3; i++ {
+				_, err := call("SessionState.Ping", PingArgs{Token: resp.Token})
+				So(err, ShouldBeNil)
+			}
+			for i := 0; i < 2; i++ {
+				_, err := call("SessionState.Ping", PingArgs{Token: "wrong"})
+				So(err, ShouldNotBeNil)
+			}
+			_, err = call("SessionState.GetStats", StatsArgs{Token: "wrong"})
+			So(err, ShouldNotBeNil)
+
+			out, err := call("SessionState.GetStats", StatsArgs{Token: resp.Token})
+			So(err, ShouldBeNil)
+			var st SessionStats
+			So(enc.Decode(out, &st), ShouldBeNil)
+
+			So(st.Pings, ShouldEqual, 3)
+			So(st.Calls, ShouldEqual, 6)
+			So(st.Errors, ShouldEqual, 3)
+			So(st.Uptime, ShouldBeGreaterThan, 0)
+			So(st.TokenAge, ShouldBeGreaterThan, 0)
+			So(len(st.Methods), ShouldEqual, 2)
+			ping := st.Methods["SessionState.Ping"]
+			So(ping.Calls, ShouldEqual, 5)
+			So(ping.Errors, ShouldEqual, 2)
+			So(ping.MinDuration, ShouldBeGreaterThan, 0)
+			So(ping.MinDuration, ShouldBeLessThanOrEqualTo, ping.AvgDuration)
+			So(ping.AvgDuration, ShouldBeLessThanOrEqualTo, ping.MaxDuration)
+			stats := st.Methods["SessionState.GetStats"]
+			So(stats.Calls, ShouldEqual, 1)
+			So(stats.Errors, ShouldEqual, 1)
+
+			_, err = call("SessionState.Kill", KillArgs{Reason: "test", Token: resp.Token})
+			So(err, ShouldBeNil)
+			<-done
+		})
+	}
+}
+
+func TestSessionStatsRecord(t *testing.T) {
+	Convey("sessionStats", t, func() {
+		st := &sessionStats{}
+		st.record("Collector.CollectMetrics", 3*time.Millisecond, "")
+		st.record("Collector.CollectMetrics", time.Millisecond, "failed")
+		st.record("Collector.CollectMetrics", 5*time.Millisecond, "")
+		methods, errors, lastError := st.snapshot()
+		So(errors, ShouldEqual, 1)
+		So(lastError, ShouldEqual, "failed")
+		m := methods["Collector.CollectMetrics"]
+		So(m.Calls, ShouldEqual, 3)
+		So(m.Errors, ShouldEqual, 1)
+		So(m.MinDuration, ShouldEqual, time.Millisecond)
+		So(m.MaxDuration, ShouldEqual, 5*time.Millisecond)
+		So(m.AvgDuration, ShouldEqual, 3*time.Millisecond)
+		So(m.LastDuration, ShouldEqual, 5*time.Millisecond)
+		So(m.LastError, ShouldEqual, "failed")
+	})
+}
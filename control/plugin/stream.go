@@ -0,0 +1,273 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultStreamBufferSize is the number of streamed metrics a plugin buffers
+// between two drains unless Arg.StreamBufferSize says otherwise.
+const DefaultStreamBufferSize = 10000
+
+// maxStreamErrors is the number of stream errors kept between two drains.
+// Older errors are dropped first.
+const maxStreamErrors = 100
+
+// ErrStreamNotCollected is returned by Collector.CollectMetrics on a stream
+// collector, whose metrics are only sent through StreamCollector.Drain.
+var ErrStreamNotCollected = errors.New("a stream collector does not collect on demand")
+
+// StreamCollector is a collector which pushes metrics as they happen rather
+// than being polled at an interval.
+type StreamCollector interface {
+	Plugin
+	// StreamMetrics sends batches of metrics on send and non fatal errors
+	// on errs until stop is closed.  It returns once it stopped sending,
+	// with the error which ended the stream if any.
+	StreamMetrics(send chan<- []MetricType, errs chan<- error, stop <-chan struct{}) error
+	// GetMetricTypes returns the catalog of metrics the plugin streams.
+	GetMetricTypes(ConfigType) ([]MetricType, error)
+}
+
+// DrainStreamArgs are the arguments of StreamCollector.Drain
+type DrainStreamArgs struct {
+	Token string
+	// Max caps the number of metrics in the reply.  Zero drains the whole
+	// buffer.
+	Max int
+	// Wait is how long Drain waits for metrics when the buffer is empty
+	Wait time.Duration
+}
+
+// DrainStreamReply is the reply of StreamCollector.Drain
+type DrainStreamReply struct {
+	Metrics []MetricType
+	// Dropped is the number of metrics dropped since the stream started,
+	// the oldest first, because the buffer was full.
+	Dropped uint64
+	// Errors are the errors sent by the plugin since the last drain
+	Errors []string
+	// Ended is set once the stream ended and the buffer is empty.  Err is
+	// the error StreamMetrics returned, if any.
+	Ended bool
+	Err   string
+}
+
+// streamBuffer holds the streamed metrics until control drains them.  Once
+// it holds size metrics the oldest are dropped.
+type streamBuffer struct {
+	mutex   sync.Mutex
+	size    int
+	metrics []MetricType
+	errs    []string
+	dropped uint64
+	ended   bool
+	err     string
+	// ready is signaled when metrics are pushed or the stream ends
+	ready chan struct{}
+}
+
+func newStreamBuffer(size int) *streamBuffer {
+	if size <= 0 {
+		size = DefaultStreamBufferSize
+	}
+	return &streamBuffer{size: size, ready: make(chan struct{}, 1)}
+}
+
+func (b *streamBuffer) push(mts []MetricType) {
+	b.mutex.Lock()
+	b.metrics = append(b.metrics, mts...)
+	if n := len(b.metrics) - b.size; n > 0 {
+		b.dropped += uint64(n)
+		b.metrics = append([]MetricType(nil), b.metrics[n:]...)
+	}
+	b.mutex.Unlock()
+	b.signal()
+}
+
+func (b *streamBuffer) pushError(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.errs = append(b.errs, err.Error())
+	if n := len(b.errs) - maxStreamErrors; n > 0 {
+		b.errs = b.errs[n:]
+	}
+}
+
+func (b *streamBuffer) end(err error) {
+	b.mutex.Lock()
+	b.ended = true
+	if err != nil {
+		b.err = err.Error()
+	}
+	b.mutex.Unlock()
+	b.signal()
+}
+
+func (b *streamBuffer) signal() {
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+}
+
+// drain takes up to max metrics, waiting up to wait for the first ones.
+func (b *streamBuffer) drain(max int, wait time.Duration) DrainStreamReply {
+	var timeout <-chan time.Time
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		timeout = t.C
+	}
+	for {
+		b.mutex.Lock()
+		if len(b.metrics) > 0 || b.ended || timeout == nil {
+			break
+		}
+		b.mutex.Unlock()
+		select {
+		case <-b.ready:
+		case <-timeout:
+			timeout = nil
+		}
+	}
+	defer b.mutex.Unlock()
+
+	n := len(b.metrics)
+	if max > 0 && max < n {
+		n = max
+	}
+	r := DrainStreamReply{
+		Metrics: b.metrics[:n:n],
+		Dropped: b.dropped,
+		Errors:  b.errs,
+	}
+	b.metrics = b.metrics[n:]
+	b.errs = nil
+	if b.ended && len(b.metrics) == 0 {
+		r.Ended, r.Err = true, b.err
+	}
+	return r
+}
+
+// stream runs StreamMetrics, started by the first drain, and buffers what it
+// sends until the session ends.
+type stream struct {
+	plugin StreamCollector
+	buffer *streamBuffer
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stop      chan struct{}
+	// done is closed once StreamMetrics returned
+	done chan struct{}
+}
+
+func newStream(p StreamCollector, size int) *stream {
+	return &stream{
+		plugin: p,
+		buffer: newStreamBuffer(size),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (s *stream) start() {
+	s.startOnce.Do(func() {
+		send := make(chan []MetricType)
+		errs := make(chan error)
+		result := make(chan error, 1)
+		go func() {
+			result <- s.plugin.StreamMetrics(send, errs, s.stop)
+		}()
+		go func() {
+			defer close(s.done)
+			// Keep reading after stop so that a plugin blocked on a send
+			// sees stop closed
+			for {
+				select {
+				case mts := <-send:
+					s.buffer.push(mts)
+				case err := <-errs:
+					if err != nil {
+						s.buffer.pushError(err)
+					}
+				case err := <-result:
+					s.buffer.end(err)
+					return
+				}
+			}
+		}()
+	})
+}
+
+// halt closes the stop channel given to StreamMetrics.  A stream which was
+// never started is marked ended.
+func (s *stream) halt() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		s.startOnce.Do(func() {
+			s.buffer.end(nil)
+			close(s.done)
+		})
+	})
+}
+
+type streamCollectorPluginProxy struct {
+	Plugin  StreamCollector
+	Session Session
+	stream  *stream
+}
+
+// Drain replies with the metrics streamed since the previous drain.  The
+// first call starts the stream.
+func (c *streamCollectorPluginProxy) Drain(args []byte, reply *[]byte) (err error) {
+	defer c.Session.recoverPanic("StreamCollector.Drain", &err)
+
+	dargs := &DrainStreamArgs{}
+	c.Session.Decode(args, dargs)
+	if err := c.Session.CheckToken(dargs.Token); err != nil {
+		return err
+	}
+	c.Session.ResetHeartbeat()
+	if err := c.Session.beginCall(context.Background()); err != nil {
+		return err
+	}
+	defer c.Session.endCall()
+
+	c.stream.start()
+	r := c.stream.buffer.drain(dargs.Max, dargs.Wait)
+	*reply, err = c.Session.Encode(r)
+	return err
+}
+
+// streamCatalog serves Collector.GetMetricTypes for a StreamCollector
+type streamCatalog struct {
+	StreamCollector
+}
+
+func (s streamCatalog) CollectMetrics([]MetricType) ([]MetricType, error) {
+	return nil, ErrStreamNotCollected
+}
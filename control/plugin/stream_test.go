@@ -0,0 +1,206 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	"github.com/intelsdi-x/snap/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// mockStreamCollector sends the batches given to it, then waits for stop.
+type mockStreamCollector struct {
+	batches chan []MetricType
+	stopped chan struct{}
+}
+
+func newMockStreamCollector() *mockStreamCollector {
+	return &mockStreamCollector{batches: make(chan []MetricType), stopped: make(chan struct{})}
+}
+
+func (m *mockStreamCollector) StreamMetrics(send chan<- []MetricType, errs chan<- error, stop <-chan struct{}) error {
+	defer close(m.stopped)
+	for {
+		select {
+		case mts := <-m.batches:
+			if mts == nil {
+				errs <- errors.New("device busy")
+				continue
+			}
+			send <- mts
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+func (m *mockStreamCollector) GetMetricTypes(ConfigType) ([]MetricType, error) {
+	return []MetricType{{Namespace_: core.NewNamespace("foo", "events")}}, nil
+}
+
+func (m *mockStreamCollector) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	return cpolicy.New(), nil
+}
+
+func streamBatch(first, n int) []MetricType {
+	mts := make([]MetricType, n)
+	for i := range mts {
+		mts[i] = MetricType{Namespace_: core.NewNamespace("foo", "events"), Data_: first + i}
+	}
+	return mts
+}
+
+func streamData(mts []MetricType) []interface{} {
+	data := make([]interface{}, len(mts))
+	for i, mt := range mts {
+		data[i] = mt.Data()
+	}
+	return data
+}
+
+func TestStreamBuffer(t *testing.T) {
+	Convey("A stream buffer", t, func() {
+		b := newStreamBuffer(5)
+		Convey("drains what was pushed", func() {
+			b.push(streamBatch(0, 3))
+			r := b.drain(2, 0)
+			So(streamData(r.Metrics), ShouldResemble, []interface{}{0, 1})
+			r = b.drain(0, 0)
+			So(streamData(r.Metrics), ShouldResemble, []interface{}{2})
+			So(r.Dropped, ShouldEqual, 0)
+			So(r.Ended, ShouldBeFalse)
+		})
+		Convey("drops the oldest metrics once full", func() {
+			b.push(streamBatch(0, 3))
+			b.push(streamBatch(3, 4))
+			r := b.drain(0, 0)
+			So(streamData(r.Metrics), ShouldResemble, []interface{}{2, 3, 4, 5, 6})
+			So(r.Dropped, ShouldEqual, 2)
+			b.push(streamBatch(7, 6))
+			r = b.drain(0, 0)
+			So(streamData(r.Metrics), ShouldResemble, []interface{}{8, 9, 10, 11, 12})
+			So(r.Dropped, ShouldEqual, 3)
+		})
+		Convey("keeps the latest errors", func() {
+			for i := 0; i < maxStreamErrors+2; i++ {
+				b.pushError(fmt.Errorf("error %d", i))
+			}
+			r := b.drain(0, 0)
+			So(r.Errors, ShouldHaveLength, maxStreamErrors)
+			So(r.Errors[0], ShouldEqual, "error 2")
+			So(b.drain(0, 0).Errors, ShouldBeEmpty)
+		})
+		Convey("waits for metrics", func() {
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				b.push(streamBatch(0, 1))
+			}()
+			r := b.drain(0, time.Minute)
+			So(r.Metrics, ShouldHaveLength, 1)
+			start := time.Now()
+			r = b.drain(0, 10*time.Millisecond)
+			So(r.Metrics, ShouldBeEmpty)
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 10*time.Millisecond)
+		})
+		Convey("is ended once drained", func() {
+			b.push(streamBatch(0, 2))
+			b.end(errors.New("device gone"))
+			r := b.drain(1, time.Minute)
+			So(r.Ended, ShouldBeFalse)
+			r = b.drain(0, time.Minute)
+			So(r.Metrics, ShouldHaveLength, 1)
+			So(r.Ended, ShouldBeTrue)
+			So(r.Err, ShouldEqual, "device gone")
+		})
+	})
+}
+
+func TestStreamCollector(t *testing.T) {
+	Convey("A stream collector", t, func() {
+		sc := newMockStreamCollector()
+		m := NewPluginMeta("stream", 1, StreamCollectorPluginType, nil, nil, Unsecure(true))
+		resp, done := startTestPlugin(m, sc, fmt.Sprintf(`{"StreamBufferSize": 4, "KillDelay": 1, "PingTimeoutDuration": %d}`, time.Minute))
+		So(resp.State, ShouldEqual, PluginSuccess)
+		So(resp.Type, ShouldEqual, StreamCollectorPluginType)
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer client.Close()
+		enc := encoding.NewGobEncoder()
+		call := func(method string, args, reply interface{}) error {
+			in, err := enc.Encode(args)
+			So(err, ShouldBeNil)
+			var out []byte
+			if err := client.Call(method, in, &out); err != nil {
+				return err
+			}
+			return enc.Decode(out, reply)
+		}
+		drain := func(max int) DrainStreamReply {
+			var r DrainStreamReply
+			So(call("StreamCollector.Drain", DrainStreamArgs{Token: resp.Token, Max: max}, &r), ShouldBeNil)
+			return r
+		}
+
+		Convey("serves its catalog but not CollectMetrics", func() {
+			var r GetMetricTypesReply
+			So(call("Collector.GetMetricTypes", GetMetricTypesArgs{Token: resp.Token}, &r), ShouldBeNil)
+			So(r.MetricTypes, ShouldHaveLength, 1)
+			err := call("Collector.CollectMetrics", CollectMetricsArgs{MetricTypes: mockMetricType, Token: resp.Token}, &CollectMetricsReply{})
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeCallFailed)
+		})
+		Convey("streams once drained", func() {
+			So(drain(0).Metrics, ShouldBeEmpty)
+			sc.batches <- streamBatch(0, 3)
+			sc.batches <- nil
+			sc.batches <- streamBatch(3, 3)
+			sc.batches <- streamBatch(6, 1)
+			// The error is only taken once the last batch was buffered
+			sc.batches <- nil
+			r := drain(2)
+			So(streamData(r.Metrics), ShouldResemble, []interface{}{3, 4})
+			So(r.Dropped, ShouldEqual, 3)
+			So(r.Errors, ShouldNotBeEmpty)
+			So(r.Errors[0], ShouldEqual, "device busy")
+			So(streamData(drain(0).Metrics), ShouldResemble, []interface{}{5, 6})
+		})
+		Convey("rejects a bad token", func() {
+			err := call("StreamCollector.Drain", DrainStreamArgs{Token: "bad"}, &DrainStreamReply{})
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeUnauthorized)
+		})
+
+		drain(0)
+		So(callKill(client, resp.Token), ShouldBeNil)
+		select {
+		case <-sc.stopped:
+		case <-time.After(5 * time.Second):
+			So("StreamMetrics was not stopped", ShouldBeEmpty)
+		}
+		<-done
+	})
+}
@@ -0,0 +1,161 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/intelsdi-x/snap/core"
+	"github.com/intelsdi-x/snap/core/cdata"
+)
+
+// SubscriptionHandler may be implemented by a collector to prepare, e.g.
+// connect to a backend, when a metric is first subscribed by a task and to
+// tear it down when no task collects it anymore.
+type SubscriptionHandler interface {
+	// OnSubscribe is called when ns gets its first subscriber, with the
+	// config of that subscription.  An error fails the subscription.
+	OnSubscribe(ns core.Namespace, config *cdata.ConfigDataNode) error
+	// OnUnsubscribe is called when the last subscriber of ns unsubscribed.
+	// Its error is returned to control but the subscription is gone.
+	OnUnsubscribe(ns core.Namespace) error
+}
+
+// SubscribeMetricsArgs are the arguments of SubscribeMetrics and
+// UnsubscribeMetrics.  Only the namespace and config of each metric are
+// used.
+type SubscribeMetricsArgs struct {
+	MetricTypes []MetricType
+	Token       string
+}
+
+// SubscribeMetricsReply is the reply of SubscribeMetrics and
+// UnsubscribeMetrics
+type SubscribeMetricsReply struct {
+	// Refs is the number of subscriptions of each namespace after the call
+	Refs map[string]int
+}
+
+// subscriptionTracker counts the subscriptions of each namespace.  Calls to
+// the handler are serialized so that it sees subscriptions and
+// unsubscriptions in order.
+type subscriptionTracker struct {
+	mutex   sync.Mutex
+	refs    map[string]int
+	handler SubscriptionHandler
+}
+
+func newSubscriptionTracker(p Plugin) *subscriptionTracker {
+	t := &subscriptionTracker{refs: map[string]int{}}
+	t.handler, _ = p.(SubscriptionHandler)
+	return t
+}
+
+// subscribe counts a subscription of each metric.  A metric which fails its
+// first subscription is not counted, and neither are those after it.
+func (t *subscriptionTracker) subscribe(mts []MetricType) (map[string]int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	refs := map[string]int{}
+	for _, mt := range mts {
+		key := mt.Namespace().String()
+		if t.refs[key] == 0 && t.handler != nil {
+			if err := t.handler.OnSubscribe(mt.Namespace(), mt.Config()); err != nil {
+				return refs, &PluginError{Code: ErrorCodeCallFailed, Message: fmt.Sprintf("OnSubscribe(%s) call error : %s", key, err)}
+			}
+		}
+		t.refs[key]++
+		refs[key] = t.refs[key]
+	}
+	return refs, nil
+}
+
+// unsubscribe drops a subscription of each metric.  Unsubscribing a metric
+// which has no subscription does nothing.
+func (t *subscriptionTracker) unsubscribe(mts []MetricType) (map[string]int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	refs := map[string]int{}
+	var errs []string
+	for _, mt := range mts {
+		key := mt.Namespace().String()
+		switch t.refs[key] {
+		case 0:
+		case 1:
+			delete(t.refs, key)
+			if t.handler != nil {
+				if err := t.handler.OnUnsubscribe(mt.Namespace()); err != nil {
+					errs = append(errs, fmt.Sprintf("OnUnsubscribe(%s) call error : %s", key, err))
+				}
+			}
+		default:
+			t.refs[key]--
+		}
+		refs[key] = t.refs[key]
+	}
+	if len(errs) > 0 {
+		return refs, &PluginError{Code: ErrorCodeCallFailed, Message: strings.Join(errs, "; ")}
+	}
+	return refs, nil
+}
+
+// SubscribeMetrics tells the collector that a task subscribed to the given
+// metrics.
+func (c *collectorPluginProxy) SubscribeMetrics(args []byte, reply *[]byte) (err error) {
+	return c.subscription("Collector.SubscribeMetrics", args, reply, (*subscriptionTracker).subscribe)
+}
+
+// UnsubscribeMetrics tells the collector that a task unsubscribed from the
+// given metrics.
+func (c *collectorPluginProxy) UnsubscribeMetrics(args []byte, reply *[]byte) (err error) {
+	return c.subscription("Collector.UnsubscribeMetrics", args, reply, (*subscriptionTracker).unsubscribe)
+}
+
+func (c *collectorPluginProxy) subscription(method string, args []byte, reply *[]byte, fn func(*subscriptionTracker, []MetricType) (map[string]int, error)) (err error) {
+	defer c.Session.recoverPanic(method, &err)
+
+	dargs := &SubscribeMetricsArgs{}
+	c.Session.Decode(args, dargs)
+	if err := c.Session.CheckToken(dargs.Token); err != nil {
+		return err
+	}
+	c.Session.ResetHeartbeat()
+	if err := c.Session.beginCall(c.base.context()); err != nil {
+		return err
+	}
+	defer c.Session.endCall()
+
+	for _, mt := range dargs.MetricTypes {
+		if mt.Config_ != nil {
+			openConfig(mt.Config_.Table(), c.Session.decrypter())
+		}
+	}
+	c.subscriptionsOnce.Do(func() {
+		c.subscriptions = newSubscriptionTracker(c.Plugin)
+	})
+	refs, err := fn(c.subscriptions, dargs.MetricTypes)
+	if err != nil {
+		return err
+	}
+	*reply, err = c.Session.Encode(SubscribeMetricsReply{Refs: refs})
+	return err
+}
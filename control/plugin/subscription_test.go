@@ -0,0 +1,172 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	"github.com/intelsdi-x/snap/core"
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// subscribedPlugin records the subscriptions and collections it sees
+type subscribedPlugin struct {
+	mockPlugin
+	events []string
+}
+
+func (p *subscribedPlugin) OnSubscribe(ns core.Namespace, config *cdata.ConfigDataNode) error {
+	if ns.String() == "/foo/broken" {
+		return errors.New("backend unreachable")
+	}
+	event := "subscribe " + ns.String()
+	if config != nil {
+		if v, ok := config.Table()["target"]; ok {
+			event += " " + v.(ctypes.ConfigValueStr).Value
+		}
+	}
+	p.events = append(p.events, event)
+	return nil
+}
+
+func (p *subscribedPlugin) OnUnsubscribe(ns core.Namespace) error {
+	p.events = append(p.events, "unsubscribe "+ns.String())
+	return nil
+}
+
+func (p *subscribedPlugin) CollectMetrics(mts []MetricType) ([]MetricType, error) {
+	for _, mt := range mts {
+		p.events = append(p.events, "collect "+mt.Namespace().String())
+	}
+	return mts, nil
+}
+
+func TestSubscriptions(t *testing.T) {
+	bar := MetricType{Namespace_: core.NewNamespace("foo", "bar")}
+	baz := MetricType{Namespace_: core.NewNamespace("foo", "baz")}
+	broken := MetricType{Namespace_: core.NewNamespace("foo", "broken")}
+	bar.Config_ = cdata.NewNode()
+	bar.Config_.AddItem("target", ctypes.ConfigValueStr{Value: "db1"})
+
+	start := func(p Plugin) (Response, func(string, ...MetricType) (map[string]int, error), func()) {
+		m := NewPluginMeta("subscriptions", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		resp, done := startTestPlugin(m, p, fmt.Sprintf(`{"KillDelay": 1, "PingTimeoutDuration": %d}`, time.Minute))
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		enc := encoding.NewGobEncoder()
+		call := func(method string, mts ...MetricType) (map[string]int, error) {
+			var args []byte
+			var err error
+			if method == "Collector.CollectMetrics" {
+				args, err = enc.Encode(CollectMetricsArgs{MetricTypes: mts, Token: resp.Token})
+			} else {
+				args, err = enc.Encode(SubscribeMetricsArgs{MetricTypes: mts, Token: resp.Token})
+			}
+			So(err, ShouldBeNil)
+			var reply []byte
+			if err := client.Call(method, args, &reply); err != nil {
+				return nil, err
+			}
+			var r SubscribeMetricsReply
+			if method != "Collector.CollectMetrics" {
+				So(enc.Decode(reply, &r), ShouldBeNil)
+			}
+			return r.Refs, nil
+		}
+		stop := func() {
+			So(callKill(client, resp.Token), ShouldBeNil)
+			client.Close()
+			<-done
+		}
+		return resp, call, stop
+	}
+
+	Convey("A collector handling subscriptions", t, func() {
+		p := &subscribedPlugin{}
+		resp, call, stop := start(p)
+		defer stop()
+		So(resp.HasCapability(CapabilitySubscriptions), ShouldBeTrue)
+
+		Convey("is told of the first subscription and the last unsubscription", func() {
+			refs, err := call("Collector.SubscribeMetrics", bar, baz)
+			So(err, ShouldBeNil)
+			So(refs, ShouldResemble, map[string]int{"/foo/bar": 1, "/foo/baz": 1})
+			refs, err = call("Collector.SubscribeMetrics", bar)
+			So(err, ShouldBeNil)
+			So(refs, ShouldResemble, map[string]int{"/foo/bar": 2})
+			_, err = call("Collector.CollectMetrics", bar, baz)
+			So(err, ShouldBeNil)
+			refs, err = call("Collector.UnsubscribeMetrics", bar)
+			So(err, ShouldBeNil)
+			So(refs, ShouldResemble, map[string]int{"/foo/bar": 1})
+			refs, err = call("Collector.UnsubscribeMetrics", bar, baz)
+			So(err, ShouldBeNil)
+			So(refs, ShouldResemble, map[string]int{"/foo/bar": 0, "/foo/baz": 0})
+
+			So(p.events, ShouldResemble, []string{
+				"subscribe /foo/bar db1",
+				"subscribe /foo/baz",
+				"collect /foo/bar",
+				"collect /foo/baz",
+				"unsubscribe /foo/bar",
+				"unsubscribe /foo/baz",
+			})
+		})
+		Convey("ignores a second unsubscription", func() {
+			_, err := call("Collector.SubscribeMetrics", baz)
+			So(err, ShouldBeNil)
+			_, err = call("Collector.UnsubscribeMetrics", baz)
+			So(err, ShouldBeNil)
+			refs, err := call("Collector.UnsubscribeMetrics", baz)
+			So(err, ShouldBeNil)
+			So(refs, ShouldResemble, map[string]int{"/foo/baz": 0})
+			So(p.events, ShouldResemble, []string{"subscribe /foo/baz", "unsubscribe /foo/baz"})
+		})
+		Convey("does not count a failed subscription", func() {
+			_, err := call("Collector.SubscribeMetrics", broken)
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeCallFailed)
+			So(err.Error(), ShouldContainSubstring, "backend unreachable")
+			refs, err := call("Collector.UnsubscribeMetrics", broken)
+			So(err, ShouldBeNil)
+			So(refs, ShouldResemble, map[string]int{"/foo/broken": 0})
+			So(p.events, ShouldBeEmpty)
+		})
+	})
+	Convey("A collector ignoring subscriptions", t, func() {
+		resp, call, stop := start(&mockPlugin{})
+		defer stop()
+		So(resp.HasCapability(CapabilitySubscriptions), ShouldBeFalse)
+		refs, err := call("Collector.SubscribeMetrics", bar, bar)
+		So(err, ShouldBeNil)
+		So(refs, ShouldResemble, map[string]int{"/foo/bar": 2})
+		refs, err = call("Collector.UnsubscribeMetrics", bar, bar, bar)
+		So(err, ShouldBeNil)
+		So(refs, ShouldResemble, map[string]int{"/foo/bar": 0})
+	})
+}
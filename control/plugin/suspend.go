@@ -0,0 +1,153 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"sync"
+	"time"
+)
+
+// ErrSuspended is returned by the collect, publish and process calls of a
+// suspended session.
+var ErrSuspended error = &PluginError{Code: ErrorCodeSuspended, Message: "plugin is suspended"}
+
+// SuspendArgs are the arguments of Suspend.  Like those of Resume, they
+// must be signed when the plugin was started with a ControlPubKey.
+type SuspendArgs struct {
+	// Reason is logged by the plugin
+	Reason string
+	Token  string
+	RequestSignature
+}
+
+// ResumeArgs are the arguments of Resume
+type ResumeArgs struct {
+	Token string
+	RequestSignature
+}
+
+// SuspendReply is the reply of Suspend and Resume
+type SuspendReply struct {
+	// Changed is false when the session already was in the requested state
+	Changed bool
+}
+
+// suspension tells whether the session is suspended.  A suspended session
+// keeps answering pings, so that control does not kill it, but fails the
+// calls which reach the plugin's backend.
+type suspension struct {
+	mutex     sync.Mutex
+	suspended bool
+	since     time.Time
+}
+
+// check returns ErrSuspended while the session is suspended.
+func (s *suspension) check() error {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.suspended {
+		return ErrSuspended
+	}
+	return nil
+}
+
+// set suspends or resumes the session and reports whether that changed its
+// state.
+func (s *suspension) set(suspended bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.suspended == suspended {
+		return false
+	}
+	s.suspended = suspended
+	s.since = time.Time{}
+	if suspended {
+		s.since = time.Now()
+	}
+	return true
+}
+
+// state returns whether the session is suspended, and since when.
+func (s *suspension) state() (bool, time.Time) {
+	if s == nil {
+		return false, time.Time{}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.suspended, s.since
+}
+
+// Suspend makes the session fail collect, publish and process calls with
+// ErrSuspended until Resume is called.  Suspending a suspended session
+// does nothing.
+func (s *SessionState) Suspend(args []byte, reply *[]byte) error {
+	a := &SuspendArgs{}
+	if err := s.Decode(args, a); err != nil {
+		return err
+	}
+	if err := s.CheckToken(a.Token); err != nil {
+		return err
+	}
+	if err := s.VerifyRequest(a); err != nil {
+		s.Logger().Errorf("Suspend rejected: %v", err)
+		return err
+	}
+	s.ResetHeartbeat()
+	r := SuspendReply{Changed: s.suspension.set(true)}
+	if r.Changed {
+		s.Logger().Infof("Session suspended: %s", a.Reason)
+	}
+	out, err := s.Encode(r)
+	if err != nil {
+		return err
+	}
+	*reply = out
+	return nil
+}
+
+// Resume ends a suspension.  Resuming a session which is not suspended does
+// nothing.
+func (s *SessionState) Resume(args []byte, reply *[]byte) error {
+	a := &ResumeArgs{}
+	if err := s.Decode(args, a); err != nil {
+		return err
+	}
+	if err := s.CheckToken(a.Token); err != nil {
+		return err
+	}
+	if err := s.VerifyRequest(a); err != nil {
+		s.Logger().Errorf("Resume rejected: %v", err)
+		return err
+	}
+	s.ResetHeartbeat()
+	r := SuspendReply{Changed: s.suspension.set(false)}
+	if r.Changed {
+		s.Logger().Infof("Session resumed")
+	}
+	out, err := s.Encode(r)
+	if err != nil {
+		return err
+	}
+	*reply = out
+	return nil
+}
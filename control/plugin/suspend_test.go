@@ -0,0 +1,193 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/rpc"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// tallyCollector counts the collections reaching it
+type tallyCollector struct {
+	mockPlugin
+	collections int
+}
+
+func (c *tallyCollector) CollectMetrics(mts []MetricType) ([]MetricType, error) {
+	c.collections++
+	return c.mockPlugin.CollectMetrics(mts)
+}
+
+func TestSuspend(t *testing.T) {
+	Convey("A suspended collector", t, func() {
+		p := &tallyCollector{}
+		m := NewPluginMeta("suspend", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		resp, done := startTestPlugin(m, p, fmt.Sprintf(`{"KillDelay": 1, "PingTimeoutDuration": %d}`, time.Minute))
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer func() {
+			So(callKill(client, resp.Token), ShouldBeNil)
+			client.Close()
+			<-done
+		}()
+		enc := encoding.NewGobEncoder()
+		call := func(method string, args, reply interface{}) error {
+			in, err := enc.Encode(args)
+			So(err, ShouldBeNil)
+			var out []byte
+			if err := client.Call(method, in, &out); err != nil {
+				return err
+			}
+			if reply == nil {
+				return nil
+			}
+			return enc.Decode(out, reply)
+		}
+		collect := func() error {
+			return call("Collector.CollectMetrics", CollectMetricsArgs{MetricTypes: mockMetricType, Token: resp.Token}, nil)
+		}
+		suspend := func() bool {
+			var r SuspendReply
+			So(call("SessionState.Suspend", SuspendArgs{Reason: "maintenance", Token: resp.Token}, &r), ShouldBeNil)
+			return r.Changed
+		}
+		resume := func() bool {
+			var r SuspendReply
+			So(call("SessionState.Resume", ResumeArgs{Token: resp.Token}, &r), ShouldBeNil)
+			return r.Changed
+		}
+		ping := func() PingReply {
+			var r PingReply
+			So(call("SessionState.PingStatus", PingArgs{Token: resp.Token}, &r), ShouldBeNil)
+			return r
+		}
+		stats := func() SessionStats {
+			var st SessionStats
+			So(call("SessionState.GetStats", StatsArgs{Token: resp.Token}, &st), ShouldBeNil)
+			return st
+		}
+
+		So(collect(), ShouldBeNil)
+		So(suspend(), ShouldBeTrue)
+		So(suspend(), ShouldBeFalse)
+
+		Convey("fails collections without calling the plugin", func() {
+			err := collect()
+			So(ErrorCodeOf(err), ShouldEqual, ErrorCodeSuspended)
+			So(err.Error(), ShouldEqual, ErrSuspended.Error())
+			So(p.collections, ShouldEqual, 1)
+		})
+		Convey("keeps answering pings", func() {
+			So(call("SessionState.Ping", PingArgs{Token: resp.Token}, nil), ShouldBeNil)
+			So(ping().State, ShouldEqual, HealthSuspended)
+			st := stats()
+			So(st.Suspended, ShouldBeTrue)
+			So(st.SuspendedSince.IsZero(), ShouldBeFalse)
+		})
+		Convey("collects again once resumed", func() {
+			So(resume(), ShouldBeTrue)
+			So(resume(), ShouldBeFalse)
+			So(collect(), ShouldBeNil)
+			So(p.collections, ShouldEqual, 2)
+			So(ping().State, ShouldEqual, HealthOK)
+			st := stats()
+			So(st.Suspended, ShouldBeFalse)
+			So(st.SuspendedSince.IsZero(), ShouldBeTrue)
+		})
+	})
+	Convey("A suspended publisher and processor fail fast", t, func() {
+		s := &suspension{}
+		s.set(true)
+		mockSessionState := &MockSessionState{
+			Encoder:  encoding.NewGobEncoder(),
+			token:    "abcdef",
+			logger:   log.New(),
+			killChan: make(chan int),
+		}
+		args, err := mockSessionState.Encode(PublishArgs{ContentType: SnapGOBContentType, Token: "abcdef"})
+		So(err, ShouldBeNil)
+		pub := &publisherPluginProxy{Plugin: &MockPublisher{}, Session: mockSessionState, suspension: s}
+		So(pub.Publish(args, &[]byte{}), ShouldEqual, ErrSuspended)
+		args, err = mockSessionState.Encode(ProcessorArgs{ContentType: SnapGOBContentType, Token: "abcdef"})
+		So(err, ShouldBeNil)
+		proc := &processorPluginProxy{Plugin: &MockProcessor{}, Session: mockSessionState, suspension: s}
+		So(proc.Process(args, &[]byte{}), ShouldEqual, ErrSuspended)
+	})
+}
+
+func TestSuspendSigned(t *testing.T) {
+	Convey("Suspend and Resume with a ControlPubKey", t, func() {
+		controlKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		So(err, ShouldBeNil)
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		So(err, ShouldBeNil)
+		m := NewPluginMeta("suspend", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		arg, err := json.Marshal(Arg{ControlPubKey: &controlKey.PublicKey})
+		So(err, ShouldBeNil)
+		ss, err, _ := NewSessionState(string(arg), &tallyCollector{}, m)
+		So(err, ShouldBeNil)
+		call := func(method func([]byte, *[]byte) error, args SignedArgs) error {
+			in, err := ss.Encode(args)
+			So(err, ShouldBeNil)
+			return method(in, &[]byte{})
+		}
+		suspended := func() bool {
+			s, _ := ss.suspension.state()
+			return s
+		}
+
+		Convey("refuse unsigned calls", func() {
+			So(call(ss.Suspend, &SuspendArgs{Reason: "maintenance", Token: ss.Token()}), ShouldEqual, ErrRequestUnsigned)
+			So(suspended(), ShouldBeFalse)
+		})
+		Convey("refuse calls signed by another key", func() {
+			args := &SuspendArgs{Reason: "maintenance", Token: ss.Token()}
+			So(SignRequest(args, otherKey), ShouldBeNil)
+			So(call(ss.Suspend, args), ShouldEqual, ErrRequestSignature)
+			So(suspended(), ShouldBeFalse)
+		})
+		Convey("honor calls signed by control", func() {
+			args := &SuspendArgs{Reason: "maintenance", Token: ss.Token()}
+			So(SignRequest(args, controlKey), ShouldBeNil)
+			So(call(ss.Suspend, args), ShouldBeNil)
+			So(suspended(), ShouldBeTrue)
+
+			resume := &ResumeArgs{Token: ss.Token()}
+			So(call(ss.Resume, resume), ShouldEqual, ErrRequestUnsigned)
+			So(SignRequest(resume, otherKey), ShouldBeNil)
+			So(call(ss.Resume, resume), ShouldEqual, ErrRequestSignature)
+			So(suspended(), ShouldBeTrue)
+			So(SignRequest(resume, controlKey), ShouldBeNil)
+			So(call(ss.Resume, resume), ShouldBeNil)
+			So(suspended(), ShouldBeFalse)
+		})
+	})
+}
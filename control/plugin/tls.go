@@ -0,0 +1,102 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+var (
+	// ErrTLSKeyPair is returned when only one of CertPath and KeyPath is set
+	ErrTLSKeyPair = errors.New("both CertPath and KeyPath must be set to enable TLS")
+	// ErrTLSNoCert is returned when CAPath is set without a certificate
+	ErrTLSNoCert = errors.New("CAPath requires CertPath and KeyPath")
+)
+
+// ServerTLSConfig returns the TLS configuration for the plugin listener
+// described by the certificate paths in Arg, or nil if TLS is not enabled.
+// When CAPath is set clients must present a certificate signed by that CA.
+func ServerTLSConfig(a *Arg) (*tls.Config, error) {
+	if a.CertPath == "" && a.KeyPath == "" {
+		if a.CAPath != "" {
+			return nil, ErrTLSNoCert
+		}
+		return nil, nil
+	}
+	if a.CertPath == "" || a.KeyPath == "" {
+		return nil, ErrTLSKeyPair
+	}
+	cert, err := tls.LoadX509KeyPair(a.CertPath, a.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if a.CAPath != "" {
+		pool, err := loadCertPool(a.CAPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// ClientTLSConfig returns the TLS configuration control uses to connect to a
+// plugin whose certificate is signed by the CA in caPath.  certPath and
+// keyPath are optional and provide the client certificate when the plugin
+// requires one.
+func ClientTLSConfig(caPath, certPath, keyPath string) (*tls.Config, error) {
+	pool, err := loadCertPool(caPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+	if certPath != "" || keyPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,217 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin/encoding"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testCert struct {
+	cert     *x509.Certificate
+	key      *rsa.PrivateKey
+	certPath string
+	keyPath  string
+}
+
+// newTestCert creates a certificate for 127.0.0.1 signed by parent, or a
+// self-signed CA when parent is nil, and writes it to dir as PEM.
+func newTestCert(dir, name string, parent *testCert) *testCert {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	So(err, ShouldBeNil)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	So(err, ShouldBeNil)
+	cert, err := x509.ParseCertificate(der)
+	So(err, ShouldBeNil)
+
+	tc := &testCert{
+		cert:     cert,
+		key:      key,
+		certPath: filepath.Join(dir, name+".crt"),
+		keyPath:  filepath.Join(dir, name+".key"),
+	}
+	err = ioutil.WriteFile(tc.certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	So(err, ShouldBeNil)
+	err = ioutil.WriteFile(tc.keyPath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600)
+	So(err, ShouldBeNil)
+	return tc
+}
+
+// startTestCollector starts a collector with the provided Arg JSON and returns
+// its Response along with a channel receiving Start's exit code.
+func startTestCollector(args string) (Response, chan int) {
+	m := NewPluginMeta("mock", 1, CollectorPluginType, []string{SnapGOBContentType}, []string{SnapGOBContentType}, Unsecure(true))
+	return startTestPlugin(m, new(MockPlugin), args)
+}
+
+func startTestPlugin(m *PluginMeta, p Plugin, args string) (Response, chan int) {
+	pr, pw := io.Pipe()
+	responseWriter = pw
+	done := make(chan int, 1)
+	go func() {
+		err, rc := Start(m, p, args)
+		if err != nil {
+			pw.CloseWithError(err)
+		}
+		done <- rc
+	}()
+	line, err := bufio.NewReader(pr).ReadString('\n')
+	responseWriter = os.Stdout
+	So(err, ShouldBeNil)
+	var resp Response
+	So(json.Unmarshal([]byte(line), &resp), ShouldBeNil)
+	return resp, done
+}
+
+func callPing(client *rpc.Client, token string) error {
+	in, err := encoding.NewGobEncoder().Encode(PingArgs{Token: token})
+	So(err, ShouldBeNil)
+	var reply []byte
+	return client.Call("SessionState.Ping", in, &reply)
+}
+
+func callKill(client *rpc.Client, token string) error {
+	in, err := encoding.NewGobEncoder().Encode(KillArgs{Reason: "test", Token: token})
+	So(err, ShouldBeNil)
+	var reply []byte
+	return client.Call("SessionState.Kill", in, &reply)
+}
+
+func TestTLSListener(t *testing.T) {
+	Convey("A plugin listening with TLS", t, func() {
+		dir, err := ioutil.TempDir("", "snap-plugin-tls")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		ca := newTestCert(dir, "ca", nil)
+		server := newTestCert(dir, "server", ca)
+		otherCA := newTestCert(dir, "other-ca", nil)
+
+		Convey("accepts clients trusting its CA", func() {
+			args := fmt.Sprintf(`{"CertPath": %q, "KeyPath": %q, "PingTimeoutDuration": %d}`, server.certPath, server.keyPath, time.Minute)
+			resp, done := startTestCollector(args)
+			So(resp.HasCapability(CapabilityTLS), ShouldBeTrue)
+
+			cfg, err := ClientTLSConfig(ca.certPath, "", "")
+			So(err, ShouldBeNil)
+			conn, err := tls.Dial("tcp", resp.ListenAddress, cfg)
+			So(err, ShouldBeNil)
+			client := rpc.NewClient(conn)
+			defer client.Close()
+			So(callPing(client, resp.Token), ShouldBeNil)
+
+			Convey("and rejects clients trusting another CA", func() {
+				cfg, err := ClientTLSConfig(otherCA.certPath, "", "")
+				So(err, ShouldBeNil)
+				_, err = tls.Dial("tcp", resp.ListenAddress, cfg)
+				So(err, ShouldNotBeNil)
+			})
+
+			So(callKill(client, resp.Token), ShouldBeNil)
+			So(<-done, ShouldEqual, 0)
+		})
+		Convey("with a CA requires a client certificate", func() {
+			args := fmt.Sprintf(`{"CertPath": %q, "KeyPath": %q, "CAPath": %q, "PingTimeoutDuration": %d}`, server.certPath, server.keyPath, ca.certPath, time.Minute)
+			resp, done := startTestCollector(args)
+			So(resp.HasCapability(CapabilityTLS), ShouldBeTrue)
+
+			cfg, err := ClientTLSConfig(ca.certPath, "", "")
+			So(err, ShouldBeNil)
+			conn, err := tls.Dial("tcp", resp.ListenAddress, cfg)
+			if err == nil {
+				// With TLS 1.3 the client learns of the rejection on first use
+				anon := rpc.NewClient(conn)
+				So(callPing(anon, resp.Token), ShouldNotBeNil)
+				anon.Close()
+			}
+
+			clientCert := newTestCert(dir, "control", ca)
+			cfg, err = ClientTLSConfig(ca.certPath, clientCert.certPath, clientCert.keyPath)
+			So(err, ShouldBeNil)
+			conn, err = tls.Dial("tcp", resp.ListenAddress, cfg)
+			So(err, ShouldBeNil)
+			client := rpc.NewClient(conn)
+			defer client.Close()
+			So(callPing(client, resp.Token), ShouldBeNil)
+			So(callKill(client, resp.Token), ShouldBeNil)
+			So(<-done, ShouldEqual, 0)
+		})
+		Convey("is not used by default", func() {
+			resp, _ := startTestCollector(`{"NoDaemon": true}`)
+			So(resp.HasCapability(CapabilityTLS), ShouldBeFalse)
+		})
+	})
+	Convey("ServerTLSConfig", t, func() {
+		Convey("requires both a certificate and key", func() {
+			_, err := ServerTLSConfig(&Arg{CertPath: "cert.pem"})
+			So(err, ShouldEqual, ErrTLSKeyPair)
+		})
+		Convey("requires a certificate with a CA", func() {
+			_, err := ServerTLSConfig(&Arg{CAPath: "ca.pem"})
+			So(err, ShouldEqual, ErrTLSNoCert)
+		})
+		Convey("returns missing file errors", func() {
+			_, err := ServerTLSConfig(&Arg{CertPath: "/nonexistent/cert.pem", KeyPath: "/nonexistent/key.pem"})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("is nil without certificates", func() {
+			cfg, err := ServerTLSConfig(&Arg{})
+			So(err, ShouldBeNil)
+			So(cfg, ShouldBeNil)
+		})
+	})
+}
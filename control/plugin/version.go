@@ -0,0 +1,146 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// RPCVersion is the version of the RPC protocol spoken by this version of
+// snap.  Version 1 is spoken by controls and plugins which do not report a
+// version.
+const RPCVersion = 10
+
+// MinRPCVersion is the oldest control RPCVersion a plugin agrees to serve
+var MinRPCVersion = 1
+
+// rpcMethods maps the methods added after version 1 to the RPCVersion
+// which introduced them.
+var rpcMethods = map[string]int{
+	"SessionState.PingStatus":      2,
+	"SessionState.GetStats":        2,
+	"SessionState.GetChunk":        3,
+	"StreamCollector.Drain":        4,
+	"Collector.SubscribeMetrics":   5,
+	"Collector.UnsubscribeMetrics": 5,
+	"SessionState.SetConfig":       6,
+	"SessionState.Suspend":         7,
+	"SessionState.Resume":          7,
+	"Publisher.PublishStatus":      8,
+	"SessionState.SelfTest":        9,
+	"SessionState.AckTransfer":     10,
+}
+
+// SupportsMethod reports whether a peer speaking RPC version serves method.
+// A version of 0, from a peer which predates versioning, is version 1.
+func SupportsMethod(version int, method string) bool {
+	return normalizeRPCVersion(version) >= rpcMethods[method]
+}
+
+func normalizeRPCVersion(version int) int {
+	if version == 0 {
+		return 1
+	}
+	return version
+}
+
+// FrameworkVersion is the version of this plugin framework.  Control passes
+// it to plugins in Arg.FrameworkVersion.
+const FrameworkVersion = "0.14.0"
+
+// semverPattern matches a version such as "1.2.3", "v1.2" or "1.2.3-beta".
+// Pre-release and build suffixes are ignored.
+var semverPattern = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:[-+][0-9A-Za-z.-]*)?$`)
+
+// parseSemver returns the major, minor and patch numbers of v.  An empty v,
+// from a control which predates FrameworkVersion, is 0.0.0.
+func parseSemver(v string) ([3]int, error) {
+	var n [3]int
+	if v == "" {
+		return n, nil
+	}
+	m := semverPattern.FindStringSubmatch(v)
+	if m == nil {
+		return n, fmt.Errorf("invalid version %q", v)
+	}
+	for i, s := range m[1:] {
+		if s == "" {
+			continue
+		}
+		x, err := strconv.Atoi(s)
+		if err != nil {
+			return n, fmt.Errorf("invalid version %q", v)
+		}
+		n[i] = x
+	}
+	return n, nil
+}
+
+func compareSemver(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// checkFrameworkVersion fails when control runs a framework older than the
+// plugin's MinFrameworkVersion.
+func checkFrameworkVersion(control, min string) error {
+	if min == "" {
+		return nil
+	}
+	minv, err := parseSemver(min)
+	if err != nil {
+		return &PluginError{Code: ErrorCodeConfigInvalid, Message: fmt.Sprintf("minimum framework version: %s", err)}
+	}
+	cv, err := parseSemver(control)
+	if err != nil {
+		return &PluginError{Code: ErrorCodeConfigInvalid, Message: fmt.Sprintf("framework version: %s", err)}
+	}
+	if compareSemver(cv, minv) < 0 {
+		if control == "" {
+			control = "an unversioned framework"
+		}
+		return &PluginError{
+			Code:    ErrorCodeUnsupported,
+			Message: fmt.Sprintf("the plugin requires framework version %s or later, control runs %s", min, control),
+		}
+	}
+	return nil
+}
+
+// checkRPCVersion fails when control speaks a version older than
+// MinRPCVersion.
+func checkRPCVersion(version int) error {
+	if v := normalizeRPCVersion(version); v < MinRPCVersion {
+		return &PluginError{
+			Code:    ErrorCodeUnsupported,
+			Message: fmt.Sprintf("control speaks RPC version %d, the plugin requires at least version %d", v, MinRPCVersion),
+		}
+	}
+	return nil
+}
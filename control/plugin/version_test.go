@@ -0,0 +1,140 @@
+// +build legacy
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"net/rpc"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSupportsMethod(t *testing.T) {
+	Convey("SupportsMethod", t, func() {
+		for _, version := range []int{0, 1, RPCVersion} {
+			So(SupportsMethod(version, "SessionState.Ping"), ShouldBeTrue)
+			So(SupportsMethod(version, "Collector.CollectMetrics"), ShouldBeTrue)
+		}
+		So(SupportsMethod(0, "SessionState.GetStats"), ShouldBeFalse)
+		So(SupportsMethod(1, "SessionState.PingStatus"), ShouldBeFalse)
+		So(SupportsMethod(2, "SessionState.GetStats"), ShouldBeTrue)
+		So(SupportsMethod(RPCVersion+1, "SessionState.GetStats"), ShouldBeTrue)
+	})
+}
+
+func TestRPCVersion(t *testing.T) {
+	Convey("A plugin started by an older control", t, func() {
+		defer func(v int) { MinRPCVersion = v }(MinRPCVersion)
+		MinRPCVersion = RPCVersion + 1
+
+		for _, args := range []string{fmt.Sprintf(`{"RPCVersion": %d}`, RPCVersion), `{}`} {
+			resp, done := startTestCollector(args)
+			So(<-done, ShouldEqual, 2)
+			So(resp.State, ShouldEqual, PluginFailure)
+			So(resp.ErrorCode, ShouldEqual, ErrorCodeUnsupported)
+			So(resp.ErrorMessage, ShouldContainSubstring, fmt.Sprintf("requires at least version %d", RPCVersion+1))
+			So(resp.RPCVersion, ShouldEqual, RPCVersion)
+		}
+	})
+	Convey("A plugin started by a newer control", t, func() {
+		resp, done := startTestCollector(fmt.Sprintf(`{"RPCVersion": %d, "PingTimeoutDuration": %d}`, RPCVersion+1, time.Minute))
+		So(resp.State, ShouldEqual, PluginSuccess)
+		So(resp.RPCVersion, ShouldEqual, RPCVersion)
+		client, err := rpc.Dial("tcp", resp.ListenAddress)
+		So(err, ShouldBeNil)
+		defer client.Close()
+		So(callPing(client, resp.Token), ShouldBeNil)
+		So(callKill(client, resp.Token), ShouldBeNil)
+		<-done
+	})
+	Convey("NewArg speaks the current version", t, func() {
+		So(NewArg(0).RPCVersion, ShouldEqual, RPCVersion)
+	})
+}
+
+func TestFrameworkVersion(t *testing.T) {
+	Convey("parseSemver", t, func() {
+		for v, want := range map[string][3]int{
+			"":           {0, 0, 0},
+			"1.2.3":      {1, 2, 3},
+			"v0.14.0":    {0, 14, 0},
+			"2":          {2, 0, 0},
+			"1.5":        {1, 5, 0},
+			"1.2.3-beta": {1, 2, 3},
+			"1.2.3+abc":  {1, 2, 3},
+		} {
+			n, err := parseSemver(v)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, want)
+		}
+		for _, v := range []string{"latest", "1.x", "1.2.3.4", " 1.2.3"} {
+			_, err := parseSemver(v)
+			So(err, ShouldNotBeNil)
+		}
+	})
+	Convey("A plugin requiring framework version 0.14.0", t, func() {
+		m := NewPluginMeta("framework", 1, CollectorPluginType, nil, nil, Unsecure(true), MinFrameworkVersion("0.14.0"))
+		Convey("starts under a control running it", func() {
+			for _, v := range []string{"0.14.0", "0.14.1", "1.0.0-rc1", FrameworkVersion} {
+				resp, done := startTestPlugin(m, new(MockPlugin), fmt.Sprintf(`{"FrameworkVersion": %q, "PingTimeoutDuration": %d}`, v, time.Millisecond))
+				So(resp.State, ShouldEqual, PluginSuccess)
+				So(resp.Meta.MinFrameworkVersion, ShouldEqual, "0.14.0")
+				<-done
+			}
+		})
+		Convey("fails under an older control", func() {
+			resp, done := startTestPlugin(m, new(MockPlugin), `{"FrameworkVersion": "0.13.2"}`)
+			So(<-done, ShouldEqual, 2)
+			So(resp.State, ShouldEqual, PluginFailure)
+			So(resp.ErrorCode, ShouldEqual, ErrorCodeUnsupported)
+			So(resp.ErrorMessage, ShouldEqual, "the plugin requires framework version 0.14.0 or later, control runs 0.13.2")
+		})
+		Convey("fails under a control which does not send its version", func() {
+			resp, done := startTestPlugin(m, new(MockPlugin), `{}`)
+			So(<-done, ShouldEqual, 2)
+			So(resp.ErrorCode, ShouldEqual, ErrorCodeUnsupported)
+			So(resp.ErrorMessage, ShouldEqual, "the plugin requires framework version 0.14.0 or later, control runs an unversioned framework")
+		})
+		Convey("fails when control sends a malformed version", func() {
+			resp, done := startTestPlugin(m, new(MockPlugin), `{"FrameworkVersion": "next"}`)
+			So(<-done, ShouldEqual, 2)
+			So(resp.ErrorCode, ShouldEqual, ErrorCodeConfigInvalid)
+		})
+	})
+	Convey("A plugin without a minimum framework version starts under any control", t, func() {
+		m := NewPluginMeta("framework", 1, CollectorPluginType, nil, nil, Unsecure(true))
+		resp, done := startTestPlugin(m, new(MockPlugin), fmt.Sprintf(`{"PingTimeoutDuration": %d}`, time.Millisecond))
+		So(resp.State, ShouldEqual, PluginSuccess)
+		<-done
+	})
+	Convey("A malformed minimum framework version", t, func() {
+		_, err := NewValidPluginMeta("framework", 1, CollectorPluginType, MinFrameworkVersion("soon"))
+		So(err, ShouldResemble, &PluginError{Code: ErrorCodeConfigInvalid, Message: `invalid version "soon"`})
+		m := &PluginMeta{Name: "framework", Version: 1, Type: CollectorPluginType, MinFrameworkVersion: "soon"}
+		So(m.Validate(), ShouldResemble, &PluginError{Code: ErrorCodeConfigInvalid, Message: `minimum framework version: invalid version "soon"`})
+	})
+	Convey("NewArg passes the framework version", t, func() {
+		So(NewArg(0).FrameworkVersion, ShouldEqual, FrameworkVersion)
+	})
+}
@@ -482,11 +482,20 @@ func (p *pluginManager) LoadPlugin(details *pluginDetails, emitter gomit.Emitter
 		pmLogger.WithFields(log.Fields{
 			"_block":          "load-plugin",
 			"error":           e,
+			"error-code":      resp.FailureCode().String(),
 			"plugin response": resp.ErrorMessage,
 		}).Error("load plugin error")
 		return nil, serror.New(e)
 	}
 
+	if err := resp.Meta.ValidateRouting(); err != nil {
+		pmLogger.WithFields(log.Fields{
+			"_block": "load-plugin",
+			"error":  err.Error(),
+		}).Error("load plugin error")
+		return nil, serror.New(err)
+	}
+
 	lPlugin.Meta = resp.Meta
 	lPlugin.Type = resp.Type
 	lPlugin.Token = resp.Token
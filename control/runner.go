@@ -167,8 +167,9 @@ func (r *runner) startPlugin(p executablePlugin) (*availablePlugin, error) {
 	if resp.State != plugin.PluginSuccess {
 		e := errors.New("plugin could not start error: " + resp.ErrorMessage)
 		runnerLog.WithFields(log.Fields{
-			"_block": "start-plugin",
-			"error":  e.Error(),
+			"_block":     "start-plugin",
+			"error":      e.Error(),
+			"error-code": resp.FailureCode().String(),
 		}).Error("error starting a plugin")
 		return nil, e
 	}
@@ -54,6 +54,7 @@ type MockAvailablePlugin struct {
 	concount   int
 	exclusive  bool
 	strategy   plugin.RoutingStrategyType
+	keys       []string
 	pluginType plugin.PluginType
 	version    int
 }
@@ -109,6 +110,11 @@ func (m *MockAvailablePlugin) WithStrategy(strategy plugin.RoutingStrategyType)
 	return m
 }
 
+func (m *MockAvailablePlugin) WithRoutingKeys(keys ...string) *MockAvailablePlugin {
+	m.keys = keys
+	return m
+}
+
 func (m *MockAvailablePlugin) WithPluginType(plgType plugin.PluginType) *MockAvailablePlugin {
 	m.pluginType = plgType
 	return m
@@ -166,6 +172,10 @@ func (m MockAvailablePlugin) RoutingStrategy() plugin.RoutingStrategyType {
 	return m.strategy
 }
 
+func (m MockAvailablePlugin) RoutingKeys() []string {
+	return m.keys
+}
+
 func (m MockAvailablePlugin) SetID(id uint32) {
 	m.id = id
 }
@@ -21,9 +21,9 @@ package strategy
 
 import (
 	"bytes"
-	"encoding/gob"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -79,6 +79,7 @@ type AvailablePlugin interface {
 	Exclusive() bool
 	Kill(r string) error
 	RoutingStrategy() plugin.RoutingStrategyType
+	RoutingKeys() []string
 	SetID(id uint32)
 	String() string
 	Type() plugin.PluginType
@@ -120,6 +121,9 @@ type pool struct {
 	// strategy RoutingAndCaching
 	RoutingAndCaching
 
+	// The config keys selecting the plugin with the config-based strategy
+	routingKeys []string
+
 	// restartCount the restart count of available plugins
 	// when the DeadAvailablePluginEvent occurs
 	restartCount int
@@ -223,6 +227,7 @@ func (p *pool) applyPluginMeta(a AvailablePlugin) error {
 		p.concurrencyCount = 1
 	case plugin.ConfigRouting:
 		p.RoutingAndCaching = NewConfigBased(cacheTTL)
+		p.routingKeys = a.RoutingKeys()
 	default:
 		return ErrBadStrategy
 	}
@@ -374,7 +379,7 @@ func (p *pool) SelectAP(taskID string, config map[string]ctypes.ConfigValue) (Av
 	case "sticky":
 		id = taskID
 	case "config-based":
-		id = idFromCfg(config)
+		id = idFromCfg(config, p.routingKeys)
 	default:
 		return nil, serror.New(ErrBadStrategy)
 	}
@@ -386,15 +391,26 @@ func (p *pool) SelectAP(taskID string, config map[string]ctypes.ConfigValue) (Av
 	return ap, nil
 }
 
-func idFromCfg(cfg map[string]ctypes.ConfigValue) string {
-	//TODO: check for nil map
+// idFromCfg returns the id of the config, or of the values of keys in it
+// when keys are given.  Secure strings print redacted, so they are told
+// apart by their digest.
+func idFromCfg(cfg map[string]ctypes.ConfigValue, keys []string) string {
+	if len(keys) == 0 {
+		keys = make([]string, 0, len(cfg))
+		for k := range cfg {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
 	var buff bytes.Buffer
-	enc := gob.NewEncoder(&buff)
-	err := enc.Encode(cfg)
-	if err != nil {
-		return ""
+	for _, k := range keys {
+		if s, ok := cfg[k].(ctypes.ConfigValueSecureString); ok {
+			fmt.Fprintf(&buff, "%s=%T{%s};", k, s, s.Digest())
+			continue
+		}
+		fmt.Fprintf(&buff, "%s=%#v;", k, cfg[k])
 	}
-	return string(buff.Bytes())
+	return buff.String()
 }
 
 // generatePID returns the next available pid for the pool
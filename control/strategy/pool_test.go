@@ -263,6 +263,54 @@ func TestPoolSelectAPConfigRouter(t *testing.T) {
 				So(err, ShouldBeNil)
 			})
 		})
+
+		Convey("When plugin is defined with config based strategy and routing keys", func() {
+			plugin := NewMockAvailablePlugin().WithStrategy(plugin.ConfigRouting).WithRoutingKeys("foo")
+			pool, _ := NewPool(plugin.String(), plugin)
+
+			Convey("Then only the routing keys select the plugin", func() {
+				ap, err := pool.SelectAP("TaskID", cfg)
+				So(ap, ShouldEqual, plugin)
+				So(err, ShouldBeNil)
+
+				withUser := map[string]ctypes.ConfigValue{"foo": ctypes.ConfigValueStr{"bar"}, "user": ctypes.ConfigValueStr{"root"}}
+				ap, err = pool.SelectAP("AnotherTaskID", withUser)
+				So(ap, ShouldEqual, plugin)
+				So(err, ShouldBeNil)
+
+				ap, err = pool.SelectAP("YetAnotherTaskID", otherCfg)
+				So(ap, ShouldBeNil)
+				So(err, ShouldResemble, serror.New(ErrCouldNotSelect))
+			})
+		})
+	})
+}
+
+func TestIDFromCfg(t *testing.T) {
+	Convey("The id of a config", t, func() {
+		secret := func(s string) map[string]ctypes.ConfigValue {
+			return map[string]ctypes.ConfigValue{
+				"user":     ctypes.ConfigValueStr{"root"},
+				"password": ctypes.NewConfigValueSecureString(s),
+			}
+		}
+
+		Convey("does not depend on the order of the map", func() {
+			cfg := map[string]ctypes.ConfigValue{}
+			for i := 0; i < 20; i++ {
+				cfg[fmt.Sprintf("key%d", i)] = ctypes.ConfigValueInt{i}
+			}
+			id := idFromCfg(cfg, nil)
+			for i := 0; i < 10; i++ {
+				So(idFromCfg(cfg, nil), ShouldEqual, id)
+			}
+		})
+		Convey("tells secure strings apart without revealing them", func() {
+			So(idFromCfg(secret("a"), nil), ShouldEqual, idFromCfg(secret("a"), nil))
+			So(idFromCfg(secret("a"), nil), ShouldNotEqual, idFromCfg(secret("b"), nil))
+			So(idFromCfg(secret("a"), []string{"password"}), ShouldNotEqual, idFromCfg(secret("b"), []string{"password"}))
+			So(idFromCfg(secret("s3cr3t"), nil), ShouldNotContainSubstring, "s3cr3t")
+		})
 	})
 }
 
@@ -21,6 +21,7 @@ package cdata
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
@@ -75,6 +76,17 @@ func (c *ConfigDataNode) UnmarshalJSON(data []byte) error {
 			c.table[k] = ctypes.ConfigValueStr{Value: t}
 		case bool:
 			c.table[k] = ctypes.ConfigValueBool{Value: t}
+		case map[string]interface{}:
+			// sealed secure strings are the only supported object
+			ct, ok := t[ctypes.ConfigValueSecureString{}.Type()].(string)
+			if !ok {
+				return fmt.Errorf("Error Unmarshalling JSON ConfigDataNode. Key: %v Type: %v is unsupported.", k, t)
+			}
+			b, err := base64.StdEncoding.DecodeString(ct)
+			if err != nil {
+				return err
+			}
+			c.table[k] = ctypes.ConfigValueSecureString{Ciphertext: b}
 		case json.Number:
 			if v, err := t.Int64(); err == nil {
 				c.table[k] = ctypes.ConfigValueInt{Value: int(v)}
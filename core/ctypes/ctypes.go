@@ -19,7 +19,16 @@ limitations under the License.
 
 package ctypes
 
-import "encoding/json"
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+)
 
 // TODO constructors for each that have typing for value (and optionally validate)
 
@@ -75,6 +84,125 @@ func (c ConfigValueBool) MarshalJSON() ([]byte, error) {
 	return json.Marshal(c.Value)
 }
 
+// Redacted replaces the value of a ConfigValueSecureString in any output.
+const Redacted = "********"
+
+var (
+	// ErrSecureStringSealed is returned when revealing a secure string
+	// which has no decrypter attached.
+	ErrSecureStringSealed = errors.New("secure string can not be decrypted without the session key")
+)
+
+// Encrypter seals secure strings for a plugin session.
+type Encrypter interface {
+	Encrypt(io.Reader) ([]byte, error)
+}
+
+// Decrypter opens secure strings received by a plugin session.
+type Decrypter interface {
+	Decrypt(io.Reader) ([]byte, error)
+}
+
+// ConfigValueSecureString holds a secret such as a password.  Only the
+// Ciphertext is ever encoded; the plaintext is kept in an unexported field
+// on the sending side and decrypted on demand on the receiving side.  The
+// value is redacted when printed or marshaled to JSON.
+type ConfigValueSecureString struct {
+	Ciphertext []byte
+
+	plaintext *string
+	decrypter Decrypter
+}
+
+// NewConfigValueSecureString returns a secure string holding plaintext.
+func NewConfigValueSecureString(plaintext string) ConfigValueSecureString {
+	return ConfigValueSecureString{plaintext: &plaintext}
+}
+
+func (c ConfigValueSecureString) Type() string {
+	return "secure_string"
+}
+
+// Seal returns a copy of the value encrypted with e and without plaintext.
+// Values which are already sealed are returned unchanged.
+func (c ConfigValueSecureString) Seal(e Encrypter) (ConfigValueSecureString, error) {
+	if c.plaintext == nil {
+		return c, nil
+	}
+	ct, err := e.Encrypt(bytes.NewBufferString(*c.plaintext))
+	if err != nil {
+		return c, err
+	}
+	return ConfigValueSecureString{Ciphertext: ct}, nil
+}
+
+// Open returns a copy of the value which decrypts with d when revealed.
+func (c ConfigValueSecureString) Open(d Decrypter) ConfigValueSecureString {
+	c.decrypter = d
+	return c
+}
+
+// Reveal returns the plaintext, decrypting the value if necessary.
+func (c ConfigValueSecureString) Reveal() (string, error) {
+	if c.plaintext != nil {
+		return *c.plaintext, nil
+	}
+	if c.decrypter == nil {
+		return "", ErrSecureStringSealed
+	}
+	b, err := c.decrypter.Decrypt(bytes.NewReader(c.Ciphertext))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (c ConfigValueSecureString) String() string {
+	return Redacted
+}
+
+// digestKey keys the digests of secure strings, so that they cannot be
+// matched against the digests of guessed secrets
+var digestKey = func() []byte {
+	k := make([]byte, sha256.Size)
+	if _, err := rand.Read(k); err != nil {
+		panic(err)
+	}
+	return k
+}()
+
+// Digest identifies the secret without revealing it, e.g. to compare
+// configs or build a key from them, which the redacted String and GoString
+// cannot do.  Secure strings holding the same secret have the same digest
+// within the process, even when sealed with different ciphertexts.  A sealed
+// value which cannot be revealed is identified by its ciphertext.
+func (c ConfigValueSecureString) Digest() string {
+	mac := hmac.New(sha256.New, digestKey)
+	if s, err := c.Reveal(); err == nil {
+		mac.Write([]byte("plaintext:"))
+		mac.Write([]byte(s))
+	} else {
+		mac.Write([]byte("ciphertext:"))
+		mac.Write(c.Ciphertext)
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (c ConfigValueSecureString) GoString() string {
+	return "ctypes.ConfigValueSecureString{" + Redacted + "}"
+}
+
+// MarshalJSON redacts the plaintext.  A sealed value is marshaled as an
+// object holding the ciphertext so that it can be sent over JSON-RPC.
+func (c ConfigValueSecureString) MarshalJSON() ([]byte, error) {
+	if c.plaintext != nil || c.Ciphertext == nil {
+		return json.Marshal(Redacted)
+	}
+	return json.Marshal(map[string][]byte{
+		c.Type(): c.Ciphertext,
+	})
+}
+
 // Returns a slice of string keywords for the types supported by ConfigValue.
 func SupportedTypes() []string {
 	// This is kind of a hack but keeps the definition of types here in
@@ -90,6 +218,8 @@ func SupportedTypes() []string {
 		ConfigValueFloat{}.Type(),
 		// Bool
 		ConfigValueBool{}.Type(),
+		// Secure string
+		ConfigValueSecureString{}.Type(),
 	}
 	return t
 }
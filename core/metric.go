@@ -20,6 +20,8 @@ limitations under the License.
 package core
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 	"time"
 
@@ -47,11 +49,52 @@ type Metric interface {
 
 type Namespace []NamespaceElement
 
+// ErrEmptyNamespace is returned when parsing a string without any namespace
+// elements.
+var ErrEmptyNamespace = errors.New("namespace is empty")
+
+// ParseNamespace parses the string representation of a namespace.  The first
+// character of the string is used as the separator so both "/intel/mock/foo"
+// and "|intel|mock|foo" are valid.  Empty elements are rejected.
+func ParseNamespace(s string) (Namespace, error) {
+	if len(s) < 2 {
+		return nil, ErrEmptyNamespace
+	}
+	sep := s[:1]
+	ns := NewNamespace(strings.Split(s[1:], sep)...)
+	if err := ns.Validate(sep); err != nil {
+		return nil, err
+	}
+	return ns, nil
+}
+
+// Validate returns an error if the namespace is empty, contains an empty
+// element or an element containing the provided separator.
+func (n Namespace) Validate(sep string) error {
+	if len(n) == 0 {
+		return ErrEmptyNamespace
+	}
+	for i, e := range n {
+		if e.Value == "" {
+			return fmt.Errorf("namespace element %d is empty", i)
+		}
+		if strings.Contains(e.Value, sep) {
+			return fmt.Errorf("namespace element %d (%s) contains the separator %s", i, e.Value, sep)
+		}
+	}
+	return nil
+}
+
+// StringWithSeparator returns the string representation of the namespace
+// using the provided separator.  A leading separator is added.
+func (n Namespace) StringWithSeparator(sep string) string {
+	return sep + strings.Join(n.Strings(), sep)
+}
+
 // String returns the string representation of the namespace with "/" joining
 // the elements of the namespace.  A leading "/" is added.
 func (n Namespace) String() string {
-	ns := n.Strings()
-	return "/" + strings.Join(ns, "/")
+	return n.StringWithSeparator("/")
 }
 
 // Strings returns an array of strings that represent the elements of the
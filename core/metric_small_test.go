@@ -0,0 +1,91 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"math/rand"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseNamespace(t *testing.T) {
+	Convey("ParseNamespace", t, func() {
+		Convey("parses a namespace using the first character as separator", func() {
+			ns, err := ParseNamespace("/intel/mock/foo")
+			So(err, ShouldBeNil)
+			So(ns, ShouldResemble, NewNamespace("intel", "mock", "foo"))
+			ns, err = ParseNamespace("|intel|mock/bar|foo")
+			So(err, ShouldBeNil)
+			So(ns, ShouldResemble, NewNamespace("intel", "mock/bar", "foo"))
+		})
+		Convey("rejects empty namespaces", func() {
+			_, err := ParseNamespace("")
+			So(err, ShouldEqual, ErrEmptyNamespace)
+			_, err = ParseNamespace("/")
+			So(err, ShouldEqual, ErrEmptyNamespace)
+		})
+		Convey("rejects empty elements", func() {
+			_, err := ParseNamespace("/intel//foo")
+			So(err, ShouldNotBeNil)
+			_, err = ParseNamespace("/intel/mock/")
+			So(err, ShouldNotBeNil)
+		})
+	})
+	Convey("Namespace.Validate", t, func() {
+		So(NewNamespace("intel", "mock").Validate("/"), ShouldBeNil)
+		So(NewNamespace().Validate("/"), ShouldEqual, ErrEmptyNamespace)
+		So(NewNamespace("intel", "").Validate("/"), ShouldNotBeNil)
+		So(NewNamespace("intel", "mock/foo").Validate("/"), ShouldNotBeNil)
+		So(NewNamespace("intel", "mock/foo").Validate("|"), ShouldBeNil)
+	})
+	Convey("Namespace.StringWithSeparator", t, func() {
+		ns := NewNamespace("intel", "mock", "foo")
+		So(ns.StringWithSeparator("|"), ShouldEqual, "|intel|mock|foo")
+		So(ns.String(), ShouldEqual, "/intel/mock/foo")
+	})
+	Convey("format and parse round trip", t, func() {
+		r := rand.New(rand.NewSource(42))
+		alphabet := []rune("abcdefghijklmnopqrstuvwxyz0123456789_-.*/|")
+		seps := []string{"/", "|", "%", ":"}
+		for i := 0; i < 1000; i++ {
+			sep := seps[r.Intn(len(seps))]
+			elems := make([]string, r.Intn(6)+1)
+			for j := range elems {
+				e := make([]rune, r.Intn(8)+1)
+				for k := range e {
+					e[k] = alphabet[r.Intn(len(alphabet))]
+				}
+				elems[j] = string(e)
+			}
+			ns := NewNamespace(elems...)
+			parsed, err := ParseNamespace(ns.StringWithSeparator(sep))
+			if ns.Validate(sep) != nil {
+				// an element containing the separator can't be recovered
+				So(parsed, ShouldNotResemble, ns)
+				continue
+			}
+			So(err, ShouldBeNil)
+			So(parsed, ShouldResemble, ns)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mock
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin"
+	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
+	"github.com/intelsdi-x/snap/core"
+)
+
+const (
+	// Name of plugin
+	Name = "mock-stream"
+	// Version of plugin
+	Version = 1
+	// Type of plugin
+	Type = plugin.StreamCollectorPluginType
+)
+
+// Mock streams bursts of random events, used for testing
+type Mock struct {
+	// Interval is the mean time between two bursts
+	Interval time.Duration
+	// Burst is the largest number of events in a burst
+	Burst int
+}
+
+// New returns a Mock sending up to 10 events every 100ms on average
+func New() *Mock {
+	return &Mock{Interval: 100 * time.Millisecond, Burst: 10}
+}
+
+// StreamMetrics sends bursts of events until stop is closed
+func (m *Mock) StreamMetrics(send chan<- []plugin.MetricType, errs chan<- error, stop <-chan struct{}) error {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for {
+		wait := time.Duration(r.Int63n(int64(2 * m.Interval)))
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(wait):
+		}
+
+		n := r.Intn(m.Burst) + 1
+		mts := make([]plugin.MetricType, n)
+		for i := range mts {
+			mts[i] = plugin.MetricType{
+				Namespace_: core.NewNamespace("intel", "mock", "stream", "event"),
+				Data_:      r.Intn(1000),
+				Timestamp_: time.Now(),
+				Unit_:      "event",
+				Version_:   Version,
+			}
+		}
+		select {
+		case send <- mts:
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// GetMetricTypes returns the streamed metric types
+func (m *Mock) GetMetricTypes(cfg plugin.ConfigType) ([]plugin.MetricType, error) {
+	return []plugin.MetricType{{
+		Namespace_:   core.NewNamespace("intel", "mock", "stream", "event"),
+		Description_: "mock event",
+		Unit_:        "event",
+	}}, nil
+}
+
+// GetConfigPolicy returns an empty ConfigPolicy
+func (m *Mock) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
+	return cpolicy.New(), nil
+}
+
+// Meta returns the plugin meta data
+func Meta() *plugin.PluginMeta {
+	return plugin.NewPluginMeta(
+		Name,
+		Version,
+		Type,
+		[]string{plugin.SnapGOBContentType},
+		[]string{plugin.SnapGOBContentType},
+	)
+}
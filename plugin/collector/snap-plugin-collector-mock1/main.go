@@ -33,9 +33,15 @@ func main() {
 	//   the definition of the plugin metadata
 	//   the implementation satisfying plugin.CollectorPlugin
 
-	// Define metadata about Plugin
-	meta := mock.Meta()
-	meta.RPCType = plugin.JSONRPC
 	// Start a collector
-	plugin.Start(meta, new(mock.Mock), os.Args[1])
+	if _, code := plugin.Start(meta(), new(mock.Mock), plugin.RequestFromArgs()); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// meta defines metadata about the plugin
+func meta() *plugin.PluginMeta {
+	m := mock.Meta()
+	m.RPCType = plugin.JSONRPC
+	return m
 }
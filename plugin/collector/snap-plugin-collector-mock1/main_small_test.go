@@ -22,15 +22,26 @@ limitations under the License.
 package main
 
 import (
-	"os"
 	"testing"
+	"time"
 
+	"github.com/intelsdi-x/snap/control/plugin"
+	"github.com/intelsdi-x/snap/control/plugin/plugintest"
+	"github.com/intelsdi-x/snap/plugin/collector/snap-plugin-collector-mock1/mock"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
 func TestMain(t *testing.T) {
 	Convey("ensure plugin loads and responds", t, func() {
-		os.Args = []string{"", "{\"NoDaemon\": true}"}
-		So(func() { main() }, ShouldNotPanic)
+		arg := plugin.NewArg(int(0))
+		arg.KillDelay = 10 * time.Millisecond
+		mc, err := plugintest.Start(meta(), new(mock.Mock), arg)
+		So(err, ShouldBeNil)
+		So(mc.Response.Meta.RPCType, ShouldEqual, plugin.JSONRPC)
+		So(mc.Ping(), ShouldBeNil)
+		So(mc.Kill("test done"), ShouldBeNil)
+		code, err := mc.Wait(time.Second)
+		So(err, ShouldBeNil)
+		So(code, ShouldEqual, 0)
 	})
 }
@@ -22,40 +22,72 @@ limitations under the License.
 package main
 
 import (
-	"os"
-	"path"
 	"testing"
+	"time"
 
-	"github.com/intelsdi-x/snap/control"
+	"github.com/intelsdi-x/snap/control/plugin"
+	"github.com/intelsdi-x/snap/control/plugin/plugintest"
 	"github.com/intelsdi-x/snap/core"
-	"github.com/intelsdi-x/snap/plugin/helper"
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/plugin/collector/snap-plugin-collector-mock1/mock"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
-var (
-	PluginName = "snap-plugin-collector-mock1"
-	PluginType = "collector"
-	SnapPath   = os.ExpandEnv(os.Getenv("SNAP_PATH"))
-	PluginPath = path.Join(SnapPath, "plugin", PluginName)
-)
+func TestMockPluginLifecycle(t *testing.T) {
+	Convey("The mock collector started as control starts it", t, func() {
+		arg := plugin.NewArg(int(0))
+		arg.PingTimeoutDuration = 100 * time.Millisecond
+		arg.KillDelay = 10 * time.Millisecond
+		mc, err := plugintest.Start(meta(), new(mock.Mock), arg)
+		So(err, ShouldBeNil)
 
-func TestMockPluginLoad(t *testing.T) {
-	// These tests only work if SNAP_PATH is known.
-	// It is the responsibility of the testing framework to
-	// build the plugins first into the build dir.
+		Convey("responds with its meta", func() {
+			So(mc.Response.Type, ShouldEqual, plugin.CollectorPluginType)
+			So(mc.Response.Meta.Name, ShouldEqual, mock.Name)
+			So(mc.Response.Meta.Version, ShouldEqual, mock.Version)
+			So(mc.Kill("test done"), ShouldBeNil)
+		})
 
-	Convey("make sure plugin has been built", t, func() {
-		err := helper.CheckPluginBuilt(SnapPath, PluginName)
-		So(err, ShouldBeNil)
+		Convey("serves its config policy, catalog and metrics", func() {
+			policy, err := mc.GetConfigPolicy()
+			So(err, ShouldBeNil)
+			So(policy, ShouldNotBeNil)
 
-		Convey("ensure plugin loads and responds", func() {
-			c := control.New(control.GetDefaultConfig())
-			c.Start()
-			rp, _ := core.NewRequestedPlugin(PluginPath)
-			_, err := c.Load(rp)
+			mts, err := mc.GetMetricTypes(plugin.ConfigType{ConfigDataNode: cdata.NewNode()})
+			So(err, ShouldBeNil)
+			So(len(mts), ShouldEqual, 3)
 
+			mts, err = mc.CollectMetrics([]core.Metric{
+				plugin.MetricType{Namespace_: core.NewNamespace("intel", "mock", "foo"), Config_: cdata.NewNode()},
+			})
 			So(err, ShouldBeNil)
+			So(len(mts), ShouldEqual, 1)
+			So(mts[0].Data(), ShouldStartWith, "The mock collected data!")
+
+			Convey("and does not publish", func() {
+				So(mc.Publish(mts, nil), ShouldEqual, plugintest.ErrUnsupportedCall)
+			})
+			So(mc.Kill("test done"), ShouldBeNil)
+		})
+
+		Convey("stays up while it is pinged", func() {
+			time.Sleep(5 * arg.PingTimeoutDuration)
+			So(mc.Ping(), ShouldBeNil)
+
+			Convey("and exits once killed", func() {
+				So(mc.Kill("test done"), ShouldBeNil)
+				code, err := mc.Wait(time.Second)
+				So(err, ShouldBeNil)
+				So(code, ShouldEqual, 0)
+			})
 		})
 
+		Convey("shuts down when its heartbeat times out", func() {
+			mc.StopPinging()
+			code, err := mc.Wait(10 * time.Duration(plugin.PingTimeoutLimit) * arg.PingTimeoutDuration)
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 0)
+			So(mc.Ping(), ShouldEqual, plugintest.ErrExited)
+		})
 	})
 }
@@ -28,22 +28,32 @@ import (
 
 	"github.com/intelsdi-x/snap-plugin-utilities/str"
 	"github.com/intelsdi-x/snap/control/plugin"
+	"github.com/intelsdi-x/snap/control/plugin/plugintest"
 	"github.com/intelsdi-x/snap/core"
 	"github.com/intelsdi-x/snap/core/cdata"
 	"github.com/intelsdi-x/snap/core/ctypes"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+// startMock starts the mock collector in a session served as under control
+func startMock(t *testing.T) *plugintest.MockControl {
+	mc, err := plugintest.Start(Meta(), new(Mock), plugin.NewArg(int(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mc
+}
+
 func TestCollectMetric(t *testing.T) {
 	ns0 := core.NewNamespace("intel", "mock", "test")
 	ns1 := core.NewNamespace("intel", "mock", "foo")
 	ns2 := core.NewNamespace("intel", "mock", "bar")
 	ns3 := core.NewNamespace("intel", "mock").AddDynamicElement("host", "name of the host").AddStaticElement("baz")
 
-	Convey("Testing CollectMetric", t, func() {
+	mc := startMock(t)
+	defer mc.Kill("test done")
 
-		newPlg := new(Mock)
-		So(newPlg, ShouldNotBeNil)
+	Convey("Testing CollectMetric", t, func() {
 
 		Convey("with 'test' config variable'", func() {
 
@@ -52,32 +62,37 @@ func TestCollectMetric(t *testing.T) {
 			cfg := plugin.ConfigType{ConfigDataNode: node}
 
 			Convey("testing specific metrics", func() {
-				mTypes := []plugin.MetricType{
+				mTypes := []core.Metric{
 					plugin.MetricType{Namespace_: ns0, Config_: cfg.ConfigDataNode},
 					plugin.MetricType{Namespace_: ns1, Config_: cfg.ConfigDataNode},
 					plugin.MetricType{Namespace_: ns2, Config_: cfg.ConfigDataNode},
 				}
-				mts, _ := newPlg.CollectMetrics(mTypes)
+				mts, err := mc.CollectMetrics(mTypes)
+				So(err, ShouldBeNil)
+				So(len(mts), ShouldEqual, 3)
 
 				for _, mt := range mts {
-					_, ok := mt.Data_.(string)
+					_, ok := mt.Data().(string)
 					So(ok, ShouldBeTrue)
 				}
 			})
 
 			Convey("testing dynamic metric", func() {
 
-				mt := plugin.MetricType{Namespace_: ns3, Config_: cfg.ConfigDataNode}
+				// the instance is set on a copy, ns3 is shared by the conveys
+				ns := append(core.Namespace{}, ns3...)
+				mt := plugin.MetricType{Namespace_: ns, Config_: cfg.ConfigDataNode}
 
 				Convey("for none specified instance", func() {
-					mts, _ := newPlg.CollectMetrics([]plugin.MetricType{mt})
+					mts, err := mc.CollectMetrics([]core.Metric{mt})
+					So(err, ShouldBeNil)
 
 					// there is 10 available hosts (host0, host1, ..., host9)
 					So(len(mts), ShouldEqual, 10)
 
 					Convey("returned metrics should have data type integer", func() {
 						for _, mt := range mts {
-							_, ok := mt.Data_.(int)
+							_, ok := mt.Data().(int)
 							So(ok, ShouldBeTrue)
 						}
 					})
@@ -93,19 +108,20 @@ func TestCollectMetric(t *testing.T) {
 
 				Convey("for specified instance which is available - host0", func() {
 					mt.Namespace()[2].Value = "host0"
-					mts, _ := newPlg.CollectMetrics([]plugin.MetricType{mt})
+					mts, err := mc.CollectMetrics([]core.Metric{mt})
+					So(err, ShouldBeNil)
 
 					// only one metric for this specific hostname should be returned
 					So(len(mts), ShouldEqual, 1)
 					So(mts[0].Namespace().String(), ShouldEqual, "/intel/mock/host0/baz")
 
 					Convey("returned metric should have data type integer", func() {
-						_, ok := mts[0].Data_.(int)
+						_, ok := mts[0].Data().(int)
 						So(ok, ShouldBeTrue)
 					})
 
 					Convey("returned metric should remain dynamic", func() {
-						isDynamic, _ := mt.Namespace().IsDynamic()
+						isDynamic, _ := mts[0].Namespace().IsDynamic()
 						So(isDynamic, ShouldBeTrue)
 					})
 
@@ -113,11 +129,11 @@ func TestCollectMetric(t *testing.T) {
 
 				Convey("for specified instance which is not available - host10", func() {
 					mt.Namespace()[2].Value = "host10"
-					mts, err := newPlg.CollectMetrics([]plugin.MetricType{mt})
+					mts, err := mc.CollectMetrics([]core.Metric{mt})
 
 					So(mts, ShouldBeNil)
 					So(err, ShouldNotBeNil)
-					So(err.Error(), ShouldStartWith, "requested hostname `host10` is not available")
+					So(err.Error(), ShouldContainSubstring, "requested hostname `host10` is not available")
 
 				})
 			})
@@ -130,28 +146,30 @@ func TestCollectMetric(t *testing.T) {
 			cfg := plugin.ConfigType{ConfigDataNode: node}
 
 			Convey("testing specific metrics", func() {
-				mTypes := []plugin.MetricType{
+				mTypes := []core.Metric{
 					plugin.MetricType{Namespace_: ns0, Config_: cfg.ConfigDataNode},
 					plugin.MetricType{Namespace_: ns1, Config_: cfg.ConfigDataNode},
 					plugin.MetricType{Namespace_: ns2, Config_: cfg.ConfigDataNode},
 				}
-				mts, _ := newPlg.CollectMetrics(mTypes)
+				mts, err := mc.CollectMetrics(mTypes)
+				So(err, ShouldBeNil)
 
 				for _, mt := range mts {
-					_, ok := mt.Data_.(string)
+					_, ok := mt.Data().(string)
 					So(ok, ShouldBeTrue)
 				}
 			})
 
 			Convey("testing dynamic metics", func() {
-				mTypes := []plugin.MetricType{
+				mTypes := []core.Metric{
 					plugin.MetricType{Namespace_: ns3, Config_: cfg.ConfigDataNode},
 				}
-				mts, _ := newPlg.CollectMetrics(mTypes)
+				mts, err := mc.CollectMetrics(mTypes)
+				So(err, ShouldBeNil)
 
 				Convey("returned metrics should have data type integer", func() {
 					for _, mt := range mts {
-						_, ok := mt.Data_.(int)
+						_, ok := mt.Data().(int)
 						So(ok, ShouldBeTrue)
 					}
 				})
@@ -170,16 +188,16 @@ func TestCollectMetric(t *testing.T) {
 }
 
 func TestGetMetricTypes(t *testing.T) {
-	Convey("Tesing GetMetricTypes", t, func() {
+	mc := startMock(t)
+	defer mc.Kill("test done")
 
-		newPlg := new(Mock)
-		So(newPlg, ShouldNotBeNil)
+	Convey("Tesing GetMetricTypes", t, func() {
 
 		Convey("with missing on-load plugin config entry", func() {
 			node := cdata.NewNode()
 			node.AddItem("test-fail", ctypes.ConfigValueStr{Value: ""})
 
-			_, err := newPlg.GetMetricTypes(plugin.ConfigType{ConfigDataNode: node})
+			_, err := mc.GetMetricTypes(plugin.ConfigType{ConfigDataNode: node})
 
 			So(err, ShouldNotBeNil)
 		})
@@ -188,11 +206,10 @@ func TestGetMetricTypes(t *testing.T) {
 			node := cdata.NewNode()
 			node.AddItem("test", ctypes.ConfigValueStr{Value: ""})
 
-			mts, err := newPlg.GetMetricTypes(plugin.ConfigType{ConfigDataNode: node})
+			mts, err := mc.GetMetricTypes(plugin.ConfigType{ConfigDataNode: node})
 
 			So(err, ShouldBeNil)
 			So(len(mts), ShouldEqual, 4)
-
 			Convey("checking namespaces", func() {
 				metricNames := []string{}
 				for _, m := range mts {
@@ -215,7 +232,7 @@ func TestGetMetricTypes(t *testing.T) {
 
 		Convey("without config variables", func() {
 			node := cdata.NewNode()
-			mts, err := newPlg.GetMetricTypes(plugin.ConfigType{ConfigDataNode: node})
+			mts, err := mc.GetMetricTypes(plugin.ConfigType{ConfigDataNode: node})
 
 			So(err, ShouldBeNil)
 			So(len(mts), ShouldEqual, 3)
@@ -263,11 +280,11 @@ func TestRandInt(t *testing.T) {
 }
 
 func TestGetConfigPolicy(t *testing.T) {
-	Convey("Testing GetConfigPolicy", t, func() {
-		newPlg := new(Mock)
-		So(newPlg, ShouldNotBeNil)
+	mc := startMock(t)
+	defer mc.Kill("test done")
 
-		configPolicy, err := newPlg.GetConfigPolicy()
+	Convey("Testing GetConfigPolicy", t, func() {
+		configPolicy, err := mc.GetConfigPolicy()
 
 		So(err, ShouldBeNil)
 		So(configPolicy, ShouldNotBeNil)
@@ -38,5 +38,7 @@ func main() {
 	// meta.RPCType = plugin.JSONRPC
 
 	// Start a collector
-	plugin.Start(meta, new(mock.Mock), os.Args[1])
+	if _, code := plugin.Start(meta, new(mock.Mock), plugin.RequestFromArgs()); code != 0 {
+		os.Exit(code)
+	}
 }
@@ -28,5 +28,7 @@ import (
 
 func main() {
 	meta := passthru.Meta()
-	plugin.Start(meta, passthru.NewPassthruProcessor(), os.Args[1])
+	if _, code := plugin.Start(meta, passthru.NewPassthruProcessor(), plugin.RequestFromArgs()); code != 0 {
+		os.Exit(code)
+	}
 }
@@ -28,5 +28,7 @@ import (
 
 func main() {
 	meta := file.Meta()
-	plugin.Start(meta, file.NewFilePublisher(), os.Args[1])
+	if _, code := plugin.Start(meta, file.NewFilePublisher(), plugin.RequestFromArgs()); code != 0 {
+		os.Exit(code)
+	}
 }
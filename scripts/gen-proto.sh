@@ -36,8 +36,8 @@ then
 	exit 1
 fi
 
-proto_files=("grpc/controlproxy/rpc/control.proto" "control/plugin/rpc/plugin.proto")
-pb_go_files=("grpc/controlproxy/rpc/control.pb.go" "control/plugin/rpc/plugin.pb.go")
+proto_files=("grpc/controlproxy/rpc/control.proto" "control/plugin/rpc/plugin.proto" "control/plugin/metric.proto")
+pb_go_files=("grpc/controlproxy/rpc/control.pb.go" "control/plugin/rpc/plugin.pb.go" "control/plugin/metric.pb.go")
 
 license='/*
 http://www.apache.org/licenses/LICENSE-2.0.txt